@@ -18,6 +18,7 @@ package agentconfig
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -35,6 +36,7 @@ import (
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
 	"golang.org/x/oauth2/jws"
 )
 
@@ -82,13 +84,28 @@ const (
 
 	osConfigPollIntervalDefault = 10
 	osConfigMetadataPollTimeout = 60
+
+	inventoryMinIntervalDefault = 5 * time.Minute
 )
 
 var (
-	endpoint            = flag.String("endpoint", prodEndpoint, "osconfig endpoint override")
-	debug               = flag.Bool("debug", false, "set debug log verbosity")
-	stdout              = flag.Bool("stdout", false, "log to stdout")
-	disableLocalLogging = flag.Bool("disable_local_logging", false, "disable logging using event log or syslog")
+	endpoint              = flag.String("endpoint", prodEndpoint, "osconfig endpoint override")
+	debug                 = flag.Bool("debug", false, "set debug log verbosity")
+	stdout                = flag.Bool("stdout", false, "log to stdout")
+	disableLocalLogging   = flag.Bool("disable_local_logging", false, "disable logging using event log or syslog")
+	serialLogPorts        = flag.String("serial_log_ports", "", "comma separated serial ports to log to, like COM2 or /dev/ttyS1, or none, overrides osconfig-serial-log-ports")
+	logFile               = flag.String("log_file", "", "also log to this file, rotated by size")
+	logFileMaxSize        = flag.Int("log_file_max_size", 10, "size in MB at which the log file is rotated")
+	logFileMaxBackups     = flag.Int("log_file_max_backups", 3, "number of rotated log files to keep")
+	logBackend            = flag.String("log_backend", "", "native logging backend, journald on Linux or eventlog on Windows, overrides osconfig-log-backend")
+	readOnly              = flag.Bool("read_only", false, "only report inventory and evaluate OS policies without enforcing them, implied when not running as root on Linux without a privileged_helper")
+	privilegedHelper      = flag.String("privileged_helper", "", "Unix socket of the privileged helper that enforces OS policies for an agent not running as root on Linux")
+	auditLogFile          = flag.String("audit_log_file", "", "audit log of enforcement actions, defaults to osconfig_audit.jsonl in the cache directory")
+	auditLogMaxSize       = flag.Int("audit_log_max_size", 10, "size in MB at which the audit log is rotated")
+	auditLogMaxBackups    = flag.Int("audit_log_max_backups", 5, "number of rotated audit logs to keep")
+	taskHistoryMaxSize    = flag.Int("task_history_max_size", 10, "size in MB at which a local task history sink is rotated")
+	taskHistoryMaxBackups = flag.Int("task_history_max_backups", 3, "number of rotated local task history files to keep")
+	statusAddress         = flag.String("status_address", "", "serve the agent status as JSON on unix:<socket path> or a localhost host:port")
 
 	agentConfig   = &config{}
 	agentConfigMx sync.RWMutex
@@ -113,6 +130,9 @@ var (
 
 	freeOSMemory          = strings.ToLower(os.Getenv("OSCONFIG_FREE_OS_MEMORY"))
 	disableInventoryWrite = strings.ToLower(os.Getenv("OSCONFIG_DISABLE_INVENTORY_WRITE"))
+
+	changeFuncs   []func()
+	changeFuncsMx sync.Mutex
 )
 
 type config struct {
@@ -125,6 +145,7 @@ type config struct {
 	zypperRepoFilePath      string
 	yumRepoFilePath         string
 	instanceID              string
+	instanceImage           string
 	numericProjectID        int64
 	osConfigPollInterval    int
 	debugEnabled            bool
@@ -132,6 +153,34 @@ type config struct {
 	guestPoliciesEnabled    bool
 	osInventoryEnabled      bool
 	guestAttributesEnabled  bool
+	extendedInventory       bool
+	featureRollouts         map[string]int
+	managedRoots            []string
+	binaryInventoryPaths    []string
+	pythonEnvPrefixes       []string
+	enabledCollectors       []string
+	disabledCollectors      []string
+	errorCodes              bool
+	assignmentSpread        map[string]time.Duration
+	pausedAssignments       []string
+	aptWithNewPkgs          bool
+	aptAutoremove           bool
+	rebootLock              string
+	serialLogPorts          []string
+	logBackend              string
+	policyProfiling         bool
+	fileRollback            bool
+	readOnly                bool
+	auditLogForwarding      bool
+	taskHistorySink         string
+	commandStallTimeout     time.Duration
+	inventoryMinInterval    time.Duration
+	policyTimeBudget        time.Duration
+	resourceTimeBudget      time.Duration
+	bootIntegrity           string
+	patchCanary             string
+	blockedResourceTypes    []string
+	arCredentialHelpers     bool
 }
 
 func (c *config) parseFeatures(features string, enabled bool) {
@@ -144,6 +193,8 @@ func (c *config) parseFeatures(features string, enabled bool) {
 			c.guestPoliciesEnabled = enabled
 		case "osinventory":
 			c.osInventoryEnabled = enabled
+		case "extendedinventory":
+			c.extendedInventory = enabled
 		}
 	}
 }
@@ -187,6 +238,16 @@ func parseBool(s string) bool {
 	return enabled
 }
 
+func parseList(s string) []string {
+	var l []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
 type metadataJSON struct {
 	Instance instanceJSON
 	Project  projectJSON
@@ -197,6 +258,7 @@ type instanceJSON struct {
 	ID         *json.Number
 	Zone       string
 	Name       string
+	Image      string
 }
 
 type projectJSON struct {
@@ -219,6 +281,33 @@ type attributesJSON struct {
 	OSConfigEnabled       string       `json:"enable-osconfig"`
 	DisabledFeatures      string       `json:"osconfig-disabled-features"`
 	EnableGuestAttributes string       `json:"enable-guest-attributes"`
+	FeatureRollouts       string       `json:"osconfig-feature-rollouts"`
+	ManagedRoots          string       `json:"osconfig-experimental-managed-roots"`
+	BinaryInventoryPaths  string       `json:"osconfig-binary-inventory-paths"`
+	PythonEnvPrefixes     string       `json:"osconfig-python-env-prefixes"`
+	EnabledCollectors     string       `json:"osconfig-inventory-collectors-enabled"`
+	DisabledCollectors    string       `json:"osconfig-inventory-collectors-disabled"`
+	ErrorCodes            string       `json:"osconfig-error-codes"`
+	AssignmentSpread      string       `json:"osconfig-assignment-spread"`
+	PauseAssignments      string       `json:"osconfig-pause-assignments"`
+	AptWithNewPkgs        string       `json:"osconfig-apt-with-new-pkgs"`
+	AptAutoremove         string       `json:"osconfig-apt-autoremove"`
+	RebootLock            string       `json:"osconfig-reboot-lock"`
+	SerialLogPorts        string       `json:"osconfig-serial-log-ports"`
+	LogBackend            string       `json:"osconfig-log-backend"`
+	PolicyProfiling       string       `json:"osconfig-policy-profiling"`
+	FileRollback          string       `json:"osconfig-file-rollback"`
+	ReadOnly              string       `json:"osconfig-read-only"`
+	AuditLogForwarding    string       `json:"osconfig-audit-log-forwarding"`
+	TaskHistorySink       string       `json:"osconfig-task-history-sink"`
+	CommandStallTimeout   string       `json:"osconfig-command-stall-timeout"`
+	InventoryMinInterval  string       `json:"osconfig-inventory-min-interval"`
+	PolicyTimeBudget      string       `json:"osconfig-policy-time-budget"`
+	ResourceTimeBudget    string       `json:"osconfig-resource-time-budget"`
+	BootIntegrity         string       `json:"osconfig-boot-integrity"`
+	PatchCanary           string       `json:"osconfig-patch-canary"`
+	BlockedResourceTypes  string       `json:"osconfig-blocked-resource-types"`
+	ARCredentialHelpers   string       `json:"osconfig-ar-credential-helpers"`
 }
 
 func createConfigFromMetadata(md metadataJSON) *config {
@@ -230,6 +319,7 @@ func createConfigFromMetadata(md metadataJSON) *config {
 		debugEnabled:            debugEnabledDefault,
 		svcEndpoint:             prodEndpoint,
 		osConfigPollInterval:    osConfigPollIntervalDefault,
+		inventoryMinInterval:    inventoryMinIntervalDefault,
 
 		googetRepoFilePath: googetRepoFilePath,
 		zypperRepoFilePath: zypperRepoFilePath,
@@ -241,6 +331,7 @@ func createConfigFromMetadata(md metadataJSON) *config {
 		instanceZone:     old.instanceZone,
 		instanceName:     old.instanceName,
 		instanceID:       old.instanceID,
+		instanceImage:    old.instanceImage,
 	}
 
 	if md.Project.ProjectID != "" {
@@ -258,6 +349,9 @@ func createConfigFromMetadata(md metadataJSON) *config {
 	if md.Instance.ID != nil {
 		c.instanceID = md.Instance.ID.String()
 	}
+	if md.Instance.Image != "" {
+		c.instanceImage = md.Instance.Image
+	}
 
 	// Check project first then instance as instance metadata overrides project.
 	switch {
@@ -344,10 +438,190 @@ func createConfigFromMetadata(md metadataJSON) *config {
 		c.guestAttributesEnabled = parseBool(md.Instance.Attributes.EnableGuestAttributes)
 	}
 
+	// Instance rollouts are applied on top of project rollouts per flag.
+	c.featureRollouts = parseFeatureRollouts(md.Project.Attributes.FeatureRollouts, nil)
+	c.featureRollouts = parseFeatureRollouts(md.Instance.Attributes.FeatureRollouts, c.featureRollouts)
+
+	if md.Project.Attributes.ManagedRoots != "" {
+		c.managedRoots = parseList(md.Project.Attributes.ManagedRoots)
+	}
+	if md.Instance.Attributes.ManagedRoots != "" {
+		c.managedRoots = parseList(md.Instance.Attributes.ManagedRoots)
+	}
+
+	if md.Project.Attributes.BinaryInventoryPaths != "" {
+		c.binaryInventoryPaths = parseList(md.Project.Attributes.BinaryInventoryPaths)
+	}
+	if md.Instance.Attributes.BinaryInventoryPaths != "" {
+		c.binaryInventoryPaths = parseList(md.Instance.Attributes.BinaryInventoryPaths)
+	}
+
+	if md.Project.Attributes.PythonEnvPrefixes != "" {
+		c.pythonEnvPrefixes = parseList(md.Project.Attributes.PythonEnvPrefixes)
+	}
+	if md.Instance.Attributes.PythonEnvPrefixes != "" {
+		c.pythonEnvPrefixes = parseList(md.Instance.Attributes.PythonEnvPrefixes)
+	}
+
+	if md.Project.Attributes.EnabledCollectors != "" {
+		c.enabledCollectors = parseList(md.Project.Attributes.EnabledCollectors)
+	}
+	if md.Instance.Attributes.EnabledCollectors != "" {
+		c.enabledCollectors = parseList(md.Instance.Attributes.EnabledCollectors)
+	}
+	if md.Project.Attributes.DisabledCollectors != "" {
+		c.disabledCollectors = parseList(md.Project.Attributes.DisabledCollectors)
+	}
+	if md.Instance.Attributes.DisabledCollectors != "" {
+		c.disabledCollectors = parseList(md.Instance.Attributes.DisabledCollectors)
+	}
+
+	if md.Project.Attributes.ErrorCodes != "" {
+		c.errorCodes = parseBool(md.Project.Attributes.ErrorCodes)
+	}
+	if md.Instance.Attributes.ErrorCodes != "" {
+		c.errorCodes = parseBool(md.Instance.Attributes.ErrorCodes)
+	}
+
+	// Instance windows are applied on top of project windows per assignment.
+	c.assignmentSpread = parseAssignmentSpread(md.Project.Attributes.AssignmentSpread, nil)
+	c.assignmentSpread = parseAssignmentSpread(md.Instance.Attributes.AssignmentSpread, c.assignmentSpread)
+
+	if md.Project.Attributes.PauseAssignments != "" {
+		c.pausedAssignments = parseList(md.Project.Attributes.PauseAssignments)
+	}
+	if md.Instance.Attributes.PauseAssignments != "" {
+		c.pausedAssignments = parseList(md.Instance.Attributes.PauseAssignments)
+	}
+
+	if md.Project.Attributes.AptWithNewPkgs != "" {
+		c.aptWithNewPkgs = parseBool(md.Project.Attributes.AptWithNewPkgs)
+	}
+	if md.Instance.Attributes.AptWithNewPkgs != "" {
+		c.aptWithNewPkgs = parseBool(md.Instance.Attributes.AptWithNewPkgs)
+	}
+	if md.Project.Attributes.AptAutoremove != "" {
+		c.aptAutoremove = parseBool(md.Project.Attributes.AptAutoremove)
+	}
+	if md.Instance.Attributes.AptAutoremove != "" {
+		c.aptAutoremove = parseBool(md.Instance.Attributes.AptAutoremove)
+	}
+
+	if md.Project.Attributes.RebootLock != "" {
+		c.rebootLock = md.Project.Attributes.RebootLock
+	}
+	if md.Instance.Attributes.RebootLock != "" {
+		c.rebootLock = md.Instance.Attributes.RebootLock
+	}
+
+	if md.Project.Attributes.SerialLogPorts != "" {
+		c.serialLogPorts = parseList(md.Project.Attributes.SerialLogPorts)
+	}
+	if md.Instance.Attributes.SerialLogPorts != "" {
+		c.serialLogPorts = parseList(md.Instance.Attributes.SerialLogPorts)
+	}
+
+	if md.Project.Attributes.LogBackend != "" {
+		c.logBackend = strings.ToLower(strings.TrimSpace(md.Project.Attributes.LogBackend))
+	}
+	if md.Instance.Attributes.LogBackend != "" {
+		c.logBackend = strings.ToLower(strings.TrimSpace(md.Instance.Attributes.LogBackend))
+	}
+
+	if md.Project.Attributes.PolicyProfiling != "" {
+		c.policyProfiling = parseBool(md.Project.Attributes.PolicyProfiling)
+	}
+	if md.Instance.Attributes.PolicyProfiling != "" {
+		c.policyProfiling = parseBool(md.Instance.Attributes.PolicyProfiling)
+	}
+
+	if md.Project.Attributes.FileRollback != "" {
+		c.fileRollback = parseBool(md.Project.Attributes.FileRollback)
+	}
+	if md.Instance.Attributes.FileRollback != "" {
+		c.fileRollback = parseBool(md.Instance.Attributes.FileRollback)
+	}
+
+	if md.Project.Attributes.ReadOnly != "" {
+		c.readOnly = parseBool(md.Project.Attributes.ReadOnly)
+	}
+	if md.Instance.Attributes.ReadOnly != "" {
+		c.readOnly = parseBool(md.Instance.Attributes.ReadOnly)
+	}
+
+	if md.Project.Attributes.AuditLogForwarding != "" {
+		c.auditLogForwarding = parseBool(md.Project.Attributes.AuditLogForwarding)
+	}
+	if md.Instance.Attributes.AuditLogForwarding != "" {
+		c.auditLogForwarding = parseBool(md.Instance.Attributes.AuditLogForwarding)
+	}
+
+	if md.Project.Attributes.TaskHistorySink != "" {
+		c.taskHistorySink = md.Project.Attributes.TaskHistorySink
+	}
+	if md.Instance.Attributes.TaskHistorySink != "" {
+		c.taskHistorySink = md.Instance.Attributes.TaskHistorySink
+	}
+
+	if d, err := time.ParseDuration(md.Project.Attributes.CommandStallTimeout); err == nil && d >= 0 {
+		c.commandStallTimeout = d
+	}
+	if d, err := time.ParseDuration(md.Instance.Attributes.CommandStallTimeout); err == nil && d >= 0 {
+		c.commandStallTimeout = d
+	}
+
+	if d, err := time.ParseDuration(md.Project.Attributes.PolicyTimeBudget); err == nil && d >= 0 {
+		c.policyTimeBudget = d
+	}
+	if d, err := time.ParseDuration(md.Instance.Attributes.PolicyTimeBudget); err == nil && d >= 0 {
+		c.policyTimeBudget = d
+	}
+
+	if d, err := time.ParseDuration(md.Project.Attributes.ResourceTimeBudget); err == nil && d >= 0 {
+		c.resourceTimeBudget = d
+	}
+	if d, err := time.ParseDuration(md.Instance.Attributes.ResourceTimeBudget); err == nil && d >= 0 {
+		c.resourceTimeBudget = d
+	}
+
+	if d, err := time.ParseDuration(md.Project.Attributes.InventoryMinInterval); err == nil && d >= 0 {
+		c.inventoryMinInterval = d
+	}
+	if d, err := time.ParseDuration(md.Instance.Attributes.InventoryMinInterval); err == nil && d >= 0 {
+		c.inventoryMinInterval = d
+	}
+
+	// Boot integrity is a result for this instance, a project value would
+	// not mean anything.
+	c.bootIntegrity = strings.TrimSpace(md.Instance.Attributes.BootIntegrity)
+
+	if md.Project.Attributes.PatchCanary != "" {
+		c.patchCanary = strings.ToLower(strings.TrimSpace(md.Project.Attributes.PatchCanary))
+	}
+	if md.Instance.Attributes.PatchCanary != "" {
+		c.patchCanary = strings.ToLower(strings.TrimSpace(md.Instance.Attributes.PatchCanary))
+	}
+
+	// Instance metadata can't unblock a type blocked for the whole project.
+	c.blockedResourceTypes = append(parseList(md.Project.Attributes.BlockedResourceTypes), parseList(md.Instance.Attributes.BlockedResourceTypes)...)
+
+	if md.Project.Attributes.ARCredentialHelpers != "" {
+		c.arCredentialHelpers = parseBool(md.Project.Attributes.ARCredentialHelpers)
+	}
+	if md.Instance.Attributes.ARCredentialHelpers != "" {
+		c.arCredentialHelpers = parseBool(md.Instance.Attributes.ARCredentialHelpers)
+	}
+
 	// Flags take precedence over metadata.
+	if *logBackend != "" {
+		c.logBackend = strings.ToLower(strings.TrimSpace(*logBackend))
+	}
 	if *debug {
 		c.debugEnabled = true
 	}
+	if *serialLogPorts != "" {
+		c.serialLogPorts = parseList(*serialLogPorts)
+	}
 
 	setSVCEndpoint(md, c)
 
@@ -368,10 +642,7 @@ func setSVCEndpoint(md metadataJSON, c *config) {
 		c.svcEndpoint = md.Project.Attributes.OSConfigEndpointOld
 	}
 
-	// Example instanceZone: projects/123456/zones/us-west1-b
-	parts := strings.Split(c.instanceZone, "/")
-	zone := parts[len(parts)-1]
-	c.svcEndpoint = strings.ReplaceAll(c.svcEndpoint, "{zone}", zone)
+	c.svcEndpoint = strings.ReplaceAll(c.svcEndpoint, "{zone}", zoneName(c.instanceZone))
 }
 
 func formatMetadataError(err error) error {
@@ -468,15 +739,9 @@ func WatchConfig(ctx context.Context) error {
 			unmarshalErrorCount = 0
 			lEtag.set(eTag)
 
-			newAgentConfig := createConfigFromMetadata(metadataConfig)
-
-			agentConfigMx.Lock()
-			if agentConfig.asSha256() != newAgentConfig.asSha256() {
-				agentConfig = newAgentConfig
-				agentConfigMx.Unlock()
+			if stageConfig(ctx, metadataConfig) {
 				break
 			}
-			agentConfigMx.Unlock()
 		}
 
 		// Try up to 12 times (60s) to wait for slow network initialization, after
@@ -501,9 +766,57 @@ func WatchConfig(ctx context.Context) error {
 	return webError
 }
 
+// Refresh fetches metadata without waiting for a change and applies any new
+// configuration immediately.
+func Refresh(ctx context.Context) error {
+	md, eTag, err := getMetadata("?recursive=true&alt=json")
+	if err != nil {
+		return formatMetadataError(err)
+	}
+	var metadataConfig metadataJSON
+	if err := json.Unmarshal(md, &metadataConfig); err != nil {
+		return err
+	}
+	// Only move the etag forward, a running WatchConfig will pick up from here.
+	if eTag != "" {
+		lEtag.set(eTag)
+	}
+	if stageConfig(ctx, metadataConfig) {
+		clog.Infof(ctx, "Agent configuration reloaded.")
+	}
+	return nil
+}
+
+// setConfig swaps in c if it differs from the current config and notifies any
+// registered change funcs, it reports whether the config changed.
+func setConfig(c *config) bool {
+	agentConfigMx.Lock()
+	if agentConfig.asSha256() == c.asSha256() {
+		agentConfigMx.Unlock()
+		return false
+	}
+	agentConfig = c
+	agentConfigMx.Unlock()
+
+	changeFuncsMx.Lock()
+	defer changeFuncsMx.Unlock()
+	for _, f := range changeFuncs {
+		f()
+	}
+	return true
+}
+
+// OnChange registers f to be called each time a new configuration is applied.
+// Funcs are called synchronously and should not block.
+func OnChange(f func()) {
+	changeFuncsMx.Lock()
+	defer changeFuncsMx.Unlock()
+	changeFuncs = append(changeFuncs, f)
+}
+
 // LogFeatures logs the osconfig feature status.
 func LogFeatures(ctx context.Context) {
-	clog.Infof(ctx, "OSConfig enabled features status:{GuestPolicies: %t, OSInventory: %t, PatchManagement: %t}.", GuestPoliciesEnabled(), OSInventoryEnabled(), TaskNotificationEnabled())
+	clog.Infof(ctx, "OSConfig enabled features status:{GuestPolicies: %t, OSInventory: %t, PatchManagement: %t, FeatureFlags: %q}.", GuestPoliciesEnabled(), OSInventoryEnabled(), TaskNotificationEnabled(), EnabledFeatureFlags())
 }
 
 // SvcPollInterval returns the frequency to poll the service.
@@ -511,15 +824,50 @@ func SvcPollInterval() time.Duration {
 	return time.Duration(getAgentConfig().osConfigPollInterval) * time.Minute
 }
 
-// SerialLogPort is the serial port to log to.
-func SerialLogPort() string {
-	if runtime.GOOS == "windows" {
-		return "COM1"
+// SerialLogPorts are the serial ports to log to, COM ports on Windows or
+// /dev/ttyS<n> on Linux. The default is COM1 on Windows and none on Linux,
+// "none" disables serial logging.
+func SerialLogPorts() []string {
+	ports := getAgentConfig().serialLogPorts
+	if ports == nil {
+		if runtime.GOOS == "windows" {
+			return []string{"COM1"}
+		}
+		// Don't write directly to the serial port on Linux as syslog already writes there.
+		return nil
+	}
+	if len(ports) == 1 && strings.EqualFold(ports[0], "none") {
+		return nil
+	}
+	return ports
+}
+
+// LogBackend is the native logging backend to use instead of the plain text
+// event log or syslog, journald on Linux or eventlog on Windows. Empty or
+// "default" keeps the plain text logging. It is read once at start.
+func LogBackend() string {
+	if b := getAgentConfig().logBackend; b != "default" {
+		return b
 	}
-	// Don't write directly to the serial port on Linux as syslog already writes there.
 	return ""
 }
 
+// LogFile is a file to log to in addition to the other log writers, empty
+// for none.
+func LogFile() string {
+	return *logFile
+}
+
+// LogFileMaxSize is the size in bytes at which LogFile is rotated.
+func LogFileMaxSize() int64 {
+	return int64(*logFileMaxSize) * 1024 * 1024
+}
+
+// LogFileMaxBackups is the number of rotated log files to keep.
+func LogFileMaxBackups() int {
+	return *logFileMaxBackups
+}
+
 // Debug sets the debug log verbosity.
 func Debug() bool {
 	return *debug || getAgentConfig().debugEnabled
@@ -605,6 +953,125 @@ func OSInventoryEnabled() bool {
 	return getAgentConfig().osInventoryEnabled
 }
 
+// ExtendedInventoryEnabled indicates whether inventory should include
+// additional, more expensive to collect, package details such as licenses.
+func ExtendedInventoryEnabled() bool {
+	return getAgentConfig().extendedInventory
+}
+
+// ManagedRoots returns the experimental managed root specs, chroot paths or
+// container pids, whose packages are inventoried and whose apt, yum and
+// zypper package resources are enforced in addition to the host.
+func ManagedRoots() []string {
+	return getAgentConfig().managedRoots
+}
+
+// BinaryInventoryPaths returns the directories, or glob patterns, to scan for
+// Go binaries and cargo install roots to include in inventory.
+func BinaryInventoryPaths() []string {
+	return getAgentConfig().binaryInventoryPaths
+}
+
+// PythonEnvPrefixes returns the directories, or glob patterns, to search for
+// conda environments and virtualenvs to include in inventory.
+func PythonEnvPrefixes() []string {
+	return getAgentConfig().pythonEnvPrefixes
+}
+
+// EnabledInventoryCollectors returns the names of optional inventory
+// collectors to run in addition to the defaults, like licenses or go.
+func EnabledInventoryCollectors() []string {
+	return getAgentConfig().enabledCollectors
+}
+
+// DisabledInventoryCollectors returns the names of inventory collectors not to
+// run, like pip or gem. Disabling takes precedence over enabling.
+func DisabledInventoryCollectors() []string {
+	return getAgentConfig().disabledCollectors
+}
+
+// AptWithNewPkgs indicates whether apt-get upgrade patches may install new
+// dependencies, like apt-get upgrade --with-new-pkgs.
+func AptWithNewPkgs() bool {
+	return getAgentConfig().aptWithNewPkgs
+}
+
+// AptAutoremove indicates whether apt-get autoremove should be run after
+// patching.
+func AptAutoremove() bool {
+	return getAgentConfig().aptAutoremove
+}
+
+// RebootLock returns the lock to acquire before rebooting for a patch, so
+// reboots are coordinated with an external orchestrator. It is one of
+// file:<path>, an http or https URL, or metadata:<key>, empty for no lock.
+func RebootLock() string {
+	return getAgentConfig().rebootLock
+}
+
+// PolicyProfiling indicates whether CPU and heap profiles of OS policy
+// runs should be written to PolicyCPUProfileFile and PolicyHeapProfileFile.
+func PolicyProfiling() bool {
+	return getAgentConfig().policyProfiling
+}
+
+// FileRollback indicates whether file resources keep a backup of the file
+// they replace, to restore it when a later exec resource of the same OS
+// policy fails, see FileBackupDir. It is off by default.
+func FileRollback() bool {
+	return getAgentConfig().fileRollback
+}
+
+// ReadOnly indicates whether the agent runs in read-only mode: it reports
+// inventory and evaluates OS policies in validation mode but never enforces
+// them, patches or runs exec steps. It is set by the read_only flag or
+// osconfig-read-only, and when the agent is Unprivileged without a
+// PrivilegedHelperSocket.
+func ReadOnly() bool {
+	return *readOnly || getAgentConfig().readOnly || (Unprivileged() && PrivilegedHelperSocket() == "")
+}
+
+// PrivilegedHelperSocket is the socket of the privileged helper an
+// Unprivileged agent enforces OS policies through, see package privhelper.
+// It is empty when the agent is not Unprivileged.
+func PrivilegedHelperSocket() string {
+	if !Unprivileged() {
+		return ""
+	}
+	return *privilegedHelper
+}
+
+// StatusAddress is where the agent serves its status, see package
+// agentstatus. It is only set by the status_address flag so metadata can't
+// open a port on the instance.
+func StatusAddress() string {
+	return *statusAddress
+}
+
+// Unprivileged indicates whether the agent runs as a non-root user on Linux,
+// its state is then kept in a directory of that user, see CacheDir.
+func Unprivileged() bool {
+	return unprivileged()
+}
+
+// unprivileged is overridden in tests.
+var unprivileged = func() bool {
+	return runtime.GOOS == "linux" && os.Geteuid() != 0
+}
+
+// unprivilegedCacheDir is the StateDirectory set by systemd for the unit, or
+// the cache directory of the user.
+func unprivilegedCacheDir() string {
+	if dir, _, _ := strings.Cut(os.Getenv("STATE_DIRECTORY"), ":"); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "google_osconfig_agent")
+}
+
 // GuestPoliciesEnabled indicates whether GuestPolicies should be enabled.
 func GuestPoliciesEnabled() bool {
 	return getAgentConfig().guestPoliciesEnabled
@@ -646,6 +1113,12 @@ func ID() string {
 	return getAgentConfig().instanceID
 }
 
+// Image is the image the instance was created from, in the form
+// projects/<project>/global/images/<image>.
+func Image() string {
+	return getAgentConfig().instanceImage
+}
+
 // GuestAttributesEnabled is a boolean flag that signal that guest attributes feature is enabled.
 func GuestAttributesEnabled() bool {
 	return getAgentConfig().guestAttributesEnabled
@@ -654,9 +1127,40 @@ func GuestAttributesEnabled() bool {
 type idToken struct {
 	exp *time.Time
 	raw string
+	// created is when the instance was created, from the
+	// instance_creation_timestamp claim of the full format token.
+	created time.Time
 	sync.Mutex
 }
 
+// tokenClaims are the Compute Engine claims of a full format identity
+// token, which jws.Decode does not return.
+type tokenClaims struct {
+	Google struct {
+		ComputeEngine struct {
+			InstanceCreationTimestamp int64 `json:"instance_creation_timestamp"`
+		} `json:"compute_engine"`
+	} `json:"google"`
+}
+
+// instanceCreated returns the instance creation time in the token, zero if
+// it has none.
+func instanceCreated(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Google.ComputeEngine.InstanceCreationTimestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Google.ComputeEngine.InstanceCreationTimestamp, 0).UTC()
+}
+
 func (t *idToken) get() error {
 	data, err := metadata.Get(IdentityTokenPath)
 	if err != nil {
@@ -671,6 +1175,7 @@ func (t *idToken) get() error {
 	t.raw = data
 	exp := time.Unix(cs.Exp, 0)
 	t.exp = &exp
+	t.created = instanceCreated(data)
 
 	return nil
 }
@@ -685,13 +1190,24 @@ func IDToken() (string, error) {
 	// Rerequest token if expiry is within 10 minutes.
 	if identity.exp == nil || time.Now().After(identity.exp.Add(-10*time.Minute)) {
 		if err := identity.get(); err != nil {
-			return "", err
+			return "", errcode.Wrap(errcode.Auth, err)
 		}
 	}
 
 	return identity.raw, nil
 }
 
+// InstanceCreated is when the instance was created, read from its identity
+// token. It is zero if the token does not have it.
+func InstanceCreated() (time.Time, error) {
+	if _, err := IDToken(); err != nil {
+		return time.Time{}, err
+	}
+	identity.Lock()
+	defer identity.Unlock()
+	return identity.created, nil
+}
+
 // Version is the agent version.
 func Version() string {
 	return version
@@ -707,11 +1223,22 @@ func Capabilities() []string {
 	return capabilities
 }
 
+// ErrorCodes indicates whether error messages reported to the service are
+// prefixed with their error code, like [NETWORK], set by
+// osconfig-error-codes. It is a local opt-in, off by default, as the service
+// does not signal that it parses the codes.
+func ErrorCodes() bool {
+	return getAgentConfig().errorCodes
+}
+
 // TaskStateFile is the location of the task state file.
 func TaskStateFile() string {
 	if runtime.GOOS == "windows" {
 		return filepath.Join(GetCacheDirWindows(), "osconfig_task.state")
 	}
+	if Unprivileged() {
+		return filepath.Join(unprivilegedCacheDir(), "osconfig_task.state")
+	}
 
 	return taskStateFileLinux
 }
@@ -730,10 +1257,176 @@ func RestartFile() string {
 		return filepath.Join(
 			GetCacheDirWindows(), "osconfig_agent_restart_required")
 	}
+	if Unprivileged() {
+		return filepath.Join(unprivilegedCacheDir(), "osconfig_agent_restart_required")
+	}
 
 	return restartFileLinux
 }
 
+// ImageBuildMarkerFile is the location of the file recording the resources
+// enforced while building the image the instance was created from.
+func ImageBuildMarkerFile() string {
+	return filepath.Join(CacheDir(), "osconfig_image_build.json")
+}
+
+// AssignmentsFile is the location of the record of the OS policy
+// assignments the last config task applied.
+func AssignmentsFile() string {
+	return filepath.Join(CacheDir(), "osconfig_assignments.json")
+}
+
+// FileBackupDir is the directory file resources keep the backups of the
+// files they replace in, see FileRollback.
+func FileBackupDir() string {
+	return filepath.Join(CacheDir(), "osconfig_file_backups")
+}
+
+// JournalFile is the location of the journal of changes made by the agent.
+func JournalFile() string {
+	return filepath.Join(CacheDir(), "osconfig_journal.jsonl")
+}
+
+// AuditLogFile is the location of the audit log of enforcement actions.
+func AuditLogFile() string {
+	if *auditLogFile != "" {
+		return *auditLogFile
+	}
+	return filepath.Join(CacheDir(), "osconfig_audit.jsonl")
+}
+
+// AuditLogMaxSize is the size in bytes at which AuditLogFile is rotated.
+func AuditLogMaxSize() int64 {
+	return int64(*auditLogMaxSize) * 1024 * 1024
+}
+
+// AuditLogMaxBackups is the number of rotated audit logs to keep.
+func AuditLogMaxBackups() int {
+	return *auditLogMaxBackups
+}
+
+// TaskHistorySink is where completed tasks are exported as NDJSON rows, a
+// local file rotated like the log file or a gs://bucket/prefix, empty to not
+// export them. It is set by osconfig-task-history-sink.
+func TaskHistorySink() string {
+	return getAgentConfig().taskHistorySink
+}
+
+// TaskHistoryMaxSize is the size in bytes at which a local TaskHistorySink
+// is rotated.
+func TaskHistoryMaxSize() int64 {
+	return int64(*taskHistoryMaxSize) * 1024 * 1024
+}
+
+// TaskHistoryMaxBackups is the number of rotated local TaskHistorySink files
+// to keep.
+func TaskHistoryMaxBackups() int {
+	return *taskHistoryMaxBackups
+}
+
+// CommandStallTimeout is how long a command may run without writing any
+// output before it is considered hung, 0, the default, to never consider
+// silent commands hung. It never applies to package manager commands, which
+// can be silent for long. It is set by osconfig-command-stall-timeout, like
+// 45m.
+func CommandStallTimeout() time.Duration {
+	return getAgentConfig().commandStallTimeout
+}
+
+// PolicyTimeBudget is the most time the resources of an OS policy may take
+// to be applied, 0 for no limit. Past it the remaining steps of the policy
+// are aborted and the agent moves on to the next policy. It is set by
+// osconfig-policy-time-budget, like 20m.
+func PolicyTimeBudget() time.Duration {
+	return getAgentConfig().policyTimeBudget
+}
+
+// ResourceTimeBudget is like PolicyTimeBudget for each resource of an OS
+// policy. It is set by osconfig-resource-time-budget, like 5m.
+func ResourceTimeBudget() time.Duration {
+	return getAgentConfig().resourceTimeBudget
+}
+
+// InventoryMinInterval is the least time between inventory reports triggered
+// by package changes, so a series of package transactions is reported once.
+// It is set by osconfig-inventory-min-interval, like 15m.
+func InventoryMinInterval() time.Duration {
+	return getAgentConfig().inventoryMinInterval
+}
+
+// BootIntegrity is the summary of the last Shielded VM integrity monitoring
+// result of the instance, like "passed" or "failed: late boot", empty if it
+// is not known. Integrity monitoring results are not visible to the guest,
+// tooling that follows them sets osconfig-boot-integrity.
+func BootIntegrity() string {
+	return getAgentConfig().bootIntegrity
+}
+
+// PatchCanarySimulate simulates patching before the real run and logs the
+// predicted changes.
+const PatchCanarySimulate = "simulate"
+
+// PatchCanary is how patch jobs are staged on the instance, set by
+// osconfig-patch-canary: PatchCanarySimulate or empty to patch without
+// simulating first. Unknown values are ignored.
+func PatchCanary() string {
+	if c := getAgentConfig().patchCanary; c == PatchCanarySimulate {
+		return c
+	}
+	return ""
+}
+
+// ResourceTypeBlocked reports whether OS policy resources of type, like exec
+// or hostEntry, are blocked on this instance by
+// osconfig-blocked-resource-types, a comma separated list of resource types
+// set in project or instance metadata.
+func ResourceTypeBlocked(typ string) bool {
+	for _, t := range getAgentConfig().blockedResourceTypes {
+		if strings.EqualFold(t, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// ARCredentialHelpers indicates whether repository resources for Artifact
+// Registry install its credential helper for apt or yum and use it for the
+// repository, set by osconfig-ar-credential-helpers. It is off by default,
+// the repository file is then written as given.
+func ARCredentialHelpers() bool {
+	return getAgentConfig().arCredentialHelpers
+}
+
+// HungCommandDir is where diagnostics of hung commands are written before
+// they are killed.
+func HungCommandDir() string {
+	return filepath.Join(CacheDir(), "hung_commands")
+}
+
+// AuditLogForwarding indicates whether audit events are also sent to Cloud
+// Logging, set by osconfig-audit-log-forwarding.
+func AuditLogForwarding() bool {
+	return getAgentConfig().auditLogForwarding
+}
+
+// PolicyCPUProfileFile is the location of the CPU profile of the last OS
+// policy run, see PolicyProfiling.
+func PolicyCPUProfileFile() string {
+	return filepath.Join(CacheDir(), "osconfig_policy_cpu.pprof")
+}
+
+// PolicyHeapProfileFile is the location of the heap profile of the last OS
+// policy run, see PolicyProfiling.
+func PolicyHeapProfileFile() string {
+	return filepath.Join(CacheDir(), "osconfig_policy_heap.pprof")
+}
+
+// PolicyLockDir is where the run locks of OS policy assignments are kept,
+// these stop a cycle applying an assignment a previous run still holds.
+func PolicyLockDir() string {
+	return filepath.Join(CacheDir(), "policy_locks")
+}
+
 // OldRestartFile is the location of the restart required file.
 func OldRestartFile() string {
 	return oldRestartFileLinux
@@ -744,6 +1437,9 @@ func CacheDir() string {
 	if runtime.GOOS == "windows" {
 		return GetCacheDirWindows()
 	}
+	if Unprivileged() {
+		return unprivilegedCacheDir()
+	}
 
 	return cacheDirLinux
 }
@@ -16,6 +16,7 @@ package agentconfig
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -30,7 +31,7 @@ import (
 
 func TestWatchConfig(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, `{"project":{"numericProjectID":12345,"projectId":"projectId","attributes":{"osconfig-endpoint":"bad!!1","enable-os-inventory":"false"}},"instance":{"id":12345,"name":"name","zone":"zone","attributes":{"osconfig-endpoint":"SvcEndpoint","enable-os-inventory":"1","enable-os-config-debug":"true","osconfig-enabled-prerelease-features":"ospackage,ospatch", "osconfig-poll-interval":"3"}}}`)
+		fmt.Fprintln(w, `{"project":{"numericProjectID":12345,"projectId":"projectId","attributes":{"osconfig-endpoint":"bad!!1","enable-os-inventory":"false"}},"instance":{"id":12345,"name":"name","zone":"zone","image":"projects/debian-cloud/global/images/debian-12-bookworm-v20240110","attributes":{"osconfig-endpoint":"SvcEndpoint","enable-os-inventory":"1","enable-os-config-debug":"true","osconfig-enabled-prerelease-features":"ospackage,ospatch", "osconfig-poll-interval":"3"}}}`)
 	}))
 	defer ts.Close()
 
@@ -53,6 +54,7 @@ func TestWatchConfig(t *testing.T) {
 		{"ProjectID", ProjectID, "projectId"},
 		{"Zone", Zone, "zone"},
 		{"Name", Name, "name"},
+		{"Image", Image, "projects/debian-cloud/global/images/debian-12-bookworm-v20240110"},
 	}
 	for _, tt := range testsString {
 		if tt.op() != tt.want {
@@ -88,6 +90,38 @@ func TestWatchConfig(t *testing.T) {
 	}
 }
 
+func TestUnprivilegedCacheDir(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the cache dir only moves on Linux")
+	}
+	defer func(f func() bool) { unprivileged = f }(unprivileged)
+	unprivileged = func() bool { return true }
+	defer func(c *config) { agentConfig = c }(agentConfig)
+	agentConfig = &config{}
+	t.Setenv("STATE_DIRECTORY", "/var/lib/osconfig-readonly:/var/lib/other")
+
+	if !ReadOnly() {
+		t.Errorf("ReadOnly: got false, want true when unprivileged")
+	}
+	defer func(s string) { *privilegedHelper = s }(*privilegedHelper)
+	*privilegedHelper = "/run/google_osconfig_agent/helper.sock"
+	if ReadOnly() {
+		t.Errorf("ReadOnly: got true, want false when unprivileged with a privileged helper")
+	}
+	if got, want := CacheDir(), "/var/lib/osconfig-readonly"; got != want {
+		t.Errorf("CacheDir: got %q, want %q", got, want)
+	}
+	if got, want := RestartFile(), "/var/lib/osconfig-readonly/osconfig_agent_restart_required"; got != want {
+		t.Errorf("RestartFile: got %q, want %q", got, want)
+	}
+
+	t.Setenv("STATE_DIRECTORY", "")
+	t.Setenv("XDG_CACHE_HOME", "/home/agent/.cache")
+	if got, want := TaskStateFile(), "/home/agent/.cache/google_osconfig_agent/osconfig_task.state"; got != want {
+		t.Errorf("TaskStateFile: got %q, want %q", got, want)
+	}
+}
+
 func TestSetConfigEnabled(t *testing.T) {
 	var request int
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -261,3 +295,564 @@ func TestSetConfigError(t *testing.T) {
 		t.Errorf("Unexpected output %+v", err)
 	}
 }
+
+func TestRefreshOnChange(t *testing.T) {
+	var logLevel = "info"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", "etag-"+logLevel)
+		fmt.Fprintf(w, `{"instance":{"zone":"fake-zone","attributes":{"osconfig-log-level":%q,"osconfig-poll-interval":"5"}}}`, logLevel)
+	}))
+	defer ts.Close()
+
+	if err := os.Setenv("GCE_METADATA_HOST", strings.Trim(ts.URL, "http://")); err != nil {
+		t.Fatalf("Error running os.Setenv: %v", err)
+	}
+
+	var changes int
+	OnChange(func() { changes++ })
+	defer func() { changeFuncs = nil }()
+
+	if err := Refresh(context.Background()); err != nil {
+		t.Fatalf("Error running Refresh: %v", err)
+	}
+	if Debug() {
+		t.Errorf("Debug: got(%t) != want(%t)", Debug(), false)
+	}
+	if SvcPollInterval() != 5*time.Minute {
+		t.Errorf("SvcPollInterval: got(%s) != want(%s)", SvcPollInterval(), 5*time.Minute)
+	}
+	before := changes
+
+	// Refreshing with unchanged metadata should not notify.
+	if err := Refresh(context.Background()); err != nil {
+		t.Fatalf("Error running Refresh: %v", err)
+	}
+	if changes != before {
+		t.Errorf("OnChange called %d times for unchanged config, want 0", changes-before)
+	}
+
+	logLevel = "debug"
+	if err := Refresh(context.Background()); err != nil {
+		t.Fatalf("Error running Refresh: %v", err)
+	}
+	if !Debug() {
+		t.Errorf("Debug: got(%t) != want(%t)", Debug(), true)
+	}
+	if changes != before+1 {
+		t.Errorf("OnChange called %d times, want 1", changes-before)
+	}
+}
+
+func TestARCredentialHelpers(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              bool
+	}{
+		{"Default", "", "", false},
+		{"Project", "true", "", true},
+		{"InstanceOverride", "true", "false", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.ARCredentialHelpers = tt.project
+			md.Instance.Attributes.ARCredentialHelpers = tt.instance
+			if got := createConfigFromMetadata(md).arCredentialHelpers; got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstanceCreated(t *testing.T) {
+	token := func(payload string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2ln"
+	}
+	tests := []struct {
+		name  string
+		token string
+		want  time.Time
+	}{
+		{"Full", token(`{"google":{"compute_engine":{"instance_creation_timestamp":1704153600,"instance_id":"12345"}}}`), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"Standard", token(`{"aud":"osconfig.googleapis.com"}`), time.Time{}},
+		{"Malformed", "token", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := instanceCreated(tt.token); !got.Equal(tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManagedRoots(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              []string
+	}{
+		{"Default", "", "", nil},
+		{"Project", "/srv/a", "", []string{"/srv/a"}},
+		{"InstanceOverride", "/srv/a", "/srv/b, c=pid:42,", []string{"/srv/b", "c=pid:42"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.ManagedRoots = tt.project
+			md.Instance.Attributes.ManagedRoots = tt.instance
+			if got := createConfigFromMetadata(md).managedRoots; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtendedInventory(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              bool
+	}{
+		{"Default", "", "", false},
+		{"Project", "extendedinventory", "", true},
+		{"Instance", "", "ospatch, ExtendedInventory", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.PreReleaseFeatures = tt.project
+			md.Instance.Attributes.PreReleaseFeatures = tt.instance
+			if got := createConfigFromMetadata(md).extendedInventory; got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBinaryInventoryPaths(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              []string
+	}{
+		{"Default", "", "", nil},
+		{"Project", "/opt/bin", "", []string{"/opt/bin"}},
+		{"InstanceOverride", "/opt/bin", "/home/*/go/bin,/root/.cargo", []string{"/home/*/go/bin", "/root/.cargo"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.BinaryInventoryPaths = tt.project
+			md.Instance.Attributes.BinaryInventoryPaths = tt.instance
+			if got := createConfigFromMetadata(md).binaryInventoryPaths; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPythonEnvPrefixes(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              []string
+	}{
+		{"Default", "", "", nil},
+		{"Project", "/opt/conda", "", []string{"/opt/conda"}},
+		{"InstanceOverride", "/opt/conda", "/opt/miniconda3, /srv/envs", []string{"/opt/miniconda3", "/srv/envs"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.PythonEnvPrefixes = tt.project
+			md.Instance.Attributes.PythonEnvPrefixes = tt.instance
+			if got := createConfigFromMetadata(md).pythonEnvPrefixes; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInventoryCollectors(t *testing.T) {
+	tests := []struct {
+		name                      string
+		project, instance         attributesJSON
+		wantEnabled, wantDisabled []string
+	}{
+		{"Default", attributesJSON{}, attributesJSON{}, nil, nil},
+		{"Project", attributesJSON{EnabledCollectors: "licenses,go"}, attributesJSON{}, []string{"licenses", "go"}, nil},
+		{"InstanceOverride", attributesJSON{DisabledCollectors: "pip"}, attributesJSON{DisabledCollectors: "gem, pip"}, nil, []string{"gem", "pip"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes = tt.project
+			md.Instance.Attributes = tt.instance
+			c := createConfigFromMetadata(md)
+			if !reflect.DeepEqual(c.enabledCollectors, tt.wantEnabled) {
+				t.Errorf("enabled: got %q, want %q", c.enabledCollectors, tt.wantEnabled)
+			}
+			if !reflect.DeepEqual(c.disabledCollectors, tt.wantDisabled) {
+				t.Errorf("disabled: got %q, want %q", c.disabledCollectors, tt.wantDisabled)
+			}
+		})
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              bool
+	}{
+		{"Default", "", "", false},
+		{"Project", "true", "", true},
+		{"InstanceOverride", "true", "false", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.ErrorCodes = tt.project
+			md.Instance.Attributes.ErrorCodes = tt.instance
+			if got := createConfigFromMetadata(md).errorCodes; got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAptOptions(t *testing.T) {
+	tests := []struct {
+		name                            string
+		project, instance               attributesJSON
+		wantWithNewPkgs, wantAutoremove bool
+	}{
+		{"Default", attributesJSON{}, attributesJSON{}, false, false},
+		{"Project", attributesJSON{AptWithNewPkgs: "true", AptAutoremove: "true"}, attributesJSON{}, true, true},
+		{"InstanceOverride", attributesJSON{AptAutoremove: "true"}, attributesJSON{AptWithNewPkgs: "true", AptAutoremove: "false"}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes = tt.project
+			md.Instance.Attributes = tt.instance
+			c := createConfigFromMetadata(md)
+			if c.aptWithNewPkgs != tt.wantWithNewPkgs {
+				t.Errorf("aptWithNewPkgs: got %t, want %t", c.aptWithNewPkgs, tt.wantWithNewPkgs)
+			}
+			if c.aptAutoremove != tt.wantAutoremove {
+				t.Errorf("aptAutoremove: got %t, want %t", c.aptAutoremove, tt.wantAutoremove)
+			}
+		})
+	}
+}
+
+func TestRebootLock(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              string
+	}{
+		{"Default", "", "", ""},
+		{"Project", "file:/mnt/shared/reboot.lock", "", "file:/mnt/shared/reboot.lock"},
+		{"InstanceOverride", "file:/mnt/shared/reboot.lock", "file:/mnt/other/reboot.lock", "file:/mnt/other/reboot.lock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.RebootLock = tt.project
+			md.Instance.Attributes.RebootLock = tt.instance
+			if got := createConfigFromMetadata(md).rebootLock; got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSerialLogPorts(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              []string
+	}{
+		{"Default", "", "", nil},
+		{"Project", "/dev/ttyS1", "", []string{"/dev/ttyS1"}},
+		{"InstanceOverride", "/dev/ttyS1", "/dev/ttyS1, /dev/ttyS2", []string{"/dev/ttyS1", "/dev/ttyS2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.SerialLogPorts = tt.project
+			md.Instance.Attributes.SerialLogPorts = tt.instance
+			if got := createConfigFromMetadata(md).serialLogPorts; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogBackend(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              string
+	}{
+		{"Default", "", "", ""},
+		{"Project", " Journald ", "", "journald"},
+		{"InstanceOverride", "journald", "EventLog", "eventlog"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.LogBackend = tt.project
+			md.Instance.Attributes.LogBackend = tt.instance
+			if got := createConfigFromMetadata(md).logBackend; got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyProfiling(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              bool
+	}{
+		{"Default", "", "", false},
+		{"Project", "true", "", true},
+		{"InstanceOverride", "true", "false", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.PolicyProfiling = tt.project
+			md.Instance.Attributes.PolicyProfiling = tt.instance
+			if got := createConfigFromMetadata(md).policyProfiling; got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              bool
+	}{
+		{"Default", "", "", false},
+		{"Project", "true", "", true},
+		{"InstanceOverride", "true", "false", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.ReadOnly = tt.project
+			md.Instance.Attributes.ReadOnly = tt.instance
+			if got := createConfigFromMetadata(md).readOnly; got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditLogForwarding(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              bool
+	}{
+		{"Default", "", "", false},
+		{"Project", "true", "", true},
+		{"InstanceOverride", "true", "false", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.AuditLogForwarding = tt.project
+			md.Instance.Attributes.AuditLogForwarding = tt.instance
+			if got := createConfigFromMetadata(md).auditLogForwarding; got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskHistorySink(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              string
+	}{
+		{"Default", "", "", ""},
+		{"Project", "gs://project/history", "", "gs://project/history"},
+		{"InstanceOverride", "gs://project/history", "/var/log/osconfig/history.ndjson", "/var/log/osconfig/history.ndjson"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.TaskHistorySink = tt.project
+			md.Instance.Attributes.TaskHistorySink = tt.instance
+			if got := createConfigFromMetadata(md).taskHistorySink; got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandStallTimeout(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              time.Duration
+	}{
+		{"Default", "", "", 0},
+		{"Project", "45m", "", 45 * time.Minute},
+		{"InstanceOverride", "45m", "0s", 0},
+		{"InvalidIgnored", "45m", "45", 45 * time.Minute},
+		{"NegativeIgnored", "", "-1m", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.CommandStallTimeout = tt.project
+			md.Instance.Attributes.CommandStallTimeout = tt.instance
+			if got := createConfigFromMetadata(md).commandStallTimeout; got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInventoryMinInterval(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              time.Duration
+	}{
+		{"Default", "", "", inventoryMinIntervalDefault},
+		{"Project", "30m", "", 30 * time.Minute},
+		{"InstanceOverride", "30m", "15m", 15 * time.Minute},
+		{"InvalidIgnored", "", "15", inventoryMinIntervalDefault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.InventoryMinInterval = tt.project
+			md.Instance.Attributes.InventoryMinInterval = tt.instance
+			if got := createConfigFromMetadata(md).inventoryMinInterval; got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBootIntegrity(t *testing.T) {
+	tests := []struct {
+		name              string
+		project, instance string
+		want              string
+	}{
+		{"Default", "", "", ""},
+		{"Instance", "", " passed ", "passed"},
+		// Boot integrity is only read from instance metadata.
+		{"ProjectIgnored", "passed", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.BootIntegrity = tt.project
+			md.Instance.Attributes.BootIntegrity = tt.instance
+			if got := createConfigFromMetadata(md).bootIntegrity; got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchCanary(t *testing.T) {
+	old := agentConfig
+	defer func() { agentConfig = old }()
+
+	tests := []struct {
+		name              string
+		project, instance string
+		want              string
+	}{
+		{"Default", "", "", ""},
+		{"Project", " Simulate ", "", PatchCanarySimulate},
+		{"InstanceOverride", "simulate", "off", ""},
+		{"UnknownIgnored", "", "confirm", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.PatchCanary = tt.project
+			md.Instance.Attributes.PatchCanary = tt.instance
+			agentConfig = createConfigFromMetadata(md)
+			if got := PatchCanary(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceTypeBlocked(t *testing.T) {
+	old := agentConfig
+	defer func() { agentConfig = old }()
+
+	tests := []struct {
+		name              string
+		project, instance string
+		typ               string
+		want              bool
+	}{
+		{"Default", "", "", "exec", false},
+		{"Project", "Exec", "", "exec", true},
+		// Instance metadata adds to the types blocked by the project.
+		{"ProjectAndInstance", "exec", "hostEntry", "exec", true},
+		{"Instance", "exec", "hostEntry", "hostEntry", true},
+		{"NotBlocked", "exec", "hostEntry", "pkg", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes.BlockedResourceTypes = tt.project
+			md.Instance.Attributes.BlockedResourceTypes = tt.instance
+			agentConfig = createConfigFromMetadata(md)
+			if got := ResourceTypeBlocked(tt.typ); got != tt.want {
+				t.Errorf("ResourceTypeBlocked(%q): got %t, want %t", tt.typ, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeBudgets(t *testing.T) {
+	tests := []struct {
+		name                     string
+		project, instance        attributesJSON
+		wantPolicy, wantResource time.Duration
+	}{
+		{"Default", attributesJSON{}, attributesJSON{}, 0, 0},
+		{"Project", attributesJSON{PolicyTimeBudget: "20m", ResourceTimeBudget: "5m"}, attributesJSON{}, 20 * time.Minute, 5 * time.Minute},
+		{"InstanceOverride", attributesJSON{PolicyTimeBudget: "20m"}, attributesJSON{PolicyTimeBudget: "10m", ResourceTimeBudget: "2m"}, 10 * time.Minute, 2 * time.Minute},
+		{"NegativeIgnored", attributesJSON{ResourceTimeBudget: "5m"}, attributesJSON{ResourceTimeBudget: "-1m"}, 0, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var md metadataJSON
+			md.Project.Attributes = tt.project
+			md.Instance.Attributes = tt.instance
+			c := createConfigFromMetadata(md)
+			if c.policyTimeBudget != tt.wantPolicy {
+				t.Errorf("policyTimeBudget: got %s, want %s", c.policyTimeBudget, tt.wantPolicy)
+			}
+			if c.resourceTimeBudget != tt.wantResource {
+				t.Errorf("resourceTimeBudget: got %s, want %s", c.resourceTimeBudget, tt.wantResource)
+			}
+		})
+	}
+}
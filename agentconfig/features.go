@@ -0,0 +1,82 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentconfig
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseFeatureRollouts parses a comma separated list of feature flags with an
+// optional rollout percentage, e.g. "delta_inventory=25,other_flag".
+// A flag with no percentage is enabled for every instance, entries that do
+// not parse are ignored. Entries are merged into rollouts, which may be nil.
+func parseFeatureRollouts(s string, rollouts map[string]int) map[string]int {
+	for _, f := range strings.Split(s, ",") {
+		name, pct, hasPct := strings.Cut(f, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		percent := 100
+		if hasPct {
+			p, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(pct), "%"))
+			if err != nil {
+				continue
+			}
+			percent = min(max(p, 0), 100)
+		}
+		if rollouts == nil {
+			rollouts = map[string]int{}
+		}
+		rollouts[name] = percent
+	}
+	return rollouts
+}
+
+// rolloutBucket deterministically maps an instance and feature to a bucket in
+// [0, 100). Including the feature name means different features are not
+// rolled out to the same set of instances first.
+func rolloutBucket(instanceID, feature string) int {
+	h := sha256.Sum256([]byte(instanceID + "/" + feature))
+	return int(binary.BigEndian.Uint64(h[:8]) % 100)
+}
+
+// FeatureEnabled reports whether the named feature flag is enabled for this
+// instance based on the rollout percentage set in metadata.
+func FeatureEnabled(name string) bool {
+	c := getAgentConfig()
+	percent, ok := c.featureRollouts[strings.ToLower(name)]
+	if !ok || percent <= 0 {
+		return false
+	}
+	return rolloutBucket(c.instanceID, strings.ToLower(name)) < percent
+}
+
+// EnabledFeatureFlags returns the sorted list of feature flags enabled for
+// this instance.
+func EnabledFeatureFlags() []string {
+	var flags []string
+	for name := range getAgentConfig().featureRollouts {
+		if FeatureEnabled(name) {
+			flags = append(flags, name)
+		}
+	}
+	sort.Strings(flags)
+	return flags
+}
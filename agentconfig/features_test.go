@@ -0,0 +1,81 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentconfig
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseFeatureRollouts(t *testing.T) {
+	tests := []struct {
+		desc     string
+		in       string
+		existing map[string]int
+		want     map[string]int
+	}{
+		{"empty", "", nil, nil},
+		{"no percentage", "foo", nil, map[string]int{"foo": 100}},
+		{"percentages", "Foo=25, bar=50%,baz=0", nil, map[string]int{"foo": 25, "bar": 50, "baz": 0}},
+		{"clamped", "foo=150,bar=-5", nil, map[string]int{"foo": 100, "bar": 0}},
+		{"bad entry ignored", "foo=abc,bar=10", nil, map[string]int{"bar": 10}},
+		{"merged", "foo=10", map[string]int{"foo": 50, "bar": 20}, map[string]int{"foo": 10, "bar": 20}},
+	}
+	for _, tt := range tests {
+		if got := parseFeatureRollouts(tt.in, tt.existing); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got(%v) != want(%v)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestFeatureEnabled(t *testing.T) {
+	old := agentConfig
+	defer func() { agentConfig = old }()
+
+	agentConfig = &config{instanceID: "12345", featureRollouts: map[string]int{"all": 100, "none": 0}}
+	if !FeatureEnabled("all") {
+		t.Errorf("FeatureEnabled(%q): got(false) != want(true)", "all")
+	}
+	if !FeatureEnabled("ALL") {
+		t.Errorf("FeatureEnabled(%q): got(false) != want(true)", "ALL")
+	}
+	if FeatureEnabled("none") {
+		t.Errorf("FeatureEnabled(%q): got(true) != want(false)", "none")
+	}
+	if FeatureEnabled("unknown") {
+		t.Errorf("FeatureEnabled(%q): got(true) != want(false)", "unknown")
+	}
+	if got := EnabledFeatureFlags(); !reflect.DeepEqual(got, []string{"all"}) {
+		t.Errorf("EnabledFeatureFlags: got(%q) != want(%q)", got, []string{"all"})
+	}
+
+	// A 50% rollout should enable roughly half of a large fleet and must be
+	// stable for any single instance.
+	var enabled int
+	for i := 0; i < 1000; i++ {
+		agentConfig = &config{instanceID: fmt.Sprint(i), featureRollouts: map[string]int{"half": 50}}
+		e := FeatureEnabled("half")
+		if e != FeatureEnabled("half") {
+			t.Fatalf("FeatureEnabled not stable for instance %d", i)
+		}
+		if e {
+			enabled++
+		}
+	}
+	if enabled < 400 || enabled > 600 {
+		t.Errorf("50%% rollout enabled %d of 1000 instances", enabled)
+	}
+}
@@ -0,0 +1,94 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentconfig
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// maxAssignmentSpread caps the spread window, so new assignment revisions
+// are still applied within the hour.
+const maxAssignmentSpread = 30 * time.Minute
+
+// parseAssignmentSpread parses a comma separated list of OS policy
+// assignments with the window their start is spread over, e.g.
+// "web-servers=10m,*=2m". Assignments are matched by their ID, * matches any
+// assignment without an entry. Entries that do not parse are ignored.
+// Entries are merged into spread, which may be nil.
+func parseAssignmentSpread(s string, spread map[string]time.Duration) map[string]time.Duration {
+	for _, e := range strings.Split(s, ",") {
+		name, window, ok := strings.Cut(e, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			continue
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(window))
+		if err != nil || d < 0 {
+			continue
+		}
+		if spread == nil {
+			spread = map[string]time.Duration{}
+		}
+		spread[name] = min(d, maxAssignmentSpread)
+	}
+	return spread
+}
+
+// assignmentID returns the ID of an OS policy assignment from its name,
+// projects/p/locations/l/osPolicyAssignments/id@revision.
+func assignmentID(assignment string) string {
+	if i := strings.LastIndex(assignment, "/"); i >= 0 {
+		assignment = assignment[i+1:]
+	}
+	id, _, _ := strings.Cut(assignment, "@")
+	return strings.ToLower(id)
+}
+
+// AssignmentStartDelay returns how long to hold back applying the policies
+// of an OS policy assignment, so instances applying the same assignment at
+// the same time do not all hit shared repositories and artifact servers at
+// once. The delay is a stable offset within the spread window set for the
+// assignment, derived from the instance and assignment.
+func AssignmentStartDelay(assignment string) time.Duration {
+	c := getAgentConfig()
+	id := assignmentID(assignment)
+	window, ok := c.assignmentSpread[id]
+	if !ok {
+		window = c.assignmentSpread["*"]
+	}
+	if window <= 0 {
+		return 0
+	}
+	h := sha256.Sum256([]byte(c.instanceID + "/" + id))
+	return time.Duration(binary.BigEndian.Uint64(h[:8]) % uint64(window))
+}
+
+// AssignmentPausedLocally reports whether applying an OS policy assignment
+// is paused on this instance by osconfig-pause-assignments, a comma
+// separated list of assignment IDs or * for every assignment. This is a
+// local switch set in metadata, it is unrelated to the rollout of the
+// assignment, whose state the service does not send to the agent.
+func AssignmentPausedLocally(assignment string) bool {
+	id := assignmentID(assignment)
+	for _, p := range getAgentConfig().pausedAssignments {
+		if p == "*" || strings.ToLower(p) == id {
+			return true
+		}
+	}
+	return false
+}
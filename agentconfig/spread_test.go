@@ -0,0 +1,120 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentconfig
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseAssignmentSpread(t *testing.T) {
+	tests := []struct {
+		desc     string
+		in       string
+		existing map[string]time.Duration
+		want     map[string]time.Duration
+	}{
+		{"empty", "", nil, nil},
+		{"windows", "Web=10m, *=90s", nil, map[string]time.Duration{"web": 10 * time.Minute, "*": 90 * time.Second}},
+		{"capped", "web=2h", nil, map[string]time.Duration{"web": maxAssignmentSpread}},
+		{"bad entry ignored", "web,db=abc,app=-1m,cache=1m", nil, map[string]time.Duration{"cache": time.Minute}},
+		{"merged", "web=1m", map[string]time.Duration{"web": 5 * time.Minute, "db": time.Minute}, map[string]time.Duration{"web": time.Minute, "db": time.Minute}},
+	}
+	for _, tt := range tests {
+		if got := parseAssignmentSpread(tt.in, tt.existing); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got(%v) != want(%v)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestAssignmentSpreadMetadata(t *testing.T) {
+	var md metadataJSON
+	md.Project.Attributes.AssignmentSpread = "web=10m,db=5m"
+	md.Instance.Attributes.AssignmentSpread = "web=1m"
+	// Instance windows are applied on top of project windows per assignment.
+	want := map[string]time.Duration{"web": time.Minute, "db": 5 * time.Minute}
+	if got := createConfigFromMetadata(md).assignmentSpread; !reflect.DeepEqual(got, want) {
+		t.Errorf("assignmentSpread: got(%v) != want(%v)", got, want)
+	}
+}
+
+func TestAssignmentStartDelay(t *testing.T) {
+	old := agentConfig
+	defer func() { agentConfig = old }()
+
+	const web = "projects/p/locations/l/osPolicyAssignments/web@abc"
+	agentConfig = &config{instanceID: "12345"}
+	if got := AssignmentStartDelay(web); got != 0 {
+		t.Errorf("AssignmentStartDelay with no spread: got(%v) != want(0)", got)
+	}
+
+	agentConfig = &config{instanceID: "12345", assignmentSpread: map[string]time.Duration{"web": 10 * time.Minute, "*": 0}}
+	if got := AssignmentStartDelay("projects/p/locations/l/osPolicyAssignments/db@abc"); got != 0 {
+		t.Errorf("AssignmentStartDelay for the default window: got(%v) != want(0)", got)
+	}
+
+	// Delays must be stable for an instance, ignore the revision and spread
+	// a fleet over the whole window.
+	var early, late int
+	for i := 0; i < 1000; i++ {
+		agentConfig = &config{instanceID: fmt.Sprint(i), assignmentSpread: map[string]time.Duration{"web": 10 * time.Minute}}
+		d := AssignmentStartDelay(web)
+		if d != AssignmentStartDelay("projects/p/locations/l/osPolicyAssignments/web@def") {
+			t.Fatalf("AssignmentStartDelay not stable for instance %d", i)
+		}
+		if d < 0 || d >= 10*time.Minute {
+			t.Fatalf("AssignmentStartDelay for instance %d: %v is outside the window", i, d)
+		}
+		if d < 5*time.Minute {
+			early++
+		} else {
+			late++
+		}
+	}
+	if early < 400 || late < 400 {
+		t.Errorf("AssignmentStartDelay spread 1000 instances %d early and %d late", early, late)
+	}
+}
+
+func TestAssignmentPausedLocally(t *testing.T) {
+	old := agentConfig
+	defer func() { agentConfig = old }()
+
+	const canary, web = "projects/p/locations/l/osPolicyAssignments/canary@1", "projects/p/locations/l/osPolicyAssignments/web@1"
+	tests := []struct {
+		desc                string
+		project, instance   string
+		wantCanary, wantWeb bool
+	}{
+		{"none", "", "", false, false},
+		{"instance", "", "Canary, db", true, false},
+		{"instance overrides project", "web", "canary", true, false},
+		{"all", "*", "", true, true},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.PauseAssignments = tt.project
+		md.Instance.Attributes.PauseAssignments = tt.instance
+		agentConfig = createConfigFromMetadata(md)
+		if got := AssignmentPausedLocally(canary); got != tt.wantCanary {
+			t.Errorf("%s: AssignmentPausedLocally(canary): got(%t) != want(%t)", tt.desc, got, tt.wantCanary)
+		}
+		if got := AssignmentPausedLocally(web); got != tt.wantWeb {
+			t.Errorf("%s: AssignmentPausedLocally(web): got(%t) != want(%t)", tt.desc, got, tt.wantWeb)
+		}
+	}
+}
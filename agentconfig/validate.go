@@ -0,0 +1,124 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentconfig
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// maxPollInterval is the longest poll interval in minutes, one day.
+const maxPollInterval = 24 * 60
+
+// endpointReachable dials an overridden service endpoint.
+var endpointReachable = func(endpoint string) error {
+	if _, _, err := net.SplitHostPort(endpoint); err != nil {
+		// gRPC defaults to port 443.
+		endpoint = net.JoinHostPort(endpoint, "443")
+	}
+	conn, err := net.DialTimeout("tcp", endpoint, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// endpointCheckDelays are the waits before each dial of a new service
+// endpoint, it may not be reachable yet early in boot.
+var endpointCheckDelays = []time.Duration{0, 30 * time.Second, 2 * time.Minute}
+
+// validEndpoint checks that endpoint is a host or host:port.
+func validEndpoint(endpoint string) error {
+	if endpoint == "" || strings.Contains(endpoint, "://") || strings.ContainsAny(endpoint, " \t\r\n/") {
+		return fmt.Errorf("invalid service endpoint %q, must be host or host:port", endpoint)
+	}
+	if host, port, err := net.SplitHostPort(endpoint); err == nil {
+		if p, err := strconv.Atoi(port); host == "" || err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("invalid service endpoint %q, must be host or host:port", endpoint)
+		}
+	}
+	return nil
+}
+
+// sanitize replaces each invalid setting of a new config, logging why, so
+// one bad setting does not discard the others. An invalid service endpoint
+// is replaced by the one of current, the active config, if it is valid, or
+// the default endpoint.
+func (c *config) sanitize(ctx context.Context, current *config) {
+	if c.osConfigPollInterval < 1 || c.osConfigPollInterval > maxPollInterval {
+		clamped := min(max(c.osConfigPollInterval, 1), maxPollInterval)
+		clog.Warningf(ctx, "Poll interval %d is out of range, must be 1 to %d minutes, using %d.", c.osConfigPollInterval, maxPollInterval, clamped)
+		c.osConfigPollInterval = clamped
+	}
+
+	if err := validEndpoint(c.svcEndpoint); err != nil {
+		fallback := current.svcEndpoint
+		if validEndpoint(fallback) != nil {
+			fallback = defaultEndpoint(c.instanceZone)
+		}
+		clog.Warningf(ctx, "%v, using %q.", err, fallback)
+		c.svcEndpoint = fallback
+	}
+}
+
+// defaultEndpoint returns the service endpoint of the zone of the instance.
+func defaultEndpoint(instanceZone string) string {
+	return strings.ReplaceAll(prodEndpoint, "{zone}", zoneName(instanceZone))
+}
+
+// checkEndpoint dials an overridden service endpoint and logs a warning if
+// it can not be reached. The check is only advisory, a direct connection
+// fails behind an HTTPS proxy that the agent may still use.
+func checkEndpoint(ctx context.Context, endpoint string) {
+	var err error
+	for _, d := range endpointCheckDelays {
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		if err = endpointReachable(endpoint); err == nil {
+			return
+		}
+	}
+	clog.Warningf(ctx, "Service endpoint %q is not reachable with a direct connection: %v. This is expected behind an HTTPS proxy.", endpoint, err)
+}
+
+// zoneName returns the zone from projects/123456/zones/us-west1-b.
+func zoneName(instanceZone string) string {
+	parts := strings.Split(instanceZone, "/")
+	return parts[len(parts)-1]
+}
+
+// stageConfig builds a config from md and activates it, invalid settings are
+// replaced one by one, see sanitize. A new overridden service endpoint is
+// checked in the background. It reports whether the active config changed.
+func stageConfig(ctx context.Context, md metadataJSON) bool {
+	current := getAgentConfig()
+	c := createConfigFromMetadata(md)
+	c.sanitize(ctx, &current)
+	if c.svcEndpoint != defaultEndpoint(c.instanceZone) && c.svcEndpoint != current.svcEndpoint {
+		go checkEndpoint(ctx, c.svcEndpoint)
+	}
+	return setConfig(c)
+}
@@ -0,0 +1,121 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Tests use made up endpoints, don't dial them.
+	endpointReachable = func(string) error { return nil }
+	os.Exit(m.Run())
+}
+
+func TestConfigSanitize(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		desc         string
+		interval     int
+		endpoint     string
+		current      string
+		wantInterval int
+		wantEndpoint string
+	}{
+		{"valid", 10, "zone-osconfig.googleapis.com.:443", "", 10, "zone-osconfig.googleapis.com.:443"},
+		{"host only", 10, "osconfig.example.com", "", 10, "osconfig.example.com"},
+		{"zero interval", 0, "osconfig.example.com", "", 1, "osconfig.example.com"},
+		{"interval too long", maxPollInterval + 1, "osconfig.example.com", "", maxPollInterval, "osconfig.example.com"},
+		{"empty endpoint", 10, "", "current.example.com", 10, "current.example.com"},
+		{"endpoint with scheme", 10, "https://osconfig.example.com", "current.example.com", 10, "current.example.com"},
+		{"bad port", 10, "osconfig.example.com:99999", "current.example.com", 10, "current.example.com"},
+		{"missing host", 10, ":443", "", 10, "zone-osconfig.googleapis.com.:443"},
+	}
+	for _, tt := range tests {
+		c := &config{osConfigPollInterval: tt.interval, svcEndpoint: tt.endpoint, instanceZone: "projects/1/zones/zone"}
+		c.sanitize(ctx, &config{svcEndpoint: tt.current})
+		if c.osConfigPollInterval != tt.wantInterval {
+			t.Errorf("%s: poll interval: got %d, want %d", tt.desc, c.osConfigPollInterval, tt.wantInterval)
+		}
+		if c.svcEndpoint != tt.wantEndpoint {
+			t.Errorf("%s: endpoint: got %q, want %q", tt.desc, c.svcEndpoint, tt.wantEndpoint)
+		}
+	}
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	defer func(f func(string) error) { endpointReachable = f }(endpointReachable)
+	defer func(d []time.Duration) { endpointCheckDelays = d }(endpointCheckDelays)
+	endpointCheckDelays = []time.Duration{0, 0, 0}
+
+	var dials int
+	endpointReachable = func(string) error {
+		dials++
+		if dials < 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	checkEndpoint(context.Background(), "osconfig.example.com")
+	if dials != 2 {
+		t.Errorf("dialed %d times, want a retry then success after 2", dials)
+	}
+
+	dials = 0
+	endpointReachable = func(string) error {
+		dials++
+		return errors.New("connection refused")
+	}
+	checkEndpoint(context.Background(), "osconfig.example.com")
+	if dials != len(endpointCheckDelays) {
+		t.Errorf("dialed %d times, want %d", dials, len(endpointCheckDelays))
+	}
+}
+
+func TestStageConfig(t *testing.T) {
+	defer func(c *config) { agentConfig = c }(agentConfig)
+	ctx := context.Background()
+	interval := func(s string) *json.Number { n := json.Number(s); return &n }
+
+	var md metadataJSON
+	md.Instance.Zone = "projects/1/zones/zone"
+	md.Instance.Attributes.PollInterval = interval("30")
+	md.Instance.Attributes.OSConfigEndpoint = "osconfig.example.com"
+
+	agentConfig = &config{}
+	if !stageConfig(ctx, md) {
+		t.Fatal("stageConfig: want a valid config to be set")
+	}
+
+	// An invalid setting is replaced, the others are kept.
+	md.Instance.Attributes.PollInterval = interval("-1")
+	if !stageConfig(ctx, md) {
+		t.Fatal("stageConfig: want the config to be set")
+	}
+	if got := getAgentConfig().osConfigPollInterval; got != 1 {
+		t.Errorf("poll interval: got %d, want 1", got)
+	}
+	if got, want := SvcEndpoint(), "osconfig.example.com"; got != want {
+		t.Errorf("SvcEndpoint: got %q, want %q", got, want)
+	}
+	if got, want := Zone(), "projects/1/zones/zone"; got != want {
+		t.Errorf("Zone: got %q, want %q", got, want)
+	}
+}
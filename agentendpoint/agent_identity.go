@@ -0,0 +1,55 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/GoogleCloudPlatform/osconfig/inventory"
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata keys of the agent identity sent with RegisterAgent.
+const (
+	agentSHA256Header     = "x-osconfig-agent-sha256"
+	agentPackageHeader    = "x-osconfig-agent-package"
+	agentRepositoryHeader = "x-osconfig-agent-repository"
+	agentModifiedHeader   = "x-osconfig-agent-modified"
+)
+
+// agentIdentity is overridden in tests.
+var agentIdentity = inventory.GetAgentIdentity
+
+// withAgentIdentity adds the identity of the agent binary, see
+// inventory.AgentIdentity, to the outgoing metadata of ctx. The
+// RegisterAgentRequest has no fields for it.
+func withAgentIdentity(ctx context.Context) context.Context {
+	id := agentIdentity(ctx)
+	if id == nil || id.SHA256 == "" {
+		return ctx
+	}
+	kv := []string{agentSHA256Header, id.SHA256}
+	if id.Package != nil {
+		kv = append(kv,
+			agentPackageHeader, id.Package.Name+"="+id.Package.Version,
+			agentModifiedHeader, strconv.FormatBool(id.Modified),
+		)
+		if id.Package.Repository != "" {
+			kv = append(kv, agentRepositoryHeader, id.Package.Repository)
+		}
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
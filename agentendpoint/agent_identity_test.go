@@ -0,0 +1,55 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/inventory"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestWithAgentIdentity(t *testing.T) {
+	defer func(f func(context.Context) *inventory.AgentIdentity) { agentIdentity = f }(agentIdentity)
+
+	tests := []struct {
+		desc string
+		id   *inventory.AgentIdentity
+		want metadata.MD
+	}{
+		{"no identity", &inventory.AgentIdentity{Error: "not found"}, nil},
+		{"no package", &inventory.AgentIdentity{SHA256: "abc"}, metadata.Pairs(agentSHA256Header, "abc")},
+		{
+			"package",
+			&inventory.AgentIdentity{SHA256: "abc", Modified: true, Package: &packages.PkgInfo{Name: "google-osconfig-agent", Version: "1.2", Repository: "google-compute-engine"}},
+			metadata.Pairs(
+				agentSHA256Header, "abc",
+				agentPackageHeader, "google-osconfig-agent=1.2",
+				agentModifiedHeader, "true",
+				agentRepositoryHeader, "google-compute-engine",
+			),
+		},
+	}
+	for _, tt := range tests {
+		agentIdentity = func(context.Context) *inventory.AgentIdentity { return tt.id }
+		got, _ := metadata.FromOutgoingContext(withAgentIdentity(context.Background()))
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s: withAgentIdentity() mismatch (-want +got):\n%s", tt.desc, diff)
+		}
+	}
+}
@@ -29,7 +29,9 @@ import (
 	"cloud.google.com/go/compute/metadata"
 	agentendpoint "cloud.google.com/go/osconfig/agentendpoint/apiv1"
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/agentstatus"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
 	"github.com/GoogleCloudPlatform/osconfig/osinfo"
 	"github.com/GoogleCloudPlatform/osconfig/retryutil"
 	"github.com/GoogleCloudPlatform/osconfig/tasker"
@@ -81,6 +83,9 @@ func NewClient(ctx context.Context) (*Client, error) {
 		option.WithEndpoint(agentconfig.SvcEndpoint()),
 		option.WithUserAgent(agentconfig.UserAgent()),
 	}
+	for _, o := range interceptorOptions() {
+		opts = append(opts, option.WithGRPCDialOption(o))
+	}
 	clog.Debugf(ctx, "Creating new agentendpoint client.")
 	c, err := agentendpoint.NewClient(ctx, opts...)
 	if err != nil {
@@ -124,7 +129,7 @@ func (c *Client) RegisterAgent(ctx context.Context) error {
 
 	req := &agentendpointpb.RegisterAgentRequest{
 		AgentVersion:          agentconfig.Version(),
-		SupportedCapabilities: agentconfig.Capabilities(),
+		SupportedCapabilities: supportedCapabilities(),
 		OsLongName:            oi.LongName,
 		OsShortName:           oi.ShortName,
 		OsVersion:             oi.Version,
@@ -134,6 +139,8 @@ func (c *Client) RegisterAgent(ctx context.Context) error {
 	clog.DebugRPC(ctx, "RegisterAgent", req, nil)
 	req.InstanceIdToken = token
 
+	ctx = withAgentIdentity(ctx)
+
 	var resp *agentendpointpb.RegisterAgentResponse
 	err = retryutil.RetryAPICall(ctx, apiRetrySec*time.Second, "RegisterAgent", func() error {
 		resp, err = c.raw.RegisterAgent(ctx, req)
@@ -230,6 +237,7 @@ func (c *Client) reportTaskComplete(ctx context.Context, req *agentendpointpb.Re
 		return err
 	})
 	clog.DebugRPC(ctx, "ReportTaskComplete", nil, res)
+	exportTaskHistory(ctx, req)
 
 	if err != nil {
 		return fmt.Errorf("error calling ReportTaskComplete: %w", err)
@@ -253,23 +261,75 @@ func (c *Client) runTask(ctx context.Context) {
 		}
 
 		clog.Debugf(ctx, "Received task: %s.", task.GetTaskType())
-		ctx := clog.WithLabels(ctx, map[string]string{"task_type": task.GetTaskType().String()})
-		switch task.GetTaskType() {
-		case agentendpointpb.TaskType_APPLY_PATCHES:
-			if err := c.RunApplyPatches(ctx, task); err != nil {
-				clog.Errorf(ctx, "Error running TaskType_APPLY_PATCHES: %v", err)
-			}
-		case agentendpointpb.TaskType_EXEC_STEP_TASK:
-			if err := c.RunExecStep(ctx, task); err != nil {
-				clog.Errorf(ctx, "Error running TaskType_EXEC_STEP_TASK: %v", err)
-			}
-		case agentendpointpb.TaskType_APPLY_CONFIG_TASK:
-			if err := c.RunApplyConfig(ctx, task); err != nil {
-				clog.Errorf(ctx, "Error running TaskType_APPLY_CONFIG_TASK: %v", err)
-			}
-		default:
-			clog.Errorf(ctx, "Unknown task type: %v", task.GetTaskType())
+		ctx := clog.WithLabels(ctx, map[string]string{"task_id": task.GetTaskId(), "task_type": task.GetTaskType().String()})
+		done := agentstatus.StartTask(task.GetTaskType().String())
+		if err := tasker.RunSafely(ctx, task.GetTaskType().String(), func() { c.runOneTask(ctx, task) }); err != nil {
+			c.reportTaskFailure(ctx, task, errorMessage(errcode.Internal, err.Error()))
 		}
+		done()
+	}
+}
+
+func (c *Client) runOneTask(ctx context.Context, task *agentendpointpb.Task) {
+	// Config tasks still run in read-only mode, in validation mode only, and
+	// when unprivileged through the privileged helper.
+	if task.GetTaskType() != agentendpointpb.TaskType_APPLY_CONFIG_TASK {
+		var mode string
+		switch {
+		case readOnly():
+			mode = "read-only mode"
+		case unprivileged():
+			mode = "unprivileged mode"
+		}
+		if mode != "" {
+			clog.Warningf(ctx, "Not running %s, the agent is in %s.", task.GetTaskType(), mode)
+			c.reportTaskFailure(ctx, task, errorMessage(errcode.Internal, fmt.Sprintf("%s is not supported in %s", task.GetTaskType(), mode)))
+			return
+		}
+	}
+
+	switch task.GetTaskType() {
+	case agentendpointpb.TaskType_APPLY_PATCHES:
+		if err := c.RunApplyPatches(ctx, task); err != nil {
+			clog.Errorf(ctx, "Error running TaskType_APPLY_PATCHES: %v", err)
+		}
+	case agentendpointpb.TaskType_EXEC_STEP_TASK:
+		if err := c.RunExecStep(ctx, task); err != nil {
+			clog.Errorf(ctx, "Error running TaskType_EXEC_STEP_TASK: %v", err)
+		}
+	case agentendpointpb.TaskType_APPLY_CONFIG_TASK:
+		if err := c.RunApplyConfig(ctx, task); err != nil {
+			clog.Errorf(ctx, "Error running TaskType_APPLY_CONFIG_TASK: %v", err)
+		}
+	default:
+		clog.Errorf(ctx, "Unknown task type: %v", task.GetTaskType())
+	}
+}
+
+// reportTaskFailure reports a task that panicked or was not run as failed so
+// it is not left running server side.
+func (c *Client) reportTaskFailure(ctx context.Context, task *agentendpointpb.Task, errMessage string) {
+	req := &agentendpointpb.ReportTaskCompleteRequest{
+		TaskId:       task.GetTaskId(),
+		TaskType:     task.GetTaskType(),
+		ErrorMessage: errMessage,
+	}
+	switch task.GetTaskType() {
+	case agentendpointpb.TaskType_APPLY_PATCHES:
+		req.Output = &agentendpointpb.ReportTaskCompleteRequest_ApplyPatchesTaskOutput{
+			ApplyPatchesTaskOutput: &agentendpointpb.ApplyPatchesTaskOutput{State: agentendpointpb.ApplyPatchesTaskOutput_FAILED},
+		}
+	case agentendpointpb.TaskType_EXEC_STEP_TASK:
+		req.Output = &agentendpointpb.ReportTaskCompleteRequest_ExecStepTaskOutput{
+			ExecStepTaskOutput: &agentendpointpb.ExecStepTaskOutput{State: agentendpointpb.ExecStepTaskOutput_COMPLETED, ExitCode: -1},
+		}
+	case agentendpointpb.TaskType_APPLY_CONFIG_TASK:
+		req.Output = &agentendpointpb.ReportTaskCompleteRequest_ApplyConfigTaskOutput{
+			ApplyConfigTaskOutput: &agentendpointpb.ApplyConfigTaskOutput{State: agentendpointpb.ApplyConfigTaskOutput_FAILED},
+		}
+	}
+	if err := c.reportTaskComplete(ctx, req); err != nil {
+		clog.Errorf(ctx, "Error reporting failed state for task: %v", err)
 	}
 }
 
@@ -361,6 +421,10 @@ func (c *Client) waitForTask(ctx context.Context) error {
 			// Service is not enabled for this project.
 			return errServiceNotEnabled
 		case codes.ResourceExhausted:
+			// Hold the next stream for the retry delay the server asked for.
+			if d, ok := retryutil.RetryDelay(err); ok {
+				apiBudget.block(time.Now(), d)
+			}
 			return errResourceExhausted
 		}
 	}
@@ -398,7 +462,11 @@ func (c *Client) WaitForTaskNotification(ctx context.Context) {
 			default:
 			}
 
-			if err := c.waitForTask(ctx); err != nil {
+			var err error
+			if perr := tasker.RunSafely(ctx, "ReceiveTaskNotification", func() { err = c.waitForTask(ctx) }); perr != nil {
+				err = perr
+			}
+			if err != nil {
 				if errors.Is(err, errServiceNotEnabled) {
 					// Service is disabled, close this client and return.
 					clog.Warningf(ctx, "OSConfig Service is disabled.")
@@ -50,6 +50,9 @@ func NewBetaClient(ctx context.Context) (*BetaClient, error) {
 		option.WithEndpoint(agentconfig.SvcEndpoint()),
 		option.WithUserAgent(agentconfig.UserAgent()),
 	}
+	for _, o := range interceptorOptions() {
+		opts = append(opts, option.WithGRPCDialOption(o))
+	}
 	clog.Debugf(ctx, "Creating new agentendpoint beta client.")
 	c, err := agentendpoint.NewClient(ctx, opts...)
 	if err != nil {
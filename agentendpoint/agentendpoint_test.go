@@ -47,6 +47,20 @@ import (
 var testIDToken string
 
 func TestMain(m *testing.M) {
+	// Tests run as any user, enforcement is tested as root.
+	readOnly = func() bool { return false }
+	unprivileged = func() bool { return false }
+
+	// Keep OS policy run locks out of the agent cache dir.
+	lockDir, err := os.MkdirTemp("", "policy_locks")
+	if err != nil {
+		fmt.Printf("Error creating lock dir: %v", err)
+		os.Exit(1)
+	}
+	policyLockFile = func(assignment string) string {
+		return filepath.Join(lockDir, filepath.Base(assignment)+".lock")
+	}
+
 	cs := &jws.ClaimSet{
 		Exp: time.Now().Add(1 * time.Hour).Unix(),
 	}
@@ -75,6 +89,7 @@ func TestMain(m *testing.M) {
 
 	out := m.Run()
 	ts.Close()
+	os.RemoveAll(lockDir)
 	os.Exit(out)
 }
 
@@ -344,3 +359,104 @@ func TestLoadPatchTaskFromState(t *testing.T) {
 		t.Errorf("first entry in runTaskIDs does not match taskID, %q, %q", srv.runTaskIDs, taskID)
 	}
 }
+
+type panicResource struct {
+	testResource
+}
+
+func (r *panicResource) Validate(ctx context.Context) error {
+	panic("boom")
+}
+
+// panicTestServer sends one config task and records its completion.
+type panicTestServer struct {
+	*agentEndpointServiceTestServer
+	started  bool
+	complete *agentendpointpb.ReportTaskCompleteRequest
+}
+
+func (s *panicTestServer) StartNextTask(ctx context.Context, req *agentendpointpb.StartNextTaskRequest) (*agentendpointpb.StartNextTaskResponse, error) {
+	if s.started {
+		return &agentendpointpb.StartNextTaskResponse{}, nil
+	}
+	s.started = true
+	return &agentendpointpb.StartNextTaskResponse{Task: &agentendpointpb.Task{
+		TaskType: agentendpointpb.TaskType_APPLY_CONFIG_TASK,
+		TaskId:   "panic",
+		TaskDetails: &agentendpointpb.Task_ApplyConfigTask{
+			ApplyConfigTask: &agentendpointpb.ApplyConfigTask{OsPolicies: []*agentendpointpb.ApplyConfigTask_OSPolicy{genTestPolicy("p1")}},
+		},
+	}}, nil
+}
+
+func (s *panicTestServer) ReportTaskProgress(ctx context.Context, req *agentendpointpb.ReportTaskProgressRequest) (*agentendpointpb.ReportTaskProgressResponse, error) {
+	return &agentendpointpb.ReportTaskProgressResponse{TaskDirective: agentendpointpb.TaskDirective_CONTINUE}, nil
+}
+
+func (s *panicTestServer) ReportTaskComplete(ctx context.Context, req *agentendpointpb.ReportTaskCompleteRequest) (*agentendpointpb.ReportTaskCompleteResponse, error) {
+	s.complete = req
+	return &agentendpointpb.ReportTaskCompleteResponse{}, nil
+}
+
+func TestRunTaskPanic(t *testing.T) {
+	ctx := context.Background()
+	srv := &panicTestServer{agentEndpointServiceTestServer: newAgentEndpointServiceTestServer()}
+	tc, err := newTestClient(ctx, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.close()
+
+	defer func(f func(*agentendpointpb.OSPolicy_Resource) *resource) { newResource = f }(newResource)
+	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+		return &resource{resourceIface: resourceIface(&panicResource{})}
+	}
+	sameStateTimeWindow = 0
+
+	// runTask returns instead of crashing and reports the task as failed.
+	tc.client.runTask(ctx)
+
+	if srv.complete == nil {
+		t.Fatal("expected ReportTaskComplete to have been called")
+	}
+	if got, want := srv.complete.GetApplyConfigTaskOutput().GetState(), agentendpointpb.ApplyConfigTaskOutput_FAILED; got != want {
+		t.Errorf("ApplyConfigTaskOutput state: got %s, want %s", got, want)
+	}
+	if got, want := srv.complete.GetErrorMessage(), "APPLY_CONFIG_TASK panicked: boom"; got != want {
+		t.Errorf("ErrorMessage: got %q, want %q", got, want)
+	}
+}
+
+func TestRunOneTaskReadOnly(t *testing.T) {
+	ctx := context.Background()
+	srv := &panicTestServer{agentEndpointServiceTestServer: newAgentEndpointServiceTestServer()}
+	tc, err := newTestClient(ctx, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.close()
+
+	defer func(f func() bool) { readOnly = f }(readOnly)
+	readOnly = func() bool { return true }
+
+	tc.client.runOneTask(ctx, &agentendpointpb.Task{TaskId: "patch", TaskType: agentendpointpb.TaskType_APPLY_PATCHES})
+
+	if srv.complete == nil {
+		t.Fatal("expected ReportTaskComplete to have been called")
+	}
+	if got, want := srv.complete.GetApplyPatchesTaskOutput().GetState(), agentendpointpb.ApplyPatchesTaskOutput_FAILED; got != want {
+		t.Errorf("ApplyPatchesTaskOutput state: got %s, want %s", got, want)
+	}
+	if got, want := srv.complete.GetErrorMessage(), "APPLY_PATCHES is not supported in read-only mode"; got != want {
+		t.Errorf("ErrorMessage: got %q, want %q", got, want)
+	}
+
+	// Exec steps are not run by an unprivileged agent either.
+	readOnly = func() bool { return false }
+	defer func(f func() bool) { unprivileged = f }(unprivileged)
+	unprivileged = func() bool { return true }
+	tc.client.runOneTask(ctx, &agentendpointpb.Task{TaskId: "exec", TaskType: agentendpointpb.TaskType_EXEC_STEP_TASK})
+	if got, want := srv.complete.GetErrorMessage(), "EXEC_STEP_TASK is not supported in unprivileged mode"; got != want {
+		t.Errorf("ErrorMessage: got %q, want %q", got, want)
+	}
+}
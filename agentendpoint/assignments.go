@@ -0,0 +1,196 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/attributes"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+var (
+	assignmentsFile = agentconfig.AssignmentsFile
+	postAssignments = func(ctx context.Context, url string, value []byte) error {
+		b := attributes.NewBatch()
+		b.Put(url, value)
+		return b.Flush(ctx)
+	}
+)
+
+// AssignmentPaused is the State of an assignment whose policies were not
+// applied as it is paused on this instance, see
+// agentconfig.AssignmentPausedLocally.
+const AssignmentPaused = "PAUSED"
+
+// AssignmentDeferred is the State of an assignment whose policies were not
+// applied yet as its start is spread, see agentconfig.AssignmentStartDelay.
+const AssignmentDeferred = "DEFERRED"
+
+// Assignment is an OS policy assignment revision the agent last applied.
+type Assignment struct {
+	// Name is the assignment resource name without the revision.
+	Name     string `json:"name"`
+	Revision string `json:"revision,omitempty"`
+	// Policies are the IDs of the OS policies of the assignment.
+	Policies []string `json:"policies"`
+	// State is the compliance of the resources of the revision after it was
+	// applied, or AssignmentPaused or AssignmentDeferred. The rollout state
+	// of the assignment is not sent to the agent.
+	State     string    `json:"state"`
+	AppliedAt time.Time `json:"appliedAt"`
+	// FirstSeenAt is when a config task first carried the revision.
+	FirstSeenAt time.Time `json:"firstSeenAt"`
+}
+
+// splitAssignment splits "projects/p/locations/l/osPolicyAssignments/a@rev"
+// into the assignment name and revision.
+func splitAssignment(s string) (string, string) {
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// assignments summarizes the policies of the task by assignment. An
+// assignment is NON_COMPLIANT if any of its resources is, otherwise UNKNOWN
+// if any resource is not COMPLIANT.
+func (c *configTask) assignments(appliedAt time.Time) []Assignment {
+	var out []Assignment
+	index := map[string]int{}
+	for i, osPolicy := range c.Task.GetOsPolicies() {
+		name, revision := splitAssignment(osPolicy.GetOsPolicyAssignment())
+		j, ok := index[osPolicy.GetOsPolicyAssignment()]
+		if !ok {
+			j = len(out)
+			index[osPolicy.GetOsPolicyAssignment()] = j
+			firstSeen, ok := c.firstSeen[osPolicy.GetOsPolicyAssignment()]
+			if !ok {
+				firstSeen = appliedAt
+			}
+			out = append(out, Assignment{Name: name, Revision: revision, State: agentendpointpb.OSPolicyComplianceState_COMPLIANT.String(), AppliedAt: appliedAt, FirstSeenAt: firstSeen})
+		}
+		a := &out[j]
+		a.Policies = append(a.Policies, osPolicy.GetId())
+		if c.paused[osPolicy.GetOsPolicyAssignment()] {
+			a.State = AssignmentPaused
+			continue
+		}
+		if c.deferred[osPolicy.GetOsPolicyAssignment()] {
+			a.State = AssignmentDeferred
+			continue
+		}
+		if i >= len(c.results) {
+			a.State = agentendpointpb.OSPolicyComplianceState_UNKNOWN.String()
+			continue
+		}
+		for _, rc := range c.results[i].GetOsPolicyResourceCompliances() {
+			switch rc.GetState() {
+			case agentendpointpb.OSPolicyComplianceState_COMPLIANT:
+			case agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT:
+				a.State = agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT.String()
+			default:
+				if a.State == agentendpointpb.OSPolicyComplianceState_COMPLIANT.String() {
+					a.State = agentendpointpb.OSPolicyComplianceState_UNKNOWN.String()
+				}
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// recordAssignments persists the assignments of the task to the state dir
+// and, if enabled, guest attributes, replacing the previous record. Each
+// config task carries every assignment that applies to the instance, so the
+// record is the set of revisions the instance believes it is under.
+func (c *configTask) recordAssignments(ctx context.Context) {
+	as := c.assignments(time.Now().UTC())
+	if as == nil {
+		as = []Assignment{}
+	}
+	b, err := json.MarshalIndent(as, "", "  ")
+	if err != nil {
+		clog.Errorf(ctx, "Error encoding OS policy assignments: %v", err)
+		return
+	}
+	path := assignmentsFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		clog.Errorf(ctx, "Error recording OS policy assignments: %v", err)
+	} else if err := util.AtomicWrite(path, b, 0644); err != nil {
+		clog.Errorf(ctx, "Error recording OS policy assignments to %q: %v", path, err)
+	}
+
+	if agentconfig.GuestAttributesEnabled() {
+		url := agentconfig.ReportURL + "/osconfig/assignments"
+		clog.Debugf(ctx, "postAttribute %s", url)
+		if err := postAssignments(ctx, url, b); err != nil {
+			clog.Errorf(ctx, "postAttribute error: %v", err)
+		}
+	}
+}
+
+// LoadAssignments reads the OS policy assignments recorded by the last config
+// task, none if no config task ran yet.
+func LoadAssignments() ([]Assignment, error) {
+	b, err := os.ReadFile(assignmentsFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var as []Assignment
+	if err := json.Unmarshal(b, &as); err != nil {
+		return nil, fmt.Errorf("error parsing OS policy assignments %q: %v", assignmentsFile(), err)
+	}
+	return as, nil
+}
+
+// WriteAssignments writes assignments in the given format, FormatText or
+// FormatJSON.
+func WriteAssignments(w io.Writer, as []Assignment, format string) error {
+	switch format {
+	case FormatText:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ASSIGNMENT\tREVISION\tSTATE\tPOLICIES\tAPPLIED")
+		for _, a := range as {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", a.Name, a.Revision, a.State, strings.Join(a.Policies, ","), a.AppliedAt.Format(time.RFC3339))
+		}
+		return tw.Flush()
+	case FormatJSON:
+		if as == nil {
+			as = []Assignment{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(as)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
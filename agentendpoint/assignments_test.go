@@ -0,0 +1,132 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitAssignment(t *testing.T) {
+	tests := []struct {
+		in, name, revision string
+	}{
+		{"projects/p/locations/l/osPolicyAssignments/a@rev1", "projects/p/locations/l/osPolicyAssignments/a", "rev1"},
+		{"projects/p/locations/l/osPolicyAssignments/a", "projects/p/locations/l/osPolicyAssignments/a", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		name, revision := splitAssignment(tt.in)
+		if name != tt.name || revision != tt.revision {
+			t.Errorf("splitAssignment(%q) = (%q, %q), want (%q, %q)", tt.in, name, revision, tt.name, tt.revision)
+		}
+	}
+}
+
+func TestRecordAssignments(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func() string) { assignmentsFile = f }(assignmentsFile)
+	path := filepath.Join(t.TempDir(), "assignments.json")
+	assignmentsFile = func() string { return path }
+
+	compliance := func(states ...agentendpointpb.OSPolicyComplianceState) *agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult {
+		r := &agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{}
+		for _, s := range states {
+			r.OsPolicyResourceCompliances = append(r.OsPolicyResourceCompliances, &agentendpointpb.OSPolicyResourceCompliance{State: s})
+		}
+		return r
+	}
+	c := &configTask{
+		Task: &applyConfigTask{&agentendpointpb.ApplyConfigTask{OsPolicies: []*agentendpointpb.ApplyConfigTask_OSPolicy{
+			{Id: "p1", OsPolicyAssignment: "projects/p/locations/l/osPolicyAssignments/b@rev2"},
+			{Id: "p2", OsPolicyAssignment: "projects/p/locations/l/osPolicyAssignments/a@rev1"},
+			{Id: "p3", OsPolicyAssignment: "projects/p/locations/l/osPolicyAssignments/b@rev2"},
+			{Id: "p4", OsPolicyAssignment: "projects/p/locations/l/osPolicyAssignments/c@rev3"},
+		}}},
+		results: []*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{
+			compliance(agentendpointpb.OSPolicyComplianceState_COMPLIANT, agentendpointpb.OSPolicyComplianceState_UNKNOWN),
+			compliance(agentendpointpb.OSPolicyComplianceState_COMPLIANT),
+			compliance(agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT),
+			compliance(agentendpointpb.OSPolicyComplianceState_UNKNOWN),
+		},
+	}
+
+	// Nothing is recorded before the first config task.
+	got, err := LoadAssignments()
+	if err != nil || got != nil {
+		t.Fatalf("LoadAssignments() = %v, %v, want nil, nil", got, err)
+	}
+
+	c.recordAssignments(ctx)
+	got, err = LoadAssignments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Assignment{
+		{Name: "projects/p/locations/l/osPolicyAssignments/a", Revision: "rev1", Policies: []string{"p2"}, State: "COMPLIANT"},
+		{Name: "projects/p/locations/l/osPolicyAssignments/b", Revision: "rev2", Policies: []string{"p1", "p3"}, State: "NON_COMPLIANT"},
+		{Name: "projects/p/locations/l/osPolicyAssignments/c", Revision: "rev3", Policies: []string{"p4"}, State: "UNKNOWN"},
+	}
+	for i := range got {
+		if got[i].AppliedAt.IsZero() || !got[i].FirstSeenAt.Equal(got[i].AppliedAt) {
+			t.Errorf("assignment %q: AppliedAt %v, FirstSeenAt %v, want both set to the same time", got[i].Name, got[i].AppliedAt, got[i].FirstSeenAt)
+		}
+		got[i].AppliedAt, got[i].FirstSeenAt = time.Time{}, time.Time{}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("LoadAssignments() mismatch (-want +got):\n%s", diff)
+	}
+
+	// A task with no policies replaces the record.
+	(&configTask{Task: &applyConfigTask{&agentendpointpb.ApplyConfigTask{}}}).recordAssignments(ctx)
+	got, err = LoadAssignments()
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf("LoadAssignments() after empty task = %v, %v, want empty", got, err)
+	}
+}
+
+func TestWriteAssignments(t *testing.T) {
+	as := []Assignment{
+		{Name: "projects/p/locations/l/osPolicyAssignments/a", Revision: "rev1", Policies: []string{"p1", "p2"}, State: "COMPLIANT", AppliedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	var buf bytes.Buffer
+	if err := WriteAssignments(&buf, as, FormatText); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"ASSIGNMENT", "projects/p/locations/l/osPolicyAssignments/a", "rev1", "COMPLIANT", "p1,p2", "2024-01-02T03:04:05Z"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("text output missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := WriteAssignments(&buf, nil, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(buf.String()); got != "[]" {
+		t.Errorf("JSON output for no assignments = %q, want []", got)
+	}
+
+	if err := WriteAssignments(&buf, as, "yaml"); err == nil {
+		t.Error("WriteAssignments with unknown format: want error")
+	}
+}
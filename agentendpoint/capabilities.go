@@ -0,0 +1,61 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+)
+
+// errorCodes, readOnly and unprivileged are overridden in tests.
+var (
+	errorCodes   = agentconfig.ErrorCodes
+	readOnly     = agentconfig.ReadOnly
+	unprivileged = agentconfig.Unprivileged
+)
+
+// supportedCapabilities returns the capabilities sent in RegisterAgent, the
+// ones the service defines and matches tasks on. In read-only mode, or when
+// the agent runs unprivileged, no patch capabilities are sent so the service
+// does not send patch tasks. RegisterAgentResponse has no fields, so the
+// service does not acknowledge capabilities and no agent behavior is gated
+// on them.
+func supportedCapabilities() []string {
+	noPatch := readOnly() || unprivileged()
+	var caps []string
+	for _, c := range agentconfig.Capabilities() {
+		if noPatch && strings.HasPrefix(c, "PATCH_") {
+			continue
+		}
+		caps = append(caps, c)
+	}
+	return caps
+}
+
+// errorMessage appends the remediation hint for msg to package manager
+// errors, if any, and prefixes it with code when error codes are turned on,
+// see agentconfig.ErrorCodes. The hints match package manager output, so
+// they are not applied to other errors.
+func errorMessage(code errcode.Code, msg string) string {
+	if code == errcode.PackageManager {
+		msg = errcode.WithHint(msg)
+	}
+	if !errorCodes() {
+		return msg
+	}
+	return errcode.Format(code, msg)
+}
@@ -0,0 +1,68 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+)
+
+func TestSupportedCapabilities(t *testing.T) {
+	// Only the capabilities the service defines are sent.
+	if got, want := supportedCapabilities(), agentconfig.Capabilities(); !reflect.DeepEqual(got, want) {
+		t.Errorf("supportedCapabilities() = %q, want %q", got, want)
+	}
+}
+
+func TestSupportedCapabilitiesNoPatch(t *testing.T) {
+	defer func(ro, up func() bool) { readOnly, unprivileged = ro, up }(readOnly, unprivileged)
+	for _, mode := range []string{"read-only", "unprivileged"} {
+		readOnly = func() bool { return mode == "read-only" }
+		unprivileged = func() bool { return mode == "unprivileged" }
+
+		caps := supportedCapabilities()
+		for _, c := range caps {
+			if strings.HasPrefix(c, "PATCH_") {
+				t.Errorf("%s: unexpected capability %q", mode, c)
+			}
+		}
+		if len(caps) != len(agentconfig.Capabilities())-1 {
+			t.Errorf("%s: supportedCapabilities() = %q, want %q without PATCH_GA", mode, caps, agentconfig.Capabilities())
+		}
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	defer func(f func() bool) { errorCodes = f }(errorCodes)
+
+	errorCodes = func() bool { return false }
+	if got, want := errorMessage(errcode.Network, "error"), "error"; got != want {
+		t.Errorf("error codes off: got %q, want %q", got, want)
+	}
+	errorCodes = func() bool { return true }
+	if got, want := errorMessage(errcode.Network, "error"), "[NETWORK] error"; got != want {
+		t.Errorf("error codes on: got %q, want %q", got, want)
+	}
+	if got, want := errorMessage(errcode.PackageManager, "dpkg was interrupted"), "[PACKAGE_MANAGER] dpkg was interrupted Hint: a previous installation was interrupted, run dpkg --configure -a."; got != want {
+		t.Errorf("with hint: got %q, want %q", got, want)
+	}
+	if got, want := errorMessage(errcode.Script, "dpkg was interrupted"), "[SCRIPT] dpkg was interrupted"; got != want {
+		t.Errorf("hint for another code: got %q, want %q", got, want)
+	}
+}
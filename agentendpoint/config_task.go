@@ -16,15 +16,19 @@ package agentendpoint
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/agentstatus"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/config"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
 	"github.com/GoogleCloudPlatform/osconfig/pretty"
+	"github.com/GoogleCloudPlatform/osconfig/util"
 
 	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
 )
@@ -37,6 +41,15 @@ var newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
 	return &resource{resourceIface: resourceIface(&config.OSPolicyResource{OSPolicy_Resource: r})}
 }
 
+// assignmentStartDelay, assignmentPausedLocally, policyTimeBudget and
+// resourceTimeBudget are overridden in tests.
+var (
+	assignmentStartDelay    = agentconfig.AssignmentStartDelay
+	assignmentPausedLocally = agentconfig.AssignmentPausedLocally
+	policyTimeBudget        = agentconfig.PolicyTimeBudget
+	resourceTimeBudget      = agentconfig.ResourceTimeBudget
+)
+
 var repoFormats = []string{agentconfig.AptRepoFormat(), agentconfig.YumRepoFormat(), agentconfig.ZypperRepoFormat(), agentconfig.GooGetRepoFormat()}
 
 type configTask struct {
@@ -48,6 +61,43 @@ type configTask struct {
 	TaskID            string
 	results           []*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult
 	managedResources  []*config.ManagedResources
+	// claims are the files and packages managed by the resources of
+	// enforced policies validated so far, see detectPolicyConflicts.
+	claims map[string]*claim
+	// localResources are resource types only available in local policies,
+	// keyed by localResourceKey.
+	localResources map[string]*config.LocalResource
+	// execExitCodes are the exit codes of exec resources of local policies,
+	// keyed by localResourceKey.
+	execExitCodes map[string]*config.ExecExitCodes
+	// fileChecksums are the checksum settings of file resources of local
+	// policies, keyed by localResourceKey.
+	fileChecksums map[string]*config.FileChecksum
+	// packageOptions are the options of pkg resources of local policies,
+	// keyed by localResourceKey.
+	packageOptions map[string]*config.PackageOptions
+	// validations are the policy level validations of local policies, keyed
+	// by policy id.
+	validations map[string]*agentendpointpb.OSPolicy_Resource_ExecResource_Exec
+	// imageBuildResources are hashes of resources that were compliant when
+	// the image was built, these are not checked or enforced.
+	imageBuildResources map[string]bool
+	// spread defers each assignment revision until its start delay has
+	// passed and skips assignments paused on this instance, this is only
+	// done for tasks from the service.
+	spread bool
+	// firstSeen is when each assignment revision of the task was first seen
+	// by a config task, the start delay counts from it.
+	firstSeen map[string]time.Time
+	// deferred are the assignments skipped as their start delay has not
+	// passed yet.
+	deferred map[string]bool
+	// paused are the assignments skipped as they are paused on this
+	// instance.
+	paused map[string]bool
+	// assignmentLocks are the run locks taken by this task keyed by
+	// assignment, nil for assignments skipped as another run holds them.
+	assignmentLocks map[string]*util.FileLock
 }
 
 type applyConfigTask struct {
@@ -56,12 +106,25 @@ type applyConfigTask struct {
 
 type policy struct {
 	resources map[string]*resource
+	// budgetExceeded is set when the policy or one of its resources ran out
+	// of its time budget, the remaining steps of the policy were aborted.
+	budgetExceeded bool
 }
 
 type resource struct {
 	resourceIface
 	needsPostCheck       bool
 	validateOrCheckError bool
+	// enforced and enforceError record the enforce step of this run, for
+	// rolling back earlier resources of the policy.
+	enforced     bool
+	enforceError bool
+}
+
+// rollbacker is implemented by resources that can restore what enforcement
+// replaced, see config.OSPolicyResource.Rollback.
+type rollbacker interface {
+	Rollback(context.Context) (bool, error)
 }
 
 type resourceIface interface {
@@ -94,7 +157,7 @@ func (c *configTask) handleErrorState(ctx context.Context, msg string, err error
 		clog.Infof(ctx, "Cancelling config run: %v", errServerCancel)
 		return c.reportCompletedState(ctx, errServerCancel.Error(), agentendpointpb.ApplyConfigTaskOutput_CANCELLED)
 	}
-	msg = fmt.Sprintf("%s: %v", msg, err)
+	msg = errorMessage(errcode.Of(err, errcode.Internal), fmt.Sprintf("%s: %v", msg, err))
 	clog.Errorf(ctx, msg)
 	return c.reportCompletedState(ctx, msg, agentendpointpb.ApplyConfigTaskOutput_FAILED)
 }
@@ -115,7 +178,7 @@ func (c *configTask) reportContinuingState(ctx context.Context, configState agen
 	}
 	res, err := c.client.reportTaskProgress(ctx, req)
 	if err != nil {
-		return fmt.Errorf("error reporting task progress %s: %v", configState, err)
+		return fmt.Errorf("error reporting task progress %s: %w", configState, err)
 	}
 	if res.GetTaskDirective() == agentendpointpb.TaskDirective_STOP {
 		return errServerCancel
@@ -127,12 +190,93 @@ func (c *configTask) reportContinuingState(ctx context.Context, configState agen
 	return nil
 }
 
-// detectPolicyConflicts checks for managed resource conflicts between a proposed
-// OSPolicyResource and all other OSPolcyResources up to this point, adding to the
-// current set of ManagedResources.
-func detectPolicyConflicts(proposed, current *config.ManagedResources) error {
-	// TODO: implement
-	return nil
+// resourceKey identifies a resource of a config task, policy IDs are only
+// unique within their assignment.
+type resourceKey struct {
+	assignment, policy, resource string
+}
+
+// claim is the desired state of a file or package set by a resource.
+type claim struct {
+	resourceKey
+	// what is the file or package, like file "/etc/motd".
+	what   string
+	absent bool
+	// algorithm and checksum are the desired contents of a file, checksum is
+	// empty for any contents.
+	algorithm, checksum string
+}
+
+// compatible reports whether both claims can be enforced without undoing
+// each other. Contents with different checksum algorithms can't be compared
+// and are left to the resources.
+func (c *claim) compatible(o *claim) bool {
+	if c.absent || o.absent {
+		return c.absent == o.absent
+	}
+	return c.checksum == "" || o.checksum == "" || c.algorithm != o.algorithm || c.checksum == o.checksum
+}
+
+// managedClaims returns the claims of the files and packages in mr, keyed by
+// what they manage. Packages installed from a file are left out, their name
+// is only known once installed.
+func managedClaims(key resourceKey, mr *config.ManagedResources) map[string]*claim {
+	claims := map[string]*claim{}
+	if mr == nil {
+		return claims
+	}
+	for _, f := range mr.Files {
+		claims["file:"+f.Path] = &claim{
+			resourceKey: key,
+			what:        fmt.Sprintf("file %q", f.Path),
+			absent:      f.State == agentendpointpb.OSPolicy_Resource_FileResource_ABSENT,
+			algorithm:   f.ChecksumAlgorithm,
+			checksum:    f.Checksum(),
+		}
+	}
+	for _, p := range mr.Packages {
+		var manager, name string
+		var state agentendpointpb.OSPolicy_Resource_PackageResource_DesiredState
+		switch {
+		case p.Apt != nil:
+			manager, name, state = "apt", p.Apt.PackageResource.GetName(), p.Apt.DesiredState
+		case p.Yum != nil:
+			manager, name, state = "yum", p.Yum.PackageResource.GetName(), p.Yum.DesiredState
+		case p.Zypper != nil:
+			manager, name, state = "zypper", p.Zypper.PackageResource.GetName(), p.Zypper.DesiredState
+		case p.GooGet != nil:
+			manager, name, state = "googet", p.GooGet.PackageResource.GetName(), p.GooGet.DesiredState
+		default:
+			continue
+		}
+		claims[manager+":"+name] = &claim{
+			resourceKey: key,
+			what:        fmt.Sprintf("%s package %q", manager, name),
+			absent:      state == agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED,
+		}
+	}
+	return claims
+}
+
+// detectPolicyConflicts checks the files and packages managed by a proposed
+// resource, key, against current, those of the resources of enforced
+// policies validated before it in the task, of any policy and assignment.
+// Enforcing conflicting resources would undo each other every run. It
+// returns the claim proposed conflicts with, otherwise the claims of
+// proposed are added to current.
+func detectPolicyConflicts(key resourceKey, proposed *config.ManagedResources, current map[string]*claim) (*claim, error) {
+	claims := managedClaims(key, proposed)
+	for k, c := range claims {
+		if o, ok := current[k]; ok && !c.compatible(o) {
+			return o, fmt.Errorf("resource %q conflicts with resource %q of policy %q in assignment %q over %s", key.resource, o.resource, o.policy, o.assignment, c.what)
+		}
+	}
+	for k, c := range claims {
+		if _, ok := current[k]; !ok {
+			current[k] = c
+		}
+	}
+	return nil, nil
 }
 
 func truncateMessage(msg string, size int) string {
@@ -143,7 +287,22 @@ func truncateMessage(msg string, size int) string {
 	return msg
 }
 
-func validateConfigResource(ctx context.Context, res *resource, policyMR *config.ManagedResources, rCompliance *agentendpointpb.OSPolicyResourceCompliance, configResource *agentendpointpb.OSPolicy_Resource) (hasError bool) {
+// resourceErrorMessage formats the error message of a resource step with the
+// error code of err, which falls back on the class of the resource type.
+func resourceErrorMessage(configResource *agentendpointpb.OSPolicy_Resource, err error, format string, a ...interface{}) string {
+	fallback := errcode.Internal
+	switch configResource.GetResourceType().(type) {
+	case *agentendpointpb.OSPolicy_Resource_Pkg, *agentendpointpb.OSPolicy_Resource_Repository:
+		fallback = errcode.PackageManager
+	case *agentendpointpb.OSPolicy_Resource_Exec:
+		fallback = errcode.Script
+	}
+	return truncateMessage(errorMessage(errcode.Of(err, fallback), fmt.Sprintf(format, a...)), maxErrorMessage)
+}
+
+// validateConfigResource validates res, and when conflicts is set checks
+// what it manages does not conflict with other resources with it.
+func validateConfigResource(ctx context.Context, res *resource, conflicts func(*config.ManagedResources) error, rCompliance *agentendpointpb.OSPolicyResourceCompliance, configResource *agentendpointpb.OSPolicy_Resource) (hasError bool) {
 	ctx = clog.WithLabels(ctx, map[string]string{"resource_id": configResource.GetId()})
 	clog.Debugf(ctx, "Running step 'validate' on resource %q.", configResource.GetId())
 
@@ -152,14 +311,18 @@ func validateConfigResource(ctx context.Context, res *resource, policyMR *config
 	if err := res.Validate(ctx); err != nil {
 		outcome = agentendpointpb.OSPolicyResourceConfigStep_FAILED
 		hasError = true
-		errMessage = truncateMessage(fmt.Sprintf("Validate: resource %q error: %v", configResource.GetId(), err), maxErrorMessage)
+		errMessage = resourceErrorMessage(configResource, err, "Validate: resource %q error: %v", configResource.GetId(), err)
 		clog.Errorf(ctx, errMessage)
 	} else {
-		// Detect any resource conflicts within this policy.
-		if err := detectPolicyConflicts(res.ManagedResources(), policyMR); err != nil {
+		// Detect any resource conflicts with the resources before this one.
+		var err error
+		if conflicts != nil {
+			err = conflicts(res.ManagedResources())
+		}
+		if err != nil {
 			outcome = agentendpointpb.OSPolicyResourceConfigStep_FAILED
 			hasError = true
-			errMessage = truncateMessage(fmt.Sprintf("Validate: resource conflict in policy: %v", err), maxErrorMessage)
+			errMessage = truncateMessage(errorMessage(errcode.Conflict, fmt.Sprintf("Validate: %v, not running it", err)), maxErrorMessage)
 			clog.Errorf(ctx, errMessage)
 		} else {
 			clog.Infof(ctx, "Validate: resource %q validation successful.", configResource.GetId())
@@ -175,6 +338,53 @@ func validateConfigResource(ctx context.Context, res *resource, policyMR *config
 	return hasError
 }
 
+// conflicts returns the conflict check of the resource key of an enforced
+// policy for validateConfigResource. The resource it conflicts with, which
+// already ran, is reported as conflicting too and is not post checked, so
+// the conflict shows on both until it is resolved. Only the first resource
+// is enforced, they no longer undo each other every run.
+func (c *configTask) conflicts(ctx context.Context, key resourceKey) func(*config.ManagedResources) error {
+	return func(proposed *config.ManagedResources) error {
+		if c.claims == nil {
+			c.claims = map[string]*claim{}
+		}
+		o, err := detectPolicyConflicts(key, proposed, c.claims)
+		if o != nil {
+			c.reportConflict(ctx, o, fmt.Sprintf("resource %q conflicts with resource %q of policy %q in assignment %q over %s", o.resource, key.resource, key.policy, key.assignment, o.what))
+		}
+		return err
+	}
+}
+
+// reportConflict adds a failed conflict validation step to the resource of
+// the claim, which already ran.
+func (c *configTask) reportConflict(ctx context.Context, o *claim, conflict string) {
+	for i, osPolicy := range c.Task.GetOsPolicies() {
+		if osPolicy.GetOsPolicyAssignment() != o.assignment || osPolicy.GetId() != o.policy {
+			continue
+		}
+		for j, configResource := range osPolicy.GetResources() {
+			if configResource.GetId() != o.resource {
+				continue
+			}
+			if res := c.policies[o.policy].resources[o.resource]; res != nil {
+				res.validateOrCheckError, res.needsPostCheck = true, false
+			}
+			ctx := clog.WithLabels(ctx, map[string]string{"os_policy_assignment": o.assignment, "os_policy_id": o.policy, "resource_id": o.resource})
+			errMessage := truncateMessage(errorMessage(errcode.Conflict, fmt.Sprintf("Validate: %s", conflict)), maxErrorMessage)
+			clog.Errorf(ctx, errMessage)
+			rCompliance := c.results[i].GetOsPolicyResourceCompliances()[j]
+			rCompliance.ConfigSteps = append(rCompliance.GetConfigSteps(), &agentendpointpb.OSPolicyResourceConfigStep{
+				Type:         agentendpointpb.OSPolicyResourceConfigStep_VALIDATION,
+				Outcome:      agentendpointpb.OSPolicyResourceConfigStep_FAILED,
+				ErrorMessage: errMessage,
+			})
+			rCompliance.State = agentendpointpb.OSPolicyComplianceState_UNKNOWN
+			return
+		}
+	}
+}
+
 func checkConfigResourceState(ctx context.Context, res *resource, rCompliance *agentendpointpb.OSPolicyResourceCompliance, configResource *agentendpointpb.OSPolicy_Resource) (hasError bool) {
 	ctx = clog.WithLabels(ctx, map[string]string{"resource_id": configResource.GetId()})
 	clog.Debugf(ctx, "Running step 'check state' on resource %q.", configResource.GetId())
@@ -186,7 +396,7 @@ func checkConfigResourceState(ctx context.Context, res *resource, rCompliance *a
 	if err != nil {
 		outcome = agentendpointpb.OSPolicyResourceConfigStep_FAILED
 		hasError = true
-		errMessage = truncateMessage(fmt.Sprintf("Check state: resource %q error: %v", configResource.GetId(), err), maxErrorMessage)
+		errMessage = resourceErrorMessage(configResource, err, "Check state: resource %q error: %v", configResource.GetId(), err)
 		clog.Errorf(ctx, errMessage)
 	} else if res.InDesiredState() {
 		state = agentendpointpb.OSPolicyComplianceState_COMPLIANT
@@ -219,7 +429,7 @@ func enforceConfigResourceState(ctx context.Context, res *resource, rCompliance
 	if err != nil {
 		outcome = agentendpointpb.OSPolicyResourceConfigStep_FAILED
 		hasError = true
-		errMessage = truncateMessage(fmt.Sprintf("Enforce state: resource %q error: %v", configResource.GetId(), err), maxErrorMessage)
+		errMessage = resourceErrorMessage(configResource, err, "Enforce state: resource %q error: %v", configResource.GetId(), err)
 		clog.Errorf(ctx, errMessage)
 	} else {
 		clog.Infof(ctx, "Enforce state: resource %q enforcement successful.", configResource.GetId())
@@ -231,7 +441,12 @@ func enforceConfigResourceState(ctx context.Context, res *resource, rCompliance
 		ErrorMessage: errMessage,
 	})
 	// Resource is always in an unknown state after enforcement is run.
-	// A COMPLIANT state will only happen after a post check.
+	// A COMPLIANT state will only happen after a post check. Nothing is
+	// changed on a read-only file system, the resource stays as checked.
+	if errors.Is(err, config.ErrReadOnlyFS) {
+		rCompliance.State = agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT
+		return false, hasError
+	}
 	rCompliance.State = agentendpointpb.OSPolicyComplianceState_UNKNOWN
 	return true, hasError
 }
@@ -250,7 +465,7 @@ func postCheckConfigResourceState(ctx context.Context, res *resource, rComplianc
 	err := res.CheckState(ctx)
 	if err != nil {
 		outcome = agentendpointpb.OSPolicyResourceConfigStep_FAILED
-		errMessage = truncateMessage(fmt.Sprintf("Check state post enforcement: resource %q error: %v", configResource.GetId(), err), maxErrorMessage)
+		errMessage = resourceErrorMessage(configResource, err, "Check state post enforcement: resource %q error: %v", configResource.GetId(), err)
 		clog.Errorf(ctx, errMessage)
 	} else if res.InDesiredState() {
 		state = agentendpointpb.OSPolicyComplianceState_COMPLIANT
@@ -267,6 +482,39 @@ func postCheckConfigResourceState(ctx context.Context, res *resource, rComplianc
 	rCompliance.State = state
 }
 
+// withTimeBudget returns a context that is done when budget, if set, has
+// passed, with cause as the reason reported by budgetExceeded.
+func withTimeBudget(ctx context.Context, budget time.Duration, cause error) (context.Context, context.CancelFunc) {
+	if budget <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, budget, cause)
+}
+
+// budgetExceeded reports whether the time budget of a resource or its policy
+// ran out, ctx being the context of the resource. The last step of the
+// resource, which was cut short or finished past the budget, is then
+// reported as failed, or a failed validation step if no step was run.
+func budgetExceeded(ctx context.Context, rCompliance *agentendpointpb.OSPolicyResourceCompliance, configResource *agentendpointpb.OSPolicy_Resource) bool {
+	if ctx.Err() != context.DeadlineExceeded {
+		return false
+	}
+	ctx = clog.WithLabels(ctx, map[string]string{"resource_id": configResource.GetId()})
+	errMessage := truncateMessage(errorMessage(errcode.BudgetExceeded, fmt.Sprintf("%v, aborting the remaining steps of the policy", context.Cause(ctx))), maxErrorMessage)
+	clog.Errorf(ctx, errMessage)
+	steps := rCompliance.GetConfigSteps()
+	if len(steps) == 0 {
+		rCompliance.ConfigSteps = append(steps, &agentendpointpb.OSPolicyResourceConfigStep{
+			Type: agentendpointpb.OSPolicyResourceConfigStep_VALIDATION,
+		})
+		steps = rCompliance.GetConfigSteps()
+	}
+	steps[len(steps)-1].Outcome = agentendpointpb.OSPolicyResourceConfigStep_FAILED
+	steps[len(steps)-1].ErrorMessage = errMessage
+	rCompliance.State = agentendpointpb.OSPolicyComplianceState_UNKNOWN
+	return true
+}
+
 func (c *configTask) postCheckState(ctx context.Context) {
 	// Actually run post check state (for policies that do not have a previous error).
 	// No prepopulate run for post check as we will always check every resource.
@@ -280,6 +528,10 @@ func (c *configTask) postCheckState(ctx context.Context) {
 			continue
 		}
 		pResult := c.results[i]
+		if plcy.budgetExceeded {
+			// The post checks are not run past the budget.
+			continue
+		}
 		for i, configResource := range osPolicy.GetResources() {
 			res, ok := plcy.resources[configResource.GetId()]
 			// This should only happen if there was a previous resource with a validate or check state error.
@@ -290,10 +542,96 @@ func (c *configTask) postCheckState(ctx context.Context) {
 			postCheckConfigResourceState(ctx, res, rCompliance, configResource)
 			clog.Infof(ctx, "Policy %q resource %q state: %s", osPolicy.GetId(), configResource.GetId(), rCompliance.GetState())
 		}
+		rollbackPolicy(ctx, osPolicy, plcy, pResult, validatePolicy(ctx, c.validations[osPolicy.GetId()], plcy, pResult))
 	}
 	return
 }
 
+// runPolicyValidation is overridden in tests.
+var runPolicyValidation = config.RunPolicyValidation
+
+// validatePolicy runs the validation of a policy, if it has one, when its
+// resources were enforced in this run and are all compliant. A failed
+// validation is reported on every resource of the policy, which are all made
+// non-compliant, and the returned reason triggers the rollback of the policy.
+func validatePolicy(ctx context.Context, validation *agentendpointpb.OSPolicy_Resource_ExecResource_Exec, plcy *policy, pResult *agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult) string {
+	if validation == nil {
+		return ""
+	}
+	var enforced bool
+	for _, res := range plcy.resources {
+		enforced = enforced || (res != nil && res.enforced)
+	}
+	if !enforced {
+		return ""
+	}
+	for _, rCompliance := range pResult.GetOsPolicyResourceCompliances() {
+		if rCompliance.GetState() != agentendpointpb.OSPolicyComplianceState_COMPLIANT {
+			return ""
+		}
+	}
+
+	err := runPolicyValidation(ctx, validation)
+	if err == nil {
+		clog.Infof(ctx, "Policy %q validation succeeded.", pResult.GetOsPolicyId())
+		return ""
+	}
+	errMessage := truncateMessage(errorMessage(errcode.Of(err, errcode.Script), fmt.Sprintf("Policy validation: error: %v", err)), maxErrorMessage)
+	clog.Errorf(ctx, errMessage)
+	for _, rCompliance := range pResult.GetOsPolicyResourceCompliances() {
+		rCompliance.ConfigSteps = append(rCompliance.GetConfigSteps(), &agentendpointpb.OSPolicyResourceConfigStep{
+			Type:         agentendpointpb.OSPolicyResourceConfigStep_DESIRED_STATE_CHECK_POST_ENFORCEMENT,
+			Outcome:      agentendpointpb.OSPolicyResourceConfigStep_FAILED,
+			ErrorMessage: errMessage,
+		})
+		rCompliance.State = agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT
+	}
+	return "the policy validation failed"
+}
+
+// rollbackPolicy restores the resources of a policy that were enforced in
+// this run and support rollback, like files backed up when rollback is
+// enabled, when an exec resource after them that was also enforced failed or
+// is not compliant, for example a service that does not restart with a new
+// config file, or all of them when failed, the reason, is set. Failures of
+// other resource types do not depend on the earlier ones and are not
+// rolled back for. Resources are rolled back last to first and the rollback
+// is reported as a failed enforcement step.
+func rollbackPolicy(ctx context.Context, osPolicy *agentendpointpb.ApplyConfigTask_OSPolicy, plcy *policy, pResult *agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult, failed string) {
+	resources := osPolicy.GetResources()
+	for i := len(resources) - 1; i >= 0; i-- {
+		configResource := resources[i]
+		res, ok := plcy.resources[configResource.GetId()]
+		if !ok || res == nil || !res.enforced {
+			continue
+		}
+		rCompliance := pResult.GetOsPolicyResourceCompliances()[i]
+		if rb, ok := res.resourceIface.(rollbacker); ok && failed != "" {
+			ctx := clog.WithLabels(ctx, map[string]string{"resource_id": configResource.GetId()})
+			restored, err := rb.Rollback(ctx)
+			var errMessage string
+			switch {
+			case err != nil:
+				errMessage = truncateMessage(errorMessage(errcode.Of(err, errcode.Internal), fmt.Sprintf("Rollback: resource %q error restoring its previous state after %s: %v", configResource.GetId(), failed, err)), maxErrorMessage)
+			case restored:
+				errMessage = fmt.Sprintf("Rollback: resource %q was restored to its previous state because %s.", configResource.GetId(), failed)
+			}
+			if errMessage != "" {
+				clog.Errorf(ctx, errMessage)
+				rCompliance.ConfigSteps = append(rCompliance.GetConfigSteps(), &agentendpointpb.OSPolicyResourceConfigStep{
+					Type:         agentendpointpb.OSPolicyResourceConfigStep_DESIRED_STATE_ENFORCEMENT,
+					Outcome:      agentendpointpb.OSPolicyResourceConfigStep_FAILED,
+					ErrorMessage: errMessage,
+				})
+				rCompliance.State = agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT
+			}
+		}
+		if failed == "" && configResource.GetExec() != nil && (res.enforceError || rCompliance.GetState() != agentendpointpb.OSPolicyComplianceState_COMPLIANT) {
+			failed = fmt.Sprintf("resource %q failed", configResource.GetId())
+		}
+	}
+}
+
 func (c *configTask) generateBaseResults() {
 	c.results = make([]*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult, len(c.Task.GetOsPolicies()))
 	for i, p := range c.Task.GetOsPolicies() {
@@ -377,6 +715,7 @@ func (c *configTask) run(ctx context.Context) error {
 
 	if len(c.Task.GetOsPolicies()) == 0 {
 		clog.Infof(ctx, "No OSPolicies to apply.")
+		c.recordAssignments(ctx)
 		return c.reportCompletedState(ctx, "", agentendpointpb.ApplyConfigTaskOutput_SUCCEEDED)
 	}
 
@@ -389,9 +728,111 @@ func (c *configTask) run(ctx context.Context) error {
 		return c.handleErrorState(ctx, rcsErrMsg, err)
 	}
 
+	c.imageBuildResources = consumeImageBuildMarker(ctx)
+	c.spread = true
+	stopProfile := startPolicyProfile(ctx)
+	c.applyPolicies(ctx)
+	stopProfile()
+	c.recordAssignments(ctx)
+	if agentconfig.OSInventoryEnabled() && c.enforcedAny() && packagesChangedSince(c.StartedAt) {
+		EnqueueInventoryReport(ctx)
+	}
+
+	if err := c.reportCompletedState(ctx, "", agentendpointpb.ApplyConfigTaskOutput_SUCCEEDED); err != nil {
+		return err
+	}
+	clog.Infof(ctx, "Successfully completed ApplyConfigTask")
+	return nil
+}
+
+// loadFirstSeen sets when each assignment revision of the task was first
+// seen, from the assignments recorded by the last config task. Revisions
+// not recorded are first seen by this task.
+func (c *configTask) loadFirstSeen(ctx context.Context) {
+	prev, err := LoadAssignments()
+	if err != nil {
+		clog.Warningf(ctx, "Error loading recorded OS policy assignments, delaying all of them: %v", err)
+	}
+	recorded := map[string]time.Time{}
+	for _, a := range prev {
+		if !a.FirstSeenAt.IsZero() {
+			recorded[a.Name+"@"+a.Revision] = a.FirstSeenAt
+		}
+	}
+	c.firstSeen = map[string]time.Time{}
+	for _, osPolicy := range c.Task.GetOsPolicies() {
+		name, revision := splitAssignment(osPolicy.GetOsPolicyAssignment())
+		t, ok := recorded[name+"@"+revision]
+		if !ok {
+			t = c.StartedAt
+		}
+		c.firstSeen[osPolicy.GetOsPolicyAssignment()] = t
+	}
+}
+
+// deferAssignment reports whether applying the policies of an assignment is
+// deferred to a later config task as its start delay, see
+// agentconfig.AssignmentStartDelay, has not passed since the revision was
+// first seen. The task is not held up waiting, the delays of assignments
+// don't add up and other tasks are not blocked.
+func (c *configTask) deferAssignment(assignment string) bool {
+	return time.Now().Before(c.firstSeen[assignment].Add(assignmentStartDelay(assignment)))
+}
+
+// reportPaused reports the resources of a policy whose assignment is paused
+// on the instance as not applied, UNKNOWN rather than NON_COMPLIANT, with a
+// failed validation step giving the reason.
+func reportPaused(pResult *agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult, assignment string) {
+	errMessage := errorMessage(errcode.Paused, fmt.Sprintf("not applied, OS policy assignment %q is paused on this instance by osconfig-pause-assignments", assignment))
+	for _, rCompliance := range pResult.GetOsPolicyResourceCompliances() {
+		rCompliance.ConfigSteps = append(rCompliance.GetConfigSteps(), &agentendpointpb.OSPolicyResourceConfigStep{
+			Type:         agentendpointpb.OSPolicyResourceConfigStep_VALIDATION,
+			Outcome:      agentendpointpb.OSPolicyResourceConfigStep_FAILED,
+			ErrorMessage: truncateMessage(errMessage, maxErrorMessage),
+		})
+		rCompliance.State = agentendpointpb.OSPolicyComplianceState_UNKNOWN
+	}
+}
+
+// applyPolicies runs validate, check and enforce for each policy resource
+// and the post checks, adding to the results.
+func (c *configTask) applyPolicies(ctx context.Context) {
 	c.policies = map[string]*policy{}
+	defer c.unlockAssignments(ctx)
+	if c.spread {
+		c.loadFirstSeen(ctx)
+	}
 	for i, osPolicy := range c.Task.GetOsPolicies() {
 		ctx := clog.WithLabels(ctx, map[string]string{"os_policy_assignment": osPolicy.GetOsPolicyAssignment(), "os_policy_id": osPolicy.GetId()})
+		if c.spread && assignmentPausedLocally(osPolicy.GetOsPolicyAssignment()) {
+			if !c.paused[osPolicy.GetOsPolicyAssignment()] {
+				clog.Infof(ctx, "OS policy assignment %q is paused on this instance by osconfig-pause-assignments, skipping it.", osPolicy.GetOsPolicyAssignment())
+			}
+			if c.paused == nil {
+				c.paused = map[string]bool{}
+			}
+			c.paused[osPolicy.GetOsPolicyAssignment()] = true
+			reportPaused(c.results[i], osPolicy.GetOsPolicyAssignment())
+			c.policies[osPolicy.GetId()] = &policy{resources: map[string]*resource{}}
+			continue
+		}
+		if c.spread && c.deferAssignment(osPolicy.GetOsPolicyAssignment()) {
+			if !c.deferred[osPolicy.GetOsPolicyAssignment()] {
+				clog.Infof(ctx, "Spreading the start of OS policy assignment %q, deferring it to a later run.", osPolicy.GetOsPolicyAssignment())
+			}
+			if c.deferred == nil {
+				c.deferred = map[string]bool{}
+			}
+			c.deferred[osPolicy.GetOsPolicyAssignment()] = true
+			// Leave the base results, the policy was not run this cycle.
+			c.policies[osPolicy.GetId()] = &policy{resources: map[string]*resource{}}
+			continue
+		}
+		if !c.lockAssignment(ctx, osPolicy.GetOsPolicyAssignment()) {
+			// Leave the base results, the policy was not run this cycle.
+			c.policies[osPolicy.GetId()] = &policy{resources: map[string]*resource{}}
+			continue
+		}
 		clog.Infof(ctx, "Executing policy %q", osPolicy.GetId())
 
 		pResult := c.results[i]
@@ -403,53 +844,120 @@ func (c *configTask) run(ctx context.Context) error {
 		if osPolicy.GetMode() == agentendpointpb.OSPolicy_VALIDATION {
 			clog.Infof(ctx, "Policy running in VALIDATION mode, not running enforcement action for any resources.")
 			validateOnly = true
+		} else if readOnly() {
+			clog.Infof(ctx, "Agent running in read-only mode, not running enforcement action for any resources.")
+			validateOnly = true
 		}
 
+		// The budgets only bound the policy and its resources.
+		policyCtx, cancelPolicy := withTimeBudget(ctx, policyTimeBudget(), fmt.Errorf("policy %q exceeded its time budget of %s", osPolicy.GetId(), policyTimeBudget()))
 		for i, configResource := range osPolicy.GetResources() {
 			rCompliance := pResult.GetOsPolicyResourceCompliances()[i]
-			plcy.resources[configResource.GetId()] = newResource(configResource)
+			plcy.resources[configResource.GetId()] = c.newResource(osPolicy, configResource)
 			res := plcy.resources[configResource.GetId()]
-			if hasError := validateConfigResource(ctx, res, policyMR, rCompliance, configResource); hasError {
-				res.validateOrCheckError = true
-				break
-			}
-			if hasError := checkConfigResourceState(ctx, res, rCompliance, configResource); hasError {
-				res.validateOrCheckError = true
-				break
-			}
-
-			// Skip enforcement actions in VALIDATION mode.
-			if validateOnly {
-				continue
-			}
-
-			// Only errors in validate and check state constitute a serious error,
-			// for enforce if any action is taken we still want to run post check.
-			// We do however stop further execution of this polcy on enforce error.
-			enforcementActionTaken, hasError := enforceConfigResourceState(ctx, res, rCompliance, configResource)
-			if enforcementActionTaken {
-				// On any change we trigger post check for all previous resouces,
-				// even if there was an error.
-				c.markPostCheckRequired()
-			}
-			// Still record output even if there was an error during enforcement.
-			res.PopulateOutput(rCompliance)
-			// Errors from enforcement are not classified as "serious" becasue we want post check to run for this resource.
-			if hasError {
+			resCtx, cancelResource := withTimeBudget(policyCtx, resourceTimeBudget(), fmt.Errorf("resource %q exceeded its time budget of %s", configResource.GetId(), resourceTimeBudget()))
+			stop := c.applyResource(resCtx, osPolicy, plcy, res, rCompliance, configResource, validateOnly)
+			cancelResource()
+			if stop {
 				break
 			}
 		}
+		cancelPolicy()
 		c.managedResources = append(c.managedResources, policyMR)
 	}
 
 	// Run any post checks that we need to.
 	c.postCheckState(ctx)
+}
 
-	if err := c.reportCompletedState(ctx, "", agentendpointpb.ApplyConfigTaskOutput_SUCCEEDED); err != nil {
-		return err
+// applyResource runs validate, check and enforce for a resource of plcy,
+// reporting whether the remaining resources of the policy are to be skipped.
+// Running out of the time budget in ctx aborts the policy.
+func (c *configTask) applyResource(ctx context.Context, osPolicy *agentendpointpb.ApplyConfigTask_OSPolicy, plcy *policy, res *resource, rCompliance *agentendpointpb.OSPolicyResourceCompliance, configResource *agentendpointpb.OSPolicy_Resource, validateOnly bool) (stop bool) {
+	// A policy may run out of its budget between two resources.
+	if budgetExceeded(ctx, rCompliance, configResource) {
+		res.validateOrCheckError = true
+		plcy.budgetExceeded = true
+		return true
 	}
-	clog.Infof(ctx, "Successfully completed ApplyConfigTask")
-	return nil
+	// Resources that are only validated are not enforced, they can't
+	// conflict.
+	var conflicts func(*config.ManagedResources) error
+	if !validateOnly {
+		conflicts = c.conflicts(ctx, resourceKey{assignment: osPolicy.GetOsPolicyAssignment(), policy: osPolicy.GetId(), resource: configResource.GetId()})
+	}
+	hasError := validateConfigResource(ctx, res, conflicts, rCompliance, configResource)
+	if budgetExceeded(ctx, rCompliance, configResource) {
+		res.validateOrCheckError = true
+		plcy.budgetExceeded = true
+		return true
+	}
+	if hasError {
+		res.validateOrCheckError = true
+		return true
+	}
+	if c.skipImageBuildResource(ctx, rCompliance, configResource) {
+		return false
+	}
+	hasError = checkConfigResourceState(ctx, res, rCompliance, configResource)
+	if budgetExceeded(ctx, rCompliance, configResource) {
+		res.validateOrCheckError = true
+		plcy.budgetExceeded = true
+		return true
+	}
+	if hasError {
+		res.validateOrCheckError = true
+		return true
+	}
+
+	// Skip enforcement actions in VALIDATION mode.
+	if validateOnly {
+		return false
+	}
+
+	// Only errors in validate and check state constitute a serious error,
+	// for enforce if any action is taken we still want to run post check.
+	// We do however stop further execution of this polcy on enforce error.
+	enforcementActionTaken, hasError := enforceConfigResourceState(ctx, res, rCompliance, configResource)
+	res.enforced, res.enforceError = enforcementActionTaken, hasError
+	if enforcementActionTaken {
+		// On any change we trigger post check for all previous resouces,
+		// even if there was an error.
+		c.markPostCheckRequired()
+	}
+	// Still record output even if there was an error during enforcement.
+	res.PopulateOutput(rCompliance)
+	if budgetExceeded(ctx, rCompliance, configResource) {
+		res.enforceError = true
+		plcy.budgetExceeded = true
+		return true
+	}
+	// Errors from enforcement are not classified as "serious" becasue we want post check to run for this resource.
+	return hasError
+}
+
+func (c *configTask) newResource(osPolicy *agentendpointpb.ApplyConfigTask_OSPolicy, r *agentendpointpb.OSPolicy_Resource) *resource {
+	key := localResourceKey(osPolicy.GetId(), r.GetId())
+	l, local := c.localResources[key]
+	exitCodes := c.execExitCodes[key]
+	fileChecksum := c.fileChecksums[key]
+	packageOptions := c.packageOptions[key]
+	if local || exitCodes != nil || fileChecksum != nil || packageOptions != nil {
+		return &resource{resourceIface: resourceIface(&config.OSPolicyResource{OSPolicy_Resource: r, Local: l, ExecExitCodes: exitCodes, FileChecksum: fileChecksum, PackageOptions: packageOptions})}
+	}
+	return newResource(r)
+}
+
+// enforcedAny reports whether enforcement acted on any resource.
+func (c *configTask) enforcedAny() bool {
+	for _, plcy := range c.policies {
+		for _, res := range plcy.resources {
+			if res != nil && res.enforced {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // Mark all resources that have already completed as "needs post check".
@@ -481,5 +989,8 @@ func (c *Client) RunApplyConfig(ctx context.Context, task *agentendpointpb.Task)
 		Task:   &applyConfigTask{task.GetApplyConfigTask()},
 	}
 
-	return e.run(ctx)
+	start := time.Now()
+	err := e.run(ctx)
+	agentstatus.RecordPolicyApplication(start, err)
+	return err
 }
@@ -21,9 +21,12 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/config"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -241,6 +244,10 @@ func genTestPolicyResult(id string, steps int, inDesiredState bool) *agentendpoi
 func TestRunApplyConfig(t *testing.T) {
 	ctx := context.Background()
 	sameStateTimeWindow = 0
+	defer func(f func() string) { imageBuildMarkerFile = f }(imageBuildMarkerFile)
+	imageBuildMarkerFile = func() string { return filepath.Join(t.TempDir(), "marker.json") }
+	defer func(f func() string) { assignmentsFile = f }(assignmentsFile)
+	assignmentsFile = func() string { return filepath.Join(t.TempDir(), "assignments.json") }
 	res := &testResource{}
 	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
 		return &resource{resourceIface: resourceIface(res)}
@@ -422,6 +429,64 @@ func TestRunApplyConfig(t *testing.T) {
 	}
 }
 
+func TestRunApplyConfigReadOnly(t *testing.T) {
+	ctx := context.Background()
+	sameStateTimeWindow = 0
+	defer func(f func() string) { imageBuildMarkerFile = f }(imageBuildMarkerFile)
+	imageBuildMarkerFile = func() string { return filepath.Join(t.TempDir(), "marker.json") }
+	defer func(f func() string) { assignmentsFile = f }(assignmentsFile)
+	assignmentsFile = func() string { return filepath.Join(t.TempDir(), "assignments.json") }
+	defer func(f func() bool) { readOnly = f }(readOnly)
+	readOnly = func() bool { return true }
+	res := &testResource{steps: 5}
+	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+		return &resource{resourceIface: resourceIface(res)}
+	}
+
+	srv := &agentEndpointServiceConfigTestServer{
+		progressError:  make(chan struct{}, 5),
+		progressCancel: make(chan struct{}, 5),
+	}
+	tc, err := newTestClient(ctx, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.close()
+
+	// An ENFORCEMENT policy is only validated and checked.
+	task := &agentendpointpb.ApplyConfigTask{OsPolicies: []*agentendpointpb.ApplyConfigTask_OSPolicy{genTestPolicy("p1")}}
+	if err := tc.client.RunApplyConfig(ctx, &agentendpointpb.Task{TaskDetails: &agentendpointpb.Task_ApplyConfigTask{ApplyConfigTask: task}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := configOutputGen("", agentendpointpb.ApplyConfigTaskOutput_SUCCEEDED,
+		[]*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{
+			{
+				OsPolicyId: "p1",
+				OsPolicyResourceCompliances: []*agentendpointpb.OSPolicyResourceCompliance{
+					{
+						State:              agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT,
+						OsPolicyResourceId: "r1",
+						ConfigSteps: []*agentendpointpb.OSPolicyResourceConfigStep{
+							{
+								Type:    agentendpointpb.OSPolicyResourceConfigStep_VALIDATION,
+								Outcome: agentendpointpb.OSPolicyResourceConfigStep_SUCCEEDED,
+							},
+							{
+								Type:    agentendpointpb.OSPolicyResourceConfigStep_DESIRED_STATE_CHECK,
+								Outcome: agentendpointpb.OSPolicyResourceConfigStep_SUCCEEDED,
+							},
+						},
+					},
+				},
+			},
+		},
+	)
+	if diff := cmp.Diff(want, srv.lastReportTaskCompleteRequest, protocmp.Transform()); diff != "" {
+		t.Fatalf("ReportTaskCompleteRequest mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestCleanupRepos(t *testing.T) {
 	ctx := context.Background()
 	tmpDir, err := ioutil.TempDir("", "")
@@ -473,3 +538,543 @@ func TestTruncateMessage(t *testing.T) {
 		})
 	}
 }
+
+type rollbackTestResource struct {
+	testResource
+	rolledBack bool
+}
+
+func (r *rollbackTestResource) Rollback(ctx context.Context) (bool, error) {
+	r.rolledBack = true
+	return true, nil
+}
+
+func TestRollbackPolicy(t *testing.T) {
+	ctx := context.Background()
+	compliant := agentendpointpb.OSPolicyComplianceState_COMPLIANT
+	nonCompliant := agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT
+
+	tests := []struct {
+		name           string
+		failed         string
+		exec           []bool
+		enforced       []bool
+		enforceError   []bool
+		states         []agentendpointpb.OSPolicyComplianceState
+		wantRolledBack []bool
+		wantStates     []agentendpointpb.OSPolicyComplianceState
+	}{
+		{
+			"AllCompliant",
+			"",
+			[]bool{false, false, true},
+			[]bool{true, true, true},
+			[]bool{false, false, false},
+			[]agentendpointpb.OSPolicyComplianceState{compliant, compliant, compliant},
+			[]bool{false, false, false},
+			[]agentendpointpb.OSPolicyComplianceState{compliant, compliant, compliant},
+		},
+		{
+			"LastResourceEnforceError",
+			"",
+			[]bool{false, false, true},
+			[]bool{true, true, true},
+			[]bool{false, false, true},
+			[]agentendpointpb.OSPolicyComplianceState{compliant, compliant, nonCompliant},
+			[]bool{true, true, false},
+			[]agentendpointpb.OSPolicyComplianceState{nonCompliant, nonCompliant, nonCompliant},
+		},
+		{
+			"PostCheckNotCompliant",
+			"",
+			[]bool{false, true, false},
+			[]bool{true, true, true},
+			[]bool{false, false, false},
+			[]agentendpointpb.OSPolicyComplianceState{compliant, nonCompliant, compliant},
+			[]bool{true, false, false},
+			[]agentendpointpb.OSPolicyComplianceState{nonCompliant, nonCompliant, compliant},
+		},
+		{
+			"FailedResourceNotEnforced",
+			"",
+			[]bool{false, false, true},
+			[]bool{true, true, false},
+			[]bool{false, false, false},
+			[]agentendpointpb.OSPolicyComplianceState{compliant, compliant, nonCompliant},
+			[]bool{false, false, false},
+			[]agentendpointpb.OSPolicyComplianceState{compliant, compliant, nonCompliant},
+		},
+		{
+			"EarlierResourceNotEnforced",
+			"",
+			[]bool{false, false, true},
+			[]bool{false, true, true},
+			[]bool{false, false, true},
+			[]agentendpointpb.OSPolicyComplianceState{compliant, compliant, nonCompliant},
+			[]bool{false, true, false},
+			[]agentendpointpb.OSPolicyComplianceState{compliant, nonCompliant, nonCompliant},
+		},
+		{
+			"LaterResourceNotExec",
+			"",
+			[]bool{false, false, false},
+			[]bool{true, true, true},
+			[]bool{false, false, true},
+			[]agentendpointpb.OSPolicyComplianceState{compliant, compliant, nonCompliant},
+			[]bool{false, false, false},
+			[]agentendpointpb.OSPolicyComplianceState{compliant, compliant, nonCompliant},
+		},
+		{
+			"PolicyValidationFailed",
+			"the policy validation failed",
+			[]bool{false, false, false},
+			[]bool{true, false, true},
+			[]bool{false, false, false},
+			[]agentendpointpb.OSPolicyComplianceState{nonCompliant, nonCompliant, nonCompliant},
+			[]bool{true, false, true},
+			[]agentendpointpb.OSPolicyComplianceState{nonCompliant, nonCompliant, nonCompliant},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			osPolicy := &agentendpointpb.ApplyConfigTask_OSPolicy{Id: "p1"}
+			plcy := &policy{resources: map[string]*resource{}}
+			pResult := &agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{OsPolicyId: "p1"}
+			var fakes []*rollbackTestResource
+			for i := range tt.enforced {
+				id := fmt.Sprintf("r%d", i+1)
+				configResource := &agentendpointpb.OSPolicy_Resource{Id: id}
+				if tt.exec[i] {
+					configResource.ResourceType = &agentendpointpb.OSPolicy_Resource_Exec{Exec: &agentendpointpb.OSPolicy_Resource_ExecResource{}}
+				}
+				osPolicy.Resources = append(osPolicy.Resources, configResource)
+				fake := &rollbackTestResource{}
+				fakes = append(fakes, fake)
+				plcy.resources[id] = &resource{resourceIface: fake, enforced: tt.enforced[i], enforceError: tt.enforceError[i]}
+				pResult.OsPolicyResourceCompliances = append(pResult.OsPolicyResourceCompliances, &agentendpointpb.OSPolicyResourceCompliance{
+					OsPolicyResourceId: id,
+					State:              tt.states[i],
+				})
+			}
+
+			rollbackPolicy(ctx, osPolicy, plcy, pResult, tt.failed)
+
+			for i, fake := range fakes {
+				rCompliance := pResult.GetOsPolicyResourceCompliances()[i]
+				if fake.rolledBack != tt.wantRolledBack[i] {
+					t.Errorf("resource %d rolled back: got %t, want %t", i+1, fake.rolledBack, tt.wantRolledBack[i])
+				}
+				if rCompliance.GetState() != tt.wantStates[i] {
+					t.Errorf("resource %d state: got %s, want %s", i+1, rCompliance.GetState(), tt.wantStates[i])
+				}
+				if got := len(rCompliance.GetConfigSteps()) == 1; got != tt.wantRolledBack[i] {
+					t.Errorf("resource %d rollback step reported: got %t, want %t", i+1, got, tt.wantRolledBack[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func(context.Context, *agentendpointpb.OSPolicy_Resource_ExecResource_Exec) error) {
+		runPolicyValidation = f
+	}(runPolicyValidation)
+	validation := &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{
+		Source: &agentendpointpb.OSPolicy_Resource_ExecResource_Exec_Script{Script: "nginx -t"},
+	}
+	compliant := agentendpointpb.OSPolicyComplianceState_COMPLIANT
+	nonCompliant := agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT
+
+	tests := []struct {
+		name       string
+		validation *agentendpointpb.OSPolicy_Resource_ExecResource_Exec
+		enforced   bool
+		states     []agentendpointpb.OSPolicyComplianceState
+		err        error
+		wantRun    bool
+		wantStates []agentendpointpb.OSPolicyComplianceState
+	}{
+		{"NoValidation", nil, true, []agentendpointpb.OSPolicyComplianceState{compliant, compliant}, errTest, false, []agentendpointpb.OSPolicyComplianceState{compliant, compliant}},
+		{"NothingEnforced", validation, false, []agentendpointpb.OSPolicyComplianceState{compliant, compliant}, errTest, false, []agentendpointpb.OSPolicyComplianceState{compliant, compliant}},
+		{"AlreadyNonCompliant", validation, true, []agentendpointpb.OSPolicyComplianceState{compliant, nonCompliant}, errTest, false, []agentendpointpb.OSPolicyComplianceState{compliant, nonCompliant}},
+		{"Succeeded", validation, true, []agentendpointpb.OSPolicyComplianceState{compliant, compliant}, nil, true, []agentendpointpb.OSPolicyComplianceState{compliant, compliant}},
+		{"Failed", validation, true, []agentendpointpb.OSPolicyComplianceState{compliant, compliant}, errTest, true, []agentendpointpb.OSPolicyComplianceState{nonCompliant, nonCompliant}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran bool
+			runPolicyValidation = func(ctx context.Context, execR *agentendpointpb.OSPolicy_Resource_ExecResource_Exec) error {
+				ran = true
+				return tt.err
+			}
+			plcy := &policy{resources: map[string]*resource{
+				"r1": {resourceIface: &testResource{}, enforced: tt.enforced},
+				"r2": {resourceIface: &testResource{}},
+			}}
+			pResult := &agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{OsPolicyId: "p1"}
+			for i, state := range tt.states {
+				pResult.OsPolicyResourceCompliances = append(pResult.OsPolicyResourceCompliances, &agentendpointpb.OSPolicyResourceCompliance{
+					OsPolicyResourceId: fmt.Sprintf("r%d", i+1),
+					State:              state,
+				})
+			}
+
+			failed := validatePolicy(ctx, tt.validation, plcy, pResult)
+			if ran != tt.wantRun {
+				t.Errorf("validation ran: got %t, want %t", ran, tt.wantRun)
+			}
+			wantFailed := tt.wantRun && tt.err != nil
+			if (failed != "") != wantFailed {
+				t.Errorf("validatePolicy: got %q, want failure %t", failed, wantFailed)
+			}
+			for i, rCompliance := range pResult.GetOsPolicyResourceCompliances() {
+				if rCompliance.GetState() != tt.wantStates[i] {
+					t.Errorf("resource %d state: got %s, want %s", i+1, rCompliance.GetState(), tt.wantStates[i])
+				}
+				if got := len(rCompliance.GetConfigSteps()) == 1; got != wantFailed {
+					t.Errorf("resource %d validation step reported: got %t, want %t", i+1, got, wantFailed)
+				}
+			}
+		})
+	}
+}
+
+func TestResourceErrorMessage(t *testing.T) {
+	defer func(f func() bool) { errorCodes = f }(errorCodes)
+	errorCodes = func() bool { return true }
+
+	tests := []struct {
+		name     string
+		resource *agentendpointpb.OSPolicy_Resource
+		err      error
+		want     string
+	}{
+		{"Pkg", &agentendpointpb.OSPolicy_Resource{Id: "r1", ResourceType: &agentendpointpb.OSPolicy_Resource_Pkg{}}, errTest, `[PACKAGE_MANAGER] Enforce state: resource "r1" error: this is a test error`},
+		{"Repository", &agentendpointpb.OSPolicy_Resource{Id: "r1", ResourceType: &agentendpointpb.OSPolicy_Resource_Repository{}}, errTest, `[PACKAGE_MANAGER] Enforce state: resource "r1" error: this is a test error`},
+		{"Exec", &agentendpointpb.OSPolicy_Resource{Id: "r1", ResourceType: &agentendpointpb.OSPolicy_Resource_Exec{}}, errTest, `[SCRIPT] Enforce state: resource "r1" error: this is a test error`},
+		{"File", &agentendpointpb.OSPolicy_Resource{Id: "r1", ResourceType: &agentendpointpb.OSPolicy_Resource_File_{}}, errTest, `[INTERNAL] Enforce state: resource "r1" error: this is a test error`},
+		{"ClassifiedError", &agentendpointpb.OSPolicy_Resource{Id: "r1", ResourceType: &agentendpointpb.OSPolicy_Resource_File_{}}, errcode.Wrap(errcode.Network, errTest), `[NETWORK] Enforce state: resource "r1" error: this is a test error`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resourceErrorMessage(tt.resource, tt.err, "Enforce state: resource %q error: %v", tt.resource.GetId(), tt.err)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPoliciesDefersAssignment(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func(*agentendpointpb.OSPolicy_Resource) *resource) { newResource = f }(newResource)
+	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+		return &resource{resourceIface: resourceIface(&testResource{steps: 5})}
+	}
+	defer func(f func() string) { assignmentsFile = f }(assignmentsFile)
+	path := filepath.Join(t.TempDir(), "assignments.json")
+	assignmentsFile = func() string { return path }
+	defer func(f func(string) time.Duration) { assignmentStartDelay = f }(assignmentStartDelay)
+	assignmentStartDelay = func(assignment string) time.Duration {
+		if strings.Contains(assignment, "/none@") {
+			return 0
+		}
+		return 30 * time.Minute
+	}
+
+	// a@rev1 was first seen an hour ago, b only in an older revision.
+	hourAgo := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	prev := &configTask{Task: &applyConfigTask{&agentendpointpb.ApplyConfigTask{OsPolicies: []*agentendpointpb.ApplyConfigTask_OSPolicy{
+		{Id: "p1", OsPolicyAssignment: "projects/p/locations/z/osPolicyAssignments/a@rev1"},
+		{Id: "p2", OsPolicyAssignment: "projects/p/locations/z/osPolicyAssignments/b@rev1"},
+	}}}, firstSeen: map[string]time.Time{
+		"projects/p/locations/z/osPolicyAssignments/a@rev1": hourAgo,
+		"projects/p/locations/z/osPolicyAssignments/b@rev1": hourAgo,
+	}}
+	prev.recordAssignments(ctx)
+
+	policies := []*agentendpointpb.ApplyConfigTask_OSPolicy{genTestPolicy("p1"), genTestPolicy("p2"), genTestPolicy("p3")}
+	policies[0].OsPolicyAssignment = "projects/p/locations/z/osPolicyAssignments/a@rev1"
+	policies[1].OsPolicyAssignment = "projects/p/locations/z/osPolicyAssignments/b@rev2"
+	policies[2].OsPolicyAssignment = "projects/p/locations/z/osPolicyAssignments/none@rev1"
+	start := time.Now()
+	c := &configTask{Task: &applyConfigTask{&agentendpointpb.ApplyConfigTask{OsPolicies: policies}}, StartedAt: start, spread: true}
+	c.generateBaseResults()
+	c.applyPolicies(ctx)
+	c.cleanup(ctx)
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("applyPolicies took %v, want it not to wait for deferred assignments", d)
+	}
+
+	for i, want := range []bool{true, false, true} {
+		ran := len(c.results[i].GetOsPolicyResourceCompliances()[0].GetConfigSteps()) > 0
+		if ran != want {
+			t.Errorf("policy %q ran = %t, want %t", policies[i].GetId(), ran, want)
+		}
+	}
+	as := c.assignments(time.Now())
+	if len(as) != 3 || as[0].State == AssignmentDeferred || as[1].State != AssignmentDeferred || as[2].State == AssignmentDeferred {
+		t.Errorf("assignments = %+v, want only b %s", as, AssignmentDeferred)
+	}
+	if !as[0].FirstSeenAt.Equal(hourAgo) || !as[1].FirstSeenAt.Equal(start) {
+		t.Errorf("assignments = %+v, want a first seen at %v and b at %v", as, hourAgo, start)
+	}
+}
+
+func TestApplyPoliciesSkipsPausedAssignment(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func(*agentendpointpb.OSPolicy_Resource) *resource) { newResource = f }(newResource)
+	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+		return &resource{resourceIface: resourceIface(&testResource{steps: 5})}
+	}
+	defer func(f func() string) { assignmentsFile = f }(assignmentsFile)
+	path := filepath.Join(t.TempDir(), "assignments.json")
+	assignmentsFile = func() string { return path }
+	defer func(f func(string) time.Duration) { assignmentStartDelay = f }(assignmentStartDelay)
+	assignmentStartDelay = func(string) time.Duration { return 0 }
+	defer func(f func(string) bool) { assignmentPausedLocally = f }(assignmentPausedLocally)
+	assignmentPausedLocally = func(assignment string) bool { return assignment == "projects/p/locations/z/osPolicyAssignments/a1@rev" }
+
+	policies := []*agentendpointpb.ApplyConfigTask_OSPolicy{genTestPolicy("p1"), genTestPolicy("p2")}
+	policies[0].OsPolicyAssignment = "projects/p/locations/z/osPolicyAssignments/a1@rev"
+	policies[1].OsPolicyAssignment = "projects/p/locations/z/osPolicyAssignments/a2@rev"
+	c := &configTask{Task: &applyConfigTask{&agentendpointpb.ApplyConfigTask{OsPolicies: policies}}, StartedAt: time.Now(), spread: true}
+	c.generateBaseResults()
+	c.applyPolicies(ctx)
+	c.cleanup(ctx)
+
+	// The paused policy is reported as not applied, with the reason.
+	paused := c.results[0].GetOsPolicyResourceCompliances()[0]
+	if steps := paused.GetConfigSteps(); len(steps) != 1 || steps[0].GetType() != agentendpointpb.OSPolicyResourceConfigStep_VALIDATION ||
+		steps[0].GetOutcome() != agentendpointpb.OSPolicyResourceConfigStep_FAILED || !strings.Contains(steps[0].GetErrorMessage(), "is paused on this instance") {
+		t.Errorf("paused policy config steps = %v, want a failed validation step with the pause as reason", steps)
+	}
+	if got := paused.GetState(); got != agentendpointpb.OSPolicyComplianceState_UNKNOWN {
+		t.Errorf("paused policy state = %s, want %s", got, agentendpointpb.OSPolicyComplianceState_UNKNOWN)
+	}
+	if steps := c.results[1].GetOsPolicyResourceCompliances()[0].GetConfigSteps(); len(steps) == 0 || steps[0].GetOutcome() != agentendpointpb.OSPolicyResourceConfigStep_SUCCEEDED {
+		t.Errorf("policy %q config steps = %v, want it run", policies[1].GetId(), steps)
+	}
+	as := c.assignments(time.Now())
+	if len(as) != 2 || as[0].State != AssignmentPaused || as[1].State == AssignmentPaused {
+		t.Errorf("assignments = %+v, want only a1 %s", as, AssignmentPaused)
+	}
+
+	// Local policies are never paused.
+	c = &configTask{Task: &applyConfigTask{&agentendpointpb.ApplyConfigTask{OsPolicies: policies}}}
+	c.generateBaseResults()
+	c.applyPolicies(ctx)
+	c.cleanup(ctx)
+	if len(c.results[0].GetOsPolicyResourceCompliances()[0].GetConfigSteps()) == 0 {
+		t.Errorf("policy %q did not run without spread", policies[0].GetId())
+	}
+}
+
+// slowTestResource enforces until its context is done.
+type slowTestResource struct {
+	testResource
+}
+
+func (r *slowTestResource) EnforceState(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestApplyPoliciesTimeBudget(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func(*agentendpointpb.OSPolicy_Resource) *resource) { newResource = f }(newResource)
+	defer func(f, g func() time.Duration) { policyTimeBudget, resourceTimeBudget = f, g }(policyTimeBudget, resourceTimeBudget)
+
+	tests := []struct {
+		name           string
+		policyBudget   time.Duration
+		resourceBudget time.Duration
+		want           string
+	}{
+		{"Policy", 50 * time.Millisecond, 0, `policy "p1" exceeded its time budget of 50ms`},
+		{"Resource", time.Hour, 50 * time.Millisecond, `resource "r1" exceeded its time budget of 50ms`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policyTimeBudget = func() time.Duration { return tt.policyBudget }
+			resourceTimeBudget = func() time.Duration { return tt.resourceBudget }
+			var slow *slowTestResource
+			newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+				if slow == nil {
+					slow = &slowTestResource{testResource{steps: 5}}
+					return &resource{resourceIface: resourceIface(slow)}
+				}
+				return &resource{resourceIface: resourceIface(&testResource{steps: 5})}
+			}
+
+			policies := []*agentendpointpb.ApplyConfigTask_OSPolicy{genTestPolicy("p1"), genTestPolicy("p2")}
+			policies[0].Resources = append(policies[0].Resources, genTestResource("r2"))
+			c := &configTask{Task: &applyConfigTask{&agentendpointpb.ApplyConfigTask{OsPolicies: policies}}}
+			c.generateBaseResults()
+			c.applyPolicies(ctx)
+
+			rCompliances := c.results[0].GetOsPolicyResourceCompliances()
+			steps := rCompliances[0].GetConfigSteps()
+			last := steps[len(steps)-1]
+			if last.GetType() != agentendpointpb.OSPolicyResourceConfigStep_DESIRED_STATE_ENFORCEMENT || last.GetOutcome() != agentendpointpb.OSPolicyResourceConfigStep_FAILED {
+				t.Errorf("r1 last step = %s %s, want a failed enforcement", last.GetType(), last.GetOutcome())
+			}
+			if !strings.Contains(last.GetErrorMessage(), tt.want) {
+				t.Errorf("r1 error message = %q, want it to contain %q", last.GetErrorMessage(), tt.want)
+			}
+			if got := rCompliances[0].GetState(); got != agentendpointpb.OSPolicyComplianceState_UNKNOWN {
+				t.Errorf("r1 state = %s, want %s", got, agentendpointpb.OSPolicyComplianceState_UNKNOWN)
+			}
+			if got := len(rCompliances[1].GetConfigSteps()); got != 0 {
+				t.Errorf("r2 ran %d steps after the budget was exceeded, want 0", got)
+			}
+			// The next policy still runs.
+			if got := c.results[1].GetOsPolicyResourceCompliances()[0].GetState(); got != agentendpointpb.OSPolicyComplianceState_COMPLIANT {
+				t.Errorf("p2 state = %s, want %s", got, agentendpointpb.OSPolicyComplianceState_COMPLIANT)
+			}
+		})
+	}
+}
+
+func BenchmarkApplyPolicies(b *testing.B) {
+	ctx := context.Background()
+	defer func(f func(*agentendpointpb.OSPolicy_Resource) *resource) { newResource = f }(newResource)
+	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+		return &resource{resourceIface: resourceIface(&testResource{steps: 5})}
+	}
+
+	for _, size := range []struct{ policies, resources int }{{1, 1}, {10, 10}, {50, 20}} {
+		task := &agentendpointpb.ApplyConfigTask{}
+		for i := 0; i < size.policies; i++ {
+			p := &agentendpointpb.ApplyConfigTask_OSPolicy{Id: fmt.Sprintf("p%d", i), Mode: agentendpointpb.OSPolicy_ENFORCEMENT}
+			for j := 0; j < size.resources; j++ {
+				p.Resources = append(p.Resources, genTestResource(fmt.Sprintf("r%d", j)))
+			}
+			task.OsPolicies = append(task.OsPolicies, p)
+		}
+
+		b.Run(fmt.Sprintf("%dx%d", size.policies, size.resources), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c := &configTask{Task: &applyConfigTask{task}}
+				c.generateBaseResults()
+				c.applyPolicies(ctx)
+			}
+		})
+	}
+}
+
+func TestDetectPolicyConflicts(t *testing.T) {
+	file := func(state agentendpointpb.OSPolicy_Resource_FileResource_DesiredState) *config.ManagedResources {
+		return &config.ManagedResources{Files: []config.ManagedFile{{Path: "/etc/motd", State: state}}}
+	}
+	apt := func(state agentendpointpb.OSPolicy_Resource_PackageResource_DesiredState) *config.ManagedResources {
+		return &config.ManagedResources{Packages: []config.ManagedPackage{{Apt: &config.AptPackage{
+			PackageResource: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: "foo"},
+			DesiredState:    state,
+		}}}}
+	}
+	r1 := resourceKey{assignment: "a1", policy: "p1", resource: "r1"}
+
+	tests := []struct {
+		name     string
+		current  *config.ManagedResources
+		key      resourceKey
+		proposed *config.ManagedResources
+		wantErr  string
+	}{
+		{"SameFileState", file(agentendpointpb.OSPolicy_Resource_FileResource_PRESENT), resourceKey{"a1", "p2", "r2"}, file(agentendpointpb.OSPolicy_Resource_FileResource_PRESENT), ""},
+		{"PresentAndContents", file(agentendpointpb.OSPolicy_Resource_FileResource_PRESENT), resourceKey{"a1", "p2", "r2"}, file(agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH), ""},
+		{"FileAbsentAndPresent", file(agentendpointpb.OSPolicy_Resource_FileResource_PRESENT), resourceKey{"a1", "p2", "r2"}, file(agentendpointpb.OSPolicy_Resource_FileResource_ABSENT), `resource "r2" conflicts with resource "r1" of policy "p1" in assignment "a1" over file "/etc/motd"`},
+		{"SamePackageState", apt(agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED), resourceKey{"a2", "p1", "r2"}, apt(agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED), ""},
+		{"PackageInstalledAndRemoved", apt(agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED), resourceKey{"a1", "p1", "r2"}, apt(agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED), `resource "r2" conflicts with resource "r1" of policy "p1" in assignment "a1" over apt package "foo"`},
+		// Policy and resource IDs are only unique within their assignment.
+		{"SameIDsOtherAssignment", apt(agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED), resourceKey{"a2", "p1", "r1"}, apt(agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED), `resource "r1" conflicts with resource "r1" of policy "p1" in assignment "a1" over apt package "foo"`},
+		{"Unrelated", apt(agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED), resourceKey{"a1", "p2", "r2"}, file(agentendpointpb.OSPolicy_Resource_FileResource_ABSENT), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := map[string]*claim{}
+			if o, err := detectPolicyConflicts(r1, tt.current, current); o != nil || err != nil {
+				t.Fatalf("detectPolicyConflicts(empty) = %v, %v, want nil, nil", o, err)
+			}
+			o, err := detectPolicyConflicts(tt.key, tt.proposed, current)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+			if o.resourceKey != r1 {
+				t.Errorf("conflicting claim of %+v, want %+v", o.resourceKey, r1)
+			}
+			for _, c := range current {
+				if c.resourceKey != r1 {
+					t.Errorf("claim %+v of the conflicting resource was added", c)
+				}
+			}
+		})
+	}
+}
+
+func TestClaimCompatible(t *testing.T) {
+	contents := func(algorithm, checksum string) *claim {
+		return &claim{algorithm: algorithm, checksum: checksum}
+	}
+	tests := []struct {
+		name string
+		a, b *claim
+		want bool
+	}{
+		{"SameContents", contents("sha256", "abc"), contents("sha256", "abc"), true},
+		{"DifferentContents", contents("sha256", "abc"), contents("sha256", "def"), false},
+		{"DifferentAlgorithms", contents("sha256", "abc"), contents("md5", "def"), true},
+		{"AnyContents", contents("", ""), contents("sha256", "def"), true},
+		{"BothAbsent", &claim{absent: true}, &claim{absent: true}, true},
+		{"AbsentAndContents", &claim{absent: true}, contents("sha256", "abc"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.compatible(tt.b); got != tt.want {
+				t.Errorf("%+v.compatible(%+v) = %t, want %t", tt.a, tt.b, got, tt.want)
+			}
+			if got := tt.b.compatible(tt.a); got != tt.want {
+				t.Errorf("%+v.compatible(%+v) = %t, want %t", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
+
+// readOnlyResource fails to enforce its state on a read-only file system.
+type readOnlyResource struct {
+	testResource
+}
+
+func (r *readOnlyResource) EnforceState(ctx context.Context) error {
+	return fmt.Errorf("%q is on a %w", "/usr/bin/app", config.ErrReadOnlyFS)
+}
+
+func TestEnforceConfigResourceStateReadOnly(t *testing.T) {
+	ctx := context.Background()
+	res := &resource{resourceIface: &readOnlyResource{}}
+	rCompliance := &agentendpointpb.OSPolicyResourceCompliance{}
+	configResource := &agentendpointpb.OSPolicy_Resource{Id: "r1", ResourceType: &agentendpointpb.OSPolicy_Resource_File_{}}
+
+	enforced, hasError := enforceConfigResourceState(ctx, res, rCompliance, configResource)
+	if enforced || !hasError {
+		t.Errorf("got enforced %t, error %t, want false, true", enforced, hasError)
+	}
+	if rCompliance.GetState() != agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT {
+		t.Errorf("got state %s, want NON_COMPLIANT", rCompliance.GetState())
+	}
+	steps := rCompliance.GetConfigSteps()
+	if len(steps) != 1 || steps[0].GetOutcome() != agentendpointpb.OSPolicyResourceConfigStep_FAILED || !strings.Contains(steps[0].GetErrorMessage(), "read-only file system") {
+		t.Errorf("got steps %v, want a failed enforcement with the read-only reason", steps)
+	}
+}
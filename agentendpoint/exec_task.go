@@ -15,6 +15,7 @@
 package agentendpoint
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -26,8 +27,11 @@ import (
 	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/osconfig/audit"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
 	"github.com/GoogleCloudPlatform/osconfig/external"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 
 	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
@@ -56,19 +60,23 @@ func init() {
 	winCmd = filepath.Join(winRoot, `System32\cmd.exe`)
 }
 
-var run = func(cmd *exec.Cmd) ([]byte, error) {
-	return cmd.CombinedOutput()
+var run = func(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := util.RunWithProcessGroup(ctx, cmd)
+	return out.Bytes(), err
 }
 
 func getGCSObject(ctx context.Context, bkt, obj string, gen int64) (string, error) {
 	cl, err := storage.NewClient(ctx)
 	if err != nil {
-		return "", fmt.Errorf("error creating gcs client: %v", err)
+		return "", errcode.Wrap(errcode.Auth, fmt.Errorf("error creating gcs client: %w", err))
 	}
 	defer cl.Close()
 	reader, err := external.FetchGCSObject(ctx, cl, bkt, obj, gen)
 	if err != nil {
-		return "", fmt.Errorf("error fetching GCS object: %v", err)
+		return "", errcode.Wrap(errcode.Network, fmt.Errorf("error fetching GCS object: %w", err))
 	}
 	defer reader.Close()
 	clog.Debugf(ctx, "Fetched GCS object bucket %s object %s generation number %d", bkt, obj, gen)
@@ -86,7 +94,7 @@ func executeCommand(ctx context.Context, path string, args []string) (int32, err
 	clog.Debugf(ctx, "Running command %s with args %s", path, args)
 
 	cmd := exec.Command(path, args...)
-	out, err := run(cmd)
+	out, err := run(ctx, cmd)
 	var exitCode int32
 	if cmd.ProcessState != nil {
 		exitCode = int32(cmd.ProcessState.ExitCode())
@@ -166,7 +174,7 @@ func (e *execTask) run(ctx context.Context) error {
 		var err error
 		localPath, err = getGCSObject(ctx, gcsObject.GetBucket(), gcsObject.GetObject(), gcsObject.GetGenerationNumber())
 		if err != nil {
-			msg := fmt.Sprintf("Error downloading GCS object: %v", err)
+			msg := errorMessage(errcode.Of(err, errcode.Network), fmt.Sprintf("Error downloading GCS object: %v", err))
 			clog.Errorf(ctx, msg)
 			return e.reportCompletedState(ctx, msg, &agentendpointpb.ReportTaskCompleteRequest_ExecStepTaskOutput{
 				ExecStepTaskOutput: &agentendpointpb.ExecStepTaskOutput{
@@ -183,6 +191,7 @@ func (e *execTask) run(ctx context.Context) error {
 	}
 
 	exitCode := int32(-1)
+	start := time.Now()
 	switch stepConfig.GetInterpreter() {
 	case agentendpointpb.ExecStepConfig_INTERPRETER_UNSPECIFIED:
 		if goos == "windows" {
@@ -205,8 +214,15 @@ func (e *execTask) run(ctx context.Context) error {
 	default:
 		err = fmt.Errorf("invalid interpreter %q", stepConfig.GetInterpreter())
 	}
+	if err == nil && exitCode != 0 {
+		journal.Record(ctx, journal.ScriptRun, localPath, fmt.Errorf("exit code %d", exitCode))
+		audit.Record(ctx, audit.RunExecStep, localPath, start, fmt.Errorf("exit code %d", exitCode))
+	} else {
+		journal.Record(ctx, journal.ScriptRun, localPath, err)
+		audit.Record(ctx, audit.RunExecStep, localPath, start, err)
+	}
 	if err != nil {
-		msg := fmt.Sprintf("Error running ExecStepTask: %v", err)
+		msg := errorMessage(errcode.Of(err, errcode.Script), fmt.Sprintf("Error running ExecStepTask: %v", err))
 		clog.Errorf(ctx, msg)
 		return e.reportCompletedState(ctx, msg, &agentendpointpb.ReportTaskCompleteRequest_ExecStepTaskOutput{
 			ExecStepTaskOutput: &agentendpointpb.ExecStepTaskOutput{
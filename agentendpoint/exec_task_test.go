@@ -108,7 +108,7 @@ func TestRunExecStep(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			var gotPath string
 			var gotArgs []string
-			run = func(cmd *exec.Cmd) ([]byte, error) {
+			run = func(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
 				gotPath = cmd.Path
 				gotArgs = cmd.Args
 				return nil, nil
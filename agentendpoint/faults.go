@@ -0,0 +1,200 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Faults are network faults injected into the calls of a client dialed with
+// DialOptions. They are meant for tests checking the retry and backoff of the
+// agent, the zero value injects nothing.
+type Faults struct {
+	// Methods limits the faults to these methods, like ReportInventory, all
+	// methods if empty.
+	Methods []string
+	// Latency is added before each call is sent.
+	Latency time.Duration
+	// ErrorRate is the share of calls failed with Code before they are
+	// sent, Code is UNAVAILABLE if unset.
+	ErrorRate float64
+	Code      codes.Code
+	// RetryDelay is sent as RetryInfo with injected RESOURCE_EXHAUSTED and
+	// UNAVAILABLE errors, if set.
+	RetryDelay time.Duration
+	// PartialWriteRate is the share of unary calls that reach the server but
+	// whose response is lost, they fail with UNAVAILABLE.
+	PartialWriteRate float64
+	// DropStreamRate is the share of stream messages after which the stream
+	// is dropped with UNAVAILABLE.
+	DropStreamRate float64
+	// Seed makes the injected faults repeatable, a random seed is used if 0.
+	Seed int64
+
+	mx       sync.Mutex
+	rnd      *rand.Rand
+	injected int
+}
+
+// DialOptions returns the dial options injecting f into the calls of a
+// client dialed by a test, after any interceptors added before them. Clients
+// dialed by the agent never add them, so production calls do not go through
+// the fault interceptors.
+func (f *Faults) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(f.unaryInterceptor),
+		grpc.WithChainStreamInterceptor(f.streamInterceptor),
+	}
+}
+
+// Injected returns the number of faults injected so far, latency is not
+// counted.
+func (f *Faults) Injected() int {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.injected
+}
+
+// matches reports whether f injects faults into method.
+func (f *Faults) matches(method string) bool {
+	if len(f.Methods) == 0 {
+		return true
+	}
+	for _, m := range f.Methods {
+		if m == methodName(method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Faults) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.rnd == nil {
+		seed := f.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		f.rnd = rand.New(rand.NewSource(seed))
+	}
+	return f.rnd.Float64() < p
+}
+
+func (f *Faults) delay(ctx context.Context) error {
+	if f.Latency <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(f.Latency):
+		return nil
+	}
+}
+
+func (f *Faults) inject(ctx context.Context, method string, code codes.Code, msg string) error {
+	f.mx.Lock()
+	f.injected++
+	f.mx.Unlock()
+	clog.Debugf(ctx, "Injecting %s fault into %s: %s.", code, methodName(method), msg)
+
+	st := status.New(code, "injected fault: "+msg)
+	if f.RetryDelay > 0 && (code == codes.ResourceExhausted || code == codes.Unavailable) {
+		if s, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(f.RetryDelay)}); err == nil {
+			st = s
+		}
+	}
+	return st.Err()
+}
+
+func (f *Faults) code() codes.Code {
+	if f.Code == codes.OK {
+		return codes.Unavailable
+	}
+	return f.Code
+}
+
+// unaryInterceptor injects f into unary calls.
+func (f *Faults) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if !f.matches(method) {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	if err := f.delay(ctx); err != nil {
+		return err
+	}
+	if f.chance(f.ErrorRate) {
+		return f.inject(ctx, method, f.code(), "call failed")
+	}
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err == nil && f.chance(f.PartialWriteRate) {
+		return f.inject(ctx, method, codes.Unavailable, "response lost")
+	}
+	return err
+}
+
+// streamInterceptor injects f into streams.
+func (f *Faults) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if !f.matches(method) {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	if err := f.delay(ctx); err != nil {
+		return nil, err
+	}
+	if f.chance(f.ErrorRate) {
+		return nil, f.inject(ctx, method, f.code(), "call failed")
+	}
+	// The stream is canceled when dropped, so it is not left open.
+	ctx, cancel := context.WithCancel(ctx)
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &faultStream{ClientStream: cs, ctx: ctx, cancel: cancel, method: method, faults: f}, nil
+}
+
+type faultStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	cancel context.CancelFunc
+	method string
+	faults *Faults
+}
+
+func (s *faultStream) RecvMsg(m interface{}) error {
+	if err := s.ClientStream.RecvMsg(m); err != nil {
+		s.cancel()
+		return err
+	}
+	if s.faults.chance(s.faults.DropStreamRate) {
+		s.cancel()
+		return s.faults.inject(s.ctx, s.method, codes.Unavailable, "stream dropped")
+	}
+	return nil
+}
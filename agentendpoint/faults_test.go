@@ -0,0 +1,158 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/retryutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const faultTestMethod = "/google.cloud.osconfig.agentendpoint.v1.AgentEndpointService/ReportInventory"
+
+func TestFaultUnaryInterceptor(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		desc         string
+		faults       *Faults
+		wantCode     codes.Code
+		wantSent     bool
+		wantInjected int
+	}{
+		{"error", &Faults{ErrorRate: 1}, codes.Unavailable, false, 1},
+		{"error code", &Faults{ErrorRate: 1, Code: codes.Internal}, codes.Internal, false, 1},
+		{"other method", &Faults{ErrorRate: 1, Methods: []string{"RegisterAgent"}}, codes.OK, true, 0},
+		{"matching method", &Faults{ErrorRate: 1, Methods: []string{"ReportInventory"}}, codes.Unavailable, false, 1},
+		{"partial write", &Faults{PartialWriteRate: 1}, codes.Unavailable, true, 1},
+		{"zero rates", &Faults{}, codes.OK, true, 0},
+	}
+	for _, tt := range tests {
+		var sent bool
+		invoke := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+			sent = true
+			return nil
+		}
+		err := tt.faults.unaryInterceptor(ctx, faultTestMethod, nil, nil, nil, invoke)
+		if got := status.Code(err); got != tt.wantCode {
+			t.Errorf("%s: got code %s, want %s", tt.desc, got, tt.wantCode)
+		}
+		if sent != tt.wantSent {
+			t.Errorf("%s: call sent %t, want %t", tt.desc, sent, tt.wantSent)
+		}
+		if tt.faults.Injected() != tt.wantInjected {
+			t.Errorf("%s: got %d injected faults, want %d", tt.desc, tt.faults.Injected(), tt.wantInjected)
+		}
+	}
+}
+
+func TestFaultsRetryDelay(t *testing.T) {
+	defer func(b *callBudget, s *callStats) { apiBudget, apiStats = b, s }(apiBudget, apiStats)
+	apiBudget = &callBudget{rate: 1, burst: 10, tokens: 10}
+	apiStats = &callStats{methods: map[string]*methodStats{}}
+	ctx := context.Background()
+
+	f := &Faults{ErrorRate: 1, Code: codes.ResourceExhausted, RetryDelay: time.Hour}
+	invoke := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return f.unaryInterceptor(ctx, method, req, reply, cc, func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+			return nil
+		}, opts...)
+	}
+	err := unaryInterceptor(ctx, faultTestMethod, nil, nil, nil, invoke)
+	if d, ok := retryutil.RetryDelay(err); !ok || d != time.Hour {
+		t.Errorf("RetryDelay(%v): got %s, %t, want 1h", err, d, ok)
+	}
+	// An injected RESOURCE_EXHAUSTED is handled like one of the service.
+	if d := apiBudget.reserve(time.Now()); d < 59*time.Minute {
+		t.Errorf("calls after injected RESOURCE_EXHAUSTED held for %s, want the 1h retry delay", d)
+	}
+}
+
+func TestFaultsLatency(t *testing.T) {
+	f := &Faults{Latency: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var sent bool
+	invoke := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		sent = true
+		return nil
+	}
+	if err := f.unaryInterceptor(ctx, faultTestMethod, nil, nil, nil, invoke); err != context.DeadlineExceeded {
+		t.Errorf("unaryInterceptor: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if sent {
+		t.Error("unaryInterceptor: call was sent before the latency passed")
+	}
+}
+
+type fakeClientStream struct {
+	grpc.ClientStream
+}
+
+func (fakeClientStream) RecvMsg(interface{}) error { return nil }
+
+func TestFaultStreamInterceptor(t *testing.T) {
+	method := "/google.cloud.osconfig.agentendpoint.v1.AgentEndpointService/ReceiveTaskNotification"
+
+	var streamCtx context.Context
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		streamCtx = ctx
+		return fakeClientStream{}, nil
+	}
+
+	f := &Faults{DropStreamRate: 1}
+	cs, err := f.streamInterceptor(context.Background(), nil, nil, method, streamer)
+	if err != nil {
+		t.Fatalf("streamInterceptor: %v", err)
+	}
+	if err := cs.RecvMsg(nil); status.Code(err) != codes.Unavailable {
+		t.Errorf("RecvMsg: got %v, want a dropped stream", err)
+	}
+	if streamCtx.Err() == nil {
+		t.Error("dropped stream was not canceled")
+	}
+
+	f = &Faults{ErrorRate: 1}
+	streamCtx = nil
+	if _, err := f.streamInterceptor(context.Background(), nil, nil, method, streamer); status.Code(err) != codes.Unavailable {
+		t.Errorf("streamInterceptor: got %v, want UNAVAILABLE", err)
+	}
+	if streamCtx != nil {
+		t.Error("streamInterceptor: stream was opened")
+	}
+}
+
+func TestFaultsSeed(t *testing.T) {
+	run := func() []bool {
+		f := &Faults{ErrorRate: 0.5, Seed: 42}
+		var got []bool
+		for i := 0; i < 20; i++ {
+			got = append(got, f.chance(f.ErrorRate))
+		}
+		return got
+	}
+	a, b := run(), run()
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("faults with the same seed differ: %v and %v", a, b)
+		}
+	}
+}
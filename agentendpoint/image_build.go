@@ -0,0 +1,155 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	"google.golang.org/protobuf/proto"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+var (
+	imageBuildMarkerFile = agentconfig.ImageBuildMarkerFile
+	instanceID           = func() string {
+		if !metadata.OnGCE() {
+			return ""
+		}
+		id, _ := metadata.InstanceID()
+		return id
+	}
+)
+
+// imageBuildMarker records the resources that were compliant after applying
+// policies while building an image, so the first config task on instances
+// created from the image can skip checking them.
+type imageBuildMarker struct {
+	// InstanceID is the instance the image was built on, the marker is not
+	// used there.
+	InstanceID string    `json:"instanceId,omitempty"`
+	Created    time.Time `json:"created"`
+	// Resources are hashes of the compliant resources, see resourceHash.
+	Resources []string `json:"resources"`
+}
+
+// resourceHash identifies a resource by its settings, so the same resource
+// matches whatever policy it is delivered in.
+func resourceHash(r *agentendpointpb.OSPolicy_Resource) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+// writeImageBuildMarker records the compliant resources of the task. Local
+// resource types are left out as they are never sent by the API.
+func (c *configTask) writeImageBuildMarker(ctx context.Context) error {
+	m := &imageBuildMarker{InstanceID: instanceID(), Created: time.Now().UTC(), Resources: []string{}}
+	for i, osPolicy := range c.Task.GetOsPolicies() {
+		for j, r := range osPolicy.GetResources() {
+			if _, ok := c.localResources[localResourceKey(osPolicy.GetId(), r.GetId())]; ok {
+				continue
+			}
+			if c.results[i].GetOsPolicyResourceCompliances()[j].GetState() != agentendpointpb.OSPolicyComplianceState_COMPLIANT {
+				continue
+			}
+			h, err := resourceHash(r)
+			if err != nil {
+				return err
+			}
+			m.Resources = append(m.Resources, h)
+		}
+	}
+	sort.Strings(m.Resources)
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := imageBuildMarkerFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := util.AtomicWrite(path, b, 0644); err != nil {
+		return fmt.Errorf("error writing image build marker %q: %v", path, err)
+	}
+	clog.Infof(ctx, "Recorded %d compliant resources in image build marker %q.", len(m.Resources), path)
+	return nil
+}
+
+// consumeImageBuildMarker returns the resources recorded at image build time
+// and removes the marker, so only the first config task on an instance
+// created from the image skips checks. Nothing is returned on the instance
+// the image was built on.
+func consumeImageBuildMarker(ctx context.Context) map[string]bool {
+	path := imageBuildMarkerFile()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			clog.Errorf(ctx, "Error reading image build marker: %v", err)
+		}
+		return nil
+	}
+	var m imageBuildMarker
+	if err := json.Unmarshal(b, &m); err != nil {
+		clog.Errorf(ctx, "Error parsing image build marker %q, ignoring it: %v", path, err)
+		return nil
+	}
+	if m.InstanceID != "" && m.InstanceID == agentconfig.ID() {
+		return nil
+	}
+	if err := os.Remove(path); err != nil {
+		clog.Errorf(ctx, "Error removing image build marker: %v", err)
+	}
+	clog.Infof(ctx, "Using image build marker from %s, skipping checks for %d resources enforced at image build time.", m.Created.Format(time.RFC3339), len(m.Resources))
+
+	resources := map[string]bool{}
+	for _, h := range m.Resources {
+		resources[h] = true
+	}
+	return resources
+}
+
+// skipImageBuildResource marks a resource compliant without checking it if
+// it was compliant at image build time.
+func (c *configTask) skipImageBuildResource(ctx context.Context, rCompliance *agentendpointpb.OSPolicyResourceCompliance, configResource *agentendpointpb.OSPolicy_Resource) bool {
+	if len(c.imageBuildResources) == 0 {
+		return false
+	}
+	h, err := resourceHash(configResource)
+	if err != nil || !c.imageBuildResources[h] {
+		return false
+	}
+	rCompliance.ConfigSteps = append(rCompliance.GetConfigSteps(), &agentendpointpb.OSPolicyResourceConfigStep{
+		Type:    agentendpointpb.OSPolicyResourceConfigStep_DESIRED_STATE_CHECK,
+		Outcome: agentendpointpb.OSPolicyResourceConfigStep_SUCCEEDED,
+	})
+	rCompliance.State = agentendpointpb.OSPolicyComplianceState_COMPLIANT
+	clog.Infof(ctx, "Check state: resource %q was enforced at image build time, skipping check.", configResource.GetId())
+	return true
+}
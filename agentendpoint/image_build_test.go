@@ -0,0 +1,95 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/config"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+func TestImageBuildMarker(t *testing.T) {
+	ctx := context.Background()
+	marker := filepath.Join(t.TempDir(), "marker.json")
+	defer func(f func() string) { imageBuildMarkerFile = f }(imageBuildMarkerFile)
+	imageBuildMarkerFile = func() string { return marker }
+	defer func(f func() string) { instanceID = f }(instanceID)
+	instanceID = func() string { return "build-instance" }
+
+	compliant := genTestResource("compliant")
+	nonCompliant := genTestResource("non-compliant")
+	local := &agentendpointpb.OSPolicy_Resource{Id: "local"}
+	c := &configTask{
+		Task: &applyConfigTask{&agentendpointpb.ApplyConfigTask{OsPolicies: []*agentendpointpb.ApplyConfigTask_OSPolicy{
+			{Id: "p1", Resources: []*agentendpointpb.OSPolicy_Resource{compliant, nonCompliant, local}},
+		}}},
+		localResources: map[string]*config.LocalResource{"p1/local": {}},
+	}
+	c.generateBaseResults()
+	for _, i := range []int{0, 2} {
+		c.results[0].GetOsPolicyResourceCompliances()[i].State = agentendpointpb.OSPolicyComplianceState_COMPLIANT
+	}
+
+	if err := c.writeImageBuildMarker(ctx); err != nil {
+		t.Fatalf("writeImageBuildMarker: %v", err)
+	}
+
+	h, err := resourceHash(compliant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]bool{h: true}, consumeImageBuildMarker(ctx)); diff != "" {
+		t.Errorf("consumeImageBuildMarker did not match expectation: (-want +got)\n%s", diff)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("marker should be removed after use, stat error: %v", err)
+	}
+	if got := consumeImageBuildMarker(ctx); got != nil {
+		t.Errorf("consumeImageBuildMarker after first use: got %v, want nil", got)
+	}
+}
+
+func TestApplyPoliciesSkipsImageBuildResources(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func(*agentendpointpb.OSPolicy_Resource) *resource) { newResource = f }(newResource)
+	// A check error shows the check ran.
+	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+		return &resource{resourceIface: resourceIface(&testResource{steps: 1})}
+	}
+
+	r1 := genTestResource("r1")
+	h, err := resourceHash(r1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &configTask{
+		Task:                &applyConfigTask{&agentendpointpb.ApplyConfigTask{OsPolicies: []*agentendpointpb.ApplyConfigTask_OSPolicy{genTestPolicy("p1")}}},
+		imageBuildResources: map[string]bool{h: true},
+	}
+	c.generateBaseResults()
+	c.applyPolicies(ctx)
+
+	want := []*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{genTestPolicyResult("p1", 2, true)}
+	if diff := cmp.Diff(want, c.results, protocmp.Transform()); diff != "" {
+		t.Errorf("results did not match expectation: (-want +got)\n%s", diff)
+	}
+}
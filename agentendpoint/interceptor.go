@@ -0,0 +1,248 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/retryutil"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDHeader is the metadata key of the ID sent with every API call, it
+// is logged on both sides so a call can be correlated with server logs.
+const requestIDHeader = "x-osconfig-request-id"
+
+const (
+	// apiCallRate and apiCallBurst are the budget of API calls of the agent,
+	// calls over the budget wait for it instead of being sent.
+	apiCallRate  = 1.0 // per second
+	apiCallBurst = 60
+	// quotaRetryDelay is how long calls are held after a RESOURCE_EXHAUSTED
+	// error without a retry delay.
+	quotaRetryDelay = 30 * time.Second
+)
+
+var (
+	apiBudget = &callBudget{rate: apiCallRate, burst: apiCallBurst, tokens: apiCallBurst}
+	apiStats  = &callStats{methods: map[string]*methodStats{}}
+)
+
+// callBudget is a token bucket shared by all clients of the agent. After a
+// RESOURCE_EXHAUSTED error no calls are made until the retry delay passed.
+type callBudget struct {
+	mu           sync.Mutex
+	rate, burst  float64
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// reserve takes a call from the budget, or returns how long to wait for
+// one.
+func (b *callBudget) reserve(t time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t.Before(b.blockedUntil) {
+		return b.blockedUntil.Sub(t)
+	}
+	if !b.last.IsZero() {
+		b.tokens += t.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = t
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// block holds all calls until t + d.
+func (b *callBudget) block(t time.Time, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if until := t.Add(d); until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+}
+
+// wait blocks until the budget allows a call and returns how long it waited.
+func (b *callBudget) wait(ctx context.Context) (time.Duration, error) {
+	var waited time.Duration
+	for {
+		d := b.reserve(time.Now())
+		if d == 0 {
+			return waited, nil
+		}
+		select {
+		case <-ctx.Done():
+			return waited, ctx.Err()
+		case <-time.After(d):
+			waited += d
+		}
+	}
+}
+
+type methodStats struct {
+	calls, errors, throttled int
+	latency                  time.Duration
+}
+
+// callStats counts the API calls of the agent by method, see logAPIStats.
+type callStats struct {
+	mu      sync.Mutex
+	methods map[string]*methodStats
+}
+
+func (s *callStats) record(method string, latency, waited time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.methods[method]
+	if !ok {
+		m = &methodStats{}
+		s.methods[method] = m
+	}
+	m.calls++
+	m.latency += latency
+	if err != nil {
+		m.errors++
+	}
+	if waited > 0 {
+		m.throttled++
+	}
+}
+
+// LogAPIStats logs the number of API calls, errors and calls held by the
+// call budget by method since the last call, and resets the counts.
+func LogAPIStats(ctx context.Context) {
+	apiStats.mu.Lock()
+	methods := apiStats.methods
+	apiStats.methods = map[string]*methodStats{}
+	apiStats.mu.Unlock()
+	if len(methods) == 0 {
+		return
+	}
+
+	var names []string
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		m := methods[name]
+		parts = append(parts, fmt.Sprintf("%s: %d calls, %d errors, %d throttled, %s average latency", name, m.calls, m.errors, m.throttled, m.latency/time.Duration(m.calls)))
+	}
+	clog.Infof(ctx, "API calls since last report: %s", strings.Join(parts, "; "))
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// methodName returns RegisterAgent from
+// /google.cloud.osconfig.agentendpoint.v1.AgentEndpointService/RegisterAgent.
+func methodName(method string) string {
+	return method[strings.LastIndex(method, "/")+1:]
+}
+
+// beforeCall waits for the call budget and adds a request ID to ctx.
+func beforeCall(ctx context.Context, method string) (context.Context, time.Duration, error) {
+	waited, err := apiBudget.wait(ctx)
+	if waited > 0 {
+		clog.Debugf(ctx, "%s held for %s by the API call budget.", methodName(method), waited)
+	}
+	if err != nil {
+		return ctx, waited, err
+	}
+	id := newRequestID()
+	clog.Debugf(ctx, "Calling %s, request id %s.", methodName(method), id)
+	return metadata.AppendToOutgoingContext(ctx, requestIDHeader, id), waited, nil
+}
+
+// afterCall records the call and holds further calls for the retry delay of
+// a RESOURCE_EXHAUSTED error.
+func afterCall(ctx context.Context, method string, start time.Time, waited time.Duration, err error) {
+	apiStats.record(methodName(method), time.Since(start), waited, err)
+	if status.Code(err) != codes.ResourceExhausted {
+		return
+	}
+	delay, ok := retryutil.RetryDelay(err)
+	if !ok {
+		delay = quotaRetryDelay
+	}
+	var violations []string
+	if s, ok := status.FromError(err); ok {
+		for _, d := range s.Details() {
+			if qf, ok := d.(*errdetails.QuotaFailure); ok {
+				for _, v := range qf.GetViolations() {
+					violations = append(violations, fmt.Sprintf("%s: %s", v.GetSubject(), v.GetDescription()))
+				}
+			}
+		}
+	}
+	clog.Warningf(ctx, "%s quota exhausted, holding API calls for %s, violations: %q", methodName(method), delay, violations)
+	apiBudget.block(time.Now(), delay)
+}
+
+// unaryInterceptor applies the call budget and request IDs to unary calls.
+func unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, waited, err := beforeCall(ctx, method)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = invoker(ctx, method, req, reply, cc, opts...)
+	afterCall(ctx, method, start, waited, err)
+	return err
+}
+
+// streamInterceptor applies the call budget and request IDs to streams,
+// errors of the stream itself are handled by the caller.
+func streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, waited, err := beforeCall(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	afterCall(ctx, method, start, waited, err)
+	return cs, err
+}
+
+// interceptorOptions returns the dial options adding the interceptors.
+func interceptorOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(unaryInterceptor),
+		grpc.WithChainStreamInterceptor(streamInterceptor),
+	}
+}
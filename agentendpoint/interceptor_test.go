@@ -0,0 +1,115 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestCallBudgetReserve(t *testing.T) {
+	start := time.Now()
+	b := &callBudget{rate: 1, burst: 2, tokens: 2}
+
+	for i := 0; i < 2; i++ {
+		if d := b.reserve(start); d != 0 {
+			t.Fatalf("call %d within the burst: got wait %s, want 0", i+1, d)
+		}
+	}
+	if d := b.reserve(start); d != time.Second {
+		t.Errorf("call over the burst: got wait %s, want 1s", d)
+	}
+	if d := b.reserve(start.Add(time.Second)); d != 0 {
+		t.Errorf("call after refill: got wait %s, want 0", d)
+	}
+
+	b.block(start.Add(time.Second), time.Minute)
+	if d := b.reserve(start.Add(31 * time.Second)); d != 30*time.Second {
+		t.Errorf("call while blocked: got wait %s, want 30s", d)
+	}
+	// A shorter block does not shorten the current one.
+	b.block(start.Add(31*time.Second), time.Second)
+	if d := b.reserve(start.Add(41 * time.Second)); d != 20*time.Second {
+		t.Errorf("call while blocked: got wait %s, want 20s", d)
+	}
+	if d := b.reserve(start.Add(61 * time.Second)); d != 0 {
+		t.Errorf("call after block: got wait %s, want 0", d)
+	}
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	defer func(b *callBudget, s *callStats) { apiBudget, apiStats = b, s }(apiBudget, apiStats)
+	apiBudget = &callBudget{rate: 1, burst: 10, tokens: 10}
+	apiStats = &callStats{methods: map[string]*methodStats{}}
+	ctx := context.Background()
+	method := "/google.cloud.osconfig.agentendpoint.v1.AgentEndpointService/ReportTaskProgress"
+
+	var gotIDs []string
+	invoke := func(err error) grpc.UnaryInvoker {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			gotIDs = append(gotIDs, md.Get(requestIDHeader)...)
+			return err
+		}
+	}
+
+	if err := unaryInterceptor(ctx, method, nil, nil, nil, invoke(nil)); err != nil {
+		t.Fatalf("unaryInterceptor: %v", err)
+	}
+	st, err := status.New(codes.ResourceExhausted, "quota exceeded").WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Hour)},
+		&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{{Subject: "project", Description: "too many calls"}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unaryInterceptor(ctx, method, nil, nil, nil, invoke(st.Err())); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("unaryInterceptor: got %v, want the RESOURCE_EXHAUSTED error", err)
+	}
+
+	if len(gotIDs) != 2 || gotIDs[0] == "" || gotIDs[0] == gotIDs[1] {
+		t.Errorf("request ids: got %q, want 2 different ids", gotIDs)
+	}
+	if d := apiBudget.reserve(time.Now()); d < 59*time.Minute {
+		t.Errorf("calls after RESOURCE_EXHAUSTED held for %s, want the 1h retry delay", d)
+	}
+	m := apiStats.methods["ReportTaskProgress"]
+	if m == nil || m.calls != 2 || m.errors != 1 {
+		t.Errorf("stats: got %+v, want 2 calls and 1 error", m)
+	}
+
+	// Held calls give up when ctx is done.
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := unaryInterceptor(ctx, method, nil, nil, nil, invoke(nil)); err != context.DeadlineExceeded {
+		t.Errorf("unaryInterceptor while held: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(gotIDs) != 2 {
+		t.Error("unaryInterceptor while held: call was sent")
+	}
+
+	LogAPIStats(ctx)
+	if len(apiStats.methods) != 0 {
+		t.Errorf("LogAPIStats did not reset the stats: %+v", apiStats.methods)
+	}
+}
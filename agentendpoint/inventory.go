@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 	"reflect"
-	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/agentstatus"
 	"github.com/GoogleCloudPlatform/osconfig/attributes"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/inventory"
@@ -23,21 +23,61 @@ const (
 	inventoryURL = agentconfig.ReportURL + "/guestInventory"
 )
 
+// GetInventory generates inventory data as configured for the agent.
+func GetInventory(ctx context.Context) *inventory.InstanceInventory {
+	created, err := agentconfig.InstanceCreated()
+	if err != nil {
+		clog.Debugf(ctx, "Error getting instance creation time: %v", err)
+	}
+	return inventory.Collect(ctx, inventory.Options{
+		Collectors:        inventoryCollectors(ctx),
+		BinaryPaths:       agentconfig.BinaryInventoryPaths(),
+		PythonEnvPrefixes: agentconfig.PythonEnvPrefixes(),
+		ManagedRoots:      agentconfig.ManagedRoots(),
+		AgentVersion:      agentconfig.Version(),
+		Image:             agentconfig.Image(),
+		BootIntegrity:     agentconfig.BootIntegrity(),
+		InstanceCreated:   created,
+		AgentIdentity:     true,
+	})
+}
+
+// inventoryCollectors returns the inventory collectors to run. These are the
+// packages.DefaultCollectors, the optional collectors enabled by their own
+// setting, like licenses in extended inventory mode, and the collectors
+// enabled in metadata, less the ones disabled in metadata.
+func inventoryCollectors(ctx context.Context) packages.Collectors {
+	defaults := append([]string(nil), packages.DefaultCollectors...)
+	if agentconfig.ExtendedInventoryEnabled() {
+		defaults = append(defaults, packages.LicensesCollector)
+	}
+	if len(agentconfig.BinaryInventoryPaths()) > 0 {
+		defaults = append(defaults, packages.GoCollector, packages.CargoCollector)
+	}
+
+	c := inventory.SelectCollectors(ctx, defaults, agentconfig.EnabledInventoryCollectors(), agentconfig.DisabledInventoryCollectors())
+	clog.Infof(ctx, "Running inventory collectors: %s.", inventory.CollectorNames(c))
+	return c
+}
+
 // ReportInventory writes inventory to guest attributes and reports it to agent endpoint.
 func (c *Client) ReportInventory(ctx context.Context) {
-	state := inventory.Get(ctx)
+	start := time.Now()
+	lastInventoryReport.Store(start.UnixNano())
+	state := GetInventory(ctx)
 
 	if agentconfig.GuestAttributesEnabled() && !agentconfig.DisableInventoryWrite() {
 		clog.Infof(ctx, "Writing inventory to guest attributes")
 		write(ctx, state, inventoryURL)
 	}
 
-	c.report(ctx, state)
+	agentstatus.RecordInventory(start, c.report(ctx, state))
 }
 
 func write(ctx context.Context, state *inventory.InstanceInventory, url string) {
 	clog.Debugf(ctx, "Writing instance inventory to guest attributes.")
 
+	b := attributes.NewBatch()
 	e := reflect.ValueOf(state).Elem()
 	t := e.Type()
 	for i := 0; i < e.NumField(); i++ {
@@ -46,22 +86,33 @@ func write(ctx context.Context, state *inventory.InstanceInventory, url string)
 		switch f.Kind() {
 		case reflect.String:
 			clog.Debugf(ctx, "postAttribute %s: %+v", u, f)
-			if err := attributes.PostAttribute(u, strings.NewReader(f.String())); err != nil {
-				clog.Errorf(ctx, "postAttribute error: %v", err)
-			}
+			b.Put(u, []byte(f.String()))
 		case reflect.Ptr:
 			switch reflect.Indirect(f).Kind() {
 			case reflect.Struct:
 				clog.Debugf(ctx, "postAttributeCompressed %s", u)
-				if err := attributes.PostAttributeCompressed(u, f.Interface()); err != nil {
+				if err := b.PutCompressed(u, f.Interface()); err != nil {
 					clog.Errorf(ctx, "postAttributeCompressed error: %v", err)
 				}
 			}
+		case reflect.Slice:
+			if f.Len() == 0 {
+				continue
+			}
+			clog.Debugf(ctx, "postAttributeCompressed %s", u)
+			if err := b.PutCompressed(u, f.Interface()); err != nil {
+				clog.Errorf(ctx, "postAttributeCompressed error: %v", err)
+			}
 		}
 	}
+
+	if err := b.Flush(ctx); err != nil {
+		m := attributes.GetMetrics()
+		clog.Errorf(ctx, "Error writing inventory to guest attributes (%d write and %d verify failures since start): %v", m.WriteFailures, m.VerifyFailures, err)
+	}
 }
 
-func (c *Client) report(ctx context.Context, state *inventory.InstanceInventory) {
+func (c *Client) report(ctx context.Context, state *inventory.InstanceInventory) error {
 	clog.Debugf(ctx, "Reporting instance inventory to agent endpoint.")
 	inventory := formatInventory(ctx, state)
 
@@ -78,16 +129,17 @@ func (c *Client) report(ctx context.Context, state *inventory.InstanceInventory)
 
 	if err = retryutil.RetryAPICall(ctx, apiRetrySec*time.Second, "ReportInventory", f); err != nil {
 		clog.Errorf(ctx, "Error reporting inventory checksum: %v", err)
-		return
+		return err
 	}
 
 	if res.GetReportFullInventory() {
 		reportFull = true
 		if err = retryutil.RetryAPICall(ctx, apiRetrySec*time.Second, "ReportInventory", f); err != nil {
 			clog.Errorf(ctx, "Error reporting full inventory: %v", err)
-			return
+			return err
 		}
 	}
+	return nil
 }
 
 func formatInventory(ctx context.Context, state *inventory.InstanceInventory) *agentendpointpb.Inventory {
@@ -112,6 +164,13 @@ func formatPackages(ctx context.Context, pkgs *packages.Packages, shortName stri
 	if pkgs == nil {
 		return softwarePackages
 	}
+	// Size the result up front, on hosts with tens of thousands of packages
+	// growing it by appending dominates the memory used.
+	n := len(pkgs.Apt) + len(pkgs.Deb) + len(pkgs.GooGet) + len(pkgs.Yum) + len(pkgs.Zypper) + len(pkgs.Rpm) +
+		len(pkgs.ZypperPatches) + len(pkgs.WUA) + len(pkgs.QFE) + len(pkgs.COS) + len(pkgs.WindowsApplication)
+	if n > 0 {
+		softwarePackages = make([]*agentendpointpb.Inventory_SoftwarePackage, 0, n)
+	}
 	if pkgs.Apt != nil {
 		temp := make([]*agentendpointpb.Inventory_SoftwarePackage, len(pkgs.Apt))
 		for i, pkg := range pkgs.Apt {
@@ -219,7 +278,8 @@ func formatPackages(ctx context.Context, pkgs *packages.Packages, shortName stri
 		}
 		softwarePackages = append(softwarePackages, temp...)
 	}
-	// Ignore Pip and Gem packages.
+	// Ignore Pacman, Flatpak, Pip, Gem, Npm, Go and Cargo packages, the API
+	// has no package type for them.
 
 	return softwarePackages
 }
@@ -22,10 +22,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"testing/quick"
 	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/inventory"
@@ -317,6 +321,7 @@ func decodePackages(str string) *packages.Packages {
 
 func TestWrite(t *testing.T) {
 	inv := &inventory.InstanceInventory{
+		SchemaVersion: inventory.SchemaVersion,
 		Hostname:      "Hostname",
 		LongName:      "LongName",
 		ShortName:     "ShortName",
@@ -324,6 +329,7 @@ func TestWrite(t *testing.T) {
 		KernelVersion: "KernelVersion",
 		KernelRelease: "KernelRelease",
 		Version:       "Version",
+		Image:         "Image",
 		InstalledPackages: &packages.Packages{
 			Yum: []*packages.PkgInfo{{Name: "Name", Arch: "Arch", Version: "Version"}},
 			WUA: []*packages.WUAPackage{{Title: "Title"}},
@@ -332,31 +338,51 @@ func TestWrite(t *testing.T) {
 		PackageUpdates: &packages.Packages{
 			Apt: []*packages.PkgInfo{{Name: "Name", Arch: "Arch", Version: "Version"}},
 		},
+		ManagedRoots: []*inventory.ManagedRootInventory{{
+			Name:              "Name",
+			Path:              "/Path",
+			InstalledPackages: &packages.Packages{Deb: []*packages.PkgInfo{{Name: "Name", Arch: "Arch", Version: "Version"}}},
+		}},
 		OSConfigAgentVersion: "OSConfigAgentVersion",
 		LastUpdated:          "LastUpdated",
 	}
 
 	want := map[string]bool{
+		"SchemaVersion":        false,
 		"Hostname":             false,
 		"LongName":             false,
 		"ShortName":            false,
 		"Architecture":         false,
 		"KernelVersion":        false,
 		"Version":              false,
+		"Image":                false,
 		"InstalledPackages":    false,
 		"PackageUpdates":       false,
+		"ManagedRoots":         false,
 		"OSConfigAgentVersion": false,
 	}
 
+	written := map[string][]byte{}
 	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		url := r.URL.String()
+		// Written attributes are read back to verify them.
+		if r.Method == http.MethodGet {
+			w.Write(written[url])
+			return
+		}
 
 		buf := new(bytes.Buffer)
 		if _, err := buf.ReadFrom(r.Body); err != nil {
 			t.Fatal(err)
 		}
+		written[url] = buf.Bytes()
 
 		switch url {
+		case "/SchemaVersion":
+			if buf.String() != inv.SchemaVersion {
+				t.Errorf("did not get expected SchemaVersion, got: %q, want: %q", buf.String(), inv.SchemaVersion)
+			}
+			want["SchemaVersion"] = true
 		case "/Hostname":
 			if buf.String() != inv.Hostname {
 				t.Errorf("did not get expected Hostname, got: %q, want: %q", buf.String(), inv.Hostname)
@@ -392,6 +418,16 @@ func TestWrite(t *testing.T) {
 				t.Errorf("did not get expected Version, got: %q, want: %q", buf.String(), inv.Version)
 			}
 			want["Version"] = true
+		case "/Image":
+			if buf.String() != inv.Image {
+				t.Errorf("did not get expected Image, got: %q, want: %q", buf.String(), inv.Image)
+			}
+			want["Image"] = true
+		case "/ImageID", "/ImageVersion", "/BuildID":
+			// Unset in inv, written empty.
+			if buf.Len() != 0 {
+				t.Errorf("did not get expected empty %s, got: %q", url, buf.String())
+			}
 		case "/InstalledPackages":
 			got := decodePackages(buf.String())
 			if !reflect.DeepEqual(got, inv.InstalledPackages) {
@@ -404,6 +440,16 @@ func TestWrite(t *testing.T) {
 				t.Errorf("did not get expected PackageUpdates, got: %+v, want: %+v", got, inv.PackageUpdates)
 			}
 			want["PackageUpdates"] = true
+		case "/ManagedRoots":
+			decoded, _ := base64.StdEncoding.DecodeString(buf.String())
+			zr, _ := gzip.NewReader(bytes.NewReader(decoded))
+			var got []*inventory.ManagedRootInventory
+			json.NewDecoder(zr).Decode(&got)
+			zr.Close()
+			if !reflect.DeepEqual(got, inv.ManagedRoots) {
+				t.Errorf("did not get expected ManagedRoots, got: %+v, want: %+v", got, inv.ManagedRoots)
+			}
+			want["ManagedRoots"] = true
 		case "/OSConfigAgentVersion":
 			if buf.String() != inv.OSConfigAgentVersion {
 				t.Errorf("did not get expected OSConfigAgentVersion, got: %q, want: %q", buf.String(), inv.OSConfigAgentVersion)
@@ -432,6 +478,196 @@ func TestWrite(t *testing.T) {
 	}
 }
 
+// readInventory reads an inventory back from the attributes written by
+// write, the way a consumer of schema/v1.json would.
+func readInventory(attrs map[string]string) (*inventory.InstanceInventory, error) {
+	inv := &inventory.InstanceInventory{}
+	e := reflect.ValueOf(inv).Elem()
+	for i := 0; i < e.NumField(); i++ {
+		v, ok := attrs[e.Type().Field(i).Name]
+		if !ok {
+			continue
+		}
+		f := e.Field(i)
+		if f.Kind() == reflect.String {
+			f.SetString(v)
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(decoded))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.NewDecoder(zr).Decode(f.Addr().Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+func randomString(r *rand.Rand) string {
+	const chars = "abcXYZ019 .-_:/@+~\"\\\n\tü日"
+	runes := []rune(chars)
+	s := make([]rune, r.Intn(12))
+	for i := range s {
+		s[i] = runes[r.Intn(len(runes))]
+	}
+	return string(s)
+}
+
+func randomTime(r *rand.Rand) time.Time {
+	if r.Intn(2) == 0 {
+		return time.Time{}
+	}
+	return time.Unix(r.Int63n(2e9), 0).UTC()
+}
+
+func randomTimePtr(r *rand.Rand) *time.Time {
+	t := randomTime(r)
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func randomStrings(r *rand.Rand) []string {
+	var ss []string
+	for i := r.Intn(3); i > 0; i-- {
+		ss = append(ss, randomString(r))
+	}
+	return ss
+}
+
+func randomPkgInfos(r *rand.Rand) []*packages.PkgInfo {
+	var pkgs []*packages.PkgInfo
+	for i := r.Intn(4); i > 0; i-- {
+		pkgs = append(pkgs, &packages.PkgInfo{
+			Name:        randomString(r),
+			Arch:        randomString(r),
+			RawArch:     randomString(r),
+			Version:     randomString(r),
+			Source:      packages.Source{Name: randomString(r), Version: randomString(r)},
+			InstallTime: randomTimePtr(r),
+			Repository:  randomString(r),
+			Licenses:    randomStrings(r),
+			Location:    randomString(r),
+		})
+	}
+	return pkgs
+}
+
+// randomPackages returns nil for empty lists as write leaves them out.
+func randomPackages(r *rand.Rand) *packages.Packages {
+	if r.Intn(4) == 0 {
+		return nil
+	}
+	p := &packages.Packages{
+		Yum:    randomPkgInfos(r),
+		Rpm:    randomPkgInfos(r),
+		Apt:    randomPkgInfos(r),
+		Deb:    randomPkgInfos(r),
+		Zypper: randomPkgInfos(r),
+		COS:    randomPkgInfos(r),
+		Gem:    randomPkgInfos(r),
+		Pip:    randomPkgInfos(r),
+		Go:     randomPkgInfos(r),
+		Cargo:  randomPkgInfos(r),
+		GooGet: randomPkgInfos(r),
+	}
+	for i := r.Intn(3); i > 0; i-- {
+		p.ZypperPatches = append(p.ZypperPatches, &packages.ZypperPatch{Name: randomString(r), Category: randomString(r), Severity: randomString(r), Summary: randomString(r)})
+	}
+	for i := r.Intn(3); i > 0; i-- {
+		p.WUA = append(p.WUA, &packages.WUAPackage{
+			LastDeploymentChangeTime: randomTime(r),
+			Title:                    randomString(r),
+			Description:              randomString(r),
+			SupportURL:               randomString(r),
+			UpdateID:                 randomString(r),
+			Categories:               randomStrings(r),
+			KBArticleIDs:             randomStrings(r),
+			MoreInfoURLs:             randomStrings(r),
+			CategoryIDs:              randomStrings(r),
+			RevisionNumber:           r.Int31(),
+		})
+	}
+	for i := r.Intn(3); i > 0; i-- {
+		p.QFE = append(p.QFE, &packages.QFEPackage{Caption: randomString(r), Description: randomString(r), HotFixID: randomString(r), InstalledOn: randomString(r)})
+	}
+	return p
+}
+
+func randomInventory(r *rand.Rand) *inventory.InstanceInventory {
+	inv := &inventory.InstanceInventory{SchemaVersion: inventory.SchemaVersion}
+	e := reflect.ValueOf(inv).Elem()
+	for i := 0; i < e.NumField(); i++ {
+		if f := e.Field(i); f.Kind() == reflect.String && f.String() == "" {
+			f.SetString(randomString(r))
+		}
+	}
+	inv.InstalledPackages = randomPackages(r)
+	inv.PackageUpdates = randomPackages(r)
+	for i := r.Intn(3); i > 0; i-- {
+		inv.ManagedRoots = append(inv.ManagedRoots, &inventory.ManagedRootInventory{
+			Name:              randomString(r),
+			Path:              randomString(r),
+			InstalledPackages: randomPackages(r),
+			Error:             randomString(r),
+		})
+	}
+	return inv
+}
+
+// TestWriteRoundTrip checks any inventory read back from the guest
+// attributes matches the inventory written.
+func TestWriteRoundTrip(t *testing.T) {
+	var mx sync.Mutex
+	attrs := map[string]string{}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		defer mx.Unlock()
+		if r.Method == http.MethodGet {
+			io.WriteString(w, attrs[strings.TrimPrefix(r.URL.Path, "/")])
+			return
+		}
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+		attrs[strings.TrimPrefix(r.URL.Path, "/")] = string(b)
+	}))
+	defer svr.Close()
+
+	ctx := context.Background()
+	roundTrip := func(seed int64) bool {
+		attrs = map[string]string{}
+		inv := randomInventory(rand.New(rand.NewSource(seed)))
+		write(ctx, inv, svr.URL)
+
+		if got := attrs["SchemaVersion"]; got != inventory.SchemaVersion {
+			t.Errorf("seed %d: SchemaVersion attribute: got %q, want %q", seed, got, inventory.SchemaVersion)
+			return false
+		}
+		got, err := readInventory(attrs)
+		if err != nil {
+			t.Errorf("seed %d: error reading inventory: %v", seed, err)
+			return false
+		}
+		if diff := cmp.Diff(inv, got); diff != "" {
+			t.Errorf("seed %d: inventory round trip mismatch (-want +got):\n%s", seed, diff)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 200}); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestReport(t *testing.T) {
 	ctx := context.Background()
 	packages.YumExists = true
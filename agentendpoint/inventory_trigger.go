@@ -0,0 +1,120 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/tasker"
+)
+
+var (
+	// lastInventoryReport is when inventory was last gathered, in Unix
+	// nanoseconds.
+	lastInventoryReport atomic.Int64
+
+	// packageChangePollInterval is how often the package databases are
+	// checked for changes.
+	packageChangePollInterval = time.Minute
+
+	// inventoryQueued is set while an inventory report is queued and has
+	// not started yet.
+	inventoryQueued atomic.Bool
+
+	// lastPackageChange is overridden in tests.
+	lastPackageChange = packages.LastChange
+)
+
+// EnqueueInventoryReport queues an inventory report, unless one is already
+// queued and not started yet. It does not block, so tasks can queue a report
+// of the changes they made.
+func EnqueueInventoryReport(ctx context.Context) {
+	if !inventoryQueued.CompareAndSwap(false, true) {
+		clog.Debugf(ctx, "Inventory report already queued.")
+		return
+	}
+	// The tasker runs one task at a time, queueing blocks until it is free.
+	go tasker.Enqueue(ctx, "Report OSInventory", func() {
+		inventoryQueued.Store(false)
+		client, release, err := SharedClient(ctx)
+		if err != nil {
+			clog.Errorf(ctx, "%v", err)
+			return
+		}
+		defer release()
+		client.ReportInventory(ctx)
+	})
+}
+
+// packagesChangedSince reports whether packages were installed, updated or
+// removed since t. Without any known package database changes are assumed.
+func packagesChangedSince(t time.Time) bool {
+	changed := lastPackageChange()
+	return changed.IsZero() || changed.After(t)
+}
+
+// packageChangeWatcher decides when package changes trigger an inventory
+// report.
+type packageChangeWatcher struct {
+	// seen is the last change time seen, pending is set while a change is
+	// not reported yet.
+	seen    time.Time
+	pending bool
+}
+
+// check reports whether inventory should be reported at now, given when
+// packages last changed and inventory was last reported. A change is only
+// reported once a check sees no further changes, so a transaction in
+// progress is not reported half way, and at most once per minInterval. A
+// change already picked up by a later report is not reported again.
+func (w *packageChangeWatcher) check(now, changed, lastReport time.Time, minInterval time.Duration) bool {
+	if changed.After(w.seen) {
+		w.seen = changed
+		w.pending = true
+		return false
+	}
+	if !w.pending || now.Sub(lastReport) < minInterval {
+		return false
+	}
+	w.pending = false
+	return !lastReport.After(w.seen)
+}
+
+// WatchPackageChanges calls report when packages are installed, updated or
+// removed, see packages.LastChange, rather than waiting for the next
+// inventory interval. Reports are at most once per
+// agentconfig.InventoryMinInterval, counting any other inventory report. It
+// returns when ctx is done.
+func WatchPackageChanges(ctx context.Context, report func()) {
+	w := &packageChangeWatcher{seen: lastPackageChange()}
+	ticker := time.NewTicker(packageChangePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if w.check(now, lastPackageChange(), time.Unix(0, lastInventoryReport.Load()), agentconfig.InventoryMinInterval()) {
+				clog.Infof(ctx, "Packages changed at %s, reporting inventory.", w.seen.UTC().Format(time.RFC3339))
+				report()
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPackageChangeWatcher(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return start.Add(time.Duration(m) * time.Minute) }
+	tests := []struct {
+		desc       string
+		changes    []int
+		lastReport int
+		// want is the minute of the checks from minute 1 to 10 that report.
+		want []int
+	}{
+		{"no changes", nil, 0, nil},
+		{"change reported once settled", []int{2}, -60, []int{3}},
+		{"transaction in progress", []int{2, 3, 4}, -60, []int{5}},
+		{"rate limited", []int{2}, 0, []int{5}},
+		{"picked up by a later report", []int{2}, 3, nil},
+		{"changes after a report", []int{2, 7}, -60, []int{3, 8}},
+	}
+	for _, tt := range tests {
+		w := &packageChangeWatcher{seen: start}
+		changed := start
+		var got []int
+		for m := 1; m <= 10; m++ {
+			for _, c := range tt.changes {
+				if c == m {
+					changed = at(m)
+				}
+			}
+			if w.check(at(m), changed, at(tt.lastReport), 5*time.Minute) {
+				got = append(got, m)
+				tt.lastReport = m
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: reported at minutes %v, want %v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestPackagesChangedSince(t *testing.T) {
+	defer func(f func() time.Time) { lastPackageChange = f }(lastPackageChange)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		desc    string
+		changed time.Time
+		want    bool
+	}{
+		{"changed during the task", start.Add(time.Minute), true},
+		{"unchanged", start.Add(-time.Minute), false},
+		{"no package database", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		lastPackageChange = func() time.Time { return tt.changed }
+		if got := packagesChangedSince(start); got != tt.want {
+			t.Errorf("%s: packagesChangedSince() = %t, want %t", tt.desc, got, tt.want)
+		}
+	}
+}
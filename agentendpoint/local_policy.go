@@ -0,0 +1,521 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/config"
+	"github.com/GoogleCloudPlatform/osconfig/schema"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+// protojsonPosRe matches the prefix of protojson errors, which randomly use
+// a non-breaking space.
+var protojsonPosRe = regexp.MustCompile(`^proto:[\s\x{a0}]*(\(line \d+:\d+\):[\s\x{a0}]*)?`)
+
+// localPolicySchema is the published JSON schema of local policy files.
+//
+//go:embed local_policy.schema.json
+var localPolicySchema []byte
+
+// localPolicy is the file format of a local OSPolicy, in YAML or JSON, see
+// local_policy.schema.json. Resources use the OS Config API JSON format, or a
+// local field for the resource types only implemented by the agent:
+//
+//	{
+//	  "id": "policy",
+//	  "mode": "ENFORCEMENT",
+//	  "resources": [
+//	    {"id": "pkg", "pkg": {"desiredState": "INSTALLED", "apt": {"name": "nginx"}}},
+//	    {"id": "hosts", "local": {"hostEntry": {"ip": "10.0.0.2", "hostnames": ["db"]}}}
+//	  ],
+//	  "validation": {"script": "nginx -t && exit 100", "interpreter": "SHELL"}
+//	}
+//
+// The optional validation is an ExecResource Exec in the API JSON format that
+// runs after resources of the policy were enforced, see
+// config.RunPolicyValidation. If it fails the whole policy is non-compliant
+// and the enforced resources are rolled back.
+//
+// Instead of resources a policy may have resource groups, as in the API the
+// resources of the first group with an inventory filter matching the host, or
+// no filters, are applied, see localInventoryFilter:
+//
+//	"resourceGroups": [
+//	  {"inventoryFilters": [{"osShortName": "debian", "osVersion": "12*"}], "resources": [...]},
+//	  {"inventoryFilters": [{"osShortName": "rhel"}], "resources": [...]}
+//	],
+//	"allowNoResourceGroupMatch": true,
+//	"noResourceGroupMatch": "NOT_APPLICABLE"
+//
+// When no group matches the policy is non-compliant, unless
+// allowNoResourceGroupMatch is set, then it is reported as noResourceGroupMatch
+// says, COMPLIANT, the default, or NOT_APPLICABLE.
+type localPolicy struct {
+	ID                        string               `json:"id"`
+	Mode                      string               `json:"mode"`
+	Resources                 []json.RawMessage    `json:"resources"`
+	ResourceGroups            []localResourceGroup `json:"resourceGroups"`
+	AllowNoResourceGroupMatch bool                 `json:"allowNoResourceGroupMatch"`
+	NoResourceGroupMatch      string               `json:"noResourceGroupMatch"`
+	Validation                json.RawMessage      `json:"validation"`
+}
+
+type localResourceGroup struct {
+	InventoryFilters []localInventoryFilter `json:"inventoryFilters"`
+	Resources        []json.RawMessage      `json:"resources"`
+}
+
+// noResourceGroupMatchID is the resource id reported for a policy without
+// a resource group matching the host.
+const noResourceGroupMatchID = "<no resource group match>"
+
+// parsedLocalPolicies are the policies of a local policy file, see
+// parseLocalPolicies.
+type parsedLocalPolicies struct {
+	policies []*agentendpointpb.ApplyConfigTask_OSPolicy
+	// local are the local resources keyed by localResourceKey.
+	local map[string]*config.LocalResource
+	// exitCodes are the exit codes of exec resources keyed by
+	// localResourceKey.
+	exitCodes map[string]*config.ExecExitCodes
+	// fileChecksums are the checksum settings of file resources keyed by
+	// localResourceKey.
+	fileChecksums map[string]*config.FileChecksum
+	// packageOptions are the options of pkg resources keyed by
+	// localResourceKey.
+	packageOptions map[string]*config.PackageOptions
+	// validations are keyed by policy id.
+	validations map[string]*agentendpointpb.OSPolicy_Resource_ExecResource_Exec
+	// noGroupMatch is the state to report for policies without a resource
+	// group matching the host, keyed by policy id.
+	noGroupMatch map[string]agentendpointpb.OSPolicyComplianceState
+}
+
+// ErrInvalidLocalPolicy is returned by ApplyLocalPolicies for a policy file
+// that can not be read as policies.
+var ErrInvalidLocalPolicy = errors.New("invalid local policy file")
+
+// Output formats for WriteLocalPolicyResults.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type localPolicyResource struct {
+	ID             string                 `json:"id"`
+	Local          *config.LocalResource  `json:"local"`
+	ExitCodes      *config.ExecExitCodes  `json:"exitCodes"`
+	Checksum       *config.FileChecksum   `json:"fileChecksum"`
+	PackageOptions *config.PackageOptions `json:"packageOptions"`
+}
+
+// localOnlyResourceFields are the fields of localPolicyResource that are not
+// part of the API resource.
+var localOnlyResourceFields = []string{"exitCodes", "fileChecksum", "packageOptions"}
+
+func localResourceKey(policyID, resourceID string) string {
+	return policyID + "/" + resourceID
+}
+
+// readLocalPolicies reads a YAML or JSON local policy file, validated
+// against localPolicySchema, and returns it as JSON. Errors point to the
+// line and column of the invalid value.
+func readLocalPolicies(data []byte) ([]byte, error) {
+	root, err := schema.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	s, err := schema.Load(localPolicySchema)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Validate(root); err != nil {
+		return nil, err
+	}
+
+	policies := []*yaml.Node{root}
+	if root.Kind == yaml.SequenceNode {
+		policies = root.Content
+	}
+	for i, p := range policies {
+		path := "$"
+		if root.Kind == yaml.SequenceNode {
+			path = fmt.Sprintf("$[%d]", i)
+		}
+		if err := validateAPIFields(p, path); err != nil {
+			return nil, err
+		}
+	}
+	return schema.ToJSON(root)
+}
+
+// validateAPIFields checks the resources and validation of a policy that use
+// the OS Config API format, which the schema leaves to protojson, so their
+// errors also point to the invalid value.
+func validateAPIFields(policy *yaml.Node, path string) error {
+	check := func(n *yaml.Node, path string, m proto.Message) error {
+		data, err := schema.ToJSON(n)
+		if err != nil {
+			return err
+		}
+		if err := protojson.Unmarshal(data, m); err != nil {
+			// The position protojson reports is in the converted JSON, not
+			// the file.
+			return schema.Errorf(n, path, "%s", protojsonPosRe.ReplaceAllString(err.Error(), ""))
+		}
+		return nil
+	}
+	checkResources := func(resources *yaml.Node, path string) error {
+		for i, r := range resources.Content {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			if mappingValue(r, "local") != nil {
+				continue
+			}
+			for _, k := range localOnlyResourceFields {
+				r = withoutKey(r, k)
+			}
+			if err := check(r, p, &agentendpointpb.OSPolicy_Resource{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if r := mappingValue(policy, "resources"); r != nil {
+		if err := checkResources(r, path+".resources"); err != nil {
+			return err
+		}
+	}
+	if groups := mappingValue(policy, "resourceGroups"); groups != nil {
+		for i, g := range groups.Content {
+			if r := mappingValue(g, "resources"); r != nil {
+				if err := checkResources(r, fmt.Sprintf("%s.resourceGroups[%d].resources", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if v := mappingValue(policy, "validation"); v != nil {
+		return check(v, path+".validation", &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{})
+	}
+	return nil
+}
+
+// mappingValue returns the value of key in the mapping n, or nil.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			v := n.Content[i+1]
+			for v.Kind == yaml.AliasNode && v.Alias != nil {
+				v = v.Alias
+			}
+			return v
+		}
+	}
+	return nil
+}
+
+// withoutKey returns a copy of the mapping n without key.
+func withoutKey(n *yaml.Node, key string) *yaml.Node {
+	if mappingValue(n, key) == nil {
+		return n
+	}
+	c := *n
+	c.Content = nil
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value != key {
+			c.Content = append(c.Content, n.Content[i], n.Content[i+1])
+		}
+	}
+	return &c
+}
+
+// parseLocalPolicies parses a local policy file holding a policy or a list
+// of policies.
+func parseLocalPolicies(ctx context.Context, data []byte) (*parsedLocalPolicies, error) {
+	data, err := readLocalPolicies(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var lps []localPolicy
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &lps); err != nil {
+			return nil, err
+		}
+	} else {
+		var lp localPolicy
+		if err := json.Unmarshal(data, &lp); err != nil {
+			return nil, err
+		}
+		lps = append(lps, lp)
+	}
+
+	parsed := &parsedLocalPolicies{
+		local:          map[string]*config.LocalResource{},
+		exitCodes:      map[string]*config.ExecExitCodes{},
+		fileChecksums:  map[string]*config.FileChecksum{},
+		packageOptions: map[string]*config.PackageOptions{},
+		validations:    map[string]*agentendpointpb.OSPolicy_Resource_ExecResource_Exec{},
+		noGroupMatch:   map[string]agentendpointpb.OSPolicyComplianceState{},
+	}
+	facts := &hostFacts{}
+	seen := map[string]bool{}
+	for _, lp := range lps {
+		if lp.ID == "" {
+			return nil, errors.New("policy id is required")
+		}
+		if seen[lp.ID] {
+			return nil, fmt.Errorf("duplicate policy id %q", lp.ID)
+		}
+		seen[lp.ID] = true
+
+		mode := agentendpointpb.OSPolicy_ENFORCEMENT
+		if lp.Mode != "" {
+			m, ok := agentendpointpb.OSPolicy_Mode_value[strings.ToUpper(lp.Mode)]
+			if !ok {
+				return nil, fmt.Errorf("policy %q: unknown mode %q", lp.ID, lp.Mode)
+			}
+			mode = agentendpointpb.OSPolicy_Mode(m)
+		}
+		p := &agentendpointpb.ApplyConfigTask_OSPolicy{Id: lp.ID, Mode: mode, OsPolicyAssignment: "local"}
+		parsed.policies = append(parsed.policies, p)
+
+		resources := lp.Resources
+		if len(lp.ResourceGroups) > 0 {
+			if len(lp.Resources) > 0 {
+				return nil, fmt.Errorf("policy %q: only one of resources and resourceGroups may be set", lp.ID)
+			}
+			group, err := matchResourceGroup(ctx, facts, lp.ResourceGroups)
+			if err != nil {
+				return nil, fmt.Errorf("policy %q: %v", lp.ID, err)
+			}
+			if group == nil {
+				state, err := noResourceGroupMatchState(lp)
+				if err != nil {
+					return nil, fmt.Errorf("policy %q: %v", lp.ID, err)
+				}
+				parsed.noGroupMatch[lp.ID] = state
+				continue
+			}
+			resources = group.Resources
+		}
+
+		resourceIDs := map[string]bool{}
+		for _, raw := range resources {
+			var lr localPolicyResource
+			if err := json.Unmarshal(raw, &lr); err != nil {
+				return nil, fmt.Errorf("policy %q: %v", lp.ID, err)
+			}
+			r := &agentendpointpb.OSPolicy_Resource{Id: lr.ID}
+			if lr.ExitCodes != nil {
+				parsed.exitCodes[localResourceKey(lp.ID, lr.ID)] = lr.ExitCodes
+			}
+			if lr.Checksum != nil {
+				parsed.fileChecksums[localResourceKey(lp.ID, lr.ID)] = lr.Checksum
+			}
+			if lr.PackageOptions != nil {
+				parsed.packageOptions[localResourceKey(lp.ID, lr.ID)] = lr.PackageOptions
+			}
+			if lr.ExitCodes != nil || lr.Checksum != nil || lr.PackageOptions != nil {
+				var fields map[string]json.RawMessage
+				if err := json.Unmarshal(raw, &fields); err != nil {
+					return nil, fmt.Errorf("policy %q: %v", lp.ID, err)
+				}
+				for _, k := range localOnlyResourceFields {
+					delete(fields, k)
+				}
+				if raw, err = json.Marshal(fields); err != nil {
+					return nil, fmt.Errorf("policy %q: %v", lp.ID, err)
+				}
+			}
+			if lr.Local != nil {
+				parsed.local[localResourceKey(lp.ID, lr.ID)] = lr.Local
+			} else if err := protojson.Unmarshal(raw, r); err != nil {
+				return nil, fmt.Errorf("policy %q resource %q: %v", lp.ID, lr.ID, err)
+			}
+			if r.GetId() == "" {
+				return nil, fmt.Errorf("policy %q: resource id is required", lp.ID)
+			}
+			if resourceIDs[r.GetId()] {
+				return nil, fmt.Errorf("policy %q: duplicate resource id %q", lp.ID, r.GetId())
+			}
+			resourceIDs[r.GetId()] = true
+			p.Resources = append(p.Resources, r)
+		}
+
+		if len(lp.Validation) > 0 {
+			v := &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{}
+			if err := protojson.Unmarshal(lp.Validation, v); err != nil {
+				return nil, fmt.Errorf("policy %q validation: %v", lp.ID, err)
+			}
+			if v.GetSource() == nil {
+				return nil, fmt.Errorf("policy %q validation: script or file is required", lp.ID)
+			}
+			parsed.validations[lp.ID] = v
+		}
+	}
+	return parsed, nil
+}
+
+// noResourceGroupMatchState is the state reported for a policy without a
+// resource group matching the host.
+func noResourceGroupMatchState(lp localPolicy) (agentendpointpb.OSPolicyComplianceState, error) {
+	if !lp.AllowNoResourceGroupMatch {
+		return agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT, nil
+	}
+	switch strings.ToUpper(lp.NoResourceGroupMatch) {
+	case "", "COMPLIANT":
+		return agentendpointpb.OSPolicyComplianceState_COMPLIANT, nil
+	case "NOT_APPLICABLE":
+		return agentendpointpb.OSPolicyComplianceState_NO_OS_POLICIES_APPLICABLE, nil
+	default:
+		return 0, fmt.Errorf("unknown noResourceGroupMatch %q, must be COMPLIANT or NOT_APPLICABLE", lp.NoResourceGroupMatch)
+	}
+}
+
+// noResourceGroupMatchResult reports state for a policy without a resource
+// group matching the host in pResult.
+func noResourceGroupMatchResult(pResult *agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult, state agentendpointpb.OSPolicyComplianceState) {
+	rc := &agentendpointpb.OSPolicyResourceCompliance{OsPolicyResourceId: noResourceGroupMatchID, State: state}
+	if state == agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT {
+		rc.ConfigSteps = []*agentendpointpb.OSPolicyResourceConfigStep{{
+			Type:         agentendpointpb.OSPolicyResourceConfigStep_VALIDATION,
+			Outcome:      agentendpointpb.OSPolicyResourceConfigStep_FAILED,
+			ErrorMessage: "no resource group matches this instance and allowNoResourceGroupMatch is not set",
+		}}
+	}
+	pResult.OsPolicyResourceCompliances = []*agentendpointpb.OSPolicyResourceCompliance{rc}
+}
+
+// ApplyLocalPolicies applies the policies in a local policy file once,
+// without contacting the API, and returns the results. With imageBuild the
+// compliant resources are recorded so the first config task on instances
+// created from the image skips them.
+func ApplyLocalPolicies(ctx context.Context, path string, imageBuild bool) ([]*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parseLocalPolicies(ctx, data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error parsing %q: %v", ErrInvalidLocalPolicy, path, err)
+	}
+
+	c := &configTask{
+		Task:           &applyConfigTask{&agentendpointpb.ApplyConfigTask{OsPolicies: parsed.policies}},
+		localResources: parsed.local,
+		execExitCodes:  parsed.exitCodes,
+		fileChecksums:  parsed.fileChecksums,
+		packageOptions: parsed.packageOptions,
+		validations:    parsed.validations,
+	}
+	clog.Infof(ctx, "Applying local policies from %q.", path)
+	c.generateBaseResults()
+	defer c.cleanup(ctx)
+	c.applyPolicies(ctx)
+	for _, pResult := range c.results {
+		if state, ok := parsed.noGroupMatch[pResult.GetOsPolicyId()]; ok {
+			clog.Infof(ctx, "No resource group of policy %q matches this instance, state: %s", pResult.GetOsPolicyId(), state)
+			noResourceGroupMatchResult(pResult, state)
+		}
+	}
+	if imageBuild {
+		if err := c.writeImageBuildMarker(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c.results, nil
+}
+
+// LocalPoliciesCompliant reports whether all resources are compliant, or
+// not applicable as no resource group of their policy matches.
+func LocalPoliciesCompliant(results []*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult) bool {
+	for _, p := range results {
+		for _, rc := range p.GetOsPolicyResourceCompliances() {
+			switch rc.GetState() {
+			case agentendpointpb.OSPolicyComplianceState_COMPLIANT, agentendpointpb.OSPolicyComplianceState_NO_OS_POLICIES_APPLICABLE:
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// WriteLocalPolicyResults writes the results in format, as a table of the
+// compliance state and steps of each resource for text, or as an
+// ApplyConfigTaskOutput in the API JSON format for json.
+func WriteLocalPolicyResults(w io.Writer, results []*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult, format string) error {
+	switch format {
+	case FormatText:
+		return writeLocalPolicyTable(w, results)
+	case FormatJSON:
+		out := &agentendpointpb.ApplyConfigTaskOutput{State: agentendpointpb.ApplyConfigTaskOutput_SUCCEEDED, OsPolicyResults: results}
+		b, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(out)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func writeLocalPolicyTable(w io.Writer, results []*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "POLICY\tRESOURCE\tSTATE\tSTEPS")
+	var errs []string
+	for _, p := range results {
+		for _, rc := range p.GetOsPolicyResourceCompliances() {
+			var steps []string
+			for _, s := range rc.GetConfigSteps() {
+				steps = append(steps, fmt.Sprintf("%s:%s", s.GetType(), s.GetOutcome()))
+				if s.GetErrorMessage() != "" {
+					errs = append(errs, fmt.Sprintf("%s/%s: %s", p.GetOsPolicyId(), rc.GetOsPolicyResourceId(), s.GetErrorMessage()))
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.GetOsPolicyId(), rc.GetOsPolicyResourceId(), rc.GetState(), strings.Join(steps, ","))
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, e := range errs {
+		fmt.Fprintln(w, e)
+	}
+	return nil
+}
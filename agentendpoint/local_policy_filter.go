@@ -0,0 +1,229 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+// localInventoryFilter selects the resource group of a local policy from
+// facts about the host. A filter matches when all of its set fields match:
+//
+//   - osShortName and osVersion, osVersion may use shell patterns such as 12*
+//   - architecture, such as x86_64 or arm64
+//   - kernelVersion, comma separated constraints on the kernel release such as
+//     ">=5.15, <6.2", versions are compared by their numeric components
+//   - package, the name of a package that must be installed
+type localInventoryFilter struct {
+	OSShortName   string `json:"osShortName"`
+	OSVersion     string `json:"osVersion"`
+	Architecture  string `json:"architecture"`
+	KernelVersion string `json:"kernelVersion"`
+	Package       string `json:"package"`
+}
+
+var (
+	// localOSInfo and localInstalledPackages are overridden in tests.
+	localOSInfo            = osinfo.Get
+	localInstalledPackages = func(ctx context.Context) (*packages.Packages, error) {
+		return packages.GetInstalledPackages(ctx, packages.Collectors{"rpm": true, "deb": true, "googet": true, "cos": true})
+	}
+)
+
+// hostFacts lazily gets the facts inventory filters are matched against,
+// once for all the policies of a file.
+type hostFacts struct {
+	oi   *osinfo.OSInfo
+	pkgs map[string]bool
+}
+
+func (h *hostFacts) osInfo() (*osinfo.OSInfo, error) {
+	if h.oi == nil {
+		oi, err := localOSInfo()
+		if err != nil {
+			return nil, fmt.Errorf("error getting OS info to match resource groups: %v", err)
+		}
+		h.oi = oi
+	}
+	return h.oi, nil
+}
+
+func (h *hostFacts) packageInstalled(ctx context.Context, name string) (bool, error) {
+	if h.pkgs == nil {
+		pkgs, err := localInstalledPackages(ctx)
+		if err != nil {
+			return false, fmt.Errorf("error listing installed packages to match resource groups: %v", err)
+		}
+		h.pkgs = map[string]bool{}
+		for _, list := range [][]*packages.PkgInfo{pkgs.Rpm, pkgs.Deb, pkgs.GooGet, pkgs.COS} {
+			for _, pkg := range list {
+				h.pkgs[pkg.Name] = true
+			}
+		}
+	}
+	return h.pkgs[name], nil
+}
+
+// matchResourceGroup returns the first resource group with an inventory
+// filter matching the host, or without filters, nil if there is none.
+func matchResourceGroup(ctx context.Context, facts *hostFacts, groups []localResourceGroup) (*localResourceGroup, error) {
+	for i, g := range groups {
+		if len(g.InventoryFilters) == 0 {
+			return &groups[i], nil
+		}
+		for _, f := range g.InventoryFilters {
+			ok, err := f.matches(ctx, facts)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return &groups[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (f localInventoryFilter) matches(ctx context.Context, facts *hostFacts) (bool, error) {
+	if f == (localInventoryFilter{}) {
+		return false, errors.New("resource group inventory filter is empty")
+	}
+	if f.OSVersion != "" && f.OSShortName == "" {
+		return false, errors.New("resource group inventory filter osVersion requires osShortName")
+	}
+
+	if f.OSShortName != "" || f.Architecture != "" || f.KernelVersion != "" {
+		oi, err := facts.osInfo()
+		if err != nil {
+			return false, err
+		}
+		if f.OSShortName != "" && !strings.EqualFold(f.OSShortName, oi.ShortName) {
+			return false, nil
+		}
+		if f.OSVersion != "" {
+			ok, err := path.Match(f.OSVersion, oi.Version)
+			if err != nil {
+				return false, fmt.Errorf("resource group inventory filter osVersion %q: %v", f.OSVersion, err)
+			}
+			if !ok {
+				return false, nil
+			}
+		}
+		if f.Architecture != "" && osinfo.Architecture(f.Architecture) != osinfo.Architecture(oi.Architecture) {
+			return false, nil
+		}
+		if f.KernelVersion != "" {
+			ok, err := kernelVersionMatches(oi.KernelRelease, f.KernelVersion)
+			if err != nil {
+				return false, fmt.Errorf("resource group inventory filter kernelVersion %q: %v", f.KernelVersion, err)
+			}
+			if !ok {
+				return false, nil
+			}
+		}
+	}
+
+	if f.Package != "" {
+		return facts.packageInstalled(ctx, f.Package)
+	}
+	return true, nil
+}
+
+// kernelVersionMatches reports whether the kernel release, such as
+// 6.1.0-18-cloud-amd64, satisfies all the comma separated constraints, each an
+// operator, one of >=, <=, >, <, = or none for =, and a version.
+func kernelVersionMatches(release, constraints string) (bool, error) {
+	have, err := versionParts(release)
+	if err != nil {
+		return false, fmt.Errorf("kernel release %q: %v", release, err)
+	}
+	for _, c := range strings.Split(constraints, ",") {
+		c = strings.TrimSpace(c)
+		op := c[:len(c)-len(strings.TrimLeft(c, "<>="))]
+		want, err := versionParts(strings.TrimSpace(c[len(op):]))
+		if err != nil {
+			return false, err
+		}
+		cmp := compareVersionParts(have, want)
+		var ok bool
+		switch op {
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		case "", "=", "==":
+			ok = cmp == 0
+		default:
+			return false, fmt.Errorf("unknown operator %q", op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// versionParts returns the numeric components of the leading dotted version
+// of v, 6.1.0-18-cloud-amd64 is [6 1 0].
+func versionParts(v string) ([]int, error) {
+	end := strings.IndexFunc(v, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if end >= 0 {
+		v = v[:end]
+	}
+	if v == "" {
+		return nil, errors.New("no version")
+	}
+	var parts []int
+	for _, p := range strings.Split(strings.Trim(v, "."), ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", v)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// compareVersionParts compares versions, missing components count as 0.
+func compareVersionParts(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+func TestKernelVersionMatches(t *testing.T) {
+	tests := []struct {
+		release     string
+		constraints string
+		want        bool
+		wantErr     bool
+	}{
+		{"6.1.0-18-cloud-amd64", ">=5.15, <6.2", true, false},
+		{"6.1.0-18-cloud-amd64", ">=6.2", false, false},
+		{"6.1.0-18-cloud-amd64", "6.1", true, false},
+		{"6.1.0-18-cloud-amd64", "=6.1.1", false, false},
+		{"5.15.0-1051-gcp", "> 5.4,<= 5.15", true, false},
+		{"5.4.0", "<5.4", false, false},
+		{"6.1.0", "~6.1", false, true},
+		{"6.1.0", ">=x", false, true},
+		{"6.1.0", ">=6.1,", false, true},
+		{"unknown", ">=6.1", false, true},
+	}
+	for _, tt := range tests {
+		got, err := kernelVersionMatches(tt.release, tt.constraints)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("kernelVersionMatches(%q, %q): got err %v, want err %t", tt.release, tt.constraints, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("kernelVersionMatches(%q, %q): got %t, want %t", tt.release, tt.constraints, got, tt.want)
+		}
+	}
+}
+
+func TestLocalInventoryFilterMatches(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func() (*osinfo.OSInfo, error)) { localOSInfo = f }(localOSInfo)
+	defer func(f func(context.Context) (*packages.Packages, error)) { localInstalledPackages = f }(localInstalledPackages)
+	localOSInfo = func() (*osinfo.OSInfo, error) {
+		return &osinfo.OSInfo{ShortName: "debian", Version: "12.4", Architecture: "amd64", KernelRelease: "6.1.0-18-cloud-amd64"}, nil
+	}
+	var listed int
+	localInstalledPackages = func(context.Context) (*packages.Packages, error) {
+		listed++
+		return &packages.Packages{Deb: []*packages.PkgInfo{{Name: "nginx"}}}, nil
+	}
+
+	tests := []struct {
+		desc    string
+		filter  localInventoryFilter
+		want    bool
+		wantErr bool
+	}{
+		{"os", localInventoryFilter{OSShortName: "debian", OSVersion: "12*"}, true, false},
+		{"other os", localInventoryFilter{OSShortName: "rhel"}, false, false},
+		{"architecture", localInventoryFilter{Architecture: "x86_64"}, true, false},
+		{"other architecture", localInventoryFilter{Architecture: "arm64"}, false, false},
+		{"kernel version", localInventoryFilter{KernelVersion: ">=6.1, <6.2"}, true, false},
+		{"other kernel version", localInventoryFilter{KernelVersion: ">=6.2"}, false, false},
+		{"package", localInventoryFilter{Package: "nginx"}, true, false},
+		{"missing package", localInventoryFilter{Package: "apache2"}, false, false},
+		{"all facts", localInventoryFilter{OSShortName: "debian", Architecture: "amd64", KernelVersion: ">=6", Package: "nginx"}, true, false},
+		{"one fact differs", localInventoryFilter{OSShortName: "debian", Architecture: "amd64", Package: "apache2"}, false, false},
+		{"empty", localInventoryFilter{}, false, true},
+		{"version without os", localInventoryFilter{OSVersion: "12"}, false, true},
+		{"bad kernel version", localInventoryFilter{KernelVersion: "~6"}, false, true},
+	}
+	facts := &hostFacts{}
+	for _, tt := range tests {
+		got, err := tt.filter.matches(ctx, facts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got err %v, want err %t", tt.desc, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.desc, got, tt.want)
+		}
+	}
+	if listed != 1 {
+		t.Errorf("installed packages listed %d times, want once", listed)
+	}
+
+	localInstalledPackages = func(context.Context) (*packages.Packages, error) { return nil, errors.New("rpm failed") }
+	if _, err := (localInventoryFilter{Package: "nginx"}).matches(ctx, &hostFacts{}); err == nil {
+		t.Error("want an error when installed packages can't be listed")
+	}
+}
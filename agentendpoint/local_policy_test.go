@@ -0,0 +1,405 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/config"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/schema"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+func TestParseLocalPolicies(t *testing.T) {
+	pkg := &agentendpointpb.OSPolicy_Resource{
+		Id: "pkg",
+		ResourceType: &agentendpointpb.OSPolicy_Resource_Pkg{
+			Pkg: &agentendpointpb.OSPolicy_Resource_PackageResource{
+				DesiredState: agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED,
+				SystemPackage: &agentendpointpb.OSPolicy_Resource_PackageResource_Apt{
+					Apt: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: "nginx"},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		desc         string
+		data         string
+		wantPolicies []*agentendpointpb.ApplyConfigTask_OSPolicy
+		wantLocal    map[string]*config.LocalResource
+		wantErr      bool
+	}{
+		{
+			"single policy",
+			`{"id": "p1", "resources": [
+			  {"id": "pkg", "pkg": {"desiredState": "INSTALLED", "apt": {"name": "nginx"}}},
+			  {"id": "hosts", "local": {"hostEntry": {"ip": "10.0.0.2", "hostnames": ["db"]}}}
+			]}`,
+			[]*agentendpointpb.ApplyConfigTask_OSPolicy{{
+				Id:                 "p1",
+				Mode:               agentendpointpb.OSPolicy_ENFORCEMENT,
+				OsPolicyAssignment: "local",
+				Resources:          []*agentendpointpb.OSPolicy_Resource{pkg, {Id: "hosts"}},
+			}},
+			map[string]*config.LocalResource{"p1/hosts": {HostEntry: &config.HostEntryResource{IP: "10.0.0.2", Hostnames: []string{"db"}}}},
+			false,
+		},
+		{
+			"list of policies",
+			`[{"id": "p1", "mode": "validation"}, {"id": "p2"}]`,
+			[]*agentendpointpb.ApplyConfigTask_OSPolicy{
+				{Id: "p1", Mode: agentendpointpb.OSPolicy_VALIDATION, OsPolicyAssignment: "local"},
+				{Id: "p2", Mode: agentendpointpb.OSPolicy_ENFORCEMENT, OsPolicyAssignment: "local"},
+			},
+			map[string]*config.LocalResource{},
+			false,
+		},
+		{
+			"yaml",
+			`
+- id: p1
+  mode: validation
+  resources:
+  - id: pkg
+    pkg:
+      desiredState: INSTALLED
+      apt:
+        name: nginx
+- id: p2
+`,
+			[]*agentendpointpb.ApplyConfigTask_OSPolicy{
+				{Id: "p1", Mode: agentendpointpb.OSPolicy_VALIDATION, OsPolicyAssignment: "local", Resources: []*agentendpointpb.OSPolicy_Resource{pkg}},
+				{Id: "p2", Mode: agentendpointpb.OSPolicy_ENFORCEMENT, OsPolicyAssignment: "local"},
+			},
+			map[string]*config.LocalResource{},
+			false,
+		},
+		{"invalid json", `{"id": `, nil, nil, true},
+		{"invalid yaml", "id: p1\nresources: [\n", nil, nil, true},
+		{"unknown policy field", `{"id": "p1", "resorces": []}`, nil, nil, true},
+		{"unknown local resource", `{"id": "p1", "resources": [{"id": "r", "local": {"hostEntries": {}}}]}`, nil, nil, true},
+		{"missing policy id", `{"resources": []}`, nil, nil, true},
+		{"duplicate policy id", `[{"id": "p1"}, {"id": "p1"}]`, nil, nil, true},
+		{"unknown mode", `{"id": "p1", "mode": "sometimes"}`, nil, nil, true},
+		{"missing resource id", `{"id": "p1", "resources": [{"pkg": {}}]}`, nil, nil, true},
+		{"duplicate resource id", `{"id": "p1", "resources": [{"id": "r", "pkg": {}}, {"id": "r", "local": {}}]}`, nil, nil, true},
+		{"unknown resource field", `{"id": "p1", "resources": [{"id": "r", "package": {}}]}`, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			parsed, err := parseLocalPolicies(context.Background(), []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLocalPolicies: got err %v, want err %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tt.wantPolicies, parsed.policies, protocmp.Transform()); diff != "" {
+				t.Errorf("policies did not match expectation: (-want +got)\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantLocal, parsed.local); diff != "" {
+				t.Errorf("local resources did not match expectation: (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseLocalPolicyExitCodes(t *testing.T) {
+	parsed, err := parseLocalPolicies(context.Background(), []byte(`{"id": "p1", "resources": [
+	  {"id": "check", "exitCodes": {"compliant": [0], "nonCompliant": [1], "other": "error"},
+	   "exec": {"validate": {"script": "grep -q foo /etc/bar", "interpreter": "SHELL"}}}
+	]}`))
+	if err != nil {
+		t.Fatalf("parseLocalPolicies: %v", err)
+	}
+	want := map[string]*config.ExecExitCodes{"p1/check": {Compliant: []int{0}, NonCompliant: []int{1}, Other: "error"}}
+	if diff := cmp.Diff(want, parsed.exitCodes); diff != "" {
+		t.Errorf("exit codes did not match expectation: (-want +got)\n%s", diff)
+	}
+	if got := parsed.policies[0].GetResources()[0].GetExec().GetValidate().GetScript(); got != "grep -q foo /etc/bar" {
+		t.Errorf("unexpected validate script %q", got)
+	}
+
+	if _, err := parseLocalPolicies(context.Background(), []byte(`{"id": "p1", "resources": [{"id": "r", "exitCodes": {"compliant": ["0"]}, "exec": {}}]}`)); err == nil {
+		t.Error("parseLocalPolicies: did not get expected error for invalid exit codes")
+	}
+}
+
+func TestParseLocalPolicyFileChecksum(t *testing.T) {
+	parsed, err := parseLocalPolicies(context.Background(), []byte(`{"id": "p1", "resources": [
+	  {"id": "image", "fileChecksum": {"algorithm": "sha512", "fastPath": true},
+	   "file": {"path": "/srv/image.raw", "state": "CONTENTS_MATCH", "file": {"localPath": "/mnt/image.raw"}}}
+	]}`))
+	if err != nil {
+		t.Fatalf("parseLocalPolicies: %v", err)
+	}
+	want := map[string]*config.FileChecksum{"p1/image": {Algorithm: "sha512", FastPath: true}}
+	if diff := cmp.Diff(want, parsed.fileChecksums); diff != "" {
+		t.Errorf("file checksums did not match expectation: (-want +got)\n%s", diff)
+	}
+	if got := parsed.policies[0].GetResources()[0].GetFile().GetPath(); got != "/srv/image.raw" {
+		t.Errorf("unexpected file path %q", got)
+	}
+}
+
+func TestParseLocalPolicyPackageOptions(t *testing.T) {
+	parsed, err := parseLocalPolicies(context.Background(), []byte(`{"id": "p1", "resources": [
+	  {"id": "curl", "packageOptions": {"allowDowngrade": true},
+	   "pkg": {"desiredState": "INSTALLED", "apt": {"name": "curl=7.74.0-1"}}}
+	]}`))
+	if err != nil {
+		t.Fatalf("parseLocalPolicies: %v", err)
+	}
+	want := map[string]*config.PackageOptions{"p1/curl": {AllowDowngrade: true}}
+	if diff := cmp.Diff(want, parsed.packageOptions); diff != "" {
+		t.Errorf("package options did not match expectation: (-want +got)\n%s", diff)
+	}
+	if got := parsed.policies[0].GetResources()[0].GetPkg().GetApt().GetName(); got != "curl=7.74.0-1" {
+		t.Errorf("unexpected package name %q", got)
+	}
+}
+
+func TestParseLocalPolicyValidation(t *testing.T) {
+	want := &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{
+		Source:      &agentendpointpb.OSPolicy_Resource_ExecResource_Exec_Script{Script: "nginx -t && exit 100"},
+		Interpreter: agentendpointpb.OSPolicy_Resource_ExecResource_Exec_SHELL,
+	}
+	parsed, err := parseLocalPolicies(context.Background(), []byte(`[
+	  {"id": "p1", "validation": {"script": "nginx -t && exit 100", "interpreter": "SHELL"}},
+	  {"id": "p2"}
+	]`))
+	if err != nil {
+		t.Fatalf("parseLocalPolicies: %v", err)
+	}
+	if diff := cmp.Diff(map[string]*agentendpointpb.OSPolicy_Resource_ExecResource_Exec{"p1": want}, parsed.validations, protocmp.Transform()); diff != "" {
+		t.Errorf("validations did not match expectation: (-want +got)\n%s", diff)
+	}
+
+	for _, data := range []string{
+		`{"id": "p1", "validation": {"interpreter": "SHELL"}}`,
+		`{"id": "p1", "validation": {"command": "true"}}`,
+	} {
+		if _, err := parseLocalPolicies(context.Background(), []byte(data)); err == nil {
+			t.Errorf("parseLocalPolicies(%s): want error", data)
+		}
+	}
+}
+
+func TestParseLocalPolicyResourceGroups(t *testing.T) {
+	defer func(f func() (*osinfo.OSInfo, error)) { localOSInfo = f }(localOSInfo)
+	localOSInfo = func() (*osinfo.OSInfo, error) { return &osinfo.OSInfo{ShortName: "debian", Version: "12.4"}, nil }
+
+	groups := `"resourceGroups": [
+	  {"inventoryFilters": [{"osShortName": "rhel"}, {"osShortName": "debian", "osVersion": "11*"}], "resources": [{"id": "old", "local": {}}]},
+	  {"inventoryFilters": [{"osShortName": "Debian", "osVersion": "12*"}], "resources": [{"id": "new", "local": {}}]},
+	  {"resources": [{"id": "any", "local": {}}]}
+	]`
+	noMatch := `"resourceGroups": [{"inventoryFilters": [{"osShortName": "windows"}], "resources": [{"id": "win", "local": {}}]}]`
+
+	tests := []struct {
+		desc          string
+		data          string
+		wantResources []string
+		wantState     agentendpointpb.OSPolicyComplianceState
+		wantErr       bool
+	}{
+		{"first matching group", `{"id": "p1", ` + groups + `}`, []string{"new"}, 0, false},
+		{"group without filters", `{"id": "p1", "resourceGroups": [{"resources": [{"id": "any", "local": {}}]}]}`, []string{"any"}, 0, false},
+		{"no match", `{"id": "p1", ` + noMatch + `}`, nil, agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT, false},
+		{"no match allowed", `{"id": "p1", "allowNoResourceGroupMatch": true, ` + noMatch + `}`, nil, agentendpointpb.OSPolicyComplianceState_COMPLIANT, false},
+		{"no match not applicable", `{"id": "p1", "allowNoResourceGroupMatch": true, "noResourceGroupMatch": "not_applicable", ` + noMatch + `}`, nil, agentendpointpb.OSPolicyComplianceState_NO_OS_POLICIES_APPLICABLE, false},
+		{"unknown no match state", `{"id": "p1", "allowNoResourceGroupMatch": true, "noResourceGroupMatch": "ignore", ` + noMatch + `}`, nil, 0, true},
+		{"resources and groups", `{"id": "p1", "resources": [{"id": "r", "local": {}}], ` + groups + `}`, nil, 0, true},
+		{"missing os short name", `{"id": "p1", "resourceGroups": [{"inventoryFilters": [{"osVersion": "12"}]}]}`, nil, 0, true},
+		{"bad os version pattern", `{"id": "p1", "resourceGroups": [{"inventoryFilters": [{"osShortName": "debian", "osVersion": "[12"}]}]}`, nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			parsed, err := parseLocalPolicies(context.Background(), []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLocalPolicies: got err %v, want err %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var ids []string
+			for _, r := range parsed.policies[0].GetResources() {
+				ids = append(ids, r.GetId())
+			}
+			if diff := cmp.Diff(tt.wantResources, ids); diff != "" {
+				t.Errorf("resources did not match expectation: (-want +got)\n%s", diff)
+			}
+			if state, ok := parsed.noGroupMatch["p1"]; ok != (tt.wantState != 0) || state != tt.wantState {
+				t.Errorf("no resource group match state: got %s (%t), want %s", state, ok, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestNoResourceGroupMatchResult(t *testing.T) {
+	for _, state := range []agentendpointpb.OSPolicyComplianceState{
+		agentendpointpb.OSPolicyComplianceState_COMPLIANT,
+		agentendpointpb.OSPolicyComplianceState_NO_OS_POLICIES_APPLICABLE,
+		agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT,
+	} {
+		pResult := &agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{OsPolicyId: "p1"}
+		noResourceGroupMatchResult(pResult, state)
+		results := []*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{pResult}
+		if got, want := LocalPoliciesCompliant(results), state != agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT; got != want {
+			t.Errorf("%s: LocalPoliciesCompliant: got %t, want %t", state, got, want)
+		}
+		if got := pResult.GetOsPolicyResourceCompliances()[0].GetState(); got != state {
+			t.Errorf("state: got %s, want %s", got, state)
+		}
+	}
+}
+
+var testLocalResults = []*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{{
+	OsPolicyId: "p1",
+	OsPolicyResourceCompliances: []*agentendpointpb.OSPolicyResourceCompliance{
+		{
+			OsPolicyResourceId: "pkg",
+			State:              agentendpointpb.OSPolicyComplianceState_COMPLIANT,
+			ConfigSteps: []*agentendpointpb.OSPolicyResourceConfigStep{
+				{Type: agentendpointpb.OSPolicyResourceConfigStep_VALIDATION, Outcome: agentendpointpb.OSPolicyResourceConfigStep_SUCCEEDED},
+			},
+		},
+		{
+			OsPolicyResourceId: "hosts",
+			State:              agentendpointpb.OSPolicyComplianceState_UNKNOWN,
+			ConfigSteps: []*agentendpointpb.OSPolicyResourceConfigStep{
+				{Type: agentendpointpb.OSPolicyResourceConfigStep_VALIDATION, Outcome: agentendpointpb.OSPolicyResourceConfigStep_FAILED, ErrorMessage: "bad ip"},
+			},
+		},
+	},
+}}
+
+func TestWriteLocalPolicyResultsText(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteLocalPolicyResults(&buf, testLocalResults, FormatText); err != nil {
+		t.Fatal(err)
+	}
+	want := "POLICY  RESOURCE  STATE      STEPS\n" +
+		"p1      pkg       COMPLIANT  VALIDATION:SUCCEEDED\n" +
+		"p1      hosts     UNKNOWN    VALIDATION:FAILED\n" +
+		"p1/hosts: bad ip\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("output did not match expectation: (-want +got)\n%s", diff)
+	}
+}
+
+func TestWriteLocalPolicyResultsJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteLocalPolicyResults(&buf, testLocalResults, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	got := &agentendpointpb.ApplyConfigTaskOutput{}
+	if err := protojson.Unmarshal(buf.Bytes(), got); err != nil {
+		t.Fatal(err)
+	}
+	want := &agentendpointpb.ApplyConfigTaskOutput{State: agentendpointpb.ApplyConfigTaskOutput_SUCCEEDED, OsPolicyResults: testLocalResults}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("output did not match expectation: (-want +got)\n%s", diff)
+	}
+
+	if err := WriteLocalPolicyResults(&buf, testLocalResults, "yaml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestLocalPoliciesCompliant(t *testing.T) {
+	if LocalPoliciesCompliant(testLocalResults) {
+		t.Error("LocalPoliciesCompliant: got true for an UNKNOWN resource, want false")
+	}
+	compliant := []*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{{
+		OsPolicyId: "p1",
+		OsPolicyResourceCompliances: []*agentendpointpb.OSPolicyResourceCompliance{
+			{OsPolicyResourceId: "pkg", State: agentendpointpb.OSPolicyComplianceState_COMPLIANT},
+		},
+	}}
+	if !LocalPoliciesCompliant(compliant) {
+		t.Error("LocalPoliciesCompliant: got false, want true")
+	}
+}
+
+func TestParseLocalPoliciesErrorPosition(t *testing.T) {
+	tests := []struct {
+		desc string
+		data string
+		want string
+	}{
+		{
+			"unknown field",
+			"id: p1\nresources:\n- id: pkg\n  pkg:\n    desiredState: INSTALLED\nvalidaton: {}\n",
+			"line 6, column 1: $.validaton: unknown field",
+		},
+		{
+			"wrong type",
+			`[{"id": "p1"}, {"id": "p2", "allowNoResourceGroupMatch": "yes"}]`,
+			`line 1, column 58: $[1].allowNoResourceGroupMatch: got string, want boolean`,
+		},
+		{
+			"api resource",
+			"id: p1\nresources:\n- id: ok\n  pkg: {desiredState: INSTALLED}\n- id: pkg\n  pkg:\n    desiredState: SOMETIMES\n",
+			"line 5, column 3: $.resources[1]: ",
+		},
+		{
+			"resource group",
+			"id: p1\nresourceGroups:\n- resources:\n  - pkg: {}\n",
+			`line 4, column 5: $.resourceGroups[0].resources[0]: missing required field "id"`,
+		},
+	}
+	for _, tt := range tests {
+		_, err := parseLocalPolicies(context.Background(), []byte(tt.data))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%s: parseLocalPolicies error = %v, want prefix %q", tt.desc, err, tt.want)
+		}
+	}
+}
+
+// The schema lists the local resource types, it must be updated with
+// config.LocalResource.
+func TestLocalPolicySchemaLocalResources(t *testing.T) {
+	s, err := schema.Load(localPolicySchema)
+	if err != nil {
+		t.Fatalf("schema.Load: %v", err)
+	}
+	var want []string
+	typ := reflect.TypeOf(config.LocalResource{})
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		want = append(want, name)
+	}
+	var got []string
+	for name := range s.Defs["localResource"].Properties {
+		got = append(got, name)
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("local resources in local_policy.schema.json do not match config.LocalResource (-want +got):\n%s", diff)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/ospatch"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
@@ -30,6 +31,22 @@ import (
 )
 
 func (r *patchTask) runUpdates(ctx context.Context) error {
+	return r.updates(ctx, r.Task.GetDryRun(), nil)
+}
+
+// simulateUpdates runs the package managers in dry run mode and returns the
+// changes the real run is predicted to make.
+func (r *patchTask) simulateUpdates(ctx context.Context) (*ospatch.ChangeSet, error) {
+	changes := &ospatch.ChangeSet{}
+	if err := r.updates(ctx, true, changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// updates installs the package updates, or in dry run mode adds the updates
+// that would be installed to changes.
+func (r *patchTask) updates(ctx context.Context, dryRun bool, changes *ospatch.ChangeSet) error {
 	var errs []string
 	const retryPeriod = 3 * time.Minute
 	// Check for both apt-get and dpkg-query to give us a clean signal.
@@ -39,9 +56,12 @@ func (r *patchTask) runUpdates(ctx context.Context) error {
 			return err
 		}
 		opts := []ospatch.AptGetUpgradeOption{
-			ospatch.AptGetDryRun(r.Task.GetDryRun()),
+			ospatch.AptGetDryRun(dryRun),
+			ospatch.AptGetChangeSet(changes),
 			ospatch.AptGetExcludes(excludes),
 			ospatch.AptGetExclusivePackages(r.Task.GetPatchConfig().GetApt().GetExclusivePackages()),
+			ospatch.AptGetWithNewPkgs(agentconfig.AptWithNewPkgs()),
+			ospatch.AptGetAutoremove(agentconfig.AptAutoremove()),
 		}
 		switch r.Task.GetPatchConfig().GetApt().GetType() {
 		case agentendpointpb.AptSettings_DIST:
@@ -62,7 +82,8 @@ func (r *patchTask) runUpdates(ctx context.Context) error {
 			ospatch.YumUpdateMinimal(r.Task.GetPatchConfig().GetYum().GetMinimal()),
 			ospatch.YumUpdateExcludes(excludes),
 			ospatch.YumExclusivePackages(r.Task.GetPatchConfig().GetYum().GetExclusivePackages()),
-			ospatch.YumDryRun(r.Task.GetDryRun()),
+			ospatch.YumDryRun(dryRun),
+			ospatch.YumChangeSet(changes),
 		}
 		clog.Debugf(ctx, "Installing YUM package updates.")
 		if err := retryutil.RetryFunc(ctx, retryPeriod, "installing YUM package updates", func() error { return ospatch.RunYumUpdate(ctx, opts...) }); err != nil {
@@ -81,7 +102,8 @@ func (r *patchTask) runUpdates(ctx context.Context) error {
 			ospatch.ZypperUpdateWithOptional(r.Task.GetPatchConfig().GetZypper().GetWithOptional()),
 			ospatch.ZypperUpdateWithExcludes(excludes),
 			ospatch.ZypperUpdateWithExclusivePatches(r.Task.GetPatchConfig().GetZypper().GetExclusivePatches()),
-			ospatch.ZypperUpdateDryrun(r.Task.GetDryRun()),
+			ospatch.ZypperUpdateDryrun(dryRun),
+			ospatch.ZypperChangeSet(changes),
 		}
 		clog.Debugf(ctx, "Installing Zypper updates.")
 		if err := retryutil.RetryFunc(ctx, retryPeriod, "installing Zypper updates", func() error { return ospatch.RunZypperPatch(ctx, opts...) }); err != nil {
@@ -15,14 +15,40 @@
 package agentendpoint
 
 import (
+	"context"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/osconfig/ospatch"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
 )
 
+func TestSimulate(t *testing.T) {
+	ctx := context.Background()
+	taskStateFile = filepath.Join(t.TempDir(), "testState")
+	// Don't run the package managers of the test machine.
+	defer func(apt, yum, zypper bool) {
+		packages.AptExists, packages.YumExists, packages.ZypperExists = apt, yum, zypper
+	}(packages.AptExists, packages.YumExists, packages.ZypperExists)
+	packages.AptExists, packages.YumExists, packages.ZypperExists = false, false, false
+
+	r := &patchTask{
+		state: &taskState{},
+		Task:  &applyPatchesTask{&agentendpointpb.ApplyPatchesTask{PatchConfig: &agentendpointpb.PatchConfig{}}},
+	}
+	if err := r.simulate(ctx); err != nil {
+		t.Errorf("simulate: unexpected error %v", err)
+	}
+	if !r.Simulated {
+		t.Error("want the task to be marked as simulated")
+	}
+}
+
 func TestExcludeConversion(t *testing.T) {
 	regex, _ := regexp.Compile("PackageName")
 	emptyRegex, _ := regexp.Compile("")
@@ -0,0 +1,125 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build simulation
+// +build simulation
+
+package agentendpoint
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+// simulationEnv is set by e2e_tests/patch_simulation/run.sh in the
+// containers it starts. The patch simulation really updates packages, so it
+// does not run anywhere else.
+const simulationEnv = "OSCONFIG_PATCH_SIMULATION"
+
+// patchSimulationServer records the patch task progress and result.
+type patchSimulationServer struct {
+	*agentEndpointServiceTestServer
+	progress []agentendpointpb.ApplyPatchesTaskProgress_State
+	complete *agentendpointpb.ReportTaskCompleteRequest
+}
+
+func (s *patchSimulationServer) ReportTaskProgress(ctx context.Context, req *agentendpointpb.ReportTaskProgressRequest) (*agentendpointpb.ReportTaskProgressResponse, error) {
+	s.progress = append(s.progress, req.GetApplyPatchesTaskProgress().GetState())
+	return &agentendpointpb.ReportTaskProgressResponse{TaskDirective: agentendpointpb.TaskDirective_CONTINUE}, nil
+}
+
+func (s *patchSimulationServer) ReportTaskComplete(ctx context.Context, req *agentendpointpb.ReportTaskCompleteRequest) (*agentendpointpb.ReportTaskCompleteResponse, error) {
+	s.complete = req
+	return &agentendpointpb.ReportTaskCompleteResponse{}, nil
+}
+
+func updateCount(ctx context.Context, t *testing.T) int {
+	pkgs, err := packages.GetPackageUpdates(ctx, nil)
+	if err != nil {
+		t.Fatalf("GetPackageUpdates: %v", err)
+	}
+	return len(pkgs.Apt) + len(pkgs.Yum) + len(pkgs.Zypper)
+}
+
+func TestPatchSimulation(t *testing.T) {
+	if os.Getenv(simulationEnv) == "" {
+		t.Skipf("%s not set, run with e2e_tests/patch_simulation/run.sh", simulationEnv)
+	}
+	ctx := context.Background()
+	taskStateFile = filepath.Join(t.TempDir(), "testState")
+
+	before := updateCount(ctx, t)
+	t.Logf("%d package updates available", before)
+
+	tests := []struct {
+		desc   string
+		dryRun bool
+	}{
+		// A dry run reports success without changing anything.
+		{"dry run", true},
+		{"apply updates", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			srv := &patchSimulationServer{agentEndpointServiceTestServer: newAgentEndpointServiceTestServer()}
+			tc, err := newTestClient(ctx, srv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tc.close()
+
+			task := &agentendpointpb.Task{
+				TaskId:   "patch-simulation",
+				TaskType: agentendpointpb.TaskType_APPLY_PATCHES,
+				TaskDetails: &agentendpointpb.Task_ApplyPatchesTask{ApplyPatchesTask: &agentendpointpb.ApplyPatchesTask{
+					PatchConfig: &agentendpointpb.PatchConfig{RebootConfig: agentendpointpb.PatchConfig_NEVER},
+					DryRun:      tt.dryRun,
+				}},
+			}
+			if err := tc.client.RunApplyPatches(ctx, task); err != nil {
+				t.Fatalf("RunApplyPatches: %v", err)
+			}
+
+			if srv.complete == nil {
+				t.Fatal("expected ReportTaskComplete to have been called")
+			}
+			if msg := srv.complete.GetErrorMessage(); msg != "" {
+				t.Errorf("ReportTaskComplete error message: %s", msg)
+			}
+			switch got := srv.complete.GetApplyPatchesTaskOutput().GetState(); got {
+			case agentendpointpb.ApplyPatchesTaskOutput_SUCCEEDED, agentendpointpb.ApplyPatchesTaskOutput_SUCCEEDED_REBOOT_REQUIRED:
+			default:
+				t.Errorf("ApplyPatchesTaskOutput state: got %s, want SUCCEEDED", got)
+			}
+			want := []agentendpointpb.ApplyPatchesTaskProgress_State{agentendpointpb.ApplyPatchesTaskProgress_STARTED, agentendpointpb.ApplyPatchesTaskProgress_APPLYING_PATCHES}
+			if len(srv.progress) != len(want) || srv.progress[0] != want[0] || srv.progress[1] != want[1] {
+				t.Errorf("progress states: got %v, want %v", srv.progress, want)
+			}
+
+			after := updateCount(ctx, t)
+			if tt.dryRun && after != before {
+				t.Errorf("dry run changed the available updates from %d to %d", before, after)
+			}
+			if !tt.dryRun && before > 0 && after >= before {
+				t.Errorf("applying updates left %d of %d updates", after, before)
+			}
+		})
+	}
+}
@@ -20,7 +20,9 @@ import (
 	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/audit"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
 	"github.com/GoogleCloudPlatform/osconfig/ospatch"
 	"google.golang.org/protobuf/encoding/protojson"
 
@@ -50,6 +52,13 @@ type patchTask struct {
 	StartedAt   time.Time `json:",omitempty"`
 	PatchStep   patchStep `json:",omitempty"`
 	RebootCount int
+	// RebootLock is the reboot lock held until the task completes, see
+	// agentconfig.RebootLock.
+	RebootLock string `json:",omitempty"`
+	// Simulated is set once the updates were simulated, see
+	// agentconfig.PatchCanary, so they are not simulated again after a
+	// reboot.
+	Simulated bool `json:",omitempty"`
 
 	// TODO: add Attempts and track number of retries with backoff, jitter, etc.
 }
@@ -65,6 +74,21 @@ func (r *patchTask) complete(ctx context.Context) {
 	}
 }
 
+// releaseRebootLock releases the reboot lock if the task holds it.
+func (r *patchTask) releaseRebootLock(ctx context.Context) {
+	if r.RebootLock == "" {
+		return
+	}
+	lock, err := parseRebootLock(r.RebootLock)
+	if err == nil {
+		err = lock.release(ctx, agentconfig.Instance())
+	}
+	if err != nil {
+		clog.Errorf(ctx, "Error releasing reboot lock %q: %v", r.RebootLock, err)
+	}
+	r.RebootLock = ""
+}
+
 type applyPatchesTask struct {
 	*agentendpointpb.ApplyPatchesTask
 }
@@ -94,16 +118,29 @@ func (r *patchTask) handleErrorState(ctx context.Context, msg string, err error)
 	if err == errServerCancel {
 		return r.reportCanceled(ctx)
 	}
-	return r.reportFailed(ctx, msg)
+	return r.reportFailed(ctx, msg, err)
 }
 
-func (r *patchTask) reportFailed(ctx context.Context, msg string) error {
+// reportFailed reports the task as failed with msg, prefixed with the error
+// code of err.
+func (r *patchTask) reportFailed(ctx context.Context, msg string, err error) error {
+	msg = errorMessage(errcode.Of(err, errcode.Internal), msg)
 	clog.Errorf(ctx, msg)
 	return r.reportCompletedState(ctx, msg, &agentendpointpb.ReportTaskCompleteRequest_ApplyPatchesTaskOutput{
 		ApplyPatchesTaskOutput: &agentendpointpb.ApplyPatchesTaskOutput{State: agentendpointpb.ApplyPatchesTaskOutput_FAILED},
 	})
 }
 
+// reportAlreadyPatching reports the task as failed without running it as the
+// patch task running is still pending, so the two do not interleave.
+func (r *patchTask) reportAlreadyPatching(ctx context.Context, running string) error {
+	msg := errorMessage(errcode.AlreadyPatching, fmt.Sprintf("Not running patch task %q, already patching for task %q", r.TaskID, running))
+	clog.Warningf(ctx, msg)
+	return r.reportCompletedState(ctx, msg, &agentendpointpb.ReportTaskCompleteRequest_ApplyPatchesTaskOutput{
+		ApplyPatchesTaskOutput: &agentendpointpb.ApplyPatchesTaskOutput{State: agentendpointpb.ApplyPatchesTaskOutput_FAILED},
+	})
+}
+
 func (r *patchTask) reportCanceled(ctx context.Context) error {
 	clog.Infof(ctx, "Canceling patch execution")
 	return r.reportCompletedState(ctx, errServerCancel.Error(), &agentendpointpb.ReportTaskCompleteRequest_ApplyPatchesTaskOutput{
@@ -141,7 +178,7 @@ func (r *patchTask) reportContinuingState(ctx context.Context, patchState agente
 	}
 	res, err := r.client.reportTaskProgress(ctx, req)
 	if err != nil {
-		return fmt.Errorf("error reporting state %s: %v", patchState, err)
+		return fmt.Errorf("error reporting state %s: %w", patchState, err)
 	}
 	if res.GetTaskDirective() == agentendpointpb.TaskDirective_STOP {
 		return errServerCancel
@@ -154,6 +191,30 @@ func (r *patchTask) reportContinuingState(ctx context.Context, patchState agente
 	return r.saveState()
 }
 
+// simulate runs the updates in dry run mode before the real run and logs the
+// predicted changes. They are not reported to the service, ApplyPatchesTaskProgress
+// and ApplyPatchesTaskOutput only carry a state. The real run is not gated on
+// the simulation either, the service answers every progress report with
+// CONTINUE unless the patch job is canceled, which the real run already
+// honors.
+func (r *patchTask) simulate(ctx context.Context) error {
+	clog.Infof(ctx, "Simulating updates before applying them.")
+	changes, err := r.simulateUpdates(ctx)
+	if err != nil {
+		return errcode.Wrap(errcode.PackageManager, err)
+	}
+	if changes == nil {
+		return nil
+	}
+	changes.Log(ctx)
+
+	r.Simulated = true
+	if err := r.saveState(); err != nil {
+		return fmt.Errorf("error saving state: %v", err)
+	}
+	return nil
+}
+
 // TODO: Add MaxRebootCount so we don't loop endlessly.
 
 func (r *patchTask) prePatchReboot(ctx context.Context) error {
@@ -200,11 +261,27 @@ func (r *patchTask) rebootIfNeeded(ctx context.Context, prePatch bool) error {
 		return nil
 	}
 
+	// The lock is held across reboots until the task completes.
+	if spec := agentconfig.RebootLock(); spec != "" && r.RebootLock == "" {
+		lock, err := parseRebootLock(spec)
+		if err != nil {
+			return err
+		}
+		clog.Infof(ctx, "Acquiring reboot lock %q.", spec)
+		if err := waitForRebootLock(ctx, lock, agentconfig.Instance()); err != nil {
+			return err
+		}
+		r.RebootLock = spec
+	}
+
 	r.RebootCount++
 	if err := r.saveState(); err != nil {
 		return fmt.Errorf("error saving state: %v", err)
 	}
-	if err := rebootSystem(); err != nil {
+	start := time.Now()
+	err = rebootSystem()
+	audit.Record(ctx, audit.Reboot, agentconfig.Instance(), start, err)
+	if err != nil {
 		return fmt.Errorf("failed to reboot system: %v", err)
 	}
 
@@ -221,14 +298,21 @@ func (r *patchTask) run(ctx context.Context) (err error) {
 	defer func() {
 		// This should not happen but the WUA libraries are complicated and
 		// recovering with an error is better than crashing.
-		if rec := recover(); rec != nil {
+		rec := recover()
+		// The lock is released first and on every path, a panic included,
+		// or the instances waiting for it would wait forever.
+		r.releaseRebootLock(ctx)
+		if rec != nil {
 			err = fmt.Errorf("Recovered from panic: %v", rec)
-			r.reportFailed(ctx, err.Error())
+			r.reportFailed(ctx, err.Error(), err)
+			// The task is over, its state would keep later patch tasks from
+			// running.
+			r.complete(ctx)
 			return
 		}
 		r.complete(ctx)
-		if agentconfig.OSInventoryEnabled() {
-			go r.client.ReportInventory(ctx)
+		if agentconfig.OSInventoryEnabled() && !r.Task.GetDryRun() && packagesChangedSince(r.StartedAt) {
+			EnqueueInventoryReport(ctx)
 		}
 	}()
 
@@ -236,11 +320,11 @@ func (r *patchTask) run(ctx context.Context) (err error) {
 		clog.Debugf(ctx, "Running PatchStep %q.", r.PatchStep)
 		switch r.PatchStep {
 		default:
-			return r.reportFailed(ctx, fmt.Sprintf("unknown step: %q", r.PatchStep))
+			return r.reportFailed(ctx, fmt.Sprintf("unknown step: %q", r.PatchStep), nil)
 		case prePatch:
 			r.StartedAt = time.Now()
 			if err := r.setStep(patching); err != nil {
-				return r.reportFailed(ctx, fmt.Sprintf("Error saving agent step: %v", err))
+				return r.reportFailed(ctx, fmt.Sprintf("Error saving agent step: %v", err), err)
 			}
 
 			if err := r.reportContinuingState(ctx, agentendpointpb.ApplyPatchesTaskProgress_STARTED); err != nil {
@@ -250,23 +334,31 @@ func (r *patchTask) run(ctx context.Context) (err error) {
 				return r.handleErrorState(ctx, fmt.Sprintf("Error running prePatchReboot: %v", err), err)
 			}
 		case patching:
+			if agentconfig.PatchCanary() == agentconfig.PatchCanarySimulate && !r.Task.GetDryRun() && !r.Simulated {
+				if err := r.simulate(ctx); err != nil {
+					return r.handleErrorState(ctx, fmt.Sprintf("Failed to simulate patches: %v", err), err)
+				}
+			}
 			if err := r.reportContinuingState(ctx, agentendpointpb.ApplyPatchesTaskProgress_APPLYING_PATCHES); err != nil {
 				return r.handleErrorState(ctx, err.Error(), err)
 			}
-			if err := r.runUpdates(ctx); err != nil {
-				return r.handleErrorState(ctx, fmt.Sprintf("Failed to apply patches: %v", err), err)
+			start := time.Now()
+			err := r.runUpdates(ctx)
+			audit.Record(ctx, audit.ApplyPatches, agentconfig.Instance(), start, err)
+			if err != nil {
+				return r.handleErrorState(ctx, fmt.Sprintf("Failed to apply patches: %v", err), errcode.Wrap(errcode.PackageManager, err))
 			}
 			if err := r.postPatchReboot(ctx); err != nil {
 				return r.handleErrorState(ctx, fmt.Sprintf("Error running postPatchReboot: %v", err), err)
 			}
 			// We have not rebooted so patching is complete.
 			if err := r.setStep(postPatch); err != nil {
-				return r.reportFailed(ctx, fmt.Sprintf("Error saving agent step: %v", err))
+				return r.reportFailed(ctx, fmt.Sprintf("Error saving agent step: %v", err), err)
 			}
 		case postPatch:
 			isRebootRequired, err := systemRebootRequired(ctx)
 			if err != nil {
-				return r.reportFailed(ctx, fmt.Sprintf("Error checking if system reboot is required: %v", err))
+				return r.reportFailed(ctx, fmt.Sprintf("Error checking if system reboot is required: %v", err), err)
 			}
 
 			finalState := agentendpointpb.ApplyPatchesTaskOutput_SUCCEEDED
@@ -293,6 +385,21 @@ func (c *Client) RunApplyPatches(ctx context.Context, task *agentendpointpb.Task
 		client: c,
 		Task:   &applyPatchesTask{task.GetApplyPatchesTask()},
 	}
+	// A patch task in the state file is resumed after a reboot, starting this
+	// one would overwrite its state. Tasks are run one at a time by the
+	// tasker, once the resumed task is done its state is cleared.
+	st, err := loadState(taskStateFile)
+	if err != nil {
+		clog.Warningf(ctx, "Error loading state: %v", err)
+	}
+	pending := st.patchTaskID()
+	if pending == r.TaskID {
+		// The server sent the task again, the run in progress reports it.
+		clog.Infof(ctx, "ApplyPatchesTask %q is already running.", r.TaskID)
+		return nil
+	} else if pending != "" {
+		return r.reportAlreadyPatching(ctx, pending)
+	}
 	r.setStep(prePatch)
 
 	return r.run(ctx)
@@ -131,6 +131,13 @@ func (r *patchTask) wuaUpdates(ctx context.Context) error {
 	return fmt.Errorf("failed to install all updates after trying %d times", retries)
 }
 
+// simulateUpdates is not supported, WUA can't predict the changes of an
+// installation.
+func (r *patchTask) simulateUpdates(ctx context.Context) (*ospatch.ChangeSet, error) {
+	clog.Infof(ctx, "Simulating updates is not supported on Windows, applying them without simulating.")
+	return nil, nil
+}
+
 func (r *patchTask) runUpdates(ctx context.Context) error {
 	// Install GooGet updates first as this will allow us to update the agent prior to any potential WUA bugs/errors.
 	if packages.GooGetExists {
@@ -0,0 +1,83 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/agentstatus"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// policyLockFile is the run lock of an assignment, the name is hashed as it
+// is a resource path, any revision is dropped so all revisions share a lock.
+var policyLockFile = func(assignment string) string {
+	name, _, _ := strings.Cut(assignment, "@")
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(agentconfig.PolicyLockDir(), hex.EncodeToString(sum[:8])+".lock")
+}
+
+// unlockedRun marks an assignment run without its lock, as it could not be
+// taken.
+var unlockedRun = &util.FileLock{}
+
+// lockAssignment takes the run lock of assignment unless this task already
+// holds it, and reports whether its policies should run. An assignment whose
+// lock is held by a previous, still running, cycle is skipped. Run locks are
+// only advisory, if the lock can't be taken for any other reason the
+// policies still run.
+func (c *configTask) lockAssignment(ctx context.Context, assignment string) bool {
+	if c.assignmentLocks == nil {
+		c.assignmentLocks = map[string]*util.FileLock{}
+	}
+	if l, ok := c.assignmentLocks[assignment]; ok {
+		// A nil lock is an assignment that was skipped.
+		return l != nil
+	}
+
+	l, err := util.TryLockFile(policyLockFile(assignment))
+	if errors.Is(err, util.ErrLocked) {
+		n := agentstatus.RecordOverlappedPolicyCycle()
+		clog.Warningf(ctx, "Skipping OS policy assignment %q, a previous run of it is still in progress (%d overlapped cycles).", assignment, n)
+		c.assignmentLocks[assignment] = nil
+		return false
+	}
+	if err != nil {
+		clog.Warningf(ctx, "Error taking run lock of OS policy assignment %q, running it unlocked: %v", assignment, err)
+		l = unlockedRun
+	}
+	c.assignmentLocks[assignment] = l
+	return true
+}
+
+// unlockAssignments releases the run locks held by this task.
+func (c *configTask) unlockAssignments(ctx context.Context) {
+	for assignment, l := range c.assignmentLocks {
+		if l == nil || l == unlockedRun {
+			continue
+		}
+		if err := l.Unlock(); err != nil {
+			clog.Warningf(ctx, "Error releasing run lock of OS policy assignment %q: %v", assignment, err)
+		}
+	}
+	c.assignmentLocks = nil
+}
@@ -0,0 +1,68 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentstatus"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+func TestApplyPoliciesSkipsLockedAssignment(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func(*agentendpointpb.OSPolicy_Resource) *resource) { newResource = f }(newResource)
+	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+		return &resource{resourceIface: resourceIface(&testResource{steps: 5})}
+	}
+
+	// A previous run still holds the lock of a1.
+	l, err := util.TryLockFile(policyLockFile("projects/p/locations/z/osPolicyAssignments/a1@rev"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock()
+
+	policies := []*agentendpointpb.ApplyConfigTask_OSPolicy{genTestPolicy("p1"), genTestPolicy("p2"), genTestPolicy("p3")}
+	policies[0].OsPolicyAssignment = "projects/p/locations/z/osPolicyAssignments/a1@rev"
+	policies[1].OsPolicyAssignment = "projects/p/locations/z/osPolicyAssignments/a2@rev"
+	policies[2].OsPolicyAssignment = "projects/p/locations/z/osPolicyAssignments/a1@rev"
+	c := &configTask{Task: &applyConfigTask{&agentendpointpb.ApplyConfigTask{OsPolicies: policies}}}
+	c.generateBaseResults()
+
+	before := agentstatus.Get().OverlappedPolicyCycles
+	c.applyPolicies(ctx)
+	c.cleanup(ctx)
+
+	for i, want := range []bool{false, true, false} {
+		ran := len(c.results[i].GetOsPolicyResourceCompliances()[0].GetConfigSteps()) > 0
+		if ran != want {
+			t.Errorf("policy %q ran = %t, want %t", policies[i].GetId(), ran, want)
+		}
+	}
+	if got := agentstatus.Get().OverlappedPolicyCycles - before; got != 1 {
+		t.Errorf("OverlappedCycles increased by %d, want 1", got)
+	}
+
+	// The lock of a2 was released when the task finished.
+	l2, err := util.TryLockFile(policyLockFile(policies[1].GetOsPolicyAssignment()))
+	if err != nil {
+		t.Fatalf("lock of a2 still held: %v", err)
+	}
+	l2.Unlock()
+}
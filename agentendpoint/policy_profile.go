@@ -0,0 +1,78 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"runtime/pprof"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+var (
+	policyProfiling       = agentconfig.PolicyProfiling
+	policyCPUProfileFile  = agentconfig.PolicyCPUProfileFile
+	policyHeapProfileFile = agentconfig.PolicyHeapProfileFile
+)
+
+// startPolicyProfile starts a CPU profile of an OS policy run when policy
+// profiling is enabled, see agentconfig.PolicyProfiling. The returned func
+// stops it and writes a heap profile, both replace those of the previous run.
+// Failing to profile never fails the run.
+func startPolicyProfile(ctx context.Context) func() {
+	if !policyProfiling() {
+		return func() {}
+	}
+
+	cpuFile := policyCPUProfileFile()
+	f, err := os.Create(cpuFile)
+	if err != nil {
+		clog.Warningf(ctx, "Error creating policy CPU profile: %v", err)
+		return func() {}
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		clog.Warningf(ctx, "Error starting policy CPU profile: %v", err)
+		f.Close()
+		return func() {}
+	}
+	clog.Debugf(ctx, "Profiling OS policy run to %q.", cpuFile)
+
+	return func() {
+		pprof.StopCPUProfile()
+		if err := f.Close(); err != nil {
+			clog.Warningf(ctx, "Error writing policy CPU profile: %v", err)
+		}
+		if err := writeHeapProfile(policyHeapProfileFile()); err != nil {
+			clog.Warningf(ctx, "Error writing policy heap profile: %v", err)
+		}
+	}
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// Collect garbage so the profile shows live memory.
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
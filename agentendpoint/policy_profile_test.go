@@ -0,0 +1,60 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStartPolicyProfile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cpuFile := filepath.Join(dir, "cpu.pprof")
+	heapFile := filepath.Join(dir, "heap.pprof")
+	defer func(f func() bool) { policyProfiling = f }(policyProfiling)
+	defer func(f func() string) { policyCPUProfileFile = f }(policyCPUProfileFile)
+	defer func(f func() string) { policyHeapProfileFile = f }(policyHeapProfileFile)
+	policyCPUProfileFile = func() string { return cpuFile }
+	policyHeapProfileFile = func() string { return heapFile }
+
+	policyProfiling = func() bool { return false }
+	startPolicyProfile(ctx)()
+	for _, f := range []string{cpuFile, heapFile} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("profiling disabled: %s exists, err: %v", f, err)
+		}
+	}
+
+	policyProfiling = func() bool { return true }
+	startPolicyProfile(ctx)()
+	for _, f := range []string{cpuFile, heapFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			t.Fatalf("profiling enabled: %v", err)
+		}
+		if fi.Size() == 0 {
+			t.Errorf("profiling enabled: %s is empty", f)
+		}
+	}
+
+	// A second run replaces the profiles.
+	startPolicyProfile(ctx)()
+	if _, err := os.Stat(cpuFile); err != nil {
+		t.Errorf("second run: %v", err)
+	}
+}
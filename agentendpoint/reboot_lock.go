@@ -0,0 +1,208 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+var (
+	rebootLockPoll    = 30 * time.Second
+	rebootLockTimeout = time.Hour
+	rebootLockClient  = &http.Client{Timeout: 30 * time.Second}
+
+	// rebootLockAttribute returns the value of a metadata attribute, the
+	// instance value taking precedence over the project value.
+	rebootLockAttribute = func(ctx context.Context, key string) (string, error) {
+		v, err := metadata.InstanceAttributeValueWithContext(ctx, key)
+		var notDefined metadata.NotDefinedError
+		if errors.As(err, &notDefined) {
+			v, err = metadata.ProjectAttributeValueWithContext(ctx, key)
+			if errors.As(err, &notDefined) {
+				return "", nil
+			}
+		}
+		return v, err
+	}
+)
+
+// rebootLock is an external lock held while rebooting for a patch, so
+// reboots cooperate with cluster orchestrators such as kured that only let a
+// few nodes reboot at a time. The holder is the instance URI.
+type rebootLock interface {
+	// acquire reports whether the lock was acquired, false if it is held by
+	// someone else.
+	acquire(ctx context.Context, holder string) (bool, error)
+	release(ctx context.Context, holder string) error
+}
+
+// parseRebootLock parses a lock spec, see agentconfig.RebootLock.
+func parseRebootLock(spec string) (rebootLock, error) {
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		path := strings.TrimPrefix(spec, "file:")
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("invalid reboot lock %q, file path must be absolute", spec)
+		}
+		return fileRebootLock(path), nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return httpRebootLock(spec), nil
+	case strings.HasPrefix(spec, "metadata:") && len(spec) > len("metadata:"):
+		return metadataRebootLock(strings.TrimPrefix(spec, "metadata:")), nil
+	}
+	return nil, fmt.Errorf("invalid reboot lock %q, must be file:<path>, an http or https URL, or metadata:<key>", spec)
+}
+
+// fileRebootLock is a lock file, on a shared file system for cluster wide
+// coordination, created exclusively and holding the name of its holder.
+type fileRebootLock string
+
+func (l fileRebootLock) acquire(ctx context.Context, holder string) (bool, error) {
+	f, err := os.OpenFile(string(l), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		// The lock may still be ours from before a restart of the agent.
+		b, err := os.ReadFile(string(l))
+		if err != nil {
+			return false, err
+		}
+		return strings.TrimSpace(string(b)) == holder, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := f.WriteString(holder + "\n"); err != nil {
+		f.Close()
+		os.Remove(string(l))
+		return false, err
+	}
+	return true, f.Close()
+}
+
+func (l fileRebootLock) release(ctx context.Context, holder string) error {
+	b, err := os.ReadFile(string(l))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(b)) != holder {
+		return nil
+	}
+	return os.Remove(string(l))
+}
+
+// httpRebootLock is a lock service, acquired with a PUT and released with a
+// DELETE of a {"holder": ...} JSON body. A 409 Conflict, 423 Locked or 429
+// Too Many Requests response means the lock is held by someone else.
+type httpRebootLock string
+
+func (l httpRebootLock) do(ctx context.Context, method, holder string) (int, error) {
+	body, err := json.Marshal(map[string]string{"holder": holder})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, string(l), bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := rebootLockClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (l httpRebootLock) acquire(ctx context.Context, holder string) (bool, error) {
+	code, err := l.do(ctx, http.MethodPut, holder)
+	switch {
+	case err != nil:
+		return false, err
+	case code >= 200 && code < 300:
+		return true, nil
+	case code == http.StatusConflict || code == http.StatusLocked || code == http.StatusTooManyRequests:
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected http status %d acquiring reboot lock", code)
+}
+
+func (l httpRebootLock) release(ctx context.Context, holder string) error {
+	code, err := l.do(ctx, http.MethodDelete, holder)
+	if err != nil {
+		return err
+	}
+	if code >= 300 && code != http.StatusNotFound {
+		return fmt.Errorf("unexpected http status %d releasing reboot lock", code)
+	}
+	return nil
+}
+
+// metadataRebootLock is a metadata attribute set by an orchestrator to the
+// instance name or URI when the instance may reboot. The orchestrator clears
+// it, so releasing is a no-op.
+type metadataRebootLock string
+
+func (l metadataRebootLock) acquire(ctx context.Context, holder string) (bool, error) {
+	v, err := rebootLockAttribute(ctx, string(l))
+	if err != nil {
+		return false, err
+	}
+	v = strings.TrimSpace(v)
+	return v != "" && (v == holder || v == agentconfig.Name()), nil
+}
+
+func (l metadataRebootLock) release(ctx context.Context, holder string) error {
+	return nil
+}
+
+// waitForRebootLock polls the lock until it is acquired, giving up after
+// rebootLockTimeout.
+func waitForRebootLock(ctx context.Context, lock rebootLock, holder string) error {
+	ctx, cancel := context.WithTimeout(ctx, rebootLockTimeout)
+	defer cancel()
+	for {
+		ok, err := lock.acquire(ctx, holder)
+		if err != nil {
+			clog.Warningf(ctx, "Error acquiring reboot lock: %v", err)
+		}
+		if ok {
+			return nil
+		}
+		clog.Infof(ctx, "Reboot lock is held, waiting %s before retrying.", rebootLockPoll)
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return fmt.Errorf("timed out acquiring reboot lock: %v", err)
+			}
+			return errors.New("timed out acquiring reboot lock")
+		case <-time.After(rebootLockPoll):
+		}
+	}
+}
@@ -0,0 +1,155 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRebootLock(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    rebootLock
+		wantErr bool
+	}{
+		{"file:/mnt/shared/reboot.lock", fileRebootLock("/mnt/shared/reboot.lock"), false},
+		{"https://locks.example.com/reboot", httpRebootLock("https://locks.example.com/reboot"), false},
+		{"metadata:reboot-allowed", metadataRebootLock("reboot-allowed"), false},
+		{"file:relative.lock", nil, true},
+		{"metadata:", nil, true},
+		{"ftp://example.com/lock", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRebootLock(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRebootLock(%q) error = %v, want error %t", tt.spec, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("parseRebootLock(%q) = %#v, want %#v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestFileRebootLock(t *testing.T) {
+	ctx := context.Background()
+	lock := fileRebootLock(filepath.Join(t.TempDir(), "reboot.lock"))
+
+	if ok, err := lock.acquire(ctx, "a"); !ok || err != nil {
+		t.Fatalf("acquire(a) = %t, %v, want true", ok, err)
+	}
+	// Acquiring again, after an agent restart, succeeds for the holder only.
+	if ok, err := lock.acquire(ctx, "a"); !ok || err != nil {
+		t.Errorf("acquire(a) again = %t, %v, want true", ok, err)
+	}
+	if ok, err := lock.acquire(ctx, "b"); ok || err != nil {
+		t.Errorf("acquire(b) = %t, %v, want false", ok, err)
+	}
+	// Only the holder releases the lock.
+	if err := lock.release(ctx, "b"); err != nil {
+		t.Errorf("release(b): %v", err)
+	}
+	if _, err := os.Stat(string(lock)); err != nil {
+		t.Errorf("lock file removed by release(b): %v", err)
+	}
+	if err := lock.release(ctx, "a"); err != nil {
+		t.Errorf("release(a): %v", err)
+	}
+	if ok, err := lock.acquire(ctx, "b"); !ok || err != nil {
+		t.Errorf("acquire(b) after release = %t, %v, want true", ok, err)
+	}
+}
+
+func TestHTTPRebootLock(t *testing.T) {
+	var mu sync.Mutex
+	var holder string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Holder string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			if holder != "" && holder != req.Holder {
+				w.WriteHeader(http.StatusLocked)
+				return
+			}
+			holder = req.Holder
+		case http.MethodDelete:
+			if holder == req.Holder {
+				holder = ""
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	lock := httpRebootLock(srv.URL)
+	if ok, err := lock.acquire(ctx, "a"); !ok || err != nil {
+		t.Fatalf("acquire(a) = %t, %v, want true", ok, err)
+	}
+	if ok, err := lock.acquire(ctx, "b"); ok || err != nil {
+		t.Errorf("acquire(b) = %t, %v, want false", ok, err)
+	}
+	if err := lock.release(ctx, "a"); err != nil {
+		t.Errorf("release(a): %v", err)
+	}
+	if ok, err := lock.acquire(ctx, "b"); !ok || err != nil {
+		t.Errorf("acquire(b) after release = %t, %v, want true", ok, err)
+	}
+}
+
+func TestWaitForRebootLock(t *testing.T) {
+	defer func(p, d time.Duration, f func(context.Context, string) (string, error)) {
+		rebootLockPoll, rebootLockTimeout, rebootLockAttribute = p, d, f
+	}(rebootLockPoll, rebootLockTimeout, rebootLockAttribute)
+	rebootLockPoll, rebootLockTimeout = time.Millisecond, 50*time.Millisecond
+
+	holder := "projects/p/zones/z/instances/i"
+	var polls int
+	rebootLockAttribute = func(_ context.Context, key string) (string, error) {
+		if key != "reboot-allowed" {
+			t.Errorf("attribute %q read, want reboot-allowed", key)
+		}
+		if polls++; polls < 3 {
+			return "other", nil
+		}
+		return holder, nil
+	}
+	ctx := context.Background()
+	if err := waitForRebootLock(ctx, metadataRebootLock("reboot-allowed"), holder); err != nil {
+		t.Errorf("waitForRebootLock: %v", err)
+	}
+	if polls != 3 {
+		t.Errorf("waitForRebootLock: got %d polls, want 3", polls)
+	}
+
+	rebootLockAttribute = func(context.Context, string) (string, error) { return "", nil }
+	if err := waitForRebootLock(ctx, metadataRebootLock("reboot-allowed"), holder); err == nil {
+		t.Error("waitForRebootLock: want a timeout error for a lock never granted")
+	}
+}
@@ -0,0 +1,135 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"google.golang.org/grpc/connectivity"
+)
+
+var (
+	sharedMx       sync.Mutex
+	shared         *sharedRef
+	sharedEndpoint string
+
+	newSharedClient = NewClient
+)
+
+// sharedRef counts the users of a shared Client, a Client replaced by a
+// re-dial or CloseSharedClient is only closed once its last user released it.
+type sharedRef struct {
+	client  *Client
+	users   int
+	retired bool
+}
+
+// acquire adds a user of r, the returned func releases it. sharedMx must be
+// held.
+func (r *sharedRef) acquire() (*Client, func()) {
+	r.users++
+	var once sync.Once
+	return r.client, func() {
+		once.Do(func() {
+			sharedMx.Lock()
+			defer sharedMx.Unlock()
+			r.users--
+			if r.retired && r.users == 0 {
+				r.close()
+			}
+		})
+	}
+}
+
+// retire stops handing out r and closes its Client unless it is in use.
+// sharedMx must be held.
+func (r *sharedRef) retire() error {
+	r.retired = true
+	if r.users > 0 {
+		return nil
+	}
+	return r.close()
+}
+
+func (r *sharedRef) close() error {
+	if r.client.Closed() {
+		return nil
+	}
+	return r.client.Close()
+}
+
+// healthy reports whether the connection of c can be used, a connection
+// that is shut down or failing is re-dialed by SharedClient rather than
+// waiting for the gRPC reconnect backoff.
+func (c *Client) healthy() bool {
+	if c.Closed() {
+		return false
+	}
+	// Connection is deprecated, it is only used to check the state.
+	conn := c.raw.Connection()
+	if conn == nil {
+		return true
+	}
+	switch conn.GetState() {
+	case connectivity.Shutdown, connectivity.TransientFailure:
+		return false
+	}
+	return true
+}
+
+// SharedClient returns the Client shared by periodic tasks, like RegisterAgent
+// and inventory reports, so they don't pay the TLS and gRPC connection setup
+// each time. It is re-dialed when its connection is unhealthy or the service
+// endpoint changed. Callers must call release once done with the Client
+// instead of closing it, a replaced Client is closed after its last release.
+func SharedClient(ctx context.Context) (c *Client, release func(), err error) {
+	sharedMx.Lock()
+	defer sharedMx.Unlock()
+
+	endpoint := agentconfig.SvcEndpoint()
+	if shared != nil && sharedEndpoint == endpoint && shared.client.healthy() {
+		c, release = shared.acquire()
+		return c, release, nil
+	}
+	if shared != nil {
+		clog.Debugf(ctx, "Re-dialing the shared agentendpoint client.")
+		shared.retire()
+		shared = nil
+	}
+
+	client, err := newSharedClient(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, sharedEndpoint = &sharedRef{client: client}, endpoint
+	c, release = shared.acquire()
+	return c, release, nil
+}
+
+// CloseSharedClient closes the shared Client, if any, once it is released by
+// its current users.
+func CloseSharedClient() error {
+	sharedMx.Lock()
+	defer sharedMx.Unlock()
+	if shared == nil {
+		return nil
+	}
+	err := shared.retire()
+	shared = nil
+	return err
+}
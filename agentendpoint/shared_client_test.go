@@ -0,0 +1,95 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSharedClient(t *testing.T) {
+	ctx := context.Background()
+	srv := &agentEndpointServiceTestServer{}
+	var dials int
+	defer func(f func(context.Context) (*Client, error)) { newSharedClient = f }(newSharedClient)
+	newSharedClient = func(ctx context.Context) (*Client, error) {
+		dials++
+		tc, err := newTestClient(ctx, srv)
+		if err != nil {
+			return nil, err
+		}
+		t.Cleanup(tc.s.Stop)
+		return tc.client, nil
+	}
+	defer CloseSharedClient()
+
+	c1, release1, err := SharedClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, release2, err := SharedClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 || dials != 1 {
+		t.Errorf("SharedClient: got %d dials, want the client to be reused", dials)
+	}
+	release2()
+
+	// The endpoint changing re-dials, the replaced client stays open until
+	// its last user released it.
+	sharedEndpoint = "old.example.com"
+	c3, release3, err := SharedClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c3 == c1 || dials != 2 {
+		t.Errorf("SharedClient: got %d dials, want an endpoint change to re-dial", dials)
+	}
+	if c1.Closed() {
+		t.Error("SharedClient: replaced client closed while in use")
+	}
+	release1()
+	release1()
+	if !c1.Closed() {
+		t.Error("SharedClient: replaced client not closed after its release")
+	}
+
+	// A closed client is re-dialed.
+	release3()
+	c3.Close()
+	c4, release4, err := SharedClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c4 == c3 || dials != 3 {
+		t.Errorf("SharedClient: got %d dials, want a closed client to be re-dialed", dials)
+	}
+
+	// CloseSharedClient waits for the users too.
+	if err := CloseSharedClient(); err != nil {
+		t.Errorf("CloseSharedClient: %v", err)
+	}
+	if shared != nil {
+		t.Error("CloseSharedClient: shared client still set")
+	}
+	if c4.Closed() {
+		t.Error("CloseSharedClient: client closed while in use")
+	}
+	release4()
+	if !c4.Closed() {
+		t.Error("CloseSharedClient: client not closed after its release")
+	}
+}
@@ -0,0 +1,322 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build soak
+// +build soak
+
+package agentendpoint
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	agentendpoint "cloud.google.com/go/osconfig/agentendpoint/apiv1"
+	"github.com/GoogleCloudPlatform/osconfig/inventory"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+// soakEnv is how long TestSoak runs the agent loop for, like 4h, it is
+// skipped when not set. Run with:
+//
+//	OSCONFIG_SOAK_DURATION=4h go test -tags soak -run '^TestSoak$' -timeout 0 ./agentendpoint
+const soakEnv = "OSCONFIG_SOAK_DURATION"
+
+const (
+	// soakFailureRate is the share of RPCs failed with Unavailable, like on a
+	// flaky network.
+	soakFailureRate = 0.05
+	// soakPackages is the number of packages in the reported inventory.
+	soakPackages = 20000
+
+	soakNotifyInterval    = 2 * time.Second
+	soakInventoryInterval = 10 * time.Second
+	soakFlapInterval      = 30 * time.Second
+
+	// Growth over the baseline taken after the warm up that fails the test.
+	// Goroutines of a client closed while backing off linger until the
+	// sleep ends, so some slack is needed.
+	soakMaxHeapGrowth      = 64 << 20
+	soakMaxGoroutineGrowth = 50
+	soakMaxFDGrowth        = 20
+)
+
+// soakServer is a fake agentendpoint that notifies the agent of a config
+// task now and then, closes streams and fails RPCs at random.
+type soakServer struct {
+	*agentEndpointServiceTestServer
+
+	mx      sync.Mutex
+	rnd     *rand.Rand
+	pending bool
+}
+
+func (s *soakServer) chance(p float64) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.rnd.Float64() < p
+}
+
+func (s *soakServer) flaky() error {
+	if s.chance(soakFailureRate) {
+		return status.Error(codes.Unavailable, "simulated network failure")
+	}
+	return nil
+}
+
+func (s *soakServer) ReceiveTaskNotification(req *agentendpointpb.ReceiveTaskNotificationRequest, srv agentendpointpb.AgentEndpointService_ReceiveTaskNotificationServer) error {
+	t := time.NewTicker(soakNotifyInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-srv.Context().Done():
+			return nil
+		case <-t.C:
+		}
+		if err := s.flaky(); err != nil {
+			return err
+		}
+		// The server closing the stream makes the agent reconnect.
+		if s.chance(0.1) {
+			return nil
+		}
+		s.mx.Lock()
+		s.pending = true
+		s.mx.Unlock()
+		if err := srv.Send(&agentendpointpb.ReceiveTaskNotificationResponse{}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *soakServer) StartNextTask(ctx context.Context, req *agentendpointpb.StartNextTaskRequest) (*agentendpointpb.StartNextTaskResponse, error) {
+	if err := s.flaky(); err != nil {
+		return nil, err
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if !s.pending {
+		return &agentendpointpb.StartNextTaskResponse{}, nil
+	}
+	s.pending = false
+	return &agentendpointpb.StartNextTaskResponse{Task: &agentendpointpb.Task{
+		TaskType:    agentendpointpb.TaskType_APPLY_CONFIG_TASK,
+		TaskId:      fmt.Sprintf("soak-%d", s.rnd.Int63()),
+		TaskDetails: &agentendpointpb.Task_ApplyConfigTask{ApplyConfigTask: &agentendpointpb.ApplyConfigTask{}},
+	}}, nil
+}
+
+func (s *soakServer) ReportTaskProgress(ctx context.Context, req *agentendpointpb.ReportTaskProgressRequest) (*agentendpointpb.ReportTaskProgressResponse, error) {
+	if err := s.flaky(); err != nil {
+		return nil, err
+	}
+	return &agentendpointpb.ReportTaskProgressResponse{TaskDirective: agentendpointpb.TaskDirective_CONTINUE}, nil
+}
+
+func (s *soakServer) ReportTaskComplete(ctx context.Context, req *agentendpointpb.ReportTaskCompleteRequest) (*agentendpointpb.ReportTaskCompleteResponse, error) {
+	if err := s.flaky(); err != nil {
+		return nil, err
+	}
+	return &agentendpointpb.ReportTaskCompleteResponse{}, nil
+}
+
+func (s *soakServer) RegisterAgent(ctx context.Context, req *agentendpointpb.RegisterAgentRequest) (*agentendpointpb.RegisterAgentResponse, error) {
+	if err := s.flaky(); err != nil {
+		return nil, err
+	}
+	return &agentendpointpb.RegisterAgentResponse{}, nil
+}
+
+func (s *soakServer) ReportInventory(ctx context.Context, req *agentendpointpb.ReportInventoryRequest) (*agentendpointpb.ReportInventoryResponse, error) {
+	if err := s.flaky(); err != nil {
+		return nil, err
+	}
+	// Ask for the full inventory now and then, like after a change.
+	return &agentendpointpb.ReportInventoryResponse{ReportFullInventory: req.GetInventory() == nil && s.chance(0.5)}, nil
+}
+
+// soakDialer dials new clients to a single fake server, like the agent
+// dialing the service again for each periodic task.
+type soakDialer struct {
+	lis *bufconn.Listener
+}
+
+func (d *soakDialer) newClient(ctx context.Context) (*Client, error) {
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return d.lis.Dial()
+	}), grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	client, err := agentendpoint.NewClient(ctx, option.WithGRPCConn(conn))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{raw: client, noti: make(chan struct{}, 1)}, nil
+}
+
+func soakInventory() *inventory.InstanceInventory {
+	pkgs := &packages.Packages{}
+	for i := 0; i < soakPackages; i++ {
+		pkgs.Deb = append(pkgs.Deb, &packages.PkgInfo{
+			Name:    fmt.Sprintf("package-%d", i),
+			Arch:    "x86_64",
+			Version: fmt.Sprintf("1.%d-1", i),
+			Source:  packages.Source{Name: fmt.Sprintf("source-%d", i), Version: fmt.Sprintf("1.%d-1", i)},
+		})
+	}
+	return &inventory.InstanceInventory{Hostname: "soak", ShortName: "debian", Version: "12", InstalledPackages: pkgs}
+}
+
+// soakSample is the resource usage of the test process.
+type soakSample struct {
+	heap       uint64
+	goroutines int
+	fds        int
+}
+
+func (s soakSample) String() string {
+	return fmt.Sprintf("heap %d MiB, %d goroutines, %d fds", s.heap>>20, s.goroutines, s.fds)
+}
+
+func takeSoakSample() soakSample {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	// Open file descriptors are only counted where /proc is available.
+	fds := -1
+	if entries, err := os.ReadDir("/proc/self/fd"); err == nil {
+		fds = len(entries)
+	}
+	return soakSample{heap: m.HeapAlloc, goroutines: runtime.NumGoroutine(), fds: fds}
+}
+
+// TestSoak runs the task notification loop, config tasks and inventory
+// reports against soakServer for the soakEnv duration. Clients are closed
+// and dialed again every soakFlapInterval, like on a config change. Memory,
+// goroutines and file descriptors must not grow past the baseline taken
+// after a warm up.
+func TestSoak(t *testing.T) {
+	d, err := time.ParseDuration(os.Getenv(soakEnv))
+	if err != nil || d <= 0 {
+		t.Skipf("%s not set to a duration, like 4h", soakEnv)
+	}
+	ctx := context.Background()
+	taskStateFile = t.TempDir() + "/testState"
+
+	srv := &soakServer{agentEndpointServiceTestServer: newAgentEndpointServiceTestServer(), rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer()
+	agentendpointpb.RegisterAgentEndpointServiceServer(s, srv)
+	go s.Serve(lis)
+	defer s.Stop()
+	dialer := &soakDialer{lis: lis}
+	inv := soakInventory()
+
+	var notify *Client
+	flap := func() {
+		if notify != nil {
+			notify.Close()
+		}
+		var err error
+		if notify, err = dialer.newClient(ctx); err != nil {
+			t.Fatalf("error dialing task notification client: %v", err)
+		}
+		notify.WaitForTaskNotification(ctx)
+	}
+	flap()
+
+	report := func() {
+		c, err := dialer.newClient(ctx)
+		if err != nil {
+			t.Fatalf("error dialing inventory client: %v", err)
+		}
+		defer c.Close()
+		if err := c.RegisterAgent(ctx); err != nil {
+			t.Logf("RegisterAgent: %v", err)
+		}
+		c.report(ctx, inv)
+	}
+
+	// The baseline is taken after a tenth of the run, or 10 minutes, so
+	// caches and connection pools are filled.
+	warmUp := d / 10
+	if warmUp > 10*time.Minute {
+		warmUp = 10 * time.Minute
+	}
+	sampleInterval := d / 20
+	if sampleInterval > 10*time.Minute {
+		sampleInterval = 10 * time.Minute
+	}
+
+	end := time.After(d)
+	baselineAt := time.After(warmUp)
+	inventoryTick := time.NewTicker(soakInventoryInterval)
+	defer inventoryTick.Stop()
+	flapTick := time.NewTicker(soakFlapInterval)
+	defer flapTick.Stop()
+	sampleTick := time.NewTicker(sampleInterval)
+	defer sampleTick.Stop()
+
+	var baseline *soakSample
+	for done := false; !done; {
+		select {
+		case <-end:
+			done = true
+		case <-inventoryTick.C:
+			report()
+		case <-flapTick.C:
+			flap()
+		case <-baselineAt:
+			b := takeSoakSample()
+			baseline = &b
+			t.Logf("baseline: %s", b)
+		case <-sampleTick.C:
+			t.Logf("sample: %s", takeSoakSample())
+		}
+	}
+
+	// Close only once, a closed Client stays locked.
+	notify.Close()
+	// Let goroutines of the last clients wind down.
+	time.Sleep(5 * time.Second)
+	final := takeSoakSample()
+	t.Logf("final: %s", final)
+	if baseline == nil {
+		t.Fatal("run ended before the baseline was taken")
+	}
+	if final.heap > baseline.heap+soakMaxHeapGrowth {
+		t.Errorf("heap grew from %d MiB to %d MiB", baseline.heap>>20, final.heap>>20)
+	}
+	if final.goroutines > baseline.goroutines+soakMaxGoroutineGrowth {
+		buf := make([]byte, 1<<20)
+		t.Errorf("goroutines grew from %d to %d:\n%s", baseline.goroutines, final.goroutines, buf[:runtime.Stack(buf, true)])
+	}
+	if final.fds >= 0 && final.fds > baseline.fds+soakMaxFDGrowth {
+		t.Errorf("open file descriptors grew from %d to %d", baseline.fds, final.fds)
+	}
+}
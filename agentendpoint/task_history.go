@@ -0,0 +1,184 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+// taskHistoryRow is a completed task as a row of the BigQuery table in
+// task_history.bq.json, so exported rows can be queried as an external
+// table, like:
+//
+//	bq mk --external_table_definition=task_history.bq.json@NEWLINE_DELIMITED_JSON=gs://bucket/prefix/* dataset.task_history
+type taskHistoryRow struct {
+	CompleteTime  string              `json:"complete_time"`
+	ProjectID     string              `json:"project_id"`
+	Zone          string              `json:"zone"`
+	InstanceName  string              `json:"instance_name"`
+	InstanceID    string              `json:"instance_id"`
+	AgentVersion  string              `json:"agent_version"`
+	TaskID        string              `json:"task_id"`
+	TaskType      string              `json:"task_type"`
+	State         string              `json:"state"`
+	ErrorMessage  string              `json:"error_message,omitempty"`
+	ExitCode      *int32              `json:"exit_code,omitempty"`
+	PolicyResults []taskHistoryPolicy `json:"os_policy_results,omitempty"`
+	Labels        []taskHistoryLabel  `json:"labels,omitempty"`
+}
+
+type taskHistoryPolicy struct {
+	OSPolicyAssignment string `json:"os_policy_assignment"`
+	OSPolicyID         string `json:"os_policy_id"`
+	ResourceID         string `json:"resource_id"`
+	State              string `json:"state"`
+}
+
+type taskHistoryLabel struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+var (
+	taskHistoryMx sync.Mutex
+	// taskHistoryFile is the local sink, kept open across tasks so it is
+	// rotated like the log file.
+	taskHistoryFile *util.RotatingFile
+	taskHistoryPath string
+
+	taskHistoryMaxSize    = agentconfig.TaskHistoryMaxSize
+	taskHistoryMaxBackups = agentconfig.TaskHistoryMaxBackups
+
+	// uploadTaskHistory writes a row to a new GCS object, objects can't be
+	// appended to so each task is its own object under the prefix.
+	uploadTaskHistory = func(ctx context.Context, bucket, object string, data []byte) error {
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		w := client.Bucket(bucket).Object(object).NewWriter(ctx)
+		w.ContentType = "application/x-ndjson"
+		if _, err := w.Write(data); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	}
+)
+
+func newTaskHistoryRow(req *agentendpointpb.ReportTaskCompleteRequest, labels map[string]string, now time.Time) *taskHistoryRow {
+	row := &taskHistoryRow{
+		CompleteTime: now.UTC().Format(time.RFC3339Nano),
+		ProjectID:    agentconfig.ProjectID(),
+		Zone:         agentconfig.Zone(),
+		InstanceName: agentconfig.Name(),
+		InstanceID:   agentconfig.ID(),
+		AgentVersion: agentconfig.Version(),
+		TaskID:       req.GetTaskId(),
+		TaskType:     req.GetTaskType().String(),
+		ErrorMessage: req.GetErrorMessage(),
+	}
+	switch req.GetOutput().(type) {
+	case *agentendpointpb.ReportTaskCompleteRequest_ApplyPatchesTaskOutput:
+		row.State = req.GetApplyPatchesTaskOutput().GetState().String()
+	case *agentendpointpb.ReportTaskCompleteRequest_ExecStepTaskOutput:
+		row.State = req.GetExecStepTaskOutput().GetState().String()
+		exitCode := req.GetExecStepTaskOutput().GetExitCode()
+		row.ExitCode = &exitCode
+	case *agentendpointpb.ReportTaskCompleteRequest_ApplyConfigTaskOutput:
+		out := req.GetApplyConfigTaskOutput()
+		row.State = out.GetState().String()
+		for _, p := range out.GetOsPolicyResults() {
+			for _, rc := range p.GetOsPolicyResourceCompliances() {
+				row.PolicyResults = append(row.PolicyResults, taskHistoryPolicy{
+					OSPolicyAssignment: p.GetOsPolicyAssignment(),
+					OSPolicyID:         p.GetOsPolicyId(),
+					ResourceID:         rc.GetOsPolicyResourceId(),
+					State:              rc.GetState().String(),
+				})
+			}
+		}
+	}
+	for k, v := range labels {
+		row.Labels = append(row.Labels, taskHistoryLabel{Key: k, Value: v})
+	}
+	sort.Slice(row.Labels, func(i, j int) bool { return row.Labels[i].Key < row.Labels[j].Key })
+	return row
+}
+
+// exportTaskHistory appends a completed task to TaskHistorySink, as a line
+// of a local file or a new object under a GCS prefix. Failing to export is
+// logged and does not fail the task.
+func exportTaskHistory(ctx context.Context, req *agentendpointpb.ReportTaskCompleteRequest) {
+	sink := agentconfig.TaskHistorySink()
+	if sink == "" {
+		return
+	}
+	row := newTaskHistoryRow(req, clog.Labels(ctx), time.Now())
+	data, err := json.Marshal(row)
+	if err != nil {
+		clog.Warningf(ctx, "Error exporting task history: %v", err)
+		return
+	}
+	if err := writeTaskHistory(ctx, sink, row.InstanceID+"/"+row.TaskID, append(data, '\n')); err != nil {
+		clog.Warningf(ctx, "Error exporting task history to %s: %v", sink, err)
+	}
+}
+
+// writeTaskHistory writes a row to sink, GCS objects are named
+// prefix/name.ndjson.
+func writeTaskHistory(ctx context.Context, sink, name string, data []byte) error {
+	if strings.HasPrefix(sink, "gs://") {
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(sink, "gs://"), "/")
+		if bucket == "" {
+			return fmt.Errorf("invalid task history sink %q, want gs://bucket/prefix", sink)
+		}
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		return uploadTaskHistory(ctx, bucket, path.Join(prefix, name+".ndjson"), data)
+	}
+	return appendTaskHistory(sink, data)
+}
+
+// appendTaskHistory appends data to the local sink file, rotating it at
+// TaskHistoryMaxSize.
+func appendTaskHistory(file string, data []byte) error {
+	taskHistoryMx.Lock()
+	defer taskHistoryMx.Unlock()
+	if taskHistoryFile == nil || taskHistoryPath != file {
+		if taskHistoryFile != nil {
+			taskHistoryFile.Close()
+		}
+		taskHistoryFile = util.NewRotatingFile(file, taskHistoryMaxSize(), taskHistoryMaxBackups())
+		taskHistoryPath = file
+	}
+	_, err := taskHistoryFile.Write(data)
+	return err
+}
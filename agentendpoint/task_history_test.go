@@ -0,0 +1,230 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+func TestNewTaskHistoryRow(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	exitCode := int32(3)
+	tests := []struct {
+		desc   string
+		req    *agentendpointpb.ReportTaskCompleteRequest
+		labels map[string]string
+		want   *taskHistoryRow
+	}{
+		{
+			"patch",
+			&agentendpointpb.ReportTaskCompleteRequest{
+				TaskId:   "patch-1",
+				TaskType: agentendpointpb.TaskType_APPLY_PATCHES,
+				Output: &agentendpointpb.ReportTaskCompleteRequest_ApplyPatchesTaskOutput{
+					ApplyPatchesTaskOutput: &agentendpointpb.ApplyPatchesTaskOutput{State: agentendpointpb.ApplyPatchesTaskOutput_SUCCEEDED},
+				},
+			},
+			map[string]string{"task_type": "ApplyPatches", "task_id": "patch-1"},
+			&taskHistoryRow{
+				TaskID:   "patch-1",
+				TaskType: "APPLY_PATCHES",
+				State:    "SUCCEEDED",
+				Labels:   []taskHistoryLabel{{"task_id", "patch-1"}, {"task_type", "ApplyPatches"}},
+			},
+		},
+		{
+			"exec",
+			&agentendpointpb.ReportTaskCompleteRequest{
+				TaskId:       "exec-1",
+				TaskType:     agentendpointpb.TaskType_EXEC_STEP_TASK,
+				ErrorMessage: "exit code 3",
+				Output: &agentendpointpb.ReportTaskCompleteRequest_ExecStepTaskOutput{
+					ExecStepTaskOutput: &agentendpointpb.ExecStepTaskOutput{State: agentendpointpb.ExecStepTaskOutput_COMPLETED, ExitCode: 3},
+				},
+			},
+			nil,
+			&taskHistoryRow{
+				TaskID:       "exec-1",
+				TaskType:     "EXEC_STEP_TASK",
+				State:        "COMPLETED",
+				ErrorMessage: "exit code 3",
+				ExitCode:     &exitCode,
+			},
+		},
+		{
+			"config",
+			&agentendpointpb.ReportTaskCompleteRequest{
+				TaskId:   "config-1",
+				TaskType: agentendpointpb.TaskType_APPLY_CONFIG_TASK,
+				Output: &agentendpointpb.ReportTaskCompleteRequest_ApplyConfigTaskOutput{
+					ApplyConfigTaskOutput: &agentendpointpb.ApplyConfigTaskOutput{
+						State: agentendpointpb.ApplyConfigTaskOutput_SUCCEEDED,
+						OsPolicyResults: []*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{{
+							OsPolicyId:         "policy",
+							OsPolicyAssignment: "assignment",
+							OsPolicyResourceCompliances: []*agentendpointpb.OSPolicyResourceCompliance{
+								{OsPolicyResourceId: "a", State: agentendpointpb.OSPolicyComplianceState_COMPLIANT},
+								{OsPolicyResourceId: "b", State: agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT},
+							},
+						}},
+					},
+				},
+			},
+			nil,
+			&taskHistoryRow{
+				TaskID:   "config-1",
+				TaskType: "APPLY_CONFIG_TASK",
+				State:    "SUCCEEDED",
+				PolicyResults: []taskHistoryPolicy{
+					{"assignment", "policy", "a", "COMPLIANT"},
+					{"assignment", "policy", "b", "NON_COMPLIANT"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got := newTaskHistoryRow(tt.req, tt.labels, now)
+		// Instance identity comes from metadata, not under test here.
+		tt.want.CompleteTime = "2024-05-01T10:00:00Z"
+		tt.want.ProjectID, tt.want.Zone, tt.want.InstanceName, tt.want.InstanceID, tt.want.AgentVersion = got.ProjectID, got.Zone, got.InstanceName, got.InstanceID, got.AgentVersion
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s: newTaskHistoryRow() mismatch (-want +got):\n%s", tt.desc, diff)
+		}
+	}
+}
+
+// TestTaskHistorySchema keeps the published BigQuery schema in sync with the
+// exported rows.
+func TestTaskHistorySchema(t *testing.T) {
+	type field struct {
+		Name   string
+		Fields []field
+	}
+	data, err := os.ReadFile("task_history.bq.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema []field
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{}
+	var walk func(prefix string, fs []field)
+	walk = func(prefix string, fs []field) {
+		for _, f := range fs {
+			want[prefix+f.Name] = true
+			walk(prefix+f.Name+".", f.Fields)
+		}
+	}
+	walk("", schema)
+
+	exitCode := int32(0)
+	row := &taskHistoryRow{
+		ErrorMessage:  "error",
+		ExitCode:      &exitCode,
+		PolicyResults: []taskHistoryPolicy{{}},
+		Labels:        []taskHistoryLabel{{}},
+	}
+	data, err = json.Marshal(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for k, v := range m {
+		got[k] = true
+		if l, ok := v.([]any); ok {
+			for sk := range l[0].(map[string]any) {
+				got[k+"."+sk] = true
+			}
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("task_history.bq.json does not match taskHistoryRow (-schema +row):\n%s", diff)
+	}
+}
+
+func TestAppendTaskHistoryRotates(t *testing.T) {
+	defer func(s func() int64, b func() int) { taskHistoryMaxSize, taskHistoryMaxBackups = s, b }(taskHistoryMaxSize, taskHistoryMaxBackups)
+	taskHistoryMaxSize = func() int64 { return 20 }
+	taskHistoryMaxBackups = func() int { return 1 }
+
+	file := filepath.Join(t.TempDir(), "tasks.ndjson")
+	for _, line := range []string{"{\"task_id\":\"1\"}\n", "{\"task_id\":\"2\"}\n", "{\"task_id\":\"3\"}\n"} {
+		if err := appendTaskHistory(file, []byte(line)); err != nil {
+			t.Fatalf("appendTaskHistory(%q): %v", file, err)
+		}
+	}
+	for path, want := range map[string]string{
+		file:        "{\"task_id\":\"3\"}\n",
+		file + ".1": "{\"task_id\":\"2\"}\n",
+	} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+	if _, err := os.Stat(file + ".2"); !os.IsNotExist(err) {
+		t.Errorf("%s.2: want only 1 backup, got err %v", file, err)
+	}
+}
+
+func TestWriteTaskHistory(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "history", "tasks.ndjson")
+	for _, line := range []string{"{\"task_id\":\"1\"}\n", "{\"task_id\":\"2\"}\n"} {
+		if err := writeTaskHistory(ctx, file, "unused", []byte(line)); err != nil {
+			t.Fatalf("writeTaskHistory(%q): %v", file, err)
+		}
+	}
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"task_id\":\"1\"}\n{\"task_id\":\"2\"}\n"; string(got) != want {
+		t.Errorf("local sink: got %q, want %q", got, want)
+	}
+
+	defer func(f func(context.Context, string, string, []byte) error) { uploadTaskHistory = f }(uploadTaskHistory)
+	var gotBucket, gotObject string
+	uploadTaskHistory = func(_ context.Context, bucket, object string, _ []byte) error {
+		gotBucket, gotObject = bucket, object
+		return nil
+	}
+	if err := writeTaskHistory(ctx, "gs://bucket/fleet/history", "123/task", []byte("{}\n")); err != nil {
+		t.Fatalf("writeTaskHistory(gs://): %v", err)
+	}
+	if gotBucket != "bucket" || gotObject != "fleet/history/123/task.ndjson" {
+		t.Errorf("gcs sink: got gs://%s/%s, want gs://bucket/fleet/history/123/task.ndjson", gotBucket, gotObject)
+	}
+	if err := writeTaskHistory(ctx, "gs://", "123/task", []byte("{}\n")); err == nil {
+		t.Error("writeTaskHistory(gs://): want an error for a missing bucket")
+	}
+}
@@ -29,6 +29,14 @@ type taskState struct {
 	Labels map[string]string `json:",omitempty"`
 }
 
+// patchTaskID returns the ID of the patch task in s, empty if there is none.
+func (s *taskState) patchTaskID() string {
+	if s == nil || s.PatchTask == nil {
+		return ""
+	}
+	return s.PatchTask.TaskID
+}
+
 func (s *taskState) save(path string) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
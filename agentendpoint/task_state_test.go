@@ -15,11 +15,14 @@
 package agentendpoint
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/protobuf/testing/protocmp"
@@ -202,3 +205,112 @@ func TestSaveLoadState(t *testing.T) {
 		t.Errorf("State does not match expectation: (-got +want)\n%s", diff)
 	}
 }
+
+func TestRunApplyPatchesAlreadyPatching(t *testing.T) {
+	ctx := context.Background()
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	defer func(f string) { taskStateFile = f }(taskStateFile)
+	taskStateFile = filepath.Join(td, "testState")
+
+	tests := []struct {
+		name    string
+		pending string
+		// want is the task the run is rejected for, empty if it is not
+		// reported at all as the same task is already being run.
+		want string
+	}{
+		{"PendingInStateFile", "foo", "foo"},
+		{"SamePendingInStateFile", "bar", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &panicTestServer{agentEndpointServiceTestServer: newAgentEndpointServiceTestServer()}
+			tc, err := newTestClient(ctx, srv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tc.close()
+
+			st := &taskState{}
+			if tt.pending != "" {
+				st.PatchTask = &patchTask{TaskID: tt.pending, PatchStep: patching}
+			}
+			if err := st.save(taskStateFile); err != nil {
+				t.Fatal(err)
+			}
+			if err := tc.client.RunApplyPatches(ctx, &agentendpointpb.Task{TaskId: "bar", TaskType: agentendpointpb.TaskType_APPLY_PATCHES}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tt.want == "" {
+				if srv.complete != nil {
+					t.Errorf("ReportTaskComplete called with %v, want it left to the run in progress", srv.complete)
+				}
+			} else {
+				if srv.complete == nil {
+					t.Fatal("expected ReportTaskComplete to have been called")
+				}
+				if got, want := srv.complete.GetApplyPatchesTaskOutput().GetState(), agentendpointpb.ApplyPatchesTaskOutput_FAILED; got != want {
+					t.Errorf("ApplyPatchesTaskOutput state: got %s, want %s", got, want)
+				}
+				if want := `already patching for task "` + tt.want + `"`; !strings.Contains(srv.complete.GetErrorMessage(), want) {
+					t.Errorf("ErrorMessage: got %q, want it to contain %q", srv.complete.GetErrorMessage(), want)
+				}
+			}
+			// The state of the task in progress is kept.
+			got, err := loadState(taskStateFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.patchTaskID() != tt.pending {
+				t.Errorf("patch task in state file: got %q, want %q", got.patchTaskID(), tt.pending)
+			}
+		})
+	}
+}
+
+func TestPatchRunPanic(t *testing.T) {
+	ctx := context.Background()
+	defer func(f string) { taskStateFile = f }(taskStateFile)
+	taskStateFile = filepath.Join(t.TempDir(), "testState")
+	sameStateTimeWindow = 0
+
+	srv := &panicTestServer{agentEndpointServiceTestServer: newAgentEndpointServiceTestServer()}
+	tc, err := newTestClient(ctx, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.close()
+
+	lockFile := filepath.Join(t.TempDir(), "reboot.lock")
+	if ok, err := fileRebootLock(lockFile).acquire(ctx, agentconfig.Instance()); !ok || err != nil {
+		t.Fatalf("acquire: got %t, %v, want the lock", ok, err)
+	}
+
+	// Without a task the patching step panics.
+	r := &patchTask{state: &taskState{}, TaskID: "foo", PatchStep: patching, RebootLock: "file:" + lockFile, client: tc.client}
+	if err := r.saveState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.run(ctx); err == nil || !strings.Contains(err.Error(), "Recovered from panic") {
+		t.Fatalf("run: got %v, want a recovered panic", err)
+	}
+	if srv.complete == nil {
+		t.Error("expected ReportTaskComplete to have been called")
+	}
+
+	st, err := loadState(taskStateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := st.patchTaskID(); got != "" {
+		t.Errorf("patch task in state file after a panic: got %q, want none", got)
+	}
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Errorf("reboot lock after a panic: got %v, want it released", err)
+	}
+}
@@ -0,0 +1,197 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package agentstatus keeps the status of the running agent, its current
+// task, last inventory and OS policy runs and recent errors, and serves it as
+// JSON on a Unix socket or a localhost port so it can be checked without
+// reading the agent logs.
+package agentstatus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+)
+
+// maxErrors is the number of recent errors kept.
+const maxErrors = 20
+
+// Task is a task the agent is running.
+type Task struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+}
+
+// Run is the last run of a recurring activity of the agent.
+type Run struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Error is an error logged by the agent.
+type Error struct {
+	Time    time.Time         `json:"time"`
+	Message string            `json:"message"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// Config is the part of the agent configuration relevant to its health.
+type Config struct {
+	Endpoint                string   `json:"endpoint"`
+	ProjectID               string   `json:"projectId"`
+	Zone                    string   `json:"zone"`
+	InstanceName            string   `json:"instanceName"`
+	OSInventoryEnabled      bool     `json:"osInventoryEnabled"`
+	GuestPoliciesEnabled    bool     `json:"guestPoliciesEnabled"`
+	TaskNotificationEnabled bool     `json:"taskNotificationEnabled"`
+	ReadOnly                bool     `json:"readOnly"`
+	Unprivileged            bool     `json:"unprivileged"`
+	Debug                   bool     `json:"debug"`
+	PollInterval            string   `json:"pollInterval"`
+	FeatureFlags            []string `json:"featureFlags,omitempty"`
+}
+
+// Status is the status of the agent.
+type Status struct {
+	Version               string    `json:"version"`
+	Start                 time.Time `json:"start"`
+	CurrentTask           *Task     `json:"currentTask,omitempty"`
+	LastInventory         *Run      `json:"lastInventory,omitempty"`
+	LastPolicyApplication *Run      `json:"lastPolicyApplication,omitempty"`
+	// OverlappedPolicyCycles are the OS policy assignments skipped since the
+	// agent started as a previous run of them was still in progress.
+	OverlappedPolicyCycles int64   `json:"overlappedPolicyCycles"`
+	Config                 Config  `json:"config"`
+	Errors                 []Error `json:"errors"`
+}
+
+var (
+	mx            sync.Mutex
+	start         = time.Now()
+	currentTask   *Task
+	lastInventory *Run
+	lastPolicy    *Run
+	overlapped    int64
+	errs          []Error
+)
+
+// StartTask records name as the current task, the returned func records its
+// end and restores the task it ran in, if any.
+func StartTask(name string) func() {
+	mx.Lock()
+	defer mx.Unlock()
+	parent := currentTask
+	currentTask = &Task{Name: name, Start: time.Now()}
+	return func() {
+		mx.Lock()
+		defer mx.Unlock()
+		currentTask = parent
+	}
+}
+
+func newRun(start time.Time, err error) *Run {
+	r := &Run{Start: start, End: time.Now()}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// RecordInventory records an inventory run that started at start and
+// failed with err, if not nil.
+func RecordInventory(start time.Time, err error) {
+	mx.Lock()
+	defer mx.Unlock()
+	lastInventory = newRun(start, err)
+}
+
+// RecordPolicyApplication records an application of OS policies that
+// started at start and failed with err, if not nil.
+func RecordPolicyApplication(start time.Time, err error) {
+	mx.Lock()
+	defer mx.Unlock()
+	lastPolicy = newRun(start, err)
+}
+
+// RecordOverlappedPolicyCycle records an OS policy assignment skipped as a
+// previous run of it was still in progress, returning the count so far.
+func RecordOverlappedPolicyCycle() int64 {
+	mx.Lock()
+	defer mx.Unlock()
+	overlapped++
+	return overlapped
+}
+
+// RecordError records an error logged by the agent, only the last
+// maxErrors are kept. It is meant for clog.OnError.
+func RecordError(msg string, labels map[string]string) {
+	e := Error{Time: time.Now(), Message: msg}
+	if len(labels) > 0 {
+		e.Labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			e.Labels[k] = v
+		}
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	errs = append(errs, e)
+	if len(errs) > maxErrors {
+		errs = errs[len(errs)-maxErrors:]
+	}
+}
+
+func configSnapshot() Config {
+	return Config{
+		Endpoint:                agentconfig.SvcEndpoint(),
+		ProjectID:               agentconfig.ProjectID(),
+		Zone:                    agentconfig.Zone(),
+		InstanceName:            agentconfig.Name(),
+		OSInventoryEnabled:      agentconfig.OSInventoryEnabled(),
+		GuestPoliciesEnabled:    agentconfig.GuestPoliciesEnabled(),
+		TaskNotificationEnabled: agentconfig.TaskNotificationEnabled(),
+		ReadOnly:                agentconfig.ReadOnly(),
+		Unprivileged:            agentconfig.Unprivileged(),
+		Debug:                   agentconfig.Debug(),
+		PollInterval:            agentconfig.SvcPollInterval().String(),
+		FeatureFlags:            agentconfig.EnabledFeatureFlags(),
+	}
+}
+
+// Get returns the current status of the agent.
+func Get() *Status {
+	s := &Status{
+		Version: agentconfig.Version(),
+		Config:  configSnapshot(),
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	s.Start = start
+	if currentTask != nil {
+		t := *currentTask
+		s.CurrentTask = &t
+	}
+	if lastInventory != nil {
+		r := *lastInventory
+		s.LastInventory = &r
+	}
+	if lastPolicy != nil {
+		r := *lastPolicy
+		s.LastPolicyApplication = &r
+	}
+	s.OverlappedPolicyCycles = overlapped
+	s.Errors = append([]Error{}, errs...)
+	return s
+}
@@ -0,0 +1,219 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentstatus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func reset() {
+	mx.Lock()
+	defer mx.Unlock()
+	currentTask, lastInventory, lastPolicy, errs = nil, nil, nil, nil
+	overlapped = 0
+}
+
+func TestStartTask(t *testing.T) {
+	defer reset()
+	done := StartTask("TaskNotification")
+	inner := StartTask("APPLY_CONFIG_TASK")
+	if got := Get().CurrentTask.Name; got != "APPLY_CONFIG_TASK" {
+		t.Errorf("current task = %q, want %q", got, "APPLY_CONFIG_TASK")
+	}
+	inner()
+	if got := Get().CurrentTask.Name; got != "TaskNotification" {
+		t.Errorf("current task after inner task = %q, want %q", got, "TaskNotification")
+	}
+	done()
+	if got := Get().CurrentTask; got != nil {
+		t.Errorf("current task after all tasks = %+v, want nil", got)
+	}
+}
+
+func TestRecordRuns(t *testing.T) {
+	defer reset()
+	start := time.Now().Add(-time.Minute)
+	RecordInventory(start, nil)
+	RecordPolicyApplication(start, errors.New("boom"))
+
+	s := Get()
+	if s.LastInventory == nil || !s.LastInventory.Start.Equal(start) || s.LastInventory.End.Before(start) || s.LastInventory.Error != "" {
+		t.Errorf("unexpected last inventory %+v", s.LastInventory)
+	}
+	if s.LastPolicyApplication == nil || s.LastPolicyApplication.Error != "boom" {
+		t.Errorf("unexpected last policy application %+v", s.LastPolicyApplication)
+	}
+
+	// Get returns a copy.
+	s.LastInventory.Error = "changed"
+	if got := Get().LastInventory.Error; got != "" {
+		t.Errorf("last inventory error = %q after modifying a copy, want none", got)
+	}
+}
+
+func TestRecordOverlappedPolicyCycle(t *testing.T) {
+	defer reset()
+	if got := Get().OverlappedPolicyCycles; got != 0 {
+		t.Errorf("overlapped policy cycles = %d at start, want 0", got)
+	}
+	RecordOverlappedPolicyCycle()
+	if got := RecordOverlappedPolicyCycle(); got != 2 {
+		t.Errorf("RecordOverlappedPolicyCycle() = %d, want 2", got)
+	}
+	if got := Get().OverlappedPolicyCycles; got != 2 {
+		t.Errorf("overlapped policy cycles = %d, want 2", got)
+	}
+}
+
+func TestRecordError(t *testing.T) {
+	defer reset()
+	labels := map[string]string{"task_id": "1"}
+	for i := 0; i < maxErrors+5; i++ {
+		RecordError(fmt.Sprintf("error %d", i), labels)
+	}
+	labels["task_id"] = "2"
+
+	got := Get().Errors
+	if len(got) != maxErrors {
+		t.Fatalf("got %d errors, want %d", len(got), maxErrors)
+	}
+	if got[0].Message != "error 5" || got[maxErrors-1].Message != fmt.Sprintf("error %d", maxErrors+4) {
+		t.Errorf("errors kept are %q to %q, want the last %d", got[0].Message, got[maxErrors-1].Message, maxErrors)
+	}
+	if diff := cmp.Diff(map[string]string{"task_id": "1"}, got[0].Labels); diff != "" {
+		t.Errorf("labels mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	defer reset()
+	RecordError("boom", nil)
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+
+	tests := []struct {
+		method   string
+		path     string
+		wantCode int
+		want     any
+	}{
+		{http.MethodGet, "/v1/status", http.StatusOK, &Status{}},
+		{http.MethodGet, "/v1/config", http.StatusOK, &Config{}},
+		{http.MethodGet, "/v1/errors", http.StatusOK, &[]Error{}},
+		{http.MethodPost, "/v1/status", http.StatusMethodNotAllowed, nil},
+		{http.MethodGet, "/v1/unknown", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.path, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status code = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if tt.want == nil {
+				return
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			if err := json.NewDecoder(resp.Body).Decode(tt.want); err != nil {
+				t.Errorf("error decoding response: %v", err)
+			}
+		})
+	}
+}
+
+func TestListen(t *testing.T) {
+	for _, addr := range []string{"unix:", "0.0.0.0:0", "10.0.0.1:8080", "example.com:80", "localhost"} {
+		if l, err := listen(addr); err == nil {
+			l.Close()
+			t.Errorf("listen(%q): want error", addr)
+		}
+	}
+	for _, addr := range []string{"localhost:0", "127.0.0.1:0", "[::1]:0"} {
+		l, err := listen(addr)
+		if err != nil {
+			// Hosts without IPv6 can't listen on ::1.
+			t.Logf("listen(%q): %v", addr, err)
+			continue
+		}
+		l.Close()
+	}
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Unix sockets are only tested on Linux")
+	}
+	defer reset()
+	RecordInventory(time.Now(), nil)
+	socket := filepath.Join(t.TempDir(), "status.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- Serve(ctx, "unix:"+socket) }()
+
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+	}}}
+	var resp *http.Response
+	var err error
+	// Wait for the server to listen.
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Get("http://agent/v1/status"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("error getting status: %v", err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s Status
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatalf("error decoding status %s: %v", b, err)
+	}
+	if s.LastInventory == nil {
+		t.Errorf("status %s has no last inventory", b)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentstatus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const unixPrefix = "unix:"
+
+// Handler serves the status of the agent as JSON:
+//
+//	/v1/status  the whole Status
+//	/v1/config  the Config of the agent
+//	/v1/errors  the recent errors
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/status", get(func() any { return Get() }))
+	mux.HandleFunc("/v1/config", get(func() any { return Get().Config }))
+	mux.HandleFunc("/v1/errors", get(func() any { return Get().Errors }))
+	return mux
+}
+
+func get(f func() any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		b, err := json.MarshalIndent(f(), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(b, '\n'))
+	}
+}
+
+// listen listens on addr, unix:<path> for a Unix socket only its owner can
+// use or a host:port where host is a loopback address.
+func listen(addr string) (net.Listener, error) {
+	if path := strings.TrimPrefix(addr, unixPrefix); path != addr {
+		if path == "" {
+			return nil, fmt.Errorf("invalid status address %q, missing socket path", addr)
+		}
+		// Remove the socket left by an agent that did not shut down cleanly.
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, 0600); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status address %q: %v", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("invalid status address %q, the host must be a loopback address", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Serve serves Handler on addr, see listen, until ctx is done.
+func Serve(ctx context.Context, addr string) error {
+	l, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
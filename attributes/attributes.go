@@ -52,20 +52,28 @@ func PostAttribute(url string, value io.Reader) error {
 
 // PostAttributeCompressed compresses and posts data to Guest Attributes
 func PostAttributeCompressed(url string, body any) error {
+	b, err := compress(body)
+	if err != nil {
+		return err
+	}
+	return PostAttribute(url, bytes.NewReader(b))
+}
+
+// compress JSON encodes, gzips and base64 encodes body.
+func compress(body any) ([]byte, error) {
 	buf := &bytes.Buffer{}
 	b := base64.NewEncoder(base64.StdEncoding, buf)
 	zw := gzip.NewWriter(b)
 	w := json.NewEncoder(zw)
 	if err := w.Encode(body); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := zw.Close(); err != nil {
-		return err
+		return nil, err
 	}
 	if err := b.Close(); err != nil {
-		return err
+		return nil, err
 	}
-
-	return PostAttribute(url, buf)
+	return buf.Bytes(), nil
 }
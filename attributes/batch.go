@@ -0,0 +1,206 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package attributes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// maxAttempts is how often a write is tried, retries back off
+	// exponentially from retryBase up to retryMax with jitter.
+	maxAttempts = 5
+	retryBase   = 500 * time.Millisecond
+	retryMax    = 8 * time.Second
+)
+
+// Metrics counts guest attribute writes of batches since the agent started.
+type Metrics struct {
+	// Writes are the attributes written, Retries the writes retried.
+	Writes, Retries int64
+	// WriteFailures are the attributes not written after all attempts,
+	// VerifyFailures the written attributes that did not read back the same.
+	WriteFailures, VerifyFailures int64
+}
+
+var metrics struct {
+	writes, retries, writeFailures, verifyFailures atomic.Int64
+}
+
+// GetMetrics returns the guest attribute write metrics.
+func GetMetrics() Metrics {
+	return Metrics{
+		Writes:         metrics.writes.Load(),
+		Retries:        metrics.retries.Load(),
+		WriteFailures:  metrics.writeFailures.Load(),
+		VerifyFailures: metrics.verifyFailures.Load(),
+	}
+}
+
+// Batch collects guest attribute writes and flushes them together over one
+// client. Writes to the same URL are coalesced, the last value wins.
+type Batch struct {
+	client *http.Client
+	urls   []string
+	values map[string][]byte
+}
+
+// NewBatch returns an empty Batch.
+func NewBatch() *Batch {
+	return &Batch{client: &http.Client{Timeout: 30 * time.Second}, values: map[string][]byte{}}
+}
+
+// Put adds a write of value to url.
+func (b *Batch) Put(url string, value []byte) {
+	if _, ok := b.values[url]; !ok {
+		b.urls = append(b.urls, url)
+	}
+	b.values[url] = value
+}
+
+// PutCompressed adds a write of body to url, JSON encoded, gzipped and
+// base64 encoded like PostAttributeCompressed.
+func (b *Batch) PutCompressed(url string, body any) error {
+	value, err := compress(body)
+	if err != nil {
+		return err
+	}
+	b.Put(url, value)
+	return nil
+}
+
+// Len returns the number of attributes to write.
+func (b *Batch) Len() int {
+	return len(b.urls)
+}
+
+// Flush writes all attributes of the batch, retrying transient errors with
+// backoff, then reads each written attribute back to verify it. The batch is
+// empty afterwards. The error lists every attribute that was not written or
+// did not verify.
+func (b *Batch) Flush(ctx context.Context) error {
+	urls, values := b.urls, b.values
+	b.urls, b.values = nil, map[string][]byte{}
+
+	var errs []string
+	var written []string
+	for _, url := range urls {
+		if err := b.writeWithRetry(ctx, url, values[url]); err != nil {
+			metrics.writeFailures.Add(1)
+			errs = append(errs, err.Error())
+			continue
+		}
+		metrics.writes.Add(1)
+		written = append(written, url)
+	}
+	for _, url := range written {
+		if err := b.verify(ctx, url, values[url]); err != nil {
+			metrics.verifyFailures.Add(1)
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error writing %d of %d guest attributes: %s", len(errs), len(urls), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (b *Batch) writeWithRetry(ctx context.Context, url string, value []byte) error {
+	delay := retryBase
+	for attempt := 1; ; attempt++ {
+		err := b.do(ctx, http.MethodPut, url, value, nil)
+		if err == nil || attempt >= maxAttempts || !transient(err) {
+			return err
+		}
+		metrics.retries.Add(1)
+
+		// Sleep between half and all of the delay so writers started at
+		// the same time spread out.
+		sleep := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(sleep):
+		}
+		if delay *= 2; delay > retryMax {
+			delay = retryMax
+		}
+	}
+}
+
+func (b *Batch) verify(ctx context.Context, url string, want []byte) error {
+	var got bytes.Buffer
+	if err := b.do(ctx, http.MethodGet, url, nil, &got); err != nil {
+		return fmt.Errorf("verifying %s: %v", url, err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		return fmt.Errorf("verifying %s: read back %d bytes, want the %d bytes written", url, got.Len(), len(want))
+	}
+	return nil
+}
+
+// statusError is a guest attributes response other than 200 OK.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// transient reports whether a write failing with err may succeed if retried,
+// which is the case for errors reaching the metadata server, 429 and 5xx.
+func transient(err error) bool {
+	var se *statusError
+	if !errors.As(err, &se) {
+		return true
+	}
+	return se.code == http.StatusTooManyRequests || se.code >= 500
+}
+
+func (b *Batch) do(ctx context.Context, method, url string, body []byte, out io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Metadata-Flavor", "Google")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg := fmt.Sprintf(`received status code %q for request "%s %s"`, resp.Status, req.Method, req.URL.String())
+		if b, err := io.ReadAll(resp.Body); err == nil {
+			msg = fmt.Sprintf("%s\n Error response: %s", msg, string(b))
+		}
+		return &statusError{code: resp.StatusCode, msg: msg}
+	}
+	if out != nil {
+		_, err = io.Copy(out, resp.Body)
+	}
+	return err
+}
@@ -0,0 +1,157 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package attributes
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// attributeServer stores written attributes, failing the first writes of
+// each URL with the status in failures.
+type attributeServer struct {
+	mx       sync.Mutex
+	attrs    map[string]string
+	failures map[string][]int
+	puts     map[string]int
+	// corrupt makes reads of the URL return something else.
+	corrupt string
+}
+
+func (s *attributeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if r.Header.Get("Metadata-Flavor") != "Google" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodGet {
+		if r.URL.Path == s.corrupt {
+			io.WriteString(w, "something else")
+			return
+		}
+		io.WriteString(w, s.attrs[r.URL.Path])
+		return
+	}
+	s.puts[r.URL.Path]++
+	if f := s.failures[r.URL.Path]; len(f) > 0 {
+		s.failures[r.URL.Path] = f[1:]
+		w.WriteHeader(f[0])
+		return
+	}
+	b, _ := io.ReadAll(r.Body)
+	s.attrs[r.URL.Path] = string(b)
+}
+
+func TestBatchFlush(t *testing.T) {
+	defer func(d time.Duration) { retryBase = d }(retryBase)
+	retryBase = time.Millisecond
+	ctx := context.Background()
+
+	s := &attributeServer{
+		attrs: map[string]string{},
+		puts:  map[string]int{},
+		failures: map[string][]int{
+			"/retried":   {http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			"/permanent": {http.StatusBadRequest},
+			"/exhausted": {500, 500, 500, 500, 500},
+		},
+		corrupt: "/corrupt",
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	before := GetMetrics()
+	b := NewBatch()
+	b.Put(ts.URL+"/ok", []byte("first"))
+	b.Put(ts.URL+"/ok", []byte("last"))
+	b.Put(ts.URL+"/retried", []byte("retried"))
+	b.Put(ts.URL+"/permanent", []byte("permanent"))
+	b.Put(ts.URL+"/exhausted", []byte("exhausted"))
+	b.Put(ts.URL+"/corrupt", []byte("corrupt"))
+	if err := b.PutCompressed(ts.URL+"/compressed", map[string]string{"a": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 6 {
+		t.Errorf("Len: got %d, want 6 with writes to the same URL coalesced", b.Len())
+	}
+
+	err := b.Flush(ctx)
+	if err == nil {
+		t.Fatal("Flush: want an error")
+	}
+	for _, want := range []string{"error writing 3 of 6", "/permanent", "/exhausted", "verifying " + ts.URL + "/corrupt"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Flush error %q does not contain %q", err, want)
+		}
+	}
+	if b.Len() != 0 {
+		t.Errorf("Len after Flush: got %d, want 0", b.Len())
+	}
+
+	if got := s.attrs["/ok"]; got != "last" {
+		t.Errorf("/ok: got %q, want the last value written", got)
+	}
+	if got := s.attrs["/retried"]; got != "retried" {
+		t.Errorf("/retried: got %q, want %q", got, "retried")
+	}
+	if _, err := getDecompressPackageInfo(s.attrs["/compressed"]); err != nil {
+		t.Errorf("/compressed: %v", err)
+	}
+	for url, want := range map[string]int{"/ok": 1, "/retried": 3, "/permanent": 1, "/exhausted": maxAttempts} {
+		if s.puts[url] != want {
+			t.Errorf("%s: got %d writes, want %d", url, s.puts[url], want)
+		}
+	}
+
+	m := GetMetrics()
+	if got := m.Writes - before.Writes; got != 4 {
+		t.Errorf("Writes: got %d, want 4", got)
+	}
+	if got := m.Retries - before.Retries; got != int64(2+maxAttempts-1) {
+		t.Errorf("Retries: got %d, want %d", got, 2+maxAttempts-1)
+	}
+	if got := m.WriteFailures - before.WriteFailures; got != 2 {
+		t.Errorf("WriteFailures: got %d, want 2", got)
+	}
+	if got := m.VerifyFailures - before.VerifyFailures; got != 1 {
+		t.Errorf("VerifyFailures: got %d, want 1", got)
+	}
+}
+
+func TestBatchFlushCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b := NewBatch()
+	b.Put(ts.URL+"/a", []byte("a"))
+	start := time.Now()
+	if err := b.Flush(ctx); err == nil {
+		t.Error("Flush: want an error")
+	}
+	if time.Since(start) > retryBase {
+		t.Errorf("Flush of a canceled context took %s, want no retries", time.Since(start))
+	}
+}
@@ -0,0 +1,153 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package audit emits a structured audit event for every enforcement action
+// of the agent, an OS policy resource enforced, an exec step run or patches
+// applied, to a dedicated append-only log. Events follow Cloud Audit Logs:
+// who acted, the task or OS policy, what was acted on, the outcome and how
+// long it took. They can also be forwarded to Cloud Logging.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+)
+
+// Methods of the audited enforcement actions.
+const (
+	EnforceResource = "EnforceResource"
+	RunExecStep     = "RunExecStep"
+	ApplyPatches    = "ApplyPatches"
+	Reboot          = "Reboot"
+)
+
+// Results of an enforcement action.
+const (
+	Success = "SUCCESS"
+	Failure = "FAILURE"
+)
+
+var (
+	mx sync.Mutex
+	w  io.Writer
+
+	// forward reports whether events are also sent to Cloud Logging.
+	forward = func() bool { return false }
+)
+
+// Event is a single enforcement action. Principal is who requested it, the
+// task or OS policy resource, and Resource what it acted on, a resource ID,
+// command or patch run.
+type Event struct {
+	Time             time.Time    `json:"timestamp"`
+	Principal        string       `json:"principal"`
+	Method           string       `json:"methodName"`
+	Resource         string       `json:"resourceName"`
+	Result           string       `json:"result"`
+	Code             errcode.Code `json:"code,omitempty"`
+	Error            string       `json:"error,omitempty"`
+	Duration         string       `json:"duration"`
+	TaskID           string       `json:"taskId,omitempty"`
+	TaskType         string       `json:"taskType,omitempty"`
+	PolicyAssignment string       `json:"osPolicyAssignment,omitempty"`
+	PolicyID         string       `json:"osPolicyId,omitempty"`
+	ResourceID       string       `json:"resourceId,omitempty"`
+}
+
+// SetWriter sets where events are written, typically a util.RotatingFile.
+// Events are not written until it is set.
+func SetWriter(wr io.Writer) {
+	mx.Lock()
+	defer mx.Unlock()
+	w = wr
+}
+
+// SetForwarding sets the function reporting whether events are also sent to
+// Cloud Logging, it is called for every event so it can follow config
+// changes.
+func SetForwarding(f func() bool) {
+	mx.Lock()
+	defer mx.Unlock()
+	forward = f
+}
+
+// Record emits an audit event for an enforcement action started at start,
+// err is its outcome. The task and OS policy IDs are read from the log
+// labels of ctx. Failing to write the event is logged and does not fail the
+// action.
+func Record(ctx context.Context, method, resource string, start time.Time, err error) {
+	e := newEvent(clog.Labels(ctx), method, resource, start, time.Now(), err)
+
+	mx.Lock()
+	wr, fwd := w, forward
+	mx.Unlock()
+	if fwd() {
+		clog.InfoStructured(ctx, e, "Audit: %s %s by %s: %s", e.Method, e.Resource, e.Principal, e.Result)
+	}
+	if wr == nil {
+		return
+	}
+	if err := write(wr, e); err != nil {
+		clog.Warningf(ctx, "Error writing audit log: %v", err)
+	}
+}
+
+func newEvent(labels map[string]string, method, resource string, start, end time.Time, err error) Event {
+	e := Event{
+		Time:             end.UTC(),
+		Method:           method,
+		Resource:         resource,
+		Result:           Success,
+		Duration:         fmt.Sprintf("%.3fs", end.Sub(start).Seconds()),
+		TaskID:           labels["task_id"],
+		TaskType:         labels["task_type"],
+		PolicyAssignment: labels["os_policy_assignment"],
+		PolicyID:         labels["os_policy_id"],
+		ResourceID:       labels["resource_id"],
+	}
+	switch {
+	case e.ResourceID != "":
+		e.Principal = fmt.Sprintf("%s/%s/%s", e.PolicyAssignment, e.PolicyID, e.ResourceID)
+	case e.TaskID != "":
+		e.Principal = fmt.Sprintf("%s/%s", e.TaskType, e.TaskID)
+	default:
+		e.Principal = "agent"
+	}
+	if err != nil {
+		e.Result = Failure
+		e.Code = errcode.Of(err, "")
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// write writes e as a single JSON line, holding mx so concurrent events are
+// never interleaved.
+func write(wr io.Writer, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	_, err = wr.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,103 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+)
+
+func TestNewEvent(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	end := start.Add(1500 * time.Millisecond)
+
+	tests := []struct {
+		desc          string
+		labels        map[string]string
+		err           error
+		wantPrincipal string
+		wantResult    string
+		wantCode      errcode.Code
+	}{
+		{"policy resource", map[string]string{"os_policy_assignment": "assignment", "os_policy_id": "policy", "resource_id": "resource"}, nil, "assignment/policy/resource", Success, ""},
+		{"task", map[string]string{"task_id": "task1", "task_type": "EXEC_STEP_TASK"}, errors.New("exit code 1"), "EXEC_STEP_TASK/task1", Failure, ""},
+		{"error code", map[string]string{"task_id": "task1", "task_type": "APPLY_PATCHES"}, errcode.Wrap(errcode.PackageManager, errors.New("apt failed")), "APPLY_PATCHES/task1", Failure, errcode.PackageManager},
+		{"no labels", nil, nil, "agent", Success, ""},
+	}
+	for _, tt := range tests {
+		e := newEvent(tt.labels, RunExecStep, "/tmp/script.sh", start, end, tt.err)
+		if e.Principal != tt.wantPrincipal {
+			t.Errorf("%s: Principal = %q, want %q", tt.desc, e.Principal, tt.wantPrincipal)
+		}
+		if e.Result != tt.wantResult {
+			t.Errorf("%s: Result = %q, want %q", tt.desc, e.Result, tt.wantResult)
+		}
+		if e.Code != tt.wantCode {
+			t.Errorf("%s: Code = %q, want %q", tt.desc, e.Code, tt.wantCode)
+		}
+		if (e.Error != "") != (tt.err != nil) {
+			t.Errorf("%s: Error = %q, want error %v", tt.desc, e.Error, tt.err)
+		}
+		if e.Duration != "1.500s" {
+			t.Errorf("%s: Duration = %q, want 1.500s", tt.desc, e.Duration)
+		}
+		if !e.Time.Equal(end) {
+			t.Errorf("%s: Time = %v, want %v", tt.desc, e.Time, end)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	ctx := context.Background()
+	defer SetWriter(nil)
+	defer SetForwarding(func() bool { return false })
+
+	// Nothing is written until a writer is set.
+	Record(ctx, EnforceResource, "ignored", time.Now(), nil)
+
+	var buf bytes.Buffer
+	SetWriter(&buf)
+	var forwarded bool
+	SetForwarding(func() bool { forwarded = true; return false })
+
+	ctx = clog.WithLabels(ctx, map[string]string{"os_policy_assignment": "assignment", "os_policy_id": "policy", "resource_id": "resource"})
+	Record(ctx, EnforceResource, "resource", time.Now(), nil)
+	Record(ctx, EnforceResource, "resource", time.Now(), errors.New("failed"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d audit lines, want 2:\n%s", len(lines), buf.String())
+	}
+	for i, want := range []string{Success, Failure} {
+		var e Event
+		if err := json.Unmarshal([]byte(lines[i]), &e); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if e.Result != want || e.Method != EnforceResource || e.ResourceID != "resource" {
+			t.Errorf("line %d: got %+v, want a %s %s of resource", i, e, want, EnforceResource)
+		}
+	}
+	if !forwarded {
+		t.Error("forwarding setting not checked")
+	}
+}
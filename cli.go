@@ -0,0 +1,116 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// Exit codes of the local CLI actions, inventory -local, policies -once,
+// sbom, status and check, so scripts can tell failures apart.
+const (
+	exitOK = 0
+	// exitError is any failure not covered below.
+	exitError = 1
+	// exitUsage is for invalid flags.
+	exitUsage = 2
+	// exitInvalidInput is for an input file that can not be used, like a
+	// policy file that does not parse.
+	exitInvalidInput = 3
+	// exitNonCompliant is for policies that were applied but have resources
+	// that are not compliant.
+	exitNonCompliant = 4
+)
+
+// cliOptions are the flags shared by the local CLI actions.
+type cliOptions struct {
+	format  string
+	formats []string
+	quiet   bool
+	debug   bool
+}
+
+// register adds the shared flags to fs, the -format flag accepts text, json
+// and any extra formats.
+func (o *cliOptions) register(fs *flag.FlagSet, defaultFormat string, extraFormats ...string) {
+	o.registerFormats(fs, defaultFormat, append([]string{"text", "json"}, extraFormats...)...)
+}
+
+// registerFormats is like register for actions that only write their own
+// formats, errors are then written as text.
+func (o *cliOptions) registerFormats(fs *flag.FlagSet, defaultFormat string, formats ...string) {
+	o.formats = formats
+	fs.StringVar(&o.format, "format", defaultFormat, "output format, "+formatList(o.formats))
+	fs.BoolVar(&o.quiet, "quiet", false, "do not print logs or results, only errors, use the exit code for the outcome")
+	fs.BoolVar(&o.debug, "debug", false, "log debug messages to stderr")
+}
+
+func (o *cliOptions) validate() {
+	if f := o.format; !slices.Contains(o.formats, f) {
+		o.format = "text"
+		o.fail(exitUsage, fmt.Errorf("invalid -format %q, must be %s", f, formatList(o.formats)))
+	}
+}
+
+// formatList returns formats as "a, b or c".
+func formatList(formats []string) string {
+	if len(formats) < 2 {
+		return strings.Join(formats, "")
+	}
+	return strings.Join(formats[:len(formats)-1], ", ") + " or " + formats[len(formats)-1]
+}
+
+// initLogging logs to stderr only, unless quiet.
+func (o *cliOptions) initLogging(ctx context.Context) {
+	var w io.Writer = os.Stderr
+	if o.quiet {
+		w = io.Discard
+	}
+	logger.Init(ctx, logger.LogOpts{LoggerName: "OSConfigAgent", Writers: []io.Writer{w}, DisableLocalLogging: true, DisableCloudLogging: true, Debug: o.debug && !o.quiet})
+	clog.DebugEnabled = o.debug && !o.quiet
+}
+
+// output is where results are written, nothing is written when quiet.
+func (o *cliOptions) output() io.Writer {
+	if o.quiet {
+		return io.Discard
+	}
+	return os.Stdout
+}
+
+// fail reports err and exits with code. In json format the error is written
+// to stdout as {"error": "...", "exitCode": n} so it can be parsed like any
+// other result.
+func (o *cliOptions) fail(code int, err error) {
+	if o.format == "json" {
+		json.NewEncoder(os.Stdout).Encode(struct {
+			Error    string `json:"error"`
+			ExitCode int    `json:"exitCode"`
+		}{err.Error(), code})
+	} else {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,120 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package clog
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// Backend is a native logging backend, like journald or the Windows Event
+// Log, that receives the severity and labels of each entry rather than
+// formatted text. See SetBackend.
+type Backend interface {
+	Log(sev logger.Severity, msg string, labels map[string]string)
+	Close() error
+}
+
+var (
+	backendMx sync.RWMutex
+	backend   Backend
+
+	// backends are the Backend constructors available on this platform
+	// keyed by name, registered by the platform files.
+	backends = map[string]func(identifier string) (Backend, error){}
+)
+
+// NewBackend returns the named Backend, logging as identifier.
+func NewBackend(name, identifier string) (Backend, error) {
+	f, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("log backend %q is not supported on %s", name, runtime.GOOS)
+	}
+	return f(identifier)
+}
+
+// SetBackend sends the entries logged with clog to b in addition to the
+// logger writers, nil removes the backend.
+func SetBackend(b Backend) {
+	backendMx.Lock()
+	defer backendMx.Unlock()
+	backend = b
+}
+
+func logToBackend(sev logger.Severity, msg string, labels map[string]string) {
+	if sev == logger.Debug && !DebugEnabled {
+		return
+	}
+	backendMx.RLock()
+	defer backendMx.RUnlock()
+	if backend != nil {
+		backend.Log(sev, msg, labels)
+	}
+}
+
+// Subsystems of the agent, see subsystem.
+const (
+	subsystemAgent         = "agent"
+	subsystemPatch         = "patch"
+	subsystemExec          = "exec"
+	subsystemConfig        = "config"
+	subsystemGuestPolicies = "guestpolicies"
+)
+
+// eventIDs are the Windows Event Log event IDs per subsystem, 882 is the
+// event ID used by guest-logging-go.
+var eventIDs = map[string]uint32{
+	subsystemAgent:         882,
+	subsystemPatch:         883,
+	subsystemExec:          884,
+	subsystemConfig:        885,
+	subsystemGuestPolicies: 886,
+}
+
+// subsystem returns the agent subsystem an entry is logged by, based on the
+// labels the subsystem adds to its context.
+func subsystem(labels map[string]string) string {
+	switch {
+	case labels["task_type"] == "APPLY_PATCHES" || labels["package-report"] != "":
+		return subsystemPatch
+	case labels["task_type"] == "EXEC_STEP_TASK":
+		return subsystemExec
+	case labels["task_type"] == "APPLY_CONFIG_TASK" || labels["os_policy_assignment"] != "":
+		return subsystemConfig
+	case labels["recipe_name"] != "":
+		return subsystemGuestPolicies
+	}
+	return subsystemAgent
+}
+
+// formatLabels formats labels as sorted key=value lines, each preceded by a
+// newline, for backends without structured fields.
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\n%s=%s", k, labels[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package clog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeBackend struct {
+	entries []string
+}
+
+func (f *fakeBackend) Log(sev logger.Severity, msg string, labels map[string]string) {
+	f.entries = append(f.entries, subsystem(labels)+": "+msg)
+}
+
+func (f *fakeBackend) Close() error { return nil }
+
+func TestBackend(t *testing.T) {
+	defer func(d bool) { DebugEnabled = d }(DebugEnabled)
+	b := &fakeBackend{}
+	SetBackend(b)
+	defer SetBackend(nil)
+
+	ctx := WithLabels(context.Background(), map[string]string{"task_type": "EXEC_STEP_TASK"})
+	DebugEnabled = false
+	Debugf(ctx, "dropped")
+	Infof(ctx, "info")
+	DebugEnabled = true
+	Debugf(context.Background(), "debug")
+
+	want := []string{"exec: info", "agent: debug"}
+	if diff := cmp.Diff(want, b.entries); diff != "" {
+		t.Errorf("backend entries mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFatalfBackend(t *testing.T) {
+	defer func(f func(int)) { exit = f }(exit)
+	defer func(f []func()) { logger.DeferredFatalFuncs = f }(logger.DeferredFatalFuncs)
+	var code int
+	exit = func(c int) { code = c }
+	b := &fakeBackend{}
+	SetBackend(b)
+	defer SetBackend(nil)
+	// The deferred fatal functions close the backend, like in main.
+	var closed bool
+	logger.DeferredFatalFuncs = []func(){func() { closed = len(b.entries) > 0 }}
+
+	Fatalf(context.Background(), "fatal %d", 1)
+
+	if diff := cmp.Diff([]string{"agent: fatal 1"}, b.entries); diff != "" {
+		t.Errorf("backend entries mismatch (-want +got):\n%s", diff)
+	}
+	if !closed {
+		t.Error("deferred fatal functions did not run after the entry was logged")
+	}
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+}
+
+func TestNewBackendUnknown(t *testing.T) {
+	if _, err := NewBackend("unknown", "test"); err == nil {
+		t.Error("NewBackend(unknown): want an error")
+	}
+}
+
+func TestSubsystem(t *testing.T) {
+	tests := []struct {
+		labels map[string]string
+		want   string
+	}{
+		{nil, subsystemAgent},
+		{map[string]string{"task_type": "APPLY_PATCHES", "task_id": "1"}, subsystemPatch},
+		{map[string]string{"package-report": "true"}, subsystemPatch},
+		{map[string]string{"task_type": "EXEC_STEP_TASK"}, subsystemExec},
+		{map[string]string{"task_type": "APPLY_CONFIG_TASK"}, subsystemConfig},
+		{map[string]string{"os_policy_assignment": "a", "os_policy_id": "p"}, subsystemConfig},
+		{map[string]string{"recipe_name": "r"}, subsystemGuestPolicies},
+	}
+	for _, tt := range tests {
+		got := subsystem(tt.labels)
+		if got != tt.want {
+			t.Errorf("subsystem(%v) = %q, want %q", tt.labels, got, tt.want)
+		}
+		if _, ok := eventIDs[got]; !ok {
+			t.Errorf("no event ID for subsystem %q", got)
+		}
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	got := formatLabels(map[string]string{"task_type": "APPLY_PATCHES", "task_id": "1"})
+	if want := "\ntask_id=1\ntask_type=APPLY_PATCHES"; got != want {
+		t.Errorf("formatLabels() = %q, want %q", got, want)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"sync"
 
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
@@ -29,6 +30,22 @@ import (
 // DebugEnabled will log debug messages.
 var DebugEnabled bool
 
+// exit is os.Exit, replaced in tests of Fatalf.
+var exit = os.Exit
+
+var (
+	errorFuncs   []func(msg string, labels map[string]string)
+	errorFuncsMx sync.RWMutex
+)
+
+// OnError registers f to be called with each error logged with clog. Funcs
+// are called synchronously and should not block.
+func OnError(f func(msg string, labels map[string]string)) {
+	errorFuncsMx.Lock()
+	defer errorFuncsMx.Unlock()
+	errorFuncs = append(errorFuncs, f)
+}
+
 // https://golang.org/pkg/context/#WithValue
 type clogKey struct{}
 
@@ -44,6 +61,14 @@ func (l *log) log(structuredPayload any, msg string, sev logger.Severity) {
 	// Set CallDepth 3, one for logger.Log, one for this function, and one for
 	// the calling clog function.
 	logger.Log(logger.LogEntry{Message: msg, StructuredPayload: structuredPayload, Severity: sev, CallDepth: 3, Labels: l.labels})
+	logToBackend(sev, msg, l.labels)
+	if sev == logger.Error {
+		errorFuncsMx.RLock()
+		defer errorFuncsMx.RUnlock()
+		for _, f := range errorFuncs {
+			f(msg, l.labels)
+		}
+	}
 }
 
 // protoToJSON converts a proto message to a generic JSON object for the purpose
@@ -91,6 +116,12 @@ func DebugStructured(ctx context.Context, structuredPayload any, format string,
 	fromContext(ctx).log(structuredPayload, fmt.Sprintf(format, args...), logger.Debug)
 }
 
+// InfoStructured is like Infof but sends structuredPayload instead of the text message
+// to Cloud Logging.
+func InfoStructured(ctx context.Context, structuredPayload any, format string, args ...any) {
+	fromContext(ctx).log(structuredPayload, fmt.Sprintf(format, args...), logger.Info)
+}
+
 // Debugf simulates logger.Debugf and adds context labels.
 func Debugf(ctx context.Context, format string, args ...any) {
 	fromContext(ctx).log(nil, fmt.Sprintf(format, args...), logger.Debug)
@@ -111,6 +142,23 @@ func Errorf(ctx context.Context, format string, args ...any) {
 	fromContext(ctx).log(nil, fmt.Sprintf(format, args...), logger.Error)
 }
 
+// Fatalf simulates logger.Fatalf and adds context labels, the entry also
+// reaches the Backend before the deferred fatal functions close it.
+func Fatalf(ctx context.Context, format string, args ...any) {
+	fromContext(ctx).log(nil, fmt.Sprintf(format, args...), logger.Critical)
+
+	for _, f := range logger.DeferredFatalFuncs {
+		f()
+	}
+	logger.Close()
+	exit(1)
+}
+
+// Labels returns a copy of the labels added to ctx by WithLabels.
+func Labels(ctx context.Context) map[string]string {
+	return fromContext(ctx).clone().labels
+}
+
 func (l *log) clone() *log {
 	l.Lock()
 	defer l.Unlock()
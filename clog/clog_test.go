@@ -44,3 +44,31 @@ func TestWithLabels(t *testing.T) {
 		})
 	}
 }
+
+func TestLabels(t *testing.T) {
+	ctx := WithLabels(context.Background(), map[string]string{"task_id": "1"})
+	got := Labels(ctx)
+	if diff := cmp.Diff(map[string]string{"task_id": "1"}, got); diff != "" {
+		t.Fatalf("Labels mismatch (-want +got):\n%s", diff)
+	}
+
+	// The returned map is a copy.
+	got["task_id"] = "2"
+	if v := Labels(ctx)["task_id"]; v != "1" {
+		t.Errorf("Labels()[task_id] = %q after modifying a copy, want %q", v, "1")
+	}
+}
+
+func TestOnError(t *testing.T) {
+	defer func(f []func(string, map[string]string)) { errorFuncs = f }(errorFuncs)
+	var got []string
+	OnError(func(msg string, labels map[string]string) { got = append(got, msg+" "+labels["task_id"]) })
+
+	ctx := WithLabels(context.Background(), map[string]string{"task_id": "1"})
+	Infof(ctx, "info")
+	Warningf(ctx, "warning")
+	Errorf(ctx, "error %d", 1)
+	if diff := cmp.Diff([]string{"error 1 1"}, got); diff != "" {
+		t.Errorf("OnError calls mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,61 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package clog
+
+import (
+	"strings"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+func init() {
+	backends["eventlog"] = newEventLog
+}
+
+// eventLog logs to the Windows Event Log with an event ID per subsystem, see
+// eventIDs. Labels are appended to the message as key=value lines.
+type eventLog struct {
+	el *eventlog.Log
+}
+
+func newEventLog(source string) (Backend, error) {
+	err := eventlog.InstallAsEventCreate(source, eventlog.Info|eventlog.Warning|eventlog.Error)
+	if err != nil && !strings.Contains(err.Error(), "registry key already exists") {
+		return nil, err
+	}
+	el, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLog{el: el}, nil
+}
+
+func (e *eventLog) Log(sev logger.Severity, msg string, labels map[string]string) {
+	id := eventIDs[subsystem(labels)]
+	msg += formatLabels(labels)
+	switch sev {
+	case logger.Debug, logger.Info:
+		e.el.Info(id, msg)
+	case logger.Warning:
+		e.el.Warning(id, msg)
+	case logger.Error, logger.Critical:
+		e.el.Error(id, msg)
+	}
+}
+
+func (e *eventLog) Close() error {
+	return e.el.Close()
+}
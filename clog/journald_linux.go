@@ -0,0 +1,116 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package clog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// journaldSocket is the socket of the journald native protocol.
+var journaldSocket = "/run/systemd/journal/socket"
+
+func init() {
+	backends["journald"] = newJournald
+}
+
+// journald logs to the systemd journal with the native protocol, labels are
+// sent as OSCONFIG_<LABEL> fields, see
+// https://systemd.io/JOURNAL_NATIVE_PROTOCOL/.
+type journald struct {
+	identifier string
+
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+func newJournald(identifier string) (Backend, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journald{identifier: identifier, conn: conn}, nil
+}
+
+// journaldPriority maps a severity to a syslog priority.
+func journaldPriority(sev logger.Severity) string {
+	switch sev {
+	case logger.Debug:
+		return "7"
+	case logger.Warning:
+		return "4"
+	case logger.Error:
+		return "3"
+	case logger.Critical:
+		return "2"
+	}
+	return "6"
+}
+
+// journaldField returns name as a journal field name, upper case letters,
+// digits and underscores.
+func journaldField(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// appendJournaldField appends a field in the native protocol format, values
+// with newlines are length prefixed.
+func appendJournaldField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+func (j *journald) Log(sev logger.Severity, msg string, labels map[string]string) {
+	var b bytes.Buffer
+	appendJournaldField(&b, "MESSAGE", msg)
+	appendJournaldField(&b, "PRIORITY", journaldPriority(sev))
+	appendJournaldField(&b, "SYSLOG_IDENTIFIER", j.identifier)
+	appendJournaldField(&b, "OSCONFIG_SUBSYSTEM", subsystem(labels))
+	for k, v := range labels {
+		appendJournaldField(&b, "OSCONFIG_"+journaldField(k), v)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	// Logging must not fail the caller, errors are dropped like the other
+	// logger writers do.
+	j.conn.Write(b.Bytes())
+}
+
+func (j *journald) Close() error {
+	return j.conn.Close()
+}
@@ -0,0 +1,82 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package clog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"github.com/google/go-cmp/cmp"
+)
+
+// parseJournald parses a native protocol datagram.
+func parseJournald(t *testing.T, b []byte) map[string]string {
+	fields := map[string]string{}
+	for len(b) > 0 {
+		i := bytes.IndexAny(b, "=\n")
+		if i < 0 {
+			t.Fatalf("malformed field %q", b)
+		}
+		name := string(b[:i])
+		if b[i] == '=' {
+			end := bytes.IndexByte(b, '\n')
+			fields[name] = string(b[i+1 : end])
+			b = b[end+1:]
+			continue
+		}
+		n := binary.LittleEndian.Uint64(b[i+1 : i+9])
+		fields[name] = string(b[i+9 : i+9+int(n)])
+		b = b[i+9+int(n)+1:]
+	}
+	return fields
+}
+
+func TestJournald(t *testing.T) {
+	defer func(s string) { journaldSocket = s }(journaldSocket)
+	journaldSocket = filepath.Join(t.TempDir(), "socket")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	b, err := NewBackend("journald", "OSConfigAgent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	b.Log(logger.Warning, "line one\nline two", map[string]string{"task_type": "APPLY_PATCHES", "package-report": "true"})
+
+	buf := make([]byte, 4096)
+	n, err := l.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"MESSAGE":                 "line one\nline two",
+		"PRIORITY":                "4",
+		"SYSLOG_IDENTIFIER":       "OSConfigAgent",
+		"OSCONFIG_SUBSYSTEM":      "patch",
+		"OSCONFIG_TASK_TYPE":      "APPLY_PATCHES",
+		"OSCONFIG_PACKAGE_REPORT": "true",
+	}
+	if diff := cmp.Diff(want, parseJournald(t, buf[:n])); diff != "" {
+		t.Errorf("journald fields mismatch (-want +got):\n%s", diff)
+	}
+}
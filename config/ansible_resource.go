@@ -0,0 +1,153 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+var (
+	ansibleCmd      = "ansible"
+	ansibleLookPath = exec.LookPath
+	ansibleModuleRe = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+	// The json callback prints one document with the result of each host,
+	// ad-hoc commands only load it with ANSIBLE_LOAD_CALLBACK_PLUGINS.
+	ansibleEnv = []string{"ANSIBLE_STDOUT_CALLBACK=json", "ANSIBLE_LOAD_CALLBACK_PLUGINS=1", "ANSIBLE_NOCOLOR=1", "ANSIBLE_RETRY_FILES_ENABLED=0"}
+)
+
+// AnsibleResource runs an Ansible module against the local host with the
+// ansible ad-hoc command, which has to be installed. The module is run in
+// check mode to check the state, a module reporting changes is not in the
+// desired state, and without check mode to enforce it. Modules without
+// check mode support can not be used.
+type AnsibleResource struct {
+	// Module is the module name, like package or ansible.builtin.lineinfile.
+	Module string `json:"module"`
+	// Args are the module arguments.
+	Args map[string]any `json:"args,omitempty"`
+}
+
+type ansibleResource struct {
+	*AnsibleResource
+
+	args string
+}
+
+// ansibleResult is the result of a module on a host in the json callback
+// output.
+type ansibleResult struct {
+	Changed     bool   `json:"changed"`
+	Failed      bool   `json:"failed"`
+	Skipped     bool   `json:"skipped"`
+	Unreachable bool   `json:"unreachable"`
+	Msg         string `json:"msg"`
+}
+
+type ansibleOutput struct {
+	Plays []struct {
+		Tasks []struct {
+			Hosts map[string]ansibleResult `json:"hosts"`
+		} `json:"tasks"`
+	} `json:"plays"`
+}
+
+func (a *ansibleResource) validate(ctx context.Context) (*ManagedResources, error) {
+	if goos == "windows" {
+		return nil, errors.New("AnsibleResource is not supported on Windows")
+	}
+	if !ansibleModuleRe.MatchString(a.Module) {
+		return nil, fmt.Errorf("AnsibleResource: invalid Module %q", a.Module)
+	}
+	if _, err := ansibleLookPath(ansibleCmd); err != nil {
+		return nil, fmt.Errorf("AnsibleResource: %s is not installed: %v", ansibleCmd, err)
+	}
+	args := a.Args
+	if args == nil {
+		args = map[string]any{}
+	}
+	b, err := json.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("AnsibleResource: invalid Args: %v", err)
+	}
+	a.args = string(b)
+	return nil, nil
+}
+
+func (a *ansibleResource) command(ctx context.Context, check bool) *exec.Cmd {
+	args := []string{"all", "-i", "localhost,", "-c", "local", "-m", a.Module, "-a", a.args}
+	if check {
+		args = append(args, "--check")
+	}
+	cmd := exec.CommandContext(ctx, ansibleCmd, args...)
+	cmd.Env = append(os.Environ(), ansibleEnv...)
+	return cmd
+}
+
+// run runs the module and returns its result. Ansible exits non zero when
+// the module fails, the result is still printed and describes why.
+func (a *ansibleResource) run(ctx context.Context, check bool) (*ansibleResult, error) {
+	stdout, stderr, err := runner.Run(ctx, a.command(ctx, check))
+	var out ansibleOutput
+	if jerr := json.Unmarshal(stdout, &out); jerr != nil || len(out.Plays) == 0 || len(out.Plays[0].Tasks) == 0 {
+		if err == nil {
+			err = jerr
+		}
+		return nil, fmt.Errorf("error running ansible module %q: %v, stderr: %q", a.Module, err, stderr)
+	}
+	res, ok := out.Plays[0].Tasks[0].Hosts["localhost"]
+	if !ok {
+		return nil, fmt.Errorf("error running ansible module %q: no result for localhost", a.Module)
+	}
+	switch {
+	case res.Failed, res.Unreachable:
+		return nil, fmt.Errorf("ansible module %q failed: %s", a.Module, res.Msg)
+	case res.Skipped:
+		return nil, fmt.Errorf("ansible module %q was skipped, it may not support check mode: %s", a.Module, res.Msg)
+	}
+	return &res, nil
+}
+
+func (a *ansibleResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	res, err := a.run(ctx, true)
+	if err != nil {
+		return false, err
+	}
+	return !res.Changed, nil
+}
+
+func (a *ansibleResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Enforcing ansible module %q.", a.Module)
+	if _, err := a.run(ctx, false); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (a *ansibleResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {
+}
+
+func (a *ansibleResource) cleanup(ctx context.Context) error {
+	return nil
+}
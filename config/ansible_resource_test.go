@@ -0,0 +1,120 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestAnsibleResourceValidate(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	defer func(f func(string) (string, error)) { ansibleLookPath = f }(ansibleLookPath)
+	ansibleLookPath = func(string) (string, error) { return "/usr/bin/ansible", nil }
+
+	var tests = []struct {
+		name    string
+		a       *AnsibleResource
+		wantErr bool
+	}{
+		{"Module", &AnsibleResource{Module: "package", Args: map[string]any{"name": "nginx", "state": "present"}}, false},
+		{"FQCN", &AnsibleResource{Module: "ansible.builtin.lineinfile", Args: map[string]any{"path": "/etc/motd", "line": "hi"}}, false},
+		{"NoArgs", &AnsibleResource{Module: "ping"}, false},
+		{"NoModule", &AnsibleResource{}, true},
+		{"BadModule", &AnsibleResource{Module: "shell -a id"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{Local: &LocalResource{Ansible: tt.a}}
+			err := pr.Validate(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	ansibleLookPath = func(string) (string, error) { return "", exec.ErrNotFound }
+	pr := &OSPolicyResource{Local: &LocalResource{Ansible: &AnsibleResource{Module: "ping"}}}
+	if err := pr.Validate(ctx); err == nil {
+		t.Error("Expected Validate error without ansible installed")
+	}
+}
+
+func TestAnsibleResourceCheckAndEnforce(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	defer func(f func(string) (string, error)) { ansibleLookPath = f }(ansibleLookPath)
+	ansibleLookPath = func(string) (string, error) { return "/usr/bin/ansible", nil }
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = mockCommandRunner
+
+	pr := &OSPolicyResource{Local: &LocalResource{Ansible: &AnsibleResource{Module: "package", Args: map[string]any{"name": "nginx", "state": "present"}}}}
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+	ansible := func(extra ...string) *exec.Cmd {
+		cmd := exec.Command(ansibleCmd, append([]string{"all", "-i", "localhost,", "-c", "local", "-m", "package", "-a", `{"name":"nginx","state":"present"}`}, extra...)...)
+		cmd.Env = append(os.Environ(), ansibleEnv...)
+		return cmd
+	}
+	output := func(result string) []byte {
+		return []byte(`{"custom_stats": {}, "plays": [{"play": {"name": "Ansible Ad-Hoc"}, "tasks": [{"hosts": {"localhost": ` + result + `}, "task": {"name": "package"}}]}]}`)
+	}
+
+	var tests = []struct {
+		name    string
+		out     []byte
+		err     error
+		want    bool
+		wantErr bool
+	}{
+		{"OK", output(`{"changed": false, "msg": ""}`), nil, true, false},
+		{"Changed", output(`{"changed": true}`), nil, false, false},
+		{"Failed", output(`{"changed": false, "failed": true, "msg": "No package matching 'nginx'"}`), errors.New("exit status 2"), false, true},
+		{"Skipped", output(`{"changed": false, "skipped": true, "msg": "check mode not supported"}`), nil, false, true},
+		{"NoOutput", nil, errors.New("exit status 1"), false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(ansible("--check"))).Return(tt.out, nil, tt.err)
+			err := pr.CheckState(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckState() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got := pr.InDesiredState(); got != tt.want {
+				t.Errorf("InDesiredState() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(ansible())).Return(output(`{"changed": true}`), nil, nil)
+	if err := pr.EnforceState(ctx); err != nil {
+		t.Fatalf("Unexpected EnforceState error: %v", err)
+	}
+}
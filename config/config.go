@@ -19,17 +19,41 @@ import (
 	"errors"
 	"fmt"
 	"runtime"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/audit"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
 
 	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
 )
 
-var goos = runtime.GOOS
+var (
+	goos = runtime.GOOS
+
+	resourceTypeBlocked = agentconfig.ResourceTypeBlocked
+	fileRollback        = agentconfig.FileRollback
+	fileBackupDir       = agentconfig.FileBackupDir
+	arCredentialHelpers = agentconfig.ARCredentialHelpers
+)
 
 // OSPolicyResource is a single OSPolicy resource.
 type OSPolicyResource struct {
 	resource
 	*agentendpointpb.OSPolicy_Resource
 
+	// Local is used in place of ResourceType for resource types that are
+	// not part of the OS Config API.
+	Local *LocalResource
+	// ExecExitCodes replaces the exit codes of the validate step of an exec
+	// resource.
+	ExecExitCodes *ExecExitCodes
+	// FileChecksum sets how a file resource is compared with its source.
+	FileChecksum *FileChecksum
+	// PackageOptions sets how a pkg resource is enforced.
+	PackageOptions *PackageOptions
+
 	managedResources *ManagedResources
 	inDesiredState   bool
 }
@@ -63,22 +87,51 @@ type ManagedResources struct {
 // Validate validates this resource.
 // Validate must be called before other methods.
 func (r *OSPolicyResource) Validate(ctx context.Context) error {
+	var typ string
 	switch x := r.GetResourceType().(type) {
 	case *agentendpointpb.OSPolicy_Resource_Pkg:
-		r.resource = resource(&packageResouce{OSPolicy_Resource_PackageResource: x.Pkg})
+		typ = "pkg"
+		r.resource = resource(&packageResouce{OSPolicy_Resource_PackageResource: x.Pkg, options: r.PackageOptions})
 	case *agentendpointpb.OSPolicy_Resource_Repository:
+		typ = "repository"
 		r.resource = resource(&repositoryResource{OSPolicy_Resource_RepositoryResource: x.Repository})
 	case *agentendpointpb.OSPolicy_Resource_File_:
-		r.resource = resource(&fileResource{OSPolicy_Resource_FileResource: x.File})
+		typ = "file"
+		r.resource = resource(&fileResource{OSPolicy_Resource_FileResource: x.File, checksum: r.FileChecksum})
 	case *agentendpointpb.OSPolicy_Resource_Exec:
-		r.resource = resource(&execResource{OSPolicy_Resource_ExecResource: x.Exec})
+		typ = "exec"
+		r.resource = resource(&execResource{OSPolicy_Resource_ExecResource: x.Exec, exitCodes: r.ExecExitCodes})
 
 	case nil:
-		return errors.New("ResourceType field not set")
+		if r.Local == nil {
+			return errors.New("ResourceType field not set")
+		}
+		res, err := r.Local.resource()
+		if err != nil {
+			return err
+		}
+		typ = r.Local.typeName()
+		r.resource = res
 	default:
 		return fmt.Errorf("ResourceType has unexpected type: %T", x)
 	}
 
+	if r.ExecExitCodes != nil && typ != "exec" {
+		return fmt.Errorf("exit codes can only be set for exec resources, not %q", typ)
+	}
+	if r.FileChecksum != nil && typ != "file" {
+		return fmt.Errorf("file checksum can only be set for file resources, not %q", typ)
+	}
+	if r.PackageOptions != nil && typ != "pkg" {
+		return fmt.Errorf("package options can only be set for pkg resources, not %q", typ)
+	}
+
+	// Blocked resources fail validation so they are never checked or
+	// enforced.
+	if resourceTypeBlocked(typ) {
+		return errcode.Wrap(errcode.Blocked, fmt.Errorf("resource type %q is blocked by local policy", typ))
+	}
+
 	var err error
 	r.managedResources, err = r.validate(ctx)
 	return err
@@ -103,11 +156,35 @@ func (r *OSPolicyResource) EnforceState(ctx context.Context) error {
 		return errors.New("EnforceState run before Validate")
 	}
 
+	start := time.Now()
 	inDesiredState, err := r.enforceState(ctx)
 	r.inDesiredState = inDesiredState
+	journal.Record(ctx, journal.ResourceEnforce, r.GetId(), err)
+	audit.Record(ctx, audit.EnforceResource, r.GetId(), start, err)
 	return err
 }
 
+// rollbacker is implemented by resources that keep what enforceState
+// replaced, so it can be restored.
+type rollbacker interface {
+	rollback(context.Context) (bool, error)
+}
+
+// Rollback restores what the last EnforceState replaced, for resource types
+// that support it, currently files. It reports whether anything was restored,
+// after which the resource is no longer in its desired state.
+func (r *OSPolicyResource) Rollback(ctx context.Context) (bool, error) {
+	rb, ok := r.resource.(rollbacker)
+	if !ok {
+		return false, nil
+	}
+	restored, err := rb.rollback(ctx)
+	if restored {
+		r.inDesiredState = false
+	}
+	return restored, err
+}
+
 // PopulateOutput populates the output field of the provided
 // OSPolicyResourceCompliance for this resource.
 func (r *OSPolicyResource) PopulateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) error {
@@ -15,7 +15,14 @@
 package config
 
 import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
 )
 
 func init() {
@@ -27,3 +34,37 @@ func init() {
 	packages.ZypperExists = true
 	packages.MSIExists = true
 }
+
+func TestValidateBlockedResourceType(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func(string) bool) { resourceTypeBlocked = f }(resourceTypeBlocked)
+	resourceTypeBlocked = func(typ string) bool { return typ == "exec" || typ == "hostEntry" }
+
+	exec := &agentendpointpb.OSPolicy_Resource_ExecResource{
+		Validate: &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{
+			Source:      &agentendpointpb.OSPolicy_Resource_ExecResource_Exec_Script{Script: "validate"},
+			Interpreter: agentendpointpb.OSPolicy_Resource_ExecResource_Exec_SHELL,
+		},
+	}
+	var tests = []struct {
+		name        string
+		pr          *OSPolicyResource
+		wantBlocked bool
+	}{
+		{"Exec", &OSPolicyResource{OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{ResourceType: &agentendpointpb.OSPolicy_Resource_Exec{Exec: exec}}}, true},
+		{"HostEntry", &OSPolicyResource{Local: &LocalResource{HostEntry: &HostEntryResource{IP: "10.0.0.1", Hostnames: []string{"api"}}}}, true},
+		{"Timezone", &OSPolicyResource{Local: &LocalResource{Timezone: &TimezoneResource{Timezone: "UTC"}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.pr.Validate(ctx)
+			blocked := err != nil && strings.Contains(err.Error(), "blocked by local policy")
+			if blocked != tt.wantBlocked {
+				t.Fatalf("Validate() error = %v, want blocked %t", err, tt.wantBlocked)
+			}
+			if blocked && errcode.Of(err, "") != errcode.Blocked {
+				t.Errorf("Validate() error code = %q, want %q", errcode.Of(err, ""), errcode.Blocked)
+			}
+		})
+	}
+}
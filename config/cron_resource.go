@@ -0,0 +1,165 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+var (
+	cronDir         = "/etc/cron.d"
+	cronDefaultFile = "google-osconfig-agent"
+
+	// cron ignores files in cron.d with other characters, like dots.
+	cronFileRe   = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	cronNameRe   = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+	cronFieldRe  = regexp.MustCompile(`^[a-zA-Z0-9*/,-]+$`)
+	cronSpecials = map[string]bool{"@reboot": true, "@yearly": true, "@annually": true, "@monthly": true, "@weekly": true, "@daily": true, "@midnight": true, "@hourly": true}
+)
+
+// CronResource ensures an entry in a /etc/cron.d file. Entries are kept
+// between markers so other entries in the file are left alone.
+type CronResource struct {
+	// Name identifies the entry within the file.
+	Name string `json:"name"`
+	// Schedule is five cron time fields or a special like @daily.
+	Schedule string `json:"schedule,omitempty"`
+	// User runs the command, root is used if not set.
+	User    string `json:"user,omitempty"`
+	Command string `json:"command,omitempty"`
+	// File is the file name in /etc/cron.d, google-osconfig-agent is used
+	// if not set.
+	File  string `json:"file,omitempty"`
+	State State  `json:"state,omitempty"`
+}
+
+type cronResource struct {
+	*CronResource
+
+	state      State
+	path, user string
+}
+
+func validCronSchedule(s string) bool {
+	f := strings.Fields(s)
+	if len(f) == 1 {
+		return cronSpecials[f[0]]
+	}
+	if len(f) != 5 {
+		return false
+	}
+	for _, field := range f {
+		if !cronFieldRe.MatchString(field) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *cronResource) validate(ctx context.Context) (*ManagedResources, error) {
+	if goos == "windows" {
+		return nil, errors.New("CronResource is not supported on Windows")
+	}
+	var err error
+	if c.state, err = c.State.validate(); err != nil {
+		return nil, fmt.Errorf("CronResource: %v", err)
+	}
+	if !cronNameRe.MatchString(c.Name) {
+		return nil, fmt.Errorf("CronResource: invalid Name %q", c.Name)
+	}
+	file := c.File
+	if file == "" {
+		file = cronDefaultFile
+	}
+	if !cronFileRe.MatchString(file) {
+		return nil, fmt.Errorf("CronResource: invalid File %q, cron only reads files named with letters, digits, '-' and '_'", c.File)
+	}
+	c.path = filepath.Join(cronDir, file)
+	if c.state == StateAbsent {
+		return nil, nil
+	}
+
+	if !validCronSchedule(c.Schedule) {
+		return nil, fmt.Errorf("CronResource: invalid Schedule %q", c.Schedule)
+	}
+	c.user = c.User
+	if c.user == "" {
+		c.user = "root"
+	}
+	if strings.ContainsAny(c.user, " \t\n") {
+		return nil, fmt.Errorf("CronResource: invalid User %q", c.User)
+	}
+	if strings.TrimSpace(c.Command) == "" || strings.ContainsAny(c.Command, "\r\n") {
+		return nil, errors.New("CronResource: Command is required and can not contain newlines")
+	}
+	return nil, nil
+}
+
+// block returns the entry lines, or nil for the absent state.
+func (c *cronResource) block() []string {
+	if c.state == StateAbsent {
+		return nil
+	}
+	return []string{strings.Join(strings.Fields(c.Schedule), " ") + " " + c.user + " " + c.Command}
+}
+
+func (c *cronResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	lines, _, err := readLines(c.path)
+	if err != nil {
+		return false, err
+	}
+	block, found := managedBlock(lines, c.Name)
+	if c.state == StateAbsent {
+		return !found, nil
+	}
+	return found && linesEqual(block, c.block()), nil
+}
+
+func (c *cronResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Enforcing state %q for cron entry %q in %q.", c.state, c.Name, c.path)
+	lines, mode, err := readLines(c.path)
+	if err != nil {
+		return false, err
+	}
+	lines = replaceManagedBlock(lines, c.Name, c.block())
+
+	// Remove the file once the last entry is gone.
+	if len(lines) == 0 {
+		if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		return true, nil
+	}
+	if err := writeLines(c.path, lines, mode); err != nil {
+		return false, fmt.Errorf("error writing %q: %v", c.path, err)
+	}
+	return true, nil
+}
+
+func (c *cronResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {}
+
+func (c *cronResource) cleanup(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,116 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+func TestCronResourceValidate(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+
+	var tests = []struct {
+		name    string
+		cr      *CronResource
+		wantErr bool
+	}{
+		{"Fields", &CronResource{Name: "backup", Schedule: "*/15 2-4 * * mon-fri", Command: "/usr/local/bin/backup"}, false},
+		{"Special", &CronResource{Name: "backup", Schedule: "@daily", User: "backup", Command: "/usr/local/bin/backup", File: "backups"}, false},
+		{"Absent", &CronResource{Name: "backup", State: "absent"}, false},
+		{"BadName", &CronResource{Name: "my backup", Schedule: "@daily", Command: "true"}, true},
+		{"BadFile", &CronResource{Name: "backup", Schedule: "@daily", Command: "true", File: "backup.cron"}, true},
+		{"TooFewFields", &CronResource{Name: "backup", Schedule: "0 2 * *", Command: "true"}, true},
+		{"BadField", &CronResource{Name: "backup", Schedule: "0 2 * * ;", Command: "true"}, true},
+		{"BadSpecial", &CronResource{Name: "backup", Schedule: "@sometimes", Command: "true"}, true},
+		{"NoCommand", &CronResource{Name: "backup", Schedule: "@daily"}, true},
+		{"MultilineCommand", &CronResource{Name: "backup", Schedule: "@daily", Command: "true\nfalse"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{Local: &LocalResource{Cron: tt.cr}}
+			err := pr.Validate(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronResource(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	defer func(d string) { cronDir = d }(cronDir)
+	cronDir = t.TempDir()
+	path := filepath.Join(cronDir, cronDefaultFile)
+
+	backup := &OSPolicyResource{Local: &LocalResource{Cron: &CronResource{Name: "backup", Schedule: "0  2 * * *", Command: "/usr/local/bin/backup > /dev/null"}}}
+	rotate := &OSPolicyResource{Local: &LocalResource{Cron: &CronResource{Name: "rotate", Schedule: "@weekly", User: "logs", Command: "rotate"}}}
+	for _, pr := range []*OSPolicyResource{backup, rotate} {
+		if err := pr.Validate(ctx); err != nil {
+			t.Fatalf("Unexpected Validate error: %v", err)
+		}
+		if err := pr.CheckState(ctx); err != nil {
+			t.Fatalf("Unexpected CheckState error: %v", err)
+		}
+		if pr.InDesiredState() {
+			t.Fatal("Unexpected InDesiredState before enforce")
+		}
+		if err := pr.EnforceState(ctx); err != nil {
+			t.Fatalf("Unexpected EnforceState error: %v", err)
+		}
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# BEGIN google-osconfig-agent backup\n" +
+		"0 2 * * * root /usr/local/bin/backup > /dev/null\n" +
+		"# END google-osconfig-agent backup\n" +
+		"# BEGIN google-osconfig-agent rotate\n" +
+		"@weekly logs rotate\n" +
+		"# END google-osconfig-agent rotate\n"
+	if string(got) != want {
+		t.Errorf("Unexpected cron file, got:\n%s\nwant:\n%s", got, want)
+	}
+	if err := backup.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if !backup.InDesiredState() {
+		t.Error("Expected InDesiredState after enforce")
+	}
+
+	// Removing the last entry removes the file.
+	for _, name := range []string{"backup", "rotate"} {
+		pr := &OSPolicyResource{Local: &LocalResource{Cron: &CronResource{Name: name, State: "absent"}}}
+		if err := pr.Validate(ctx); err != nil {
+			t.Fatalf("Unexpected Validate error: %v", err)
+		}
+		if err := pr.EnforceState(ctx); err != nil {
+			t.Fatalf("Unexpected EnforceState error: %v", err)
+		}
+	}
+	if util.Exists(path) {
+		t.Error("Expected the cron file to be removed with its last entry")
+	}
+}
@@ -0,0 +1,224 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+// DNSResolverResource ensures resolv.conf has name servers and search
+// domains. It is only supported on Linux, with a resolv.conf that is not a
+// link to one generated by a resolver service such as systemd-resolved.
+type DNSResolverResource struct {
+	// Nameservers are added before the existing name servers, so they are
+	// tried first.
+	Nameservers []string `json:"nameservers,omitempty"`
+	// Search are added after the existing search domains.
+	Search []string `json:"search,omitempty"`
+	State  State    `json:"state,omitempty"`
+	// Path overrides /etc/resolv.conf.
+	Path string `json:"path,omitempty"`
+}
+
+type dnsResolverResource struct {
+	*DNSResolverResource
+
+	path  string
+	state State
+}
+
+func (d *dnsResolverResource) validate(ctx context.Context) (*ManagedResources, error) {
+	if goos == "windows" {
+		return nil, errors.New("DNSResolverResource: not supported on Windows")
+	}
+	var err error
+	if d.state, err = d.State.validate(); err != nil {
+		return nil, fmt.Errorf("DNSResolverResource: %v", err)
+	}
+	if len(d.Nameservers) == 0 && len(d.Search) == 0 {
+		return nil, errors.New("DNSResolverResource: at least one name server or search domain is required")
+	}
+	for _, ns := range d.Nameservers {
+		if net.ParseIP(ns) == nil {
+			return nil, fmt.Errorf("DNSResolverResource: invalid name server %q", ns)
+		}
+	}
+	for _, s := range d.Search {
+		if s == "" || strings.ContainsAny(s, " \t#;") {
+			return nil, fmt.Errorf("DNSResolverResource: invalid search domain %q", s)
+		}
+	}
+	d.path = d.Path
+	if d.path == "" {
+		d.path = "/etc/resolv.conf"
+	}
+	// Writing a link would replace it, and the resolver service would
+	// overwrite the changes anyway.
+	if fi, err := os.Lstat(d.path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return nil, fmt.Errorf("DNSResolverResource: %q is a link, it is managed by a resolver service", d.path)
+	}
+	return nil, nil
+}
+
+// resolvFields returns the fields of a resolv.conf line without its comment.
+func resolvFields(ln string) []string {
+	if i := strings.IndexAny(ln, "#;"); i != -1 {
+		ln = ln[:i]
+	}
+	return strings.Fields(ln)
+}
+
+func (d *dnsResolverResource) nameserver(ip string) bool {
+	addr := net.ParseIP(ip)
+	for _, ns := range d.Nameservers {
+		if addr.Equal(net.ParseIP(ns)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dnsResolverResource) domain(name string) bool {
+	for _, s := range d.Search {
+		if strings.EqualFold(strings.TrimSuffix(s, "."), strings.TrimSuffix(name, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// update returns lines with the name servers and search domains made
+// present or absent and whether anything changed.
+func (d *dnsResolverResource) update(lines []string) ([]string, bool) {
+	var out []string
+	var changed bool
+	foundNS := map[string]bool{}
+	foundSearch := map[string]bool{}
+	firstNS, lastSearch := -1, -1
+	for _, ln := range lines {
+		f := resolvFields(ln)
+		switch {
+		case len(f) == 2 && f[0] == "nameserver" && d.nameserver(f[1]):
+			if d.state == StateAbsent {
+				changed = true
+				continue
+			}
+			foundNS[net.ParseIP(f[1]).String()] = true
+		case len(f) > 0 && f[0] == "search" && d.state == StateAbsent:
+			keep := []string{"search"}
+			for _, s := range f[1:] {
+				if !d.domain(s) {
+					keep = append(keep, s)
+				}
+			}
+			if len(keep) == len(f) {
+				break
+			}
+			changed = true
+			if len(keep) > 1 {
+				out = append(out, strings.Join(keep, " "))
+			}
+			continue
+		case len(f) > 0 && f[0] == "search":
+			// Only the last search line is used.
+			lastSearch = len(out)
+			foundSearch = map[string]bool{}
+			for _, s := range f[1:] {
+				foundSearch[strings.ToLower(strings.TrimSuffix(s, "."))] = true
+			}
+		}
+		if len(f) > 0 && f[0] == "nameserver" && firstNS == -1 {
+			firstNS = len(out)
+		}
+		out = append(out, ln)
+	}
+	if d.state == StateAbsent {
+		return out, changed
+	}
+
+	var search []string
+	for _, s := range d.Search {
+		if key := strings.ToLower(strings.TrimSuffix(s, ".")); !foundSearch[key] {
+			foundSearch[key] = true
+			search = append(search, s)
+		}
+	}
+	if len(search) > 0 {
+		changed = true
+		if lastSearch == -1 {
+			out = append(out, "search "+strings.Join(search, " "))
+		} else {
+			out[lastSearch] = strings.Join(append(resolvFields(out[lastSearch]), search...), " ")
+		}
+	}
+
+	var nameservers []string
+	for _, ns := range d.Nameservers {
+		if key := net.ParseIP(ns).String(); !foundNS[key] {
+			foundNS[key] = true
+			nameservers = append(nameservers, "nameserver "+ns)
+		}
+	}
+	if len(nameservers) > 0 {
+		changed = true
+		if firstNS == -1 {
+			out = append(out, nameservers...)
+		} else {
+			out = append(out[:firstNS], append(nameservers, out[firstNS:]...)...)
+		}
+	}
+	return out, changed
+}
+
+func (d *dnsResolverResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	lines, _, err := readLines(d.path)
+	if err != nil {
+		return false, err
+	}
+	_, changed := d.update(lines)
+	return !changed, nil
+}
+
+func (d *dnsResolverResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Enforcing state %q for name servers %q and search domains %q in %q.", d.state, d.Nameservers, d.Search, d.path)
+	lines, mode, err := readLines(d.path)
+	if err != nil {
+		return false, err
+	}
+	lines, changed := d.update(lines)
+	if !changed {
+		return true, nil
+	}
+	if err := writeLines(d.path, lines, mode); err != nil {
+		return false, fmt.Errorf("error writing %q: %v", d.path, err)
+	}
+	return true, nil
+}
+
+func (d *dnsResolverResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {
+}
+
+func (d *dnsResolverResource) cleanup(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,144 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testResolvConf = `# Generated by the image build
+domain example.com
+search example.com
+nameserver 10.0.0.2
+options edns0
+`
+
+func TestDNSResolverResourceValidate(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+
+	dir := t.TempDir()
+	link := filepath.Join(dir, "resolv.conf")
+	if err := os.Symlink(filepath.Join(dir, "stub-resolv.conf"), link); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name    string
+		goos    string
+		dr      *DNSResolverResource
+		wantErr bool
+	}{
+		{"Valid", "linux", &DNSResolverResource{Nameservers: []string{"10.0.0.1", "fd00::1"}, Search: []string{"internal"}}, false},
+		{"Empty", "linux", &DNSResolverResource{}, true},
+		{"BadNameserver", "linux", &DNSResolverResource{Nameservers: []string{"dns.internal"}}, true},
+		{"BadSearch", "linux", &DNSResolverResource{Search: []string{"a b"}}, true},
+		{"BadState", "linux", &DNSResolverResource{Search: []string{"internal"}, State: "gone"}, true},
+		{"Link", "linux", &DNSResolverResource{Search: []string{"internal"}, Path: link}, true},
+		{"Windows", "windows", &DNSResolverResource{Search: []string{"internal"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goos = tt.goos
+			pr := &OSPolicyResource{Local: &LocalResource{DNSResolver: tt.dr}}
+			err := pr.Validate(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDNSResolverResourceCheckAndEnforce(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	var tests = []struct {
+		name string
+		dr   DNSResolverResource
+		want string
+	}{
+		{
+			"PresentNameserversFirst",
+			DNSResolverResource{Nameservers: []string{"10.0.0.1", "10.0.0.2"}},
+			`# Generated by the image build
+domain example.com
+search example.com
+nameserver 10.0.0.1
+nameserver 10.0.0.2
+options edns0
+`,
+		},
+		{
+			"PresentSearchAppended",
+			DNSResolverResource{Search: []string{"Example.com.", "internal"}},
+			`# Generated by the image build
+domain example.com
+search example.com internal
+nameserver 10.0.0.2
+options edns0
+`,
+		},
+		{
+			"Absent",
+			DNSResolverResource{Nameservers: []string{"10.0.0.2"}, Search: []string{"example.com"}, State: StateAbsent},
+			`# Generated by the image build
+domain example.com
+options edns0
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "resolv.conf")
+			if err := os.WriteFile(path, []byte(testResolvConf), 0644); err != nil {
+				t.Fatal(err)
+			}
+			tt.dr.Path = path
+			pr := &OSPolicyResource{Local: &LocalResource{DNSResolver: &tt.dr}}
+			if err := pr.Validate(ctx); err != nil {
+				t.Fatalf("Unexpected Validate error: %v", err)
+			}
+
+			if err := pr.CheckState(ctx); err != nil {
+				t.Fatalf("Unexpected CheckState error: %v", err)
+			}
+			if pr.InDesiredState() {
+				t.Fatal("Unexpected InDesiredState before enforce")
+			}
+
+			if err := pr.EnforceState(ctx); err != nil {
+				t.Fatalf("Unexpected EnforceState error: %v", err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Unexpected resolv.conf, got:\n%s\nwant:\n%s", got, tt.want)
+			}
+
+			if err := pr.CheckState(ctx); err != nil {
+				t.Fatalf("Unexpected CheckState error: %v", err)
+			}
+			if !pr.InDesiredState() {
+				t.Error("Unexpected InDesiredState after enforce")
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+var dscNameRe = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// DSCResource runs a PowerShell Desired State Configuration resource with
+// Invoke-DscResource, its Test method checks the state and Set enforces it.
+// On Windows PowerShell 5.1 this requires the LCM RefreshMode to be
+// Disabled.
+type DSCResource struct {
+	// Name is the DSC resource, like File or WindowsFeature.
+	Name string `json:"name"`
+	// ModuleName is the module providing the resource, like
+	// PSDesiredStateConfiguration, and ModuleVersion optionally pins its
+	// version.
+	ModuleName    string `json:"moduleName"`
+	ModuleVersion string `json:"moduleVersion,omitempty"`
+	// Properties are the resource properties, strings, numbers, booleans or
+	// arrays of them.
+	Properties map[string]any `json:"properties,omitempty"`
+}
+
+type dscResource struct {
+	*DSCResource
+
+	properties string
+}
+
+func (d *dscResource) validate(ctx context.Context) (*ManagedResources, error) {
+	if goos != "windows" {
+		return nil, errors.New("DSCResource is only supported on Windows")
+	}
+	if !dscNameRe.MatchString(d.Name) {
+		return nil, fmt.Errorf("DSCResource: invalid Name %q", d.Name)
+	}
+	if !dscNameRe.MatchString(d.ModuleName) {
+		return nil, fmt.Errorf("DSCResource: invalid ModuleName %q", d.ModuleName)
+	}
+	for k, v := range d.Properties {
+		if _, ok := v.(map[string]any); ok {
+			return nil, fmt.Errorf("DSCResource: property %q is an object, only strings, numbers, booleans or arrays of them are supported", k)
+		}
+	}
+	props := d.Properties
+	if props == nil {
+		props = map[string]any{}
+	}
+	b, err := json.Marshal(props)
+	if err != nil {
+		return nil, fmt.Errorf("DSCResource: invalid Properties: %v", err)
+	}
+	d.properties = string(b)
+	return nil, nil
+}
+
+// invokeCommand returns the command running method, Test or Set, of the DSC
+// resource. Properties are passed as JSON and converted to the hashtable
+// Invoke-DscResource takes, Windows PowerShell has no -AsHashtable.
+func (d *dscResource) invokeCommand(method string) string {
+	module := psQuote(d.ModuleName)
+	if d.ModuleVersion != "" {
+		module = fmt.Sprintf("@{ModuleName = %s; ModuleVersion = %s}", psQuote(d.ModuleName), psQuote(d.ModuleVersion))
+	}
+	return fmt.Sprintf(`$p = @{}
+(ConvertFrom-Json -InputObject %s).psobject.Properties | ForEach-Object { $p[$_.Name] = $_.Value }
+$r = Invoke-DscResource -Name %s -ModuleName %s -Method %s -Property $p`, psQuote(d.properties), psQuote(d.Name), module, method)
+}
+
+func (d *dscResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	out, err := runPowerShell(ctx, d.invokeCommand("Test")+"\n$r.InDesiredState")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(out)) == "True", nil
+}
+
+func (d *dscResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Enforcing DSC resource %q from module %q.", d.Name, d.ModuleName)
+	out, err := runPowerShell(ctx, d.invokeCommand("Set")+"\n$r.RebootRequired")
+	if err != nil {
+		return false, err
+	}
+	if strings.TrimSpace(string(out)) == "True" {
+		clog.Warningf(ctx, "DSC resource %q requires a reboot to complete.", d.Name)
+	}
+	return true, nil
+}
+
+func (d *dscResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {
+}
+
+func (d *dscResource) cleanup(ctx context.Context) error {
+	return nil
+}
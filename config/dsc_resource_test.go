@@ -0,0 +1,117 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestDSCResourceValidate(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "windows"
+
+	var tests = []struct {
+		name    string
+		dsc     *DSCResource
+		wantErr bool
+	}{
+		{"File", &DSCResource{Name: "File", ModuleName: "PSDesiredStateConfiguration", Properties: map[string]any{"DestinationPath": `C:\app\settings.ini`, "Contents": "a=1"}}, false},
+		{"Version", &DSCResource{Name: "WindowsFeature", ModuleName: "PSDscResources", ModuleVersion: "2.12.0.0", Properties: map[string]any{"Name": "Web-Server"}}, false},
+		{"Array", &DSCResource{Name: "xWebsite", ModuleName: "xWebAdministration", Properties: map[string]any{"Name": "site", "Ports": []any{80.0, 443.0}}}, false},
+		{"NoProperties", &DSCResource{Name: "TimeZone", ModuleName: "ComputerManagementDsc"}, false},
+		{"NoName", &DSCResource{ModuleName: "PSDesiredStateConfiguration"}, true},
+		{"BadName", &DSCResource{Name: "File; Remove-Item", ModuleName: "PSDesiredStateConfiguration"}, true},
+		{"NoModule", &DSCResource{Name: "File"}, true},
+		{"ObjectProperty", &DSCResource{Name: "User", ModuleName: "PSDesiredStateConfiguration", Properties: map[string]any{"Password": map[string]any{"UserName": "a"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{Local: &LocalResource{DSC: tt.dsc}}
+			err := pr.Validate(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	goos = "linux"
+	pr := &OSPolicyResource{Local: &LocalResource{DSC: &DSCResource{Name: "File", ModuleName: "PSDesiredStateConfiguration"}}}
+	if err := pr.Validate(ctx); err == nil {
+		t.Error("Expected Validate error on Linux")
+	}
+}
+
+func TestDSCResourceInvokeCommand(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "windows"
+
+	d := &dscResource{DSCResource: &DSCResource{Name: "WindowsFeature", ModuleName: "PSDscResources", ModuleVersion: "2.12.0.0", Properties: map[string]any{"Name": "Web-Server", "Ensure": "Present", "Note": "it's"}}}
+	if _, err := d.validate(ctx); err != nil {
+		t.Fatalf("Unexpected validate error: %v", err)
+	}
+	want := `$p = @{}
+(ConvertFrom-Json -InputObject '{"Ensure":"Present","Name":"Web-Server","Note":"it''s"}').psobject.Properties | ForEach-Object { $p[$_.Name] = $_.Value }
+$r = Invoke-DscResource -Name 'WindowsFeature' -ModuleName @{ModuleName = 'PSDscResources'; ModuleVersion = '2.12.0.0'} -Method Test -Property $p`
+	if got := d.invokeCommand("Test"); got != want {
+		t.Errorf("invokeCommand() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDSCResourceCheckAndEnforce(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "windows"
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = mockCommandRunner
+
+	pr := &OSPolicyResource{Local: &LocalResource{DSC: &DSCResource{Name: "File", ModuleName: "PSDesiredStateConfiguration", Properties: map[string]any{"DestinationPath": `C:\app`}}}}
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+	d := pr.resource.(*dscResource)
+	psCmd := func(command string) *exec.Cmd {
+		return exec.Command(powershell, "-NoProfile", "-NonInteractive", "-Command", "$ErrorActionPreference = 'Stop'; "+command)
+	}
+
+	for _, tt := range []struct {
+		out  string
+		want bool
+	}{{"True\r\n", true}, {"False\r\n", false}} {
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(psCmd(d.invokeCommand("Test")+"\n$r.InDesiredState"))).Return([]byte(tt.out), nil, nil)
+		if err := pr.CheckState(ctx); err != nil {
+			t.Fatalf("Unexpected CheckState error: %v", err)
+		}
+		if got := pr.InDesiredState(); got != tt.want {
+			t.Errorf("InDesiredState() with output %q = %t, want %t", tt.out, got, tt.want)
+		}
+	}
+
+	mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(psCmd(d.invokeCommand("Set")+"\n$r.RebootRequired"))).Return([]byte("False\r\n"), nil, nil)
+	if err := pr.EnforceState(ctx); err != nil {
+		t.Fatalf("Unexpected EnforceState error: %v", err)
+	}
+}
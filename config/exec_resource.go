@@ -26,23 +26,88 @@ import (
 	"strings"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 
 	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
 )
 
-const maxExecOutputSize = 500 * 1024
+const (
+	maxExecOutputSize = 500 * 1024
 
-var runner = util.CommandRunner(&util.DefaultRunner{})
+	// winMaxPath is MAX_PATH, neither cmd.exe nor PowerShell run scripts at
+	// longer paths.
+	winMaxPath = 260
+)
+
+var (
+	runner = util.CommandRunner(&util.DefaultRunner{})
+
+	winCmd = `C:\Windows\System32\cmd.exe`
+	// Scripts on UNC paths are remote to the execution policy and would not
+	// run unsigned under RemoteSigned.
+	execPowershellArgs = []string{"-NonInteractive", "-NoProfile", "-ExecutionPolicy", "Bypass"}
+)
+
+// Exit code states of ExecExitCodes.
+const (
+	ExitCompliant    = "compliant"
+	ExitNonCompliant = "nonCompliant"
+	ExitError        = "error"
+)
+
+// ExecExitCodes maps the exit codes of the validate step of an exec
+// resource to a state, in place of 100 for compliant and 101 for
+// non-compliant. Only local policies can set it.
+type ExecExitCodes struct {
+	Compliant    []int `json:"compliant,omitempty"`
+	NonCompliant []int `json:"nonCompliant,omitempty"`
+	Error        []int `json:"error,omitempty"`
+	// Other is the state of codes that are not listed, ExitError if not
+	// set.
+	Other string `json:"other,omitempty"`
+}
+
+// states returns the state of each listed code and the state of the other
+// codes.
+func (c *ExecExitCodes) states() (map[int]string, string, error) {
+	states := map[int]string{}
+	for _, l := range []struct {
+		state string
+		codes []int
+	}{{ExitCompliant, c.Compliant}, {ExitNonCompliant, c.NonCompliant}, {ExitError, c.Error}} {
+		for _, code := range l.codes {
+			if s, ok := states[code]; ok {
+				return nil, "", fmt.Errorf("exit code %d is both %s and %s", code, s, l.state)
+			}
+			states[code] = l.state
+		}
+	}
+	for _, s := range []string{ExitCompliant, ExitNonCompliant, ExitError} {
+		if strings.EqualFold(c.Other, s) {
+			return states, s, nil
+		}
+	}
+	if c.Other == "" {
+		return states, ExitError, nil
+	}
+	return nil, "", fmt.Errorf("unrecognized other exit code state %q, must be %q, %q or %q", c.Other, ExitCompliant, ExitNonCompliant, ExitError)
+}
 
 type execResource struct {
 	*agentendpointpb.OSPolicy_Resource_ExecResource
 
+	exitCodes *ExecExitCodes
+	// exitStates and otherExitState are the validated exitCodes.
+	exitStates     map[int]string
+	otherExitState string
+
 	validatePath, enforcePath, tempDir string
 	enforceOutput                      []byte
 }
 
-// TODO: use a persistent cache for downloaded files so we dont need to redownload them each time
+// download writes the script of execR to a new temp dir, remote scripts are
+// revalidated against the script cache instead of downloaded each time.
 func (e *execResource) download(ctx context.Context, execR *agentendpointpb.OSPolicy_Resource_ExecResource_Exec) (string, error) {
 	tmpDir, err := ioutil.TempDir(e.tempDir, "")
 	if err != nil {
@@ -80,9 +145,15 @@ func (e *execResource) download(ctx context.Context, execR *agentendpointpb.OSPo
 
 	case *agentendpointpb.OSPolicy_Resource_ExecResource_Exec_File:
 		if execR.GetFile().GetLocalPath() != "" {
-			return execR.GetFile().GetLocalPath(), nil
+			return e.runnablePath(execR.GetFile().GetLocalPath())
 		}
 		switch {
+		case isGitURI(execR.GetFile().GetRemote().GetUri()):
+			src, err := parseGitURI(execR.GetFile().GetRemote().GetUri())
+			if err != nil {
+				return "", err
+			}
+			name = path.Base(src.path)
 		case execR.GetFile().GetGcs().GetObject() != "":
 			name = path.Base(execR.GetFile().GetGcs().GetObject())
 		case execR.GetFile().GetRemote().GetUri() != "":
@@ -94,6 +165,12 @@ func (e *execResource) download(ctx context.Context, execR *agentendpointpb.OSPo
 			perms = os.FileMode(0755)
 		}
 		name = filepath.Join(tmpDir, name)
+		if uri := execR.GetFile().GetRemote().GetUri(); uri != "" && !isShareURI(uri) && !isGitURI(uri) {
+			if err := downloadCachedScript(ctx, name, perms, execR.GetFile().GetRemote()); err != nil {
+				return "", err
+			}
+			break
+		}
 		if _, err := downloadFile(ctx, name, perms, execR.GetFile()); err != nil {
 			return "", err
 		}
@@ -101,10 +178,69 @@ func (e *execResource) download(ctx context.Context, execR *agentendpointpb.OSPo
 		return "", fmt.Errorf("unrecognized Source type for ExecResource: %q", execR.GetSource())
 	}
 
-	return name, nil
+	return e.runnablePath(name)
+}
+
+// runnablePath returns name, or on Windows a copy of name in the temp dir if
+// the path is too long for the interpreters.
+func (e *execResource) runnablePath(name string) (string, error) {
+	if goos != "windows" || len(name) < winMaxPath {
+		return name, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	tmpDir, err := ioutil.TempDir(e.tempDir, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp dir: %s", err)
+	}
+	short := filepath.Join(tmpDir, "script"+filepath.Ext(name))
+	if _, err := util.AtomicWriteFileStream(f, "", short, 0755); err != nil {
+		return "", err
+	}
+	return short, nil
+}
+
+// isBatchFile reports whether name is run by cmd.exe on Windows.
+func isBatchFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".cmd" || ext == ".bat"
+}
+
+// cmdQuote quotes s for cmd.exe, which does not follow the quoting rules
+// of the C runtime that exec uses.
+func cmdQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"&|<>()^,;=") {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// batchCommand runs the batch file name with cmd.exe. With /s cmd.exe only
+// strips the outer quotes of the command, which keeps quoted paths with
+// spaces intact.
+func batchCommand(ctx context.Context, name string, args []string) *exec.Cmd {
+	line := cmdQuote(name)
+	for _, a := range args {
+		line += " " + cmdQuote(a)
+	}
+	line = `"` + line + `"`
+	cmd := exec.CommandContext(ctx, winCmd, "/d", "/s", "/c", line)
+	setCmdLine(cmd, cmdQuote(winCmd)+" /d /s /c "+line)
+	return cmd
 }
 
 func (e *execResource) validate(ctx context.Context) (*ManagedResources, error) {
+	if e.exitCodes != nil {
+		var err error
+		if e.exitStates, e.otherExitState, err = e.exitCodes.states(); err != nil {
+			return nil, err
+		}
+	}
+
 	tmpDir, err := ioutil.TempDir("", "osconfig_exec_resource_")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temp dir: %s", err)
@@ -132,6 +268,10 @@ func (e *execResource) run(ctx context.Context, name string, execR *agentendpoin
 		return nil, nil, 0, fmt.Errorf("ExecResource Exec cannot be nil")
 	}
 
+	if goos == "windows" && isBatchFile(name) && execR.GetInterpreter() != agentendpointpb.OSPolicy_Resource_ExecResource_Exec_POWERSHELL {
+		return e.execute(ctx, batchCommand(ctx, name, execR.GetArgs()))
+	}
+
 	var cmd string
 	var args []string
 	switch execR.GetInterpreter() {
@@ -148,14 +288,18 @@ func (e *execResource) run(ctx context.Context, name string, execR *agentendpoin
 		if goos != "windows" {
 			return nil, nil, 0, fmt.Errorf("interpreter %q can only be used on Windows systems", execR.GetInterpreter())
 		}
-		args = append(args, "-File", name)
-		cmd = "C:\\Windows\\System32\\WindowsPowerShell\\v1.0\\PowerShell.exe"
+		args = append(append(args, execPowershellArgs...), "-File", name)
+		cmd = powershell
 	default:
 		return nil, nil, 0, fmt.Errorf("unsupported interpreter %q", execR.GetInterpreter())
 	}
 	args = append(args, execR.GetArgs()...)
 
-	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, cmd, args...))
+	return e.execute(ctx, exec.CommandContext(ctx, cmd, args...))
+}
+
+func (e *execResource) execute(ctx context.Context, cmd *exec.Cmd) ([]byte, []byte, int, error) {
+	stdout, stderr, err := runner.Run(ctx, cmd)
 	code := 0
 	if err != nil {
 		code = -1
@@ -173,6 +317,20 @@ func (e *execResource) checkState(ctx context.Context) (inDesiredState bool, err
 	// is explicitly called.
 	// A code of -1 indicates some other error, so we just return err.
 	stdout, stderr, code, err := e.run(ctx, e.validatePath, e.GetValidate())
+	if e.exitStates != nil && code != -1 {
+		state, ok := e.exitStates[code]
+		if !ok {
+			state = e.otherExitState
+		}
+		switch state {
+		case ExitCompliant:
+			return true, nil
+		case ExitNonCompliant:
+			return false, nil
+		default:
+			return false, fmt.Errorf("error return code from validate: %d, stdout: %s, stderr: %s", code, stdout, stderr)
+		}
+	}
 	switch code {
 	case -1:
 		return false, err
@@ -187,6 +345,7 @@ func (e *execResource) checkState(ctx context.Context) (inDesiredState bool, err
 
 func (e *execResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
 	clog.Infof(ctx, `Running "Enforce" for ExecResource.`)
+	defer func() { journal.Record(ctx, journal.ScriptRun, e.enforcePath, err) }()
 	// For enforce we expect an exit code of 100 for "success" and anything positive code is a failure".
 	// 100 was chosen over 0 because we want an explicit indicator of "sucess" vs errors.
 	// Also Powershell will always exit 0 unless "exit" is explicitly called.
@@ -16,14 +16,21 @@ package config
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+
 	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
 )
 
@@ -242,3 +249,204 @@ func TestExecOutput(t *testing.T) {
 		})
 	}
 }
+
+func TestCmdQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{``, `""`},
+		{`C:\script.cmd`, `C:\script.cmd`},
+		{`C:\Program Files\script.cmd`, `"C:\Program Files\script.cmd"`},
+		{`\\server\share\script.cmd`, `\\server\share\script.cmd`},
+		{`a&b`, `"a&b"`},
+		{`say "hi"`, `"say ""hi"""`},
+	}
+	for _, tt := range tests {
+		if got := cmdQuote(tt.in); got != tt.want {
+			t.Errorf("cmdQuote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExecResourceRunWindows(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "windows"
+
+	tests := []struct {
+		name        string
+		path        string
+		interpreter agentendpointpb.OSPolicy_Resource_ExecResource_Exec_Interpreter
+		args        []string
+		want        *exec.Cmd
+	}{
+		{
+			"batch file with spaces",
+			`C:\Program Files\My Scripts\script.cmd`,
+			agentendpointpb.OSPolicy_Resource_ExecResource_Exec_SHELL,
+			[]string{"arg 1", "arg2"},
+			exec.Command(winCmd, "/d", "/s", "/c", `""C:\Program Files\My Scripts\script.cmd" "arg 1" arg2"`),
+		},
+		{
+			"batch file on UNC path",
+			`\\server\share\script.BAT`,
+			agentendpointpb.OSPolicy_Resource_ExecResource_Exec_NONE,
+			nil,
+			exec.Command(winCmd, "/d", "/s", "/c", `"\\server\share\script.BAT"`),
+		},
+		{
+			"executable",
+			`C:\Program Files\tool.exe`,
+			agentendpointpb.OSPolicy_Resource_ExecResource_Exec_NONE,
+			[]string{"arg 1"},
+			exec.Command(`C:\Program Files\tool.exe`, "arg 1"),
+		},
+		{
+			"PowerShell on UNC path",
+			`\\server\share\My Scripts\script.ps1`,
+			agentendpointpb.OSPolicy_Resource_ExecResource_Exec_POWERSHELL,
+			[]string{"-Name", "a b"},
+			exec.Command(powershell, "-NonInteractive", "-NoProfile", "-ExecutionPolicy", "Bypass", "-File", `\\server\share\My Scripts\script.ps1`, "-Name", "a b"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+			defer func(r util.CommandRunner) { runner = r }(runner)
+			runner = mockCommandRunner
+
+			mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(tt.want)).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+			e := &execResource{}
+			if _, _, code, err := e.run(ctx, tt.path, &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{Interpreter: tt.interpreter, Args: tt.args}); err != nil || code != 0 {
+				t.Errorf("run() = %d, %v, want 0, nil", code, err)
+			}
+		})
+	}
+}
+
+func TestExecResourceRunnablePath(t *testing.T) {
+	defer func(g string) { goos = g }(goos)
+	tmpDir := t.TempDir()
+	e := &execResource{tempDir: tmpDir}
+
+	short := filepath.Join(tmpDir, "script.ps1")
+	long := filepath.Join(tmpDir, strings.Repeat("a", 200), strings.Repeat("b", 60)+".ps1")
+	if err := os.MkdirAll(filepath.Dir(long), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{short, long} {
+		if err := os.WriteFile(p, []byte("exit 100"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	goos = "linux"
+	if got, err := e.runnablePath(long); err != nil || got != long {
+		t.Errorf("runnablePath(long) on linux = %q, %v, want %q", got, err, long)
+	}
+
+	goos = "windows"
+	if got, err := e.runnablePath(short); err != nil || got != short {
+		t.Errorf("runnablePath(short) = %q, %v, want %q", got, err, short)
+	}
+	got, err := e.runnablePath(long)
+	if err != nil {
+		t.Fatalf("runnablePath(long): %v", err)
+	}
+	if len(got) >= winMaxPath || filepath.Ext(got) != ".ps1" || !strings.HasPrefix(got, tmpDir) {
+		t.Errorf("runnablePath(long) = %q, want a short .ps1 path in %q", got, tmpDir)
+	}
+	data, err := os.ReadFile(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "exit 100" {
+		t.Errorf("unexpected contents of %q: %q", got, data)
+	}
+}
+
+func TestExecResourceExitCodes(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+
+	tests := []struct {
+		name      string
+		exitCodes *ExecExitCodes
+		code      int
+		want      bool
+		wantErr   bool
+	}{
+		{"default compliant", nil, 100, true, false},
+		{"default non-compliant", nil, 101, false, false},
+		{"default error", nil, 0, false, true},
+		{"compliant", &ExecExitCodes{Compliant: []int{0}, NonCompliant: []int{1}}, 0, true, false},
+		{"non-compliant", &ExecExitCodes{Compliant: []int{0}, NonCompliant: []int{1}}, 1, false, false},
+		{"listed error", &ExecExitCodes{Compliant: []int{0}, Error: []int{2}, Other: "nonCompliant"}, 2, false, true},
+		{"other error", &ExecExitCodes{Compliant: []int{0}, NonCompliant: []int{1}}, 100, false, true},
+		{"other non-compliant", &ExecExitCodes{Compliant: []int{0}, Other: "NONCOMPLIANT"}, 3, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{
+				OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+					ResourceType: &agentendpointpb.OSPolicy_Resource_Exec{
+						Exec: &agentendpointpb.OSPolicy_Resource_ExecResource{
+							Validate: &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{
+								Source:      &agentendpointpb.OSPolicy_Resource_ExecResource_Exec_Script{Script: fmt.Sprintf("exit %d", tt.code)},
+								Interpreter: agentendpointpb.OSPolicy_Resource_ExecResource_Exec_SHELL,
+							},
+						},
+					},
+				},
+				ExecExitCodes: tt.exitCodes,
+			}
+			defer pr.Cleanup(ctx)
+			if err := pr.Validate(ctx); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			err := pr.CheckState(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckState: got err %v, want err %t", err, tt.wantErr)
+			}
+			if pr.InDesiredState() != tt.want {
+				t.Errorf("InDesiredState() = %t, want %t", pr.InDesiredState(), tt.want)
+			}
+		})
+	}
+}
+
+func TestExecExitCodesValidate(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name      string
+		exitCodes *ExecExitCodes
+		pr        *agentendpointpb.OSPolicy_Resource
+	}{
+		{
+			"duplicate code",
+			&ExecExitCodes{Compliant: []int{0}, NonCompliant: []int{0}},
+			&agentendpointpb.OSPolicy_Resource{ResourceType: &agentendpointpb.OSPolicy_Resource_Exec{Exec: &agentendpointpb.OSPolicy_Resource_ExecResource{}}},
+		},
+		{
+			"unknown other",
+			&ExecExitCodes{Other: "fine"},
+			&agentendpointpb.OSPolicy_Resource{ResourceType: &agentendpointpb.OSPolicy_Resource_Exec{Exec: &agentendpointpb.OSPolicy_Resource_ExecResource{}}},
+		},
+		{
+			"not an exec resource",
+			&ExecExitCodes{Compliant: []int{0}},
+			&agentendpointpb.OSPolicy_Resource{ResourceType: &agentendpointpb.OSPolicy_Resource_File_{File: &agentendpointpb.OSPolicy_Resource_FileResource{}}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{OSPolicy_Resource: tt.pr, ExecExitCodes: tt.exitCodes}
+			if err := pr.Validate(ctx); err == nil {
+				t.Error("Validate: did not get expected error")
+			}
+		})
+	}
+}
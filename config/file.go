@@ -17,13 +17,17 @@ package config
 import (
 	"context"
 	"crypto/sha256"
-	"encoding/hex"
+	"crypto/sha512"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"os"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
 	"github.com/GoogleCloudPlatform/osconfig/external"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 
@@ -31,9 +35,74 @@ import (
 )
 
 func checksum(r io.Reader) string {
-	hash := sha256.New()
-	io.Copy(hash, r)
-	return hex.EncodeToString(hash.Sum(nil))
+	sum, _ := util.HashReader(sha256.New(), r)
+	return sum
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumSHA512:
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown checksum algorithm %q", algorithm)
+	}
+}
+
+// cachedChecksum is the checksum of a file along with the size and
+// modification time the file had when it was hashed.
+type cachedChecksum struct {
+	size      int64
+	modTime   time.Time
+	algorithm string
+	sum       string
+}
+
+// checksumCache holds the checksums computed by fileChecksum with the fast
+// path, keyed by file path. It lives as long as the agent so the checksum of
+// an unchanged file is only computed once.
+var checksumCache = struct {
+	sync.Mutex
+	m map[string]cachedChecksum
+}{m: map[string]cachedChecksum{}}
+
+// fileChecksum returns the hex checksum of the file at path. With fastPath a
+// checksum computed before is reused as long as the size and modification
+// time of the file are unchanged, cached reports whether it was.
+func fileChecksum(path, algorithm string, fastPath bool) (sum string, cached bool, err error) {
+	h, err := newHash(algorithm)
+	if err != nil {
+		return "", false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	var fi os.FileInfo
+	if fastPath {
+		if fi, err = f.Stat(); err != nil {
+			return "", false, err
+		}
+		checksumCache.Lock()
+		c, ok := checksumCache.m[path]
+		checksumCache.Unlock()
+		if ok && c.algorithm == algorithm && c.size == fi.Size() && c.modTime.Equal(fi.ModTime()) {
+			return c.sum, true, nil
+		}
+	}
+
+	if sum, err = util.HashReader(h, f); err != nil {
+		return "", false, err
+	}
+	if fastPath {
+		checksumCache.Lock()
+		checksumCache.m[path] = cachedChecksum{size: fi.Size(), modTime: fi.ModTime(), algorithm: algorithm, sum: sum}
+		checksumCache.Unlock()
+	}
+	return sum, false, nil
 }
 
 func downloadFile(ctx context.Context, path string, perms os.FileMode, file *agentendpointpb.OSPolicy_Resource_File) (string, error) {
@@ -45,19 +114,25 @@ func downloadFile(ctx context.Context, path string, perms os.FileMode, file *age
 	case *agentendpointpb.OSPolicy_Resource_File_Gcs_:
 		client, err := storage.NewClient(ctx)
 		if err != nil {
-			return "", fmt.Errorf("error creating gcs client: %v", err)
+			return "", errcode.Wrap(errcode.Auth, fmt.Errorf("error creating gcs client: %w", err))
 		}
 		defer client.Close()
 
 		reader, err = external.FetchGCSObject(ctx, client, file.GetGcs().GetBucket(), file.GetGcs().GetObject(), file.GetGcs().GetGeneration())
 		if err != nil {
-			return "", err
+			return "", errcode.Wrap(errcode.Network, err)
 		}
 
 	case *agentendpointpb.OSPolicy_Resource_File_Remote_:
+		if isShareURI(file.GetRemote().GetUri()) {
+			return downloadShareFile(ctx, path, perms, file.GetRemote())
+		}
+		if isGitURI(file.GetRemote().GetUri()) {
+			return downloadGitFile(ctx, path, perms, file.GetRemote())
+		}
 		reader, err = external.FetchRemoteObjectHTTP(ctx, &http.Client{}, file.GetRemote().GetUri())
 		if err != nil {
-			return "", err
+			return "", errcode.Wrap(errcode.Network, err)
 		}
 		wantChecksum = file.GetRemote().GetSha256Checksum()
 
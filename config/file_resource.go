@@ -17,7 +17,6 @@ package config
 import (
 	"context"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -25,6 +24,7 @@ import (
 	"strings"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 
 	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
@@ -32,20 +32,86 @@ import (
 
 const defaultFilePerms = 0644
 
+// Checksum algorithms of FileChecksum.
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA512 = "sha512"
+)
+
+// FileChecksum configures how a file resource with desired state
+// CONTENTS_MATCH compares the file with its source.
+type FileChecksum struct {
+	// Algorithm is ChecksumSHA256, the default, or ChecksumSHA512.
+	Algorithm string `json:"algorithm,omitempty"`
+	// FastPath skips hashing the file, and a local source, while its size
+	// and modification time are the same as when it was last hashed. This
+	// saves hashing very large files each run, at the cost of missing
+	// changes that keep both.
+	FastPath bool `json:"fastPath,omitempty"`
+}
+
+func (c *FileChecksum) algorithm() string {
+	if c == nil || c.Algorithm == "" {
+		return ChecksumSHA256
+	}
+	return strings.ToLower(c.Algorithm)
+}
+
+func (c *FileChecksum) fastPath() bool {
+	return c != nil && c.FastPath
+}
+
 type fileResource struct {
 	*agentendpointpb.OSPolicy_Resource_FileResource
 
+	checksum    *FileChecksum
 	managedFile ManagedFile
+	// verified is how the contents of the file were last compared with its
+	// source, nil if they were not.
+	verified *checksumVerification
+	backup   *fileBackup
+	// immutable is why the file can't be written, set if it is on a
+	// read-only file system with no stateful overlay.
+	immutable error
+}
+
+// checksumVerification is how the contents of a file were compared with its
+// source, fastPath is set if the checksum was not computed as the size and
+// modification time of the file were unchanged.
+type checksumVerification struct {
+	algorithm string
+	fastPath  bool
+}
+
+// fileBackup is the file replaced or removed by enforceState, kept so it can
+// be restored by rollback.
+type fileBackup struct {
+	dir string
+	// path is the copy of the file, empty if there was no file.
+	path string
+	perm os.FileMode
 }
 
 // ManagedFile is the file that this FileResouce manages.
 type ManagedFile struct {
-	Path       string
-	tempDir    string
-	source     string
-	checksum   string
-	State      agentendpointpb.OSPolicy_Resource_FileResource_DesiredState
-	Permisions os.FileMode
+	Path     string
+	tempDir  string
+	source   string
+	checksum string
+	// ChecksumAlgorithm is the algorithm of checksum, the file is compared
+	// with its source using it.
+	ChecksumAlgorithm string
+	State             agentendpointpb.OSPolicy_Resource_FileResource_DesiredState
+	Permisions        os.FileMode
+}
+
+// Checksum is the checksum of the desired contents of the file with
+// ChecksumAlgorithm, empty when its contents are not managed.
+func (f ManagedFile) Checksum() string {
+	if f.State != agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH {
+		return ""
+	}
+	return f.checksum
 }
 
 func parsePermissions(s string) (os.FileMode, error) {
@@ -93,6 +159,13 @@ func (f *fileResource) download(ctx context.Context) error {
 		return fmt.Errorf("unrecognized Source type for FileResource: %q", f.GetSource())
 	}
 
+	// The checksum from the download is always SHA-256.
+	if f.managedFile.ChecksumAlgorithm != ChecksumSHA256 {
+		if f.managedFile.checksum, _, err = fileChecksum(tmpFile, f.managedFile.ChecksumAlgorithm, false); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -105,6 +178,23 @@ func (f *fileResource) validate(ctx context.Context) (*ManagedResources, error)
 	}
 
 	f.managedFile.Path = f.GetPath()
+	f.managedFile.ChecksumAlgorithm = f.checksum.algorithm()
+	if _, err := newHash(f.managedFile.ChecksumAlgorithm); err != nil {
+		return nil, err
+	}
+	if f.checksum != nil && f.GetState() != agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH {
+		return nil, fmt.Errorf("file checksum can only be set for desired state CONTENTS_MATCH, not %q", f.GetState())
+	}
+
+	// On images with a read-only root file system the file is managed where
+	// it resolves to in a stateful overlay. Otherwise its state can still be
+	// checked, enforcing it fails with ErrReadOnlyFS.
+	if path, err := statefulPath(f.GetPath()); err != nil {
+		f.immutable = err
+	} else if path != f.GetPath() {
+		clog.Infof(ctx, "%q is on a read-only file system, managing %q in its stateful overlay instead.", f.GetPath(), path)
+		f.managedFile.Path = path
+	}
 
 	// If desired state is absent, we can return now.
 	if f.GetState() == agentendpointpb.OSPolicy_Resource_FileResource_ABSENT {
@@ -119,12 +209,10 @@ func (f *fileResource) validate(ctx context.Context) (*ManagedResources, error)
 
 	if f.GetFile().GetLocalPath() != "" {
 		f.managedFile.source = f.GetFile().GetLocalPath()
-		file, err := os.Open(f.GetFile().GetLocalPath())
+		f.managedFile.checksum, _, err = fileChecksum(f.managedFile.source, f.managedFile.ChecksumAlgorithm, f.checksum.fastPath())
 		if err != nil {
 			return nil, err
 		}
-		f.managedFile.checksum = checksum(file)
-		file.Close()
 	}
 
 	switch f.managedFile.State {
@@ -154,12 +242,36 @@ func (f *fileResource) checkState(ctx context.Context) (inDesiredState bool, err
 	case agentendpointpb.OSPolicy_Resource_FileResource_PRESENT:
 		return util.Exists(f.managedFile.Path), nil
 	case agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH:
-		return contentsMatch(ctx, f.managedFile.Path, f.managedFile.checksum)
+		return f.contentsMatch(ctx)
 	default:
 		return false, fmt.Errorf("unrecognized DesiredState for FileResource: %q", f.managedFile.State)
 	}
 }
 
+// contentsMatch compares the checksum of the managed file with that of its
+// source.
+func (f *fileResource) contentsMatch(ctx context.Context) (bool, error) {
+	sum, cached, err := fileChecksum(f.managedFile.Path, f.managedFile.ChecksumAlgorithm, f.checksum.fastPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			clog.Debugf(ctx, "File not found: %s", f.managedFile.Path)
+			return false, nil
+		}
+		return false, err
+	}
+	f.verified = &checksumVerification{algorithm: f.managedFile.ChecksumAlgorithm, fastPath: cached}
+	how := "computed"
+	if cached {
+		how = "unchanged size and modification time"
+	}
+	clog.Debugf(ctx, "Verified %q with %s checksum (%s).", f.managedFile.Path, f.managedFile.ChecksumAlgorithm, how)
+	if sum != f.managedFile.checksum {
+		clog.Debugf(ctx, "Checksums don't match, got: %s, actual: %s", f.managedFile.checksum, sum)
+		return false, nil
+	}
+	return true, nil
+}
+
 func copyFile(dst, src string, perms os.FileMode) (retErr error) {
 	reader, err := os.Open(src)
 	if err != nil {
@@ -178,7 +290,7 @@ func copyFile(dst, src string, perms os.FileMode) (retErr error) {
 		}
 	}()
 
-	if _, err := io.Copy(writer, reader); err != nil {
+	if _, err := util.Copy(writer, reader); err != nil {
 		return err
 	}
 
@@ -187,9 +299,20 @@ func copyFile(dst, src string, perms os.FileMode) (retErr error) {
 
 func (f *fileResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
 	clog.Infof(ctx, "Enforcing state %q for file %q.", f.managedFile.State, f.managedFile.Path)
+	if f.immutable != nil {
+		return false, f.immutable
+	}
+	if fileRollback() {
+		// Rollback is best effort, enforce the file even if it can not be backed up.
+		if err := f.backUp(); err != nil {
+			clog.Warningf(ctx, "Error backing up %q, it can not be rolled back: %v", f.managedFile.Path, err)
+		}
+	}
 	switch f.managedFile.State {
 	case agentendpointpb.OSPolicy_Resource_FileResource_ABSENT:
-		if err := os.Remove(f.managedFile.Path); err != nil {
+		err := removeFile(ctx, f.managedFile.Path)
+		journal.Record(ctx, journal.FileRemove, f.managedFile.Path, err)
+		if err != nil {
 			return false, fmt.Errorf("error removing %q: %v", f.managedFile.Path, err)
 		}
 	case agentendpointpb.OSPolicy_Resource_FileResource_PRESENT, agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH:
@@ -199,7 +322,9 @@ func (f *fileResource) enforceState(ctx context.Context) (inDesiredState bool, e
 				return false, err
 			}
 		}
-		if err := copyFile(f.managedFile.Path, f.managedFile.source, f.managedFile.Permisions); err != nil {
+		err := copyFileTo(ctx, f.managedFile.Path, f.managedFile.source, f.managedFile.Permisions)
+		journal.Record(ctx, journal.FileWrite, f.managedFile.Path, err)
+		if err != nil {
 			return false, fmt.Errorf("error copying %q to %q: %v", f.managedFile.source, f.managedFile.Path, err)
 		}
 	default:
@@ -209,9 +334,84 @@ func (f *fileResource) enforceState(ctx context.Context) (inDesiredState bool, e
 	return true, nil
 }
 
-func (f *fileResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {}
+// backUp copies the file at the managed path, if any, to fileBackupDir so
+// rollback can restore it.
+func (f *fileResource) backUp() error {
+	if err := os.MkdirAll(fileBackupDir(), 0700); err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir(fileBackupDir(), "backup_")
+	if err != nil {
+		return err
+	}
+	b := &fileBackup{dir: dir}
+	fi, err := os.Stat(f.managedFile.Path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		os.RemoveAll(dir)
+		return err
+	default:
+		b.path = filepath.Join(dir, "backup")
+		b.perm = fi.Mode().Perm()
+		if err := copyFile(b.path, f.managedFile.Path, 0600); err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+	}
+	f.backup = b
+	return nil
+}
+
+// rollback restores the file replaced or removed by enforceState, or removes
+// the file if there was none. It reports whether anything was restored.
+func (f *fileResource) rollback(ctx context.Context) (bool, error) {
+	if f.backup == nil {
+		return false, nil
+	}
+	b := f.backup
+	f.backup = nil
+	defer os.RemoveAll(b.dir)
+
+	if b.path == "" {
+		clog.Infof(ctx, "Rolling back file %q, removing it.", f.managedFile.Path)
+		err := removeFile(ctx, f.managedFile.Path)
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		journal.Record(ctx, journal.FileRemove, f.managedFile.Path, err)
+		return err == nil, err
+	}
+
+	clog.Infof(ctx, "Rolling back file %q to its previous contents.", f.managedFile.Path)
+	err := copyFileTo(ctx, f.managedFile.Path, b.path, b.perm)
+	// The privileged helper writes the file with its permissions.
+	if err == nil && helperFor(f.managedFile.Path) == nil {
+		err = os.Chmod(f.managedFile.Path, b.perm)
+	}
+	journal.Record(ctx, journal.FileWrite, f.managedFile.Path, err)
+	return err == nil, err
+}
+
+// populateOutput records how the contents of the file were verified, for
+// resources with checksum settings, which only local policies have. The API
+// only defines an output for exec resources, so it is its enforcement output,
+// like "checksum=sha512 fast_path=true".
+func (f *fileResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {
+	if f.checksum == nil || f.verified == nil {
+		return
+	}
+	rCompliance.Output = &agentendpointpb.OSPolicyResourceCompliance_ExecResourceOutput_{
+		ExecResourceOutput: &agentendpointpb.OSPolicyResourceCompliance_ExecResourceOutput{
+			EnforcementOutput: []byte(fmt.Sprintf("checksum=%s fast_path=%t", f.verified.algorithm, f.verified.fastPath)),
+		},
+	}
+}
 
 func (f *fileResource) cleanup(ctx context.Context) error {
+	if f.backup != nil {
+		os.RemoveAll(f.backup.dir)
+	}
 	if f.managedFile.tempDir != "" {
 		return os.RemoveAll(f.managedFile.tempDir)
 	}
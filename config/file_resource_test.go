@@ -15,10 +15,13 @@
 package config
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/osconfig/util"
@@ -51,8 +54,9 @@ func TestFileResourceValidate(t *testing.T) {
 				State: agentendpointpb.OSPolicy_Resource_FileResource_ABSENT,
 			},
 			ManagedFile{
-				Path:  tmpFile,
-				State: agentendpointpb.OSPolicy_Resource_FileResource_ABSENT,
+				ChecksumAlgorithm: ChecksumSHA256,
+				Path:              tmpFile,
+				State:             agentendpointpb.OSPolicy_Resource_FileResource_ABSENT,
 			},
 		},
 		{
@@ -62,9 +66,10 @@ func TestFileResourceValidate(t *testing.T) {
 				State: agentendpointpb.OSPolicy_Resource_FileResource_PRESENT,
 			},
 			ManagedFile{
-				Path:       tmpFile,
-				State:      agentendpointpb.OSPolicy_Resource_FileResource_PRESENT,
-				Permisions: defaultFilePerms,
+				ChecksumAlgorithm: ChecksumSHA256,
+				Path:              tmpFile,
+				State:             agentendpointpb.OSPolicy_Resource_FileResource_PRESENT,
+				Permisions:        defaultFilePerms,
 			},
 		},
 		{
@@ -81,11 +86,12 @@ func TestFileResourceValidate(t *testing.T) {
 				State: agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH,
 			},
 			ManagedFile{
-				Path:       tmpFile,
-				source:     tmpFile,
-				checksum:   "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
-				State:      agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH,
-				Permisions: defaultFilePerms,
+				ChecksumAlgorithm: ChecksumSHA256,
+				Path:              tmpFile,
+				source:            tmpFile,
+				checksum:          "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				State:             agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH,
+				Permisions:        defaultFilePerms,
 			},
 		},
 		{
@@ -96,9 +102,10 @@ func TestFileResourceValidate(t *testing.T) {
 				Permissions: "0777",
 			},
 			ManagedFile{
-				Path:       tmpFile,
-				State:      agentendpointpb.OSPolicy_Resource_FileResource_PRESENT,
-				Permisions: 0777,
+				ChecksumAlgorithm: ChecksumSHA256,
+				Path:              tmpFile,
+				State:             agentendpointpb.OSPolicy_Resource_FileResource_PRESENT,
+				Permisions:        0777,
 			},
 		},
 		{
@@ -115,11 +122,12 @@ func TestFileResourceValidate(t *testing.T) {
 				State: agentendpointpb.OSPolicy_Resource_FileResource_PRESENT,
 			},
 			ManagedFile{
-				Path:       tmpFile,
-				State:      agentendpointpb.OSPolicy_Resource_FileResource_PRESENT,
-				Permisions: defaultFilePerms,
-				checksum:   "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
-				source:     tmpFile,
+				ChecksumAlgorithm: ChecksumSHA256,
+				Path:              tmpFile,
+				State:             agentendpointpb.OSPolicy_Resource_FileResource_PRESENT,
+				Permisions:        defaultFilePerms,
+				checksum:          "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				source:            tmpFile,
 			},
 		},
 	}
@@ -298,6 +306,117 @@ func TestFileResourceCheckState(t *testing.T) {
 	}
 }
 
+func TestFileResourceChecksum(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	src := filepath.Join(tmpDir, "src")
+	dst := filepath.Join(tmpDir, "dst")
+	for _, f := range []string{src, dst} {
+		if err := ioutil.WriteFile(f, []byte("foo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newResource := func(state agentendpointpb.OSPolicy_Resource_FileResource_DesiredState, fc *FileChecksum) *OSPolicyResource {
+		return &OSPolicyResource{
+			OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+				ResourceType: &agentendpointpb.OSPolicy_Resource_File_{
+					File: &agentendpointpb.OSPolicy_Resource_FileResource{
+						Path:   dst,
+						Source: &agentendpointpb.OSPolicy_Resource_FileResource_File{File: &agentendpointpb.OSPolicy_Resource_File{Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: src}}},
+						State:  state,
+					},
+				},
+			},
+			FileChecksum: fc,
+		}
+	}
+	var output string
+	checkState := func(fc *FileChecksum) bool {
+		t.Helper()
+		pr := newResource(agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH, fc)
+		if err := pr.Validate(ctx); err != nil {
+			t.Fatalf("Validate: %v", err)
+		}
+		if err := pr.CheckState(ctx); err != nil {
+			t.Fatalf("CheckState: %v", err)
+		}
+		rc := &agentendpointpb.OSPolicyResourceCompliance{}
+		if err := pr.PopulateOutput(rc); err != nil {
+			t.Fatalf("PopulateOutput: %v", err)
+		}
+		output = string(rc.GetExecResourceOutput().GetEnforcementOutput())
+		return pr.InDesiredState()
+	}
+
+	pr := newResource(agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH, &FileChecksum{Algorithm: "SHA512"})
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	mf := pr.ManagedResources().Files[0]
+	if mf.ChecksumAlgorithm != ChecksumSHA512 || len(mf.checksum) != 128 {
+		t.Errorf("got %s checksum %q, want a sha512 checksum", mf.ChecksumAlgorithm, mf.checksum)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		state agentendpointpb.OSPolicy_Resource_FileResource_DesiredState
+		fc    *FileChecksum
+	}{
+		{"UnknownAlgorithm", agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH, &FileChecksum{Algorithm: "md5"}},
+		{"NotContentsMatch", agentendpointpb.OSPolicy_Resource_FileResource_PRESENT, &FileChecksum{FastPath: true}},
+	} {
+		if err := newResource(tt.state, tt.fc).Validate(ctx); err == nil {
+			t.Errorf("%s: Validate did not return expected error", tt.name)
+		}
+	}
+
+	if !checkState(&FileChecksum{Algorithm: ChecksumSHA512}) {
+		t.Error("sha512: want file in desired state")
+	}
+	if want := "checksum=sha512 fast_path=false"; output != want {
+		t.Errorf("sha512: got output %q, want %q", output, want)
+	}
+
+	// A change that keeps the size and modification time is only seen
+	// without the fast path.
+	fast := &FileChecksum{FastPath: true}
+	if !checkState(fast) {
+		t.Error("fast path: want file in desired state")
+	}
+	fi, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dst, []byte("bar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(dst, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if !checkState(fast) {
+		t.Error("fast path with unchanged size and modification time: want file in desired state")
+	}
+	if want := "checksum=sha256 fast_path=true"; output != want {
+		t.Errorf("fast path: got output %q, want %q", output, want)
+	}
+	if checkState(nil) {
+		t.Error("no fast path: want file not in desired state")
+	}
+	if output != "" {
+		t.Errorf("no checksum settings: got output %q, want none", output)
+	}
+	if err := ioutil.WriteFile(dst, []byte("barbaz"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if checkState(fast) {
+		t.Error("fast path with changed size: want file not in desired state")
+	}
+}
+
 func TestFileResourceEnforceStateAbsent(t *testing.T) {
 	ctx := context.Background()
 	tmpDir, err := ioutil.TempDir("", "")
@@ -377,3 +496,244 @@ func TestFileResourceEnforceStatePresent(t *testing.T) {
 		t.Fatal("Repo file contents do not match after enforcement")
 	}
 }
+
+func TestFileResourceRollback(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func() bool) { fileRollback = f }(fileRollback)
+	defer func(f func() string) { fileBackupDir = f }(fileBackupDir)
+	fileRollback = func() bool { return true }
+	backupDir := t.TempDir()
+	fileBackupDir = func() string { return backupDir }
+
+	var tests = []struct {
+		name         string
+		state        agentendpointpb.OSPolicy_Resource_FileResource_DesiredState
+		existing     bool
+		wantRestored bool
+	}{
+		{"PresentReplacesFile", agentendpointpb.OSPolicy_Resource_FileResource_PRESENT, true, true},
+		{"PresentCreatesFile", agentendpointpb.OSPolicy_Resource_FileResource_PRESENT, false, true},
+		{"AbsentRemovesFile", agentendpointpb.OSPolicy_Resource_FileResource_ABSENT, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			path := filepath.Join(tmpDir, "foo")
+			if tt.existing {
+				if err := ioutil.WriteFile(path, []byte("old"), 0640); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			frpb := &agentendpointpb.OSPolicy_Resource_FileResource{Path: path, State: tt.state}
+			if tt.state == agentendpointpb.OSPolicy_Resource_FileResource_PRESENT {
+				frpb.Source = &agentendpointpb.OSPolicy_Resource_FileResource_Content{Content: "new"}
+			}
+			pr := &OSPolicyResource{
+				OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+					ResourceType: &agentendpointpb.OSPolicy_Resource_File_{File: frpb},
+				},
+			}
+			if err := pr.Validate(ctx); err != nil {
+				t.Fatalf("Unexpected Validate error: %v", err)
+			}
+			defer pr.Cleanup(ctx)
+			if err := pr.EnforceState(ctx); err != nil {
+				t.Fatalf("Unexpected EnforceState error: %v", err)
+			}
+
+			restored, err := pr.Rollback(ctx)
+			if err != nil {
+				t.Fatalf("Unexpected Rollback error: %v", err)
+			}
+			if restored != tt.wantRestored {
+				t.Errorf("Rollback restored: got %t, want %t", restored, tt.wantRestored)
+			}
+			if restored && pr.InDesiredState() {
+				t.Error("resource still in desired state after rollback")
+			}
+
+			if !tt.existing {
+				if util.Exists(path) {
+					t.Error("file exists after rollback, want it removed")
+				}
+				return
+			}
+			got, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "old" {
+				t.Errorf("file contents after rollback: got %q, want %q", got, "old")
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0640 {
+				t.Errorf("file mode after rollback: got %v, want %v", fi.Mode().Perm(), os.FileMode(0640))
+			}
+
+			// A second rollback has nothing left to restore.
+			if restored, err := pr.Rollback(ctx); restored || err != nil {
+				t.Errorf("second Rollback: got (%t, %v), want (false, nil)", restored, err)
+			}
+		})
+	}
+}
+
+func TestFileResourceNoBackup(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func() bool) { fileRollback = f }(fileRollback)
+	defer func(f func() string) { fileBackupDir = f }(fileBackupDir)
+	tmpDir := t.TempDir()
+	// A file where the backup directory should be makes backing up fail.
+	backupDir := filepath.Join(tmpDir, "backups")
+	if err := ioutil.WriteFile(backupDir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fileBackupDir = func() string { return backupDir }
+
+	for _, enabled := range []bool{false, true} {
+		fileRollback = func() bool { return enabled }
+		path := filepath.Join(tmpDir, "foo")
+		if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		pr := &OSPolicyResource{
+			OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+				ResourceType: &agentendpointpb.OSPolicy_Resource_File_{File: &agentendpointpb.OSPolicy_Resource_FileResource{
+					Path:   path,
+					State:  agentendpointpb.OSPolicy_Resource_FileResource_PRESENT,
+					Source: &agentendpointpb.OSPolicy_Resource_FileResource_Content{Content: "new"},
+				}},
+			},
+		}
+		if err := pr.Validate(ctx); err != nil {
+			t.Fatalf("Unexpected Validate error: %v", err)
+		}
+		if err := pr.EnforceState(ctx); err != nil {
+			t.Errorf("rollback %t: unexpected EnforceState error: %v", enabled, err)
+		}
+		if restored, err := pr.Rollback(ctx); restored || err != nil {
+			t.Errorf("rollback %t: Rollback: got (%t, %v), want (false, nil)", enabled, restored, err)
+		}
+		if got, _ := ioutil.ReadFile(path); string(got) != "new" {
+			t.Errorf("rollback %t: file contents: got %q, want %q", enabled, got, "new")
+		}
+		pr.Cleanup(ctx)
+	}
+}
+
+func BenchmarkFileResourceCheckState(b *testing.B) {
+	ctx := context.Background()
+	tmpDir := b.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	dst := filepath.Join(tmpDir, "dst")
+	contents := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	for _, f := range []string{src, dst} {
+		if err := os.WriteFile(f, contents, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	pr := &OSPolicyResource{
+		OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+			ResourceType: &agentendpointpb.OSPolicy_Resource_File_{
+				File: &agentendpointpb.OSPolicy_Resource_FileResource{
+					Path: dst,
+					Source: &agentendpointpb.OSPolicy_Resource_FileResource_File{
+						File: &agentendpointpb.OSPolicy_Resource_File{
+							Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: src},
+						},
+					},
+					State: agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH,
+				},
+			},
+		},
+	}
+	if err := pr.Validate(ctx); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := pr.CheckState(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestFileResourceStatefulOverlay(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootfs := filepath.Join(tmpDir, "rootfs")
+	overlay := filepath.Join(tmpDir, "overlay")
+	if err := os.MkdirAll(filepath.Join(overlay, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// rootfs/opt links to its overlay, rootfs/srv is only mapped to one.
+	if err := os.MkdirAll(filepath.Join(rootfs, "srv"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(overlay, filepath.Join(rootfs, "opt")); err != nil {
+		t.Fatal(err)
+	}
+	defer func(f func(string) bool) { readOnly = f }(readOnly)
+	readOnly = func(path string) bool { return !strings.HasPrefix(path, overlay) }
+	defer func(o []struct{ dir, overlay string }) { statefulOverlays = o }(statefulOverlays)
+	statefulOverlays = []struct{ dir, overlay string }{{filepath.Join(rootfs, "opt"), overlay}, {filepath.Join(rootfs, "srv"), overlay}}
+
+	var tests = []struct {
+		name       string
+		path       string
+		wantPath   string
+		wantErrMsg string
+	}{
+		{"Overlay", filepath.Join(rootfs, "opt", "app", "config"), filepath.Join(overlay, "app", "config"), ""},
+		{"NotResolvedIntoOverlay", filepath.Join(rootfs, "srv", "app", "config"), filepath.Join(rootfs, "srv", "app", "config"), "read-only file system"},
+		{"Immutable", filepath.Join(rootfs, "usr", "bin", "app"), filepath.Join(rootfs, "usr", "bin", "app"), "read-only file system"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{
+				OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+					ResourceType: &agentendpointpb.OSPolicy_Resource_File_{File: &agentendpointpb.OSPolicy_Resource_FileResource{
+						Path:   tt.path,
+						State:  agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH,
+						Source: &agentendpointpb.OSPolicy_Resource_FileResource_Content{Content: "contents"},
+					}},
+				},
+			}
+			if err := pr.Validate(ctx); err != nil {
+				t.Fatalf("Unexpected Validate error: %v", err)
+			}
+			defer pr.Cleanup(ctx)
+			if got := pr.ManagedResources().Files[0].Path; got != tt.wantPath {
+				t.Errorf("managed path: got %q, want %q", got, tt.wantPath)
+			}
+
+			err := pr.EnforceState(ctx)
+			if tt.wantErrMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrMsg) || !errors.Is(err, ErrReadOnlyFS) {
+					t.Errorf("EnforceState error: got %v, want %q", err, tt.wantErrMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected EnforceState error: %v", err)
+			}
+			if got, err := ioutil.ReadFile(tt.wantPath); err != nil || string(got) != "contents" {
+				t.Errorf("overlay file: got %q, %v, want %q", got, err, "contents")
+			}
+		})
+	}
+}
@@ -0,0 +1,711 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+// Supported firewall backends.
+const (
+	Firewalld       = "firewalld"
+	Nftables        = "nftables"
+	Iptables        = "iptables"
+	WindowsFirewall = "windows"
+)
+
+var (
+	firewallCmd = "/usr/bin/firewall-cmd"
+	nft         = "/usr/sbin/nft"
+	iptables    = "/usr/sbin/iptables"
+	ip6tables   = "/usr/sbin/ip6tables"
+
+	// nftTable holds all agent managed nftables rules.
+	nftTable = "google_osconfig_agent"
+
+	firewallRuleNameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,48}$`)
+	nftCommentRe       = regexp.MustCompile(`comment "([^"]*)"`)
+	nftHandleRe        = regexp.MustCompile(`# handle (\d+)`)
+)
+
+// FirewallRuleResource ensures a host firewall rule exists. Rules are
+// identified by Name, changing any other field replaces the rule.
+//
+// nftables and iptables rules are applied to the running firewall only and
+// are reapplied on each policy run, firewalld and Windows Firewall rules are
+// persistent. nftables rules live in their own table so an allow rule does
+// not override a drop from another table.
+type FirewallRuleResource struct {
+	Name string `json:"name"`
+	// Direction is inbound (the default) or outbound.
+	Direction string `json:"direction,omitempty"`
+	// Action is allow (the default) or deny.
+	Action string `json:"action,omitempty"`
+	// Protocol is tcp (the default), udp or any.
+	Protocol string `json:"protocol,omitempty"`
+	// Ports are local ports for inbound rules and remote ports for outbound
+	// rules, as single ports or ranges like 8000-8100.
+	Ports []string `json:"ports,omitempty"`
+	// RemoteAddresses are IP addresses or CIDR ranges, these are the
+	// sources of inbound and the destinations of outbound traffic.
+	RemoteAddresses []string `json:"remoteAddresses,omitempty"`
+	// Zone is the firewalld zone, the default zone is used if not set.
+	Zone string `json:"zone,omitempty"`
+	// Backend is one of firewalld, nftables, iptables or windows, it is
+	// detected if not set.
+	Backend string `json:"backend,omitempty"`
+	State   State  `json:"state,omitempty"`
+}
+
+type firewallRuleResource struct {
+	*FirewallRuleResource
+
+	state                                State
+	direction, action, protocol, backend string
+	// tag identifies the rule and its settings, it is stored with the rule
+	// as a comment or description.
+	tag string
+}
+
+func detectFirewallBackend(ctx context.Context) (string, error) {
+	if goos == "windows" {
+		return WindowsFirewall, nil
+	}
+	if util.Exists(firewallCmd) {
+		if _, err := runCmd(ctx, firewallCmd, "--state"); err == nil {
+			return Firewalld, nil
+		}
+	}
+	if util.Exists(nft) {
+		return Nftables, nil
+	}
+	if util.Exists(iptables) {
+		return Iptables, nil
+	}
+	return "", errors.New("no supported firewall found")
+}
+
+func validPortRange(p string) bool {
+	lo, hi, isRange := strings.Cut(p, "-")
+	if !isRange {
+		hi = lo
+	}
+	l, err := strconv.Atoi(lo)
+	if err != nil {
+		return false
+	}
+	h, err := strconv.Atoi(hi)
+	if err != nil {
+		return false
+	}
+	return l > 0 && h <= 65535 && l <= h
+}
+
+func isIPv6Address(a string) bool {
+	return strings.Contains(a, ":")
+}
+
+func (f *firewallRuleResource) validate(ctx context.Context) (*ManagedResources, error) {
+	var err error
+	if f.state, err = f.State.validate(); err != nil {
+		return nil, fmt.Errorf("FirewallRuleResource: %v", err)
+	}
+	if !firewallRuleNameRe.MatchString(f.Name) {
+		return nil, fmt.Errorf("FirewallRuleResource: invalid Name %q, must be 1-48 letters, digits, '-' or '_'", f.Name)
+	}
+
+	f.direction = strings.ToLower(f.Direction)
+	switch f.direction {
+	case "":
+		f.direction = "inbound"
+	case "inbound", "outbound":
+	default:
+		return nil, fmt.Errorf("FirewallRuleResource: unrecognized Direction %q", f.Direction)
+	}
+	f.action = strings.ToLower(f.Action)
+	switch f.action {
+	case "":
+		f.action = "allow"
+	case "allow", "deny":
+	default:
+		return nil, fmt.Errorf("FirewallRuleResource: unrecognized Action %q", f.Action)
+	}
+	f.protocol = strings.ToLower(f.Protocol)
+	switch f.protocol {
+	case "":
+		f.protocol = "tcp"
+	case "tcp", "udp", "any":
+	default:
+		return nil, fmt.Errorf("FirewallRuleResource: unrecognized Protocol %q", f.Protocol)
+	}
+	if f.protocol == "any" && len(f.Ports) > 0 {
+		return nil, errors.New("FirewallRuleResource: Ports require a tcp or udp Protocol")
+	}
+	for _, p := range f.Ports {
+		if !validPortRange(p) {
+			return nil, fmt.Errorf("FirewallRuleResource: invalid port %q", p)
+		}
+	}
+	for _, a := range f.RemoteAddresses {
+		if net.ParseIP(a) == nil {
+			if _, _, err := net.ParseCIDR(a); err != nil {
+				return nil, fmt.Errorf("FirewallRuleResource: invalid remote address %q", a)
+			}
+		}
+	}
+
+	f.backend = strings.ToLower(f.Backend)
+	if f.backend == "" {
+		if f.backend, err = detectFirewallBackend(ctx); err != nil {
+			return nil, fmt.Errorf("FirewallRuleResource: %v", err)
+		}
+	}
+	switch f.backend {
+	case Firewalld:
+		if f.direction != "inbound" {
+			return nil, errors.New("FirewallRuleResource: firewalld zones only support inbound rules")
+		}
+		if len(f.Ports) == 0 {
+			return nil, errors.New("FirewallRuleResource: firewalld rules require Ports")
+		}
+	case Nftables, Iptables:
+		if goos == "windows" {
+			return nil, fmt.Errorf("FirewallRuleResource: backend %q is not supported on Windows", f.backend)
+		}
+	case WindowsFirewall:
+		if goos != "windows" {
+			return nil, fmt.Errorf("FirewallRuleResource: backend %q can only be used on Windows", f.backend)
+		}
+	default:
+		return nil, fmt.Errorf("FirewallRuleResource: unsupported backend %q", f.Backend)
+	}
+	if f.Zone != "" && f.backend != Firewalld {
+		return nil, errors.New("FirewallRuleResource: Zone is only supported with firewalld")
+	}
+
+	b, err := json.Marshal([]any{f.direction, f.action, f.protocol, f.Ports, f.RemoteAddresses, f.Zone})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	f.tag = fmt.Sprintf("osconfig:%s:%x", f.Name, sum[:6])
+	return nil, nil
+}
+
+// ownsTag reports whether a rule tag belongs to this rule, regardless of
+// its settings.
+func (f *firewallRuleResource) ownsTag(tag string) bool {
+	return strings.HasPrefix(tag, "osconfig:"+f.Name+":")
+}
+
+// tagsMatch reports whether the rules tagged for this rule are exactly want
+// rules with the current tag.
+func (f *firewallRuleResource) tagsMatch(tags []string, want int) bool {
+	if f.state == StateAbsent {
+		return len(tags) == 0
+	}
+	if len(tags) != want {
+		return false
+	}
+	for _, t := range tags {
+		if t != f.tag {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *firewallRuleResource) addressesByFamily() (v4, v6 []string) {
+	for _, a := range f.RemoteAddresses {
+		if isIPv6Address(a) {
+			v6 = append(v6, a)
+		} else {
+			v4 = append(v4, a)
+		}
+	}
+	return v4, v6
+}
+
+// nftables
+
+type nftRule struct {
+	comment, handle string
+}
+
+func (f *firewallRuleResource) nftChain() string {
+	if f.direction == "outbound" {
+		return "output"
+	}
+	return "input"
+}
+
+func parseNftRules(out []byte) []nftRule {
+	var rules []nftRule
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		c := nftCommentRe.FindStringSubmatch(scanner.Text())
+		h := nftHandleRe.FindStringSubmatch(scanner.Text())
+		if c == nil || h == nil {
+			continue
+		}
+		rules = append(rules, nftRule{comment: c[1], handle: h[1]})
+	}
+	return rules
+}
+
+func (f *firewallRuleResource) nftOwnedRules(ctx context.Context) []nftRule {
+	out, err := runCmd(ctx, nft, "-a", "list", "chain", "inet", nftTable, f.nftChain())
+	if err != nil {
+		// The table or chain does not exist yet.
+		clog.Debugf(ctx, "Error listing nftables chain: %v", err)
+		return nil
+	}
+	var rules []nftRule
+	for _, r := range parseNftRules(out) {
+		if f.ownsTag(r.comment) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// nftRules returns the rule expressions, one per address family if remote
+// addresses are set.
+func (f *firewallRuleResource) nftRules() [][]string {
+	var match []string
+	switch {
+	case len(f.Ports) > 0:
+		match = []string{f.protocol, "dport", "{ " + strings.Join(f.Ports, ", ") + " }"}
+	case f.protocol != "any":
+		match = []string{"meta", "l4proto", f.protocol}
+	}
+	verdict := []string{"accept"}
+	if f.action == "deny" {
+		verdict = []string{"drop"}
+	}
+	verdict = append(verdict, "comment", strconv.Quote(f.tag))
+
+	addr := "saddr"
+	if f.direction == "outbound" {
+		addr = "daddr"
+	}
+	v4, v6 := f.addressesByFamily()
+	if len(v4)+len(v6) == 0 {
+		return [][]string{append(match, verdict...)}
+	}
+	var rules [][]string
+	add := func(family string, addrs []string) {
+		if len(addrs) == 0 {
+			return
+		}
+		r := []string{family, addr, "{ " + strings.Join(addrs, ", ") + " }"}
+		r = append(r, match...)
+		rules = append(rules, append(r, verdict...))
+	}
+	add("ip", v4)
+	add("ip6", v6)
+	return rules
+}
+
+func (f *firewallRuleResource) checkNftables(ctx context.Context) bool {
+	var tags []string
+	for _, r := range f.nftOwnedRules(ctx) {
+		tags = append(tags, r.comment)
+	}
+	return f.tagsMatch(tags, len(f.nftRules()))
+}
+
+func (f *firewallRuleResource) enforceNftables(ctx context.Context) error {
+	for _, r := range f.nftOwnedRules(ctx) {
+		if _, err := runCmd(ctx, nft, "delete", "rule", "inet", nftTable, f.nftChain(), "handle", r.handle); err != nil {
+			return err
+		}
+	}
+	if f.state == StateAbsent {
+		return nil
+	}
+
+	// Adding an existing table or chain is a no-op.
+	if _, err := runCmd(ctx, nft, "add", "table", "inet", nftTable); err != nil {
+		return err
+	}
+	if _, err := runCmd(ctx, nft, "add", "chain", "inet", nftTable, f.nftChain(), fmt.Sprintf("{ type filter hook %s priority 0 ; policy accept ; }", f.nftChain())); err != nil {
+		return err
+	}
+	for _, r := range f.nftRules() {
+		if _, err := runCmd(ctx, nft, append([]string{"add", "rule", "inet", nftTable, f.nftChain()}, r...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iptables
+
+func (f *firewallRuleResource) iptablesChain() string {
+	if f.direction == "outbound" {
+		return "OUTPUT"
+	}
+	return "INPUT"
+}
+
+// iptablesRules returns the rule specs by iptables binary, iptables expands
+// multiple addresses into one rule each so rules are created that way.
+func (f *firewallRuleResource) iptablesRules() map[string][][]string {
+	var match []string
+	if f.protocol != "any" {
+		match = []string{"-p", f.protocol}
+	}
+	if len(f.Ports) > 0 {
+		match = append(match, "-m", "multiport", "--dports", strings.ReplaceAll(strings.Join(f.Ports, ","), "-", ":"))
+	}
+	target := "ACCEPT"
+	if f.action == "deny" {
+		target = "DROP"
+	}
+	end := []string{"-m", "comment", "--comment", f.tag, "-j", target}
+
+	addrFlag := "-s"
+	if f.direction == "outbound" {
+		addrFlag = "-d"
+	}
+	rules := map[string][][]string{}
+	add := func(bin string, addr string) {
+		var r []string
+		if addr != "" {
+			r = append(r, addrFlag, addr)
+		}
+		r = append(r, match...)
+		rules[bin] = append(rules[bin], append(r, end...))
+	}
+	v4, v6 := f.addressesByFamily()
+	if len(v4)+len(v6) == 0 {
+		add(iptables, "")
+		if util.Exists(ip6tables) {
+			add(ip6tables, "")
+		}
+	}
+	for _, a := range v4 {
+		add(iptables, a)
+	}
+	for _, a := range v6 {
+		add(ip6tables, a)
+	}
+	return rules
+}
+
+// parseIptablesRules returns the rule specs in chain from iptables -S
+// output with their comments.
+func parseIptablesRules(out []byte, chain string) (specs [][]string, comments []string) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 2 || f[0] != "-A" || f[1] != chain {
+			continue
+		}
+		var comment string
+		for i := range f {
+			f[i] = strings.Trim(f[i], `"`)
+			if i > 0 && f[i-1] == "--comment" {
+				comment = f[i]
+			}
+		}
+		specs = append(specs, f[2:])
+		comments = append(comments, comment)
+	}
+	return specs, comments
+}
+
+func (f *firewallRuleResource) iptablesOwnedRules(ctx context.Context, bin string) ([][]string, []string, error) {
+	out, err := runCmd(ctx, bin, "-w", "-S", f.iptablesChain())
+	if err != nil {
+		return nil, nil, err
+	}
+	specs, comments := parseIptablesRules(out, f.iptablesChain())
+	var owned [][]string
+	var tags []string
+	for i, c := range comments {
+		if f.ownsTag(c) {
+			owned = append(owned, specs[i])
+			tags = append(tags, c)
+		}
+	}
+	return owned, tags, nil
+}
+
+func (f *firewallRuleResource) iptablesBins() []string {
+	bins := []string{iptables}
+	if util.Exists(ip6tables) {
+		bins = append(bins, ip6tables)
+	}
+	return bins
+}
+
+func (f *firewallRuleResource) checkIptables(ctx context.Context) (bool, error) {
+	want := f.iptablesRules()
+	for _, bin := range f.iptablesBins() {
+		_, tags, err := f.iptablesOwnedRules(ctx, bin)
+		if err != nil {
+			return false, err
+		}
+		if !f.tagsMatch(tags, len(want[bin])) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (f *firewallRuleResource) enforceIptables(ctx context.Context) error {
+	want := f.iptablesRules()
+	for _, bin := range f.iptablesBins() {
+		owned, _, err := f.iptablesOwnedRules(ctx, bin)
+		if err != nil {
+			return err
+		}
+		for _, spec := range owned {
+			if _, err := runCmd(ctx, bin, append([]string{"-w", "-D", f.iptablesChain()}, spec...)...); err != nil {
+				return err
+			}
+		}
+		if f.state == StateAbsent {
+			continue
+		}
+		for _, spec := range want[bin] {
+			if _, err := runCmd(ctx, bin, append([]string{"-w", "-A", f.iptablesChain()}, spec...)...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// firewalld, rules are a service holding the ports that is referenced by
+// rich rules for the addresses and action. The service description holds
+// the tag.
+
+func (f *firewallRuleResource) firewalldService() string {
+	return "osconfig-" + f.Name
+}
+
+func (f *firewallRuleResource) firewalldZoneArgs(args ...string) []string {
+	a := []string{"--permanent"}
+	if f.Zone != "" {
+		a = append(a, "--zone="+f.Zone)
+	}
+	return append(a, args...)
+}
+
+// firewalldRichRules returns the rich rules as firewalld lists them.
+func (f *firewallRuleResource) firewalldRichRules() []string {
+	action := "accept"
+	if f.action == "deny" {
+		action = "drop"
+	}
+	svc := fmt.Sprintf("service name=%q %s", f.firewalldService(), action)
+	if len(f.RemoteAddresses) == 0 {
+		return []string{"rule " + svc}
+	}
+	var rules []string
+	for _, a := range f.RemoteAddresses {
+		family := "ipv4"
+		if isIPv6Address(a) {
+			family = "ipv6"
+		}
+		rules = append(rules, fmt.Sprintf("rule family=%q source address=%q %s", family, a, svc))
+	}
+	return rules
+}
+
+func (f *firewallRuleResource) firewalldOwnedRichRules(ctx context.Context) ([]string, error) {
+	out, err := runCmd(ctx, firewallCmd, f.firewalldZoneArgs("--list-rich-rules")...)
+	if err != nil {
+		return nil, err
+	}
+	ref := fmt.Sprintf("service name=%q", f.firewalldService())
+	var rules []string
+	for _, ln := range strings.Split(string(out), "\n") {
+		if strings.Contains(ln, ref) {
+			rules = append(rules, strings.TrimSpace(ln))
+		}
+	}
+	return rules, nil
+}
+
+func (f *firewallRuleResource) checkFirewalld(ctx context.Context) (bool, error) {
+	desc, err := runCmd(ctx, firewallCmd, "--permanent", "--service="+f.firewalldService(), "--get-description")
+	exists := err == nil
+	rules, err := f.firewalldOwnedRichRules(ctx)
+	if err != nil {
+		return false, err
+	}
+	if f.state == StateAbsent {
+		return !exists && len(rules) == 0, nil
+	}
+	if !exists || strings.TrimSpace(string(desc)) != f.tag {
+		return false, nil
+	}
+	want := f.firewalldRichRules()
+	sort.Strings(rules)
+	sort.Strings(want)
+	return linesEqual(rules, want), nil
+}
+
+func (f *firewallRuleResource) enforceFirewalld(ctx context.Context) error {
+	rules, err := f.firewalldOwnedRichRules(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		if _, err := runCmd(ctx, firewallCmd, f.firewalldZoneArgs("--remove-rich-rule="+r)...); err != nil {
+			return err
+		}
+	}
+	svc := f.firewalldService()
+	if _, err := runCmd(ctx, firewallCmd, "--permanent", "--service="+svc, "--get-description"); err == nil {
+		if _, err := runCmd(ctx, firewallCmd, "--permanent", "--delete-service="+svc); err != nil {
+			return err
+		}
+	}
+
+	if f.state == StatePresent {
+		if _, err := runCmd(ctx, firewallCmd, "--permanent", "--new-service="+svc); err != nil {
+			return err
+		}
+		args := []string{"--permanent", "--service=" + svc, "--set-description=" + f.tag}
+		for _, p := range f.Ports {
+			args = append(args, "--add-port="+p+"/"+f.protocol)
+		}
+		if _, err := runCmd(ctx, firewallCmd, args...); err != nil {
+			return err
+		}
+		for _, r := range f.firewalldRichRules() {
+			if _, err := runCmd(ctx, firewallCmd, f.firewalldZoneArgs("--add-rich-rule="+r)...); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Apply the permanent config to the running firewall.
+	_, err = runCmd(ctx, firewallCmd, "--reload")
+	return err
+}
+
+// Windows Firewall, the rule description holds the tag.
+
+func (f *firewallRuleResource) windowsRuleName() string {
+	return "osconfig-" + f.Name
+}
+
+func (f *firewallRuleResource) windowsNewRuleCommand() string {
+	direction := "Inbound"
+	portParam := "LocalPort"
+	if f.direction == "outbound" {
+		direction = "Outbound"
+		portParam = "RemotePort"
+	}
+	action := "Allow"
+	if f.action == "deny" {
+		action = "Block"
+	}
+	cmd := fmt.Sprintf("New-NetFirewallRule -Name '%s' -DisplayName '%s' -Description '%s' -Direction %s -Action %s", f.windowsRuleName(), f.Name, f.tag, direction, action)
+	if f.protocol != "any" {
+		cmd += " -Protocol " + strings.ToUpper(f.protocol)
+	}
+	if len(f.Ports) > 0 {
+		cmd += fmt.Sprintf(" -%s '%s'", portParam, strings.Join(f.Ports, "','"))
+	}
+	if len(f.RemoteAddresses) > 0 {
+		cmd += fmt.Sprintf(" -RemoteAddress '%s'", strings.Join(f.RemoteAddresses, "','"))
+	}
+	return cmd
+}
+
+func (f *firewallRuleResource) checkWindowsFirewall(ctx context.Context) (bool, error) {
+	out, err := runPowerShell(ctx, fmt.Sprintf("Get-NetFirewallRule -Name '%s' -ErrorAction SilentlyContinue | ForEach-Object { $_.Description }", f.windowsRuleName()))
+	if err != nil {
+		return false, err
+	}
+	var tags []string
+	if desc := strings.TrimSpace(string(out)); desc != "" {
+		tags = append(tags, desc)
+	}
+	return f.tagsMatch(tags, 1), nil
+}
+
+func (f *firewallRuleResource) enforceWindowsFirewall(ctx context.Context) error {
+	cmd := fmt.Sprintf("Remove-NetFirewallRule -Name '%s' -ErrorAction SilentlyContinue", f.windowsRuleName())
+	if f.state == StatePresent {
+		cmd += "; " + f.windowsNewRuleCommand()
+	}
+	_, err := runPowerShell(ctx, cmd)
+	return err
+}
+
+func (f *firewallRuleResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	switch f.backend {
+	case Nftables:
+		return f.checkNftables(ctx), nil
+	case Iptables:
+		return f.checkIptables(ctx)
+	case Firewalld:
+		return f.checkFirewalld(ctx)
+	case WindowsFirewall:
+		return f.checkWindowsFirewall(ctx)
+	}
+	return false, fmt.Errorf("unsupported backend %q", f.backend)
+}
+
+func (f *firewallRuleResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Enforcing state %q for %s firewall rule %q.", f.state, f.backend, f.Name)
+	switch f.backend {
+	case Nftables:
+		err = f.enforceNftables(ctx)
+	case Iptables:
+		err = f.enforceIptables(ctx)
+	case Firewalld:
+		err = f.enforceFirewalld(ctx)
+	case WindowsFirewall:
+		err = f.enforceWindowsFirewall(ctx)
+	default:
+		err = fmt.Errorf("unsupported backend %q", f.backend)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (f *firewallRuleResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {
+}
+
+func (f *firewallRuleResource) cleanup(ctx context.Context) error {
+	return nil
+}
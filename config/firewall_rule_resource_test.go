@@ -0,0 +1,194 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestFirewallRuleResourceValidate(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+
+	var tests = []struct {
+		name    string
+		fr      *FirewallRuleResource
+		wantErr bool
+	}{
+		{"Nftables", &FirewallRuleResource{Name: "ssh", Ports: []string{"22"}, RemoteAddresses: []string{"10.0.0.0/8", "::1"}, Backend: "nftables"}, false},
+		{"IptablesOutbound", &FirewallRuleResource{Name: "block-smtp", Direction: "outbound", Action: "deny", Ports: []string{"25"}, Backend: "iptables"}, false},
+		{"FirewalldRange", &FirewallRuleResource{Name: "app", Protocol: "udp", Ports: []string{"8000-8100"}, Zone: "public", Backend: "firewalld"}, false},
+		{"AnyProtocol", &FirewallRuleResource{Name: "trusted", Protocol: "any", RemoteAddresses: []string{"10.1.2.3"}, Backend: "nftables"}, false},
+		{"BadName", &FirewallRuleResource{Name: "my rule", Ports: []string{"22"}, Backend: "nftables"}, true},
+		{"BadPort", &FirewallRuleResource{Name: "a", Ports: []string{"70000"}, Backend: "nftables"}, true},
+		{"BadRange", &FirewallRuleResource{Name: "a", Ports: []string{"90-80"}, Backend: "nftables"}, true},
+		{"PortsWithAnyProtocol", &FirewallRuleResource{Name: "a", Protocol: "any", Ports: []string{"22"}, Backend: "nftables"}, true},
+		{"BadAddress", &FirewallRuleResource{Name: "a", RemoteAddresses: []string{"10.0.0.0/33"}, Backend: "nftables"}, true},
+		{"FirewalldOutbound", &FirewallRuleResource{Name: "a", Direction: "outbound", Ports: []string{"22"}, Backend: "firewalld"}, true},
+		{"FirewalldNoPorts", &FirewallRuleResource{Name: "a", Backend: "firewalld"}, true},
+		{"ZoneWithoutFirewalld", &FirewallRuleResource{Name: "a", Ports: []string{"22"}, Zone: "public", Backend: "iptables"}, true},
+		{"WindowsOnLinux", &FirewallRuleResource{Name: "a", Ports: []string{"22"}, Backend: "windows"}, true},
+		{"BadAction", &FirewallRuleResource{Name: "a", Action: "reject", Backend: "nftables"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{Local: &LocalResource{FirewallRule: tt.fr}}
+			err := pr.Validate(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFirewallRuleTag(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+
+	f1 := &firewallRuleResource{FirewallRuleResource: &FirewallRuleResource{Name: "ssh", Ports: []string{"22"}, Backend: "nftables"}}
+	f2 := &firewallRuleResource{FirewallRuleResource: &FirewallRuleResource{Name: "ssh", Ports: []string{"2222"}, Backend: "nftables"}}
+	for _, f := range []*firewallRuleResource{f1, f2} {
+		if _, err := f.validate(ctx); err != nil {
+			t.Fatalf("Unexpected validate error: %v", err)
+		}
+	}
+	if f1.tag == f2.tag {
+		t.Errorf("Expected different tags for different settings, got %q", f1.tag)
+	}
+	if !f1.ownsTag(f2.tag) {
+		t.Errorf("Expected %q to own tag %q", f1.Name, f2.tag)
+	}
+	if f1.ownsTag("osconfig:ssh-2:abc") {
+		t.Error("Unexpected ownership of another rule's tag")
+	}
+}
+
+func TestNftRules(t *testing.T) {
+	f := &firewallRuleResource{
+		FirewallRuleResource: &FirewallRuleResource{Ports: []string{"22", "8000-8100"}, RemoteAddresses: []string{"10.0.0.0/8", "fd00::/8", "192.168.0.1"}},
+		direction:            "inbound", action: "allow", protocol: "tcp", tag: "osconfig:ssh:abc",
+	}
+	want := [][]string{
+		{"ip", "saddr", "{ 10.0.0.0/8, 192.168.0.1 }", "tcp", "dport", "{ 22, 8000-8100 }", "accept", "comment", `"osconfig:ssh:abc"`},
+		{"ip6", "saddr", "{ fd00::/8 }", "tcp", "dport", "{ 22, 8000-8100 }", "accept", "comment", `"osconfig:ssh:abc"`},
+	}
+	if got := f.nftRules(); !reflect.DeepEqual(got, want) {
+		t.Errorf("nftRules() = %q, want %q", got, want)
+	}
+}
+
+func TestParseNftRules(t *testing.T) {
+	out := []byte(`table inet google_osconfig_agent {
+	chain input { # handle 1
+		type filter hook input priority filter; policy accept;
+		tcp dport 22 accept comment "osconfig:ssh:abc" # handle 4
+		udp dport 53 accept # handle 5
+	}
+}
+`)
+	want := []nftRule{{comment: "osconfig:ssh:abc", handle: "4"}}
+	if got := parseNftRules(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseNftRules() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseIptablesRules(t *testing.T) {
+	out := []byte(`-P INPUT ACCEPT
+-A INPUT -s 10.0.0.0/8 -p tcp -m multiport --dports 22 -m comment --comment "osconfig:ssh:abc" -j ACCEPT
+-A OUTPUT -p tcp -j DROP
+`)
+	specs, comments := parseIptablesRules(out, "INPUT")
+	wantSpecs := [][]string{{"-s", "10.0.0.0/8", "-p", "tcp", "-m", "multiport", "--dports", "22", "-m", "comment", "--comment", "osconfig:ssh:abc", "-j", "ACCEPT"}}
+	if !reflect.DeepEqual(specs, wantSpecs) {
+		t.Errorf("parseIptablesRules() specs = %q, want %q", specs, wantSpecs)
+	}
+	if want := []string{"osconfig:ssh:abc"}; !reflect.DeepEqual(comments, want) {
+		t.Errorf("parseIptablesRules() comments = %q, want %q", comments, want)
+	}
+}
+
+func TestFirewallRuleResourceFirewalld(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = mockCommandRunner
+
+	pr := &OSPolicyResource{Local: &LocalResource{FirewallRule: &FirewallRuleResource{Name: "web", Ports: []string{"443"}, RemoteAddresses: []string{"10.0.0.0/8"}, Backend: "firewalld"}}}
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+	tag := pr.resource.(*firewallRuleResource).tag
+	richRule := `rule family="ipv4" source address="10.0.0.0/8" service name="osconfig-web" accept`
+	notFound := &exec.ExitError{}
+
+	gomock.InOrder(
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(firewallCmd, "--permanent", "--service=osconfig-web", "--get-description"))).Return(nil, nil, notFound),
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(firewallCmd, "--permanent", "--list-rich-rules"))).Return([]byte("rule family=\"ipv4\" source address=\"1.2.3.4\" accept\n"), nil, nil),
+	)
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if pr.InDesiredState() {
+		t.Fatal("Unexpected InDesiredState before enforce")
+	}
+
+	gomock.InOrder(
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(firewallCmd, "--permanent", "--list-rich-rules"))).Return(nil, nil, nil),
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(firewallCmd, "--permanent", "--service=osconfig-web", "--get-description"))).Return(nil, nil, notFound),
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(firewallCmd, "--permanent", "--new-service=osconfig-web"))).Return(nil, nil, nil),
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(firewallCmd, "--permanent", "--service=osconfig-web", "--set-description="+tag, "--add-port=443/tcp"))).Return(nil, nil, nil),
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(firewallCmd, "--permanent", "--add-rich-rule="+richRule))).Return(nil, nil, nil),
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(firewallCmd, "--reload"))).Return(nil, nil, nil),
+	)
+	if err := pr.EnforceState(ctx); err != nil {
+		t.Fatalf("Unexpected EnforceState error: %v", err)
+	}
+
+	gomock.InOrder(
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(firewallCmd, "--permanent", "--service=osconfig-web", "--get-description"))).Return([]byte(tag+"\n"), nil, nil),
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(firewallCmd, "--permanent", "--list-rich-rules"))).Return([]byte(richRule+"\n"), nil, nil),
+	)
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if !pr.InDesiredState() {
+		t.Error("Expected InDesiredState after enforce")
+	}
+}
+
+func TestFirewallRuleResourceWindows(t *testing.T) {
+	f := &firewallRuleResource{
+		FirewallRuleResource: &FirewallRuleResource{Name: "rdp", Ports: []string{"3389"}, RemoteAddresses: []string{"10.0.0.0/8", "192.168.0.0/16"}},
+		direction:            "inbound", action: "allow", protocol: "tcp", tag: "osconfig:rdp:abc",
+	}
+	want := "New-NetFirewallRule -Name 'osconfig-rdp' -DisplayName 'rdp' -Description 'osconfig:rdp:abc' -Direction Inbound -Action Allow -Protocol TCP -LocalPort '3389' -RemoteAddress '10.0.0.0/8','192.168.0.0/16'"
+	if got := f.windowsNewRuleCommand(); got != want {
+		t.Errorf("windowsNewRuleCommand() =\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,221 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+const gitCmd = "git"
+
+var (
+	// gitCacheDir holds a bare repository per git source, commits already
+	// fetched are reused across policy runs.
+	gitCacheDir = filepath.Join(agentconfig.CacheDir(), "config_git_cache")
+	gitCacheMx  sync.Mutex
+
+	commitRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+)
+
+// gitSource is a file in a git repository, referenced by a Remote URI like
+// git+https://github.com/org/scripts.git?ref=v1.2.0&path=linux/install.sh.
+// Ref is a branch, tag or full commit ID, HEAD if not set, a commit ID pins
+// the file to that commit.
+type gitSource struct {
+	repo, ref, path string
+}
+
+// isGitURI reports whether uri is a file in a git repository.
+func isGitURI(uri string) bool {
+	return strings.HasPrefix(uri, "git+")
+}
+
+func parseGitURI(uri string) (*gitSource, error) {
+	u, err := url.Parse(strings.TrimPrefix(uri, "git+"))
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https", "http", "ssh", "file":
+	default:
+		return nil, fmt.Errorf("invalid git URI %q, scheme must be git+https, git+ssh or git+file", uri)
+	}
+	q := u.Query()
+	src := &gitSource{ref: q.Get("ref"), path: q.Get("path")}
+	u.RawQuery = ""
+	src.repo = u.String()
+
+	if src.ref == "" {
+		src.ref = "HEAD"
+	}
+	// Refs and paths must not be taken for options by git.
+	if strings.HasPrefix(src.ref, "-") || strings.ContainsAny(src.ref, " \t\r\n:") {
+		return nil, fmt.Errorf("invalid git URI %q, bad ref %q", uri, src.ref)
+	}
+	if src.path == "" || path.IsAbs(src.path) || path.Clean(src.path) != src.path || strings.HasPrefix(src.path, "../") || src.path == ".." {
+		return nil, fmt.Errorf("invalid git URI %q, path must be a file relative to the repository root", uri)
+	}
+	return src, nil
+}
+
+// dir is the cached bare repository of the source.
+func (s *gitSource) dir() string {
+	sum := sha256.Sum256([]byte(s.repo))
+	return filepath.Join(gitCacheDir, hex.EncodeToString(sum[:])[:16])
+}
+
+// pinned reports whether the source is pinned to a commit.
+func (s *gitSource) pinned() bool {
+	return commitRe.MatchString(s.ref)
+}
+
+func gitCommand(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, gitCmd, append([]string{"-C", dir}, args...)...)
+	// Never prompt for credentials, use a credential helper or ssh keys.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	return cmd
+}
+
+func gitError(args []string, err error, stderr []byte) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("git is required for git sources, install it or use a GCS or remote source: %v", err)
+	}
+	return fmt.Errorf("error running git %q: %v, stderr: %q", args, err, bytes.TrimSpace(stderr))
+}
+
+func runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	stdout, stderr, err := runner.Run(ctx, gitCommand(ctx, dir, args...))
+	if err != nil {
+		return nil, gitError(args, err, stderr)
+	}
+	return stdout, nil
+}
+
+// write writes the file of the source at commit to path, checked against
+// checksum if set. With a runner that can stream the file is never held in
+// memory, it may be large.
+func (s *gitSource) write(ctx context.Context, commit, path string, perms os.FileMode, checksum string) (string, error) {
+	args := []string{"show", commit + ":" + s.path}
+	readErr := func(err error) error {
+		return fmt.Errorf("error reading %q at commit %s of %s: %v", s.path, commit, s.repo, err)
+	}
+	sr, ok := runner.(util.StreamingCommandRunner)
+	if !ok {
+		content, err := runGit(ctx, s.dir(), args...)
+		if err != nil {
+			return "", readErr(err)
+		}
+		return util.AtomicWriteFileStream(bytes.NewReader(content), checksum, path, perms)
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		stderr, err := sr.RunStreaming(ctx, gitCommand(ctx, s.dir(), args...), pw)
+		if err != nil {
+			err = readErr(gitError(args, err, stderr))
+		}
+		// A failed git show fails the write rather than leaving a partial
+		// file.
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	sum, err := util.AtomicWriteFileStream(pr, checksum, path, perms)
+	// Unblock git if the write stopped early, its error is then only that of
+	// the closed pipe.
+	pr.Close()
+	gitErr := <-done
+	if err != nil {
+		return "", err
+	}
+	return sum, gitErr
+}
+
+// fetch makes sure the commit of the source is in the cached repository,
+// with a shallow fetch unless it is a pinned commit already fetched, and
+// returns its ID.
+func (s *gitSource) fetch(ctx context.Context) (string, error) {
+	dir := s.dir()
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(gitCacheDir, 0700); err != nil {
+			return "", err
+		}
+		if _, err := runGit(ctx, gitCacheDir, "init", "--quiet", "--bare", dir); err != nil {
+			return "", err
+		}
+	}
+
+	if s.pinned() {
+		if _, err := runGit(ctx, dir, "cat-file", "-e", s.ref+"^{commit}"); err == nil {
+			clog.Debugf(ctx, "Using cached commit %s of %s", s.ref, s.repo)
+			return s.ref, nil
+		}
+	}
+	if _, err := runGit(ctx, dir, "fetch", "--quiet", "--depth=1", "--no-tags", "--", s.repo, s.ref); err != nil {
+		return "", errcode.Wrap(errcode.Network, err)
+	}
+	out, err := runGit(ctx, dir, "rev-parse", "--verify", "FETCH_HEAD^{commit}")
+	if err != nil {
+		return "", err
+	}
+	commit := strings.TrimSpace(string(out))
+	if s.pinned() && commit != s.ref {
+		return "", fmt.Errorf("fetched commit %s of %s, want pinned commit %s", commit, s.repo, s.ref)
+	}
+	return commit, nil
+}
+
+// downloadGitFile writes the file a git+ URI references to path, checked
+// against the sha256 checksum of remote if set.
+func downloadGitFile(ctx context.Context, path string, perms os.FileMode, remote *agentendpointpb.OSPolicy_Resource_File_Remote) (string, error) {
+	src, err := parseGitURI(remote.GetUri())
+	if err != nil {
+		return "", err
+	}
+
+	gitCacheMx.Lock()
+	defer gitCacheMx.Unlock()
+	commit, err := src.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	sum, err := src.write(ctx, commit, path, perms, remote.GetSha256Checksum())
+	if err != nil {
+		return "", err
+	}
+	clog.Debugf(ctx, "Fetched %q at commit %s of %s", src.path, commit, src.repo)
+	return sum, nil
+}
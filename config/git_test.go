@@ -0,0 +1,133 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+func TestParseGitURI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		want    gitSource
+		wantErr bool
+	}{
+		{"git+https://github.com/org/scripts.git?ref=v1.2.0&path=linux/install.sh", gitSource{"https://github.com/org/scripts.git", "v1.2.0", "linux/install.sh"}, false},
+		{"git+ssh://git@github.com/org/scripts.git?path=install.sh", gitSource{"ssh://git@github.com/org/scripts.git", "HEAD", "install.sh"}, false},
+		{"git+file:///srv/scripts?ref=0123456789abcdef0123456789abcdef01234567&path=a/b.ps1", gitSource{"file:///srv/scripts", "0123456789abcdef0123456789abcdef01234567", "a/b.ps1"}, false},
+		{"git+ftp://example.com/scripts.git?path=install.sh", gitSource{}, true},
+		{"git+https://github.com/org/scripts.git", gitSource{}, true},
+		{"git+https://github.com/org/scripts.git?path=/etc/passwd", gitSource{}, true},
+		{"git+https://github.com/org/scripts.git?path=../secret", gitSource{}, true},
+		{"git+https://github.com/org/scripts.git?path=a/../../b", gitSource{}, true},
+		{"git+https://github.com/org/scripts.git?ref=--upload-pack=id&path=install.sh", gitSource{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseGitURI(tt.uri)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseGitURI(%q) error = %v, want error %t", tt.uri, err, tt.wantErr)
+			continue
+		}
+		if err == nil && *got != tt.want {
+			t.Errorf("parseGitURI(%q) = %+v, want %+v", tt.uri, *got, tt.want)
+		}
+	}
+}
+
+func TestDownloadGitFile(t *testing.T) {
+	if _, err := exec.LookPath(gitCmd); err != nil {
+		t.Skip("git is not installed")
+	}
+	ctx := context.Background()
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = &util.DefaultRunner{}
+	defer func(d string) { gitCacheDir = d }(gitCacheDir)
+	gitCacheDir = filepath.Join(t.TempDir(), "cache")
+
+	// A repository with two commits of the script.
+	repo := filepath.Join(t.TempDir(), "repo")
+	git := func(args ...string) string {
+		cmd := exec.Command(gitCmd, append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %q: %v, %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := os.MkdirAll(filepath.Join(repo, "linux"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	git("init", "--quiet")
+	// Allow fetching pinned commits that are not a branch tip.
+	git("config", "uploadpack.allowAnySHA1InWant", "true")
+	write := func(content string) string {
+		if err := os.WriteFile(filepath.Join(repo, "linux", "install.sh"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", ".")
+		git("commit", "--quiet", "-m", content)
+		return git("rev-parse", "HEAD")
+	}
+	first := write("echo one")
+	write("echo two")
+
+	tests := []struct {
+		desc     string
+		ref      string
+		checksum string
+		want     string
+		wantErr  bool
+	}{
+		{"head", "", "", "echo two", false},
+		{"pinned commit", first, "", "echo one", false},
+		{"pinned commit cached", first, "", "echo one", false},
+		{"checksum", "", checksum(strings.NewReader("echo two")), "echo two", false},
+		{"bad checksum", "", checksum(strings.NewReader("echo one")), "", true},
+		{"unknown ref", "missing", "", "", true},
+	}
+	for _, tt := range tests {
+		uri := "git+file://" + filepath.ToSlash(repo) + "?path=linux/install.sh"
+		if tt.ref != "" {
+			uri += "&ref=" + tt.ref
+		}
+		dst := filepath.Join(t.TempDir(), "install.sh")
+		_, err := downloadFile(ctx, dst, 0755, &agentendpointpb.OSPolicy_Resource_File{
+			Type: &agentendpointpb.OSPolicy_Resource_File_Remote_{Remote: &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: uri, Sha256Checksum: tt.checksum}},
+		})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: downloadFile error = %v, want error %t", tt.desc, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.desc, got, tt.want)
+		}
+	}
+}
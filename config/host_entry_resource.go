@@ -0,0 +1,204 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+// HostEntryResource ensures a hosts file maps hostnames to an IP address.
+type HostEntryResource struct {
+	IP        string   `json:"ip"`
+	Hostnames []string `json:"hostnames"`
+	State     State    `json:"state,omitempty"`
+	// Path overrides the hosts file, /etc/hosts on Linux and
+	// %SystemRoot%\System32\drivers\etc\hosts on Windows.
+	Path string `json:"path,omitempty"`
+}
+
+type hostEntryResource struct {
+	*HostEntryResource
+
+	path  string
+	state State
+}
+
+func hostsFilePath() string {
+	if goos == "windows" {
+		root := os.Getenv("SystemRoot")
+		if root == "" {
+			root = `C:\Windows`
+		}
+		return filepath.Join(root, "System32", "drivers", "etc", "hosts")
+	}
+	return "/etc/hosts"
+}
+
+func (h *hostEntryResource) validate(ctx context.Context) (*ManagedResources, error) {
+	var err error
+	if h.state, err = h.State.validate(); err != nil {
+		return nil, fmt.Errorf("HostEntryResource: %v", err)
+	}
+	if net.ParseIP(h.IP) == nil {
+		return nil, fmt.Errorf("HostEntryResource: invalid IP %q", h.IP)
+	}
+	if len(h.Hostnames) == 0 {
+		return nil, errors.New("HostEntryResource: at least one hostname is required")
+	}
+	for _, n := range h.Hostnames {
+		if n == "" || strings.ContainsAny(n, " \t#") {
+			return nil, fmt.Errorf("HostEntryResource: invalid hostname %q", n)
+		}
+	}
+	h.path = h.Path
+	if h.path == "" {
+		h.path = hostsFilePath()
+	}
+	return nil, nil
+}
+
+// hostsLine is a parsed hosts file line, comment holds any trailing comment
+// including the leading "#".
+type hostsLine struct {
+	ip        string
+	hostnames []string
+	comment   string
+}
+
+func parseHostsLine(ln string) (hostsLine, bool) {
+	var hl hostsLine
+	entry := ln
+	if i := strings.Index(ln, "#"); i != -1 {
+		entry, hl.comment = ln[:i], ln[i:]
+	}
+	fields := strings.Fields(entry)
+	if len(fields) < 2 {
+		return hl, false
+	}
+	hl.ip, hl.hostnames = fields[0], fields[1:]
+	return hl, true
+}
+
+func (hl hostsLine) String() string {
+	s := hl.ip + "\t" + strings.Join(hl.hostnames, " ")
+	if hl.comment != "" {
+		s += " " + hl.comment
+	}
+	return s
+}
+
+func (h *hostEntryResource) wanted(name string) bool {
+	for _, n := range h.Hostnames {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// update returns lines with the entry made present or absent and whether
+// anything changed.
+func (h *hostEntryResource) update(lines []string) ([]string, bool) {
+	ip := net.ParseIP(h.IP)
+	var out []string
+	var changed bool
+	found := map[string]bool{}
+	for _, ln := range lines {
+		hl, ok := parseHostsLine(ln)
+		if !ok {
+			out = append(out, ln)
+			continue
+		}
+		sameIP := ip.Equal(net.ParseIP(hl.ip))
+		var keep []string
+		for _, n := range hl.hostnames {
+			switch {
+			case !h.wanted(n):
+				keep = append(keep, n)
+			case sameIP && h.state == StatePresent:
+				found[strings.ToLower(n)] = true
+				keep = append(keep, n)
+			case !sameIP && h.state == StateAbsent:
+				// Mappings to other IPs are not ours to remove.
+				keep = append(keep, n)
+			default:
+				changed = true
+			}
+		}
+		switch {
+		case len(keep) == len(hl.hostnames):
+			out = append(out, ln)
+		case len(keep) > 0:
+			hl.hostnames = keep
+			out = append(out, hl.String())
+		}
+	}
+
+	if h.state == StatePresent {
+		var missing []string
+		for _, n := range h.Hostnames {
+			if !found[strings.ToLower(n)] {
+				missing = append(missing, n)
+				found[strings.ToLower(n)] = true
+			}
+		}
+		if len(missing) > 0 {
+			changed = true
+			out = append(out, hostsLine{ip: h.IP, hostnames: missing}.String())
+		}
+	}
+	return out, changed
+}
+
+func (h *hostEntryResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	lines, _, err := readLines(h.path)
+	if err != nil {
+		return false, err
+	}
+	_, changed := h.update(lines)
+	return !changed, nil
+}
+
+func (h *hostEntryResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Enforcing state %q for host entry %s %q in %q.", h.state, h.IP, h.Hostnames, h.path)
+	lines, mode, err := readLines(h.path)
+	if err != nil {
+		return false, err
+	}
+	lines, changed := h.update(lines)
+	if !changed {
+		return true, nil
+	}
+	if err := writeLines(h.path, lines, mode); err != nil {
+		return false, fmt.Errorf("error writing %q: %v", h.path, err)
+	}
+	return true, nil
+}
+
+func (h *hostEntryResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {}
+
+func (h *hostEntryResource) cleanup(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,139 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testHosts = `127.0.0.1	localhost
+# The following lines are desirable for IPv6 capable hosts
+::1	localhost ip6-localhost
+10.0.0.5	db db.internal # managed elsewhere
+10.0.0.9	old-api api
+`
+
+func TestHostEntryResourceValidate(t *testing.T) {
+	ctx := context.Background()
+	var tests = []struct {
+		name    string
+		he      *HostEntryResource
+		wantErr bool
+	}{
+		{"Valid", &HostEntryResource{IP: "10.0.0.1", Hostnames: []string{"api"}}, false},
+		{"ValidIPv6Absent", &HostEntryResource{IP: "fe80::1", Hostnames: []string{"api"}, State: StateAbsent}, false},
+		{"BadIP", &HostEntryResource{IP: "10.0.0", Hostnames: []string{"api"}}, true},
+		{"NoHostnames", &HostEntryResource{IP: "10.0.0.1"}, true},
+		{"BadHostname", &HostEntryResource{IP: "10.0.0.1", Hostnames: []string{"a b"}}, true},
+		{"BadState", &HostEntryResource{IP: "10.0.0.1", Hostnames: []string{"api"}, State: "gone"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{Local: &LocalResource{HostEntry: tt.he}}
+			err := pr.Validate(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHostEntryResourceCheckAndEnforce(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	var tests = []struct {
+		name string
+		he   HostEntryResource
+		want string
+	}{
+		{
+			"PresentNew",
+			HostEntryResource{IP: "10.0.0.7", Hostnames: []string{"cache"}},
+			testHosts + "10.0.0.7\tcache\n",
+		},
+		{
+			"PresentMovesHostname",
+			HostEntryResource{IP: "10.0.0.10", Hostnames: []string{"api"}},
+			`127.0.0.1	localhost
+# The following lines are desirable for IPv6 capable hosts
+::1	localhost ip6-localhost
+10.0.0.5	db db.internal # managed elsewhere
+10.0.0.9	old-api
+10.0.0.10	api
+`,
+		},
+		{
+			"AbsentKeepsComment",
+			HostEntryResource{IP: "10.0.0.5", Hostnames: []string{"db.internal"}, State: StateAbsent},
+			`127.0.0.1	localhost
+# The following lines are desirable for IPv6 capable hosts
+::1	localhost ip6-localhost
+10.0.0.5	db # managed elsewhere
+10.0.0.9	old-api api
+`,
+		},
+		{
+			"AbsentRemovesLine",
+			HostEntryResource{IP: "10.0.0.9", Hostnames: []string{"old-api", "api"}, State: StateAbsent},
+			`127.0.0.1	localhost
+# The following lines are desirable for IPv6 capable hosts
+::1	localhost ip6-localhost
+10.0.0.5	db db.internal # managed elsewhere
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hosts")
+			if err := os.WriteFile(path, []byte(testHosts), 0644); err != nil {
+				t.Fatal(err)
+			}
+			tt.he.Path = path
+			pr := &OSPolicyResource{Local: &LocalResource{HostEntry: &tt.he}}
+			if err := pr.Validate(ctx); err != nil {
+				t.Fatalf("Unexpected Validate error: %v", err)
+			}
+
+			if err := pr.CheckState(ctx); err != nil {
+				t.Fatalf("Unexpected CheckState error: %v", err)
+			}
+			if pr.InDesiredState() {
+				t.Fatal("Unexpected InDesiredState before enforce")
+			}
+
+			if err := pr.EnforceState(ctx); err != nil {
+				t.Fatalf("Unexpected EnforceState error: %v", err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Unexpected hosts file, got:\n%s\nwant:\n%s", got, tt.want)
+			}
+
+			if err := pr.CheckState(ctx); err != nil {
+				t.Fatalf("Unexpected CheckState error: %v", err)
+			}
+			if !pr.InDesiredState() {
+				t.Error("Unexpected InDesiredState after enforce")
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// LocalResource holds OSPolicy resource types that are implemented by the
+// agent but are not part of the OS Config API, these can only be set by
+// local policies. Exactly one field should be set.
+type LocalResource struct {
+	HostEntry           *HostEntryResource           `json:"hostEntry,omitempty"`
+	DNSResolver         *DNSResolverResource         `json:"dnsResolver,omitempty"`
+	TimeSync            *TimeSyncResource            `json:"timeSync,omitempty"`
+	AuthorizedKey       *AuthorizedKeyResource       `json:"authorizedKey,omitempty"`
+	SSHDConfig          *SSHDConfigResource          `json:"sshdConfig,omitempty"`
+	Mount               *MountResource               `json:"mount,omitempty"`
+	FirewallRule        *FirewallRuleResource        `json:"firewallRule,omitempty"`
+	Timezone            *TimezoneResource            `json:"timezone,omitempty"`
+	Locale              *LocaleResource              `json:"locale,omitempty"`
+	EnvironmentVariable *EnvironmentVariableResource `json:"environmentVariable,omitempty"`
+	ScheduledTask       *ScheduledTaskResource       `json:"scheduledTask,omitempty"`
+	Cron                *CronResource                `json:"cron,omitempty"`
+	SecurityPolicy      *SecurityPolicyResource      `json:"securityPolicy,omitempty"`
+	DSC                 *DSCResource                 `json:"dsc,omitempty"`
+	Ansible             *AnsibleResource             `json:"ansible,omitempty"`
+	PackageSet          *PackageSetResource          `json:"packageSet,omitempty"`
+}
+
+func (l *LocalResource) resource() (resource, error) {
+	switch {
+	case l.HostEntry != nil:
+		return &hostEntryResource{HostEntryResource: l.HostEntry}, nil
+	case l.DNSResolver != nil:
+		return &dnsResolverResource{DNSResolverResource: l.DNSResolver}, nil
+	case l.TimeSync != nil:
+		return &timeSyncResource{TimeSyncResource: l.TimeSync}, nil
+	case l.AuthorizedKey != nil:
+		return &authorizedKeyResource{AuthorizedKeyResource: l.AuthorizedKey}, nil
+	case l.SSHDConfig != nil:
+		return &sshdConfigResource{SSHDConfigResource: l.SSHDConfig}, nil
+	case l.Mount != nil:
+		return &mountResource{MountResource: l.Mount}, nil
+	case l.FirewallRule != nil:
+		return &firewallRuleResource{FirewallRuleResource: l.FirewallRule}, nil
+	case l.Timezone != nil:
+		return &timezoneResource{TimezoneResource: l.Timezone}, nil
+	case l.Locale != nil:
+		return &localeResource{LocaleResource: l.Locale}, nil
+	case l.EnvironmentVariable != nil:
+		return &environmentVariableResource{EnvironmentVariableResource: l.EnvironmentVariable}, nil
+	case l.ScheduledTask != nil:
+		return &scheduledTaskResource{ScheduledTaskResource: l.ScheduledTask}, nil
+	case l.Cron != nil:
+		return &cronResource{CronResource: l.Cron}, nil
+	case l.SecurityPolicy != nil:
+		return &securityPolicyResource{SecurityPolicyResource: l.SecurityPolicy}, nil
+	case l.DSC != nil:
+		return &dscResource{DSCResource: l.DSC}, nil
+	case l.Ansible != nil:
+		return &ansibleResource{AnsibleResource: l.Ansible}, nil
+	case l.PackageSet != nil:
+		return &packageSetResource{PackageSetResource: l.PackageSet}, nil
+	default:
+		return nil, errors.New("LocalResource has no resource type set")
+	}
+}
+
+// typeName returns the JSON name of the resource type set, like hostEntry.
+func (l *LocalResource) typeName() string {
+	v := reflect.ValueOf(l).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Field(i).IsNil() {
+			return strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		}
+	}
+	return ""
+}
+
+// State is the desired state of a local resource.
+type State string
+
+const (
+	// StatePresent ensures the resource is present, this is the default.
+	StatePresent State = "present"
+	// StateAbsent ensures the resource is absent.
+	StateAbsent State = "absent"
+)
+
+func (s State) validate() (State, error) {
+	switch strings.ToLower(string(s)) {
+	case "", string(StatePresent):
+		return StatePresent, nil
+	case string(StateAbsent):
+		return StateAbsent, nil
+	default:
+		return "", fmt.Errorf("unrecognized State %q, must be %q or %q", s, StatePresent, StateAbsent)
+	}
+}
+
+// readLines reads a text file as lines, a missing file has no lines.
+func readLines(path string) ([]string, os.FileMode, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, 0644, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return splitLines(b), fi.Mode().Perm(), nil
+}
+
+// splitLines splits the content of a text file into lines.
+func splitLines(b []byte) []string {
+	s := strings.ReplaceAll(string(b), "\r\n", "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// joinLines is the content of a text file with lines.
+func joinLines(lines []string) []byte {
+	nl := "\n"
+	if goos == "windows" {
+		nl = "\r\n"
+	}
+	content := strings.Join(lines, nl)
+	if len(lines) > 0 {
+		content += nl
+	}
+	return []byte(content)
+}
+
+// writeLines atomically replaces a text file with lines.
+func writeLines(path string, lines []string, mode os.FileMode) error {
+	return util.AtomicWrite(path, joinLines(lines), mode)
+}
+
+func blockMarkers(name string) (string, string) {
+	return "# BEGIN google-osconfig-agent " + name, "# END google-osconfig-agent " + name
+}
+
+// managedBlock returns the lines between the markers for name, and whether
+// the block exists.
+func managedBlock(lines []string, name string) ([]string, bool) {
+	begin, end := blockMarkers(name)
+	var block []string
+	var in, found bool
+	for _, ln := range lines {
+		switch {
+		case ln == begin:
+			in, found = true, true
+		case ln == end:
+			in = false
+		case in:
+			block = append(block, ln)
+		}
+	}
+	return block, found
+}
+
+// replaceManagedBlock replaces the lines between the markers for name with
+// block, appending the block if there are no markers yet. A nil block
+// removes the markers too.
+func replaceManagedBlock(lines []string, name string, block []string) []string {
+	begin, end := blockMarkers(name)
+	var out []string
+	var in, written bool
+	for _, ln := range lines {
+		switch {
+		case ln == begin:
+			in = true
+			if block != nil && !written {
+				out = append(out, begin)
+				out = append(out, block...)
+				out = append(out, end)
+				written = true
+			}
+		case in:
+			if ln == end {
+				in = false
+			}
+		default:
+			out = append(out, ln)
+		}
+	}
+	if block != nil && !written {
+		out = append(out, begin)
+		out = append(out, block...)
+		out = append(out, end)
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+var (
+	// managedRoots are the experimental managed roots repository packages
+	// are enforced in besides the host, see agentconfig.ManagedRoots.
+	managedRoots = func(ctx context.Context) []packages.ManagedRoot {
+		var roots []packages.ManagedRoot
+		for _, spec := range agentconfig.ManagedRoots() {
+			root, err := packages.ParseManagedRoot(spec)
+			if err != nil {
+				clog.Warningf(ctx, "Skipping managed root: %v", err)
+				continue
+			}
+			roots = append(roots, root)
+		}
+		return roots
+	}
+
+	installedPackagesInRoot = packages.GetInstalledPackagesInRoot
+	installPackagesInRoot   = packages.InstallPackagesInRoot
+	removePackagesInRoot    = packages.RemovePackagesInRoot
+)
+
+// rootPackage returns the package manager, name and desired state of p if it
+// is enforced in managed roots, only apt, yum and zypper packages without a
+// pinned version are.
+func (p *packageResouce) rootPackage() (string, string, agentendpointpb.OSPolicy_Resource_PackageResource_DesiredState, bool) {
+	if p.pin != nil {
+		return "", "", 0, false
+	}
+	switch {
+	case p.managedPackage.Apt != nil:
+		return "apt", p.managedPackage.Apt.PackageResource.GetName(), p.managedPackage.Apt.DesiredState, true
+	case p.managedPackage.Yum != nil:
+		return "yum", p.managedPackage.Yum.PackageResource.GetName(), p.managedPackage.Yum.DesiredState, true
+	case p.managedPackage.Zypper != nil:
+		return "zypper", p.managedPackage.Zypper.PackageResource.GetName(), p.managedPackage.Zypper.DesiredState, true
+	}
+	return "", "", 0, false
+}
+
+// rootsNotInDesiredState returns the managed roots using the package manager
+// of p where its package is not in the desired state.
+func (p *packageResouce) rootsNotInDesiredState(ctx context.Context) ([]packages.ManagedRoot, error) {
+	manager, name, desiredState, ok := p.rootPackage()
+	if !ok {
+		return nil, nil
+	}
+	var roots []packages.ManagedRoot
+	for _, root := range managedRoots(ctx) {
+		if root.PackageManager() != manager {
+			continue
+		}
+		pkgs, err := installedPackagesInRoot(ctx, root)
+		if err != nil {
+			return nil, err
+		}
+		installed := pkgs.Rpm
+		if manager == "apt" {
+			installed = pkgs.Deb
+		}
+		var pkgIns bool
+		for _, pkg := range installed {
+			if pkg.Name == name {
+				pkgIns = true
+				break
+			}
+		}
+		if pkgIns != (desiredState == agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED) {
+			roots = append(roots, root)
+		}
+	}
+	return roots, nil
+}
+
+// enforceInRoots brings the package of p to its desired state in roots.
+func (p *packageResouce) enforceInRoots(ctx context.Context, roots []packages.ManagedRoot) error {
+	manager, name, desiredState, _ := p.rootPackage()
+	for _, root := range roots {
+		action, f := "installing", installPackagesInRoot
+		if desiredState == agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED {
+			action, f = "removing", removePackagesInRoot
+		}
+		clog.Infof(ctx, "%s %s package %q in managed root %q", strings.Title(action), manager, name, root.Name)
+		if err := f(ctx, root, []string{name}); err != nil {
+			return fmt.Errorf("error %s %s package %q in managed root %q: %v", action, manager, name, root.Name, err)
+		}
+	}
+	return nil
+}
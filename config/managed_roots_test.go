@@ -0,0 +1,109 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+// newManagedRoot returns a managed root with the package manager at cmd.
+func newManagedRoot(t *testing.T, name, cmd string) packages.ManagedRoot {
+	root := packages.ManagedRoot{Name: name, Path: t.TempDir()}
+	if err := os.MkdirAll(filepath.Join(root.Path, filepath.Dir(cmd)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root.Path, cmd), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestPackageResourceManagedRoots(t *testing.T) {
+	ctx := context.Background()
+	packages.SetCommandRunner(&fakeCommandRunner{})
+
+	// Only yum roots are checked for a yum package, foo is missing in b.
+	roots := []packages.ManagedRoot{
+		newManagedRoot(t, "a", "/usr/bin/yum"),
+		newManagedRoot(t, "b", "/usr/bin/yum"),
+		newManagedRoot(t, "c", "/usr/bin/apt-get"),
+	}
+	installed := map[string]*packages.Packages{
+		"a": {Rpm: []*packages.PkgInfo{{Name: "foo"}}},
+		"b": {Rpm: []*packages.PkgInfo{{Name: "bar"}}},
+	}
+	var enforced []string
+	defer func(f func(context.Context) []packages.ManagedRoot) { managedRoots = f }(managedRoots)
+	managedRoots = func(context.Context) []packages.ManagedRoot { return roots }
+	defer func(f func(context.Context, packages.ManagedRoot) (*packages.Packages, error)) {
+		installedPackagesInRoot = f
+	}(installedPackagesInRoot)
+	installedPackagesInRoot = func(_ context.Context, root packages.ManagedRoot) (*packages.Packages, error) {
+		if root.Name == "c" {
+			t.Errorf("packages of apt root %q were checked for a yum package", root.Name)
+		}
+		return installed[root.Name], nil
+	}
+	defer func(f func(context.Context, packages.ManagedRoot, []string) error) { installPackagesInRoot = f }(installPackagesInRoot)
+	installPackagesInRoot = func(_ context.Context, root packages.ManagedRoot, pkgs []string) error {
+		enforced = append(enforced, root.Name+": "+pkgs[0])
+		return nil
+	}
+
+	pr := &OSPolicyResource{
+		OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+			ResourceType: &agentendpointpb.OSPolicy_Resource_Pkg{Pkg: yumInstalledPR},
+		},
+	}
+	defer pr.Cleanup(ctx)
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+
+	// foo is installed on the host but not in every managed root.
+	yumInstalled.cache = map[string]struct{}{"foo": {}}
+	yumInstalled.refreshed = time.Now()
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if pr.InDesiredState() {
+		t.Errorf("InDesiredState() = true with foo missing in managed root b")
+	}
+
+	if err := pr.EnforceState(ctx); err != nil {
+		t.Fatalf("Unexpected EnforceState error: %v", err)
+	}
+	if want := []string{"b: foo"}; !reflect.DeepEqual(enforced, want) {
+		t.Errorf("enforced in managed roots %q, want %q", enforced, want)
+	}
+
+	installed["b"] = installed["a"]
+	yumInstalled.cache = map[string]struct{}{"foo": {}}
+	yumInstalled.refreshed = time.Now()
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if !pr.InDesiredState() {
+		t.Errorf("InDesiredState() = false with foo installed on the host and in all managed roots")
+	}
+}
@@ -0,0 +1,272 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+var (
+	fstab      = "/etc/fstab"
+	procMounts = "/proc/self/mounts"
+	mount      = "/bin/mount"
+	umount     = "/bin/umount"
+)
+
+// MountResource ensures a filesystem has an fstab entry and is mounted.
+type MountResource struct {
+	// Device is the fstab spec, e.g. /dev/sdb, UUID=... or host:/export.
+	Device     string   `json:"device"`
+	MountPoint string   `json:"mountPoint"`
+	FSType     string   `json:"fsType"`
+	Options    []string `json:"options,omitempty"`
+	Dump       int      `json:"dump,omitempty"`
+	Pass       int      `json:"pass,omitempty"`
+	State      State    `json:"state,omitempty"`
+}
+
+type mountResource struct {
+	*MountResource
+
+	state State
+
+	entryMatches bool
+	mounted      *mountInfo
+}
+
+type mountInfo struct {
+	device, fsType string
+	options        []string
+}
+
+// userspaceMountOptions are only used by mount(8) and never show up in the
+// kernel mount table, these are ignored when checking for option drift.
+var userspaceMountOptions = map[string]bool{
+	"defaults": true, "auto": true, "noauto": true, "nofail": true, "_netdev": true,
+	"user": true, "nouser": true, "users": true, "owner": true, "group": true,
+}
+
+func (m *mountResource) validate(ctx context.Context) (*ManagedResources, error) {
+	if goos == "windows" {
+		return nil, errors.New("MountResource is not supported on Windows")
+	}
+	var err error
+	if m.state, err = m.State.validate(); err != nil {
+		return nil, fmt.Errorf("MountResource: %v", err)
+	}
+	if !path.IsAbs(m.MountPoint) || m.MountPoint == "/" || path.Clean(m.MountPoint) != m.MountPoint {
+		return nil, fmt.Errorf("MountResource: invalid MountPoint %q", m.MountPoint)
+	}
+	if strings.ContainsAny(m.MountPoint, " \t") {
+		return nil, fmt.Errorf("MountResource: MountPoint %q can not contain whitespace", m.MountPoint)
+	}
+	if m.state == StateAbsent {
+		return nil, nil
+	}
+	if m.Device == "" || strings.ContainsAny(m.Device, " \t") {
+		return nil, fmt.Errorf("MountResource: invalid Device %q", m.Device)
+	}
+	if m.FSType == "" || strings.ContainsAny(m.FSType, " \t") {
+		return nil, fmt.Errorf("MountResource: invalid FSType %q", m.FSType)
+	}
+	for _, o := range m.Options {
+		if o == "" || strings.ContainsAny(o, " \t,") {
+			return nil, fmt.Errorf("MountResource: invalid option %q", o)
+		}
+	}
+	return nil, nil
+}
+
+func (m *mountResource) options() string {
+	if len(m.Options) == 0 {
+		return "defaults"
+	}
+	return strings.Join(m.Options, ",")
+}
+
+func (m *mountResource) entry() string {
+	return strings.Join([]string{m.Device, m.MountPoint, m.FSType, m.options(), strconv.Itoa(m.Dump), strconv.Itoa(m.Pass)}, "\t")
+}
+
+// unescapeMountField decodes the octal escapes used for whitespace in fstab
+// and the kernel mount table.
+func unescapeMountField(s string) string {
+	r := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+	return r.Replace(s)
+}
+
+// updateFstab returns the fstab lines with the entry for the mount point
+// added, replaced or removed.
+func (m *mountResource) updateFstab(lines []string) []string {
+	var out []string
+	var written bool
+	for _, ln := range lines {
+		f := strings.Fields(ln)
+		if len(f) < 2 || strings.HasPrefix(f[0], "#") || path.Clean(unescapeMountField(f[1])) != m.MountPoint {
+			out = append(out, ln)
+			continue
+		}
+		if m.state == StatePresent && !written {
+			out = append(out, m.entry())
+			written = true
+		}
+	}
+	if m.state == StatePresent && !written {
+		out = append(out, m.entry())
+	}
+	return out
+}
+
+// fstabEntryMatches reports whether the fstab has exactly the desired entry
+// for the mount point, ignoring whitespace.
+func (m *mountResource) fstabEntryMatches(lines []string) bool {
+	want := strings.Fields(m.entry())
+	var found bool
+	for _, ln := range lines {
+		f := strings.Fields(ln)
+		if len(f) < 2 || strings.HasPrefix(f[0], "#") || path.Clean(unescapeMountField(f[1])) != m.MountPoint {
+			continue
+		}
+		// Dump and pass default to 0 when omitted.
+		for len(f) < 6 {
+			f = append(f, "0")
+		}
+		if m.state == StateAbsent || found || !linesEqual(f, want) {
+			return false
+		}
+		found = true
+	}
+	return found == (m.state == StatePresent)
+}
+
+// parseProcMounts returns the last mount on mountPoint, or nil if nothing is
+// mounted there.
+func parseProcMounts(data []byte, mountPoint string) *mountInfo {
+	var mi *mountInfo
+	for _, ln := range strings.Split(string(data), "\n") {
+		f := strings.Fields(ln)
+		if len(f) < 4 || unescapeMountField(f[1]) != mountPoint {
+			continue
+		}
+		mi = &mountInfo{device: unescapeMountField(f[0]), fsType: f[2], options: strings.Split(f[3], ",")}
+	}
+	return mi
+}
+
+// optionsMatch reports whether all kernel mount options in want are set on
+// the mount. Options are compared as written, so values must be given as
+// the kernel reports them, e.g. vers=4.2 rather than vers=4 for NFS.
+func optionsMatch(have, want []string) bool {
+	set := make(map[string]bool, len(have))
+	for _, o := range have {
+		set[o] = true
+	}
+	for _, o := range want {
+		if userspaceMountOptions[o] || strings.HasPrefix(o, "x-") || strings.HasPrefix(o, "comment=") {
+			continue
+		}
+		if !set[o] {
+			return false
+		}
+	}
+	return true
+}
+
+// fsTypeMatches reports whether the mounted filesystem type is the desired
+// one, an nfs entry is reported as nfs4 when mounted with NFSv4.
+func (m *mountResource) fsTypeMatches() bool {
+	return m.mounted.fsType == m.FSType || (m.FSType == "nfs" && m.mounted.fsType == "nfs4")
+}
+
+func (m *mountResource) mountMatches() bool {
+	if m.state == StateAbsent {
+		return m.mounted == nil
+	}
+	return m.mounted != nil && m.fsTypeMatches() && optionsMatch(m.mounted.options, m.Options)
+}
+
+func (m *mountResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	lines, _, err := readLines(fstab)
+	if err != nil {
+		return false, err
+	}
+	m.entryMatches = m.fstabEntryMatches(lines)
+
+	data, err := os.ReadFile(procMounts)
+	if err != nil {
+		return false, err
+	}
+	m.mounted = parseProcMounts(data, m.MountPoint)
+
+	return m.entryMatches && m.mountMatches(), nil
+}
+
+func (m *mountResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Enforcing state %q for mount %q.", m.state, m.MountPoint)
+	if !m.entryMatches {
+		lines, mode, err := readLines(fstab)
+		if err != nil {
+			return false, err
+		}
+		if err := writeLines(fstab, m.updateFstab(lines), mode); err != nil {
+			return false, fmt.Errorf("error writing %q: %v", fstab, err)
+		}
+	}
+	if m.mountMatches() {
+		return true, nil
+	}
+
+	switch {
+	case m.state == StateAbsent:
+		if _, err := runCmd(ctx, umount, m.MountPoint); err != nil {
+			return false, err
+		}
+	case m.mounted != nil && m.fsTypeMatches():
+		// Only the options drifted, these can be changed in place.
+		if _, err := runCmd(ctx, mount, "-o", "remount,"+m.options(), m.MountPoint); err != nil {
+			return false, err
+		}
+	default:
+		if m.mounted != nil {
+			if _, err := runCmd(ctx, umount, m.MountPoint); err != nil {
+				return false, err
+			}
+		}
+		if err := os.MkdirAll(m.MountPoint, 0755); err != nil {
+			return false, err
+		}
+		// Mount using the fstab entry.
+		if _, err := runCmd(ctx, mount, m.MountPoint); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (m *mountResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {}
+
+func (m *mountResource) cleanup(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,177 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestMountResourceValidate(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+
+	var tests = []struct {
+		name    string
+		mr      *MountResource
+		wantErr bool
+	}{
+		{"Disk", &MountResource{Device: "UUID=1234", MountPoint: "/mnt/data", FSType: "ext4", Options: []string{"discard", "nofail"}}, false},
+		{"NFS", &MountResource{Device: "10.0.0.2:/share", MountPoint: "/mnt/share", FSType: "nfs"}, false},
+		{"Absent", &MountResource{MountPoint: "/mnt/data", State: "absent"}, false},
+		{"RelativeMountPoint", &MountResource{Device: "/dev/sdb", MountPoint: "mnt/data", FSType: "ext4"}, true},
+		{"RootMountPoint", &MountResource{Device: "/dev/sdb", MountPoint: "/", FSType: "ext4"}, true},
+		{"UncleanMountPoint", &MountResource{Device: "/dev/sdb", MountPoint: "/mnt/data/", FSType: "ext4"}, true},
+		{"NoDevice", &MountResource{MountPoint: "/mnt/data", FSType: "ext4"}, true},
+		{"NoFSType", &MountResource{Device: "/dev/sdb", MountPoint: "/mnt/data"}, true},
+		{"BadOption", &MountResource{Device: "/dev/sdb", MountPoint: "/mnt/data", FSType: "ext4", Options: []string{"rw,noatime"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{Local: &LocalResource{Mount: tt.mr}}
+			err := pr.Validate(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMountResourceFstab(t *testing.T) {
+	m := &mountResource{MountResource: &MountResource{Device: "/dev/sdb", MountPoint: "/mnt/data", FSType: "ext4", Options: []string{"discard"}}, state: StatePresent}
+	in := []string{
+		"# /mnt/data was on /dev/sdc",
+		"UUID=abcd / ext4 defaults 1 1",
+		"/dev/sdc /mnt/data/ xfs defaults",
+	}
+	want := []string{
+		"# /mnt/data was on /dev/sdc",
+		"UUID=abcd / ext4 defaults 1 1",
+		"/dev/sdb\t/mnt/data\text4\tdiscard\t0\t0",
+	}
+	if m.fstabEntryMatches(in) {
+		t.Error("fstabEntryMatches() = true for a different entry")
+	}
+	got := m.updateFstab(in)
+	if !linesEqual(got, want) {
+		t.Errorf("updateFstab() =\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if !m.fstabEntryMatches(got) {
+		t.Error("fstabEntryMatches() = false after updateFstab()")
+	}
+
+	m.state = StateAbsent
+	got = m.updateFstab(got)
+	if !linesEqual(got, in[:2]) {
+		t.Errorf("updateFstab() with absent state =\n%s", strings.Join(got, "\n"))
+	}
+	if !m.fstabEntryMatches(got) {
+		t.Error("fstabEntryMatches() = false after removing the entry")
+	}
+}
+
+func TestOptionsMatch(t *testing.T) {
+	have := strings.Split("rw,relatime,discard", ",")
+	var tests = []struct {
+		want []string
+		ok   bool
+	}{
+		{nil, true},
+		{[]string{"defaults", "nofail", "x-systemd.automount"}, true},
+		{[]string{"discard"}, true},
+		{[]string{"noatime"}, false},
+		{[]string{"ro"}, false},
+	}
+	for _, tt := range tests {
+		if got := optionsMatch(have, tt.want); got != tt.ok {
+			t.Errorf("optionsMatch(%q, %q) = %t, want %t", have, tt.want, got, tt.ok)
+		}
+	}
+}
+
+func TestMountResource(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	dir := t.TempDir()
+	defer func(f, p string) { fstab, procMounts = f, p }(fstab, procMounts)
+	fstab = filepath.Join(dir, "fstab")
+	procMounts = filepath.Join(dir, "mounts")
+	mountPoint := filepath.Join(dir, "data")
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = mockCommandRunner
+
+	pr := &OSPolicyResource{Local: &LocalResource{Mount: &MountResource{Device: "/dev/sdb", MountPoint: mountPoint, FSType: "ext4", Options: []string{"noatime"}}}}
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+
+	var tests = []struct {
+		name   string
+		mounts string
+		cmd    *exec.Cmd
+	}{
+		{"NotMounted", "/dev/sda1 / ext4 rw,relatime 0 0\n", exec.Command(mount, mountPoint)},
+		{"OptionDrift", "/dev/sdb " + mountPoint + " ext4 rw,relatime 0 0\n", exec.Command(mount, "-o", "remount,noatime", mountPoint)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(procMounts, []byte(tt.mounts), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := pr.CheckState(ctx); err != nil {
+				t.Fatalf("Unexpected CheckState error: %v", err)
+			}
+			if pr.InDesiredState() {
+				t.Fatal("Unexpected InDesiredState before enforce")
+			}
+			mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(tt.cmd)).Return(nil, nil, nil)
+			if err := pr.EnforceState(ctx); err != nil {
+				t.Fatalf("Unexpected EnforceState error: %v", err)
+			}
+		})
+	}
+
+	got, err := os.ReadFile(fstab)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/dev/sdb\t" + mountPoint + "\text4\tnoatime\t0\t0\n"; string(got) != want {
+		t.Errorf("Unexpected fstab, got %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(procMounts, []byte("/dev/sdb "+mountPoint+" ext4 rw,noatime 0 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if !pr.InDesiredState() {
+		t.Error("Expected InDesiredState when mounted with the desired options")
+	}
+}
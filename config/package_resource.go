@@ -18,6 +18,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -28,6 +29,7 @@ import (
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/privhelper"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 
 	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
@@ -45,6 +47,100 @@ type packageResouce struct {
 	*agentendpointpb.OSPolicy_Resource_PackageResource
 
 	managedPackage ManagedPackage
+	options        *PackageOptions
+	// pin is the version pinned in an apt, yum or zypper package name.
+	pin *packagePin
+}
+
+// PackageOptions sets how an apt, yum or zypper package resource is
+// enforced, only local policies can set them.
+type PackageOptions struct {
+	// AllowDowngrade permits downgrading a newer installed version to the
+	// version pinned as name=version.
+	AllowDowngrade bool `json:"allowDowngrade,omitempty"`
+}
+
+// packagePin is a version pinned in the name of an apt, yum or zypper
+// package resource as name=version, the API has no version field. Without
+// allowDowngrade a newer installed version is reported and left in place.
+type packagePin struct {
+	name, version  string
+	allowDowngrade bool
+
+	// spec is the package as passed to the package manager to install the
+	// version, manager is the privileged helper package manager.
+	spec      string
+	manager   string
+	compare   func(a, b string) int
+	downgrade func(context.Context, []string) error
+}
+
+// parsePackagePin parses the name of an apt, yum or zypper package, a nil
+// pin is returned for names without a version.
+func parsePackagePin(manager, s string) (string, *packagePin, error) {
+	name, version, ok := strings.Cut(s, "=")
+	if !ok {
+		return s, nil, packages.ValidatePackageName(s)
+	}
+	if err := packages.ValidatePackageName(name); err != nil {
+		return "", nil, err
+	}
+	if err := packages.ValidatePackageVersion(version); err != nil {
+		return "", nil, fmt.Errorf("package %q: %v", name, err)
+	}
+	pin := &packagePin{name: name, version: version, manager: manager, spec: name + "=" + version, compare: packages.CompareRPMVersions}
+	switch manager {
+	case privhelper.Apt:
+		pin.compare, pin.downgrade = packages.CompareDebVersions, packages.DowngradeAptPackages
+	case privhelper.Yum:
+		pin.spec, pin.downgrade = name+"-"+version, packages.DowngradeYumPackages
+	case privhelper.Zypper:
+		pin.downgrade = packages.InstallZypperPackageVersions
+	}
+	return name, pin, nil
+}
+
+// validatePin validates the name of an apt, yum or zypper package and the
+// version it pins, which can only be installed.
+func (p *packageResouce) validatePin(manager, name string) error {
+	_, pin, err := parsePackagePin(manager, name)
+	if err != nil {
+		return err
+	}
+	if pin == nil {
+		return nil
+	}
+	if p.GetDesiredState() != agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED {
+		return fmt.Errorf("package %q: a version can only be pinned for installed packages", name)
+	}
+	pin.allowDowngrade = p.options != nil && p.options.AllowDowngrade
+	p.pin = pin
+	return nil
+}
+
+// pinnedName is the name of the package without a pinned version.
+func (p *packageResouce) pinnedName(name string) string {
+	if p.pin != nil {
+		return p.pin.name
+	}
+	return name
+}
+
+// installedPinned reports whether only the pinned version is installed, and
+// the newest installed version if it is newer than the pinned one.
+func (p *packageResouce) installedPinned(cache *packageCache) (bool, string) {
+	var newer string
+	pinned := len(cache.versions[p.pin.name]) > 0
+	for _, v := range cache.versions[p.pin.name] {
+		c := p.pin.compare(v, p.pin.version)
+		if c != 0 {
+			pinned = false
+		}
+		if c > 0 && (newer == "" || p.pin.compare(v, newer) > 0) {
+			newer = v
+		}
+	}
+	return pinned, newer
 }
 
 // AptPackage describes an apt package resource.
@@ -201,6 +297,10 @@ func (p *packageResouce) validate(ctx context.Context) (*ManagedResources, error
 			return nil, fmt.Errorf("cannot manage Apt package %q because apt-get does not exist on the system", pr.GetName())
 		}
 
+		if err := p.validatePin(privhelper.Apt, pr.GetName()); err != nil {
+			return nil, err
+		}
+
 		p.managedPackage.Apt = &AptPackage{DesiredState: p.GetDesiredState(), PackageResource: pr}
 
 	case *agentendpointpb.OSPolicy_Resource_PackageResource_Deb_:
@@ -279,6 +379,10 @@ func (p *packageResouce) validate(ctx context.Context) (*ManagedResources, error
 			return nil, fmt.Errorf("cannot manage Yum package %q because yum does not exist on the system", pr.GetName())
 		}
 
+		if err := p.validatePin(privhelper.Yum, pr.GetName()); err != nil {
+			return nil, err
+		}
+
 		p.managedPackage.Yum = &YumPackage{DesiredState: p.GetDesiredState(), PackageResource: pr}
 
 	case *agentendpointpb.OSPolicy_Resource_PackageResource_Zypper_:
@@ -287,6 +391,10 @@ func (p *packageResouce) validate(ctx context.Context) (*ManagedResources, error
 			return nil, fmt.Errorf("cannot manage Zypper package %q because zypper does not exist on the system", pr.GetName())
 		}
 
+		if err := p.validatePin(privhelper.Zypper, pr.GetName()); err != nil {
+			return nil, err
+		}
+
 		p.managedPackage.Zypper = &ZypperPackage{DesiredState: p.GetDesiredState(), PackageResource: pr}
 
 	case *agentendpointpb.OSPolicy_Resource_PackageResource_Rpm:
@@ -323,11 +431,18 @@ func (p *packageResouce) validate(ctx context.Context) (*ManagedResources, error
 		return nil, fmt.Errorf("SystemPackage field not set or references unknown package manager: %v", p.GetSystemPackage())
 	}
 
+	if p.options != nil && p.options.AllowDowngrade && p.pin == nil {
+		return nil, errors.New("allowDowngrade requires a pinned apt, yum or zypper version, name=version")
+	}
+
 	return &ManagedResources{Packages: []ManagedPackage{p.managedPackage}}, nil
 }
 
 type packageCache struct {
-	cache     map[string]struct{}
+	cache map[string]struct{}
+	// versions are the installed versions of each package, more than one
+	// for a package installed for several architectures.
+	versions  map[string][]string
 	refreshed time.Time
 }
 
@@ -389,8 +504,10 @@ func populateInstalledCache(ctx context.Context, mp ManagedPackage) error {
 	}
 
 	cache.cache = map[string]struct{}{}
+	cache.versions = map[string][]string{}
 	for _, pkg := range pis {
 		cache.cache[pkg.Name] = struct{}{}
+		cache.versions[pkg.Name] = append(cache.versions[pkg.Name], pkg.Version)
 	}
 	cache.refreshed = time.Now()
 
@@ -405,7 +522,7 @@ func (p *packageResouce) download(ctx context.Context, name string, file *agente
 	case file.GetLocalPath() != "":
 		path = file.GetLocalPath()
 	default:
-		tmpDir, err := ioutil.TempDir("", "osconfig_package_resource_")
+		tmpDir, err := ioutil.TempDir(downloadDir(), "osconfig_package_resource_")
 		if err != nil {
 			return "", fmt.Errorf("failed to create temp dir: %s", err)
 		}
@@ -430,7 +547,10 @@ func (p *packageResouce) checkState(ctx context.Context) (inDesiredState bool, e
 	switch {
 	case p.managedPackage.Apt != nil:
 		desiredState = p.managedPackage.Apt.DesiredState
-		_, pkgIns = aptInstalled.cache[p.managedPackage.Apt.PackageResource.GetName()]
+		_, pkgIns = aptInstalled.cache[p.pinnedName(p.managedPackage.Apt.PackageResource.GetName())]
+		if p.pin != nil {
+			pkgIns, _ = p.installedPinned(aptInstalled)
+		}
 
 	case p.managedPackage.Deb != nil:
 		desiredState = agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED
@@ -449,11 +569,17 @@ func (p *packageResouce) checkState(ctx context.Context) (inDesiredState bool, e
 
 	case p.managedPackage.Yum != nil:
 		desiredState = p.managedPackage.Yum.DesiredState
-		_, pkgIns = yumInstalled.cache[p.managedPackage.Yum.PackageResource.GetName()]
+		_, pkgIns = yumInstalled.cache[p.pinnedName(p.managedPackage.Yum.PackageResource.GetName())]
+		if p.pin != nil {
+			pkgIns, _ = p.installedPinned(yumInstalled)
+		}
 
 	case p.managedPackage.Zypper != nil:
 		desiredState = p.managedPackage.Zypper.DesiredState
-		_, pkgIns = zypperInstalled.cache[p.managedPackage.Zypper.PackageResource.GetName()]
+		_, pkgIns = zypperInstalled.cache[p.pinnedName(p.managedPackage.Zypper.PackageResource.GetName())]
+		if p.pin != nil {
+			pkgIns, _ = p.installedPinned(zypperInstalled)
+		}
 
 	case p.managedPackage.RPM != nil:
 		desiredState = agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED
@@ -462,24 +588,30 @@ func (p *packageResouce) checkState(ctx context.Context) (inDesiredState bool, e
 
 	switch desiredState {
 	case agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED:
-		if pkgIns {
-			return true, nil
+		if !pkgIns {
+			return false, nil
 		}
 	case agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED:
-		if !pkgIns {
-			return true, nil
+		if pkgIns {
+			return false, nil
 		}
 	default:
 		return false, fmt.Errorf("DesiredState field not set or references state: %q", desiredState)
 	}
 
-	return false, nil
+	// The package must also be in its desired state in the managed roots.
+	roots, err := p.rootsNotInDesiredState(ctx)
+	if err != nil {
+		return false, err
+	}
+	return len(roots) == 0, nil
 }
 
 func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool, err error) {
 	var (
-		installing = "installing"
-		removing   = "removing"
+		installing  = "installing"
+		downgrading = "downgrading"
+		removing    = "removing"
 
 		enforcePackage struct {
 			actionFunc     func() error
@@ -498,13 +630,12 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 		switch p.managedPackage.Apt.DesiredState {
 		case agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED:
 			enforcePackage.action, enforcePackage.actionFunc = installing, func() error {
-				if _, err := packages.AptUpdate(ctx); err != nil {
-					return err
-				}
-				return packages.InstallAptPackages(ctx, []string{enforcePackage.name})
+				return installPackages(ctx, privhelper.Apt, []string{enforcePackage.name}, true, packages.InstallAptPackages)
 			}
 		case agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED:
-			enforcePackage.action, enforcePackage.actionFunc = removing, func() error { return packages.RemoveAptPackages(ctx, []string{enforcePackage.name}) }
+			enforcePackage.action, enforcePackage.actionFunc = removing, func() error {
+				return removePackages(ctx, privhelper.Apt, []string{enforcePackage.name}, packages.RemoveAptPackages)
+			}
 		}
 
 	case p.managedPackage.Deb != nil:
@@ -521,9 +652,15 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 			p.managedPackage.Deb.localPath = localPath
 		}
 		if p.GetDeb().GetPullDeps() {
-			enforcePackage.actionFunc = func() error { return packages.InstallAptPackages(ctx, []string{p.managedPackage.Deb.localPath}) }
+			enforcePackage.actionFunc = func() error {
+				return installPackages(ctx, privhelper.Apt, []string{p.managedPackage.Deb.localPath}, false, packages.InstallAptPackages)
+			}
 		} else {
-			enforcePackage.actionFunc = func() error { return packages.DpkgInstall(ctx, p.managedPackage.Deb.localPath) }
+			enforcePackage.actionFunc = func() error {
+				return installPackages(ctx, privhelper.Deb, []string{p.managedPackage.Deb.localPath}, false, func(ctx context.Context, pkgs []string) error {
+					return packages.DpkgInstall(ctx, pkgs[0])
+				})
+			}
 		}
 
 	case p.managedPackage.GooGet != nil:
@@ -532,9 +669,13 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 		enforcePackage.installedCache = gooInstalled
 		switch p.managedPackage.GooGet.DesiredState {
 		case agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED:
-			enforcePackage.action, enforcePackage.actionFunc = installing, func() error { return packages.InstallGooGetPackages(ctx, []string{enforcePackage.name}) }
+			enforcePackage.action, enforcePackage.actionFunc = installing, func() error {
+				return installPackages(ctx, privhelper.GooGet, []string{enforcePackage.name}, false, packages.InstallGooGetPackages)
+			}
 		case agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED:
-			enforcePackage.action, enforcePackage.actionFunc = removing, func() error { return packages.RemoveGooGetPackages(ctx, []string{enforcePackage.name}) }
+			enforcePackage.action, enforcePackage.actionFunc = removing, func() error {
+				return removePackages(ctx, privhelper.GooGet, []string{enforcePackage.name}, packages.RemoveGooGetPackages)
+			}
 		}
 
 	case p.managedPackage.MSI != nil:
@@ -560,9 +701,13 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 		enforcePackage.installedCache = yumInstalled
 		switch p.managedPackage.Yum.DesiredState {
 		case agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED:
-			enforcePackage.action, enforcePackage.actionFunc = installing, func() error { return packages.InstallYumPackages(ctx, []string{enforcePackage.name}) }
+			enforcePackage.action, enforcePackage.actionFunc = installing, func() error {
+				return installPackages(ctx, privhelper.Yum, []string{enforcePackage.name}, false, packages.InstallYumPackages)
+			}
 		case agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED:
-			enforcePackage.action, enforcePackage.actionFunc = removing, func() error { return packages.RemoveYumPackages(ctx, []string{enforcePackage.name}) }
+			enforcePackage.action, enforcePackage.actionFunc = removing, func() error {
+				return removePackages(ctx, privhelper.Yum, []string{enforcePackage.name}, packages.RemoveYumPackages)
+			}
 		}
 
 	case p.managedPackage.Zypper != nil:
@@ -571,9 +716,13 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 		enforcePackage.installedCache = zypperInstalled
 		switch p.managedPackage.Zypper.DesiredState {
 		case agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED:
-			enforcePackage.action, enforcePackage.actionFunc = installing, func() error { return packages.InstallZypperPackages(ctx, []string{enforcePackage.name}) }
+			enforcePackage.action, enforcePackage.actionFunc = installing, func() error {
+				return installPackages(ctx, privhelper.Zypper, []string{enforcePackage.name}, false, packages.InstallZypperPackages)
+			}
 		case agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED:
-			enforcePackage.action, enforcePackage.actionFunc = removing, func() error { return packages.RemoveZypperPackages(ctx, []string{enforcePackage.name}) }
+			enforcePackage.action, enforcePackage.actionFunc = removing, func() error {
+				return removePackages(ctx, privhelper.Zypper, []string{enforcePackage.name}, packages.RemoveZypperPackages)
+			}
 		}
 
 	case p.managedPackage.RPM != nil:
@@ -592,23 +741,61 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 		if p.GetRpm().GetPullDeps() {
 			switch {
 			case packages.YumExists:
-				enforcePackage.actionFunc = func() error { return packages.InstallYumPackages(ctx, []string{p.managedPackage.RPM.localPath}) }
+				enforcePackage.actionFunc = func() error {
+					return installPackages(ctx, privhelper.Yum, []string{p.managedPackage.RPM.localPath}, false, packages.InstallYumPackages)
+				}
 			case packages.ZypperExists:
-				enforcePackage.actionFunc = func() error { return packages.InstallZypperPackages(ctx, []string{p.managedPackage.RPM.localPath}) }
+				enforcePackage.actionFunc = func() error {
+					return installPackages(ctx, privhelper.Zypper, []string{p.managedPackage.RPM.localPath}, false, packages.InstallZypperPackages)
+				}
 			default:
 				return false, fmt.Errorf("cannot install rpm %q with 'PullDeps' option as neither yum or zypper exist on system", enforcePackage.name)
 			}
 		} else {
-			enforcePackage.actionFunc = func() error { return packages.RPMInstall(ctx, p.managedPackage.RPM.localPath) }
+			enforcePackage.actionFunc = func() error {
+				return installPackages(ctx, privhelper.RPM, []string{p.managedPackage.RPM.localPath}, false, func(ctx context.Context, pkgs []string) error {
+					return packages.RPMInstall(ctx, pkgs[0])
+				})
+			}
+		}
+	}
+
+	var downgraded string
+	if p.pin != nil {
+		if err := populateInstalledCache(ctx, p.managedPackage); err != nil {
+			return false, err
+		}
+		enforcePackage.name = p.pin.spec
+		_, newer := p.installedPinned(enforcePackage.installedCache)
+		switch {
+		case newer != "" && !p.pin.allowDowngrade:
+			return false, fmt.Errorf("%s package %q version %s is installed, newer than the pinned version %s, set packageOptions.allowDowngrade in a local policy to permit the downgrade", enforcePackage.packageType, p.pin.name, newer, p.pin.version)
+		case newer != "":
+			enforcePackage.action, enforcePackage.actionFunc = downgrading, func() error {
+				return downgradePackages(ctx, p.pin.manager, []string{enforcePackage.name}, p.pin.downgrade)
+			}
+			downgraded = fmt.Sprintf("Downgraded %s package %q from %s to %s.", enforcePackage.packageType, p.pin.name, newer, p.pin.version)
 		}
 	}
 
 	clog.Infof(ctx, "%s %s package %q", strings.Title(enforcePackage.action), enforcePackage.packageType, enforcePackage.name)
 	// Reset the cache as we are taking action on.
 	enforcePackage.installedCache.cache = nil
+	enforcePackage.installedCache.versions = nil
 	if err := enforcePackage.actionFunc(); err != nil {
 		return false, fmt.Errorf("error %s %s package %q", enforcePackage.action, enforcePackage.packageType, enforcePackage.name)
 	}
+	if downgraded != "" {
+		clog.Infof(ctx, "%s", downgraded)
+	}
+
+	roots, err := p.rootsNotInDesiredState(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := p.enforceInRoots(ctx, roots); err != nil {
+		return false, err
+	}
 
 	return true, nil
 }
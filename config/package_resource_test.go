@@ -239,7 +239,7 @@ func TestPackageResourceValidate(t *testing.T) {
 				PackageResource: &agentendpointpb.OSPolicy_Resource_PackageResource_RPM{
 					Source: &agentendpointpb.OSPolicy_Resource_File{
 						Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: tmpFile}}}}},
-			exec.Command("/usr/bin/rpmquery", "--queryformat", "\\{\"architecture\":\"%{ARCH}\",\"package\":\"%{NAME}\",\"source_name\":\"%{SOURCERPM}\",\"version\":\"%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\"\\}\n", "-p", tmpFile),
+			exec.Command("/usr/bin/rpmquery", "--queryformat", "\\{\"architecture\":\"%{ARCH}\",\"install_time\":\"%{INSTALLTIME}\",\"package\":\"%{NAME}\",\"source_name\":\"%{SOURCERPM}\",\"version\":\"%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\"\\}\n", "-p", tmpFile),
 			[]byte("{\"architecture\":\"x86_64\",\"package\":\"gcc\",\"source_name\":\"gcc-11.4.1-3.el9.src.rpm\",\"version\":\"11.4.1-3.el9\"}"),
 		},
 	}
@@ -572,3 +572,158 @@ func TestUpdatePackageInfoCacheTimeout(t *testing.T) {
 		t.Errorf("Cache should not contain expired data, cache: %+v", packageInfoCacheStore)
 	}
 }
+
+func TestPackageResourcePinnedVersion(t *testing.T) {
+	ctx := context.Background()
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	packages.SetCommandRunner(mockCommandRunner)
+
+	aptCmd := func(args ...string) *exec.Cmd {
+		cmd := exec.Command("/usr/bin/apt-get", args...)
+		cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+		return cmd
+	}
+	apt := func(name string) *agentendpointpb.OSPolicy_Resource_PackageResource {
+		return &agentendpointpb.OSPolicy_Resource_PackageResource{
+			DesiredState:  agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED,
+			SystemPackage: &agentendpointpb.OSPolicy_Resource_PackageResource_Apt{Apt: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: name}}}
+	}
+	yum := func(name string) *agentendpointpb.OSPolicy_Resource_PackageResource {
+		return &agentendpointpb.OSPolicy_Resource_PackageResource{
+			DesiredState:  agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED,
+			SystemPackage: &agentendpointpb.OSPolicy_Resource_PackageResource_Yum{Yum: &agentendpointpb.OSPolicy_Resource_PackageResource_YUM{Name: name}}}
+	}
+
+	var tests = []struct {
+		name               string
+		prpb               *agentendpointpb.OSPolicy_Resource_PackageResource
+		allowDowngrade     bool
+		cachePointer       *packageCache
+		installed          []string
+		wantInDesiredState bool
+		expectedCmds       []*exec.Cmd
+		wantErr            bool
+	}{
+		{
+			name:               "AptPinned",
+			prpb:               apt("foo=1.0-2"),
+			cachePointer:       aptInstalled,
+			installed:          []string{"1.0-2"},
+			wantInDesiredState: true,
+		},
+		{
+			name:         "AptUpgrade",
+			prpb:         apt("foo=1.0-2"),
+			cachePointer: aptInstalled,
+			installed:    []string{"1.0-1"},
+			expectedCmds: []*exec.Cmd{aptCmd("update"), aptCmd("install", "-y", "foo=1.0-2")},
+		},
+		{
+			name:           "AptNotInstalled",
+			prpb:           apt("foo=1.0-2"),
+			allowDowngrade: true,
+			cachePointer:   aptInstalled,
+			expectedCmds:   []*exec.Cmd{aptCmd("update"), aptCmd("install", "-y", "foo=1.0-2")},
+		},
+		{
+			name:         "AptDowngradeNotPermitted",
+			prpb:         apt("foo=1.0-2"),
+			cachePointer: aptInstalled,
+			installed:    []string{"1:0.9-1"},
+			wantErr:      true,
+		},
+		{
+			name:           "AptDowngrade",
+			prpb:           apt("foo=1.0-2"),
+			allowDowngrade: true,
+			cachePointer:   aptInstalled,
+			installed:      []string{"1.0-10"},
+			expectedCmds:   []*exec.Cmd{aptCmd("install", "-y", "--allow-downgrades", "foo=1.0-2")},
+		},
+		{
+			name:           "YumDowngrade",
+			prpb:           yum("foo=1.0-2.el9"),
+			allowDowngrade: true,
+			cachePointer:   yumInstalled,
+			installed:      []string{"1.0-3.el9", "1.0-2.el9"},
+			expectedCmds:   []*exec.Cmd{exec.Command("/usr/bin/yum", "downgrade", "--assumeyes", "foo-1.0-2.el9")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{
+				OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+					ResourceType: &agentendpointpb.OSPolicy_Resource_Pkg{Pkg: tt.prpb},
+				},
+			}
+			if tt.allowDowngrade {
+				pr.PackageOptions = &PackageOptions{AllowDowngrade: true}
+			}
+			defer pr.Cleanup(ctx)
+			if err := pr.Validate(ctx); err != nil {
+				t.Fatalf("Unexpected Validate error: %v", err)
+			}
+
+			tt.cachePointer.cache = map[string]struct{}{}
+			tt.cachePointer.versions = map[string][]string{}
+			if tt.installed != nil {
+				tt.cachePointer.cache["foo"] = struct{}{}
+				tt.cachePointer.versions["foo"] = tt.installed
+			}
+			tt.cachePointer.refreshed = time.Now()
+			if err := pr.CheckState(ctx); err != nil {
+				t.Fatalf("Unexpected CheckState error: %v", err)
+			}
+			if tt.wantInDesiredState != pr.InDesiredState() {
+				t.Fatalf("Unexpected InDesiredState, want: %t, got: %t", tt.wantInDesiredState, pr.InDesiredState())
+			}
+			if tt.wantInDesiredState {
+				return
+			}
+
+			for _, expectedCmd := range tt.expectedCmds {
+				mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(expectedCmd))
+			}
+			if err := pr.EnforceState(ctx); (err != nil) != tt.wantErr {
+				t.Fatalf("EnforceState() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPackageOptionsRequirePin(t *testing.T) {
+	pr := &OSPolicyResource{
+		OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+			ResourceType: &agentendpointpb.OSPolicy_Resource_Pkg{Pkg: &agentendpointpb.OSPolicy_Resource_PackageResource{
+				DesiredState:  agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED,
+				SystemPackage: &agentendpointpb.OSPolicy_Resource_PackageResource_Apt{Apt: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: "foo"}}}},
+		},
+		PackageOptions: &PackageOptions{AllowDowngrade: true},
+	}
+	if err := pr.Validate(context.Background()); err == nil {
+		t.Error("Validate: expected an error for allowDowngrade without a pinned version")
+	}
+}
+
+func TestParsePackagePin(t *testing.T) {
+	var tests = []struct {
+		name    string
+		wantErr bool
+	}{
+		{"foo", false},
+		{"foo=1.0-1", false},
+		{"foo=1:1.0-1", false},
+		{"foo=", true},
+		{"foo=-1", true},
+		{"=1.0", true},
+		{"foo=1.0,allow-downgrade", true},
+	}
+	for _, tt := range tests {
+		if _, _, err := parsePackagePin("apt", tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("parsePackagePin(%q) error = %v, wantErr %t", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+// PackageSetResource converges the host to a package set manifest, like one
+// written by inventory -local -format manifest on a golden VM. Each package
+// in the manifest is installed, upgraded or downgraded to its exact version
+// with apt, yum or zypper, packages that could not be reconciled are reported
+// in the enforcement error. Exactly one of ManifestPath or Packages should be
+// set.
+type PackageSetResource struct {
+	// ManifestPath is a local JSON manifest file, see packages.Manifest.
+	ManifestPath string `json:"manifestPath,omitempty"`
+	// Packages is an inline manifest, package name to version.
+	Packages map[string]string `json:"packages,omitempty"`
+	// RemoveUnlisted also removes the installed packages that are not in the
+	// manifest, so the host has the exact package set.
+	RemoveUnlisted bool `json:"removeUnlisted,omitempty"`
+}
+
+type packageSetResource struct {
+	*PackageSetResource
+
+	manifest *packages.Manifest
+	mgr      *packageSetManager
+}
+
+// packageSetManager is how a package manager installs exact versions, spec
+// formats a package and version as an argument to install.
+type packageSetManager struct {
+	name      string
+	installed func(context.Context) ([]*packages.PkgInfo, error)
+	spec      func(name, version string) string
+	install   func(context.Context, []string) error
+	// downgrade is for package managers that don't downgrade on install.
+	downgrade func(context.Context, []string) error
+	remove    func(context.Context, []string) error
+}
+
+var packageSetManagerFor = func() (*packageSetManager, error) {
+	switch {
+	case goos == "windows":
+		return nil, errors.New("not supported on Windows")
+	case packages.AptExists && packages.DpkgQueryExists:
+		return &packageSetManager{
+			name:      "apt",
+			installed: packages.InstalledDebPackages,
+			spec:      func(name, version string) string { return name + "=" + version },
+			install:   packages.InstallAptPackages,
+			remove:    packages.RemoveAptPackages,
+		}, nil
+	case packages.YumExists && packages.RPMQueryExists:
+		return &packageSetManager{
+			name:      "yum",
+			installed: packages.InstalledRPMPackages,
+			spec:      func(name, version string) string { return name + "-" + version },
+			install:   packages.InstallYumPackages,
+			downgrade: packages.DowngradeYumPackages,
+			remove:    packages.RemoveYumPackages,
+		}, nil
+	case packages.ZypperExists && packages.RPMQueryExists:
+		return &packageSetManager{
+			name:      "zypper",
+			installed: packages.InstalledRPMPackages,
+			spec:      func(name, version string) string { return name + "=" + version },
+			install:   packages.InstallZypperPackageVersions,
+			remove:    packages.RemoveZypperPackages,
+		}, nil
+	default:
+		return nil, errors.New("no supported package manager, apt, yum or zypper, found")
+	}
+}
+
+func (p *packageSetResource) validate(ctx context.Context) (*ManagedResources, error) {
+	switch {
+	case p.ManifestPath != "" && len(p.Packages) > 0:
+		return nil, errors.New("PackageSetResource: only one of ManifestPath or Packages can be set")
+	case p.ManifestPath != "":
+		data, err := os.ReadFile(p.ManifestPath)
+		if err != nil {
+			return nil, fmt.Errorf("PackageSetResource: %v", err)
+		}
+		if p.manifest, err = packages.ParseManifest(data); err != nil {
+			return nil, fmt.Errorf("PackageSetResource: %q: %v", p.ManifestPath, err)
+		}
+	default:
+		p.manifest = &packages.Manifest{Packages: p.Packages}
+		if err := p.manifest.Validate(); err != nil {
+			return nil, fmt.Errorf("PackageSetResource: %v", err)
+		}
+	}
+	var err error
+	if p.mgr, err = packageSetManagerFor(); err != nil {
+		return nil, fmt.Errorf("PackageSetResource: %v", err)
+	}
+	return nil, nil
+}
+
+// packageSetDiff is how the installed packages differ from the manifest.
+type packageSetDiff struct {
+	// change are the packages to install, upgrade or downgrade, installed
+	// the subset of those that are installed with another version.
+	change, installed []string
+	// unlisted are installed packages not in the manifest.
+	unlisted []string
+	// have is the installed versions of each package.
+	have map[string][]string
+}
+
+func (d *packageSetDiff) empty(removeUnlisted bool) bool {
+	return len(d.change) == 0 && (!removeUnlisted || len(d.unlisted) == 0)
+}
+
+// diff compares the installed packages to the manifest, a package installed
+// for more than one architecture has to have the version on all of them.
+func (p *packageSetResource) diff(ctx context.Context) (*packageSetDiff, error) {
+	pkgs, err := p.mgr.installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d := &packageSetDiff{have: map[string][]string{}}
+	for _, pkg := range pkgs {
+		d.have[pkg.Name] = append(d.have[pkg.Name], pkg.Version)
+	}
+	for name, want := range p.manifest.Packages {
+		have, ok := d.have[name]
+		if !ok {
+			d.change = append(d.change, name)
+			continue
+		}
+		for _, v := range have {
+			if v != want {
+				d.change = append(d.change, name)
+				d.installed = append(d.installed, name)
+				break
+			}
+		}
+	}
+	for name := range d.have {
+		if _, ok := p.manifest.Packages[name]; !ok {
+			d.unlisted = append(d.unlisted, name)
+		}
+	}
+	sort.Strings(d.change)
+	sort.Strings(d.installed)
+	sort.Strings(d.unlisted)
+	return d, nil
+}
+
+func (p *packageSetResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	d, err := p.diff(ctx)
+	if err != nil {
+		return false, err
+	}
+	return d.empty(p.RemoveUnlisted), nil
+}
+
+func (p *packageSetResource) specs(names []string) []string {
+	var specs []string
+	for _, name := range names {
+		specs = append(specs, p.mgr.spec(name, p.manifest.Packages[name]))
+	}
+	return specs
+}
+
+// apply runs f with the specs of names in one transaction, falling back to
+// one package at a time when that fails so a single package that can't be
+// installed doesn't hold back the others.
+func (p *packageSetResource) apply(ctx context.Context, f func(context.Context, []string) error, specs []string) {
+	if len(specs) == 0 {
+		return
+	}
+	if err := f(ctx, specs); err == nil || len(specs) == 1 {
+		return
+	}
+	for _, spec := range specs {
+		if err := f(ctx, []string{spec}); err != nil {
+			clog.Warningf(ctx, "Error reconciling package %q: %v", spec, err)
+		}
+	}
+}
+
+func (p *packageSetResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	d, err := p.diff(ctx)
+	if err != nil {
+		return false, err
+	}
+	clog.Infof(ctx, "Reconciling %d packages with %s.", len(d.change), p.mgr.name)
+	p.apply(ctx, p.mgr.install, p.specs(d.change))
+
+	if p.mgr.downgrade != nil {
+		if d, err = p.diff(ctx); err != nil {
+			return false, err
+		}
+		p.apply(ctx, p.mgr.downgrade, p.specs(d.installed))
+	}
+
+	if p.RemoveUnlisted && len(d.unlisted) > 0 {
+		clog.Infof(ctx, "Removing %d packages not in the package set.", len(d.unlisted))
+		p.apply(ctx, p.mgr.remove, d.unlisted)
+	}
+
+	if d, err = p.diff(ctx); err != nil {
+		return false, err
+	}
+	if d.empty(p.RemoveUnlisted) {
+		return true, nil
+	}
+	var failed []string
+	for _, name := range d.change {
+		have := "not installed"
+		if v, ok := d.have[name]; ok {
+			have = "have " + strings.Join(v, ", ")
+		}
+		failed = append(failed, fmt.Sprintf("%s (want %s, %s)", name, p.manifest.Packages[name], have))
+	}
+	if p.RemoveUnlisted {
+		for _, name := range d.unlisted {
+			failed = append(failed, fmt.Sprintf("%s (not in the package set)", name))
+		}
+	}
+	return false, fmt.Errorf("could not reconcile %d packages: %s", len(failed), strings.Join(failed, "; "))
+}
+
+func (p *packageSetResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {
+}
+
+func (p *packageSetResource) cleanup(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,186 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakePackageSet is a package manager holding installed versions, packages
+// in fail can't be installed and, when noDowngrade is set, install does not
+// downgrade.
+type fakePackageSet struct {
+	installed   map[string]string
+	fail        map[string]bool
+	noDowngrade bool
+	calls       []string
+}
+
+func (f *fakePackageSet) manager() *packageSetManager {
+	m := &packageSetManager{
+		name: "fake",
+		installed: func(context.Context) ([]*packages.PkgInfo, error) {
+			var pkgs []*packages.PkgInfo
+			for name, v := range f.installed {
+				pkgs = append(pkgs, &packages.PkgInfo{Name: name, Version: v})
+			}
+			return pkgs, nil
+		},
+		spec: func(name, version string) string { return name + "=" + version },
+		install: func(_ context.Context, specs []string) error {
+			return f.apply("install", specs, f.noDowngrade)
+		},
+		remove: func(_ context.Context, names []string) error {
+			f.calls = append(f.calls, "remove "+strings.Join(names, " "))
+			for _, name := range names {
+				delete(f.installed, name)
+			}
+			return nil
+		},
+	}
+	if f.noDowngrade {
+		m.downgrade = func(_ context.Context, specs []string) error {
+			return f.apply("downgrade", specs, false)
+		}
+	}
+	return m
+}
+
+func (f *fakePackageSet) apply(op string, specs []string, upgradeOnly bool) error {
+	f.calls = append(f.calls, op+" "+strings.Join(specs, " "))
+	for _, spec := range specs {
+		if name, _, _ := strings.Cut(spec, "="); f.fail[name] {
+			return errors.New("no such version")
+		}
+	}
+	for _, spec := range specs {
+		name, v, _ := strings.Cut(spec, "=")
+		if have, ok := f.installed[name]; ok && upgradeOnly && have > v {
+			continue
+		}
+		f.installed[name] = v
+	}
+	return nil
+}
+
+func TestPackageSetResourceValidate(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func() (*packageSetManager, error)) { packageSetManagerFor = f }(packageSetManagerFor)
+	packageSetManagerFor = func() (*packageSetManager, error) { return (&fakePackageSet{}).manager(), nil }
+
+	manifest := filepath.Join(t.TempDir(), "manifest.json")
+	if err := os.WriteFile(manifest, []byte(`{"packages": {"curl": "7.88.1-10"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name    string
+		ps      *PackageSetResource
+		wantErr bool
+	}{
+		{"Inline", &PackageSetResource{Packages: map[string]string{"curl": "7.88.1-10"}}, false},
+		{"ManifestPath", &PackageSetResource{ManifestPath: manifest}, false},
+		{"MissingManifest", &PackageSetResource{ManifestPath: manifest + ".missing"}, true},
+		{"Both", &PackageSetResource{ManifestPath: manifest, Packages: map[string]string{"curl": "7.88.1-10"}}, true},
+		{"Neither", &PackageSetResource{}, true},
+		{"BadVersion", &PackageSetResource{Packages: map[string]string{"curl": "-1"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{Local: &LocalResource{PackageSet: tt.ps}}
+			err := pr.Validate(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPackageSetResourceCheckAndEnforce(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func() (*packageSetManager, error)) { packageSetManagerFor = f }(packageSetManagerFor)
+
+	want := map[string]string{"curl": "2", "vim": "1", "jq": "3"}
+	var tests = []struct {
+		name           string
+		fake           *fakePackageSet
+		removeUnlisted bool
+		wantInstalled  map[string]string
+		wantCalls      []string
+		wantErr        string
+	}{
+		{
+			name:          "InstallUpgradeDowngrade",
+			fake:          &fakePackageSet{installed: map[string]string{"curl": "1", "vim": "2", "bash": "5"}},
+			wantInstalled: map[string]string{"curl": "2", "vim": "1", "jq": "3", "bash": "5"},
+			wantCalls:     []string{"install curl=2 jq=3 vim=1"},
+		},
+		{
+			name:          "SeparateDowngrade",
+			fake:          &fakePackageSet{installed: map[string]string{"curl": "1", "vim": "2"}, noDowngrade: true},
+			wantInstalled: map[string]string{"curl": "2", "vim": "1", "jq": "3"},
+			wantCalls:     []string{"install curl=2 jq=3 vim=1", "downgrade vim=1"},
+		},
+		{
+			name:           "RemoveUnlisted",
+			fake:           &fakePackageSet{installed: map[string]string{"curl": "2", "vim": "1", "jq": "3", "bash": "5"}},
+			removeUnlisted: true,
+			wantInstalled:  map[string]string{"curl": "2", "vim": "1", "jq": "3"},
+			wantCalls:      []string{"remove bash"},
+		},
+		{
+			name:          "Unreconciled",
+			fake:          &fakePackageSet{installed: map[string]string{"curl": "1"}, fail: map[string]bool{"curl": true}},
+			wantInstalled: map[string]string{"curl": "1", "vim": "1", "jq": "3"},
+			wantCalls:     []string{"install curl=2 jq=3 vim=1", "install curl=2", "install jq=3", "install vim=1"},
+			wantErr:       "could not reconcile 1 packages: curl (want 2, have 1)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packageSetManagerFor = func() (*packageSetManager, error) { return tt.fake.manager(), nil }
+			pr := &OSPolicyResource{Local: &LocalResource{PackageSet: &PackageSetResource{Packages: want, RemoveUnlisted: tt.removeUnlisted}}}
+			if err := pr.Validate(ctx); err != nil {
+				t.Fatalf("Validate() error: %v", err)
+			}
+			if err := pr.CheckState(ctx); err != nil {
+				t.Fatalf("CheckState() error: %v", err)
+			}
+			if tt.wantCalls != nil && pr.InDesiredState() {
+				t.Error("CheckState() = in desired state, want not")
+			}
+			var gotErr string
+			if err := pr.EnforceState(ctx); err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tt.wantErr {
+				t.Errorf("EnforceState() error = %q, want %q", gotErr, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.wantInstalled, tt.fake.installed); diff != "" {
+				t.Errorf("installed mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantCalls, tt.fake.calls); diff != "" {
+				t.Errorf("calls mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
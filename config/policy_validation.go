@@ -0,0 +1,57 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+// RunPolicyValidation runs a policy level validation script after the
+// resources of a policy were enforced, for example to test a web server
+// config before it is considered compliant. Like the enforce script of an
+// ExecResource it must exit with 100 to succeed, any other exit code is an
+// error.
+func RunPolicyValidation(ctx context.Context, execR *agentendpointpb.OSPolicy_Resource_ExecResource_Exec) (err error) {
+	e := &execResource{}
+	tmpDir, err := os.MkdirTemp("", "osconfig_policy_validation_")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %s", err)
+	}
+	e.tempDir = tmpDir
+	defer e.cleanup(ctx)
+
+	name, err := e.download(ctx, execR)
+	if err != nil {
+		return err
+	}
+	clog.Infof(ctx, "Running policy validation %q.", name)
+	defer func() { journal.Record(ctx, journal.ScriptRun, name, err) }()
+	stdout, stderr, code, err := e.run(ctx, name, execR)
+	switch code {
+	case -1:
+		return err
+	case 100:
+		return nil
+	default:
+		return fmt.Errorf("unexpected return code from policy validation: %d, stdout: %s, stderr: %s", code, stdout, stderr)
+	}
+}
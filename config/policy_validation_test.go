@@ -0,0 +1,52 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+func TestRunPolicyValidation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	ctx := context.Background()
+	tests := []struct {
+		desc    string
+		script  string
+		wantErr bool
+	}{
+		{"success", "exit 100", false},
+		{"failure", "echo invalid config >&2; exit 1", true},
+		{"zero is not success", "exit 0", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			execR := &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{
+				Source:      &agentendpointpb.OSPolicy_Resource_ExecResource_Exec_Script{Script: tt.script},
+				Interpreter: agentendpointpb.OSPolicy_Resource_ExecResource_Exec_SHELL,
+			}
+			if err := RunPolicyValidation(ctx, execR); (err != nil) != tt.wantErr {
+				t.Errorf("RunPolicyValidation: got err %v, want err %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/privhelper"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	// privilegedHelper returns the client of the privileged helper when the
+	// agent runs unprivileged, nil when it enforces itself.
+	privilegedHelper = func() *privhelper.Client {
+		if socket := agentconfig.PrivilegedHelperSocket(); socket != "" {
+			return privhelper.NewClient(socket)
+		}
+		return nil
+	}
+
+	// writableDirs are written by an unprivileged agent itself.
+	writableDirs = func() []string {
+		return []string{agentconfig.CacheDir(), os.TempDir()}
+	}
+)
+
+// downloadDir is the directory packages are downloaded to: the state
+// directory of an agent using the privileged helper, which only installs
+// packages from directories of root or the agent user, or the temporary
+// directory.
+func downloadDir() string {
+	if privilegedHelper() != nil {
+		return agentconfig.CacheDir()
+	}
+	return ""
+}
+
+// helperFor returns the privileged helper to write path with, nil if the
+// agent writes it itself.
+func helperFor(path string) *privhelper.Client {
+	h := privilegedHelper()
+	if h == nil {
+		return nil
+	}
+	path = filepath.Clean(path)
+	for _, dir := range writableDirs() {
+		if dir = filepath.Clean(dir); path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return h
+}
+
+// writeFile atomically replaces path with content.
+func writeFile(ctx context.Context, path string, content []byte, perms os.FileMode) error {
+	if h := helperFor(path); h != nil {
+		return h.WriteFile(ctx, path, content, perms)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return util.AtomicWrite(path, content, perms)
+}
+
+// copyFileTo copies src, a file of the agent, to dst.
+func copyFileTo(ctx context.Context, dst, src string, perms os.FileMode) error {
+	h := helperFor(dst)
+	if h == nil {
+		return copyFile(dst, src, perms)
+	}
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return h.WriteFile(ctx, dst, content, perms)
+}
+
+// removeFile removes path.
+func removeFile(ctx context.Context, path string) error {
+	if h := helperFor(path); h != nil {
+		return h.RemoveFile(ctx, path)
+	}
+	return os.Remove(path)
+}
+
+// installPackages installs pkgs with install, or with manager through the
+// privileged helper. refresh updates the apt package index first.
+func installPackages(ctx context.Context, manager string, pkgs []string, refresh bool, install func(context.Context, []string) error) error {
+	if h := privilegedHelper(); h != nil {
+		return h.InstallPackages(ctx, manager, pkgs, refresh)
+	}
+	if refresh {
+		if _, err := packages.AptUpdate(ctx); err != nil {
+			return err
+		}
+	}
+	return install(ctx, pkgs)
+}
+
+// downgradePackages installs the pinned versions in pkgs with downgrade, or
+// with manager through the privileged helper.
+func downgradePackages(ctx context.Context, manager string, pkgs []string, downgrade func(context.Context, []string) error) error {
+	if h := privilegedHelper(); h != nil {
+		return h.DowngradePackages(ctx, manager, pkgs)
+	}
+	return downgrade(ctx, pkgs)
+}
+
+// removePackages removes pkgs with remove, or with manager through the
+// privileged helper.
+func removePackages(ctx context.Context, manager string, pkgs []string, remove func(context.Context, []string) error) error {
+	if h := privilegedHelper(); h != nil {
+		return h.RemovePackages(ctx, manager, pkgs)
+	}
+	return remove(ctx, pkgs)
+}
@@ -0,0 +1,73 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/privhelper"
+)
+
+func TestHelperFor(t *testing.T) {
+	defer func(f func() *privhelper.Client) { privilegedHelper = f }(privilegedHelper)
+	defer func(f func() []string) { writableDirs = f }(writableDirs)
+	writableDirs = func() []string { return []string{"/var/lib/google_osconfig_agent", "/tmp/"} }
+
+	privilegedHelper = func() *privhelper.Client { return nil }
+	if helperFor("/etc/app.conf") != nil {
+		t.Error("helperFor: got a helper, want none when the agent enforces itself")
+	}
+
+	h := privhelper.NewClient("/run/google_osconfig_agent/helper.sock")
+	privilegedHelper = func() *privhelper.Client { return h }
+	for path, want := range map[string]*privhelper.Client{
+		"/etc/app.conf":                         h,
+		"/var/lib/google_osconfig_agent":        nil,
+		"/var/lib/google_osconfig_agent/a/b":    nil,
+		"/var/lib/google_osconfig_agent_other":  h,
+		"/var/lib/google_osconfig_agent/../etc": h,
+		"/tmp/osconfig_file_resource_1/file":    nil,
+	} {
+		if got := helperFor(path); got != want {
+			t.Errorf("helperFor(%q): got %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestWriteFileWithoutHelper(t *testing.T) {
+	defer func(f func() *privhelper.Client) { privilegedHelper = f }(privilegedHelper)
+	privilegedHelper = func() *privhelper.Client { return nil }
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "sources.list.d", "repo.list")
+	if err := writeFile(ctx, path, []byte("deb http://repo/ stable main\n"), 0644); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "deb http://repo/ stable main\n" {
+		t.Errorf("written file: got %q, %v", data, err)
+	}
+	if err := removeFile(ctx, path); err != nil {
+		t.Errorf("removeFile: %v", err)
+	}
+
+	var installed []string
+	install := func(_ context.Context, pkgs []string) error { installed = pkgs; return nil }
+	if err := installPackages(ctx, privhelper.Yum, []string{"nginx"}, false, install); err != nil || len(installed) != 1 {
+		t.Errorf("installPackages: got %q, %v, want nginx installed directly", installed, err)
+	}
+}
@@ -20,14 +20,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/privhelper"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/armor"
@@ -37,10 +39,38 @@ import (
 
 const aptGPGDir = "/etc/apt/trusted.gpg.d"
 
+var (
+	installAptPackages = packages.InstallAptPackages
+	installYumPackages = packages.InstallYumPackages
+	dnfExists          = util.Exists("/usr/bin/dnf")
+)
+
 type repositoryResource struct {
 	*agentendpointpb.OSPolicy_Resource_RepositoryResource
 
 	managedRepository ManagedRepository
+	credentialHelper  *credentialHelper
+}
+
+// credentialHelper is a package that authenticates a package manager to
+// Artifact Registry with the instance service account.
+type credentialHelper struct {
+	pkg string
+	// installed is a file the package installs.
+	installed string
+	install   func(context.Context, []string) error
+	// manager installs the package through the privileged helper.
+	manager string
+}
+
+// artifactRegistryRepo reports whether uri is an Artifact Registry
+// repository of the given format, like https://us-apt.pkg.dev/projects/p.
+func artifactRegistryRepo(uri, format string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "https" && strings.HasSuffix(u.Hostname(), "-"+format+".pkg.dev")
 }
 
 // AptRepository describes an apt repository resource.
@@ -75,9 +105,14 @@ type ManagedRepository struct {
 	RepoFilePath     string
 	RepoChecksum     string
 	RepoFileContents []byte
+	// CredentialHelper is the package installed to access an Artifact
+	// Registry repository.
+	CredentialHelper string
 }
 
-func aptRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository) []byte {
+// aptRepoContents returns the apt repository file, with arHelper Artifact
+// Registry URIs are handled by its apt transport.
+func aptRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository, arHelper bool) []byte {
 	var debArchiveTypeMap = map[agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository_ArchiveType]string{
 		agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository_DEB:     "deb",
 		agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository_DEB_SRC: "deb-src",
@@ -93,7 +128,12 @@ func aptRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_
 	if !ok {
 		archiveType = "deb"
 	}
-	line := fmt.Sprintf("%s %s %s", archiveType, repo.GetUri(), repo.GetDistribution())
+	uri := repo.GetUri()
+	// The Artifact Registry apt transport handles ar+https URIs.
+	if arHelper && artifactRegistryRepo(uri, "apt") {
+		uri = "ar+" + uri
+	}
+	line := fmt.Sprintf("%s %s %s", archiveType, uri, repo.GetDistribution())
 	for _, c := range repo.GetComponents() {
 		line = fmt.Sprintf("%s %s", line, c)
 	}
@@ -230,8 +270,11 @@ func (r *repositoryResource) validate(ctx context.Context) (*ManagedResources, e
 		}
 		gpgkey := r.GetApt().GetGpgKey()
 		r.managedRepository.Apt = &AptRepository{RepositoryResource: r.GetApt()}
-		r.managedRepository.RepoFileContents = aptRepoContents(r.GetApt())
+		r.managedRepository.RepoFileContents = aptRepoContents(r.GetApt(), arCredentialHelpers())
 		repoFormat = agentconfig.AptRepoFormat()
+		if arCredentialHelpers() && artifactRegistryRepo(r.GetApt().GetUri(), "apt") {
+			r.credentialHelper = &credentialHelper{pkg: "apt-transport-artifact-registry", installed: "/usr/lib/apt/methods/ar+https", install: installAptPackages, manager: privhelper.Apt}
+		}
 		if gpgkey != "" {
 			entityList, err := fetchGPGKey(gpgkey)
 			if err != nil {
@@ -259,14 +302,26 @@ func (r *repositoryResource) validate(ctx context.Context) (*ManagedResources, e
 		if !packages.YumExists {
 			return nil, errors.New("cannot manage yum repository because yum does not exist on the system")
 		}
+		if err := packages.ValidateRepoID(r.GetYum().GetId()); err != nil {
+			return nil, err
+		}
 		r.managedRepository.Yum = &YumRepository{RepositoryResource: r.GetYum()}
 		r.managedRepository.RepoFileContents = yumRepoContents(r.GetYum())
 		repoFormat = agentconfig.YumRepoFormat()
+		if arCredentialHelpers() && artifactRegistryRepo(r.GetYum().GetBaseUrl(), "yum") {
+			r.credentialHelper = &credentialHelper{pkg: "yum-plugin-artifact-registry", installed: "/etc/yum/pluginconf.d/artifact-registry.conf", install: installYumPackages, manager: privhelper.Yum}
+			if dnfExists {
+				r.credentialHelper = &credentialHelper{pkg: "dnf-plugin-artifact-registry", installed: "/etc/dnf/plugins/artifact-registry.conf", install: installYumPackages, manager: privhelper.Yum}
+			}
+		}
 
 	case *agentendpointpb.OSPolicy_Resource_RepositoryResource_Zypper:
 		if !packages.ZypperExists {
 			return nil, errors.New("cannot manage zypper repository because zypper does not exist on the system")
 		}
+		if err := packages.ValidateRepoID(r.GetZypper().GetId()); err != nil {
+			return nil, err
+		}
 		r.managedRepository.Zypper = &ZypperRepository{RepositoryResource: r.GetZypper()}
 		r.managedRepository.RepoFileContents = zypperRepoContents(r.GetZypper())
 		repoFormat = agentconfig.ZypperRepoFormat()
@@ -274,6 +329,9 @@ func (r *repositoryResource) validate(ctx context.Context) (*ManagedResources, e
 		return nil, fmt.Errorf("Repository field not set or references unknown repository type: %v", r.GetRepository())
 	}
 
+	if r.credentialHelper != nil {
+		r.managedRepository.CredentialHelper = r.credentialHelper.pkg
+	}
 	r.managedRepository.RepoChecksum = checksum(bytes.NewReader(r.managedRepository.RepoFileContents))
 	r.managedRepository.RepoFilePath = fmt.Sprintf(repoFormat, r.managedRepository.RepoChecksum[:10])
 	return &ManagedResources{Repositories: []ManagedRepository{r.managedRepository}}, nil
@@ -301,6 +359,11 @@ func contentsMatch(ctx context.Context, path, chksum string) (bool, error) {
 }
 
 func (r *repositoryResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	if r.credentialHelper != nil && !util.Exists(r.credentialHelper.installed) {
+		clog.Debugf(ctx, "Artifact Registry credential helper %q is not installed.", r.credentialHelper.pkg)
+		return false, nil
+	}
+
 	// Check APT gpg key if applicable.
 	if r.managedRepository.Apt != nil && r.managedRepository.Apt.GpgFileContents != nil {
 		match, err := contentsMatch(ctx, r.managedRepository.Apt.GpgFilePath, r.managedRepository.Apt.GpgChecksum)
@@ -317,17 +380,22 @@ func (r *repositoryResource) checkState(ctx context.Context) (inDesiredState boo
 
 func (r *repositoryResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
 	clog.Infof(ctx, "Enforcing repo %s.", r.managedRepository.RepoFilePath)
+	// Install the credential helper first, so the package manager can access
+	// the repository once it is added.
+	if r.credentialHelper != nil && !util.Exists(r.credentialHelper.installed) {
+		clog.Infof(ctx, "Installing Artifact Registry credential helper %q.", r.credentialHelper.pkg)
+		if err := installPackages(ctx, r.credentialHelper.manager, []string{r.credentialHelper.pkg}, false, r.credentialHelper.install); err != nil {
+			return false, fmt.Errorf("error installing Artifact Registry credential helper %q: %w", r.credentialHelper.pkg, err)
+		}
+	}
 	// Set APT gpg key if applicable.
 	if r.managedRepository.Apt != nil && r.managedRepository.Apt.GpgFileContents != nil {
-		if err := ioutil.WriteFile(r.managedRepository.Apt.GpgFilePath, r.managedRepository.Apt.GpgFileContents, 0644); err != nil {
+		if err := writeFile(ctx, r.managedRepository.Apt.GpgFilePath, r.managedRepository.Apt.GpgFileContents, 0644); err != nil {
 			return false, err
 		}
 	}
 
-	if err := os.MkdirAll(filepath.Dir(r.managedRepository.RepoFilePath), 0755); err != nil {
-		return false, err
-	}
-	if err := util.AtomicWrite(r.managedRepository.RepoFilePath, r.managedRepository.RepoFileContents, 0644); err != nil {
+	if err := writeFile(ctx, r.managedRepository.RepoFilePath, r.managedRepository.RepoFileContents, 0644); err != nil {
 		return false, err
 	}
 	return true, nil
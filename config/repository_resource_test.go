@@ -301,3 +301,90 @@ func TestFetchGPGKey(t *testing.T) {
 		t.Errorf("Expected to find Artifact Registry key in Google Cloud Public GPG key, but its missed.")
 	}
 }
+
+func TestRepositoryResourceArtifactRegistry(t *testing.T) {
+	ctx := context.Background()
+	defer func(b bool) { dnfExists = b }(dnfExists)
+	dnfExists = true
+	defer func(f func(context.Context, []string) error) { installAptPackages = f }(installAptPackages)
+	defer func(f func(context.Context, []string) error) { installYumPackages = f }(installYumPackages)
+	defer func(f func() bool) { arCredentialHelpers = f }(arCredentialHelpers)
+
+	arApt := &agentendpointpb.OSPolicy_Resource_RepositoryResource{Repository: &agentendpointpb.OSPolicy_Resource_RepositoryResource_Apt{
+		Apt: &agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository{Uri: "https://us-apt.pkg.dev/projects/p", Distribution: "repo", Components: []string{"main"}},
+	}}
+	arYum := &agentendpointpb.OSPolicy_Resource_RepositoryResource{Repository: &agentendpointpb.OSPolicy_Resource_RepositoryResource_Yum{
+		Yum: &agentendpointpb.OSPolicy_Resource_RepositoryResource_YumRepository{Id: "ar", BaseUrl: "https://us-yum.pkg.dev/projects/p/repo"},
+	}}
+	var tests = []struct {
+		name         string
+		helpers      bool
+		rrpb         *agentendpointpb.OSPolicy_Resource_RepositoryResource
+		wantContents string
+		wantHelper   string
+	}{
+		{"Apt", true, arApt, "deb ar+https://us-apt.pkg.dev/projects/p repo main\n", "apt-transport-artifact-registry"},
+		{"Yum", true, arYum, "baseurl=https://us-yum.pkg.dev/projects/p/repo\n", "dnf-plugin-artifact-registry"},
+		{
+			"NotArtifactRegistry",
+			true,
+			&agentendpointpb.OSPolicy_Resource_RepositoryResource{Repository: &agentendpointpb.OSPolicy_Resource_RepositoryResource_Apt{
+				Apt: &agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository{Uri: "https://packages.example.com/apt", Distribution: "repo", Components: []string{"main"}},
+			}},
+			"deb https://packages.example.com/apt repo main\n",
+			"",
+		},
+		// Without the opt-in repository files are written as given.
+		{"AptNotEnabled", false, arApt, "deb https://us-apt.pkg.dev/projects/p repo main\n", ""},
+		{"YumNotEnabled", false, arYum, "baseurl=https://us-yum.pkg.dev/projects/p/repo\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arCredentialHelpers = func() bool { return tt.helpers }
+			var installed []string
+			installAptPackages = func(_ context.Context, pkgs []string) error { installed = append(installed, pkgs...); return nil }
+			installYumPackages = installAptPackages
+
+			pr := &OSPolicyResource{
+				OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+					ResourceType: &agentendpointpb.OSPolicy_Resource_Repository{Repository: tt.rrpb},
+				},
+			}
+			if err := pr.Validate(ctx); err != nil {
+				t.Fatalf("Unexpected Validate error: %v", err)
+			}
+			r := pr.resource.(*repositoryResource)
+			if !strings.Contains(string(r.managedRepository.RepoFileContents), tt.wantContents) {
+				t.Errorf("repo file does not contain %q:\n%s", tt.wantContents, r.managedRepository.RepoFileContents)
+			}
+			if r.managedRepository.CredentialHelper != tt.wantHelper {
+				t.Errorf("CredentialHelper: got %q, want %q", r.managedRepository.CredentialHelper, tt.wantHelper)
+			}
+
+			dir := t.TempDir()
+			r.managedRepository.RepoFilePath = filepath.Join(dir, "repo")
+			if err := os.WriteFile(r.managedRepository.RepoFilePath, r.managedRepository.RepoFileContents, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if r.credentialHelper != nil {
+				r.credentialHelper.installed = filepath.Join(dir, "helper")
+			}
+			if err := pr.CheckState(ctx); err != nil {
+				t.Fatalf("Unexpected CheckState error: %v", err)
+			}
+			if got, want := pr.InDesiredState(), tt.wantHelper == ""; got != want {
+				t.Errorf("InDesiredState: got %t, want %t", got, want)
+			}
+			if err := pr.EnforceState(ctx); err != nil {
+				t.Fatalf("Unexpected EnforceState error: %v", err)
+			}
+			var want []string
+			if tt.wantHelper != "" {
+				want = []string{tt.wantHelper}
+			}
+			if diff := cmp.Diff(want, installed); diff != "" {
+				t.Errorf("installed packages (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+// Scheduled task trigger types.
+const (
+	TriggerBoot   = "boot"
+	TriggerLogon  = "logon"
+	TriggerOnce   = "once"
+	TriggerDaily  = "daily"
+	TriggerWeekly = "weekly"
+)
+
+var weekdays = map[string]string{
+	"sunday": "Sunday", "monday": "Monday", "tuesday": "Tuesday", "wednesday": "Wednesday",
+	"thursday": "Thursday", "friday": "Friday", "saturday": "Saturday",
+}
+
+// serviceAccounts can run tasks without a stored password.
+var serviceAccounts = map[string]bool{
+	"SYSTEM": true, "LOCAL SERVICE": true, "NETWORK SERVICE": true,
+	`NT AUTHORITY\SYSTEM`: true, `NT AUTHORITY\LOCAL SERVICE`: true, `NT AUTHORITY\NETWORK SERVICE`: true,
+}
+
+// ScheduledTaskResource ensures a Windows scheduled task exists with the
+// given trigger, action and identity.
+type ScheduledTaskResource struct {
+	Name string `json:"name"`
+	// Folder is the task folder, the root folder \ is used if not set.
+	Folder           string               `json:"folder,omitempty"`
+	Command          string               `json:"command,omitempty"`
+	Arguments        string               `json:"arguments,omitempty"`
+	WorkingDirectory string               `json:"workingDirectory,omitempty"`
+	Trigger          ScheduledTaskTrigger `json:"trigger"`
+	// User runs the task, SYSTEM is used if not set. Other accounts than
+	// the built in service accounts run the task only while logged on or
+	// as S4U without network access since no password is stored.
+	User string `json:"user,omitempty"`
+	// HighestPrivileges runs the task elevated.
+	HighestPrivileges bool  `json:"highestPrivileges,omitempty"`
+	State             State `json:"state,omitempty"`
+}
+
+// ScheduledTaskTrigger is when a scheduled task runs.
+type ScheduledTaskTrigger struct {
+	// Type is one of boot, logon, once, daily or weekly.
+	Type string `json:"type"`
+	// At is the start time as RFC 3339 for once triggers and 24 hour HH:MM
+	// local time for daily and weekly triggers.
+	At string `json:"at,omitempty"`
+	// DaysInterval runs a daily trigger every n days.
+	DaysInterval int `json:"daysInterval,omitempty"`
+	// DaysOfWeek are the days a weekly trigger runs, like monday.
+	DaysOfWeek []string `json:"daysOfWeek,omitempty"`
+}
+
+type scheduledTaskResource struct {
+	*ScheduledTaskResource
+
+	state             State
+	folder, user, tag string
+	triggerType       string
+	daysOfWeek        []string
+}
+
+func (s *scheduledTaskResource) validate(ctx context.Context) (*ManagedResources, error) {
+	if goos != "windows" {
+		return nil, errors.New("ScheduledTaskResource is only supported on Windows")
+	}
+	var err error
+	if s.state, err = s.State.validate(); err != nil {
+		return nil, fmt.Errorf("ScheduledTaskResource: %v", err)
+	}
+	if s.Name == "" || strings.ContainsAny(s.Name, `\/:*?"<>|`) {
+		return nil, fmt.Errorf("ScheduledTaskResource: invalid Name %q", s.Name)
+	}
+	s.folder = s.Folder
+	if s.folder == "" {
+		s.folder = `\`
+	}
+	// Task paths always start and end with a backslash.
+	if !strings.HasPrefix(s.folder, `\`) {
+		s.folder = `\` + s.folder
+	}
+	if !strings.HasSuffix(s.folder, `\`) {
+		s.folder += `\`
+	}
+	if s.state == StateAbsent {
+		return nil, nil
+	}
+
+	if s.Command == "" {
+		return nil, errors.New("ScheduledTaskResource: Command is required")
+	}
+	s.user = s.User
+	if s.user == "" {
+		s.user = "SYSTEM"
+	}
+
+	t := s.Trigger
+	s.triggerType = strings.ToLower(t.Type)
+	switch s.triggerType {
+	case TriggerBoot, TriggerLogon:
+	case TriggerOnce:
+		if _, err := time.Parse(time.RFC3339, t.At); err != nil {
+			return nil, fmt.Errorf("ScheduledTaskResource: invalid At %q for a once trigger, must be RFC 3339", t.At)
+		}
+	case TriggerDaily, TriggerWeekly:
+		if _, err := time.Parse("15:04", t.At); err != nil {
+			return nil, fmt.Errorf("ScheduledTaskResource: invalid At %q, must be HH:MM", t.At)
+		}
+		if t.DaysInterval < 0 || (t.DaysInterval > 0 && s.triggerType != TriggerDaily) {
+			return nil, fmt.Errorf("ScheduledTaskResource: invalid DaysInterval %d", t.DaysInterval)
+		}
+		if s.triggerType == TriggerWeekly && len(t.DaysOfWeek) == 0 {
+			return nil, errors.New("ScheduledTaskResource: weekly triggers require DaysOfWeek")
+		}
+	default:
+		return nil, fmt.Errorf("ScheduledTaskResource: unrecognized trigger Type %q", t.Type)
+	}
+	s.daysOfWeek = nil
+	for _, d := range t.DaysOfWeek {
+		day, ok := weekdays[strings.ToLower(d)]
+		if !ok || s.triggerType != TriggerWeekly {
+			return nil, fmt.Errorf("ScheduledTaskResource: invalid day of week %q", d)
+		}
+		s.daysOfWeek = append(s.daysOfWeek, day)
+	}
+
+	// The settings are hashed into the description so changes to the
+	// trigger, which are hard to compare, are detected.
+	b, err := json.Marshal(s.ScheduledTaskResource)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	s.tag = fmt.Sprintf("Managed by google-osconfig-agent %x", sum[:6])
+	return nil, nil
+}
+
+// scheduledTaskState is the subset of task settings checked for drift.
+type scheduledTaskState struct {
+	Description      string
+	Execute          string
+	Arguments        string
+	WorkingDirectory string
+	UserID           string
+}
+
+func (s *scheduledTaskResource) getTaskCommand() string {
+	return fmt.Sprintf(`$t = Get-ScheduledTask -TaskName %s -TaskPath %s -ErrorAction SilentlyContinue
+if ($t -eq $null) { exit 0 }
+@{Description = $t.Description; Execute = $t.Actions[0].Execute; Arguments = $t.Actions[0].Arguments; WorkingDirectory = $t.Actions[0].WorkingDirectory; UserID = $t.Principal.UserId} | ConvertTo-Json`, psQuote(s.Name), psQuote(s.folder))
+}
+
+// matches reports whether the current task settings are the desired ones,
+// the user is compared without the domain as Windows reports SYSTEM for
+// NT AUTHORITY\SYSTEM.
+func (s *scheduledTaskResource) matches(st *scheduledTaskState) bool {
+	trimDomain := func(u string) string {
+		if i := strings.LastIndex(u, `\`); i != -1 {
+			return u[i+1:]
+		}
+		return u
+	}
+	return st.Description == s.tag &&
+		st.Execute == s.Command &&
+		st.Arguments == s.Arguments &&
+		st.WorkingDirectory == s.WorkingDirectory &&
+		strings.EqualFold(trimDomain(st.UserID), trimDomain(s.user))
+}
+
+func (s *scheduledTaskResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	out, err := runPowerShell(ctx, s.getTaskCommand())
+	if err != nil {
+		return false, err
+	}
+	out = []byte(strings.TrimSpace(string(out)))
+	if len(out) == 0 {
+		return s.state == StateAbsent, nil
+	}
+	if s.state == StateAbsent {
+		return false, nil
+	}
+	var st scheduledTaskState
+	if err := json.Unmarshal(out, &st); err != nil {
+		return false, fmt.Errorf("error parsing scheduled task %q: %v", s.Name, err)
+	}
+	return s.matches(&st), nil
+}
+
+func (s *scheduledTaskResource) triggerCommand() string {
+	t := s.Trigger
+	switch s.triggerType {
+	case TriggerBoot:
+		return "New-ScheduledTaskTrigger -AtStartup"
+	case TriggerLogon:
+		return "New-ScheduledTaskTrigger -AtLogOn"
+	case TriggerOnce:
+		return fmt.Sprintf("New-ScheduledTaskTrigger -Once -At ([DateTime]::Parse(%s))", psQuote(t.At))
+	case TriggerDaily:
+		cmd := fmt.Sprintf("New-ScheduledTaskTrigger -Daily -At %s", psQuote(t.At))
+		if t.DaysInterval > 0 {
+			cmd += fmt.Sprintf(" -DaysInterval %d", t.DaysInterval)
+		}
+		return cmd
+	default:
+		return fmt.Sprintf("New-ScheduledTaskTrigger -Weekly -At %s -DaysOfWeek %s", psQuote(t.At), strings.Join(s.daysOfWeek, ","))
+	}
+}
+
+func (s *scheduledTaskResource) registerCommand() string {
+	action := "New-ScheduledTaskAction -Execute " + psQuote(s.Command)
+	if s.Arguments != "" {
+		action += " -Argument " + psQuote(s.Arguments)
+	}
+	if s.WorkingDirectory != "" {
+		action += " -WorkingDirectory " + psQuote(s.WorkingDirectory)
+	}
+	logonType := "S4U"
+	if serviceAccounts[strings.ToUpper(s.user)] {
+		logonType = "ServiceAccount"
+	}
+	principal := fmt.Sprintf("New-ScheduledTaskPrincipal -UserId %s -LogonType %s", psQuote(s.user), logonType)
+	if s.HighestPrivileges {
+		principal += " -RunLevel Highest"
+	}
+	return fmt.Sprintf("Register-ScheduledTask -TaskName %s -TaskPath %s -Description %s -Action (%s) -Trigger (%s) -Principal (%s) -Force | Out-Null",
+		psQuote(s.Name), psQuote(s.folder), psQuote(s.tag), action, s.triggerCommand(), principal)
+}
+
+func (s *scheduledTaskResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Enforcing state %q for scheduled task %q.", s.state, s.folder+s.Name)
+	cmd := fmt.Sprintf("Unregister-ScheduledTask -TaskName %s -TaskPath %s -Confirm:$false -ErrorAction SilentlyContinue", psQuote(s.Name), psQuote(s.folder))
+	if s.state == StatePresent {
+		// -Force replaces an existing task.
+		cmd = s.registerCommand()
+	}
+	if _, err := runPowerShell(ctx, cmd); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *scheduledTaskResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {
+}
+
+func (s *scheduledTaskResource) cleanup(ctx context.Context) error {
+	return nil
+}
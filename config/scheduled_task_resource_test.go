@@ -0,0 +1,129 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestScheduledTaskResourceValidate(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "windows"
+
+	var tests = []struct {
+		name    string
+		st      *ScheduledTaskResource
+		wantErr bool
+	}{
+		{"Boot", &ScheduledTaskResource{Name: "Cleanup", Command: `C:\cleanup.exe`, Trigger: ScheduledTaskTrigger{Type: "boot"}}, false},
+		{"Daily", &ScheduledTaskResource{Name: "Cleanup", Command: "cmd.exe", Trigger: ScheduledTaskTrigger{Type: "daily", At: "03:30", DaysInterval: 2}}, false},
+		{"Weekly", &ScheduledTaskResource{Name: "Cleanup", Command: "cmd.exe", Trigger: ScheduledTaskTrigger{Type: "Weekly", At: "23:00", DaysOfWeek: []string{"monday", "Friday"}}}, false},
+		{"Once", &ScheduledTaskResource{Name: "Cleanup", Command: "cmd.exe", Trigger: ScheduledTaskTrigger{Type: "once", At: "2024-06-01T10:00:00Z"}}, false},
+		{"Absent", &ScheduledTaskResource{Name: "Cleanup", Folder: `\Vendor`, State: "absent"}, false},
+		{"BadName", &ScheduledTaskResource{Name: `a\b`, Command: "cmd.exe", Trigger: ScheduledTaskTrigger{Type: "boot"}}, true},
+		{"NoCommand", &ScheduledTaskResource{Name: "Cleanup", Trigger: ScheduledTaskTrigger{Type: "boot"}}, true},
+		{"BadTrigger", &ScheduledTaskResource{Name: "Cleanup", Command: "cmd.exe", Trigger: ScheduledTaskTrigger{Type: "hourly"}}, true},
+		{"BadTime", &ScheduledTaskResource{Name: "Cleanup", Command: "cmd.exe", Trigger: ScheduledTaskTrigger{Type: "daily", At: "3pm"}}, true},
+		{"WeeklyNoDays", &ScheduledTaskResource{Name: "Cleanup", Command: "cmd.exe", Trigger: ScheduledTaskTrigger{Type: "weekly", At: "03:00"}}, true},
+		{"BadDay", &ScheduledTaskResource{Name: "Cleanup", Command: "cmd.exe", Trigger: ScheduledTaskTrigger{Type: "weekly", At: "03:00", DaysOfWeek: []string{"someday"}}}, true},
+		{"DaysOnDaily", &ScheduledTaskResource{Name: "Cleanup", Command: "cmd.exe", Trigger: ScheduledTaskTrigger{Type: "daily", At: "03:00", DaysOfWeek: []string{"monday"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{Local: &LocalResource{ScheduledTask: tt.st}}
+			err := pr.Validate(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	goos = "linux"
+	pr := &OSPolicyResource{Local: &LocalResource{ScheduledTask: &ScheduledTaskResource{Name: "Cleanup", Command: "cmd.exe", Trigger: ScheduledTaskTrigger{Type: "boot"}}}}
+	if err := pr.Validate(ctx); err == nil {
+		t.Error("Expected Validate error on Linux")
+	}
+}
+
+func TestScheduledTaskResourceRegisterCommand(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "windows"
+
+	s := &scheduledTaskResource{ScheduledTaskResource: &ScheduledTaskResource{
+		Name:              "Rotate logs",
+		Folder:            "Vendor",
+		Command:           `C:\Program Files\rotate.exe`,
+		Arguments:         "--keep '7'",
+		Trigger:           ScheduledTaskTrigger{Type: "weekly", At: "02:00", DaysOfWeek: []string{"sunday", "wednesday"}},
+		HighestPrivileges: true,
+	}}
+	if _, err := s.validate(ctx); err != nil {
+		t.Fatalf("Unexpected validate error: %v", err)
+	}
+	want := fmt.Sprintf(`Register-ScheduledTask -TaskName 'Rotate logs' -TaskPath '\Vendor\' -Description '%s' -Action (New-ScheduledTaskAction -Execute 'C:\Program Files\rotate.exe' -Argument '--keep ''7''') -Trigger (New-ScheduledTaskTrigger -Weekly -At '02:00' -DaysOfWeek Sunday,Wednesday) -Principal (New-ScheduledTaskPrincipal -UserId 'SYSTEM' -LogonType ServiceAccount -RunLevel Highest) -Force | Out-Null`, s.tag)
+	if got := s.registerCommand(); got != want {
+		t.Errorf("registerCommand() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestScheduledTaskResourceCheckState(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "windows"
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = mockCommandRunner
+
+	pr := &OSPolicyResource{Local: &LocalResource{ScheduledTask: &ScheduledTaskResource{Name: "Cleanup", Command: `C:\cleanup.exe`, Trigger: ScheduledTaskTrigger{Type: "boot"}}}}
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+	s := pr.resource.(*scheduledTaskResource)
+	getTask := exec.Command(powershell, "-NoProfile", "-NonInteractive", "-Command", "$ErrorActionPreference = 'Stop'; "+s.getTaskCommand())
+
+	var tests = []struct {
+		name string
+		out  string
+		want bool
+	}{
+		{"Missing", "", false},
+		{"Matching", fmt.Sprintf(`{"Description": %q, "Execute": "C:\\cleanup.exe", "Arguments": null, "WorkingDirectory": null, "UserID": "SYSTEM"}`, s.tag), true},
+		{"ChangedAction", fmt.Sprintf(`{"Description": %q, "Execute": "C:\\other.exe", "Arguments": null, "WorkingDirectory": null, "UserID": "SYSTEM"}`, s.tag), false},
+		{"ChangedSettings", `{"Description": "Managed by google-osconfig-agent 000000000000", "Execute": "C:\\cleanup.exe", "Arguments": null, "WorkingDirectory": null, "UserID": "SYSTEM"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(getTask)).Return([]byte(tt.out), nil, nil)
+			if err := pr.CheckState(ctx); err != nil {
+				t.Fatalf("Unexpected CheckState error: %v", err)
+			}
+			if got := pr.InDesiredState(); got != tt.want {
+				t.Errorf("InDesiredState() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
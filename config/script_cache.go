@@ -0,0 +1,155 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+var (
+	scriptCacheDir = filepath.Join(agentconfig.CacheDir(), "config_script_cache")
+	// Remove entries that were not used in the last 7 days.
+	scriptCacheTimeout = -168 * time.Hour
+	scriptCacheClient  = &http.Client{}
+)
+
+// scriptCacheEntry is the metadata of a cached remote script, the script
+// itself is stored next to it without the .json extension.
+type scriptCacheEntry struct {
+	URI          string `json:"uri"`
+	Checksum     string `json:"checksum,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// scriptCacheKey identifies a remote script by its URI and checksum, so a
+// policy pinning a different checksum for the same URI does not share the
+// cached copy.
+func scriptCacheKey(remote *agentendpointpb.OSPolicy_Resource_File_Remote) string {
+	sum := sha256.Sum256([]byte(remote.GetUri() + "\x00" + strings.ToLower(remote.GetSha256Checksum())))
+	return hex.EncodeToString(sum[:])
+}
+
+// downloadCachedScript writes the remote script to path, using the cached
+// copy if the server reports it is unchanged through a conditional GET on
+// its ETag or Last-Modified time. Responses without either are not cached.
+func downloadCachedScript(ctx context.Context, path string, perms os.FileMode, remote *agentendpointpb.OSPolicy_Resource_File_Remote) error {
+	key := scriptCacheKey(remote)
+	dataFile := filepath.Join(scriptCacheDir, key)
+	metaFile := dataFile + ".json"
+	if err := os.MkdirAll(scriptCacheDir, 0700); err != nil {
+		clog.Warningf(ctx, "Error creating script cache dir, downloading %q without it: %v", remote.GetUri(), err)
+		_, err := downloadFile(ctx, path, perms, &agentendpointpb.OSPolicy_Resource_File{Type: &agentendpointpb.OSPolicy_Resource_File_Remote_{Remote: remote}})
+		return err
+	}
+	pruneScriptCache(ctx, time.Now())
+
+	var entry scriptCacheEntry
+	cached := false
+	if b, err := os.ReadFile(metaFile); err == nil && json.Unmarshal(b, &entry) == nil && entry.URI == remote.GetUri() {
+		_, err := os.Stat(dataFile)
+		cached = err == nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, remote.GetUri(), nil)
+	if err != nil {
+		return err
+	}
+	if cached {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+	clog.Debugf(ctx, "Fetching remote script: %q", remote.GetUri())
+	resp, err := scriptCacheClient.Do(req)
+	if err != nil {
+		return errcode.Wrap(errcode.Network, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case cached && resp.StatusCode == http.StatusNotModified:
+		clog.Debugf(ctx, "Using cached copy of remote script %q.", remote.GetUri())
+		now := time.Now()
+		os.Chtimes(dataFile, now, now)
+	case resp.StatusCode == http.StatusOK:
+		if _, err := util.AtomicWriteFileStream(resp.Body, remote.GetSha256Checksum(), dataFile, 0600); err != nil {
+			return err
+		}
+		entry = scriptCacheEntry{URI: remote.GetUri(), Checksum: remote.GetSha256Checksum(), ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+		if entry.ETag == "" && entry.LastModified == "" {
+			os.Remove(metaFile)
+			break
+		}
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := util.AtomicWrite(metaFile, b, 0600); err != nil {
+			clog.Warningf(ctx, "Error writing script cache entry for %q: %v", remote.GetUri(), err)
+		}
+	default:
+		return errcode.Wrap(errcode.Network, fmt.Errorf("got http status %d when attempting to download artifact", resp.StatusCode))
+	}
+
+	f, err := os.Open(dataFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = util.AtomicWriteFileStream(f, remote.GetSha256Checksum(), path, perms)
+	return err
+}
+
+// pruneScriptCache removes cached scripts that were not used within
+// scriptCacheTimeout.
+func pruneScriptCache(ctx context.Context, now time.Time) {
+	entries, err := os.ReadDir(scriptCacheDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil || fi.ModTime().After(now.Add(scriptCacheTimeout)) {
+			continue
+		}
+		clog.Debugf(ctx, "Removing unused script %q from the script cache.", e.Name())
+		dataFile := filepath.Join(scriptCacheDir, e.Name())
+		os.Remove(dataFile)
+		os.Remove(dataFile + ".json")
+	}
+}
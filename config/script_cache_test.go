@@ -0,0 +1,125 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+func TestDownloadCachedScript(t *testing.T) {
+	ctx := context.Background()
+	defer func(d string) { scriptCacheDir = d }(scriptCacheDir)
+	scriptCacheDir = filepath.Join(t.TempDir(), "cache")
+
+	content, etag := "echo one", `"1"`
+	var downloads, notModified int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/noetag" {
+			downloads++
+			fmt.Fprint(w, content)
+			return
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, content)
+	}))
+	defer ts.Close()
+
+	fetch := func(remote *agentendpointpb.OSPolicy_Resource_File_Remote) (string, error) {
+		path := filepath.Join(t.TempDir(), "script")
+		if err := downloadCachedScript(ctx, path, 0755, remote); err != nil {
+			return "", err
+		}
+		b, err := os.ReadFile(path)
+		return string(b), err
+	}
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+
+	tests := []struct {
+		desc                           string
+		remote                         *agentendpointpb.OSPolicy_Resource_File_Remote
+		content, etag                  string
+		want                           string
+		wantDownloads, wantNotModified int
+		wantErr                        bool
+	}{
+		{"first fetch downloads", &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: ts.URL + "/s"}, "echo one", `"1"`, "echo one", 1, 0, false},
+		{"unchanged script is revalidated", &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: ts.URL + "/s"}, "echo one", `"1"`, "echo one", 1, 1, false},
+		{"changed script is downloaded", &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: ts.URL + "/s"}, "echo two", `"2"`, "echo two", 2, 1, false},
+		{"checksum is part of the key", &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: ts.URL + "/s", Sha256Checksum: sum("echo two")}, "echo two", `"2"`, "echo two", 3, 1, false},
+		{"cached with checksum", &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: ts.URL + "/s", Sha256Checksum: sum("echo two")}, "echo two", `"2"`, "echo two", 3, 2, false},
+		{"checksum mismatch", &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: ts.URL + "/s", Sha256Checksum: sum("other")}, "echo two", `"2"`, "", 4, 2, true},
+		{"no validator is not cached", &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: ts.URL + "/noetag"}, "echo two", `"2"`, "echo two", 5, 2, false},
+		{"no validator downloads again", &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: ts.URL + "/noetag"}, "echo two", `"2"`, "echo two", 6, 2, false},
+	}
+	for _, tt := range tests {
+		content, etag = tt.content, tt.etag
+		got, err := fetch(tt.remote)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.desc, got, tt.want)
+		}
+		if downloads != tt.wantDownloads || notModified != tt.wantNotModified {
+			t.Errorf("%s: got %d downloads and %d not modified, want %d and %d", tt.desc, downloads, notModified, tt.wantDownloads, tt.wantNotModified)
+		}
+	}
+}
+
+func TestPruneScriptCache(t *testing.T) {
+	defer func(d string) { scriptCacheDir = d }(scriptCacheDir)
+	scriptCacheDir = t.TempDir()
+
+	now := time.Now()
+	for name, age := range map[string]time.Duration{"old": 200 * time.Hour, "new": time.Hour} {
+		for _, f := range []string{name, name + ".json"} {
+			path := filepath.Join(scriptCacheDir, f)
+			if err := os.WriteFile(path, nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	pruneScriptCache(context.Background(), now)
+	for f, want := range map[string]bool{"old": false, "old.json": false, "new": true, "new.json": true} {
+		_, err := os.Stat(filepath.Join(scriptCacheDir, f))
+		if got := err == nil; got != want {
+			t.Errorf("%s exists: got %t, want %t", f, got, want)
+		}
+	}
+}
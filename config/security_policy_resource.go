@@ -0,0 +1,241 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+var secedit = `C:\Windows\System32\secedit.exe`
+
+// secedit template sections.
+const (
+	systemAccessSection    = "System Access"
+	eventAuditSection      = "Event Audit"
+	privilegeRightsSection = "Privilege Rights"
+)
+
+// SecurityPolicyResource ensures local security policy settings on Windows
+// using secedit security templates. Only the given settings are compared and
+// applied, all others are left as they are.
+type SecurityPolicyResource struct {
+	// SystemAccess are password and account lockout policy settings, e.g.
+	// MinimumPasswordLength: 14.
+	SystemAccess map[string]string `json:"systemAccess,omitempty"`
+	// EventAudit are audit policy settings, 0 for no auditing, 1 for
+	// success, 2 for failure and 3 for both, e.g. AuditLogonEvents: 3.
+	EventAudit map[string]string `json:"eventAudit,omitempty"`
+	// PrivilegeRights are user rights assignments, e.g.
+	// SeRemoteInteractiveLogonRight: ["*S-1-5-32-544"]. Accounts should be
+	// given as SIDs prefixed with * since secedit reports them that way.
+	PrivilegeRights map[string][]string `json:"privilegeRights,omitempty"`
+}
+
+type securityPolicyResource struct {
+	*SecurityPolicyResource
+}
+
+func (s *securityPolicyResource) validate(ctx context.Context) (*ManagedResources, error) {
+	if goos != "windows" {
+		return nil, errors.New("SecurityPolicyResource is only supported on Windows")
+	}
+	if len(s.SystemAccess)+len(s.EventAudit)+len(s.PrivilegeRights) == 0 {
+		return nil, errors.New("SecurityPolicyResource: at least one setting is required")
+	}
+	for _, m := range []map[string]string{s.SystemAccess, s.EventAudit} {
+		for k, v := range m {
+			if !validINFKey(k) || strings.ContainsAny(v, "\r\n") {
+				return nil, fmt.Errorf("SecurityPolicyResource: invalid setting %q = %q", k, v)
+			}
+		}
+	}
+	for k, accounts := range s.PrivilegeRights {
+		if !validINFKey(k) {
+			return nil, fmt.Errorf("SecurityPolicyResource: invalid privilege %q", k)
+		}
+		for _, a := range accounts {
+			if a == "" || strings.ContainsAny(a, ",\r\n") {
+				return nil, fmt.Errorf("SecurityPolicyResource: invalid account %q for privilege %q", a, k)
+			}
+		}
+	}
+	return nil, nil
+}
+
+func validINFKey(k string) bool {
+	return k != "" && !strings.ContainsAny(k, "=[]\r\n ")
+}
+
+// decodeINF decodes a secedit template, these are written as UTF-16LE.
+func decodeINF(data []byte) string {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xfe}) {
+		return string(data)
+	}
+	data = data[2:]
+	u := make([]uint16, len(data)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func encodeINF(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2+len(u)*2)
+	b[0], b[1] = 0xff, 0xfe
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2+i*2:], c)
+	}
+	return b
+}
+
+// parseINF returns the key values by lowercased section and key name.
+func parseINF(s string) map[string]map[string]string {
+	sections := map[string]map[string]string{}
+	var cur map[string]string
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		ln := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(ln, "[") && strings.HasSuffix(ln, "]") {
+			name := strings.ToLower(strings.TrimSpace(ln[1 : len(ln)-1]))
+			cur = map[string]string{}
+			sections[name] = cur
+			continue
+		}
+		k, v, ok := strings.Cut(ln, "=")
+		if !ok || cur == nil {
+			continue
+		}
+		cur[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	}
+	return sections
+}
+
+func accountSet(v string) []string {
+	var accounts []string
+	for _, a := range strings.Split(v, ",") {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			accounts = append(accounts, a)
+		}
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+// compliant reports whether the exported policy has all desired settings.
+func (s *securityPolicyResource) compliant(exported map[string]map[string]string) bool {
+	for section, settings := range map[string]map[string]string{systemAccessSection: s.SystemAccess, eventAuditSection: s.EventAudit} {
+		have := exported[strings.ToLower(section)]
+		for k, v := range settings {
+			// Strings are exported quoted, so compare without quotes.
+			if strings.Trim(have[strings.ToLower(k)], `"`) != strings.Trim(strings.TrimSpace(v), `"`) {
+				return false
+			}
+		}
+	}
+	have := exported[strings.ToLower(privilegeRightsSection)]
+	for k, accounts := range s.PrivilegeRights {
+		if !linesEqual(accountSet(have[strings.ToLower(k)]), accountSet(strings.Join(accounts, ","))) {
+			return false
+		}
+	}
+	return true
+}
+
+// template returns a secedit template with the desired settings.
+func (s *securityPolicyResource) template() string {
+	var b strings.Builder
+	b.WriteString("[Unicode]\r\nUnicode=yes\r\n[Version]\r\nsignature=\"$CHICAGO$\"\r\nRevision=1\r\n")
+	writeSection := func(name string, settings map[string]string) {
+		if len(settings) == 0 {
+			return
+		}
+		var keys []string
+		for k := range settings {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(&b, "[%s]\r\n", name)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s = %s\r\n", k, settings[k])
+		}
+	}
+	writeSection(systemAccessSection, s.SystemAccess)
+	writeSection(eventAuditSection, s.EventAudit)
+	rights := map[string]string{}
+	for k, accounts := range s.PrivilegeRights {
+		rights[k] = strings.Join(accounts, ",")
+	}
+	writeSection(privilegeRightsSection, rights)
+	return b.String()
+}
+
+func (s *securityPolicyResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	dir, err := os.MkdirTemp("", "osconfig_secedit")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := filepath.Join(dir, "export.inf")
+	if _, err := runCmd(ctx, secedit, "/export", "/cfg", cfg, "/areas", "SECURITYPOLICY", "USER_RIGHTS", "/quiet"); err != nil {
+		return false, err
+	}
+	data, err := os.ReadFile(cfg)
+	if err != nil {
+		return false, err
+	}
+	return s.compliant(parseINF(decodeINF(data))), nil
+}
+
+func (s *securityPolicyResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Enforcing local security policy settings.")
+	dir, err := os.MkdirTemp("", "osconfig_secedit")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := filepath.Join(dir, "policy.inf")
+	if err := os.WriteFile(cfg, encodeINF(s.template()), 0600); err != nil {
+		return false, err
+	}
+	// A new database is used so only the settings in the template apply.
+	if _, err := runCmd(ctx, secedit, "/configure", "/db", filepath.Join(dir, "policy.sdb"), "/cfg", cfg, "/areas", "SECURITYPOLICY", "USER_RIGHTS", "/quiet"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *securityPolicyResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {
+}
+
+func (s *securityPolicyResource) cleanup(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,120 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+const testSeceditExport = "[Unicode]\r\nUnicode=yes\r\n[System Access]\r\nMinimumPasswordAge = 0\r\nMinimumPasswordLength = 0\r\nNewAdministratorName = \"Administrator\"\r\n[Event Audit]\r\nAuditLogonEvents = 0\r\n[Privilege Rights]\r\nSeRemoteInteractiveLogonRight = *S-1-5-32-544,*S-1-5-32-555\r\n"
+
+func TestSecurityPolicyResourceValidate(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "windows"
+
+	var tests = []struct {
+		name    string
+		sp      *SecurityPolicyResource
+		wantErr bool
+	}{
+		{"Valid", &SecurityPolicyResource{SystemAccess: map[string]string{"MinimumPasswordLength": "14"}, PrivilegeRights: map[string][]string{"SeDenyNetworkLogonRight": {"*S-1-5-32-546"}}}, false},
+		{"Empty", &SecurityPolicyResource{}, true},
+		{"BadKey", &SecurityPolicyResource{EventAudit: map[string]string{"Audit Logon": "3"}}, true},
+		{"BadAccount", &SecurityPolicyResource{PrivilegeRights: map[string][]string{"SeDenyNetworkLogonRight": {"a,b"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{Local: &LocalResource{SecurityPolicy: tt.sp}}
+			err := pr.Validate(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestINFEncoding(t *testing.T) {
+	if got := decodeINF(encodeINF(testSeceditExport)); got != testSeceditExport {
+		t.Errorf("decodeINF(encodeINF()) = %q, want %q", got, testSeceditExport)
+	}
+	if got := decodeINF([]byte("[Version]")); got != "[Version]" {
+		t.Errorf("decodeINF() of plain text = %q", got)
+	}
+}
+
+func TestSecurityPolicyResourceCompliant(t *testing.T) {
+	exported := parseINF(testSeceditExport)
+	var tests = []struct {
+		name string
+		sp   *SecurityPolicyResource
+		want bool
+	}{
+		{"Matching", &SecurityPolicyResource{
+			SystemAccess:    map[string]string{"minimumpasswordlength": "0", "NewAdministratorName": `"Administrator"`},
+			PrivilegeRights: map[string][]string{"SeRemoteInteractiveLogonRight": {"*S-1-5-32-555", "*s-1-5-32-544"}},
+		}, true},
+		{"DifferentValue", &SecurityPolicyResource{EventAudit: map[string]string{"AuditLogonEvents": "3"}}, false},
+		{"MissingSetting", &SecurityPolicyResource{SystemAccess: map[string]string{"LockoutBadCount": "5"}}, false},
+		{"ExtraAccount", &SecurityPolicyResource{PrivilegeRights: map[string][]string{"SeRemoteInteractiveLogonRight": {"*S-1-5-32-544"}}}, false},
+		{"EmptyRight", &SecurityPolicyResource{PrivilegeRights: map[string][]string{"SeDenyNetworkLogonRight": nil}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &securityPolicyResource{SecurityPolicyResource: tt.sp}
+			if got := s.compliant(exported); got != tt.want {
+				t.Errorf("compliant() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecurityPolicyResourceCheckState(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "windows"
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = mockCommandRunner
+
+	pr := &OSPolicyResource{Local: &LocalResource{SecurityPolicy: &SecurityPolicyResource{SystemAccess: map[string]string{"MinimumPasswordLength": "14"}}}}
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+
+	// secedit writes the export to the path following /cfg.
+	mockCommandRunner.EXPECT().Run(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, cmd *exec.Cmd) ([]byte, []byte, error) {
+		if cmd.Path != secedit || cmd.Args[1] != "/export" {
+			t.Fatalf("Unexpected command %q", cmd.Args)
+		}
+		return nil, nil, os.WriteFile(cmd.Args[3], encodeINF(testSeceditExport), 0600)
+	})
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if pr.InDesiredState() {
+		t.Error("Unexpected InDesiredState with a shorter minimum password length")
+	}
+}
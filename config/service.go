@@ -0,0 +1,92 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var (
+	systemctl  = "/bin/systemctl"
+	powershell = `C:\Windows\System32\WindowsPowerShell\v1.0\PowerShell.exe`
+)
+
+// runCmd runs a command and returns its stdout, the error includes the
+// command output.
+func runCmd(ctx context.Context, cmd string, args ...string) ([]byte, error) {
+	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, cmd, args...))
+	if err != nil {
+		return stdout, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", cmd, args, err, stdout, stderr)
+	}
+	return stdout, nil
+}
+
+// runPowerShell runs a PowerShell command, any error terminates the command
+// so that it is reflected in the exit code.
+func runPowerShell(ctx context.Context, command string) ([]byte, error) {
+	return runCmd(ctx, powershell, "-NoProfile", "-NonInteractive", "-Command", "$ErrorActionPreference = 'Stop'; "+command)
+}
+
+// psQuote quotes s as a PowerShell single quoted string.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// serviceRunning reports whether a service is running and set to start at
+// boot.
+func serviceRunning(ctx context.Context, name string) (bool, error) {
+	if goos == "windows" {
+		out, err := runPowerShell(ctx, fmt.Sprintf("$s = Get-Service -Name '%s'; $s.Status -eq 'Running' -and $s.StartType -eq 'Automatic'", name))
+		if err != nil {
+			return false, err
+		}
+		return strings.TrimSpace(string(out)) == "True", nil
+	}
+
+	// Both return a non zero exit code for inactive or disabled units.
+	if _, err := runCmd(ctx, systemctl, "is-active", "--quiet", name); err != nil {
+		return false, nil
+	}
+	if _, err := runCmd(ctx, systemctl, "is-enabled", "--quiet", name); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// startService sets a service to start at boot and starts or, if restart is
+// set, restarts it.
+func startService(ctx context.Context, name string, restart bool) error {
+	if goos == "windows" {
+		cmd := "Start-Service"
+		if restart {
+			cmd = "Restart-Service"
+		}
+		_, err := runPowerShell(ctx, fmt.Sprintf("Set-Service -Name '%s' -StartupType Automatic; %s -Name '%s'", name, cmd, name))
+		return err
+	}
+
+	if _, err := runCmd(ctx, systemctl, "enable", name); err != nil {
+		return err
+	}
+	cmd := "start"
+	if restart {
+		cmd = "restart"
+	}
+	_, err := runCmd(ctx, systemctl, cmd, name)
+	return err
+}
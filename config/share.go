@@ -0,0 +1,117 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+const (
+	smbclientCmd = "smbclient"
+	// nfs-cp is part of libnfs-utils.
+	nfsCpCmd = "nfs-cp"
+)
+
+// isShareURI reports whether uri is on an NFS or SMB file share.
+func isShareURI(uri string) bool {
+	return strings.HasPrefix(uri, "nfs://") || strings.HasPrefix(uri, "smb://")
+}
+
+// parseShareURI splits smb://host/share/dir/file or nfs://host/export/file
+// into the host and the path on it.
+func parseShareURI(uri string) (string, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", err
+	}
+	p := strings.Trim(u.Path, "/")
+	if u.Host == "" || !strings.Contains(p, "/") {
+		return "", "", fmt.Errorf("invalid file share URI %q, must be %s://host/share/path", uri, u.Scheme)
+	}
+	return u.Host, p, nil
+}
+
+// validSMBPath reports an error if p can not be passed to an smbclient -c
+// command as is: smbclient splits commands on ';', runs a shell for '!' and
+// has no escaping for quotes.
+func validSMBPath(p string) error {
+	for _, r := range p {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`;!"'`+"`", r) {
+			return fmt.Errorf("path %q must not contain quotes, ';', '!' or control characters", p)
+		}
+	}
+	return nil
+}
+
+// downloadShareFile copies the file at an nfs:// or smb:// URI to path
+// without mounting the share. Windows reads the share through its UNC path,
+// other systems use smbclient, with anonymous or guest access, or nfs-cp
+// from libnfs, which have to be installed.
+func downloadShareFile(ctx context.Context, path string, perms os.FileMode, remote *agentendpointpb.OSPolicy_Resource_File_Remote) (string, error) {
+	host, sharePath, err := parseShareURI(remote.GetUri())
+	if err != nil {
+		return "", err
+	}
+
+	var r io.Reader
+	if goos == "windows" {
+		f, err := os.Open(`\\` + host + `\` + strings.ReplaceAll(sharePath, "/", `\`))
+		if err != nil {
+			return "", errcode.Wrap(errcode.Network, err)
+		}
+		defer f.Close()
+		r = f
+	} else {
+		tmp := path + ".share"
+		defer os.Remove(tmp)
+		var cmd *exec.Cmd
+		if strings.HasPrefix(remote.GetUri(), "smb://") {
+			share, file, _ := strings.Cut(sharePath, "/")
+			for _, p := range []string{file, tmp} {
+				if err := validSMBPath(p); err != nil {
+					return "", fmt.Errorf("invalid file share URI %q: %v", remote.GetUri(), err)
+				}
+			}
+			cmd = exec.CommandContext(ctx, smbclientCmd, "//"+host+"/"+share, "-N", "-c", fmt.Sprintf(`get "%s" "%s"`, file, tmp))
+		} else {
+			cmd = exec.CommandContext(ctx, nfsCpCmd, remote.GetUri(), tmp)
+		}
+		if _, stderr, err := runner.Run(ctx, cmd); err != nil {
+			if errors.Is(err, exec.ErrNotFound) {
+				return "", fmt.Errorf("%s is required to download %q without mounting the share, install it or mount the share and use a local path", cmd.Args[0], remote.GetUri())
+			}
+			return "", errcode.Wrap(errcode.Network, fmt.Errorf("error downloading %q: %v, stderr: %q", remote.GetUri(), err, stderr))
+		}
+		f, err := os.Open(tmp)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	}
+	return util.AtomicWriteFileStream(r, remote.GetSha256Checksum(), path, perms)
+}
@@ -0,0 +1,155 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+func TestParseShareURI(t *testing.T) {
+	tests := []struct {
+		uri, host, path string
+		wantErr         bool
+	}{
+		{"smb://files.example.com/artifacts/dir/script.sh", "files.example.com", "artifacts/dir/script.sh", false},
+		{"nfs://10.0.0.2/export/pkg.deb", "10.0.0.2", "export/pkg.deb", false},
+		{"smb://files.example.com/artifacts", "", "", true},
+		{"nfs:///export/pkg.deb", "", "", true},
+	}
+	for _, tt := range tests {
+		host, path, err := parseShareURI(tt.uri)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseShareURI(%q) error = %v, want error %t", tt.uri, err, tt.wantErr)
+			continue
+		}
+		if host != tt.host || path != tt.path {
+			t.Errorf("parseShareURI(%q) = (%q, %q), want (%q, %q)", tt.uri, host, path, tt.host, tt.path)
+		}
+	}
+}
+
+func TestDownloadShareFile(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = mockCommandRunner
+
+	const sum = "d1b2a59fbea7e20077af9f91b27e95e865061b270be03ff539ab3b73587882e8"
+
+	tests := []struct {
+		desc     string
+		uri      string
+		checksum string
+		wantCmd  func(tmp string) *exec.Cmd
+		runErr   error
+		wantErr  bool
+	}{
+		{
+			desc:     "smb",
+			uri:      "smb://files.example.com/artifacts/dir/script.sh",
+			checksum: sum,
+			wantCmd: func(tmp string) *exec.Cmd {
+				return exec.Command(smbclientCmd, "//files.example.com/artifacts", "-N", "-c", `get "dir/script.sh" "`+tmp+`"`)
+			},
+		},
+		{
+			desc:    "nfs",
+			uri:     "nfs://10.0.0.2/export/pkg.deb",
+			wantCmd: func(tmp string) *exec.Cmd { return exec.Command(nfsCpCmd, "nfs://10.0.0.2/export/pkg.deb", tmp) },
+		},
+		{
+			desc:     "checksum mismatch",
+			uri:      "nfs://10.0.0.2/export/pkg.deb",
+			checksum: "abc",
+			wantCmd:  func(tmp string) *exec.Cmd { return exec.Command(nfsCpCmd, "nfs://10.0.0.2/export/pkg.deb", tmp) },
+			wantErr:  true,
+		},
+		{
+			desc:    "share error",
+			uri:     "nfs://10.0.0.2/export/pkg.deb",
+			wantCmd: func(tmp string) *exec.Cmd { return exec.Command(nfsCpCmd, "nfs://10.0.0.2/export/pkg.deb", tmp) },
+			runErr:  errors.New("exit status 1"),
+			wantErr: true,
+		},
+		{
+			desc:    "quote in path",
+			uri:     `smb://files.example.com/artifacts/a"b`,
+			wantErr: true,
+		},
+		{
+			desc:    "single quote in path",
+			uri:     `smb://files.example.com/artifacts/a'b`,
+			wantErr: true,
+		},
+		{
+			desc:    "command separator in path",
+			uri:     "smb://files.example.com/artifacts/a;rm b",
+			wantErr: true,
+		},
+		{
+			desc:    "shell escape in path",
+			uri:     "smb://files.example.com/artifacts/!id",
+			wantErr: true,
+		},
+		{
+			desc:    "control character in path",
+			uri:     "smb://files.example.com/artifacts/a%0Ab",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "file")
+		if tt.wantCmd != nil {
+			tmp := path + ".share"
+			mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(tt.wantCmd(tmp))).DoAndReturn(func(context.Context, *exec.Cmd) ([]byte, []byte, error) {
+				if tt.runErr != nil {
+					return nil, []byte("permission denied"), tt.runErr
+				}
+				return nil, nil, os.WriteFile(tmp, []byte("contents"), 0600)
+			})
+		}
+		file := &agentendpointpb.OSPolicy_Resource_File{Type: &agentendpointpb.OSPolicy_Resource_File_Remote_{Remote: &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: tt.uri, Sha256Checksum: tt.checksum}}}
+		_, err := downloadFile(ctx, path, 0644, file)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: downloadFile() error = %v, want error %t", tt.desc, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if b, err := os.ReadFile(path); err != nil || string(b) != "contents" {
+			t.Errorf("%s: got contents %q, %v, want %q", tt.desc, b, err, "contents")
+		}
+		if _, err := os.Stat(path + ".share"); !os.IsNotExist(err) {
+			t.Errorf("%s: temp file was not removed: %v", tt.desc, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// sshDir is the open .ssh directory of a user. Everything from the home
+// directory down is opened relative to its parent without following
+// symlinks, a user can't redirect the agent to another file.
+type sshDir struct {
+	fd   int
+	path string
+}
+
+const openDirFlags = unix.O_RDONLY | unix.O_DIRECTORY | unix.O_NOFOLLOW | unix.O_CLOEXEC
+
+// openSSHDir opens the .ssh directory in home, with create it is made for
+// uid and gid when missing, without a nil sshDir is returned. The directory
+// holding home must belong to the agent user, usually root.
+func openSSHDir(home string, create bool, uid, gid int) (*sshDir, error) {
+	parent, err := filepath.EvalSymlinks(filepath.Dir(home))
+	if err != nil {
+		return nil, err
+	}
+	pfd, err := unix.Open(parent, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %q: %v", parent, err)
+	}
+	defer unix.Close(pfd)
+	var st unix.Stat_t
+	if err := unix.Fstat(pfd, &st); err != nil {
+		return nil, err
+	}
+	if int(st.Uid) != os.Geteuid() {
+		return nil, fmt.Errorf("%q holding home directory %q is owned by uid %d", parent, home, st.Uid)
+	}
+
+	hfd, err := unix.Openat(pfd, filepath.Base(home), openDirFlags, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening home directory %q, symlinks are not followed: %v", home, err)
+	}
+	defer unix.Close(hfd)
+
+	path := filepath.Join(home, ".ssh")
+	fd, err := unix.Openat(hfd, ".ssh", openDirFlags, 0)
+	if err == unix.ENOENT && create {
+		if err := unix.Mkdirat(hfd, ".ssh", 0700); err != nil && err != unix.EEXIST {
+			return nil, fmt.Errorf("error creating %q: %v", path, err)
+		}
+		if fd, err = unix.Openat(hfd, ".ssh", openDirFlags, 0); err == nil {
+			if err := unix.Fchown(fd, uid, gid); err != nil {
+				unix.Close(fd)
+				return nil, err
+			}
+		}
+	}
+	if err == unix.ENOENT {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening %q, symlinks are not followed: %v", path, err)
+	}
+	return &sshDir{fd: fd, path: path}, nil
+}
+
+func (d *sshDir) close() {
+	unix.Close(d.fd)
+}
+
+// readFile reads the regular file name, a missing file has no content.
+func (d *sshDir) readFile(name string) ([]byte, os.FileMode, error) {
+	path := filepath.Join(d.path, name)
+	fd, err := unix.Openat(d.fd, name, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err == unix.ENOENT {
+		return nil, 0644, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening %q, symlinks are not followed: %v", path, err)
+	}
+	f := os.NewFile(uintptr(fd), path)
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, 0, fmt.Errorf("%q is not a regular file", path)
+	}
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	if content == nil {
+		content = []byte{}
+	}
+	return content, fi.Mode().Perm(), nil
+}
+
+// writeFile atomically replaces name with content, owned by uid and gid. A
+// new file is written and renamed over name, a symlink at name is replaced,
+// not followed.
+func (d *sshDir) writeFile(name string, content []byte, mode os.FileMode, uid, gid int) error {
+	tmp := name + ".osconfig"
+	if err := unix.Unlinkat(d.fd, tmp, 0); err != nil && err != unix.ENOENT {
+		return err
+	}
+	fd, err := unix.Openat(d.fd, tmp, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, uint32(mode.Perm()))
+	if err != nil {
+		return err
+	}
+	f := os.NewFile(uintptr(fd), filepath.Join(d.path, tmp))
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Chown(uid, gid)
+	}
+	if err == nil {
+		err = f.Chmod(mode.Perm())
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = unix.Renameat(d.fd, tmp, d.fd, name)
+	}
+	if err != nil {
+		unix.Unlinkat(d.fd, tmp, 0)
+	}
+	return err
+}
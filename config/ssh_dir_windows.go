@@ -0,0 +1,37 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"os"
+)
+
+// sshDir is not supported, authorized keys are only managed on Linux.
+type sshDir struct{}
+
+func openSSHDir(home string, create bool, uid, gid int) (*sshDir, error) {
+	return nil, errors.New("authorized keys are not supported on Windows")
+}
+
+func (d *sshDir) close() {}
+
+func (d *sshDir) readFile(name string) ([]byte, os.FileMode, error) {
+	return nil, 0, errors.New("authorized keys are not supported on Windows")
+}
+
+func (d *sshDir) writeFile(name string, content []byte, mode os.FileMode, uid, gid int) error {
+	return errors.New("authorized keys are not supported on Windows")
+}
@@ -0,0 +1,283 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"golang.org/x/crypto/ssh"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+var (
+	sshd           = "/usr/sbin/sshd"
+	sshdConfigPath = "/etc/ssh/sshd_config"
+	lookupUser     = user.Lookup
+)
+
+// AuthorizedKeyResource ensures an SSH public key is present in or absent
+// from a user's authorized_keys file. Keys are matched by fingerprint.
+type AuthorizedKeyResource struct {
+	User string `json:"user"`
+	// Key is an authorized_keys line, options are allowed. Only the
+	// Fingerprint is required for the absent state.
+	Key         string `json:"key,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	State       State  `json:"state,omitempty"`
+}
+
+type authorizedKeyResource struct {
+	*AuthorizedKeyResource
+
+	state       State
+	home, path  string
+	fingerprint string
+	uid, gid    int
+}
+
+func (a *authorizedKeyResource) validate(ctx context.Context) (*ManagedResources, error) {
+	if goos == "windows" {
+		return nil, errors.New("AuthorizedKeyResource is not supported on Windows")
+	}
+	var err error
+	if a.state, err = a.State.validate(); err != nil {
+		return nil, fmt.Errorf("AuthorizedKeyResource: %v", err)
+	}
+
+	a.fingerprint = a.Fingerprint
+	if a.Key != "" {
+		pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(a.Key))
+		if err != nil {
+			return nil, fmt.Errorf("AuthorizedKeyResource: invalid key: %v", err)
+		}
+		fp := ssh.FingerprintSHA256(pk)
+		if a.fingerprint != "" && a.fingerprint != fp {
+			return nil, fmt.Errorf("AuthorizedKeyResource: fingerprint %q does not match key fingerprint %q", a.fingerprint, fp)
+		}
+		a.fingerprint = fp
+	}
+	switch {
+	case a.state == StatePresent && a.Key == "":
+		return nil, errors.New("AuthorizedKeyResource: Key is required for the present state")
+	case a.fingerprint == "":
+		return nil, errors.New("AuthorizedKeyResource: one of Key or Fingerprint is required")
+	}
+
+	u, err := lookupUser(a.User)
+	if err != nil {
+		return nil, fmt.Errorf("AuthorizedKeyResource: %v", err)
+	}
+	if a.uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, fmt.Errorf("AuthorizedKeyResource: unexpected uid %q for user %q", u.Uid, a.User)
+	}
+	if a.gid, err = strconv.Atoi(u.Gid); err != nil {
+		return nil, fmt.Errorf("AuthorizedKeyResource: unexpected gid %q for user %q", u.Gid, a.User)
+	}
+	a.home = filepath.Clean(u.HomeDir)
+	a.path = filepath.Join(a.home, ".ssh", "authorized_keys")
+	return nil, nil
+}
+
+func keyFingerprint(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(pk)
+}
+
+// update returns the authorized_keys lines with the key made present or
+// absent and whether anything changed.
+func (a *authorizedKeyResource) update(lines []string) ([]string, bool) {
+	var out []string
+	var found, changed bool
+	for _, ln := range lines {
+		if keyFingerprint(ln) == a.fingerprint {
+			if a.state == StateAbsent {
+				changed = true
+				continue
+			}
+			found = true
+		}
+		out = append(out, ln)
+	}
+	if a.state == StatePresent && !found {
+		out = append(out, strings.TrimSpace(a.Key))
+		changed = true
+	}
+	return out, changed
+}
+
+// The user controls their home directory, so authorized_keys is only
+// accessed through an sshDir, which refuses symlinks, and is never read,
+// written or chowned by path.
+func (a *authorizedKeyResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	dir, err := openSSHDir(a.home, false, a.uid, a.gid)
+	if err != nil {
+		return false, err
+	}
+	var lines []string
+	if dir != nil {
+		defer dir.close()
+		content, _, err := dir.readFile("authorized_keys")
+		if err != nil {
+			return false, err
+		}
+		lines = splitLines(content)
+	}
+	_, changed := a.update(lines)
+	return !changed, nil
+}
+
+func (a *authorizedKeyResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Enforcing state %q for authorized key %s of user %q.", a.state, a.fingerprint, a.User)
+	dir, err := openSSHDir(a.home, true, a.uid, a.gid)
+	if err != nil {
+		return false, err
+	}
+	defer dir.close()
+	content, mode, err := dir.readFile("authorized_keys")
+	if err != nil {
+		return false, err
+	}
+	lines, changed := a.update(splitLines(content))
+	if !changed {
+		return true, nil
+	}
+	if content == nil {
+		mode = 0600
+	}
+	if err := dir.writeFile("authorized_keys", joinLines(lines), mode, a.uid, a.gid); err != nil {
+		return false, fmt.Errorf("error writing %q: %v", a.path, err)
+	}
+	return true, nil
+}
+
+func (a *authorizedKeyResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {
+}
+
+func (a *authorizedKeyResource) cleanup(ctx context.Context) error {
+	return nil
+}
+
+// SSHDConfigResource ensures sshd_config directives are set. The directives
+// are placed at the top of the file as sshd uses the first value it reads
+// for most keywords.
+type SSHDConfigResource struct {
+	Directives map[string]string `json:"directives"`
+}
+
+type sshdConfigResource struct {
+	*SSHDConfigResource
+
+	path string
+}
+
+func (s *sshdConfigResource) validate(ctx context.Context) (*ManagedResources, error) {
+	if goos == "windows" {
+		return nil, errors.New("SSHDConfigResource is not supported on Windows")
+	}
+	if len(s.Directives) == 0 {
+		return nil, errors.New("SSHDConfigResource: at least one directive is required")
+	}
+	for k, v := range s.Directives {
+		if k == "" || strings.ContainsAny(k, " \t\n") || strings.ContainsAny(v, "\n") {
+			return nil, fmt.Errorf("SSHDConfigResource: invalid directive %q %q", k, v)
+		}
+		if strings.EqualFold(k, "Match") {
+			return nil, errors.New("SSHDConfigResource: Match blocks are not supported")
+		}
+	}
+	s.path = sshdConfigPath
+	return nil, nil
+}
+
+func (s *sshdConfigResource) block() []string {
+	var keys []string
+	for k := range s.Directives {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var block []string
+	for _, k := range keys {
+		block = append(block, k+" "+s.Directives[k])
+	}
+	return block
+}
+
+// config returns the sshd_config lines with the managed block at the top.
+func (s *sshdConfigResource) config(lines []string) []string {
+	begin, end := blockMarkers("directives")
+	out := []string{begin}
+	out = append(out, s.block()...)
+	out = append(out, end)
+	return append(out, replaceManagedBlock(lines, "directives", nil)...)
+}
+
+func (s *sshdConfigResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	lines, _, err := readLines(s.path)
+	if err != nil {
+		return false, err
+	}
+	return linesEqual(lines, s.config(lines)), nil
+}
+
+func (s *sshdConfigResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Enforcing sshd_config directives %q.", s.block())
+	lines, mode, err := readLines(s.path)
+	if err != nil {
+		return false, err
+	}
+
+	// Validate the new config before replacing the current one so a bad
+	// directive can't lock users out.
+	tmp := s.path + ".osconfig"
+	if err := writeLines(tmp, s.config(lines), mode); err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp)
+	if _, err := runCmd(ctx, sshd, "-t", "-f", tmp); err != nil {
+		return false, fmt.Errorf("new sshd_config failed validation: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return false, err
+	}
+
+	if _, err := runCmd(ctx, systemctl, "reload", "sshd"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *sshdConfigResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {
+}
+
+func (s *sshdConfigResource) cleanup(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,255 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+const (
+	testKey1   = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIDpqv5A/bB4qc5HY9ncECJ9tdj5bnz9+BLauaxSGpyYE a@b"
+	testKey1FP = "SHA256:Gx4VzOyabBL+l4C6DOl+PbVaBhF5s/jm6xFzeAcWPmg"
+	testKey2   = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIPjRifu68b9BsN0HWDdD1VccbJ+W/kbgpbZfAn+Xk562 c@d"
+)
+
+func TestAuthorizedKeyResourceValidate(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	defer func(f func(string) (*user.User, error)) { lookupUser = f }(lookupUser)
+	lookupUser = func(name string) (*user.User, error) {
+		if name != "alice" {
+			return nil, user.UnknownUserError(name)
+		}
+		return &user.User{Uid: "1000", Gid: "1000", HomeDir: "/home/alice"}, nil
+	}
+
+	var tests = []struct {
+		name    string
+		ak      *AuthorizedKeyResource
+		wantErr bool
+	}{
+		{"Present", &AuthorizedKeyResource{User: "alice", Key: testKey1}, false},
+		{"PresentWithOptions", &AuthorizedKeyResource{User: "alice", Key: `from="10.0.0.0/8" ` + testKey1}, false},
+		{"PresentMatchingFingerprint", &AuthorizedKeyResource{User: "alice", Key: testKey1, Fingerprint: testKey1FP}, false},
+		{"AbsentByFingerprint", &AuthorizedKeyResource{User: "alice", Fingerprint: testKey1FP, State: "absent"}, false},
+		{"PresentNoKey", &AuthorizedKeyResource{User: "alice", Fingerprint: testKey1FP}, true},
+		{"MismatchedFingerprint", &AuthorizedKeyResource{User: "alice", Key: testKey2, Fingerprint: testKey1FP}, true},
+		{"BadKey", &AuthorizedKeyResource{User: "alice", Key: "ssh-rsa garbage"}, true},
+		{"UnknownUser", &AuthorizedKeyResource{User: "bob", Key: testKey1}, true},
+		{"BadState", &AuthorizedKeyResource{User: "alice", Key: testKey1, State: "gone"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{Local: &LocalResource{AuthorizedKey: tt.ak}}
+			err := pr.Validate(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthorizedKeyResource(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	home := t.TempDir()
+	defer func(f func(string) (*user.User, error)) { lookupUser = f }(lookupUser)
+	lookupUser = func(string) (*user.User, error) {
+		return &user.User{Uid: strconv.Itoa(os.Getuid()), Gid: strconv.Itoa(os.Getgid()), HomeDir: home}, nil
+	}
+	path := filepath.Join(home, ".ssh", "authorized_keys")
+
+	present := &OSPolicyResource{Local: &LocalResource{AuthorizedKey: &AuthorizedKeyResource{User: "alice", Key: testKey1}}}
+	absent := &OSPolicyResource{Local: &LocalResource{AuthorizedKey: &AuthorizedKeyResource{User: "alice", Fingerprint: testKey1FP, State: "absent"}}}
+	for _, pr := range []*OSPolicyResource{present, absent} {
+		if err := pr.Validate(ctx); err != nil {
+			t.Fatalf("Unexpected Validate error: %v", err)
+		}
+	}
+
+	if err := present.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if present.InDesiredState() {
+		t.Fatal("Unexpected InDesiredState before enforce")
+	}
+	if err := present.EnforceState(ctx); err != nil {
+		t.Fatalf("Unexpected EnforceState error: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("Unexpected authorized_keys mode %v, want 0600", fi.Mode().Perm())
+	}
+
+	// Keep an unrelated key, and the same key with different options
+	// should not be duplicated.
+	if err := os.WriteFile(path, []byte("# comment\n"+testKey2+"\nno-pty "+testKey1+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := present.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if !present.InDesiredState() {
+		t.Error("Expected InDesiredState with key present with options")
+	}
+
+	if err := absent.EnforceState(ctx); err != nil {
+		t.Fatalf("Unexpected EnforceState error: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# comment\n" + testKey2 + "\n"; string(got) != want {
+		t.Errorf("Unexpected authorized_keys, got %q, want %q", got, want)
+	}
+}
+
+func TestAuthorizedKeyResourceSymlinks(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	home := t.TempDir()
+	defer func(f func(string) (*user.User, error)) { lookupUser = f }(lookupUser)
+	lookupUser = func(string) (*user.User, error) {
+		return &user.User{Uid: strconv.Itoa(os.Getuid()), Gid: strconv.Itoa(os.Getgid()), HomeDir: home}, nil
+	}
+	target := filepath.Join(t.TempDir(), "shadow")
+	if err := os.WriteFile(target, []byte("root:x:0:0\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	present := &OSPolicyResource{Local: &LocalResource{AuthorizedKey: &AuthorizedKeyResource{User: "alice", Key: testKey1}}}
+	if err := present.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+
+	// Neither a symlinked authorized_keys nor a symlinked .ssh is followed.
+	ssh := filepath.Join(home, ".ssh")
+	if err := os.Mkdir(ssh, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, filepath.Join(ssh, "authorized_keys")); err != nil {
+		t.Fatal(err)
+	}
+	if err := present.EnforceState(ctx); err == nil {
+		t.Error("Expected EnforceState error with a symlinked authorized_keys")
+	}
+	if err := os.RemoveAll(ssh); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Dir(target), ssh); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(target, filepath.Join(filepath.Dir(target), "authorized_keys")); err != nil {
+		t.Fatal(err)
+	}
+	target = filepath.Join(filepath.Dir(target), "authorized_keys")
+	if err := present.EnforceState(ctx); err == nil {
+		t.Error("Expected EnforceState error with a symlinked .ssh")
+	}
+	if got, err := os.ReadFile(target); err != nil || string(got) != "root:x:0:0\n" {
+		t.Errorf("Symlink target changed: got %q, %v", got, err)
+	}
+}
+
+func TestSSHDConfigResource(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	defer func(p string) { sshdConfigPath = p }(sshdConfigPath)
+	sshdConfigPath = filepath.Join(t.TempDir(), "sshd_config")
+	orig := "Include /etc/ssh/sshd_config.d/*.conf\nPasswordAuthentication yes\n"
+	if err := os.WriteFile(sshdConfigPath, []byte(orig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = mockCommandRunner
+
+	pr := &OSPolicyResource{Local: &LocalResource{SSHDConfig: &SSHDConfigResource{Directives: map[string]string{
+		"PermitRootLogin":        "no",
+		"PasswordAuthentication": "no",
+	}}}}
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if pr.InDesiredState() {
+		t.Fatal("Unexpected InDesiredState before enforce")
+	}
+
+	// Failed validation leaves the config untouched.
+	mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(sshd, "-t", "-f", sshdConfigPath+".osconfig"))).Return(nil, []byte("bad"), errors.New("exit status 255"))
+	if err := pr.EnforceState(ctx); err == nil {
+		t.Fatal("Expected EnforceState error on failed validation")
+	}
+	if got, _ := os.ReadFile(sshdConfigPath); string(got) != orig {
+		t.Errorf("Config changed after failed validation: %q", got)
+	}
+
+	gomock.InOrder(
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(sshd, "-t", "-f", sshdConfigPath+".osconfig"))).Return(nil, nil, nil),
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(systemctl, "reload", "sshd"))).Return(nil, nil, nil),
+	)
+	if err := pr.EnforceState(ctx); err != nil {
+		t.Fatalf("Unexpected EnforceState error: %v", err)
+	}
+	got, err := os.ReadFile(sshdConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"# BEGIN google-osconfig-agent directives",
+		"PasswordAuthentication no",
+		"PermitRootLogin no",
+		"# END google-osconfig-agent directives",
+		"Include /etc/ssh/sshd_config.d/*.conf",
+		"PasswordAuthentication yes",
+	}, "\n") + "\n"
+	if string(got) != want {
+		t.Errorf("Unexpected sshd_config, got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if !pr.InDesiredState() {
+		t.Error("Expected InDesiredState after enforce")
+	}
+}
@@ -0,0 +1,90 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// statefulOverlays are the writable locations of directories that are
+// read-only on immutable images, following the ostree layout of Fedora
+// CoreOS. On COS /etc, /var and /home are already writable and the rest of
+// the root file system has no stateful location.
+var statefulOverlays = []struct{ dir, overlay string }{
+	{"/usr/local", "/var/usrlocal"},
+	{"/opt", "/var/opt"},
+	{"/srv", "/var/srv"},
+	{"/root", "/var/roothome"},
+	{"/home", "/var/home"},
+	{"/mnt", "/var/mnt"},
+}
+
+// ErrReadOnlyFS is returned when enforcing a file on a read-only file
+// system, nothing was changed.
+var ErrReadOnlyFS = errors.New("read-only file system")
+
+// readOnly reports whether the file system of path is mounted read-only.
+var readOnly = readOnlyFS
+
+// statefulPath returns where path can be written. That is path itself unless
+// it is on a read-only file system. Then it is where path resolves to if
+// that is in a writable stateful overlay, like /opt linking to /var/opt,
+// otherwise it is an ErrReadOnlyFS error. A path is never moved to an
+// overlay it does not resolve into, the file would not be where it is
+// expected.
+func statefulPath(path string) (string, error) {
+	parent := existingParent(path)
+	if !readOnly(parent) {
+		return path, nil
+	}
+	if resolved, err := filepath.EvalSymlinks(parent); err == nil {
+		rel, _ := filepath.Rel(parent, path)
+		resolved = filepath.Join(resolved, rel)
+		for _, o := range statefulOverlays {
+			if !within(resolved, o.overlay) {
+				continue
+			}
+			if fi, err := os.Stat(o.overlay); err != nil || !fi.IsDir() || readOnly(o.overlay) {
+				continue
+			}
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%q is on a %w and does not resolve into a writable stateful location", path, ErrReadOnlyFS)
+}
+
+// within reports whether path is dir or in it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// existingParent returns path or its closest parent that exists.
+func existingParent(path string) string {
+	for {
+		if _, err := os.Lstat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
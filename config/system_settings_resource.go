@@ -0,0 +1,280 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+var (
+	timedatectl    = "/usr/bin/timedatectl"
+	localectl      = "/usr/bin/localectl"
+	tzutil         = `C:\Windows\System32\tzutil.exe`
+	localtime      = "/etc/localtime"
+	zoneinfoDir    = "/usr/share/zoneinfo"
+	profileEnvPath = "/etc/profile.d/google-osconfig-agent-env.sh"
+
+	envVarNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	localeRe     = regexp.MustCompile(`^[a-zA-Z0-9_.@-]+$`)
+)
+
+// TimezoneResource ensures the system timezone. Timezone is an IANA name
+// like America/New_York on Linux and a Windows time zone ID like
+// Eastern Standard Time on Windows.
+type TimezoneResource struct {
+	Timezone string `json:"timezone"`
+}
+
+type timezoneResource struct {
+	*TimezoneResource
+}
+
+func (t *timezoneResource) validate(ctx context.Context) (*ManagedResources, error) {
+	if t.Timezone == "" || strings.ContainsAny(t.Timezone, "\"'\n") {
+		return nil, fmt.Errorf("TimezoneResource: invalid Timezone %q", t.Timezone)
+	}
+	if goos == "windows" {
+		return nil, nil
+	}
+	if strings.Contains(t.Timezone, "..") || !util.Exists(filepath.Join(zoneinfoDir, t.Timezone)) {
+		return nil, fmt.Errorf("TimezoneResource: unknown Timezone %q", t.Timezone)
+	}
+	return nil, nil
+}
+
+func (t *timezoneResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	if goos == "windows" {
+		out, err := runCmd(ctx, tzutil, "/g")
+		if err != nil {
+			return false, err
+		}
+		return strings.TrimSpace(string(out)) == t.Timezone, nil
+	}
+
+	// /etc/localtime is a symlink into the zoneinfo dir on systemd systems.
+	target, err := filepath.EvalSymlinks(localtime)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	zoneinfo, err := filepath.EvalSymlinks(zoneinfoDir)
+	if err != nil {
+		return false, err
+	}
+	return target == filepath.Join(zoneinfo, t.Timezone), nil
+}
+
+func (t *timezoneResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Setting timezone to %q.", t.Timezone)
+	if goos == "windows" {
+		_, err = runCmd(ctx, tzutil, "/s", t.Timezone)
+	} else {
+		_, err = runCmd(ctx, timedatectl, "set-timezone", t.Timezone)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (t *timezoneResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {}
+
+func (t *timezoneResource) cleanup(ctx context.Context) error {
+	return nil
+}
+
+// LocaleResource ensures the system locale, LANG on Linux and the system
+// locale on Windows. Changing the Windows system locale requires a reboot to
+// take effect.
+type LocaleResource struct {
+	Locale string `json:"locale"`
+}
+
+type localeResource struct {
+	*LocaleResource
+}
+
+func (l *localeResource) validate(ctx context.Context) (*ManagedResources, error) {
+	if !localeRe.MatchString(l.Locale) {
+		return nil, fmt.Errorf("LocaleResource: invalid Locale %q", l.Locale)
+	}
+	return nil, nil
+}
+
+// parseLocalectlLang returns LANG from localectl status output.
+func parseLocalectlLang(out []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		_, v, ok := strings.Cut(scanner.Text(), "System Locale:")
+		if !ok {
+			continue
+		}
+		for _, f := range strings.Fields(v) {
+			if lang, ok := strings.CutPrefix(f, "LANG="); ok {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+func (l *localeResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	if goos == "windows" {
+		out, err := runPowerShell(ctx, "(Get-WinSystemLocale).Name")
+		if err != nil {
+			return false, err
+		}
+		return strings.EqualFold(strings.TrimSpace(string(out)), l.Locale), nil
+	}
+	out, err := runCmd(ctx, localectl, "status")
+	if err != nil {
+		return false, err
+	}
+	return parseLocalectlLang(out) == l.Locale, nil
+}
+
+func (l *localeResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Setting system locale to %q.", l.Locale)
+	if goos == "windows" {
+		_, err = runPowerShell(ctx, fmt.Sprintf("Set-WinSystemLocale -SystemLocale '%s'", l.Locale))
+	} else {
+		_, err = runCmd(ctx, localectl, "set-locale", "LANG="+l.Locale)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *localeResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {}
+
+func (l *localeResource) cleanup(ctx context.Context) error {
+	return nil
+}
+
+// EnvironmentVariableResource ensures a global environment variable, set in
+// a profile.d script on Linux, so it applies to new login shells, and as a
+// machine environment variable on Windows.
+type EnvironmentVariableResource struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+	State State  `json:"state,omitempty"`
+}
+
+type environmentVariableResource struct {
+	*EnvironmentVariableResource
+
+	state State
+}
+
+func (e *environmentVariableResource) validate(ctx context.Context) (*ManagedResources, error) {
+	var err error
+	if e.state, err = e.State.validate(); err != nil {
+		return nil, fmt.Errorf("EnvironmentVariableResource: %v", err)
+	}
+	if !envVarNameRe.MatchString(e.Name) {
+		return nil, fmt.Errorf("EnvironmentVariableResource: invalid Name %q", e.Name)
+	}
+	if strings.ContainsAny(e.Value, "\r\n") {
+		return nil, errors.New("EnvironmentVariableResource: Value can not contain newlines")
+	}
+	return nil, nil
+}
+
+func (e *environmentVariableResource) blockName() string {
+	return "env " + e.Name
+}
+
+// profileBlock returns the export line for the variable, or nil for the
+// absent state.
+func (e *environmentVariableResource) profileBlock() []string {
+	if e.state == StateAbsent {
+		return nil
+	}
+	return []string{fmt.Sprintf("export %s='%s'", e.Name, strings.ReplaceAll(e.Value, "'", `'\''`))}
+}
+
+func (e *environmentVariableResource) windowsVariable() string {
+	return fmt.Sprintf("[Environment]::GetEnvironmentVariable('%s', 'Machine')", e.Name)
+}
+
+func (e *environmentVariableResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	if goos == "windows" {
+		out, err := runPowerShell(ctx, fmt.Sprintf("$v = %s; if ($v -eq $null) { 'absent' } else { 'present:' + $v }", e.windowsVariable()))
+		if err != nil {
+			return false, err
+		}
+		got := strings.TrimRight(string(out), "\r\n")
+		if e.state == StateAbsent {
+			return got == "absent", nil
+		}
+		return got == "present:"+e.Value, nil
+	}
+
+	lines, _, err := readLines(profileEnvPath)
+	if err != nil {
+		return false, err
+	}
+	block, found := managedBlock(lines, e.blockName())
+	if e.state == StateAbsent {
+		return !found, nil
+	}
+	return found && linesEqual(block, e.profileBlock()), nil
+}
+
+func (e *environmentVariableResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Enforcing state %q for environment variable %q.", e.state, e.Name)
+	if goos == "windows" {
+		value := "$null"
+		if e.state == StatePresent {
+			value = "'" + strings.ReplaceAll(e.Value, "'", "''") + "'"
+		}
+		if _, err := runPowerShell(ctx, fmt.Sprintf("[Environment]::SetEnvironmentVariable('%s', %s, 'Machine')", e.Name, value)); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	lines, mode, err := readLines(profileEnvPath)
+	if err != nil {
+		return false, err
+	}
+	if err := writeLines(profileEnvPath, replaceManagedBlock(lines, e.blockName(), e.profileBlock()), mode); err != nil {
+		return false, fmt.Errorf("error writing %q: %v", profileEnvPath, err)
+	}
+	return true, nil
+}
+
+func (e *environmentVariableResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {
+}
+
+func (e *environmentVariableResource) cleanup(ctx context.Context) error {
+	return nil
+}
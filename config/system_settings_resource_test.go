@@ -0,0 +1,166 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestTimezoneResource(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	dir := t.TempDir()
+	defer func(l, z string) { localtime, zoneinfoDir = l, z }(localtime, zoneinfoDir)
+	zoneinfoDir = filepath.Join(dir, "zoneinfo")
+	localtime = filepath.Join(dir, "localtime")
+	for _, tz := range []string{"UTC", "Europe/Berlin"} {
+		p := filepath.Join(zoneinfoDir, tz)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(zoneinfoDir, "UTC"), localtime); err != nil {
+		t.Fatal(err)
+	}
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = mockCommandRunner
+
+	if err := (&OSPolicyResource{Local: &LocalResource{Timezone: &TimezoneResource{Timezone: "Mars/Olympus_Mons"}}}).Validate(ctx); err == nil {
+		t.Error("Expected Validate error for unknown timezone")
+	}
+
+	pr := &OSPolicyResource{Local: &LocalResource{Timezone: &TimezoneResource{Timezone: "Europe/Berlin"}}}
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if pr.InDesiredState() {
+		t.Fatal("Unexpected InDesiredState before enforce")
+	}
+
+	mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(timedatectl, "set-timezone", "Europe/Berlin"))).Return(nil, nil, nil)
+	if err := pr.EnforceState(ctx); err != nil {
+		t.Fatalf("Unexpected EnforceState error: %v", err)
+	}
+
+	if err := os.Remove(localtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(zoneinfoDir, "Europe/Berlin"), localtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if !pr.InDesiredState() {
+		t.Error("Expected InDesiredState with matching /etc/localtime")
+	}
+}
+
+func TestParseLocalectlLang(t *testing.T) {
+	out := []byte("   System Locale: LANG=en_US.UTF-8\n                  LC_TIME=de_DE.UTF-8\n       VC Keymap: us\n")
+	if got, want := parseLocalectlLang(out), "en_US.UTF-8"; got != want {
+		t.Errorf("parseLocalectlLang() = %q, want %q", got, want)
+	}
+	if got := parseLocalectlLang([]byte("   System Locale: n/a\n")); got != "" {
+		t.Errorf("parseLocalectlLang() = %q, want empty", got)
+	}
+}
+
+func TestEnvironmentVariableResource(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	defer func(p string) { profileEnvPath = p }(profileEnvPath)
+	profileEnvPath = filepath.Join(t.TempDir(), "env.sh")
+
+	if err := (&OSPolicyResource{Local: &LocalResource{EnvironmentVariable: &EnvironmentVariableResource{Name: "1BAD"}}}).Validate(ctx); err == nil {
+		t.Error("Expected Validate error for invalid name")
+	}
+
+	present := &OSPolicyResource{Local: &LocalResource{EnvironmentVariable: &EnvironmentVariableResource{Name: "HTTP_PROXY", Value: "http://proxy:3128/?a='b'"}}}
+	other := &OSPolicyResource{Local: &LocalResource{EnvironmentVariable: &EnvironmentVariableResource{Name: "NO_PROXY", Value: "metadata.google.internal"}}}
+	absent := &OSPolicyResource{Local: &LocalResource{EnvironmentVariable: &EnvironmentVariableResource{Name: "HTTP_PROXY", State: "absent"}}}
+	for _, pr := range []*OSPolicyResource{present, other, absent} {
+		if err := pr.Validate(ctx); err != nil {
+			t.Fatalf("Unexpected Validate error: %v", err)
+		}
+	}
+
+	for _, pr := range []*OSPolicyResource{present, other} {
+		if err := pr.CheckState(ctx); err != nil {
+			t.Fatalf("Unexpected CheckState error: %v", err)
+		}
+		if pr.InDesiredState() {
+			t.Fatal("Unexpected InDesiredState before enforce")
+		}
+		if err := pr.EnforceState(ctx); err != nil {
+			t.Fatalf("Unexpected EnforceState error: %v", err)
+		}
+	}
+	got, err := os.ReadFile(profileEnvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# BEGIN google-osconfig-agent env HTTP_PROXY\n" +
+		`export HTTP_PROXY='http://proxy:3128/?a='\''b'\'''` + "\n" +
+		"# END google-osconfig-agent env HTTP_PROXY\n" +
+		"# BEGIN google-osconfig-agent env NO_PROXY\n" +
+		"export NO_PROXY='metadata.google.internal'\n" +
+		"# END google-osconfig-agent env NO_PROXY\n"
+	if string(got) != want {
+		t.Errorf("Unexpected profile script, got:\n%s\nwant:\n%s", got, want)
+	}
+	if err := present.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if !present.InDesiredState() {
+		t.Error("Expected InDesiredState after enforce")
+	}
+
+	if err := absent.EnforceState(ctx); err != nil {
+		t.Fatalf("Unexpected EnforceState error: %v", err)
+	}
+	if err := absent.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if !absent.InDesiredState() {
+		t.Error("Expected InDesiredState after removing the variable")
+	}
+	if err := other.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if !other.InDesiredState() {
+		t.Error("Removing a variable changed another variable")
+	}
+}
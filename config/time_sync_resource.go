@@ -0,0 +1,269 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+
+	"cloud.google.com/go/osconfig/agentendpoint/apiv1/agentendpointpb"
+)
+
+// Supported time sync daemons.
+const (
+	Chrony    = "chrony"
+	Timesyncd = "timesyncd"
+	W32Time   = "w32time"
+)
+
+var (
+	chronyd         = "/usr/sbin/chronyd"
+	chronyConfPaths = []string{"/etc/chrony.conf", "/etc/chrony/chrony.conf"}
+	timesyncdPaths  = []string{"/lib/systemd/systemd-timesyncd", "/usr/lib/systemd/systemd-timesyncd"}
+	timesyncdDropIn = "/etc/systemd/timesyncd.conf.d/google-osconfig-agent.conf"
+	w32tm           = `C:\Windows\System32\w32tm.exe`
+)
+
+// TimeSyncResource ensures the system time sync daemon is configured with a
+// set of NTP servers and is running.
+type TimeSyncResource struct {
+	Servers []string `json:"servers"`
+	// Daemon is one of chrony, timesyncd or w32time, it is detected if not
+	// set.
+	Daemon string `json:"daemon,omitempty"`
+}
+
+type timeSyncResource struct {
+	*TimeSyncResource
+
+	daemon, service, confPath string
+	configMatches             bool
+}
+
+func detectTimeSyncDaemon() (string, error) {
+	if goos == "windows" {
+		return W32Time, nil
+	}
+	if util.Exists(chronyd) {
+		return Chrony, nil
+	}
+	for _, p := range timesyncdPaths {
+		if util.Exists(p) {
+			return Timesyncd, nil
+		}
+	}
+	return "", errors.New("no supported time sync daemon found")
+}
+
+func (t *timeSyncResource) validate(ctx context.Context) (*ManagedResources, error) {
+	if len(t.Servers) == 0 {
+		return nil, errors.New("TimeSyncResource: at least one server is required")
+	}
+	for _, s := range t.Servers {
+		if s == "" || strings.ContainsAny(s, " \t,\"'") {
+			return nil, fmt.Errorf("TimeSyncResource: invalid server %q", s)
+		}
+	}
+
+	t.daemon = strings.ToLower(t.Daemon)
+	if t.daemon == "" {
+		var err error
+		if t.daemon, err = detectTimeSyncDaemon(); err != nil {
+			return nil, fmt.Errorf("TimeSyncResource: %v", err)
+		}
+	}
+	switch t.daemon {
+	case Chrony:
+		if goos == "windows" {
+			return nil, fmt.Errorf("TimeSyncResource: daemon %q is not supported on Windows", t.daemon)
+		}
+		t.confPath = chronyConfPaths[0]
+		for _, p := range chronyConfPaths {
+			if util.Exists(p) {
+				t.confPath = p
+				break
+			}
+		}
+		// The unit is chronyd on EL and SUSE and chrony on Debian.
+		t.service = "chronyd"
+		if t.confPath == "/etc/chrony/chrony.conf" {
+			t.service = "chrony"
+		}
+	case Timesyncd:
+		if goos == "windows" {
+			return nil, fmt.Errorf("TimeSyncResource: daemon %q is not supported on Windows", t.daemon)
+		}
+		t.confPath = timesyncdDropIn
+		t.service = "systemd-timesyncd"
+	case W32Time:
+		if goos != "windows" {
+			return nil, fmt.Errorf("TimeSyncResource: daemon %q can only be used on Windows", t.daemon)
+		}
+		t.service = "w32time"
+	default:
+		return nil, fmt.Errorf("TimeSyncResource: unsupported daemon %q", t.Daemon)
+	}
+	return nil, nil
+}
+
+// chronyConfig returns the chrony config with the managed servers and all
+// other server and pool directives commented out.
+func (t *timeSyncResource) chronyConfig(lines []string) []string {
+	var block []string
+	for _, s := range t.Servers {
+		block = append(block, "server "+s+" iburst")
+	}
+	lines = replaceManagedBlock(lines, "servers", nil)
+	var out []string
+	for _, ln := range lines {
+		if f := strings.Fields(ln); len(f) > 0 && (f[0] == "server" || f[0] == "pool") {
+			ln = "# " + ln
+		}
+		out = append(out, ln)
+	}
+	return replaceManagedBlock(out, "servers", block)
+}
+
+func (t *timeSyncResource) timesyncdConfig() []string {
+	return []string{"[Time]", "NTP=" + strings.Join(t.Servers, " ")}
+}
+
+func (t *timeSyncResource) w32timePeers() string {
+	var peers []string
+	for _, s := range t.Servers {
+		peers = append(peers, s+",0x8")
+	}
+	return strings.Join(peers, " ")
+}
+
+func parseW32tmConfiguration(out []byte) (ntpServer, typ string) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		// Values have a trailing source, e.g. "NtpServer: a,0x8 (Local)".
+		if i := strings.LastIndex(v, " ("); i != -1 {
+			v = v[:i]
+		}
+		switch strings.TrimSpace(k) {
+		case "NtpServer":
+			ntpServer = strings.TrimSpace(v)
+		case "Type":
+			typ = strings.TrimSpace(v)
+		}
+	}
+	return ntpServer, typ
+}
+
+func linesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *timeSyncResource) checkConfig(ctx context.Context) (bool, error) {
+	switch t.daemon {
+	case Chrony:
+		lines, _, err := readLines(t.confPath)
+		if err != nil {
+			return false, err
+		}
+		return linesEqual(lines, t.chronyConfig(lines)), nil
+	case Timesyncd:
+		lines, _, err := readLines(t.confPath)
+		if err != nil {
+			return false, err
+		}
+		return linesEqual(lines, t.timesyncdConfig()), nil
+	case W32Time:
+		out, err := runCmd(ctx, w32tm, "/query", "/configuration")
+		if err != nil {
+			// This fails if the service is not running.
+			clog.Debugf(ctx, "Error querying w32time configuration: %v", err)
+			return false, nil
+		}
+		peers, typ := parseW32tmConfiguration(out)
+		return peers == t.w32timePeers() && typ == "NTP", nil
+	}
+	return false, fmt.Errorf("unsupported daemon %q", t.daemon)
+}
+
+func (t *timeSyncResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	t.configMatches, err = t.checkConfig(ctx)
+	if err != nil || !t.configMatches {
+		return false, err
+	}
+	return serviceRunning(ctx, t.service)
+}
+
+func (t *timeSyncResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	clog.Infof(ctx, "Enforcing time sync servers %q for %s.", t.Servers, t.daemon)
+	if !t.configMatches {
+		switch t.daemon {
+		case Chrony:
+			lines, mode, err := readLines(t.confPath)
+			if err != nil {
+				return false, err
+			}
+			if err := writeLines(t.confPath, t.chronyConfig(lines), mode); err != nil {
+				return false, fmt.Errorf("error writing %q: %v", t.confPath, err)
+			}
+		case Timesyncd:
+			if err := os.MkdirAll(filepath.Dir(t.confPath), 0755); err != nil {
+				return false, err
+			}
+			if err := writeLines(t.confPath, t.timesyncdConfig(), 0644); err != nil {
+				return false, fmt.Errorf("error writing %q: %v", t.confPath, err)
+			}
+		case W32Time:
+			// The service must be running for w32tm to update its config.
+			if err := startService(ctx, t.service, false); err != nil {
+				return false, err
+			}
+			if _, err := runCmd(ctx, w32tm, "/config", "/manualpeerlist:"+t.w32timePeers(), "/syncfromflags:manual", "/update"); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	// Restart to pick up config changes, otherwise just make sure it runs.
+	if err := startService(ctx, t.service, !t.configMatches); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (t *timeSyncResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {}
+
+func (t *timeSyncResource) cleanup(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,143 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestTimeSyncResourceValidate(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+
+	var tests = []struct {
+		name    string
+		ts      *TimeSyncResource
+		wantErr bool
+	}{
+		{"Chrony", &TimeSyncResource{Servers: []string{"metadata.google.internal"}, Daemon: "chrony"}, false},
+		{"Timesyncd", &TimeSyncResource{Servers: []string{"a", "b"}, Daemon: "Timesyncd"}, false},
+		{"W32TimeOnLinux", &TimeSyncResource{Servers: []string{"a"}, Daemon: "w32time"}, true},
+		{"NoServers", &TimeSyncResource{Daemon: "chrony"}, true},
+		{"BadServer", &TimeSyncResource{Servers: []string{"a b"}, Daemon: "chrony"}, true},
+		{"UnknownDaemon", &TimeSyncResource{Servers: []string{"a"}, Daemon: "ntpd"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &OSPolicyResource{Local: &LocalResource{TimeSync: tt.ts}}
+			err := pr.Validate(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChronyConfig(t *testing.T) {
+	tr := &timeSyncResource{TimeSyncResource: &TimeSyncResource{Servers: []string{"metadata.google.internal"}}}
+	in := []string{
+		"pool 2.debian.pool.ntp.org iburst",
+		"# server commented.example.com",
+		"driftfile /var/lib/chrony/chrony.drift",
+	}
+	want := []string{
+		"# pool 2.debian.pool.ntp.org iburst",
+		"# server commented.example.com",
+		"driftfile /var/lib/chrony/chrony.drift",
+		"# BEGIN google-osconfig-agent servers",
+		"server metadata.google.internal iburst",
+		"# END google-osconfig-agent servers",
+	}
+	got := tr.chronyConfig(in)
+	if !linesEqual(got, want) {
+		t.Errorf("chronyConfig() =\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	// Applying the config again must not change it.
+	if again := tr.chronyConfig(got); !linesEqual(again, got) {
+		t.Errorf("chronyConfig() is not idempotent:\n%s", strings.Join(again, "\n"))
+	}
+}
+
+func TestParseW32tmConfiguration(t *testing.T) {
+	out := []byte("[Configuration]\r\n\r\nEventLogFlags: 2 (Local)\r\n[TimeProviders]\r\n\r\nNtpClient (Local)\r\nType: NTP (Local)\r\nNtpServer: a,0x8 b,0x8 (Local)\r\n")
+	peers, typ := parseW32tmConfiguration(out)
+	if peers != "a,0x8 b,0x8" || typ != "NTP" {
+		t.Errorf("parseW32tmConfiguration() = %q, %q, want %q, %q", peers, typ, "a,0x8 b,0x8", "NTP")
+	}
+}
+
+func TestTimeSyncResourceTimesyncd(t *testing.T) {
+	ctx := context.Background()
+	defer func(g string) { goos = g }(goos)
+	goos = "linux"
+	defer func(p string) { timesyncdDropIn = p }(timesyncdDropIn)
+	timesyncdDropIn = filepath.Join(t.TempDir(), "timesyncd.conf.d", "osconfig.conf")
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = mockCommandRunner
+
+	pr := &OSPolicyResource{Local: &LocalResource{TimeSync: &TimeSyncResource{Servers: []string{"a", "b"}, Daemon: "timesyncd"}}}
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+
+	// Config is missing so the service state is not checked.
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if pr.InDesiredState() {
+		t.Fatal("Unexpected InDesiredState before enforce")
+	}
+
+	gomock.InOrder(
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(systemctl, "enable", "systemd-timesyncd"))).Return(nil, nil, nil),
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(systemctl, "restart", "systemd-timesyncd"))).Return(nil, nil, nil),
+	)
+	if err := pr.EnforceState(ctx); err != nil {
+		t.Fatalf("Unexpected EnforceState error: %v", err)
+	}
+	got, err := os.ReadFile(timesyncdDropIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[Time]\nNTP=a b\n"; string(got) != want {
+		t.Errorf("Unexpected drop-in, got %q, want %q", got, want)
+	}
+
+	gomock.InOrder(
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(systemctl, "is-active", "--quiet", "systemd-timesyncd"))).Return(nil, nil, nil),
+		mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command(systemctl, "is-enabled", "--quiet", "systemd-timesyncd"))).Return(nil, nil, errors.New("disabled")),
+	)
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if pr.InDesiredState() {
+		t.Error("Unexpected InDesiredState with disabled service")
+	}
+}
@@ -28,6 +28,10 @@ import (
 var (
 	agentEndpoint          = flag.String("agent_endpoint", "", "API endpoint to use for the agent to use for the tests")
 	endpoint               = flag.String("endpoint", "osconfig.googleapis.com:443", "API endpoint to use for the tests")
+	computeEndpoint        = flag.String("compute_endpoint", "", "Compute API endpoint to use for the tests, leave blank for the default")
+	quotaProject           = flag.String("quota_project", "", "project API quota is charged to, leave blank for the project of the credentials")
+	apiVersions            = flag.String("api_versions", "v1,v1beta", "comma separated OS Config API versions to test, suites using other versions are skipped")
+	enabledAPIVersions     = map[string]bool{}
 	oauthDefault           = flag.String("local_oauth", "", "path to service creds file")
 	agentRepo              = flag.String("agent_repo", "", "repo to pull agent from (unstable, staging, or stable, leave blank for no agent install)")
 	bucketDefault          = "osconfig-agent-end2end-tests"
@@ -75,6 +79,15 @@ func init() {
 
 	projects = strings.Split(*testProjectIDs, ",")
 
+	for _, v := range strings.Split(*apiVersions, ",") {
+		v = strings.TrimSpace(v)
+		if v != "v1" && v != "v1beta" {
+			fmt.Printf("-api_versions flag not valid: unknown API version %q\n", v)
+			os.Exit(1)
+		}
+		enabledAPIVersions[v] = true
+	}
+
 	zones = make(map[string]int)
 	if len(strings.TrimSpace(*testZone)) != 0 {
 		zones[*testZone] = math.MaxInt32
@@ -127,6 +140,23 @@ func SvcEndpoint() string {
 	return *endpoint
 }
 
+// ComputeEndpoint returns the Compute API endpoint, empty for the default.
+func ComputeEndpoint() string {
+	return *computeEndpoint
+}
+
+// QuotaProject returns the project API quota is charged to, empty for the
+// project of the credentials.
+func QuotaProject() string {
+	return *quotaProject
+}
+
+// APIVersionEnabled reports whether suites using OS Config API version v,
+// v1 or v1beta, should run.
+func APIVersionEnabled(v string) bool {
+	return enabledAPIVersions[v]
+}
+
 // OauthPath returns the oauthPath file path
 func OauthPath() string {
 	return *oauthDefault
@@ -31,32 +31,51 @@ var (
 	osconfigZonalClientV1 *osconfigV1.OsConfigZonalClient
 )
 
-// PopulateClients populates the GCP clients.
+// PopulateClients populates the GCP clients, OS Config clients are only
+// created for the selected API versions.
 func PopulateClients(ctx context.Context) error {
 	if err := createComputeClient(ctx); err != nil {
 		return err
 	}
-	if err := createOsConfigClientV1(ctx); err != nil {
-		return err
+	if config.APIVersionEnabled("v1") {
+		if err := createOsConfigClientV1(ctx); err != nil {
+			return err
+		}
+	}
+	if config.APIVersionEnabled("v1beta") {
+		return createOsConfigClientV1beta(ctx)
+	}
+	return nil
+}
+
+// clientOptions returns the options shared by all clients, endpoint is
+// left to the client default when empty.
+func clientOptions(endpoint string) []option.ClientOption {
+	opts := []option.ClientOption{option.WithCredentialsFile(config.OauthPath())}
+	if endpoint != "" {
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+	if config.QuotaProject() != "" {
+		opts = append(opts, option.WithQuotaProject(config.QuotaProject()))
 	}
-	return createOsConfigClientV1beta(ctx)
+	return opts
 }
 
 func createComputeClient(ctx context.Context) error {
 	var err error
-	computeClient, err = compute.NewClient(ctx, option.WithCredentialsFile(config.OauthPath()))
+	computeClient, err = compute.NewClient(ctx, clientOptions(config.ComputeEndpoint())...)
 	return err
 }
 
 func createOsConfigClientV1beta(ctx context.Context) error {
 	var err error
-	osconfigClientV1beta, err = osconfigV1beta.NewClient(ctx, option.WithCredentialsFile(config.OauthPath()), option.WithEndpoint(config.SvcEndpoint()))
+	osconfigClientV1beta, err = osconfigV1beta.NewClient(ctx, clientOptions(config.SvcEndpoint())...)
 	return err
 }
 
 func createOsConfigClientV1(ctx context.Context) error {
 	var err error
-	osconfigZonalClientV1, err = osconfigV1.NewOsConfigZonalClient(ctx, option.WithCredentialsFile(config.OauthPath()), option.WithEndpoint(config.SvcEndpoint()))
+	osconfigZonalClientV1, err = osconfigV1.NewOsConfigZonalClient(ctx, clientOptions(config.SvcEndpoint())...)
 	return err
 }
 
@@ -36,6 +36,7 @@ import (
 	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/test_suites/inventoryreporting"
 	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/test_suites/ospolicies"
 	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/test_suites/patch"
+	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/test_suites/upgrade"
 
 	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
 )
@@ -46,6 +47,7 @@ var testFunctions = []func(context.Context, *sync.WaitGroup, chan *junitxml.Test
 	inventory.TestSuite,
 	inventoryreporting.TestSuite,
 	patch.TestSuite,
+	upgrade.TestSuite,
 }
 
 type logWriter struct {
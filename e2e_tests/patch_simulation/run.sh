@@ -0,0 +1,66 @@
+#!/bin/bash
+# Copyright 2024 Google Inc. All Rights Reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# Runs the agent's patch logic against container images of the supported
+# distros with a fake agentendpoint, see
+# agentendpoint/patch_simulation_linux_test.go. Needs go and docker, no GCE
+# project.
+#
+# Example:
+#   ./run.sh                  # all default images
+#   ./run.sh rockylinux:9     # a single image
+
+set -u
+
+IMAGES=("$@")
+if [[ ${#IMAGES[@]} -eq 0 ]]; then
+  IMAGES=(
+    debian:11
+    debian:12
+    ubuntu:20.04
+    ubuntu:22.04
+    rockylinux:8
+    rockylinux:9
+    opensuse/leap:15
+  )
+fi
+
+ROOT="$(cd "$(dirname "$0")/../.." && pwd)"
+BIN_DIR="$(mktemp -d)"
+trap 'rm -rf "${BIN_DIR}"' EXIT
+
+# A static test binary runs on any of the images.
+if ! (cd "${ROOT}" && CGO_ENABLED=0 go test -c -tags simulation -o "${BIN_DIR}/agentendpoint.test" ./agentendpoint); then
+  echo "Failed to build the simulation test binary"
+  exit 1
+fi
+
+FAILED=()
+for image in "${IMAGES[@]}"; do
+  echo "=== Patch simulation on ${image}"
+  if ! docker run --rm \
+      -e OSCONFIG_PATCH_SIMULATION=1 \
+      -v "${BIN_DIR}:/simulation:ro" \
+      "${image}" \
+      /simulation/agentendpoint.test -test.run '^TestPatchSimulation$' -test.v; then
+    FAILED+=("${image}")
+  fi
+done
+
+if [[ ${#FAILED[@]} -ne 0 ]]; then
+  echo "Patch simulation failed on: ${FAILED[*]}"
+  exit 1
+fi
+echo "Patch simulation passed on: ${IMAGES[*]}"
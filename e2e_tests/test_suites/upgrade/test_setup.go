@@ -0,0 +1,167 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package upgrade
+
+import (
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/compute"
+	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/utils"
+	computeAPI "google.golang.org/api/compute/v1"
+)
+
+type upgradeTestSetup struct {
+	testName    string
+	image       string
+	metadata    []*computeAPI.MetadataItems
+	machineType string
+	timeout     time.Duration
+}
+
+const (
+	// Scripts run as local patch job pre-steps, they hand the package change
+	// to a transient unit so the agent running the patch task can be
+	// replaced while the task continues.
+	upgradeScript   = "/osconfig_tests/upgrade.sh"
+	downgradeScript = "/osconfig_tests/downgrade.sh"
+)
+
+var (
+	// linuxStageHelpers record the installed agent version and the number of
+	// task state file load errors logged by the agent for a stage.
+	linuxStageHelpers = `
+ga=http://metadata.google.internal/computeMetadata/v1/instance/guest-attributes/osconfig_tests
+record_stage() {
+  if command -v dpkg-query >/dev/null; then
+    version=$(dpkg-query -W -f='${Version}' google-osconfig-agent)
+  else
+    version=$(rpm -q --qf '%{VERSION}-%{RELEASE}' google-osconfig-agent)
+  fi
+  curl -X PUT --data "${version}" $ga/agent_version_$1 -H "Metadata-Flavor: Google"
+  errors=$(journalctl -u google-osconfig-agent | grep -c "loadState error")
+  curl -X PUT --data "${errors}" $ga/state_errors_$1 -H "Metadata-Flavor: Google"
+}
+`
+
+	// linuxRecordReleased runs at boot with the agent released in the image.
+	// Inventory is reported again on restart so the test sees it was
+	// reported by this agent.
+	linuxRecordReleased = `
+source /osconfig_tests/helpers.sh
+record_stage released
+echo "${version}" > /osconfig_tests/released_version
+curl -X DELETE http://metadata.google.internal/computeMetadata/v1/instance/guest-attributes/guestInventory/LastUpdated -H "Metadata-Flavor: Google"
+systemctl restart google-osconfig-agent
+curl -X PUT --data "1" $ga/released_done -H "Metadata-Flavor: Google"
+`
+
+	linuxDowngrade = `
+source /osconfig_tests/helpers.sh
+released=$(cat /osconfig_tests/released_version)
+systemctl stop google-osconfig-agent
+if command -v apt-get >/dev/null; then
+  apt-get install -y --allow-downgrades google-osconfig-agent=${released}
+else
+  yum downgrade -y google-osconfig-agent-${released}
+fi
+curl -X DELETE http://metadata.google.internal/computeMetadata/v1/instance/guest-attributes/guestInventory/LastUpdated -H "Metadata-Flavor: Google"
+systemctl start google-osconfig-agent
+sleep 60
+record_stage downgraded
+curl -X PUT --data "1" $ga/downgrade_done -H "Metadata-Flavor: Google"
+`
+
+	enableOsconfig  = compute.BuildInstanceMetadataItem("enable-osconfig", "true")
+	disableFeatures = compute.BuildInstanceMetadataItem("osconfig-disabled-features", "guestpolicies")
+	pollInterval    = compute.BuildInstanceMetadataItem("osconfig-poll-interval", "1")
+)
+
+// linuxStartup writes the stage scripts, the candidate build is installed
+// with install, and records the released agent.
+func linuxStartup(install string) string {
+	return `
+mkdir -p /osconfig_tests
+cat > /osconfig_tests/helpers.sh <<'EOS'` + linuxStageHelpers + `EOS
+cat > /osconfig_tests/install_candidate.sh <<'EOS'
+source /osconfig_tests/helpers.sh` + install + `sleep 60
+record_stage candidate
+EOS
+cat > /osconfig_tests/downgrade_agent.sh <<'EOS'` + linuxDowngrade + `EOS
+cat > ` + upgradeScript + ` <<'EOS'
+systemd-run --unit=osconfig-test-upgrade /bin/bash /osconfig_tests/install_candidate.sh
+EOS
+cat > ` + downgradeScript + ` <<'EOS'
+systemd-run --unit=osconfig-test-downgrade /bin/bash /osconfig_tests/downgrade_agent.sh
+EOS
+chmod +x ` + upgradeScript + ` ` + downgradeScript + `
+` + linuxRecordReleased
+}
+
+func createAptTestSetup(image string) *upgradeTestSetup {
+	return &upgradeTestSetup{
+		metadata: []*computeAPI.MetadataItems{
+			compute.BuildInstanceMetadataItem("startup-script", linuxStartup(utils.InstallOSConfigDeb(image))),
+			enableOsconfig,
+			disableFeatures,
+			pollInterval,
+		},
+		machineType: "e2-medium",
+		timeout:     30 * time.Minute,
+	}
+}
+
+func createELTestSetup(image string) *upgradeTestSetup {
+	return &upgradeTestSetup{
+		metadata: []*computeAPI.MetadataItems{
+			compute.BuildInstanceMetadataItem("startup-script", linuxStartup(utils.InstallOSConfigEL(image))),
+			enableOsconfig,
+			disableFeatures,
+			pollInterval,
+		},
+		machineType: "e2-medium",
+		timeout:     30 * time.Minute,
+	}
+}
+
+var (
+	bullseyeAptSetup = createAptTestSetup("debian-11")
+	bookwormAptSetup = createAptTestSetup("debian-12")
+	el8Setup         = createELTestSetup("8")
+	el9Setup         = createELTestSetup("9")
+)
+
+func imageTestSetup(mapping map[*upgradeTestSetup]map[string]string) (setup []*upgradeTestSetup) {
+	for s, m := range mapping {
+		for name, image := range m {
+			new := upgradeTestSetup(*s)
+			new.testName = name
+			new.image = image
+			setup = append(setup, &new)
+		}
+	}
+	return
+}
+
+func headImageTestSetup() []*upgradeTestSetup {
+	// This maps a specific upgradeTestSetup to test setup names and associated images.
+	mapping := map[*upgradeTestSetup]map[string]string{
+		bullseyeAptSetup: utils.HeadBullseyeAptImages,
+		bookwormAptSetup: utils.HeadBookwormAptImages,
+		el8Setup:         utils.HeadEL8Images,
+		el9Setup:         utils.HeadEL9Images,
+	}
+
+	return imageTestSetup(mapping)
+}
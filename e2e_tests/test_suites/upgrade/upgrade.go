@@ -0,0 +1,287 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package upgrade contains end to end tests upgrading the agent in place from
+// the released to the candidate build and downgrading it back.
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/go/e2e_test_utils/junitxml"
+	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/compute"
+	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/config"
+	gcpclients "github.com/GoogleCloudPlatform/osconfig/e2e_tests/gcp_clients"
+	testconfig "github.com/GoogleCloudPlatform/osconfig/e2e_tests/test_config"
+	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/utils"
+	"github.com/GoogleCloudPlatform/osconfig/retryutil"
+	"github.com/golang/protobuf/ptypes/duration"
+
+	"cloud.google.com/go/osconfig/apiv1beta/osconfigpb"
+)
+
+const (
+	testSuiteName = "OSConfigAgentUpgrade"
+)
+
+var testSuffix = utils.RandString(3)
+
+// TestSuite is a OSConfigAgentUpgrade test suite.
+func TestSuite(ctx context.Context, tswg *sync.WaitGroup, testSuites chan *junitxml.TestSuite, logger *log.Logger, testSuiteRegex, testCaseRegex *regexp.Regexp) {
+	defer tswg.Done()
+
+	if testSuiteRegex != nil && !testSuiteRegex.MatchString(testSuiteName) {
+		return
+	}
+	if !config.APIVersionEnabled("v1beta") {
+		return
+	}
+	// There is no candidate build to upgrade to without an agent repo, and
+	// stable is the released build.
+	if config.AgentRepo() == "" || config.AgentRepo() == "stable" {
+		return
+	}
+
+	testSuite := junitxml.NewTestSuite(testSuiteName)
+	defer testSuite.Finish(testSuites)
+
+	logger.Printf("Running TestSuite %q", testSuite.Name)
+
+	var wg sync.WaitGroup
+	tests := make(chan *junitxml.TestCase)
+	for _, setup := range headImageTestSetup() {
+		wg.Add(1)
+		go upgradeTestCase(ctx, setup, tests, &wg, logger, testCaseRegex)
+	}
+
+	go func() {
+		wg.Wait()
+		close(tests)
+	}()
+
+	for ret := range tests {
+		testSuite.TestCase = append(testSuite.TestCase, ret)
+	}
+
+	logger.Printf("Finished TestSuite %q", testSuite.Name)
+}
+
+func upgradeTestCase(ctx context.Context, testSetup *upgradeTestSetup, tests chan *junitxml.TestCase, wg *sync.WaitGroup, logger *log.Logger, regex *regexp.Regexp) {
+	defer wg.Done()
+
+	tc := junitxml.NewTestCase(testSuiteName, fmt.Sprintf("[Upgrade and downgrade agent] [%s]", testSetup.testName))
+	if tc.FilterTestCase(regex) {
+		tc.Finish(tests)
+		return
+	}
+
+	logger.Printf("Running TestCase %q", tc.Name)
+	runUpgradeTest(ctx, tc, testSetup)
+	if tc.Failure != nil {
+		rerunTC := junitxml.NewTestCase(testSuiteName, strings.TrimPrefix(tc.Name, fmt.Sprintf("[%s] ", testSuiteName)))
+		logger.Printf("Rerunning TestCase %q", rerunTC.Name)
+		runUpgradeTest(ctx, rerunTC, testSetup)
+		rerunTC.Finish(tests)
+		logger.Printf("TestCase %q finished in %fs", rerunTC.Name, rerunTC.Time)
+	}
+	tc.Finish(tests)
+	logger.Printf("TestCase %q finished in %fs", tc.Name, tc.Time)
+}
+
+// runUpgradeTest checks the released agent reports inventory, then runs a
+// patch job that upgrades the agent to the candidate build from its pre-step
+// and one that downgrades it back. The agent is replaced while it runs the
+// patch task, so the jobs only succeed if the new agent picks the task up
+// from the state file of the old one. Inventory must be reported again after
+// each change.
+func runUpgradeTest(ctx context.Context, testCase *junitxml.TestCase, testSetup *upgradeTestSetup) {
+	computeClient, err := gcpclients.GetComputeClient()
+	if err != nil {
+		testCase.WriteFailure("Error getting compute client: %v", err)
+		return
+	}
+
+	name := fmt.Sprintf("upgrade-test-%s-%s-%s", path.Base(testSetup.testName), testSuffix, utils.RandString(5))
+	testProjectConfig := testconfig.GetProject()
+	zone := testProjectConfig.AcquireZone()
+	defer testProjectConfig.ReleaseZone(zone)
+	testCase.Logf("Creating instance %q with image %q", name, testSetup.image)
+	inst, err := utils.CreateComputeInstance(testSetup.metadata, computeClient, testSetup.machineType, testSetup.image, name, testProjectConfig.TestProjectID, zone, testProjectConfig.ServiceAccountEmail, testProjectConfig.ServiceAccountScopes)
+	if err != nil {
+		testCase.WriteFailure("Error creating instance: %v", utils.GetStatusFromError(err))
+		return
+	}
+	defer inst.Cleanup()
+	defer inst.RecordSerialOutput(ctx, path.Join(*config.OutDir, testSuiteName), 1)
+
+	testCase.Logf("Waiting for the released agent")
+	if err := checkStage(inst, "released", "released_done", testSetup.timeout); err != nil {
+		testCase.WriteFailure("Released agent: %v", err)
+		return
+	}
+	released, err := agentVersion(inst, "released")
+	if err != nil {
+		testCase.WriteFailure("Released agent: %v", err)
+		return
+	}
+
+	testCase.Logf("Upgrading agent %s to the candidate build", released)
+	if err := runPatchJob(ctx, name, testProjectConfig.TestProjectID, upgradeScript, testSetup.timeout); err != nil {
+		testCase.WriteFailure("Upgrade: %v", err)
+		return
+	}
+	if err := checkStage(inst, "candidate", "install_done", testSetup.timeout); err != nil {
+		testCase.WriteFailure("Upgrade: %v", err)
+		return
+	}
+	candidate, err := agentVersion(inst, "candidate")
+	if err != nil {
+		testCase.WriteFailure("Upgrade: %v", err)
+		return
+	}
+	if candidate == released {
+		testCase.WriteFailure("Upgrade: agent version is still the released %s", released)
+		return
+	}
+
+	testCase.Logf("Downgrading agent %s to %s", candidate, released)
+	if err := runPatchJob(ctx, name, testProjectConfig.TestProjectID, downgradeScript, testSetup.timeout); err != nil {
+		testCase.WriteFailure("Downgrade: %v", err)
+		return
+	}
+	if err := checkStage(inst, "downgraded", "downgrade_done", testSetup.timeout); err != nil {
+		testCase.WriteFailure("Downgrade: %v", err)
+		return
+	}
+	downgraded, err := agentVersion(inst, "downgraded")
+	if err != nil {
+		testCase.WriteFailure("Downgrade: %v", err)
+		return
+	}
+	if downgraded != released {
+		testCase.WriteFailure("Downgrade: got agent version %s, want %s", downgraded, released)
+	}
+}
+
+// checkStage waits for the done guest attribute of a stage and checks the
+// agent loaded its task state and reported inventory.
+func checkStage(inst *compute.Instance, stage, done string, timeout time.Duration) error {
+	if _, err := inst.WaitForGuestAttributes("osconfig_tests/"+done, 5*time.Second, timeout); err != nil {
+		return fmt.Errorf("error waiting for %s: %v", done, err)
+	}
+	errs, err := guestAttribute(inst, "osconfig_tests/state_errors_"+stage)
+	if err != nil {
+		return err
+	}
+	if errs != "0" {
+		return fmt.Errorf("agent logged %s errors loading its task state file", errs)
+	}
+	// LastUpdated is the last entry written by the agent, so wait on that.
+	if _, err := inst.WaitForGuestAttributes("guestInventory/LastUpdated", 20*time.Second, timeout); err != nil {
+		return fmt.Errorf("error waiting for inventory: %v", err)
+	}
+	return nil
+}
+
+func agentVersion(inst *compute.Instance, stage string) (string, error) {
+	v, err := guestAttribute(inst, "osconfig_tests/agent_version_"+stage)
+	if err != nil {
+		return "", err
+	}
+	if v == "" {
+		return "", errors.New("agent is not installed")
+	}
+	return v, nil
+}
+
+func guestAttribute(inst *compute.Instance, key string) (string, error) {
+	attr, err := inst.GetGuestAttributes(key)
+	if err != nil {
+		return "", fmt.Errorf("error retrieving %s: %v", key, err)
+	}
+	if len(attr) == 0 {
+		return "", fmt.Errorf("error retrieving %s: attribute empty", key)
+	}
+	return strings.TrimSpace(attr[0].Value), nil
+}
+
+// runPatchJob runs a patch job on the instance that only runs script as its
+// pre-step, no packages are updated so the agent is left as the script set
+// it up.
+func runPatchJob(ctx context.Context, name, projectID, script string, timeout time.Duration) error {
+	osconfigClient, err := gcpclients.GetOsConfigClientV1beta()
+	if err != nil {
+		return fmt.Errorf("error getting osconfig client: %v", err)
+	}
+
+	preStep := &osconfigpb.ExecStep{LinuxExecStepConfig: &osconfigpb.ExecStepConfig{Executable: &osconfigpb.ExecStepConfig_LocalPath{LocalPath: script}, Interpreter: osconfigpb.ExecStepConfig_SHELL}}
+	req := &osconfigpb.ExecutePatchJobRequest{
+		Parent:         fmt.Sprintf("projects/%s", projectID),
+		Description:    "testing agent upgrade during a patch job",
+		InstanceFilter: &osconfigpb.PatchInstanceFilter{InstanceNamePrefixes: []string{name}},
+		Duration:       &duration.Duration{Seconds: int64(timeout / time.Second)},
+		PatchConfig: &osconfigpb.PatchConfig{
+			RebootConfig: osconfigpb.PatchConfig_NEVER,
+			PreStep:      preStep,
+			Apt:          &osconfigpb.AptSettings{ExclusivePackages: []string{"pkg1"}},
+			Yum:          &osconfigpb.YumSettings{ExclusivePackages: []string{"pkg1"}},
+		},
+	}
+	job, err := osconfigClient.ExecutePatchJob(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error running ExecutePatchJob: %s", utils.GetStatusFromError(err))
+	}
+	return awaitPatchJob(ctx, job, timeout)
+}
+
+func awaitPatchJob(ctx context.Context, job *osconfigpb.PatchJob, timeout time.Duration) error {
+	client, err := gcpclients.GetOsConfigClientV1beta()
+	if err != nil {
+		return err
+	}
+	tick := time.Tick(10 * time.Second)
+	timedout := time.Tick(timeout)
+	for {
+		select {
+		case <-timedout:
+			return fmt.Errorf("timed out while waiting for patch job %q to complete", job.GetName())
+		case <-tick:
+			var res *osconfigpb.PatchJob
+			if err := retryutil.RetryAPICall(ctx, timeout, "GetPatchJobRequest", func() error {
+				res, err = client.GetPatchJob(ctx, &osconfigpb.GetPatchJobRequest{Name: job.GetName()})
+				return err
+			}); err != nil {
+				return fmt.Errorf("error while fetching patch job: %s", utils.GetStatusFromError(err))
+			}
+
+			switch res.State {
+			case osconfigpb.PatchJob_SUCCEEDED:
+				if res.GetInstanceDetailsSummary().GetSucceededInstanceCount() < 1 {
+					return fmt.Errorf("patch job %q completed with no instances patched", job.GetName())
+				}
+				return nil
+			case osconfigpb.PatchJob_COMPLETED_WITH_ERRORS, osconfigpb.PatchJob_TIMED_OUT, osconfigpb.PatchJob_CANCELED:
+				return fmt.Errorf("patch job %q failure status %v with message: %q", job.GetName(), res.State, res.GetErrorMessage())
+			}
+		}
+	}
+}
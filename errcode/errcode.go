@@ -0,0 +1,132 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package errcode classifies agent and policy engine errors with stable
+// codes so failures can be aggregated by class instead of by message text.
+// The OS Config API only has error messages, so codes are reported as a
+// prefix of the message, see Format.
+package errcode
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Code is the class of an error.
+type Code string
+
+// Error codes, these are stable and must not be renamed.
+const (
+	// Network is a failure to reach a service or download a file.
+	Network Code = "NETWORK"
+	// Auth is a missing or rejected credential or permission.
+	Auth Code = "AUTH"
+	// PackageManager is a failure of a package manager like apt or yum.
+	PackageManager Code = "PACKAGE_MANAGER"
+	// Script is a failure of a user provided script or executable.
+	Script Code = "SCRIPT"
+	// Quota is a rejected request because of quota or rate limits.
+	Quota Code = "QUOTA"
+	// HungCommand is a command killed by the watchdog as it ran far past
+	// its timeout or stopped writing output.
+	HungCommand Code = "HUNG_COMMAND"
+	// Conflict is a resource that sets a different desired state for a file
+	// or package than another resource.
+	Conflict Code = "CONFLICT"
+	// Blocked is a resource or action refused by local policy on the
+	// instance, see agentconfig.ResourceTypeBlocked.
+	Blocked Code = "BLOCKED"
+	// BudgetExceeded is an OS policy or resource aborted as it ran past its
+	// time budget, see agentconfig.PolicyTimeBudget.
+	BudgetExceeded Code = "BUDGET_EXCEEDED"
+	// Paused is an OS policy not applied as its assignment is paused on the
+	// instance, see agentconfig.AssignmentPausedLocally.
+	Paused Code = "PAUSED"
+	// AlreadyPatching is a patch job not run as the agent is running
+	// another one.
+	AlreadyPatching Code = "ALREADY_PATCHING"
+	// Internal is any other error.
+	Internal Code = "INTERNAL"
+)
+
+type codeError struct {
+	code Code
+	err  error
+}
+
+func (e *codeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codeError) Unwrap() error {
+	return e.err
+}
+
+// Wrap attaches code to err, unless err can already be classified, the
+// message is unchanged. A nil err returns nil.
+func Wrap(code Code, err error) error {
+	if err == nil || Of(err, "") != "" {
+		return err
+	}
+	return &codeError{code: code, err: err}
+}
+
+// Of returns the code attached to err with Wrap, or derived from a gRPC
+// status, a Google API error or a network error in its chain. Other errors
+// are fallback.
+func Of(err error, fallback Code) Code {
+	var ce *codeError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		switch se.GRPCStatus().Code() {
+		case codes.Unauthenticated, codes.PermissionDenied:
+			return Auth
+		case codes.ResourceExhausted:
+			return Quota
+		case codes.Unavailable, codes.DeadlineExceeded:
+			return Network
+		}
+	}
+
+	var ge *googleapi.Error
+	if errors.As(err, &ge) {
+		switch ge.Code {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return Auth
+		case http.StatusTooManyRequests:
+			return Quota
+		}
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return Network
+	}
+	return fallback
+}
+
+// Format prefixes msg with code, like "[NETWORK] error downloading file".
+func Format(code Code, msg string) string {
+	return fmt.Sprintf("[%s] %s", code, msg)
+}
@@ -0,0 +1,102 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package errcode
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOf(t *testing.T) {
+	errTest := errors.New("test error")
+	tests := []struct {
+		desc string
+		err  error
+		want Code
+	}{
+		{"plain error", errTest, Internal},
+		{"wrapped", Wrap(Script, errTest), Script},
+		{"wrapped twice keeps first code", Wrap(Network, Wrap(PackageManager, errTest)), PackageManager},
+		{"wrapped with %w", fmt.Errorf("context: %w", Wrap(Quota, errTest)), Quota},
+		{"grpc unauthenticated", status.Error(codes.Unauthenticated, "no token"), Auth},
+		{"grpc permission denied", status.Error(codes.PermissionDenied, "denied"), Auth},
+		{"grpc resource exhausted", status.Error(codes.ResourceExhausted, "quota"), Quota},
+		{"grpc unavailable", status.Error(codes.Unavailable, "unavailable"), Network},
+		{"grpc not found", status.Error(codes.NotFound, "not found"), Internal},
+		{"googleapi forbidden", &googleapi.Error{Code: http.StatusForbidden}, Auth},
+		{"googleapi too many requests", fmt.Errorf("fetching: %w", &googleapi.Error{Code: http.StatusTooManyRequests}), Quota},
+		{"net error", &net.OpError{Op: "dial", Err: errTest}, Network},
+		{"wrap does not override a network error", Wrap(PackageManager, &net.OpError{Op: "dial", Err: errTest}), Network},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := Of(tt.err, Internal); got != tt.want {
+				t.Errorf("Of(%v): got %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	if err := Wrap(Script, nil); err != nil {
+		t.Errorf("Wrap(nil): got %v, want nil", err)
+	}
+	errTest := errors.New("test error")
+	err := Wrap(Script, errTest)
+	if err.Error() != errTest.Error() {
+		t.Errorf("Wrap changed the message: got %q, want %q", err, errTest)
+	}
+	if !errors.Is(err, errTest) {
+		t.Error("Wrap: want the wrapped error in the chain")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if got, want := Format(Network, "error downloading file"), "[NETWORK] error downloading file"; got != want {
+		t.Errorf("Format: got %q, want %q", got, want)
+	}
+}
+
+func TestHint(t *testing.T) {
+	tests := []struct {
+		desc string
+		msg  string
+		want string
+	}{
+		{"no hint", "error running apt-get: exit status 1", ""},
+		{"missing key", "W: GPG error: https://example.com stable InRelease: The following signatures couldn't be verified because the public key is not available: NO_PUBKEY B53DC80D13EDEF05", "the signing key B53DC80D13EDEF05 of an apt repository is missing, add it to the keyring of the repository"},
+		{"held packages", "E: Unable to correct problems, you have held broken packages.", "apt can't resolve the dependencies, check for held packages with apt-mark showhold and for repositories of another release"},
+		{"dpkg lock", "E: Could not get lock /var/lib/dpkg/lock-frontend. It is held by process 1234 (unattended-upgr)", "another process, like unattended-upgrades, holds the dpkg lock, let it finish or disable it"},
+		{"repo 404", "E: Failed to fetch https://example.com/dists/bookworm/main/binary-amd64/Packages  404  Not Found [IP: 10.0.0.1 443]", "a repository returned 404 Not Found, check that its URL, release and components exist for this OS version"},
+		{"yum gpg", "Public key for foo-1.0-1.x86_64.rpm is not installed", "the GPG key of a yum repository is missing, set gpgkey in the repository or import it with rpm --import"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := Hint(tt.msg); got != tt.want {
+				t.Errorf("Hint(%q): got %q, want %q", tt.msg, got, tt.want)
+			}
+		})
+	}
+	if got, want := WithHint("dpkg was interrupted"), "dpkg was interrupted Hint: a previous installation was interrupted, run dpkg --configure -a."; got != want {
+		t.Errorf("WithHint: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,52 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package errcode
+
+import "regexp"
+
+// hints map common package manager errors to remediation text, the first
+// match is used. $1 is replaced with the first submatch.
+var hints = []struct {
+	re   *regexp.Regexp
+	hint string
+}{
+	{regexp.MustCompile(`NO_PUBKEY ([0-9A-Fa-f]+)`), "the signing key $1 of an apt repository is missing, add it to the keyring of the repository"},
+	{regexp.MustCompile(`held broken packages|[Uu]nmet dependencies`), "apt can't resolve the dependencies, check for held packages with apt-mark showhold and for repositories of another release"},
+	{regexp.MustCompile(`Could not get lock|dpkg frontend lock|/var/lib/dpkg/lock`), "another process, like unattended-upgrades, holds the dpkg lock, let it finish or disable it"},
+	{regexp.MustCompile(`dpkg was interrupted`), "a previous installation was interrupted, run dpkg --configure -a"},
+	{regexp.MustCompile(`GPG check FAILED|Public key for \S+ is not installed`), "the GPG key of a yum repository is missing, set gpgkey in the repository or import it with rpm --import"},
+	{regexp.MustCompile(`Existing lock /var/run/yum\.pid|Waiting for process with pid`), "another yum or dnf process holds the lock, let it finish or stop it"},
+	{regexp.MustCompile(`System management is locked`), "another zypper process, like PackageKit, holds the lock, let it finish or stop it"},
+	{regexp.MustCompile(`\b404\s+Not Found|status code 404|Error 404`), "a repository returned 404 Not Found, check that its URL, release and components exist for this OS version"},
+}
+
+// Hint returns remediation text for a common package manager error in msg,
+// empty if there is none.
+func Hint(msg string) string {
+	for _, h := range hints {
+		if m := h.re.FindStringSubmatchIndex(msg); m != nil {
+			return string(h.re.ExpandString(nil, h.hint, msg, m))
+		}
+	}
+	return ""
+}
+
+// WithHint appends the Hint for msg to msg, if there is one.
+func WithHint(msg string) string {
+	if h := Hint(msg); h != "" {
+		return msg + " Hint: " + h + "."
+	}
+	return msg
+}
@@ -0,0 +1,114 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+// agentPackageName is the package the agent is released as.
+const agentPackageName = "google-osconfig-agent"
+
+// AgentIdentity identifies the running agent binary so fleet owners can
+// detect tampered or sideloaded agent builds.
+type AgentIdentity struct {
+	Path   string
+	SHA256 string
+	// Package is the package that installed the binary, nil if it was not
+	// installed by a package manager.
+	Package *packages.PkgInfo `json:",omitempty"`
+	// Modified is set when the binary differs from the one its package
+	// installed.
+	Modified bool   `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+var (
+	agentExecutable = os.Executable
+
+	agentIdentityOnce sync.Once
+	agentIdentity     *AgentIdentity
+)
+
+// GetAgentIdentity returns the identity of the running agent binary, it is
+// only gathered once.
+func GetAgentIdentity(ctx context.Context) *AgentIdentity {
+	agentIdentityOnce.Do(func() { agentIdentity = getAgentIdentity(ctx) })
+	return agentIdentity
+}
+
+func getAgentIdentity(ctx context.Context) *AgentIdentity {
+	id := &AgentIdentity{}
+	path, err := agentExecutable()
+	if err == nil {
+		path, err = filepath.EvalSymlinks(path)
+	}
+	if err != nil {
+		id.Error = err.Error()
+		return id
+	}
+	id.Path = path
+
+	f, err := os.Open(path)
+	if err != nil {
+		id.Error = err.Error()
+		return id
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		id.Error = err.Error()
+		return id
+	}
+	id.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	if packages.GooGetExists {
+		// GooGet does not record the files of a package.
+		pkgs, err := packages.InstalledGooGetPackages(ctx)
+		if err != nil {
+			clog.Debugf(ctx, "Error listing googet packages for the agent identity: %v", err)
+		}
+		for _, pkg := range pkgs {
+			if pkg.Name == agentPackageName {
+				id.Package = pkg
+			}
+		}
+		return id
+	}
+
+	if id.Package, err = packages.OwningPackage(ctx, path); err != nil {
+		clog.Debugf(ctx, "Error getting the package of the agent: %v", err)
+		return id
+	}
+	if id.Package == nil {
+		clog.Warningf(ctx, "The agent binary %q was not installed by a package manager.", path)
+		return id
+	}
+	if id.Modified, err = packages.PackageFileModified(ctx, id.Package, path); err != nil {
+		clog.Debugf(ctx, "Error verifying the agent binary: %v", err)
+	} else if id.Modified {
+		clog.Warningf(ctx, "The agent binary %q differs from the one installed by package %s.", path, id.Package)
+	}
+	return id
+}
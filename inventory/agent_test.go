@@ -0,0 +1,55 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+func TestGetAgentIdentity(t *testing.T) {
+	defer func(f func() (string, error)) { agentExecutable = f }(agentExecutable)
+	defer func(dq, rq, gg bool) {
+		packages.DpkgQueryExists, packages.RPMQueryExists, packages.GooGetExists = dq, rq, gg
+	}(packages.DpkgQueryExists, packages.RPMQueryExists, packages.GooGetExists)
+	packages.DpkgQueryExists, packages.RPMQueryExists, packages.GooGetExists = false, false, false
+
+	path := filepath.Join(t.TempDir(), "google_osconfig_agent")
+	if err := os.WriteFile(path, []byte("agent"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	agentExecutable = func() (string, error) { return path, nil }
+
+	id := getAgentIdentity(context.Background())
+	want := "d4f0bc5a29de06b510f9aa428f1eedba926012b591fef7a518e776a7c9bd1824"
+	if id.Path != path || id.Error != "" {
+		t.Errorf("getAgentIdentity: got path %q, error %q, want %q", id.Path, id.Error, path)
+	}
+	if id.SHA256 != want {
+		t.Errorf("getAgentIdentity: got SHA256 %q, want %q", id.SHA256, want)
+	}
+	if id.Package != nil || id.Modified {
+		t.Errorf("getAgentIdentity: got package %v, modified %t, want no package", id.Package, id.Modified)
+	}
+
+	agentExecutable = func() (string, error) { return filepath.Join(t.TempDir(), "missing"), nil }
+	if id := getAgentIdentity(context.Background()); id.Error == "" || id.SHA256 != "" {
+		t.Errorf("getAgentIdentity of a missing binary: got %+v, want an error", id)
+	}
+}
@@ -0,0 +1,149 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AntivirusStatus is the state of the antivirus products of a Windows
+// instance.
+type AntivirusStatus struct {
+	// Defender is the state of Microsoft Defender Antivirus, nil if it is not
+	// installed or its service is not running.
+	Defender *DefenderStatus `json:",omitempty"`
+	// Products are the antivirus products registered with Security Center,
+	// Defender included. Security Center is not available on Windows Server.
+	Products []*AntivirusProduct `json:",omitempty"`
+}
+
+// DefenderStatus is the state of Microsoft Defender Antivirus, times are zero
+// if they are not known, like a full scan that never ran.
+type DefenderStatus struct {
+	Enabled            bool
+	RealTimeProtection bool
+	SignatureVersion   string
+	SignatureUpdated   time.Time
+	LastQuickScan      time.Time
+	LastFullScan       time.Time
+}
+
+// AntivirusProduct is an antivirus product registered with Security Center.
+type AntivirusProduct struct {
+	Name string
+	// Path is the executable reporting the state of the product.
+	Path     string
+	Enabled  bool
+	UpToDate bool
+}
+
+// Security Center product state flags, the second byte is the scanner state
+// and the third the signature state.
+const (
+	productScannerOn       = 0x1000
+	productSignaturesStale = 0x10
+)
+
+// parseAntivirus parses the output of windowsAntivirusScript.
+func parseAntivirus(out []byte) (*AntivirusStatus, error) {
+	var v struct {
+		Defender *struct {
+			Enabled, RealTimeProtection                                     bool
+			SignatureVersion, SignatureUpdated, LastQuickScan, LastFullScan string
+		}
+		Products []struct {
+			Name, Path string
+			State      int
+		}
+	}
+	if err := json.Unmarshal(out, &v); err != nil {
+		return nil, err
+	}
+
+	status := &AntivirusStatus{}
+	if d := v.Defender; d != nil {
+		status.Defender = &DefenderStatus{Enabled: d.Enabled, RealTimeProtection: d.RealTimeProtection, SignatureVersion: d.SignatureVersion}
+		for _, t := range []struct {
+			s string
+			t *time.Time
+		}{
+			{d.SignatureUpdated, &status.Defender.SignatureUpdated},
+			{d.LastQuickScan, &status.Defender.LastQuickScan},
+			{d.LastFullScan, &status.Defender.LastFullScan},
+		} {
+			if t.s == "" {
+				continue
+			}
+			parsed, err := time.Parse(time.RFC3339Nano, t.s)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing Defender time %q: %v", t.s, err)
+			}
+			*t.t = parsed.UTC()
+		}
+	}
+	for _, p := range v.Products {
+		status.Products = append(status.Products, &AntivirusProduct{
+			Name:     p.Name,
+			Path:     p.Path,
+			Enabled:  p.State&productScannerOn != 0,
+			UpToDate: p.State&productSignaturesStale == 0,
+		})
+	}
+	return status, nil
+}
+
+// antivirusSummary summarizes status for WriteText, like "Defender enabled,
+// signatures 1.403.7.0 (2024-01-02); Sophos disabled".
+func antivirusSummary(status *AntivirusStatus) string {
+	var parts []string
+	if d := status.Defender; d != nil {
+		s := "Defender " + onOff(d.Enabled)
+		if d.Enabled && !d.RealTimeProtection {
+			s += " without real-time protection"
+		}
+		if d.SignatureVersion != "" {
+			s += ", signatures " + d.SignatureVersion
+			if !d.SignatureUpdated.IsZero() {
+				s += " (" + d.SignatureUpdated.Format("2006-01-02") + ")"
+			}
+		}
+		parts = append(parts, s)
+	}
+	for _, p := range status.Products {
+		// Defender is already reported from its own status.
+		if status.Defender != nil && strings.Contains(p.Name, "Defender") {
+			continue
+		}
+		s := p.Name + " " + onOff(p.Enabled)
+		if !p.UpToDate {
+			s += ", out of date"
+		}
+		parts = append(parts, s)
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, "; ")
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
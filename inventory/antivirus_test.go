@@ -0,0 +1,72 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseAntivirus(t *testing.T) {
+	tests := []struct {
+		desc string
+		out  string
+		want *AntivirusStatus
+	}{
+		{
+			desc: "defender and third party",
+			out:  `{"Defender":{"Enabled":true,"RealTimeProtection":true,"SignatureVersion":"1.403.7.0","SignatureUpdated":"2024-01-02T03:04:05.1234567Z","LastQuickScan":"2024-01-01T00:00:00.0000000Z","LastFullScan":""},"Products":[{"Name":"Windows Defender","Path":"windowsdefender://","State":397568},{"Name":"Acme AV","Path":"C:\\acme\\report.exe","State":262160}]}`,
+			want: &AntivirusStatus{
+				Defender: &DefenderStatus{
+					Enabled:            true,
+					RealTimeProtection: true,
+					SignatureVersion:   "1.403.7.0",
+					SignatureUpdated:   time.Date(2024, 1, 2, 3, 4, 5, 123456700, time.UTC),
+					LastQuickScan:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+				Products: []*AntivirusProduct{
+					{Name: "Windows Defender", Path: "windowsdefender://", Enabled: true, UpToDate: true},
+					{Name: "Acme AV", Path: `C:\acme\report.exe`, Enabled: false, UpToDate: false},
+				},
+			},
+		},
+		{
+			desc: "server without security center",
+			out:  `{"Defender":{"Enabled":false,"RealTimeProtection":false,"SignatureVersion":"","SignatureUpdated":"","LastQuickScan":"","LastFullScan":""},"Products":[]}`,
+			want: &AntivirusStatus{Defender: &DefenderStatus{}},
+		},
+		{
+			desc: "defender not running",
+			out:  `{"Defender":null,"Products":[]}`,
+			want: &AntivirusStatus{},
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseAntivirus([]byte(tt.out))
+		if err != nil {
+			t.Errorf("%s: parseAntivirus() error: %v", tt.desc, err)
+			continue
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s: parseAntivirus() mismatch (-want +got):\n%s", tt.desc, diff)
+		}
+	}
+
+	if _, err := parseAntivirus([]byte(`{"Defender":{"SignatureUpdated":"yesterday"}}`)); err == nil {
+		t.Error("parseAntivirus() with a bad time: want error")
+	}
+}
@@ -0,0 +1,54 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"fmt"
+)
+
+// windowsAntivirusScript writes the Defender status, if its service is
+// running, and the antivirus products registered with Security Center as
+// JSON, times in UTC.
+const windowsAntivirusScript = `$ErrorActionPreference = 'Stop'
+function Format-Time($t) { if ($t) { $t.ToUniversalTime().ToString('o') } else { '' } }
+$defender = $null
+if (Get-Command Get-MpComputerStatus -ErrorAction SilentlyContinue) {
+  try {
+    $s = Get-MpComputerStatus
+    $defender = [pscustomobject]@{
+      Enabled = [bool]$s.AntivirusEnabled
+      RealTimeProtection = [bool]$s.RealTimeProtectionEnabled
+      SignatureVersion = [string]$s.AntivirusSignatureVersion
+      SignatureUpdated = Format-Time $s.AntivirusSignatureLastUpdated
+      LastQuickScan = Format-Time $s.QuickScanEndTime
+      LastFullScan = Format-Time $s.FullScanEndTime
+    }
+  } catch {}
+}
+$products = @(Get-CimInstance -Namespace root/SecurityCenter2 -ClassName AntiVirusProduct -ErrorAction SilentlyContinue | ForEach-Object {
+  [pscustomobject]@{ Name = [string]$_.displayName; Path = [string]$_.pathToSignedReportingExe; State = [int]$_.productState }
+})
+ConvertTo-Json -Compress -Depth 3 -InputObject ([pscustomobject]@{ Defender = $defender; Products = $products })`
+
+// Antivirus returns the state of Microsoft Defender and the antivirus
+// products registered with Security Center.
+func Antivirus(ctx context.Context) (*AntivirusStatus, error) {
+	stdout, err := runPowerShell(ctx, windowsAntivirusScript)
+	if err != nil {
+		return nil, fmt.Errorf("error getting antivirus status: %v", err)
+	}
+	return parseAntivirus(stdout)
+}
@@ -0,0 +1,72 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+// SelectCollectors returns defaults plus enabled less disabled, unknown
+// collector names are logged and ignored.
+func SelectCollectors(ctx context.Context, defaults, enabled, disabled []string) packages.Collectors {
+	known := map[string]bool{}
+	for _, name := range packages.DefaultCollectors {
+		known[name] = true
+	}
+	for _, name := range packages.OptionalCollectors {
+		known[name] = true
+	}
+
+	c := packages.Collectors{}
+	for _, name := range defaults {
+		c[name] = true
+	}
+	for _, name := range enabled {
+		if name = strings.ToLower(name); known[name] {
+			c[name] = true
+		} else {
+			clog.Warningf(ctx, "Unknown inventory collector %q enabled, known collectors are %s.", name, sortedNames(known))
+		}
+	}
+	for _, name := range disabled {
+		if name = strings.ToLower(name); known[name] {
+			delete(c, name)
+		} else {
+			clog.Warningf(ctx, "Unknown inventory collector %q disabled, known collectors are %s.", name, sortedNames(known))
+		}
+	}
+	return c
+}
+
+// CollectorNames returns the sorted names of the enabled collectors in c.
+func CollectorNames(c packages.Collectors) string {
+	return sortedNames(c)
+}
+
+func sortedNames(set map[string]bool) string {
+	var names []string
+	for name, ok := range set {
+		if ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,45 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+func TestSelectCollectors(t *testing.T) {
+	defaults := packages.DefaultCollectors
+	tests := []struct {
+		desc              string
+		enabled, disabled []string
+		want              map[string]bool
+	}{
+		{"defaults", nil, nil, map[string]bool{defaults[0]: true, packages.GoCollector: false}},
+		{"enable optional", []string{"Go", "licenses"}, nil, map[string]bool{defaults[0]: true, packages.GoCollector: true, packages.LicensesCollector: true, packages.CargoCollector: false}},
+		{"disable default", nil, []string{defaults[0]}, map[string]bool{defaults[0]: false}},
+		{"disable wins", []string{"go"}, []string{"go"}, map[string]bool{packages.GoCollector: false}},
+		{"unknown ignored", []string{"bogus"}, []string{"bogus"}, map[string]bool{"bogus": false, defaults[0]: true}},
+	}
+	for _, tt := range tests {
+		c := SelectCollectors(context.Background(), defaults, tt.enabled, tt.disabled)
+		for name, want := range tt.want {
+			if got := c.Enabled(name); got != want {
+				t.Errorf("%s: Enabled(%q) = %t, want %t", tt.desc, name, got, want)
+			}
+		}
+	}
+}
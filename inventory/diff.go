@@ -0,0 +1,241 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+// PackageChange is a package that differs between two inventories.
+type PackageChange struct {
+	Manager string
+	Name    string
+	Arch    string `json:",omitempty"`
+	// OldVersion is empty for an added package, NewVersion for a removed
+	// one.
+	OldVersion string `json:",omitempty"`
+	NewVersion string `json:",omitempty"`
+}
+
+func (c *PackageChange) String() string {
+	name := c.Name
+	if c.Arch != "" {
+		name += "." + c.Arch
+	}
+	switch {
+	case c.OldVersion == "":
+		return fmt.Sprintf("%s %s %s", c.Manager, name, c.NewVersion)
+	case c.NewVersion == "":
+		return fmt.Sprintf("%s %s %s", c.Manager, name, c.OldVersion)
+	}
+	return fmt.Sprintf("%s %s %s -> %s", c.Manager, name, c.OldVersion, c.NewVersion)
+}
+
+// Diff are the package changes between two inventories, see DiffPackages.
+type Diff struct {
+	Added      []*PackageChange
+	Removed    []*PackageChange
+	Upgraded   []*PackageChange
+	Downgraded []*PackageChange
+}
+
+// Empty reports whether there are no changes.
+func (d *Diff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Upgraded)+len(d.Downgraded) == 0
+}
+
+// pkgKey identifies a package of a package manager, several versions of a
+// package, like kernels, can be installed at once.
+type pkgKey struct {
+	manager, name, arch string
+}
+
+// pkgVersions returns the versions of each package in p.
+func pkgVersions(p *packages.Packages) map[pkgKey][]string {
+	versions := map[pkgKey][]string{}
+	if p == nil {
+		return versions
+	}
+	add := func(manager, name, arch, version string) {
+		k := pkgKey{manager, name, arch}
+		versions[k] = append(versions[k], version)
+	}
+	for _, l := range []struct {
+		manager string
+		pkgs    []*packages.PkgInfo
+	}{
+		{"yum", p.Yum}, {"rpm", p.Rpm}, {"apt", p.Apt}, {"deb", p.Deb}, {"zypper", p.Zypper},
+		{"pacman", p.Pacman}, {"flatpak", p.Flatpak}, {"cos", p.COS}, {"gem", p.Gem}, {"pip", p.Pip},
+		{"npm", p.Npm}, {"go", p.Go}, {"cargo", p.Cargo}, {"googet", p.GooGet},
+	} {
+		for _, pkg := range l.pkgs {
+			add(l.manager, pkg.Name, pkg.Arch, pkg.Version)
+		}
+	}
+	for _, patch := range p.ZypperPatches {
+		add("zypper patch", patch.Name, "", "")
+	}
+	for _, u := range p.WUA {
+		add("wua", u.Title, "", fmt.Sprint(u.RevisionNumber))
+	}
+	for _, q := range p.QFE {
+		add("qfe", q.HotFixID, "", "")
+	}
+	for _, a := range p.WindowsApplication {
+		add("windows application", a.DisplayName, "", a.DisplayVersion)
+	}
+	return versions
+}
+
+// compareVersions compares versions of manager, the rpm comparison is used
+// for any manager but apt and deb.
+func compareVersions(manager, a, b string) int {
+	if manager == "apt" || manager == "deb" {
+		return packages.CompareDebVersions(a, b)
+	}
+	return packages.CompareRPMVersions(a, b)
+}
+
+// DiffPackages returns the packages added, removed, upgraded or downgraded
+// from before to after. A package with a single version replaced by another
+// is an upgrade or downgrade, other version changes, like a new kernel next
+// to the old one, are additions and removals.
+func DiffPackages(before, after *packages.Packages) *Diff {
+	d := &Diff{}
+	bv, av := pkgVersions(before), pkgVersions(after)
+	keys := map[pkgKey]bool{}
+	for k := range bv {
+		keys[k] = true
+	}
+	for k := range av {
+		keys[k] = true
+	}
+	for k := range keys {
+		removed, added := subtract(bv[k], av[k]), subtract(av[k], bv[k])
+		if len(removed) == 1 && len(added) == 1 {
+			c := &PackageChange{Manager: k.manager, Name: k.name, Arch: k.arch, OldVersion: removed[0], NewVersion: added[0]}
+			if compareVersions(k.manager, added[0], removed[0]) < 0 {
+				d.Downgraded = append(d.Downgraded, c)
+			} else {
+				d.Upgraded = append(d.Upgraded, c)
+			}
+			continue
+		}
+		for _, v := range removed {
+			d.Removed = append(d.Removed, &PackageChange{Manager: k.manager, Name: k.name, Arch: k.arch, OldVersion: v})
+		}
+		for _, v := range added {
+			d.Added = append(d.Added, &PackageChange{Manager: k.manager, Name: k.name, Arch: k.arch, NewVersion: v})
+		}
+	}
+	for _, l := range [][]*PackageChange{d.Added, d.Removed, d.Upgraded, d.Downgraded} {
+		sort.Slice(l, func(i, j int) bool { return l[i].String() < l[j].String() })
+	}
+	return d
+}
+
+// subtract returns the versions in a that are not in b.
+func subtract(a, b []string) []string {
+	var out []string
+	for _, v := range a {
+		found := false
+		for _, w := range b {
+			if v == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// ReadInventory reads an inventory saved as JSON, like by inventory -local,
+// from a local file or a gs://bucket/object URL.
+func ReadInventory(ctx context.Context, src string) (*InstanceInventory, error) {
+	var data []byte
+	if strings.HasPrefix(src, "gs://") {
+		bucket, object, ok := strings.Cut(strings.TrimPrefix(src, "gs://"), "/")
+		if !ok || bucket == "" || object == "" {
+			return nil, fmt.Errorf("invalid GCS source %q, must be gs://bucket/object", src)
+		}
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error creating gcs client: %v", err)
+		}
+		defer client.Close()
+		r, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error reading inventory %q: %w", src, err)
+		}
+		defer r.Close()
+		if data, err = io.ReadAll(r); err != nil {
+			return nil, fmt.Errorf("error reading inventory %q: %w", src, err)
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(src); err != nil {
+			return nil, err
+		}
+	}
+
+	var inv InstanceInventory
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("error parsing inventory %q: %v", src, err)
+	}
+	return &inv, nil
+}
+
+// WriteDiff writes d in format, FormatJSON or FormatText.
+func WriteDiff(w io.Writer, d *Diff, format string) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	case FormatText:
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	if d.Empty() {
+		_, err := fmt.Fprintln(w, "No package changes.")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, l := range []struct {
+		label   string
+		changes []*PackageChange
+	}{
+		{"Added", d.Added}, {"Removed", d.Removed}, {"Upgraded", d.Upgraded}, {"Downgraded", d.Downgraded},
+	} {
+		for _, c := range l.changes {
+			fmt.Fprintf(tw, "%s:\t%s\n", l.label, c)
+		}
+	}
+	return tw.Flush()
+}
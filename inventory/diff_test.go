@@ -0,0 +1,139 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffPackages(t *testing.T) {
+	before := &packages.Packages{
+		Apt: []*packages.PkgInfo{
+			{Name: "curl", Arch: "x86_64", Version: "7.88.1-10"},
+			{Name: "vim", Arch: "x86_64", Version: "2:9.0.1378-2"},
+			{Name: "nano", Arch: "x86_64", Version: "7.2-1"},
+		},
+		Rpm: []*packages.PkgInfo{
+			{Name: "kernel", Arch: "x86_64", Version: "5.14.0-362.el9"},
+			{Name: "bash", Arch: "x86_64", Version: "5.1.8-6.el9"},
+		},
+		QFE: []*packages.QFEPackage{{HotFixID: "KB5001"}},
+	}
+	after := &packages.Packages{
+		Apt: []*packages.PkgInfo{
+			{Name: "curl", Arch: "x86_64", Version: "7.88.1-10+deb12u5"},
+			{Name: "vim", Arch: "x86_64", Version: "2:9.0.1378-1"},
+			{Name: "git", Arch: "x86_64", Version: "1:2.39.2-1.1"},
+		},
+		Rpm: []*packages.PkgInfo{
+			{Name: "kernel", Arch: "x86_64", Version: "5.14.0-362.el9"},
+			{Name: "kernel", Arch: "x86_64", Version: "5.14.0-427.el9"},
+			{Name: "bash", Arch: "x86_64", Version: "5.1.8-6.el9"},
+		},
+		QFE: []*packages.QFEPackage{{HotFixID: "KB5001"}, {HotFixID: "KB5002"}},
+	}
+
+	want := &Diff{
+		Added: []*PackageChange{
+			{Manager: "apt", Name: "git", Arch: "x86_64", NewVersion: "1:2.39.2-1.1"},
+			{Manager: "qfe", Name: "KB5002"},
+			{Manager: "rpm", Name: "kernel", Arch: "x86_64", NewVersion: "5.14.0-427.el9"},
+		},
+		Removed: []*PackageChange{
+			{Manager: "apt", Name: "nano", Arch: "x86_64", OldVersion: "7.2-1"},
+		},
+		Upgraded: []*PackageChange{
+			{Manager: "apt", Name: "curl", Arch: "x86_64", OldVersion: "7.88.1-10", NewVersion: "7.88.1-10+deb12u5"},
+		},
+		Downgraded: []*PackageChange{
+			{Manager: "apt", Name: "vim", Arch: "x86_64", OldVersion: "2:9.0.1378-2", NewVersion: "2:9.0.1378-1"},
+		},
+	}
+	if diff := cmp.Diff(want, DiffPackages(before, after)); diff != "" {
+		t.Errorf("DiffPackages() mismatch (-want +got):\n%s", diff)
+	}
+
+	if d := DiffPackages(before, before); !d.Empty() {
+		t.Errorf("DiffPackages() of the same packages: got %+v, want no changes", d)
+	}
+	if d := DiffPackages(nil, &packages.Packages{}); !d.Empty() {
+		t.Errorf("DiffPackages() of no packages: got %+v, want no changes", d)
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	d := &Diff{
+		Added:    []*PackageChange{{Manager: "apt", Name: "git", Arch: "x86_64", NewVersion: "1:2.39.2-1.1"}},
+		Upgraded: []*PackageChange{{Manager: "apt", Name: "curl", Arch: "x86_64", OldVersion: "7.88.1-10", NewVersion: "7.88.1-10+deb12u5"}},
+	}
+	tests := []struct {
+		desc string
+		d    *Diff
+		want string
+	}{
+		{"changes", d, "Added:     apt git.x86_64 1:2.39.2-1.1\nUpgraded:  apt curl.x86_64 7.88.1-10 -> 7.88.1-10+deb12u5\n"},
+		{"no changes", &Diff{}, "No package changes.\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteDiff(&buf, tt.d, FormatText); err != nil {
+			t.Fatalf("%s: WriteDiff: %v", tt.desc, err)
+		}
+		if diff := cmp.Diff(tt.want, buf.String()); diff != "" {
+			t.Errorf("%s: WriteDiff() mismatch (-want +got):\n%s", tt.desc, diff)
+		}
+	}
+
+	if err := WriteDiff(&bytes.Buffer{}, d, FormatManifest); err == nil {
+		t.Error("WriteDiff(FormatManifest): want an error")
+	}
+}
+
+func TestReadInventory(t *testing.T) {
+	inv := &InstanceInventory{
+		Hostname:          "host",
+		InstalledPackages: &packages.Packages{Apt: []*packages.PkgInfo{{Name: "curl", Arch: "x86_64", Version: "7.88.1-10"}}},
+	}
+	data, err := json.Marshal(inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadInventory(context.Background(), path)
+	if err != nil {
+		t.Fatalf("ReadInventory: %v", err)
+	}
+	if diff := cmp.Diff(inv, got); diff != "" {
+		t.Errorf("ReadInventory() mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, src := range []string{filepath.Join(t.TempDir(), "missing.json"), "gs://bucket"} {
+		if _, err := ReadInventory(context.Background(), src); err == nil {
+			t.Errorf("ReadInventory(%q): want an error", src)
+		}
+	}
+}
@@ -17,17 +17,31 @@
 package inventory
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
-	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/osinfo"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
+// SchemaVersion is the version of the inventory written to guest attributes,
+// see schema/README.md. It changes when a field is removed, renamed or
+// changes type or encoding, new fields do not change it.
+const SchemaVersion = "1"
+
 // InstanceInventory is an instances inventory data.
 type InstanceInventory struct {
+	SchemaVersion        string
 	Hostname             string
 	LongName             string
 	ShortName            string
@@ -36,31 +50,149 @@ type InstanceInventory struct {
 	KernelVersion        string
 	KernelRelease        string
 	OSConfigAgentVersion string
-	InstalledPackages    *packages.Packages
-	PackageUpdates       *packages.Packages
-	LastUpdated          string
+	// Image is the image the instance was created from. The digest of the
+	// source image of the boot disk is not available to the guest without
+	// the Compute Engine API, so it is not reported.
+	Image string
+	// InstanceCreated is when the instance was created, in RFC 3339 format,
+	// empty if not known.
+	InstanceCreated string
+	ImageID         string
+	ImageVersion    string
+	BuildID         string
+	// TPMVersion is the version of the (v)TPM, empty without one, and
+	// BootIntegrity the last Shielded VM integrity monitoring result, see
+	// Options.
+	TPMVersion        string
+	BootIntegrity     string
+	InstalledPackages *packages.Packages
+	PackageUpdates    *packages.Packages
+	ManagedRoots      []*ManagedRootInventory
+	// Repositories is the health of the configured package repositories,
+	// see packages.GetRepositoryHealth.
+	Repositories []*packages.RepositoryHealth
+	// ListeningPorts are the listening sockets, only collected when the
+	// ports collector is enabled, see ListeningPorts.
+	ListeningPorts []*ListeningPort
+	// ScheduledJobs are the cron jobs, systemd timers or scheduled tasks,
+	// only collected when the scheduledjobs collector is enabled, see
+	// ScheduledJobs.
+	ScheduledJobs []*ScheduledJob
+	// Antivirus is the state of the antivirus products on Windows, see
+	// Antivirus.
+	Antivirus *AntivirusStatus
+	// Agent identifies the agent binary, see GetAgentIdentity, it is only
+	// set by Get.
+	Agent       *AgentIdentity
+	LastUpdated string
+}
+
+// ManagedRootInventory is the inventory data of a managed root.
+// This is experimental.
+type ManagedRootInventory struct {
+	Name              string
+	Path              string
+	InstalledPackages *packages.Packages
+	Error             string `json:",omitempty"`
+}
+
+// Options select what Collect gathers.
+type Options struct {
+	// Collectors are the collectors to run, nil runs the
+	// packages.DefaultCollectors.
+	Collectors packages.Collectors
+	// BinaryPaths are scanned by the go and cargo collectors,
+	// packages.DefaultBinaryPaths if empty.
+	BinaryPaths []string
+	// PythonEnvPrefixes are scanned for Python environments by the pip
+	// collector.
+	PythonEnvPrefixes []string
+	// ManagedRoots are the managed roots to inventory, see
+	// packages.ParseManagedRoot.
+	ManagedRoots []string
+	// AgentVersion, Image and BootIntegrity are reported as is.
+	AgentVersion, Image, BootIntegrity string
+	// InstanceCreated is when the instance was created, zero if not known.
+	InstanceCreated time.Time
+	// AgentIdentity adds the identity of the running agent binary, see
+	// GetAgentIdentity.
+	AgentIdentity bool
 }
 
-// Get generates inventory data.
-func Get(ctx context.Context) *InstanceInventory {
+// Collect generates inventory data as selected by opts, it does not read the
+// agent configuration.
+func Collect(ctx context.Context, opts Options) *InstanceInventory {
 	clog.Debugf(ctx, "Gathering instance inventory.")
 
-	installedPackages, err := packages.GetInstalledPackages(ctx)
+	collectors := opts.Collectors
+	installedPackages, err := packages.GetInstalledPackages(ctx, collectors)
 	if err != nil {
 		clog.Errorf(ctx, "packages.GetInstalledPackages() error: %v", err)
 	}
+	if collectors.Enabled(packages.LicensesCollector) && installedPackages != nil {
+		if err := packages.AddLicenses(ctx, installedPackages); err != nil {
+			clog.Errorf(ctx, "packages.AddLicenses() error: %v", err)
+		}
+	}
+	if (collectors.Enabled(packages.GoCollector) || collectors.Enabled(packages.CargoCollector)) && installedPackages != nil {
+		paths := opts.BinaryPaths
+		if len(paths) == 0 {
+			paths = packages.DefaultBinaryPaths
+		}
+		gobins, cargo := packages.InstalledBinaries(ctx, paths)
+		if collectors.Enabled(packages.GoCollector) {
+			installedPackages.Go = gobins
+		}
+		if collectors.Enabled(packages.CargoCollector) {
+			installedPackages.Cargo = cargo
+		}
+	}
+	if prefixes := opts.PythonEnvPrefixes; len(prefixes) > 0 && collectors.Enabled("pip") && installedPackages != nil {
+		installedPackages.Pip = append(installedPackages.Pip, packages.InstalledPythonEnvPackages(ctx, prefixes)...)
+	}
 
-	packageUpdates, err := packages.GetPackageUpdates(ctx)
+	packageUpdates, err := packages.GetPackageUpdates(ctx, collectors)
 	if err != nil {
 		clog.Errorf(ctx, "packages.GetPackageUpdates() error: %v", err)
 	}
 
+	var repositories []*packages.RepositoryHealth
+	if collectors.Enabled(packages.RepositoriesCollector) {
+		repositories = packages.GetRepositoryHealth(ctx)
+	}
+
+	var ports []*ListeningPort
+	if collectors.Enabled(packages.PortsCollector) {
+		if ports, err = ListeningPorts(ctx); err != nil {
+			clog.Errorf(ctx, "ListeningPorts() error: %v", err)
+		}
+	}
+	var jobs []*ScheduledJob
+	if collectors.Enabled(packages.ScheduledJobsCollector) {
+		if jobs, err = ScheduledJobs(ctx); err != nil {
+			clog.Errorf(ctx, "ScheduledJobs() error: %v", err)
+		}
+	}
+
+	var antivirus *AntivirusStatus
+	if collectors.Enabled(packages.AntivirusCollector) {
+		if antivirus, err = Antivirus(ctx); err != nil {
+			clog.Errorf(ctx, "Antivirus() error: %v", err)
+		}
+	}
+
+	var agent *AgentIdentity
+	if opts.AgentIdentity {
+		agent = GetAgentIdentity(ctx)
+	}
+
 	oi, err := osinfo.Get()
 	if err != nil {
 		clog.Errorf(ctx, "osinfo.Get() error: %v", err)
 	}
 
 	return &InstanceInventory{
+		SchemaVersion:        SchemaVersion,
 		Hostname:             oi.Hostname,
 		LongName:             oi.LongName,
 		ShortName:            oi.ShortName,
@@ -68,9 +200,211 @@ func Get(ctx context.Context) *InstanceInventory {
 		KernelVersion:        oi.KernelVersion,
 		KernelRelease:        oi.KernelRelease,
 		Architecture:         oi.Architecture,
-		OSConfigAgentVersion: agentconfig.Version(),
+		OSConfigAgentVersion: opts.AgentVersion,
+		Image:                opts.Image,
+		InstanceCreated:      formatTime(opts.InstanceCreated),
+		ImageID:              oi.ImageID,
+		ImageVersion:         oi.ImageVersion,
+		BuildID:              oi.BuildID,
+		TPMVersion:           oi.TPMVersion,
+		BootIntegrity:        opts.BootIntegrity,
 		InstalledPackages:    installedPackages,
 		PackageUpdates:       packageUpdates,
+		ManagedRoots:         getManagedRoots(ctx, opts.ManagedRoots),
+		Repositories:         repositories,
+		ListeningPorts:       ports,
+		ScheduledJobs:        jobs,
+		Antivirus:            antivirus,
+		Agent:                agent,
 		LastUpdated:          time.Now().UTC().Format(time.RFC3339),
 	}
 }
+
+func getManagedRoots(ctx context.Context, specs []string) []*ManagedRootInventory {
+	var roots []*ManagedRootInventory
+	for _, spec := range specs {
+		root, err := packages.ParseManagedRoot(spec)
+		if err != nil {
+			clog.Errorf(ctx, "Skipping managed root: %v", err)
+			continue
+		}
+		clog.Debugf(ctx, "Gathering inventory for managed root %q.", root.Name)
+		inv := &ManagedRootInventory{Name: root.Name, Path: root.Path}
+		inv.InstalledPackages, err = packages.GetInstalledPackagesInRoot(ctx, root)
+		if err != nil {
+			clog.Errorf(ctx, "packages.GetInstalledPackagesInRoot(%q) error: %v", root.Name, err)
+			inv.Error = err.Error()
+		}
+		roots = append(roots, inv)
+	}
+	return roots
+}
+
+// formatTime returns t in RFC 3339 format, empty if it is zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Write writes inv as indented JSON.
+func Write(w io.Writer, inv *InstanceInventory) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(inv)
+}
+
+// Output formats for WriteLocal.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+	// FormatManifest is the package set manifest of the installed packages,
+	// see packages.Manifest.
+	FormatManifest = "manifest"
+)
+
+// WriteManifest writes the package set manifest of the installed packages
+// in inv.
+func WriteManifest(w io.Writer, inv *InstanceInventory) error {
+	m := &packages.Manifest{Packages: map[string]string{}}
+	if inv.InstalledPackages != nil {
+		m = packages.ManifestFromPackages(inv.InstalledPackages)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// WriteText writes a summary of inv with the number of packages per package
+// manager.
+func WriteText(w io.Writer, inv *InstanceInventory) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Hostname:\t%s\n", inv.Hostname)
+	fmt.Fprintf(tw, "OS:\t%s\n", inv.LongName)
+	fmt.Fprintf(tw, "Kernel:\t%s\n", inv.KernelRelease)
+	fmt.Fprintf(tw, "Architecture:\t%s\n", inv.Architecture)
+	if inv.TPMVersion != "" {
+		fmt.Fprintf(tw, "TPM:\t%s\n", inv.TPMVersion)
+	}
+	if inv.BootIntegrity != "" {
+		fmt.Fprintf(tw, "Boot integrity:\t%s\n", inv.BootIntegrity)
+	}
+	fmt.Fprintf(tw, "Agent version:\t%s\n", inv.OSConfigAgentVersion)
+	if a := inv.Agent; a != nil && a.SHA256 != "" {
+		origin := "not installed by a package manager"
+		if a.Package != nil {
+			origin = fmt.Sprintf("package %s %s", a.Package.Name, a.Package.Version)
+			if a.Package.Repository != "" {
+				origin += " from " + a.Package.Repository
+			}
+			if a.Modified {
+				origin += ", MODIFIED"
+			}
+		}
+		fmt.Fprintf(tw, "Agent binary:\t%s sha256:%s (%s)\n", a.Path, a.SHA256, origin)
+	}
+	fmt.Fprintf(tw, "Installed packages:\t%s\n", packageCounts(inv.InstalledPackages))
+	fmt.Fprintf(tw, "Package updates:\t%s\n", packageCounts(inv.PackageUpdates))
+	for _, r := range inv.ManagedRoots {
+		counts := packageCounts(r.InstalledPackages)
+		if r.Error != "" {
+			counts = "error: " + r.Error
+		}
+		fmt.Fprintf(tw, "Managed root %s:\t%s\n", r.Name, counts)
+	}
+	for _, r := range inv.Repositories {
+		if r.Status != packages.RepoOK && r.Status != packages.RepoNotChecked {
+			fmt.Fprintf(tw, "Repository %s %s:\t%s: %s\n", r.Manager, r.URL, r.Status, r.Error)
+		}
+	}
+	for _, p := range inv.ListeningPorts {
+		if p.Exposure == ExposureLoopback {
+			continue
+		}
+		owner := "unknown process"
+		if p.Process != "" {
+			owner = fmt.Sprintf("%s (pid %d)", p.Process, p.PID)
+		}
+		fmt.Fprintf(tw, "Listening %s %s:\t%s\n", p.Protocol, net.JoinHostPort(p.Address, strconv.Itoa(p.Port)), owner)
+	}
+	if len(inv.ScheduledJobs) > 0 {
+		fmt.Fprintf(tw, "Scheduled jobs:\t%s\n", jobCounts(inv.ScheduledJobs))
+	}
+	if inv.Antivirus != nil {
+		fmt.Fprintf(tw, "Antivirus:\t%s\n", antivirusSummary(inv.Antivirus))
+	}
+	return tw.Flush()
+}
+
+// jobCounts returns the number of scheduled jobs per source, like cron 3,
+// systemd 12.
+func jobCounts(jobs []*ScheduledJob) string {
+	counts := map[string]int{}
+	var sources []string
+	for _, j := range jobs {
+		if counts[j.Source] == 0 {
+			sources = append(sources, j.Source)
+		}
+		counts[j.Source]++
+	}
+	for i, s := range sources {
+		sources[i] = fmt.Sprintf("%s %d", s, counts[s])
+	}
+	return strings.Join(sources, ", ")
+}
+
+func packageCounts(p *packages.Packages) string {
+	if p == nil {
+		return "none"
+	}
+	var counts []string
+	for _, c := range []struct {
+		name string
+		n    int
+	}{
+		{"yum", len(p.Yum)}, {"rpm", len(p.Rpm)}, {"apt", len(p.Apt)}, {"deb", len(p.Deb)},
+		{"zypper", len(p.Zypper)}, {"zypper patches", len(p.ZypperPatches)},
+		{"pacman", len(p.Pacman)}, {"flatpak", len(p.Flatpak)}, {"cos", len(p.COS)},
+		{"gem", len(p.Gem)}, {"pip", len(p.Pip)}, {"npm", len(p.Npm)},
+		{"go", len(p.Go)}, {"cargo", len(p.Cargo)}, {"googet", len(p.GooGet)}, {"wua", len(p.WUA)},
+		{"qfe", len(p.QFE)}, {"windows applications", len(p.WindowsApplication)},
+	} {
+		if c.n > 0 {
+			counts = append(counts, fmt.Sprintf("%s %d", c.name, c.n))
+		}
+	}
+	if len(counts) == 0 {
+		return "none"
+	}
+	return strings.Join(counts, ", ")
+}
+
+// WriteLocal writes inv in format to dest, or to w if dest is empty, without
+// reporting it.
+func WriteLocal(ctx context.Context, w io.Writer, inv *InstanceInventory, dest, format string) error {
+	write := Write
+	switch format {
+	case FormatJSON:
+	case FormatText:
+		write = WriteText
+	case FormatManifest:
+		write = WriteManifest
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	if dest == "" {
+		return write(w, inv)
+	}
+
+	var buf bytes.Buffer
+	if err := write(&buf, inv); err != nil {
+		return err
+	}
+	if err := util.AtomicWrite(dest, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing inventory to %q: %v", dest, err)
+	}
+	clog.Infof(ctx, "Wrote inventory to %q.", dest)
+	return nil
+}
@@ -0,0 +1,109 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWrite(t *testing.T) {
+	inv := &InstanceInventory{
+		Hostname:          "host",
+		ShortName:         "debian",
+		InstalledPackages: &packages.Packages{Deb: []*packages.PkgInfo{{Name: "bash", Arch: "x86_64", Version: "5.2"}}},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, inv); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	var got InstanceInventory
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Write() did not produce valid JSON: %v\n%s", err, buf.String())
+	}
+	if diff := cmp.Diff(inv, &got); diff != "" {
+		t.Errorf("Write() round trip mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	inv := &InstanceInventory{
+		Hostname:             "host",
+		LongName:             "Debian GNU/Linux 12 (bookworm)",
+		KernelRelease:        "6.1.0-18-cloud-amd64",
+		Architecture:         "x86_64",
+		TPMVersion:           "2.0",
+		BootIntegrity:        "passed",
+		OSConfigAgentVersion: "1.0",
+		InstalledPackages: &packages.Packages{
+			Deb: []*packages.PkgInfo{{Name: "bash"}, {Name: "curl"}},
+			Pip: []*packages.PkgInfo{{Name: "requests"}},
+		},
+		ManagedRoots: []*ManagedRootInventory{{Name: "chroot", Error: "no package manager"}},
+		ListeningPorts: []*ListeningPort{
+			{Protocol: "tcp", Address: "0.0.0.0", Port: 22, Exposure: ExposureAny, Process: "sshd", PID: 700},
+			{Protocol: "tcp", Address: "127.0.0.1", Port: 25, Exposure: ExposureLoopback},
+			{Protocol: "udp", Address: "::", Port: 5353, Exposure: ExposureAny},
+		},
+		ScheduledJobs: []*ScheduledJob{{Source: JobSourceCron}, {Source: JobSourceSystemd}, {Source: JobSourceCron}},
+		Antivirus: &AntivirusStatus{
+			Defender: &DefenderStatus{Enabled: true, SignatureVersion: "1.403.7.0", SignatureUpdated: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			Products: []*AntivirusProduct{{Name: "Windows Defender", Enabled: true, UpToDate: true}, {Name: "Acme AV", Enabled: true}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteText(&buf, inv); err != nil {
+		t.Fatalf("WriteText() error: %v", err)
+	}
+	want := "Hostname:                  host\n" +
+		"OS:                        Debian GNU/Linux 12 (bookworm)\n" +
+		"Kernel:                    6.1.0-18-cloud-amd64\n" +
+		"Architecture:              x86_64\n" +
+		"TPM:                       2.0\n" +
+		"Boot integrity:            passed\n" +
+		"Agent version:             1.0\n" +
+		"Installed packages:        deb 2, pip 1\n" +
+		"Package updates:           none\n" +
+		"Managed root chroot:       error: no package manager\n" +
+		"Listening tcp 0.0.0.0:22:  sshd (pid 700)\n" +
+		"Listening udp [::]:5353:   unknown process\n" +
+		"Scheduled jobs:            cron 2, systemd 1\n" +
+		"Antivirus:                 Defender enabled without real-time protection, signatures 1.403.7.0 (2024-01-02); Acme AV enabled, out of date\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("WriteText() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	inv := &InstanceInventory{
+		InstalledPackages: &packages.Packages{
+			Deb: []*packages.PkgInfo{{Name: "curl", Version: "7.88.1-10"}, {Name: "bash", Version: "5.2.15-2"}},
+			Pip: []*packages.PkgInfo{{Name: "requests", Version: "2.31.0"}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteManifest(&buf, inv); err != nil {
+		t.Fatalf("WriteManifest() error: %v", err)
+	}
+	want := "{\n  \"packages\": {\n    \"bash\": \"5.2.15-2\",\n    \"curl\": \"7.88.1-10\"\n  }\n}\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("WriteManifest() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,190 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Exposure of a listening socket, from the address it is bound to.
+const (
+	// ExposureLoopback sockets are only reachable from the instance.
+	ExposureLoopback = "loopback"
+	// ExposureAny sockets are bound to all addresses of the instance.
+	ExposureAny = "any"
+	// ExposureAddress sockets are bound to one non loopback address.
+	ExposureAddress = "address"
+)
+
+// ListeningPort is a listening TCP socket or a bound UDP socket. Firewall
+// rules are not evaluated, Exposure is from the bound address only.
+type ListeningPort struct {
+	// Protocol is tcp or udp, Family ipv4 or ipv6.
+	Protocol, Family string
+	Address          string
+	Port             int
+	// Exposure is one of ExposureLoopback, ExposureAny or ExposureAddress.
+	Exposure string
+	// Process and PID own the socket, when known.
+	Process string `json:",omitempty"`
+	PID     int    `json:",omitempty"`
+}
+
+// newListeningPort returns the port bound to addr, which is host:port with
+// an optional IPv6 zone or interface, like 127.0.0.53%lo:53 or [::]:22. A
+// wildcard * host, as printed by ss for dual stack sockets, is ::.
+func newListeningPort(protocol, addr string) (*ListeningPort, bool) {
+	i := strings.LastIndex(addr, ":")
+	if i < 0 {
+		return nil, false
+	}
+	port, err := strconv.Atoi(addr[i+1:])
+	if err != nil || port < 0 || port > 65535 {
+		return nil, false
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(addr[:i], "["), "]")
+	host, _, _ = strings.Cut(host, "%")
+	if host == "*" {
+		host = "::"
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil, false
+	}
+	ip = ip.Unmap()
+
+	p := &ListeningPort{Protocol: protocol, Family: "ipv6", Address: ip.String(), Port: port, Exposure: ExposureAddress}
+	if ip.Is4() {
+		p.Family = "ipv4"
+	}
+	switch {
+	case ip.IsLoopback():
+		p.Exposure = ExposureLoopback
+	case ip.IsUnspecified():
+		p.Exposure = ExposureAny
+	}
+	return p, true
+}
+
+// ssUsersRe matches the first process in ss -p output, like
+// users:(("sshd",pid=700,fd=4)).
+var ssUsersRe = regexp.MustCompile(`\("([^"]*)",pid=([0-9]+)`)
+
+// parseSS parses the output of ss -lntup.
+func parseSS(out []byte) []*ListeningPort {
+	var ports []*ListeningPort
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// Netid State Recv-Q Send-Q Local:Port Peer:Port [Process]
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || (fields[0] != "tcp" && fields[0] != "udp") {
+			continue
+		}
+		p, ok := newListeningPort(fields[0], fields[4])
+		if !ok {
+			continue
+		}
+		if len(fields) > 6 {
+			if m := ssUsersRe.FindStringSubmatch(strings.Join(fields[6:], " ")); m != nil {
+				p.Process = m[1]
+				p.PID, _ = strconv.Atoi(m[2])
+			}
+		}
+		ports = append(ports, p)
+	}
+	sortPorts(ports)
+	return ports
+}
+
+// parseNetstat parses the output of netstat -lntup.
+func parseNetstat(out []byte) []*ListeningPort {
+	var ports []*ListeningPort
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// Proto Recv-Q Send-Q Local Foreign [State] PID/Program
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		protocol := strings.TrimSuffix(fields[0], "6")
+		if (protocol != "tcp" || fields[5] != "LISTEN") && protocol != "udp" {
+			continue
+		}
+		p, ok := newListeningPort(protocol, fields[3])
+		if !ok {
+			continue
+		}
+		if pid, name, ok := strings.Cut(fields[len(fields)-1], "/"); ok {
+			p.PID, _ = strconv.Atoi(pid)
+			p.Process = name
+		}
+		ports = append(ports, p)
+	}
+	sortPorts(ports)
+	return ports
+}
+
+// netEndpoint is an endpoint from Get-NetTCPConnection or
+// Get-NetUDPEndpoint, see windowsPortsScript.
+type netEndpoint struct {
+	Protocol      string
+	LocalAddress  string
+	LocalPort     int
+	OwningProcess int
+	ProcessName   string
+}
+
+// parseNetEndpoints parses the JSON written by windowsPortsScript.
+func parseNetEndpoints(out []byte) ([]*ListeningPort, error) {
+	var endpoints []netEndpoint
+	if err := json.Unmarshal(out, &endpoints); err != nil {
+		return nil, err
+	}
+	var ports []*ListeningPort
+	for _, e := range endpoints {
+		host := e.LocalAddress
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		p, ok := newListeningPort(e.Protocol, host+":"+strconv.Itoa(e.LocalPort))
+		if !ok {
+			continue
+		}
+		p.PID, p.Process = e.OwningProcess, e.ProcessName
+		ports = append(ports, p)
+	}
+	sortPorts(ports)
+	return ports, nil
+}
+
+func sortPorts(ports []*ListeningPort) {
+	sort.Slice(ports, func(i, j int) bool {
+		a, b := ports[i], ports[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Address < b.Address
+	})
+}
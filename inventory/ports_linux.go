@@ -0,0 +1,41 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// ListeningPorts returns the listening TCP and bound UDP sockets with the
+// processes owning them, from ss or, if it is not installed, netstat.
+func ListeningPorts(ctx context.Context) ([]*ListeningPort, error) {
+	parse := parseSS
+	cmd, err := exec.LookPath("ss")
+	if err != nil {
+		if cmd, err = exec.LookPath("netstat"); err != nil {
+			return nil, fmt.Errorf("neither ss nor netstat is installed")
+		}
+		parse = parseNetstat
+	}
+	stdout, stderr, err := (&util.DefaultRunner{}).Run(ctx, exec.CommandContext(ctx, cmd, "-lntup"))
+	if err != nil {
+		return nil, fmt.Errorf("error running %s: %v, stderr: %q", cmd, err, stderr)
+	}
+	return parse(stdout), nil
+}
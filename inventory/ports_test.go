@@ -0,0 +1,86 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseSS(t *testing.T) {
+	out := []byte(`Netid State  Recv-Q Send-Q Local Address:Port  Peer Address:Port Process
+udp   UNCONN 0      0      127.0.0.53%lo:53         0.0.0.0:*     users:(("systemd-resolve",pid=600,fd=13))
+udp   UNCONN 0      0      0.0.0.0%eth0:68          0.0.0.0:*     users:(("dhclient",pid=512,fd=6))
+tcp   LISTEN 0      128          0.0.0.0:22         0.0.0.0:*     users:(("sshd",pid=700,fd=3),("sshd",pid=701,fd=3))
+tcp   LISTEN 0      128             [::]:22            [::]:*     users:(("sshd",pid=700,fd=4))
+tcp   LISTEN 0      511                *:80               *:*
+tcp   LISTEN 0      4096    [::ffff:10.128.0.2]:8080   *:*
+`)
+	want := []*ListeningPort{
+		{Protocol: "tcp", Family: "ipv4", Address: "0.0.0.0", Port: 22, Exposure: ExposureAny, Process: "sshd", PID: 700},
+		{Protocol: "tcp", Family: "ipv6", Address: "::", Port: 22, Exposure: ExposureAny, Process: "sshd", PID: 700},
+		{Protocol: "tcp", Family: "ipv6", Address: "::", Port: 80, Exposure: ExposureAny},
+		{Protocol: "tcp", Family: "ipv4", Address: "10.128.0.2", Port: 8080, Exposure: ExposureAddress},
+		{Protocol: "udp", Family: "ipv4", Address: "127.0.0.53", Port: 53, Exposure: ExposureLoopback, Process: "systemd-resolve", PID: 600},
+		{Protocol: "udp", Family: "ipv4", Address: "0.0.0.0", Port: 68, Exposure: ExposureAny, Process: "dhclient", PID: 512},
+	}
+	if diff := cmp.Diff(want, parseSS(out)); diff != "" {
+		t.Errorf("parseSS() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseNetstat(t *testing.T) {
+	out := []byte(`Active Internet connections (only servers)
+Proto Recv-Q Send-Q Local Address           Foreign Address         State       PID/Program name
+tcp        0      0 0.0.0.0:22              0.0.0.0:*               LISTEN      700/sshd
+tcp        0      0 127.0.0.1:25            0.0.0.0:*               LISTEN      -
+tcp6       0      0 :::22                   :::*                    LISTEN      700/sshd
+udp        0      0 0.0.0.0:68              0.0.0.0:*                           512/dhclient
+udp6       0      0 fe80::4001:aff:fe80:123 :::*                                -
+`)
+	want := []*ListeningPort{
+		{Protocol: "tcp", Family: "ipv4", Address: "0.0.0.0", Port: 22, Exposure: ExposureAny, Process: "sshd", PID: 700},
+		{Protocol: "tcp", Family: "ipv6", Address: "::", Port: 22, Exposure: ExposureAny, Process: "sshd", PID: 700},
+		{Protocol: "tcp", Family: "ipv4", Address: "127.0.0.1", Port: 25, Exposure: ExposureLoopback},
+		{Protocol: "udp", Family: "ipv4", Address: "0.0.0.0", Port: 68, Exposure: ExposureAny, Process: "dhclient", PID: 512},
+		{Protocol: "udp", Family: "ipv6", Address: "fe80::4001:aff:fe80", Port: 123, Exposure: ExposureAddress},
+	}
+	if diff := cmp.Diff(want, parseNetstat(out)); diff != "" {
+		t.Errorf("parseNetstat() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseNetEndpoints(t *testing.T) {
+	out := []byte(`[{"Protocol":"tcp","LocalAddress":"0.0.0.0","LocalPort":3389,"OwningProcess":1044,"ProcessName":"svchost"},` +
+		`{"Protocol":"tcp","LocalAddress":"::1","LocalPort":47001,"OwningProcess":4,"ProcessName":"System"},` +
+		`{"Protocol":"udp","LocalAddress":"10.128.0.5","LocalPort":137,"OwningProcess":4,"ProcessName":"System"}]`)
+	want := []*ListeningPort{
+		{Protocol: "tcp", Family: "ipv4", Address: "0.0.0.0", Port: 3389, Exposure: ExposureAny, Process: "svchost", PID: 1044},
+		{Protocol: "tcp", Family: "ipv6", Address: "::1", Port: 47001, Exposure: ExposureLoopback, Process: "System", PID: 4},
+		{Protocol: "udp", Family: "ipv4", Address: "10.128.0.5", Port: 137, Exposure: ExposureAddress, Process: "System", PID: 4},
+	}
+	got, err := parseNetEndpoints(out)
+	if err != nil {
+		t.Fatalf("parseNetEndpoints() error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseNetEndpoints() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := parseNetEndpoints([]byte("not json")); err == nil {
+		t.Error("parseNetEndpoints() of invalid JSON: want an error")
+	}
+}
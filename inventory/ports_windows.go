@@ -0,0 +1,40 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"fmt"
+)
+
+// windowsPortsScript writes the listening TCP and bound UDP endpoints with
+// the names of their owning processes as a JSON array of netEndpoint.
+const windowsPortsScript = `$ErrorActionPreference = 'Stop'
+$names = @{}
+Get-Process | ForEach-Object { $names[$_.Id] = $_.ProcessName }
+$endpoints = @(Get-NetTCPConnection -State Listen -ErrorAction SilentlyContinue | ForEach-Object { [pscustomobject]@{Protocol = 'tcp'; LocalAddress = $_.LocalAddress; LocalPort = [int]$_.LocalPort; OwningProcess = [int]$_.OwningProcess} })
+$endpoints += @(Get-NetUDPEndpoint -ErrorAction SilentlyContinue | ForEach-Object { [pscustomobject]@{Protocol = 'udp'; LocalAddress = $_.LocalAddress; LocalPort = [int]$_.LocalPort; OwningProcess = [int]$_.OwningProcess} })
+$endpoints | ForEach-Object { $_ | Add-Member -NotePropertyName ProcessName -NotePropertyValue $names[$_.OwningProcess] }
+ConvertTo-Json -Compress -InputObject $endpoints`
+
+// ListeningPorts returns the listening TCP and bound UDP endpoints with the
+// processes owning them, from Get-NetTCPConnection and Get-NetUDPEndpoint.
+func ListeningPorts(ctx context.Context) ([]*ListeningPort, error) {
+	stdout, err := runPowerShell(ctx, windowsPortsScript)
+	if err != nil {
+		return nil, fmt.Errorf("error listing endpoints: %v", err)
+	}
+	return parseNetEndpoints(stdout)
+}
@@ -0,0 +1,40 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// runPowerShell runs script and returns its stdout.
+func runPowerShell(ctx context.Context, script string) ([]byte, error) {
+	root := os.Getenv("SystemRoot")
+	if root == "" {
+		root = `C:\Windows`
+	}
+	powershell := filepath.Join(root, `System32\WindowsPowerShell\v1.0\PowerShell.exe`)
+	cmd := exec.CommandContext(ctx, powershell, "-NonInteractive", "-NoProfile", "-Command", script)
+	stdout, stderr, err := (&util.DefaultRunner{}).Run(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("%v, stderr: %q", err, stderr)
+	}
+	return stdout, nil
+}
@@ -0,0 +1,344 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	"github.com/google/uuid"
+)
+
+// SBOM formats supported by WriteSBOM.
+const (
+	SPDX      = "spdx"
+	CycloneDX = "cyclonedx"
+)
+
+// sbomComponent is a package in a format neutral form.
+type sbomComponent struct {
+	name, version, purl string
+	licenses            []string
+}
+
+func purl(typ, namespace, name, version, arch string) string {
+	p := "pkg:" + typ + "/"
+	// Namespace segments, like in golang module paths, are escaped separately.
+	for _, seg := range strings.Split(namespace, "/") {
+		if seg != "" {
+			p += url.PathEscape(seg) + "/"
+		}
+	}
+	p += url.PathEscape(name)
+	if version != "" {
+		p += "@" + url.PathEscape(version)
+	}
+	if arch != "" {
+		p += "?arch=" + url.QueryEscape(arch)
+	}
+	return p
+}
+
+func sbomComponents(inv *InstanceInventory) []sbomComponent {
+	pkgs := inv.InstalledPackages
+	if pkgs == nil {
+		return nil
+	}
+
+	var comps []sbomComponent
+	add := func(pkgs []*packages.PkgInfo, typ, namespace string, withArch bool) {
+		for _, pkg := range pkgs {
+			name := pkg.Name
+			if typ == "pypi" {
+				name = strings.ToLower(name)
+			}
+			arch := ""
+			if withArch {
+				arch = pkg.Arch
+			}
+			comps = append(comps, sbomComponent{
+				name:     pkg.Name,
+				version:  pkg.Version,
+				purl:     purl(typ, namespace, name, pkg.Version, arch),
+				licenses: pkg.Licenses,
+			})
+		}
+	}
+	add(pkgs.Rpm, "rpm", inv.ShortName, true)
+	add(pkgs.Deb, "deb", inv.ShortName, true)
+	add(pkgs.Pacman, "alpm", "arch", true)
+	add(pkgs.Flatpak, "generic", "flatpak", true)
+	add(pkgs.COS, "generic", "cos", false)
+	add(pkgs.Gem, "gem", "", false)
+	add(pkgs.Pip, "pypi", "", false)
+	for _, pkg := range pkgs.Npm {
+		// Scoped packages, @scope/name, have the scope as namespace.
+		namespace, name := path.Split(pkg.Name)
+		comps = append(comps, sbomComponent{
+			name:    pkg.Name,
+			version: pkg.Version,
+			purl:    purl("npm", strings.TrimSuffix(namespace, "/"), name, pkg.Version, ""),
+		})
+	}
+	for _, pkg := range pkgs.Go {
+		// The module path is the namespace and name, pkg:golang/github.com/foo/bar.
+		namespace, name := path.Split(pkg.Name)
+		comps = append(comps, sbomComponent{
+			name:    pkg.Name,
+			version: pkg.Version,
+			purl:    purl("golang", strings.TrimSuffix(namespace, "/"), name, pkg.Version, ""),
+		})
+	}
+	add(pkgs.Cargo, "cargo", "", false)
+	add(pkgs.GooGet, "generic", "googet", true)
+	for _, app := range pkgs.WindowsApplication {
+		comps = append(comps, sbomComponent{
+			name:    app.DisplayName,
+			version: app.DisplayVersion,
+			purl:    purl("generic", app.Publisher, app.DisplayName, app.DisplayVersion, ""),
+		})
+	}
+	return comps
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	PrimaryPurpose   string            `json:"primaryPackagePurpose,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	LicenseComments  string            `json:"licenseComments,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+func spdx(inv *InstanceInventory, created time.Time, id string) *spdxDocument {
+	const osID = "SPDXRef-OperatingSystem"
+	doc := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              inv.Hostname,
+		DocumentNamespace: "https://cloud.google.com/compute/docs/osconfig/sbom/" + id,
+		CreationInfo: spdxCreationInfo{
+			Created:  created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: google-osconfig-agent-" + inv.OSConfigAgentVersion},
+		},
+		Packages: []spdxPackage{{
+			Name:             inv.ShortName,
+			SPDXID:           osID,
+			VersionInfo:      inv.Version,
+			PrimaryPurpose:   "OPERATING-SYSTEM",
+			DownloadLocation: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+		}},
+		Relationships: []spdxRelationship{{
+			SPDXElementID:      "SPDXRef-DOCUMENT",
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: osID,
+		}},
+	}
+
+	for i, c := range sbomComponents(inv) {
+		pkg := spdxPackage{
+			Name:             c.name,
+			SPDXID:           fmt.Sprintf("SPDXRef-Package-%d", i),
+			VersionInfo:      c.version,
+			DownloadLocation: "NOASSERTION",
+			// Collected licenses are free-form rather than SPDX license
+			// expressions, so they are only recorded as comments.
+			LicenseDeclared: "NOASSERTION",
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  c.purl,
+			}},
+		}
+		if len(c.licenses) > 0 {
+			pkg.LicenseComments = "Declared licenses: " + strings.Join(c.licenses, ", ")
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      osID,
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: pkg.SPDXID,
+		})
+	}
+	return doc
+}
+
+type cdxDocument struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     []cdxTool    `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTool struct {
+	Vendor  string `json:"vendor"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type cdxComponent struct {
+	Type     string       `json:"type"`
+	BOMRef   string       `json:"bom-ref,omitempty"`
+	Name     string       `json:"name"`
+	Version  string       `json:"version,omitempty"`
+	PURL     string       `json:"purl,omitempty"`
+	Licenses []cdxLicense `json:"licenses,omitempty"`
+}
+
+type cdxLicense struct {
+	License cdxLicenseName `json:"license"`
+}
+
+type cdxLicenseName struct {
+	Name string `json:"name"`
+}
+
+func cycloneDX(inv *InstanceInventory, created time.Time, id string) *cdxDocument {
+	doc := &cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + id,
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: created.UTC().Format(time.RFC3339),
+			Tools:     []cdxTool{{Vendor: "Google", Name: "google-osconfig-agent", Version: inv.OSConfigAgentVersion}},
+			Component: cdxComponent{Type: "operating-system", Name: inv.ShortName, Version: inv.Version},
+		},
+	}
+
+	for _, c := range sbomComponents(inv) {
+		comp := cdxComponent{
+			Type:    "library",
+			BOMRef:  c.purl,
+			Name:    c.name,
+			Version: c.version,
+			PURL:    c.purl,
+		}
+		for _, l := range c.licenses {
+			comp.Licenses = append(comp.Licenses, cdxLicense{License: cdxLicenseName{Name: l}})
+		}
+		doc.Components = append(doc.Components, comp)
+	}
+	return doc
+}
+
+// WriteSBOM writes the installed packages in inv as an SBOM document in
+// the given format, SPDX or CycloneDX JSON.
+func WriteSBOM(w io.Writer, inv *InstanceInventory, format string) error {
+	var doc any
+	switch strings.ToLower(format) {
+	case SPDX:
+		doc = spdx(inv, time.Now(), uuid.New().String())
+	case CycloneDX:
+		doc = cycloneDX(inv, time.Now(), uuid.New().String())
+	default:
+		return fmt.Errorf("unknown SBOM format %q, must be %q or %q", format, SPDX, CycloneDX)
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(doc)
+}
+
+// ExportSBOM writes inv as an SBOM document to dest, which is a local path,
+// a gs://bucket/object URL or empty for w.
+func ExportSBOM(ctx context.Context, w io.Writer, inv *InstanceInventory, format, dest string) error {
+	switch {
+	case dest == "":
+		return WriteSBOM(w, inv, format)
+	case strings.HasPrefix(dest, "gs://"):
+		bucket, object, ok := strings.Cut(strings.TrimPrefix(dest, "gs://"), "/")
+		if !ok || bucket == "" || object == "" {
+			return fmt.Errorf("invalid GCS destination %q, must be gs://bucket/object", dest)
+		}
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return fmt.Errorf("error creating gcs client: %v", err)
+		}
+		defer client.Close()
+
+		w := client.Bucket(bucket).Object(object).NewWriter(ctx)
+		w.ContentType = "application/json"
+		if err := WriteSBOM(w, inv, format); err != nil {
+			w.Close()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("error writing SBOM to %q: %v", dest, err)
+		}
+	default:
+		var buf bytes.Buffer
+		if err := WriteSBOM(&buf, inv, format); err != nil {
+			return err
+		}
+		if err := util.AtomicWrite(dest, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("error writing SBOM to %q: %v", dest, err)
+		}
+	}
+	clog.Infof(ctx, "Wrote %s SBOM for %s to %q.", format, inv.Hostname, dest)
+	return nil
+}
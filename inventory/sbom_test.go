@@ -0,0 +1,108 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/google/go-cmp/cmp"
+)
+
+var sbomTestInventory = &InstanceInventory{
+	Hostname:             "host",
+	ShortName:            "debian",
+	Version:              "12",
+	OSConfigAgentVersion: "1.0",
+	InstalledPackages: &packages.Packages{
+		Deb: []*packages.PkgInfo{{Name: "bash", Arch: "x86_64", Version: "5.2.15-2+b2", Licenses: []string{"GPL-3+"}}},
+		Pip: []*packages.PkgInfo{{Name: "PyYAML", Arch: "all", Version: "6.0"}},
+	},
+}
+
+func TestPurl(t *testing.T) {
+	tests := []struct {
+		typ, namespace, name, version, arch string
+		want                                string
+	}{
+		{"deb", "debian", "bash", "5.2.15-2+b2", "x86_64", "pkg:deb/debian/bash@5.2.15-2+b2?arch=x86_64"},
+		{"rpm", "rhel", "kernel", "4.18.0-513.el8", "x86_64", "pkg:rpm/rhel/kernel@4.18.0-513.el8?arch=x86_64"},
+		{"pypi", "", "pyyaml", "6.0", "", "pkg:pypi/pyyaml@6.0"},
+		{"generic", "Some Vendor", "app name", "", "", "pkg:generic/Some%20Vendor/app%20name"},
+		{"golang", "github.com/foo", "bar", "v1.2.3", "", "pkg:golang/github.com/foo/bar@v1.2.3"},
+		{"cargo", "", "ripgrep", "14.1.0", "", "pkg:cargo/ripgrep@14.1.0"},
+	}
+	for _, tt := range tests {
+		if got := purl(tt.typ, tt.namespace, tt.name, tt.version, tt.arch); got != tt.want {
+			t.Errorf("purl(%q, %q, %q, %q, %q) = %q, want %q", tt.typ, tt.namespace, tt.name, tt.version, tt.arch, got, tt.want)
+		}
+	}
+}
+
+func TestSPDX(t *testing.T) {
+	doc := spdx(sbomTestInventory, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "id")
+
+	if doc.CreationInfo.Created != "2024-01-02T03:04:05Z" {
+		t.Errorf("unexpected created time: %q", doc.CreationInfo.Created)
+	}
+	want := []spdxPackage{
+		{Name: "debian", SPDXID: "SPDXRef-OperatingSystem", VersionInfo: "12", PrimaryPurpose: "OPERATING-SYSTEM", DownloadLocation: "NOASSERTION", LicenseDeclared: "NOASSERTION"},
+		{Name: "bash", SPDXID: "SPDXRef-Package-0", VersionInfo: "5.2.15-2+b2", DownloadLocation: "NOASSERTION", LicenseDeclared: "NOASSERTION", LicenseComments: "Declared licenses: GPL-3+",
+			ExternalRefs: []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: "pkg:deb/debian/bash@5.2.15-2+b2?arch=x86_64"}}},
+		{Name: "PyYAML", SPDXID: "SPDXRef-Package-1", VersionInfo: "6.0", DownloadLocation: "NOASSERTION", LicenseDeclared: "NOASSERTION",
+			ExternalRefs: []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: "pkg:pypi/pyyaml@6.0"}}},
+	}
+	if diff := cmp.Diff(want, doc.Packages); diff != "" {
+		t.Errorf("spdx() packages mismatch (-want +got):\n%s", diff)
+	}
+	if len(doc.Relationships) != 3 {
+		t.Errorf("spdx() got %d relationships, want 3", len(doc.Relationships))
+	}
+}
+
+func TestCycloneDX(t *testing.T) {
+	doc := cycloneDX(sbomTestInventory, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "id")
+
+	if doc.SerialNumber != "urn:uuid:id" {
+		t.Errorf("unexpected serial number: %q", doc.SerialNumber)
+	}
+	want := []cdxComponent{
+		{Type: "library", BOMRef: "pkg:deb/debian/bash@5.2.15-2+b2?arch=x86_64", Name: "bash", Version: "5.2.15-2+b2", PURL: "pkg:deb/debian/bash@5.2.15-2+b2?arch=x86_64", Licenses: []cdxLicense{{License: cdxLicenseName{Name: "GPL-3+"}}}},
+		{Type: "library", BOMRef: "pkg:pypi/pyyaml@6.0", Name: "PyYAML", Version: "6.0", PURL: "pkg:pypi/pyyaml@6.0"},
+	}
+	if diff := cmp.Diff(want, doc.Components); diff != "" {
+		t.Errorf("cycloneDX() components mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteSBOM(t *testing.T) {
+	for _, format := range []string{SPDX, CycloneDX, "SPDX"} {
+		var buf bytes.Buffer
+		if err := WriteSBOM(&buf, sbomTestInventory, format); err != nil {
+			t.Errorf("WriteSBOM(%q): unexpected error: %v", format, err)
+			continue
+		}
+		if !json.Valid(buf.Bytes()) {
+			t.Errorf("WriteSBOM(%q): wrote invalid JSON: %s", format, buf.String())
+		}
+	}
+
+	if err := WriteSBOM(&bytes.Buffer{}, sbomTestInventory, "unknown"); err == nil {
+		t.Errorf("WriteSBOM(unknown): expected error")
+	}
+}
@@ -0,0 +1,267 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// Sources of scheduled jobs.
+const (
+	JobSourceCron          = "cron"
+	JobSourceSystemd       = "systemd"
+	JobSourceTaskScheduler = "taskscheduler"
+)
+
+// ScheduledJob is a job run on a schedule, by cron, a systemd timer or the
+// Windows Task Scheduler.
+type ScheduledJob struct {
+	// Source is one of JobSourceCron, JobSourceSystemd or
+	// JobSourceTaskScheduler.
+	Source string
+	// Name is the crontab file and line, the timer unit or the task path.
+	Name string
+	// Schedule is like the crontab schedule, OnCalendar=daily or Daily.
+	Schedule string
+	// User runs the job, when known.
+	User    string `json:",omitempty"`
+	Command string
+	Enabled bool
+}
+
+// cronRunPartsSchedules are the schedules of the etc/cron.<schedule>
+// directories of scripts run by run-parts.
+var cronRunPartsSchedules = []string{"hourly", "daily", "weekly", "monthly"}
+
+// cronJobs returns the jobs of the system crontab, cron.d, the cron.hourly
+// to cron.monthly scripts and the user crontabs under root. Files that
+// can't be read are logged and skipped. Jobs are in the order cron reads
+// them.
+func cronJobs(ctx context.Context, root string) []*ScheduledJob {
+	var jobs []*ScheduledJob
+	read := func(path, user string) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				clog.Debugf(ctx, "Error reading crontab %q: %v", path, err)
+			}
+			return
+		}
+		jobs = append(jobs, parseCrontab("/"+strings.TrimPrefix(filepath.ToSlash(strings.TrimPrefix(path, root)), "/"), user, data)...)
+	}
+
+	read(filepath.Join(root, "etc/crontab"), "")
+	systemTabs, _ := filepath.Glob(filepath.Join(root, "etc/cron.d/*"))
+	for _, path := range systemTabs {
+		read(path, "")
+	}
+	// Debian keeps user crontabs in crontabs/, Red Hat and SUSE directly in
+	// the spool directory.
+	for _, dir := range []string{"var/spool/cron/crontabs", "var/spool/cron/tabs", "var/spool/cron"} {
+		entries, _ := os.ReadDir(filepath.Join(root, dir))
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				read(filepath.Join(root, dir, e.Name()), e.Name())
+			}
+		}
+	}
+
+	for _, schedule := range cronRunPartsSchedules {
+		dir := "etc/cron." + schedule
+		entries, _ := os.ReadDir(filepath.Join(root, dir))
+		for _, e := range entries {
+			// run-parts skips hidden files and the placeholders packages
+			// install.
+			if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			path := "/" + dir + "/" + e.Name()
+			jobs = append(jobs, &ScheduledJob{Source: JobSourceCron, Name: path, Schedule: schedule, User: "root", Command: path, Enabled: true})
+		}
+	}
+	return jobs
+}
+
+// cronEnvRe matches environment settings in crontabs, like SHELL=/bin/sh.
+var cronEnvRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\s*=`)
+
+// parseCrontab parses the crontab at path. A system crontab, with no user,
+// has a user field after the schedule.
+func parseCrontab(path, user string, data []byte) []*ScheduledJob {
+	var jobs []*ScheduledJob
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || cronEnvRe.MatchString(line) {
+			continue
+		}
+		scheduleFields := 5
+		if strings.HasPrefix(line, "@") {
+			scheduleFields = 1
+		}
+		userFields := 0
+		if user == "" {
+			userFields = 1
+		}
+		fields, command := splitFields(line, scheduleFields+userFields)
+		if command == "" {
+			continue
+		}
+		job := &ScheduledJob{
+			Source:   JobSourceCron,
+			Name:     fmt.Sprintf("%s:%d", path, n),
+			Schedule: strings.Join(fields[:scheduleFields], " "),
+			User:     user,
+			Command:  command,
+			Enabled:  true,
+		}
+		if user == "" {
+			job.User = fields[scheduleFields]
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// splitFields splits the first n whitespace separated fields off s, rest is
+// the remainder with its spacing kept, empty if s has n fields or less.
+func splitFields(s string, n int) (fields []string, rest string) {
+	for i := 0; i < n; i++ {
+		s = strings.TrimLeft(s, " \t")
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			return nil, ""
+		}
+		fields = append(fields, s[:end])
+		s = s[end:]
+	}
+	return fields, strings.TrimSpace(s)
+}
+
+// parseSystemdShow parses the output of systemctl show for several units,
+// blocks of Key=value lines separated by empty lines.
+func parseSystemdShow(out []byte) []map[string]string {
+	var units []map[string]string
+	var unit map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			unit = nil
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if unit == nil {
+			unit = map[string]string{}
+			units = append(units, unit)
+		}
+		unit[k] = v
+	}
+	return units
+}
+
+// systemdTimerRe matches the timers in TimersCalendar and TimersMonotonic,
+// like { OnCalendar=*-*-* 06:00:00 ; next_elapse=... }.
+var systemdTimerRe = regexp.MustCompile(`\{ (On[A-Za-z]+)=([^;]*?) ;`)
+
+// systemdArgvRe matches the command line in ExecStart, like
+// { path=/usr/bin/true ; argv[]=/usr/bin/true --flag ; ... }.
+var systemdArgvRe = regexp.MustCompile(`argv\[\]=([^;]*?) ;`)
+
+// systemdTimerJobs returns the jobs of timers, the output of systemctl show
+// of timer units, with the commands of the services they trigger from
+// services, the output of systemctl show of those services.
+func systemdTimerJobs(timers, services []byte) []*ScheduledJob {
+	commands := map[string]string{}
+	users := map[string]string{}
+	for _, s := range parseSystemdShow(services) {
+		var argv []string
+		for _, m := range systemdArgvRe.FindAllStringSubmatch(s["ExecStart"], -1) {
+			argv = append(argv, strings.TrimSpace(m[1]))
+		}
+		commands[s["Id"]] = strings.Join(argv, "; ")
+		users[s["Id"]] = s["User"]
+	}
+
+	var jobs []*ScheduledJob
+	for _, t := range parseSystemdShow(timers) {
+		if t["Id"] == "" {
+			continue
+		}
+		var schedule []string
+		for _, m := range systemdTimerRe.FindAllStringSubmatch(t["TimersCalendar"]+" "+t["TimersMonotonic"], -1) {
+			schedule = append(schedule, m[1]+"="+strings.TrimSpace(m[2]))
+		}
+		user := users[t["Unit"]]
+		if user == "" {
+			user = "root"
+		}
+		command := commands[t["Unit"]]
+		if command == "" {
+			command = t["Unit"]
+		}
+		jobs = append(jobs, &ScheduledJob{
+			Source:   JobSourceSystemd,
+			Name:     t["Id"],
+			Schedule: strings.Join(schedule, ", "),
+			User:     user,
+			Command:  command,
+			Enabled:  t["UnitFileState"] == "enabled" || t["ActiveState"] == "active",
+		})
+	}
+	sortJobs(jobs)
+	return jobs
+}
+
+// parseScheduledTasks parses the JSON written by windowsTasksScript.
+func parseScheduledTasks(out []byte) ([]*ScheduledJob, error) {
+	var tasks []struct {
+		Name, State, User, Command, Schedule string
+	}
+	if err := json.Unmarshal(out, &tasks); err != nil {
+		return nil, err
+	}
+	var jobs []*ScheduledJob
+	for _, t := range tasks {
+		jobs = append(jobs, &ScheduledJob{
+			Source:   JobSourceTaskScheduler,
+			Name:     t.Name,
+			Schedule: t.Schedule,
+			User:     t.User,
+			Command:  t.Command,
+			Enabled:  t.State != "Disabled",
+		})
+	}
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+func sortJobs(jobs []*ScheduledJob) {
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+}
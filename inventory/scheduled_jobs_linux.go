@@ -0,0 +1,86 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+const systemctl = "/bin/systemctl"
+
+// ScheduledJobs returns the cron jobs and systemd timers.
+func ScheduledJobs(ctx context.Context) ([]*ScheduledJob, error) {
+	jobs := cronJobs(ctx, "/")
+	if !util.Exists(systemctl) {
+		return jobs, nil
+	}
+	timers, err := systemdTimers(ctx)
+	if err != nil {
+		return jobs, err
+	}
+	return append(jobs, timers...), nil
+}
+
+func runSystemctl(ctx context.Context, args ...string) ([]byte, error) {
+	stdout, stderr, err := (&util.DefaultRunner{}).Run(ctx, exec.CommandContext(ctx, systemctl, args...))
+	if err != nil {
+		return nil, fmt.Errorf("error running systemctl %s: %v, stderr: %q", args[0], err, stderr)
+	}
+	return stdout, nil
+}
+
+// systemdTimers returns the jobs of all loaded timer units.
+func systemdTimers(ctx context.Context) ([]*ScheduledJob, error) {
+	out, err := runSystemctl(ctx, "list-units", "--type=timer", "--all", "--no-legend", "--no-pager", "--plain")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(out), "\n") {
+		for _, f := range strings.Fields(line) {
+			// Failed units may be marked with a bullet.
+			if strings.HasSuffix(f, ".timer") {
+				names = append(names, f)
+				break
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	timers, err := runSystemctl(ctx, append([]string{"show", "--property=Id,Unit,TimersCalendar,TimersMonotonic,ActiveState,UnitFileState", "--"}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+	var units []string
+	for _, t := range parseSystemdShow(timers) {
+		if t["Unit"] != "" {
+			units = append(units, t["Unit"])
+		}
+	}
+	var services []byte
+	if len(units) > 0 {
+		if services, err = runSystemctl(ctx, append([]string{"show", "--property=Id,ExecStart,User", "--"}, units...)...); err != nil {
+			return nil, err
+		}
+	}
+	return systemdTimerJobs(timers, services), nil
+}
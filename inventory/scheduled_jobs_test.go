@@ -0,0 +1,102 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCronJobs(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"etc/crontab":                   "SHELL=/bin/sh\n# m h dom mon dow user command\n17 *\t* * *\troot    cd / && run-parts --report /etc/cron.hourly\n",
+		"etc/cron.d/backup":             "MAILTO=\"\"\n\n@reboot backup /opt/backup/start.sh --quiet\n*/5 * * * * root\n",
+		"var/spool/cron/crontabs/alice": "# user crontab\n0 3 * * 1 /home/alice/report.sh > /tmp/report.log 2>&1\n",
+		"etc/cron.daily/logrotate":      "#!/bin/sh\n",
+		"etc/cron.daily/.placeholder":   "",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []*ScheduledJob{
+		{Source: JobSourceCron, Name: "/etc/crontab:3", Schedule: "17 * * * *", User: "root", Command: "cd / && run-parts --report /etc/cron.hourly", Enabled: true},
+		{Source: JobSourceCron, Name: "/etc/cron.d/backup:3", Schedule: "@reboot", User: "backup", Command: "/opt/backup/start.sh --quiet", Enabled: true},
+		{Source: JobSourceCron, Name: "/var/spool/cron/crontabs/alice:2", Schedule: "0 3 * * 1", User: "alice", Command: "/home/alice/report.sh > /tmp/report.log 2>&1", Enabled: true},
+		{Source: JobSourceCron, Name: "/etc/cron.daily/logrotate", Schedule: "daily", User: "root", Command: "/etc/cron.daily/logrotate", Enabled: true},
+	}
+	if diff := cmp.Diff(want, cronJobs(context.Background(), root)); diff != "" {
+		t.Errorf("cronJobs() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSystemdTimerJobs(t *testing.T) {
+	timers := []byte(`Id=apt-daily.timer
+Unit=apt-daily.service
+TimersCalendar={ OnCalendar=*-*-* 06,18:00:00 ; next_elapse=Thu 2024-05-02 06:00:00 UTC }
+TimersMonotonic=
+ActiveState=active
+UnitFileState=enabled
+
+Id=fstrim.timer
+Unit=fstrim.service
+TimersCalendar=
+TimersMonotonic={ OnBootUSec=15min ; next_elapse=0 } { OnUnitActiveUSec=1w ; next_elapse=0 }
+ActiveState=inactive
+UnitFileState=disabled
+`)
+	services := []byte(`Id=apt-daily.service
+ExecStart={ path=/usr/lib/apt/apt.systemd.daily ; argv[]=/usr/lib/apt/apt.systemd.daily update ; ignore_errors=no ; start_time=[n/a] ; stop_time=[n/a] ; pid=0 ; code=(null) ; status=0/0 }
+User=
+
+Id=fstrim.service
+ExecStart={ path=/sbin/fstrim ; argv[]=/sbin/fstrim --listed-in /etc/fstab ; ignore_errors=no ; start_time=[n/a] ; stop_time=[n/a] ; pid=0 ; code=(null) ; status=0/0 }
+User=nobody
+`)
+	want := []*ScheduledJob{
+		{Source: JobSourceSystemd, Name: "apt-daily.timer", Schedule: "OnCalendar=*-*-* 06,18:00:00", User: "root", Command: "/usr/lib/apt/apt.systemd.daily update", Enabled: true},
+		{Source: JobSourceSystemd, Name: "fstrim.timer", Schedule: "OnBootUSec=15min, OnUnitActiveUSec=1w", User: "nobody", Command: "/sbin/fstrim --listed-in /etc/fstab"},
+	}
+	if diff := cmp.Diff(want, systemdTimerJobs(timers, services)); diff != "" {
+		t.Errorf("systemdTimerJobs() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseScheduledTasks(t *testing.T) {
+	out := []byte(`[{"Name":"\\Updater","State":"Ready","User":"SYSTEM","Command":"C:\\ProgramData\\u.exe /silent","Schedule":"Logon, Daily"},` +
+		`{"Name":"\\Microsoft\\Windows\\Defrag\\ScheduledDefrag","State":"Disabled","User":"SYSTEM","Command":"%windir%\\system32\\defrag.exe -c","Schedule":""}]`)
+	want := []*ScheduledJob{
+		{Source: JobSourceTaskScheduler, Name: `\Microsoft\Windows\Defrag\ScheduledDefrag`, User: "SYSTEM", Command: `%windir%\system32\defrag.exe -c`},
+		{Source: JobSourceTaskScheduler, Name: `\Updater`, Schedule: "Logon, Daily", User: "SYSTEM", Command: `C:\ProgramData\u.exe /silent`, Enabled: true},
+	}
+	got, err := parseScheduledTasks(out)
+	if err != nil {
+		t.Fatalf("parseScheduledTasks() error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseScheduledTasks() mismatch (-want +got):\n%s", diff)
+	}
+}
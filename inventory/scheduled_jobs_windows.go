@@ -0,0 +1,43 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"fmt"
+)
+
+// windowsTasksScript writes the scheduled tasks as a JSON array with their
+// path, state, principal, actions and trigger types, like Daily or Logon.
+const windowsTasksScript = `$ErrorActionPreference = 'Stop'
+$tasks = @(Get-ScheduledTask | ForEach-Object {
+  [pscustomobject]@{
+    Name = $_.TaskPath + $_.TaskName
+    State = [string]$_.State
+    User = [string]$_.Principal.UserId
+    Command = (@($_.Actions | Where-Object { $_.Execute } | ForEach-Object { ($_.Execute + ' ' + $_.Arguments).Trim() }) -join '; ')
+    Schedule = (@($_.Triggers | Where-Object { $_ } | ForEach-Object { $_.CimClass.CimClassName -replace '^MSFT_Task', '' -replace 'Trigger$', '' }) -join ', ')
+  }
+})
+ConvertTo-Json -Compress -InputObject $tasks`
+
+// ScheduledJobs returns the Task Scheduler tasks.
+func ScheduledJobs(ctx context.Context) ([]*ScheduledJob, error) {
+	stdout, err := runPowerShell(ctx, windowsTasksScript)
+	if err != nil {
+		return nil, fmt.Errorf("error listing scheduled tasks: %v", err)
+	}
+	return parseScheduledTasks(stdout)
+}
@@ -0,0 +1,105 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/google/go-cmp/cmp"
+)
+
+type jsonSchema struct {
+	Const      string                 `json:"const"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	Defs       map[string]*jsonSchema `json:"$defs"`
+}
+
+// jsonNames returns the names the fields of t are marshaled as.
+func jsonNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = t.Field(i).Name
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func propertyNames(s *jsonSchema) []string {
+	var names []string
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TestSchema checks the schema of the current SchemaVersion describes every
+// field written to guest attributes, so the schema is updated with the types.
+func TestSchema(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("schema", "v"+SchemaVersion+".json"))
+	if err != nil {
+		t.Fatalf("no schema for SchemaVersion %q: %v", SchemaVersion, err)
+	}
+	var schema jsonSchema
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatalf("error parsing schema: %v", err)
+	}
+
+	if got := schema.Properties["SchemaVersion"].Const; got != SchemaVersion {
+		t.Errorf("schema SchemaVersion const: got %q, want %q", got, SchemaVersion)
+	}
+	if diff := cmp.Diff(jsonNames(reflect.TypeOf(InstanceInventory{})), propertyNames(&schema)); diff != "" {
+		t.Errorf("InstanceInventory fields do not match the schema: (-fields +schema)\n%s", diff)
+	}
+
+	for def, v := range map[string]interface{}{
+		"Packages":             packages.Packages{},
+		"PkgInfo":              packages.PkgInfo{},
+		"ZypperPatch":          packages.ZypperPatch{},
+		"WUAPackage":           packages.WUAPackage{},
+		"QFEPackage":           packages.QFEPackage{},
+		"ManagedRootInventory": ManagedRootInventory{},
+		"RepositoryHealth":     packages.RepositoryHealth{},
+		"ListeningPort":        ListeningPort{},
+		"ScheduledJob":         ScheduledJob{},
+		"AntivirusStatus":      AntivirusStatus{},
+		"DefenderStatus":       DefenderStatus{},
+		"AntivirusProduct":     AntivirusProduct{},
+		"AgentIdentity":        AgentIdentity{},
+	} {
+		s, ok := schema.Defs[def]
+		if !ok {
+			t.Errorf("schema has no definition for %s", def)
+			continue
+		}
+		if diff := cmp.Diff(jsonNames(reflect.TypeOf(v)), propertyNames(s)); diff != "" {
+			t.Errorf("%s fields do not match the schema: (-fields +schema)\n%s", def, diff)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package journal records the changes the agent makes to the host, like
+// installing packages, writing files and running scripts, in an append-only
+// local file so they can be reviewed later.
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// Actions recorded in the journal.
+const (
+	PackageInstall  = "package-install"
+	PackageRemove   = "package-remove"
+	FileWrite       = "file-write"
+	FileRemove      = "file-remove"
+	ScriptRun       = "script-run"
+	ResourceEnforce = "resource-enforce"
+)
+
+// maxSize is the size after which the journal is rotated, the previous
+// journal is kept with a .1 suffix.
+const maxSize = 10 << 20
+
+var (
+	mx   sync.Mutex
+	file string
+)
+
+// Entry is a single change made by the agent. The IDs are those of the task
+// or OS policy resource the change was made for, if any.
+type Entry struct {
+	Time             time.Time `json:"time"`
+	Action           string    `json:"action"`
+	Target           string    `json:"target"`
+	Error            string    `json:"error,omitempty"`
+	TaskID           string    `json:"taskId,omitempty"`
+	TaskType         string    `json:"taskType,omitempty"`
+	PolicyAssignment string    `json:"osPolicyAssignment,omitempty"`
+	PolicyID         string    `json:"osPolicyId,omitempty"`
+	ResourceID       string    `json:"resourceId,omitempty"`
+}
+
+// SetFile sets the journal file, changes are not recorded until it is set.
+func SetFile(path string) {
+	mx.Lock()
+	defer mx.Unlock()
+	file = path
+}
+
+// Record appends a change to the journal, err is the outcome of the change.
+// The task and OS policy IDs are read from the log labels of ctx. Failing to
+// write the journal is logged and does not fail the change.
+func Record(ctx context.Context, action, target string, err error) {
+	labels := clog.Labels(ctx)
+	e := Entry{
+		Time:             time.Now().UTC(),
+		Action:           action,
+		Target:           target,
+		TaskID:           labels["task_id"],
+		TaskType:         labels["task_type"],
+		PolicyAssignment: labels["os_policy_assignment"],
+		PolicyID:         labels["os_policy_id"],
+		ResourceID:       labels["resource_id"],
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if err := write(e); err != nil {
+		clog.Warningf(ctx, "Error writing change journal: %v", err)
+	}
+}
+
+func write(e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+	if file == "" {
+		return nil
+	}
+	if fi, err := os.Stat(file); err == nil && fi.Size()+int64(len(data)) > maxSize {
+		if err := os.Rename(file, file+".1"); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Query returns the entries in the journal file path recorded since the given
+// time, oldest first. If id is set only entries with a matching task, OS
+// policy assignment, OS policy or resource ID are returned.
+func Query(path string, since time.Time, id string) ([]Entry, error) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	var entries []Entry
+	for _, f := range []string{path + ".1", path} {
+		e, err := read(f)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		entries = append(entries, e...)
+	}
+
+	matched := entries[:0]
+	for _, e := range entries {
+		if e.Time.Before(since) {
+			continue
+		}
+		if id != "" && id != e.TaskID && id != e.PolicyAssignment && id != e.PolicyID && id != e.ResourceID {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].Time.Before(matched[j].Time) })
+	return matched, nil
+}
+
+// Write writes entries to w as a table for format "text", or a JSON array
+// for format "json".
+func Write(w io.Writer, entries []Entry, format string) error {
+	switch format {
+	case "text":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tACTION\tTARGET\tSOURCE\tRESULT")
+		for _, e := range entries {
+			result := "ok"
+			if e.Error != "" {
+				result = "error: " + e.Error
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Action, e.Target, e.source(), result)
+		}
+		return tw.Flush()
+	case "json":
+		if entries == nil {
+			entries = []Entry{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// source describes what the change was made for, a task or an OS policy
+// resource.
+func (e Entry) source() string {
+	switch {
+	case e.ResourceID != "":
+		return fmt.Sprintf("%s/%s/%s", e.PolicyAssignment, e.PolicyID, e.ResourceID)
+	case e.TaskID != "":
+		return fmt.Sprintf("%s %s", e.TaskType, e.TaskID)
+	default:
+		return "-"
+	}
+}
+
+// read returns the entries in a journal file, skipping lines that do not
+// parse, like one cut short by a crash.
+func read(file string) ([]Entry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, maxSize)
+	for s.Scan() {
+		var e Entry
+		if ln := strings.TrimSpace(s.Text()); ln == "" || json.Unmarshal([]byte(ln), &e) != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
@@ -0,0 +1,148 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package journal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+func TestRecordAndQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	defer SetFile("")
+
+	ctx := context.Background()
+	// Nothing is recorded until the file is set.
+	Record(ctx, PackageInstall, "ignored", nil)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("journal written before SetFile: %v", err)
+	}
+
+	SetFile(path)
+	start := time.Now().Add(-time.Second)
+	taskCtx := clog.WithLabels(ctx, map[string]string{"task_id": "task1", "task_type": "EXEC_STEP_TASK"})
+	policyCtx := clog.WithLabels(ctx, map[string]string{"os_policy_assignment": "assignment", "os_policy_id": "policy", "resource_id": "resource"})
+	Record(taskCtx, ScriptRun, "/tmp/script.sh", nil)
+	Record(policyCtx, PackageInstall, "vim", errors.New("exit status 100"))
+
+	// Partial lines, like one cut short by a crash, are skipped.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2024-`)
+	f.Close()
+
+	tests := []struct {
+		desc  string
+		since time.Time
+		id    string
+		want  []string
+	}{
+		{"all", time.Time{}, "", []string{"/tmp/script.sh", "vim"}},
+		{"since", start, "", []string{"/tmp/script.sh", "vim"}},
+		{"future", time.Now().Add(time.Hour), "", nil},
+		{"task", time.Time{}, "task1", []string{"/tmp/script.sh"}},
+		{"assignment", time.Time{}, "assignment", []string{"vim"}},
+		{"resource", time.Time{}, "resource", []string{"vim"}},
+		{"unknown id", time.Time{}, "other", nil},
+	}
+	for _, tt := range tests {
+		entries, err := Query(path, tt.since, tt.id)
+		if err != nil {
+			t.Fatalf("%s: Query: %v", tt.desc, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Target)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: Query targets = %q, want %q", tt.desc, got, tt.want)
+		}
+	}
+
+	entries, err := Query(path, time.Time{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := entries[0]; e.TaskID != "task1" || e.TaskType != "EXEC_STEP_TASK" || e.Action != ScriptRun || e.Error != "" {
+		t.Errorf("task entry = %+v", e)
+	}
+	if e := entries[1]; e.PolicyAssignment != "assignment" || e.PolicyID != "policy" || e.ResourceID != "resource" || e.Error != "exit status 100" {
+		t.Errorf("policy entry = %+v", e)
+	}
+}
+
+func TestQueryRotated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	old := `{"time":"2024-01-01T00:00:00Z","action":"file-write","target":"/etc/old"}` + "\n"
+	cur := `{"time":"2024-01-02T00:00:00Z","action":"file-write","target":"/etc/new"}` + "\n"
+	if err := os.WriteFile(path+".1", []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(cur), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := Query(path, time.Time{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Target != "/etc/old" || entries[1].Target != "/etc/new" {
+		t.Errorf("Query = %+v, want the rotated entry first", entries)
+	}
+
+	// A missing journal is empty, not an error.
+	if entries, err := Query(filepath.Join(t.TempDir(), "missing"), time.Time{}, ""); err != nil || len(entries) != 0 {
+		t.Errorf("Query of a missing journal = %v, %v, want no entries", entries, err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	entries := []Entry{
+		{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Action: PackageInstall, Target: "vim", TaskID: "task1", TaskType: "APPLY_PATCHES"},
+		{Time: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Action: FileWrite, Target: "/etc/motd", PolicyID: "policy", ResourceID: "motd", Error: "permission denied"},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, entries, "text"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"TIME", "2024-01-01T00:00:00Z", "APPLY_PATCHES task1", "/policy/motd", "error: permission denied"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Write text output missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := Write(&buf, nil, "json"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(buf.String()); got != "[]" {
+		t.Errorf("Write json of no entries = %q, want []", got)
+	}
+
+	if err := Write(&buf, entries, "yaml"); err == nil {
+		t.Error("Write: expected an error for an unknown format")
+	}
+}
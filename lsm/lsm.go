@@ -0,0 +1,307 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package lsm reports how the agent is confined by a Linux Security Module,
+// SELinux or AppArmor, and turns the denials affecting it into actionable log
+// messages.
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// Names of the policies shipped with the agent packages, see packaging/selinux
+// and packaging/apparmor.
+const (
+	SELinuxDomain   = "google_osconfig_agent_t"
+	SELinuxBoolean  = "google_osconfig_agent_unconfined"
+	AppArmorProfile = "google_osconfig_agent"
+
+	appArmorProfileFile = "/etc/apparmor.d/usr.bin.google_osconfig_agent"
+	appArmorLocalFile   = "/etc/apparmor.d/local/usr.bin.google_osconfig_agent"
+)
+
+// Modules.
+const (
+	SELinux  = "selinux"
+	AppArmor = "apparmor"
+)
+
+var (
+	selinuxEnforceFile  = "/sys/fs/selinux/enforce"
+	apparmorEnabledFile = "/sys/module/apparmor/parameters/enabled"
+	// The AppArmor specific attr is used when several modules are stacked.
+	apparmorCurrentFile = "/proc/self/attr/apparmor/current"
+	currentFile         = "/proc/self/attr/current"
+
+	// auditLogs are where denials are logged, the kernel log is used by
+	// AppArmor when auditd is not running.
+	auditLogs = []string{"/var/log/audit/audit.log", "/var/log/kern.log"}
+)
+
+// Confinement describes the security module the agent runs under.
+type Confinement struct {
+	// Module is SELinux or AppArmor, empty if neither is enabled.
+	Module string
+	// Enforcing is set when denials are enforced rather than only logged.
+	Enforcing bool
+	// Label is the SELinux context or AppArmor profile of the agent.
+	Label string
+	// Confined is set when the agent runs under its own policy.
+	Confined bool
+}
+
+func readAttr(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+}
+
+// Current returns the confinement of the running agent.
+func Current() Confinement {
+	if enforce := readAttr(selinuxEnforceFile); enforce != "" {
+		label := readAttr(currentFile)
+		return Confinement{
+			Module:    SELinux,
+			Enforcing: enforce == "1",
+			Label:     label,
+			Confined:  strings.Contains(label, ":"+SELinuxDomain+":"),
+		}
+	}
+	if readAttr(apparmorEnabledFile) == "Y" {
+		label := readAttr(apparmorCurrentFile)
+		if label == "" {
+			label = readAttr(currentFile)
+		}
+		// The label is "unconfined" or "profile (mode)".
+		name, mode, _ := strings.Cut(label, " ")
+		return Confinement{
+			Module:    AppArmor,
+			Enforcing: mode == "(enforce)",
+			Label:     label,
+			Confined:  name == AppArmorProfile,
+		}
+	}
+	return Confinement{}
+}
+
+// LogStatus logs the confinement of the agent, with how to fix it when the
+// agent is not running under the policy shipped with it.
+func LogStatus(ctx context.Context) {
+	c := Current()
+	switch {
+	case c.Module == "":
+		clog.Debugf(ctx, "No Linux Security Module is enabled, the agent is not confined.")
+	case c.Confined:
+		clog.Infof(ctx, "The agent is confined by %s as %q.", c.Module, c.Label)
+	case c.Module == SELinux && c.Enforcing:
+		clog.Warningf(ctx, "SELinux is enforcing but the agent runs as %q instead of %s, the google_osconfig_agent policy module is not loaded or the agent binary is mislabeled. Run `semodule -l | grep google_osconfig_agent` and `restorecon -v /usr/bin/google_osconfig_agent`, then restart the agent.", c.Label, SELinuxDomain)
+	case c.Module == AppArmor && c.Enforcing:
+		clog.Warningf(ctx, "The agent runs under the AppArmor profile %q instead of %s.", c.Label, AppArmorProfile)
+	default:
+		clog.Debugf(ctx, "%s is enabled, the agent runs as %q.", c.Module, c.Label)
+	}
+}
+
+// Denial is an access denied to the agent, or a process it started, by a
+// security module.
+type Denial struct {
+	Time   time.Time
+	Module string
+	// Operation is the denied SELinux permissions, like "write", or the
+	// AppArmor operation, like "open".
+	Operation string
+	// Class is the SELinux object class, like "file".
+	Class string
+	// Target is the SELinux context of the object or the path AppArmor
+	// denied access to.
+	Target string
+	Comm   string
+	// Permissive is set when the access was only logged.
+	Permissive bool
+}
+
+var (
+	auditTimeRe = regexp.MustCompile(`audit\((\d+)\.\d+:\d+\)`)
+	avcRe       = regexp.MustCompile(`avc:\s+denied\s+\{\s*([^}]*?)\s*\}`)
+	fieldRe     = regexp.MustCompile(`(\w+)=("[^"]*"|\S+)`)
+)
+
+// parseDenial parses an SELinux AVC or AppArmor denial from an audit or kernel
+// log line, it returns nil for any other line and for denials of other
+// domains or profiles.
+func parseDenial(line string) *Denial {
+	var d Denial
+	avc := avcRe.FindStringSubmatch(line)
+	switch {
+	case avc != nil:
+		d.Module = SELinux
+		d.Operation = avc[1]
+	case strings.Contains(line, `apparmor="DENIED"`):
+		d.Module = AppArmor
+	case strings.Contains(line, `apparmor="ALLOWED"`):
+		// Logged in complain mode, the profile would deny it.
+		d.Module = AppArmor
+		d.Permissive = true
+	default:
+		return nil
+	}
+
+	fields := map[string]string{}
+	for _, m := range fieldRe.FindAllStringSubmatch(line, -1) {
+		fields[m[1]] = strings.Trim(m[2], `"`)
+	}
+	d.Comm = fields["comm"]
+	if d.Module == SELinux {
+		if !strings.Contains(fields["scontext"], ":"+SELinuxDomain+":") {
+			return nil
+		}
+		d.Class = fields["tclass"]
+		d.Target = fields["tcontext"]
+		d.Permissive = fields["permissive"] == "1"
+	} else {
+		// Child profiles of the agent profile are named profile//child.
+		if p := fields["profile"]; p != AppArmorProfile && !strings.HasPrefix(p, AppArmorProfile+"//") {
+			return nil
+		}
+		d.Operation = fields["operation"]
+		d.Target = fields["name"]
+	}
+
+	if m := auditTimeRe.FindStringSubmatch(line); m != nil {
+		if sec, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+			d.Time = time.Unix(sec, 0).UTC()
+		}
+	}
+	return &d
+}
+
+func (d *Denial) key() string {
+	return strings.Join([]string{d.Module, d.Operation, d.Class, d.Target, d.Comm}, "|")
+}
+
+// Advice returns what to do about the denial.
+func (d *Denial) Advice() string {
+	if d.Module == SELinux {
+		return fmt.Sprintf("SELinux denied %q { %s } on %s %q to the agent. If an OS policy or exec task needs this access run `setsebool -P %s on`, or build a local module with `ausearch -m AVC -c %s | audit2allow -M google_osconfig_agent_local`.", d.Comm, d.Operation, d.Class, d.Target, SELinuxBoolean, d.Comm)
+	}
+	return fmt.Sprintf("AppArmor denied %q %s of %q to the agent. If an OS policy or exec task needs this access add a rule to %s and run `apparmor_parser -r %s`, or run `aa-complain %s` to only log denials.", d.Comm, d.Operation, d.Target, appArmorLocalFile, appArmorProfileFile, appArmorProfileFile)
+}
+
+// Monitor reports the denials affecting the agent logged since it was
+// created, each distinct denial is reported once.
+type Monitor struct {
+	mx       sync.Mutex
+	since    time.Time
+	offsets  map[string]int64
+	reported map[string]bool
+}
+
+// NewMonitor returns a Monitor reporting denials logged from now on.
+func NewMonitor() *Monitor {
+	return &Monitor{
+		// Audit timestamps have a resolution of one second.
+		since:    time.Now().Truncate(time.Second),
+		offsets:  map[string]int64{},
+		reported: map[string]bool{},
+	}
+}
+
+// Check reads the denials logged since the last check and logs each new one
+// with the action to take, it returns them.
+func (m *Monitor) Check(ctx context.Context) []*Denial {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	var denials []*Denial
+	for _, path := range auditLogs {
+		found, err := m.read(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				clog.Debugf(ctx, "Error reading %s for security module denials: %v", path, err)
+			}
+			continue
+		}
+		for _, d := range found {
+			if m.reported[d.key()] {
+				continue
+			}
+			m.reported[d.key()] = true
+			denials = append(denials, d)
+			if d.Permissive {
+				clog.Infof(ctx, "%s (not enforced)", d.Advice())
+			} else {
+				clog.Warningf(ctx, "%s", d.Advice())
+			}
+		}
+	}
+	return denials
+}
+
+// read returns the denials in path after the offset of the last read, the
+// whole file is read again when it was rotated.
+func (m *Monitor) read(path string) ([]*Denial, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := m.offsets[path]
+	if fi.Size() < offset {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var denials []*Denial
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		// Partial lines are read again once complete.
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(len(line))
+		if !bytes.Contains(line, []byte("denied")) && !bytes.Contains(line, []byte("DENIED")) {
+			continue
+		}
+		if d := parseDenial(string(line)); d != nil && !d.Time.Before(m.since) {
+			denials = append(denials, d)
+		}
+	}
+	m.offsets[path] = offset
+	return denials, nil
+}
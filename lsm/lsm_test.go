@@ -0,0 +1,144 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package lsm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	defer func(s, a, ac, c string) {
+		selinuxEnforceFile, apparmorEnabledFile, apparmorCurrentFile, currentFile = s, a, ac, c
+	}(selinuxEnforceFile, apparmorEnabledFile, apparmorCurrentFile, currentFile)
+
+	tests := []struct {
+		desc                      string
+		enforce, enabled, aaLabel string
+		label                     string
+		want                      Confinement
+	}{
+		{"none", "", "", "", "", Confinement{}},
+		{"selinux confined", "1", "", "", "system_u:system_r:google_osconfig_agent_t:s0\x00", Confinement{SELinux, true, "system_u:system_r:google_osconfig_agent_t:s0", true}},
+		{"selinux unconfined", "1", "", "", "system_u:system_r:unconfined_service_t:s0", Confinement{SELinux, true, "system_u:system_r:unconfined_service_t:s0", false}},
+		{"selinux permissive", "0", "", "", "system_u:system_r:google_osconfig_agent_t:s0", Confinement{SELinux, false, "system_u:system_r:google_osconfig_agent_t:s0", true}},
+		{"apparmor confined", "", "Y", "", "google_osconfig_agent (enforce)\n", Confinement{AppArmor, true, "google_osconfig_agent (enforce)", true}},
+		{"apparmor stacked", "", "Y", "google_osconfig_agent (complain)", "", Confinement{AppArmor, false, "google_osconfig_agent (complain)", true}},
+		{"apparmor unconfined", "", "Y", "", "unconfined", Confinement{AppArmor, false, "unconfined", false}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		selinuxEnforceFile = filepath.Join(dir, "enforce")
+		apparmorEnabledFile = filepath.Join(dir, "enabled")
+		apparmorCurrentFile = filepath.Join(dir, "apparmor_current")
+		currentFile = filepath.Join(dir, "current")
+		for path, content := range map[string]string{selinuxEnforceFile: tt.enforce, apparmorEnabledFile: tt.enabled, apparmorCurrentFile: tt.aaLabel, currentFile: tt.label} {
+			if content != "" {
+				writeFile(t, path, content)
+			}
+		}
+		if diff := cmp.Diff(tt.want, Current()); diff != "" {
+			t.Errorf("%s: Current() mismatch (-want +got):\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestParseDenial(t *testing.T) {
+	tests := []struct {
+		desc string
+		line string
+		want *Denial
+	}{
+		{
+			"selinux",
+			`type=AVC msg=audit(1700000000.123:456): avc:  denied  { write } for  pid=1234 comm="dnf" name="repo" dev="sda1" ino=42 scontext=system_u:system_r:google_osconfig_agent_t:s0 tcontext=system_u:object_r:etc_t:s0 tclass=dir permissive=0`,
+			&Denial{Time: time.Unix(1700000000, 0).UTC(), Module: SELinux, Operation: "write", Class: "dir", Target: "system_u:object_r:etc_t:s0", Comm: "dnf"},
+		},
+		{
+			"selinux permissive",
+			`type=AVC msg=audit(1700000000.123:456): avc:  denied  { name_connect } for  pid=1234 comm="google_osconfig" dest=8080 scontext=system_u:system_r:google_osconfig_agent_t:s0 tcontext=system_u:object_r:http_cache_port_t:s0 tclass=tcp_socket permissive=1`,
+			&Denial{Time: time.Unix(1700000000, 0).UTC(), Module: SELinux, Operation: "name_connect", Class: "tcp_socket", Target: "system_u:object_r:http_cache_port_t:s0", Comm: "google_osconfig", Permissive: true},
+		},
+		{
+			"selinux other domain",
+			`type=AVC msg=audit(1700000000.123:456): avc:  denied  { write } for  pid=1 comm="httpd" scontext=system_u:system_r:httpd_t:s0 tcontext=system_u:object_r:etc_t:s0 tclass=dir permissive=0`,
+			nil,
+		},
+		{
+			"apparmor",
+			`Nov 14 22:13:20 host kernel: [ 12.3] audit: type=1400 audit(1700000000.123:45): apparmor="DENIED" operation="open" profile="google_osconfig_agent" name="/opt/app/config" pid=1234 comm="google_osconfig" requested_mask="wc" denied_mask="wc" fsuid=0 ouid=0`,
+			&Denial{Time: time.Unix(1700000000, 0).UTC(), Module: AppArmor, Operation: "open", Target: "/opt/app/config", Comm: "google_osconfig"},
+		},
+		{
+			"apparmor complain",
+			`type=AVC msg=audit(1700000000.123:45): apparmor="ALLOWED" operation="mknod" profile="google_osconfig_agent" name="/etc/apt/sources.list.d/osconfig_managed.list" pid=1234 comm="google_osconfig" requested_mask="c" denied_mask="c"`,
+			&Denial{Time: time.Unix(1700000000, 0).UTC(), Module: AppArmor, Operation: "mknod", Target: "/etc/apt/sources.list.d/osconfig_managed.list", Comm: "google_osconfig", Permissive: true},
+		},
+		{
+			"apparmor other profile",
+			`type=AVC msg=audit(1700000000.123:45): apparmor="DENIED" operation="open" profile="/usr/sbin/cupsd" name="/etc/shadow" pid=1 comm="cupsd"`,
+			nil,
+		},
+		{"other", `type=SYSCALL msg=audit(1700000000.123:456): arch=c000003e syscall=2 success=yes`, nil},
+	}
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, parseDenial(tt.line)); diff != "" {
+			t.Errorf("%s: parseDenial() mismatch (-want +got):\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestMonitorCheck(t *testing.T) {
+	defer func(l []string) { auditLogs = l }(auditLogs)
+	dir := t.TempDir()
+	log := filepath.Join(dir, "audit.log")
+	auditLogs = []string{log, filepath.Join(dir, "missing.log")}
+
+	denial := func(sec int64, perm string) string {
+		return fmt.Sprintf("type=AVC msg=audit(%d.000:1): avc:  denied  { %s } for  pid=1 comm=\"sh\" scontext=system_u:system_r:google_osconfig_agent_t:s0 tcontext=system_u:object_r:etc_t:s0 tclass=file permissive=0\n", sec, perm)
+	}
+	m := NewMonitor()
+	now := m.since.Unix()
+	writeFile(t, log, denial(now-60, "read")+denial(now, "write")+denial(now, "write"))
+
+	ctx := context.Background()
+	got := m.Check(ctx)
+	if len(got) != 1 || got[0].Operation != "write" {
+		t.Fatalf("Check: got %v, want only the write denial logged since the monitor was created", got)
+	}
+	if got := m.Check(ctx); len(got) != 0 {
+		t.Errorf("Check: got %v, want no new denials", got)
+	}
+
+	// After rotation the file is read from the start, repeated denials are
+	// not reported again.
+	writeFile(t, log, denial(now+1, "write")+denial(now+1, "unlink"))
+	if got := m.Check(ctx); len(got) != 1 || got[0].Operation != "unlink" {
+		t.Errorf("Check after rotation: got %v, want the unlink denial", got)
+	}
+}
@@ -17,6 +17,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -33,8 +34,14 @@ import (
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/agentendpoint"
+	"github.com/GoogleCloudPlatform/osconfig/agentstatus"
+	"github.com/GoogleCloudPlatform/osconfig/audit"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/inventory"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
+	"github.com/GoogleCloudPlatform/osconfig/lsm"
 	"github.com/GoogleCloudPlatform/osconfig/policies"
+	"github.com/GoogleCloudPlatform/osconfig/preflight"
 	"github.com/GoogleCloudPlatform/osconfig/tasker"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 	"github.com/tarm/serial"
@@ -47,14 +54,54 @@ import (
 var (
 	version string
 	profile = flag.Bool("profile", false, "serve profiling data at localhost:6060/debug/pprof")
+
+	// inventory [-local [-output path] [-format json|text|manifest] [-quiet] [-debug]]
+	// inventory -diff [-format text|json] [-quiet] [-debug] before after
+	inventoryFlags  = flag.NewFlagSet("inventory", flag.ExitOnError)
+	localInventory  = inventoryFlags.Bool("local", false, "gather inventory and print it instead of reporting it, metadata settings are not read")
+	inventoryOutput = inventoryFlags.String("output", "", "with -local, write the inventory to this file instead of stdout")
+	inventoryDiff   = inventoryFlags.Bool("diff", false, "print the package changes between two inventories saved as JSON with -local, local files or gs://bucket/object URLs")
+	inventoryOpts   cliOptions
+
+	// policies -once -from-file path [-image-build] [-format text|json] [-quiet] [-debug]
+	policiesFlags      = flag.NewFlagSet("policies", flag.ExitOnError)
+	policiesOnce       = policiesFlags.Bool("once", false, "apply the policies in -from-file once and print the result instead of running the agent")
+	policiesFile       = policiesFlags.String("from-file", "", "with -once, a YAML or JSON OS policy file to apply")
+	policiesImageBuild = policiesFlags.Bool("image-build", false, "with -once, record the compliant resources so the first policy run on instances created from this image skips them")
+	policiesOpts       cliOptions
+
+	// status [-since duration] [-id id] [-assignments] [-format text|json] [-quiet] [-debug]
+	statusFlags       = flag.NewFlagSet("status", flag.ExitOnError)
+	statusSince       = statusFlags.Duration("since", 24*time.Hour, "only show changes made within this duration, 0 for all changes")
+	statusID          = statusFlags.String("id", "", "only show changes made for this task, OS policy assignment, OS policy or resource ID")
+	statusAssignments = statusFlags.Bool("assignments", false, "show the OS policy assignment revisions the last config task applied instead of changes")
+	statusOpts        cliOptions
+
+	// sbom [-output path|gs://bucket/object] [-format spdx|cyclonedx] [-quiet] [-debug]
+	sbomFlags  = flag.NewFlagSet("sbom", flag.ExitOnError)
+	sbomOutput = sbomFlags.String("output", "", "write the SBOM to this file or gs://bucket/object URL instead of stdout")
+	sbomOpts   cliOptions
+
+	// check [-timeout duration] [-format text|json] [-quiet] [-debug]
+	checkFlags   = flag.NewFlagSet("check", flag.ExitOnError)
+	checkTimeout = checkFlags.Duration("timeout", 30*time.Second, "timeout of each network check")
+	checkOpts    cliOptions
 )
 
 func init() {
+	inventoryOpts.register(inventoryFlags, "json", inventory.FormatManifest)
+	policiesOpts.register(policiesFlags, "text")
+	statusOpts.register(statusFlags, "text")
+	checkOpts.register(checkFlags, "text")
+	sbomOpts.registerFormats(sbomFlags, inventory.SPDX, inventory.SPDX, inventory.CycloneDX)
+
 	if version == "" {
 		version = "manual-" + time.Now().Format(time.RFC3339)
 	}
 	// We do this here so the -X value doesn't need the full path.
 	agentconfig.SetVersion(version)
+	util.CommandStallTimeout = agentconfig.CommandStallTimeout
+	util.HungCommandDir = agentconfig.HungCommandDir
 
 	os.MkdirAll(filepath.Dir(agentconfig.RestartFile()), 0755)
 }
@@ -63,6 +110,15 @@ type serialPort struct {
 	port string
 }
 
+// serialWriters returns the writers for agentconfig.SerialLogPorts.
+func serialWriters() []io.Writer {
+	var writers []io.Writer
+	for _, port := range agentconfig.SerialLogPorts() {
+		writers = append(writers, &serialPort{port})
+	}
+	return writers
+}
+
 func (s *serialPort) Write(b []byte) (int, error) {
 	c := &serial.Config{Name: s.port, Baud: 115200}
 	p, err := serial.OpenPort(c)
@@ -74,7 +130,39 @@ func (s *serialPort) Write(b []byte) (int, error) {
 	return p.Write(b)
 }
 
-var deferredFuncs []func()
+var (
+	deferredFuncs []func()
+
+	// configChanged is signaled whenever a new agent configuration is applied.
+	configChanged = make(chan struct{}, 1)
+)
+
+// applyConfigChange applies settings that should take effect without a
+// restart and wakes up the service loop.
+func applyConfigChange() {
+	logger.SetDebugLogging(agentconfig.Debug())
+	clog.DebugEnabled = agentconfig.Debug()
+	select {
+	case configChanged <- struct{}{}:
+	default:
+	}
+}
+
+// watchReload refreshes the agent configuration each time a local reload is
+// requested.
+func watchReload(ctx context.Context, reload <-chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+			clog.Infof(ctx, "Reload requested, refreshing agent configuration.")
+			if err := agentconfig.Refresh(ctx); err != nil {
+				clog.Errorf(ctx, "Error refreshing agent configuration: %v", err)
+			}
+		}
+	}
+}
 
 // RegisterAgent is a blocking call, the RPC itself has retry logic baked in
 // with jitter and backoff up to a total of 10 minutes.
@@ -82,15 +170,16 @@ var deferredFuncs []func()
 // 5 minutes and try again.
 func registerAgent(ctx context.Context) {
 	for {
-		if client, err := agentendpoint.NewClient(ctx); err != nil {
-			logger.Errorf(err.Error())
-		} else if err := client.RegisterAgent(ctx); err != nil {
-			logger.Errorf(err.Error())
-			client.Close()
+		if client, release, err := agentendpoint.SharedClient(ctx); err != nil {
+			clog.Errorf(ctx, "%v", err)
 		} else {
-			// RegisterAgent completed successfully.
-			client.Close()
-			return
+			err := client.RegisterAgent(ctx)
+			release()
+			if err == nil {
+				// RegisterAgent completed successfully.
+				return
+			}
+			clog.Errorf(ctx, "%v", err)
 		}
 		time.Sleep(5 * time.Minute)
 	}
@@ -102,23 +191,42 @@ func run(ctx context.Context) {
 	if agentconfig.Stdout() {
 		opts.Writers = []io.Writer{os.Stdout}
 	}
-	if runtime.GOOS == "windows" {
-		opts.Writers = append(opts.Writers, &serialPort{"COM1"})
+	if path := agentconfig.LogFile(); path != "" {
+		f := util.NewRotatingFile(path, agentconfig.LogFileMaxSize(), agentconfig.LogFileMaxBackups())
+		opts.Writers = append(opts.Writers, f)
+		deferredFuncs = append(deferredFuncs, func() { f.Close() })
 	}
 
 	// If this call to WatchConfig fails (like a metadata error) we can't continue.
 	if err := agentconfig.WatchConfig(ctx); err != nil {
+		opts.Writers = append(opts.Writers, serialWriters()...)
 		logger.Init(ctx, opts)
-		logger.Fatalf("Error parsing metadata, agent cannot start: %v", err.Error())
+		clog.Fatalf(ctx, "Error parsing metadata, agent cannot start: %v", err.Error())
 	}
+	// The serial ports may be set in metadata, they are read once at start.
+	opts.Writers = append(opts.Writers, serialWriters()...)
 	opts.Debug = agentconfig.Debug()
 	clog.DebugEnabled = agentconfig.Debug()
 	opts.ProjectName = agentconfig.ProjectID()
 
+	// A native backend replaces the plain text event log or syslog.
+	var backendErr error
+	if name := agentconfig.LogBackend(); name != "" {
+		var b clog.Backend
+		if b, backendErr = clog.NewBackend(name, opts.LoggerName); backendErr == nil {
+			opts.DisableLocalLogging = true
+			clog.SetBackend(b)
+			deferredFuncs = append(deferredFuncs, func() { clog.SetBackend(nil); b.Close() })
+		}
+	}
+
 	if err := logger.Init(ctx, opts); err != nil {
 		fmt.Printf("Error initializing logger: %v", err)
 		os.Exit(1)
 	}
+	if backendErr != nil {
+		clog.Errorf(ctx, "Error setting up log backend %q, using the default logging: %v", agentconfig.LogBackend(), backendErr)
+	}
 	ctx = clog.WithLabels(ctx, map[string]string{"instance_name": agentconfig.Name()})
 
 	// Remove any existing restart file.
@@ -141,14 +249,42 @@ func run(ctx context.Context) {
 		}
 	})
 
-	deferredFuncs = append(deferredFuncs, logger.Close, func() { clog.Infof(ctx, "OSConfig Agent (version %s) shutting down.", agentconfig.Version()) })
+	deferredFuncs = append(deferredFuncs, func() { agentendpoint.CloseSharedClient() }, logger.Close, func() { clog.Infof(ctx, "OSConfig Agent (version %s) shutting down.", agentconfig.Version()) })
+
+	obtainLock(ctx)
 
-	obtainLock()
+	// Only the agent records to the change journal and the audit log,
+	// one-shot commands such as status only read them.
+	journal.SetFile(agentconfig.JournalFile())
+	auditLog := util.NewRotatingFile(agentconfig.AuditLogFile(), agentconfig.AuditLogMaxSize(), agentconfig.AuditLogMaxBackups())
+	audit.SetWriter(auditLog)
+	audit.SetForwarding(agentconfig.AuditLogForwarding)
+	deferredFuncs = append(deferredFuncs, func() { audit.SetWriter(nil); auditLog.Close() })
 
 	// obtainLock adds functions to clear the lock at close.
 	logger.DeferredFatalFuncs = append(logger.DeferredFatalFuncs, deferredFuncs...)
 
 	clog.Infof(ctx, "OSConfig Agent (version %s) started.", agentconfig.Version())
+	if runtime.GOOS == "linux" {
+		lsm.LogStatus(ctx)
+	}
+	if agentconfig.ReadOnly() {
+		clog.Infof(ctx, "Running in read-only mode, OS policies are only validated and no patches or exec steps are run.")
+	}
+
+	agentconfig.OnChange(applyConfigChange)
+	go watchReload(ctx, reloadRequests(ctx))
+
+	// Serve the agent status for local health checks.
+	if addr := agentconfig.StatusAddress(); addr != "" {
+		clog.OnError(agentstatus.RecordError)
+		go func() {
+			clog.Infof(ctx, "Serving agent status on %q.", addr)
+			if err := agentstatus.Serve(ctx, addr); err != nil {
+				clog.Errorf(ctx, "Error serving agent status on %q: %v", addr, err)
+			}
+		}()
+	}
 
 	// Call RegisterAgent at least once every day, on start calling
 	// of RegisterAgent is handled in the service loop.
@@ -167,7 +303,7 @@ func run(ctx context.Context) {
 	case "inventory", "osinventory":
 		client, err := agentendpoint.NewClient(ctx)
 		if err != nil {
-			logger.Fatalf(err.Error())
+			clog.Fatalf(ctx, "%v", err)
 		}
 		tasker.Enqueue(ctx, "Report OSInventory", func() {
 			client.ReportInventory(ctx)
@@ -181,14 +317,14 @@ func run(ctx context.Context) {
 	case "w", "waitfortasknotification", "ospatch":
 		client, err := agentendpoint.NewClient(ctx)
 		if err != nil {
-			logger.Fatalf(err.Error())
+			clog.Fatalf(ctx, "%v", err)
 		}
 		client.WaitForTaskNotification(ctx)
 		select {
 		case <-ctx.Done():
 		}
 	default:
-		logger.Fatalf("Unknown arg %q", action)
+		clog.Fatalf(ctx, "Unknown arg %q", action)
 	}
 }
 
@@ -196,29 +332,30 @@ func runTaskLoop(ctx context.Context, c chan struct{}) {
 	var taskNotificationClient *agentendpoint.Client
 	var err error
 	for {
-		// Set debug logging settings so that customers don't need to restart the agent.
-		logger.SetDebugLogging(agentconfig.Debug())
-		clog.DebugEnabled = agentconfig.Debug()
-		if agentconfig.TaskNotificationEnabled() && taskNotificationClient == nil {
-			// Call RegisterAgent now since we just either started running or were just enabled.
-			// This call is blocking until successful as we can't continue unless register agent has completed.
-			registerAgent(ctx)
-		}
-		if agentconfig.TaskNotificationEnabled() && (taskNotificationClient == nil || taskNotificationClient.Closed()) {
-			// Start WaitForTaskNotification if we need to.
-			taskNotificationClient, err = agentendpoint.NewClient(ctx)
-			if err != nil {
-				clog.Errorf(ctx, err.Error())
-			} else {
-				taskNotificationClient.WaitForTaskNotification(ctx)
+		// A panic here would stop task notifications for good, so recover
+		// and let the next config change retry.
+		tasker.RunSafely(ctx, "Task notification setup", func() {
+			if agentconfig.TaskNotificationEnabled() && taskNotificationClient == nil {
+				// Call RegisterAgent now since we just either started running or were just enabled.
+				// This call is blocking until successful as we can't continue unless register agent has completed.
+				registerAgent(ctx)
 			}
-		} else if !agentconfig.TaskNotificationEnabled() && taskNotificationClient != nil && !taskNotificationClient.Closed() {
-			// Cancel WaitForTaskNotification if we need to, this will block if there is
-			// an existing current task running.
-			if err := taskNotificationClient.Close(); err != nil {
-				clog.Errorf(ctx, err.Error())
+			if agentconfig.TaskNotificationEnabled() && (taskNotificationClient == nil || taskNotificationClient.Closed()) {
+				// Start WaitForTaskNotification if we need to.
+				taskNotificationClient, err = agentendpoint.NewClient(ctx)
+				if err != nil {
+					clog.Errorf(ctx, err.Error())
+				} else {
+					taskNotificationClient.WaitForTaskNotification(ctx)
+				}
+			} else if !agentconfig.TaskNotificationEnabled() && taskNotificationClient != nil && !taskNotificationClient.Closed() {
+				// Cancel WaitForTaskNotification if we need to, this will block if there is
+				// an existing current task running.
+				if err := taskNotificationClient.Close(); err != nil {
+					clog.Errorf(ctx, err.Error())
+				}
 			}
-		}
+		})
 
 		// This is just to signal WaitForTaskNotification has run if needed.
 		select {
@@ -243,6 +380,7 @@ func runTaskLoop(ctx context.Context, c chan struct{}) {
 func runInternalPeriodics(ctx context.Context) {
 	ticker := time.NewTicker(10 * time.Minute)
 	defer ticker.Stop()
+	denials := lsm.NewMonitor()
 	for {
 		if _, err := os.Stat(agentconfig.RestartFile()); err == nil {
 			clog.Infof(ctx, "Restart required marker file exists, beginning agent shutdown, waiting for tasks to complete.")
@@ -254,6 +392,11 @@ func runInternalPeriodics(ctx context.Context) {
 			os.Exit(2)
 		}
 
+		agentendpoint.LogAPIStats(ctx)
+		if runtime.GOOS == "linux" {
+			denials.Check(ctx)
+		}
+
 		select {
 		case <-ticker.C:
 			continue
@@ -273,15 +416,48 @@ func runServiceLoop(ctx context.Context) {
 	// Don't continue any other tasks until WaitForTaskNotification has run.
 	<-c
 
+	// Report inventory as soon as packages change, not only on the interval.
+	go agentendpoint.WatchPackageChanges(ctx, func() {
+		if agentconfig.OSInventoryEnabled() {
+			agentendpoint.EnqueueInventoryReport(ctx)
+		}
+	})
+
 	// Runs functions that need to run on a set interval.
-	ticker := time.NewTicker(agentconfig.SvcPollInterval())
+	interval := agentconfig.SvcPollInterval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	// First inventory run will be somewhere between 3 and 5 min.
 	firstInventory := time.After(time.Duration(rand.Intn(120)+180) * time.Second)
 	ranFirstInventory := false
+	guestPoliciesEnabled, osInventoryEnabled := agentconfig.GuestPoliciesEnabled(), agentconfig.OSInventoryEnabled()
+	// waitForNextCycle blocks until the next poll interval or until a config
+	// change enables a feature, it returns false once ctx is done.
+	waitForNextCycle := func() bool {
+		for {
+			select {
+			case <-ticker.C:
+				return true
+			case <-configChanged:
+				if i := agentconfig.SvcPollInterval(); i > 0 && i != interval {
+					clog.Infof(ctx, "Poll interval changed from %s to %s.", interval, i)
+					interval = i
+					ticker.Reset(interval)
+				}
+				// Run a cycle right away for any feature that was just enabled.
+				newlyEnabled := (!guestPoliciesEnabled && agentconfig.GuestPoliciesEnabled()) || (!osInventoryEnabled && agentconfig.OSInventoryEnabled())
+				guestPoliciesEnabled, osInventoryEnabled = agentconfig.GuestPoliciesEnabled(), agentconfig.OSInventoryEnabled()
+				if newlyEnabled {
+					return true
+				}
+			case <-ctx.Done():
+				return false
+			}
+		}
+	}
 	for {
 		if agentconfig.GuestPoliciesEnabled() {
-			policies.Run(ctx)
+			tasker.RunSafely(ctx, "Guest policies", func() { policies.Run(ctx) })
 		}
 
 		if agentconfig.OSInventoryEnabled() {
@@ -300,19 +476,17 @@ func runServiceLoop(ctx context.Context) {
 
 			// This should always run after ospackage.SetConfig.
 			tasker.Enqueue(ctx, "Report OSInventory", func() {
-				client, err := agentendpoint.NewClient(ctx)
+				client, release, err := agentendpoint.SharedClient(ctx)
 				if err != nil {
-					logger.Errorf(err.Error())
+					clog.Errorf(ctx, "%v", err)
+					return
 				}
+				defer release()
 				client.ReportInventory(ctx)
-				client.Close()
 			})
 		}
 
-		select {
-		case <-ticker.C:
-			continue
-		case <-ctx.Done():
+		if !waitForNextCycle() {
 			return
 		}
 	}
@@ -347,6 +521,145 @@ func main() {
 			os.Exit(1)
 		}
 		os.Exit(0)
+	// inventory -local gathers and prints inventory without the metadata
+	// server or the API, for troubleshooting package detection.
+	case "inventory", "osinventory":
+		inventoryFlags.Parse(flag.Args()[1:])
+		if *inventoryDiff {
+			// The diff is printed as text unless -format is set.
+			formatSet := false
+			inventoryFlags.Visit(func(f *flag.Flag) { formatSet = formatSet || f.Name == "format" })
+			if !formatSet {
+				inventoryOpts.format = inventory.FormatText
+			}
+			inventoryOpts.validate()
+			if inventoryOpts.format == inventory.FormatManifest {
+				inventoryOpts.fail(exitUsage, errors.New("-diff does not support -format manifest"))
+			}
+			if inventoryFlags.NArg() != 2 {
+				inventoryOpts.fail(exitUsage, errors.New("-diff requires two inventories, before and after"))
+			}
+			inventoryOpts.initLogging(ctx)
+			var invs []*inventory.InstanceInventory
+			for _, src := range inventoryFlags.Args() {
+				inv, err := inventory.ReadInventory(ctx, src)
+				if err != nil {
+					inventoryOpts.fail(exitInvalidInput, err)
+				}
+				invs = append(invs, inv)
+			}
+			d := inventory.DiffPackages(invs[0].InstalledPackages, invs[1].InstalledPackages)
+			if err := inventory.WriteDiff(inventoryOpts.output(), d, inventoryOpts.format); err != nil {
+				inventoryOpts.fail(exitError, err)
+			}
+			os.Exit(exitOK)
+		}
+		if !*localInventory {
+			run(ctx)
+			break
+		}
+		inventoryOpts.validate()
+		inventoryOpts.initLogging(ctx)
+		if err := inventory.WriteLocal(ctx, inventoryOpts.output(), agentendpoint.GetInventory(ctx), *inventoryOutput, inventoryOpts.format); err != nil {
+			inventoryOpts.fail(exitError, err)
+		}
+		os.Exit(exitOK)
+	// policies -once -from-file applies a local OS policy, for testing
+	// policies on a VM without an OS policy assignment.
+	case "gp", "policies", "guestpolicies", "ospackage":
+		policiesFlags.Parse(flag.Args()[1:])
+		if !*policiesOnce {
+			if *policiesFile != "" || *policiesImageBuild {
+				policiesOpts.fail(exitUsage, errors.New("-from-file and -image-build require -once"))
+			}
+			run(ctx)
+			break
+		}
+		policiesOpts.validate()
+		if *policiesFile == "" {
+			policiesOpts.fail(exitUsage, errors.New("-once requires -from-file"))
+		}
+		policiesOpts.initLogging(ctx)
+		results, err := agentendpoint.ApplyLocalPolicies(ctx, *policiesFile, *policiesImageBuild)
+		if errors.Is(err, agentendpoint.ErrInvalidLocalPolicy) || os.IsNotExist(err) {
+			policiesOpts.fail(exitInvalidInput, err)
+		}
+		if err != nil {
+			policiesOpts.fail(exitError, err)
+		}
+		if err := agentendpoint.WriteLocalPolicyResults(policiesOpts.output(), results, policiesOpts.format); err != nil {
+			policiesOpts.fail(exitError, err)
+		}
+		if !agentendpoint.LocalPoliciesCompliant(results) {
+			os.Exit(exitNonCompliant)
+		}
+		os.Exit(exitOK)
+	// status prints the changes the agent made to this host, from the
+	// change journal, or the OS policy assignments it last applied.
+	case "status":
+		statusFlags.Parse(flag.Args()[1:])
+		statusOpts.validate()
+		statusOpts.initLogging(ctx)
+		if *statusAssignments {
+			as, err := agentendpoint.LoadAssignments()
+			if err != nil {
+				statusOpts.fail(exitError, err)
+			}
+			if err := agentendpoint.WriteAssignments(statusOpts.output(), as, statusOpts.format); err != nil {
+				statusOpts.fail(exitError, err)
+			}
+			os.Exit(exitOK)
+		}
+		var since time.Time
+		if *statusSince > 0 {
+			since = time.Now().Add(-*statusSince)
+		}
+		entries, err := journal.Query(agentconfig.JournalFile(), since, *statusID)
+		if err != nil {
+			statusOpts.fail(exitError, err)
+		}
+		if err := journal.Write(statusOpts.output(), entries, statusOpts.format); err != nil {
+			statusOpts.fail(exitError, err)
+		}
+		os.Exit(exitOK)
+	// sbom exports the inventory as an SPDX or CycloneDX document.
+	case "sbom":
+		sbomFlags.Parse(flag.Args()[1:])
+		// The format and destination used to be positional, sbom spdx path.
+		if sbomFlags.NArg() > 0 {
+			sbomOpts.format = sbomFlags.Arg(0)
+		}
+		if sbomFlags.NArg() > 1 {
+			*sbomOutput = sbomFlags.Arg(1)
+		}
+		sbomOpts.validate()
+		sbomOpts.initLogging(ctx)
+		if err := inventory.ExportSBOM(ctx, sbomOpts.output(), agentendpoint.GetInventory(ctx), sbomOpts.format, *sbomOutput); err != nil {
+			sbomOpts.fail(exitError, err)
+		}
+		os.Exit(exitOK)
+	// check runs preflight diagnostics of what the agent needs to work, for
+	// agents that fail without reporting anything.
+	case "check":
+		checkFlags.Parse(flag.Args()[1:])
+		checkOpts.validate()
+		checkOpts.initLogging(ctx)
+		report := preflight.Run(ctx, *checkTimeout)
+		if err := preflight.Write(checkOpts.output(), report, checkOpts.format); err != nil {
+			checkOpts.fail(exitError, err)
+		}
+		if report.Failed() {
+			os.Exit(exitError)
+		}
+		os.Exit(exitOK)
+	// privileged-helper <agent user> enforces OS policies for an agent
+	// running as that user, it is started by systemd socket activation.
+	case "privileged-helper":
+		logger.Init(ctx, logger.LogOpts{LoggerName: "OSConfigPrivilegedHelper", Writers: []io.Writer{os.Stderr}, DisableLocalLogging: true, DisableCloudLogging: true})
+		if err := runPrivilegedHelper(ctx, flag.Arg(1)); err != nil {
+			clog.Fatalf(ctx, "Privileged helper error: %v", err)
+		}
+		os.Exit(exitOK)
 	case "", "run":
 		runService(ctx)
 	default:
@@ -17,29 +17,42 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"os/user"
 	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 
-	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/privhelper"
 )
 
 func runService(ctx context.Context) {
 	run(ctx)
 }
 
-func obtainLock() {
+func obtainLock(ctx context.Context) {
 	lockFile := "/run/lock/osconfig_agent.lock"
+	if agentconfig.Unprivileged() {
+		// Only root can write to /run/lock on some distributions.
+		lockFile = filepath.Join(agentconfig.CacheDir(), "osconfig_agent.lock")
+		if err := os.MkdirAll(agentconfig.CacheDir(), 0700); err != nil {
+			clog.Fatalf(ctx, "Cannot obtain agent lock: %v", err)
+		}
+	}
 
 	err := os.Mkdir(filepath.Dir(lockFile), 1777)
 	if err != nil && !os.IsExist(err) {
-		logger.Fatalf("Cannot obtain agent lock: %v", err)
+		clog.Fatalf(ctx, "Cannot obtain agent lock: %v", err)
 	}
 
 	f, err := os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil && !os.IsExist(err) {
-		logger.Fatalf("Cannot obtain agent lock: %v", err)
+		clog.Fatalf(ctx, "Cannot obtain agent lock: %v", err)
 	}
 
 	c := make(chan error)
@@ -49,15 +62,69 @@ func obtainLock() {
 	select {
 	case err := <-c:
 		if err != nil {
-			logger.Fatalf("Cannot obtain agent lock, is the agent already running? Error: %v", err)
+			clog.Fatalf(ctx, "Cannot obtain agent lock, is the agent already running? Error: %v", err)
 		}
 	case <-time.After(time.Second):
-		logger.Fatalf("OSConfig agent lock already held, is the agent already running?")
+		clog.Fatalf(ctx, "OSConfig agent lock already held, is the agent already running?")
 	}
 
 	deferredFuncs = append(deferredFuncs, func() { syscall.Flock(int(f.Fd()), syscall.LOCK_UN); f.Close(); os.Remove(lockFile) })
 }
 
+// reloadRequests returns a channel that receives a value each time the agent
+// receives a SIGHUP.
+func reloadRequests(ctx context.Context) <-chan struct{} {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	reload := make(chan struct{}, 1)
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sig:
+				select {
+				case reload <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return reload
+}
+
 func wuaUpdates(ctx context.Context, _ string) error {
 	return errors.New("wuaUpdates not implemented on linux")
 }
+
+// privilegedHelperIdleTimeout is how long the privileged helper waits for the
+// next request before it exits, systemd starts it again on demand.
+const privilegedHelperIdleTimeout = time.Minute
+
+// runPrivilegedHelper serves the privileged actions of the agent running as
+// username on the socket passed by systemd.
+func runPrivilegedHelper(ctx context.Context, username string) error {
+	if username == "" {
+		return errors.New("missing the user the agent runs as")
+	}
+	u, err := user.Lookup(username)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %q of user %q: %v", u.Uid, username, err)
+	}
+	l, err := privhelper.ActivationListener()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	cfg, err := privhelper.LoadConfig(privhelper.ConfigFile)
+	if err != nil {
+		return err
+	}
+	s := &privhelper.Server{UID: uid, Config: *cfg, IdleTimeout: privilegedHelperIdleTimeout}
+	return s.Serve(ctx, l)
+}
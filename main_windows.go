@@ -24,14 +24,18 @@ import (
 	"syscall"
 	"unsafe"
 
-	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
 	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/svc"
 )
 
 var (
+	// reload is signaled when the service receives a ParamChange control
+	// request, e.g. "sc control google_osconfig_agent paramchange".
+	reload = make(chan struct{}, 1)
+
 	kernel32         = windows.NewLazySystemDLL("kernel32.dll")
 	procLockFileEx   = kernel32.NewProc("LockFileEx")
 	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
@@ -76,20 +80,20 @@ func unlockFileEx(hFile uintptr, nNumberOfBytesToLockLow, nNumberOfBytesToLockHi
 	return nil
 }
 
-func obtainLock() {
+func obtainLock(ctx context.Context) {
 	lockFile := filepath.Join(agentconfig.GetCacheDirWindows(), "lock")
 
 	err := os.MkdirAll(filepath.Dir(lockFile), 0755)
 	if err != nil && !os.IsExist(err) {
-		logger.Fatalf("Cannot obtain agent lock: %v", err)
+		clog.Fatalf(ctx, "Cannot obtain agent lock: %v", err)
 	}
 	f, err := os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil && !os.IsExist(err) {
-		logger.Fatalf("Cannot obtain agent lock: %v", err)
+		clog.Fatalf(ctx, "Cannot obtain agent lock: %v", err)
 	}
 
 	if err := lockFileEx(f.Fd(), LOCKFILE_EXCLUSIVE_LOCK|LOCKFILE_FAIL_IMMEDIATELY, 1, 0, &syscall.Overlapped{}); err != nil {
-		logger.Fatalf("OSConfig agent lock already held, is the agent already running?")
+		clog.Fatalf(ctx, "OSConfig agent lock already held, is the agent already running?")
 	}
 
 	deferredFuncs = append(deferredFuncs, func() { unlockFileEx(f.Fd(), 1, 0, &syscall.Overlapped{}); f.Close(); os.Remove(lockFile) })
@@ -110,7 +114,7 @@ func (s *service) Execute(_ []string, r <-chan svc.ChangeRequest, status chan<-
 		s.run(ctx)
 		close(done)
 	}()
-	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange}
 
 	for {
 		select {
@@ -123,6 +127,11 @@ func (s *service) Execute(_ []string, r <-chan svc.ChangeRequest, status chan<-
 				status <- c.CurrentStatus
 			case svc.Stop, svc.Shutdown:
 				cncl()
+			case svc.ParamChange:
+				select {
+				case reload <- struct{}{}:
+				default:
+				}
 			default:
 			}
 		}
@@ -131,10 +140,16 @@ func (s *service) Execute(_ []string, r <-chan svc.ChangeRequest, status chan<-
 
 func runService(ctx context.Context) {
 	if err := svc.Run(serviceName, &service{run: run, ctx: ctx}); err != nil {
-		logger.Fatalf("svc.Run error: %v", err)
+		clog.Fatalf(ctx, "svc.Run error: %v", err)
 	}
 }
 
+// reloadRequests returns a channel that receives a value each time the service
+// receives a ParamChange control request.
+func reloadRequests(_ context.Context) <-chan struct{} {
+	return reload
+}
+
 func wuaUpdates(ctx context.Context, query string) error {
 	updts, err := packages.WUAUpdates(ctx, query)
 	if err != nil {
@@ -147,3 +162,7 @@ func wuaUpdates(ctx context.Context, query string) error {
 	fmt.Fprint(os.Stdout, string(data))
 	return nil
 }
+
+func runPrivilegedHelper(ctx context.Context, _ string) error {
+	return errors.New("the privileged helper is not implemented on windows, the agent runs as SYSTEM")
+}
@@ -25,6 +25,14 @@ const (
 // OSInfo describes an operating system.
 type OSInfo struct {
 	Hostname, LongName, ShortName, Version, KernelVersion, KernelRelease, Architecture string
+
+	// ImageID, ImageVersion and BuildID identify the image the OS was
+	// built from, if the image records them in os-release.
+	ImageID, ImageVersion, BuildID string
+
+	// TPMVersion is the spec version of the (v)TPM, like 2.0, empty if the
+	// instance has none.
+	TPMVersion string
 }
 
 // Architecture attempts to standardize architecture naming.
@@ -18,6 +18,8 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -35,6 +37,28 @@ const (
 	rhRelease = "/etc/redhat-release"
 )
 
+var tpmClassDir = "/sys/class/tpm"
+
+// tpmVersion returns the version of the first TPM in dir, the sysfs tpm
+// class. Kernels before 5.6 do not report the version, TPM 2.0 devices are
+// then told apart by their resource manager device.
+func tpmVersion(dir string) string {
+	if !util.Exists(filepath.Join(dir, "tpm0")) {
+		return ""
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "tpm0", "tpm_version_major"))
+	switch strings.TrimSpace(string(b)) {
+	case "2":
+		return "2.0"
+	case "1":
+		return "1.2"
+	}
+	if err != nil && util.Exists(filepath.Join(filepath.Dir(dir), "tpmrm", "tpmrm0")) {
+		return "2.0"
+	}
+	return "unknown"
+}
+
 func parseOsRelease(releaseDetails string) *OSInfo {
 	oi := &OSInfo{}
 
@@ -50,9 +74,12 @@ func parseOsRelease(releaseDetails string) *OSInfo {
 			oi.Version = strings.Trim(entry[1], `"`)
 		case "ID":
 			oi.ShortName = strings.Trim(entry[1], `"`)
-		}
-		if oi.LongName != "" && oi.Version != "" && oi.ShortName != "" {
-			break
+		case "IMAGE_ID":
+			oi.ImageID = strings.Trim(entry[1], `"`)
+		case "IMAGE_VERSION":
+			oi.ImageVersion = strings.Trim(entry[1], `"`)
+		case "BUILD_ID":
+			oi.BuildID = strings.Trim(entry[1], `"`)
 		}
 	}
 
@@ -117,6 +144,7 @@ func Get() (*OSInfo, error) {
 	oi.Architecture = Architecture(string(bytes.TrimRight(uts.Machine[:], "\x00")))
 	oi.KernelVersion = string(bytes.TrimRight(uts.Version[:], "\x00"))
 	oi.KernelRelease = string(bytes.TrimRight(uts.Release[:], "\x00"))
+	oi.TPMVersion = tpmVersion(tpmClassDir)
 
 	return oi, nil
 }
@@ -15,9 +15,43 @@
 package osinfo
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 )
 
+func TestTPMVersion(t *testing.T) {
+	tests := []struct {
+		desc  string
+		files map[string]string
+		want  string
+	}{
+		{"no tpm", nil, ""},
+		{"tpm 2.0", map[string]string{"tpm/tpm0/tpm_version_major": "2\n"}, "2.0"},
+		{"tpm 1.2", map[string]string{"tpm/tpm0/tpm_version_major": "1\n"}, "1.2"},
+		{"old kernel with a resource manager", map[string]string{"tpm/tpm0/dev": "", "tpmrm/tpmrm0/dev": ""}, "2.0"},
+		{"old kernel", map[string]string{"tpm/tpm0/dev": ""}, "unknown"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if err := os.Mkdir(filepath.Join(dir, "tpm"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range tt.files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := tpmVersion(filepath.Join(dir, "tpm")); got != tt.want {
+			t.Errorf("%s: tpmVersion() = %q, want %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
 // debian system with all details in os-release file
 // happy case, taken from google desktop
 func TestGetDistributionInfoOSRelease(t *testing.T) {
@@ -49,6 +83,34 @@ BUG_REPORT_URL="https://bugs.debian.org/"
 	}
 }
 
+// container-optimized os with image details in os-release file
+func TestGetDistributionInfoOSReleaseImage(t *testing.T) {
+	fcontent := `NAME="Container-Optimized OS"
+ID=cos
+VERSION_ID=109
+BUILD_ID=17800.66.78
+IMAGE_ID="cos-109"
+IMAGE_VERSION="17800.66.78"
+`
+	di := parseOsRelease(fcontent)
+	tests := []struct {
+		expect string
+		actual string
+		errMsg string
+	}{
+		{"cos", di.ShortName, "unexpected short name"},
+		{"cos-109", di.ImageID, "unexpected image id"},
+		{"17800.66.78", di.ImageVersion, "unexpected image version"},
+		{"17800.66.78", di.BuildID, "unexpected build id"},
+	}
+
+	for _, v := range tests {
+		if v.actual != v.expect {
+			t.Errorf("%s! expected(%s); got(%s)", v.errMsg, v.expect, v.actual)
+		}
+	}
+}
+
 // debian system with empty os-release file
 // with empty file, the short name should default to Linux
 func TestGetDistributionInfoEmptyOSRelease(t *testing.T) {
@@ -19,6 +19,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
 	"unsafe"
 
@@ -137,6 +138,23 @@ type win32OperatingSystem struct {
 	Caption, Version string
 }
 
+type win32Tpm struct {
+	SpecVersion string
+}
+
+// tpmVersion returns the spec version of the TPM, empty if there is none or
+// it can't be queried.
+func tpmVersion() string {
+	var tpms []win32Tpm
+	if err := wmi.QueryNamespace("SELECT SpecVersion FROM Win32_Tpm", &tpms, `root\CIMV2\Security\MicrosoftTpm`); err != nil || len(tpms) == 0 {
+		return ""
+	}
+	// SpecVersion is like "2.0, 0, 1.38", the spec version followed by
+	// the spec level and revision.
+	v, _, _ := strings.Cut(tpms[0].SpecVersion, ",")
+	return strings.TrimSpace(v)
+}
+
 // Get reports OSInfo.
 func Get() (*OSInfo, error) {
 	oi := &OSInfo{ShortName: Windows, Architecture: Architecture(runtime.GOARCH)}
@@ -153,6 +171,7 @@ func Get() (*OSInfo, error) {
 	}
 	oi.KernelVersion = kVersion
 	oi.KernelRelease = kRelease
+	oi.TPMVersion = tpmVersion()
 
 	var ops []win32OperatingSystem
 	query := "SELECT Caption, Version FROM Win32_OperatingSystem"
@@ -27,6 +27,9 @@ type aptGetUpgradeOpts struct {
 	excludes          []*Exclude
 	upgradeType       packages.AptUpgradeType
 	dryrun            bool
+	withNewPkgs       bool
+	autoremove        bool
+	changes           *ChangeSet
 }
 
 // AptGetUpgradeOption is an option for apt-get update.
@@ -60,6 +63,29 @@ func AptGetDryRun(dryrun bool) AptGetUpgradeOption {
 	}
 }
 
+// AptGetChangeSet adds the changes of a dry run to changes.
+func AptGetChangeSet(changes *ChangeSet) AptGetUpgradeOption {
+	return func(args *aptGetUpgradeOpts) {
+		args.changes = changes
+	}
+}
+
+// AptGetWithNewPkgs lets the upgrade install new dependencies, like apt-get
+// upgrade --with-new-pkgs. The new packages are marked as automatically
+// installed.
+func AptGetWithNewPkgs(withNewPkgs bool) AptGetUpgradeOption {
+	return func(args *aptGetUpgradeOpts) {
+		args.withNewPkgs = withNewPkgs
+	}
+}
+
+// AptGetAutoremove runs apt-get autoremove after the upgrade.
+func AptGetAutoremove(autoremove bool) AptGetUpgradeOption {
+	return func(args *aptGetUpgradeOpts) {
+		args.autoremove = autoremove
+	}
+}
+
 // RunAptGetUpgrade runs apt-get upgrade.
 func RunAptGetUpgrade(ctx context.Context, opts ...AptGetUpgradeOption) error {
 	aptOpts := &aptGetUpgradeOpts{
@@ -73,7 +99,17 @@ func RunAptGetUpgrade(ctx context.Context, opts ...AptGetUpgradeOption) error {
 		opt(aptOpts)
 	}
 
-	pkgs, err := packages.AptUpdates(ctx, packages.AptGetUpgradeType(aptOpts.upgradeType), packages.AptGetUpgradeShowNew(true))
+	if err := aptGetUpgrade(ctx, aptOpts); err != nil {
+		return err
+	}
+	if aptOpts.autoremove {
+		return aptGetAutoremove(ctx, aptOpts.dryrun, aptOpts.changes)
+	}
+	return nil
+}
+
+func aptGetUpgrade(ctx context.Context, aptOpts *aptGetUpgradeOpts) error {
+	pkgs, err := packages.AptUpdates(ctx, packages.AptGetUpgradeType(aptOpts.upgradeType), packages.AptGetUpgradeShowNew(true), packages.AptGetUpgradeWithNewPkgs(aptOpts.withNewPkgs))
 	if err != nil {
 		return err
 	}
@@ -95,20 +131,89 @@ func RunAptGetUpgrade(ctx context.Context, opts ...AptGetUpgradeOption) error {
 	msg := fmt.Sprintf("%d packages: %q", len(pkgNames), fPkgs)
 	if aptOpts.dryrun {
 		clog.Infof(ctx, "Running in dryrun mode, not updating %s", msg)
+		aptOpts.changes.add(opsToReport{packages: fPkgs})
 		return nil
 	}
 
 	ops := opsToReport{
 		packages: fPkgs,
 	}
+	if aptOpts.withNewPkgs {
+		ops.packages, ops.newPackages, err = splitNewAptPackages(ctx, fPkgs)
+		if err != nil {
+			return err
+		}
+	}
 	logOps(ctx, ops)
 
 	err = packages.InstallAptPackages(ctx, pkgNames)
-	if err == nil {
-		logSuccess(ctx, ops)
-	} else {
+	if err != nil {
 		logFailure(ctx, ops, err)
+		return err
+	}
+	logSuccess(ctx, ops)
+
+	// Installing the new packages by name marks them as manually installed,
+	// they are dependencies so autoremove should remove them once unused.
+	if len(ops.newPackages) > 0 {
+		var names []string
+		for _, pkg := range ops.newPackages {
+			names = append(names, pkg.Name)
+		}
+		if err := packages.AptMarkAuto(ctx, names); err != nil {
+			clog.Warningf(ctx, "Error marking new packages as automatically installed: %v", err)
+		}
 	}
+	return nil
+}
 
-	return err
+// splitNewAptPackages splits pkgs into upgrades of installed packages and
+// new packages.
+func splitNewAptPackages(ctx context.Context, pkgs []*packages.PkgInfo) ([]*packages.PkgInfo, []*packages.PkgInfo, error) {
+	installed, err := packages.InstalledDebPackages(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := map[string]bool{}
+	for _, pkg := range installed {
+		names[pkg.Name] = true
+	}
+	var upgrades, newPkgs []*packages.PkgInfo
+	for _, pkg := range pkgs {
+		if names[pkg.Name] {
+			upgrades = append(upgrades, pkg)
+		} else {
+			newPkgs = append(newPkgs, pkg)
+		}
+	}
+	return upgrades, newPkgs, nil
+}
+
+// aptGetAutoremove removes the automatically installed packages that are no
+// longer needed.
+func aptGetAutoremove(ctx context.Context, dryrun bool, changes *ChangeSet) error {
+	pkgs, err := packages.AptAutoremovable(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pkgs) == 0 {
+		clog.Infof(ctx, "No unused packages to remove.")
+		return nil
+	}
+	if dryrun {
+		clog.Infof(ctx, "Running in dryrun mode, not removing %d unused packages: %q", len(pkgs), pkgs)
+		changes.add(opsToReport{removed: pkgs})
+		return nil
+	}
+
+	ops := opsToReport{
+		removed: pkgs,
+	}
+	logOps(ctx, ops)
+	if err := packages.AptAutoremove(ctx); err != nil {
+		logFailure(ctx, ops, err)
+		return err
+	}
+	logSuccess(ctx, ops)
+	return nil
 }
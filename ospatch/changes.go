@@ -0,0 +1,69 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package ospatch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+// ChangeSet is the change a dry run predicts an update would make, see
+// AptGetChangeSet, YumChangeSet and ZypperChangeSet.
+type ChangeSet struct {
+	Packages []*packages.PkgInfo     `json:",omitempty"`
+	Patches  []*packages.ZypperPatch `json:",omitempty"`
+	Removed  []*packages.PkgInfo     `json:",omitempty"`
+}
+
+func (c *ChangeSet) add(ops opsToReport) {
+	if c == nil {
+		return
+	}
+	c.Packages = append(append(c.Packages, ops.packages...), ops.newPackages...)
+	c.Patches = append(c.Patches, ops.patches...)
+	c.Removed = append(c.Removed, ops.removed...)
+}
+
+// Empty reports whether the change set has no changes.
+func (c *ChangeSet) Empty() bool {
+	return c == nil || len(c.Packages)+len(c.Patches)+len(c.Removed) == 0
+}
+
+func (c *ChangeSet) String() string {
+	if c.Empty() {
+		return "no changes"
+	}
+	var parts []string
+	if len(c.Packages) > 0 {
+		parts = append(parts, fmt.Sprintf("update %d packages: %q", len(c.Packages), c.Packages))
+	}
+	if len(c.Patches) > 0 {
+		parts = append(parts, fmt.Sprintf("install %d patches: %s", len(c.Patches), formatPatches(c.Patches)))
+	}
+	if len(c.Removed) > 0 {
+		parts = append(parts, fmt.Sprintf("remove %d unused packages: %q", len(c.Removed), c.Removed))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Log logs the change set as predicted changes for the purpose of patch
+// report.
+func (c *ChangeSet) Log(ctx context.Context) {
+	clog.Infof(clog.WithLabels(ctx, repLabels), "Simulated update, predicted changes: %s", c)
+}
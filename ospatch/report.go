@@ -32,6 +32,10 @@ var repLabels = map[string]string{"package-report": "true"}
 type opsToReport struct {
 	packages []*packages.PkgInfo
 	patches  []*packages.ZypperPatch
+	// newPackages are new dependencies installed by the update, removed
+	// the unused packages removed by apt-get autoremove.
+	newPackages []*packages.PkgInfo
+	removed     []*packages.PkgInfo
 }
 
 func formatPatches(patches []*packages.ZypperPatch) string {
@@ -53,6 +57,14 @@ func logOps(ctx context.Context, ops opsToReport) {
 	}
 	if len(ops.patches) > 0 {
 		msg = msg + fmt.Sprintf("%sInstalling %d patches: %s", sep, len(ops.patches), formatPatches(ops.patches))
+		sep = "; "
+	}
+	if len(ops.newPackages) > 0 {
+		msg = msg + fmt.Sprintf("%sInstalling %d new packages: %q", sep, len(ops.newPackages), ops.newPackages)
+		sep = "; "
+	}
+	if len(ops.removed) > 0 {
+		msg = msg + fmt.Sprintf("%sRemoving %d unused packages: %q", sep, len(ops.removed), ops.removed)
 	}
 
 	clog.Infof(clog.WithLabels(ctx, repLabels), msg)
@@ -70,6 +82,14 @@ func logSuccess(ctx context.Context, ops opsToReport) {
 	}
 	if len(patches) > 0 {
 		msg = msg + fmt.Sprintf("%sApplied %d patches: %s", sep, len(patches), formatPatches(patches))
+		sep = "; "
+	}
+	if len(ops.newPackages) > 0 {
+		msg = msg + fmt.Sprintf("%sInstalled %d new packages: %q", sep, len(ops.newPackages), ops.newPackages)
+		sep = "; "
+	}
+	if len(ops.removed) > 0 {
+		msg = msg + fmt.Sprintf("%sRemoved %d unused packages: %q", sep, len(ops.removed), ops.removed)
 	}
 	msg = fmt.Sprintf("Success. %s", msg)
 	clog.Infof(clog.WithLabels(ctx, repLabels), msg)
@@ -87,6 +107,14 @@ func logFailure(ctx context.Context, ops opsToReport, err error) {
 	}
 	if len(patches) > 0 {
 		msg = msg + fmt.Sprintf("%sTried to apply %d patches: %s", sep, len(patches), formatPatches(patches))
+		sep = "; "
+	}
+	if len(ops.newPackages) > 0 {
+		msg = msg + fmt.Sprintf("%sTried to install %d new packages: %q", sep, len(ops.newPackages), ops.newPackages)
+		sep = "; "
+	}
+	if len(ops.removed) > 0 {
+		msg = msg + fmt.Sprintf("%sTried to remove %d unused packages: %q", sep, len(ops.removed), ops.removed)
 	}
 	msg = fmt.Sprintf("Failure: %s. Error: %v", msg, err)
 	clog.Infof(clog.WithLabels(ctx, repLabels), msg)
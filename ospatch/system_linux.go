@@ -25,6 +25,7 @@ import (
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
 const (
@@ -56,7 +57,9 @@ func DisableAutoUpdates(ctx context.Context) {
 			clog.Errorf(ctx, "Error disabling yum-cron, error: %v, out: %s", err, out)
 		}
 	} else if _, err := os.Stat("/usr/sbin/yum-cron"); err == nil {
-		out, err := exec.Command("/sbin/chkconfig", "yum-cron").CombinedOutput()
+		cmd := exec.Command("/sbin/chkconfig", "yum-cron")
+		util.SetCLocale(cmd)
+		out, err := cmd.CombinedOutput()
 		if err != nil {
 			clog.Errorf(ctx, "Error checking status of yum-cron, error: %v, out: %s", err, out)
 		}
@@ -73,7 +76,9 @@ func DisableAutoUpdates(ctx context.Context) {
 
 	// dnf-automatic on el8 systems
 	if _, err := os.Stat("/usr/lib/systemd/system/dnf-automatic.timer"); err == nil {
-		out, err := exec.Command(systemctl, "list-timers", "dnf-automatic.timer").CombinedOutput()
+		cmd := exec.Command(systemctl, "list-timers", "dnf-automatic.timer")
+		util.SetCLocale(cmd)
+		out, err := cmd.CombinedOutput()
 		if err != nil {
 			clog.Errorf(ctx, "Error checking status of dnf-automatic, error: %v, out: %s", err, out)
 		}
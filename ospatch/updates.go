@@ -24,6 +24,7 @@ import (
 	"strconv"
 
 	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
 const (
@@ -95,7 +96,9 @@ func rpmReboot() (bool, error) {
 		"kernel-firmware", "libopenssl1_1", "libopenssl1_0_0", "dbus-1",
 	}
 	args := append([]string{"--queryformat", "%{INSTALLTIME}\n", "--whatprovides"}, provides...)
-	out, err := exec.Command(rpmquery, args...).Output()
+	cmd := exec.Command(rpmquery, args...)
+	util.SetCLocale(cmd)
+	out, err := cmd.Output()
 	if err != nil {
 		// We don't care about return codes as we know some of these packages won't be installed.
 		if _, ok := err.(*exec.ExitError); !ok {
@@ -35,6 +35,7 @@ type yumUpdateOpts struct {
 	security          bool
 	minimal           bool
 	dryrun            bool
+	changes           *ChangeSet
 }
 
 // YumUpdateOption is an option for yum update.
@@ -87,6 +88,13 @@ func fullPackageName(pkgInfo *packages.PkgInfo) string {
 	return pkgName
 }
 
+// YumChangeSet adds the changes of a dry run to changes.
+func YumChangeSet(changes *ChangeSet) YumUpdateOption {
+	return func(args *yumUpdateOpts) {
+		args.changes = changes
+	}
+}
+
 // RunYumUpdate runs yum update.
 func RunYumUpdate(ctx context.Context, opts ...YumUpdateOption) error {
 	yumOpts := &yumUpdateOpts{
@@ -123,6 +131,7 @@ func RunYumUpdate(ctx context.Context, opts ...YumUpdateOption) error {
 	msg := fmt.Sprintf("%d packages: %q", len(pkgNames), fPkgs)
 	if yumOpts.dryrun {
 		clog.Infof(ctx, "Running in dryrun mode, not updating %s", msg)
+		yumOpts.changes.add(opsToReport{packages: fPkgs})
 		return nil
 	}
 	ops := opsToReport{
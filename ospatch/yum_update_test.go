@@ -103,3 +103,44 @@ func TestRunYumUpdateWithSecurityWithExclusives(t *testing.T) {
 		t.Errorf("did not expect error: %+v", err)
 	}
 }
+
+func TestRunYumUpdateChangeSet(t *testing.T) {
+	data := []byte(`
+	=================================================================================================================================================================================
+	Package                                      Arch                           Version                                              Repository                                Size
+	=================================================================================================================================================================================
+	Upgrading:
+	  foo                                       noarch                         2.0.0-1                                              BaseOS                                   361 k
+	  bar                                       x86_64                         2.0.0-1                                              repo                                      10 M
+`)
+	ctx := context.Background()
+
+	if os.Getenv("EXIT100") == "1" {
+		os.Exit(100)
+	}
+
+	cmd := exec.CommandContext(context.Background(), os.Args[0], "-test.run=TestRunYumUpdateChangeSet")
+	cmd.Env = append(os.Environ(), "EXIT100=1")
+	err := cmd.Run()
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// A dry run installs nothing, yum is only asked for the updates.
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	packages.SetCommandRunner(mockCommandRunner)
+	mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command("/usr/bin/yum", []string{"check-update", "--assumeyes"}...))).Return([]byte("stdout"), []byte("stderr"), err).Times(1)
+	packages.SetPtyCommandRunner(mockCommandRunner)
+	mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command("/usr/bin/yum", []string{"update", "--assumeno", "--cacheonly", "--color=never"}...))).Return(data, []byte("stderr"), nil).Times(1)
+
+	changes := &ChangeSet{}
+	if err := RunYumUpdate(ctx, YumDryRun(true), YumChangeSet(changes)); err != nil {
+		t.Fatalf("did not expect error: %+v", err)
+	}
+	if got, want := changes.String(), `update 2 packages: ["foo all 2.0.0-1" "bar x86_64 2.0.0-1"]`; got != want {
+		t.Errorf("ChangeSet: got %q, want %q", got, want)
+	}
+	if (*ChangeSet)(nil).String() != "no changes" {
+		t.Errorf("nil ChangeSet: got %q, want %q", (*ChangeSet)(nil).String(), "no changes")
+	}
+}
@@ -36,6 +36,7 @@ type zypperPatchOpts struct {
 	withOptional     bool
 	withUpdate       bool
 	dryrun           bool
+	changes          *ChangeSet
 }
 
 // ZypperPatchOption is an option for zypper patch.
@@ -96,6 +97,13 @@ func ZypperUpdateDryrun(dryrun bool) ZypperPatchOption {
 	}
 }
 
+// ZypperChangeSet adds the changes of a dry run to changes.
+func ZypperChangeSet(changes *ChangeSet) ZypperPatchOption {
+	return func(args *zypperPatchOpts) {
+		args.changes = changes
+	}
+}
+
 // RunZypperPatch runs zypper patch.
 func RunZypperPatch(ctx context.Context, opts ...ZypperPatchOption) error {
 	zOpts := &zypperPatchOpts{
@@ -171,6 +179,7 @@ func RunZypperPatch(ctx context.Context, opts ...ZypperPatchOption) error {
 	logOps(ctx, ops)
 
 	if zOpts.dryrun {
+		zOpts.changes.add(opsToReport{patches: fPatches, packages: fpkgs})
 		return nil
 	}
 	err = packages.ZypperInstall(ctx, fPatches, fpkgs)
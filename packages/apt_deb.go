@@ -22,9 +22,11 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
 	"github.com/GoogleCloudPlatform/osconfig/osinfo"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 )
@@ -34,6 +36,8 @@ var (
 	dpkgQuery string
 	dpkgDeb   string
 	aptGet    string
+	aptMark   string
+	aptCache  string
 
 	dpkgInstallArgs          = []string{"--install"}
 	dpkgPackageFieldsMapping = map[string]string{
@@ -43,18 +47,24 @@ var (
 		"status":         "${db:Status-Status}",
 		"source_name":    "${source:Package}",
 		"source_version": "${source:Version}",
+		// Requires dpkg 1.19.3 or later, older versions leave it empty.
+		"install_time": "${db-fsys:Last-Modified}",
 	}
 
 	dpkgQueryArgs     = []string{"-W", "-f", formatFieldsMappingToFormattingString(dpkgPackageFieldsMapping)}
 	dpkgRepairArgs    = []string{"--configure", "-a"}
 	aptGetInstallArgs = []string{"install", "-y"}
 	aptGetRemoveArgs  = []string{"remove", "-y"}
+	aptGetAutoremove  = []string{"autoremove", "-y"}
+	aptMarkAutoArgs   = []string{"auto"}
 	aptGetUpdateArgs  = []string{"update"}
+	aptCachePolicy    = []string{"policy"}
 
 	aptGetUpgradeCmd     = "upgrade"
 	aptGetFullUpgradeCmd = "full-upgrade"
 	aptGetDistUpgradeCmd = "dist-upgrade"
 	aptGetUpgradableArgs = []string{"--just-print", "-qq"}
+	aptGetWithNewPkgsArg = "--with-new-pkgs"
 	allowDowngradesArg   = "--allow-downgrades"
 
 	dpkgErr = []byte("dpkg --configure -a")
@@ -66,6 +76,8 @@ func init() {
 		dpkgQuery = "/usr/bin/dpkg-query"
 		dpkgDeb = "/usr/bin/dpkg-deb"
 		aptGet = "/usr/bin/apt-get"
+		aptMark = "/usr/bin/apt-mark"
+		aptCache = "/usr/bin/apt-cache"
 	}
 	AptExists = util.Exists(aptGet)
 	DpkgExists = util.Exists(dpkg)
@@ -88,6 +100,7 @@ type aptGetUpgradeOpts struct {
 	upgradeType     AptUpgradeType
 	showNew         bool
 	allowDowngrades bool
+	withNewPkgs     bool
 }
 
 // AptGetUpgradeOption is an option for apt-get upgrade.
@@ -114,6 +127,16 @@ func AptGetUpgradeAllowDowngrades(allowDowngrades bool) AptGetUpgradeOption {
 	}
 }
 
+// AptGetUpgradeWithNewPkgs returns a AptGetUpgradeOption that lets apt-get
+// upgrade install new dependencies, upgrading the packages it would otherwise
+// keep back. It has no effect on dist-upgrade and full-upgrade, which always
+// install new dependencies.
+func AptGetUpgradeWithNewPkgs(withNewPkgs bool) AptGetUpgradeOption {
+	return func(args *aptGetUpgradeOpts) {
+		args.withNewPkgs = withNewPkgs
+	}
+}
+
 func dpkgRepair(ctx context.Context, out []byte) bool {
 	// Error code 100 may occur for non repairable errors, just check the output.
 	if !bytes.Contains(out, dpkgErr) {
@@ -134,7 +157,11 @@ func runAptGet(ctx context.Context, args []string, cmdModifiers []cmdModifier) (
 		modifier(cmd)
 	}
 
-	return runner.Run(ctx, cmd)
+	// Simulations don't change the system and run with every inventory.
+	if !slices.Contains(args, "--just-print") {
+		logArgv(ctx, cmd)
+	}
+	return envFrom(ctx).Runner.Run(ctx, cmd)
 }
 
 func runAptGetWithDowngradeRetrial(ctx context.Context, args []string, cmdModifiers []cmdModifier) ([]byte, []byte, error) {
@@ -219,7 +246,10 @@ func DebPkgInfo(ctx context.Context, path string) (*PkgInfo, error) {
 
 // InstallAptPackages installs apt packages.
 func InstallAptPackages(ctx context.Context, pkgs []string) error {
-	args := append(aptGetInstallArgs, pkgs...)
+	args, err := pkgArgs(aptGetInstallArgs, pkgs)
+	if err != nil {
+		return err
+	}
 	cmdModifiers := []cmdModifier{
 		func(cmd *exec.Cmd) {
 			cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
@@ -234,12 +264,41 @@ func InstallAptPackages(ctx context.Context, pkgs []string) error {
 	if err != nil {
 		err = fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", aptGet, args, err, stdout, stderr)
 	}
+	journal.Record(ctx, journal.PackageInstall, strings.Join(pkgs, " "), err)
+	return err
+}
+
+// DowngradeAptPackages installs the versions of apt packages in pkgs, as
+// name=version, downgrading installed packages.
+func DowngradeAptPackages(ctx context.Context, pkgs []string) error {
+	args, err := pkgArgs(append(append([]string{}, aptGetInstallArgs...), allowDowngradesArg), pkgs)
+	if err != nil {
+		return err
+	}
+	cmdModifiers := []cmdModifier{
+		func(cmd *exec.Cmd) {
+			cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+		},
+	}
+	stdout, stderr, err := runAptGet(ctx, args, cmdModifiers)
+	if err != nil {
+		if dpkgRepair(ctx, stderr) {
+			stdout, stderr, err = runAptGet(ctx, args, cmdModifiers)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", aptGet, args, err, stdout, stderr)
+	}
+	journal.Record(ctx, journal.PackageInstall, strings.Join(pkgs, " "), err)
 	return err
 }
 
 // RemoveAptPackages removes apt packages.
 func RemoveAptPackages(ctx context.Context, pkgs []string) error {
-	args := append(aptGetRemoveArgs, pkgs...)
+	args, err := pkgArgs(aptGetRemoveArgs, pkgs)
+	if err != nil {
+		return err
+	}
 	cmdModifiers := []cmdModifier{
 		func(cmd *exec.Cmd) {
 			cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
@@ -254,6 +313,7 @@ func RemoveAptPackages(ctx context.Context, pkgs []string) error {
 	if err != nil {
 		err = fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", aptGet, args, err, stdout, stderr)
 	}
+	journal.Record(ctx, journal.PackageRemove, strings.Join(pkgs, " "), err)
 	return err
 }
 
@@ -317,6 +377,9 @@ func AptUpdates(ctx context.Context, opts ...AptGetUpgradeOption) ([]*PkgInfo, e
 	switch aptOpts.upgradeType {
 	case AptGetUpgrade:
 		args = append(aptGetUpgradableArgs, aptGetUpgradeCmd)
+		if aptOpts.withNewPkgs {
+			args = append(args, aptGetWithNewPkgsArg)
+		}
 	case AptGetDistUpgrade:
 		args = append(aptGetUpgradableArgs, aptGetDistUpgradeCmd)
 	case AptGetFullUpgrade:
@@ -341,6 +404,69 @@ func AptUpdates(ctx context.Context, opts ...AptGetUpgradeOption) ([]*PkgInfo, e
 	return parseAptUpdates(ctx, out, aptOpts.showNew), nil
 }
 
+// parseAptAutoremove parses the packages apt-get --just-print autoremove
+// would remove.
+func parseAptAutoremove(data []byte) []*PkgInfo {
+	/*
+		Remv linux-image-5.10.0-20-amd64 [5.10.158-2]
+		Remv libfoo1 [1.2-3] [libbar2:amd64 ]
+	*/
+	var pkgs []*PkgInfo
+	for _, ln := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		fields := bytes.Fields(ln)
+		if len(fields) < 2 || string(fields[0]) != "Remv" {
+			continue
+		}
+		pkg := &PkgInfo{Name: string(fields[1])}
+		if len(fields) > 2 && bytes.HasPrefix(fields[2], []byte("[")) {
+			pkg.Version = string(bytes.Trim(fields[2], "[]"))
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs
+}
+
+// AptAutoremovable returns the automatically installed packages that are no
+// longer needed, the packages apt-get autoremove removes.
+func AptAutoremovable(ctx context.Context) ([]*PkgInfo, error) {
+	out, _, err := runAptGet(ctx, append(slices.Clone(aptGetUpgradableArgs), "autoremove"), []cmdModifier{
+		func(cmd *exec.Cmd) {
+			cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseAptAutoremove(out), nil
+}
+
+// AptAutoremove runs apt-get autoremove.
+func AptAutoremove(ctx context.Context) error {
+	cmdModifiers := []cmdModifier{
+		func(cmd *exec.Cmd) {
+			cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+		},
+	}
+	stdout, stderr, err := runAptGet(ctx, aptGetAutoremove, cmdModifiers)
+	if err != nil {
+		if dpkgRepair(ctx, stderr) {
+			stdout, stderr, err = runAptGet(ctx, aptGetAutoremove, cmdModifiers)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", aptGet, aptGetAutoremove, err, stdout, stderr)
+	}
+	journal.Record(ctx, journal.PackageRemove, "autoremove", err)
+	return err
+}
+
+// AptMarkAuto marks packages as automatically installed, so apt-get
+// autoremove removes them once nothing depends on them.
+func AptMarkAuto(ctx context.Context, pkgs []string) error {
+	_, err := run(ctx, aptMark, append(slices.Clone(aptMarkAutoArgs), pkgs...))
+	return err
+}
+
 // AptUpdate runs apt-get update.
 func AptUpdate(ctx context.Context) ([]byte, error) {
 	stdout, _, err := runAptGet(ctx, aptGetUpdateArgs, []cmdModifier{
@@ -353,12 +479,78 @@ func AptUpdate(ctx context.Context) ([]byte, error) {
 
 // InstalledDebPackages queries for all installed deb packages.
 func InstalledDebPackages(ctx context.Context) ([]*PkgInfo, error) {
-	out, err := run(ctx, dpkgQuery, dpkgQueryArgs)
-	if err != nil {
+	var result []*PkgInfo
+	if err := runLines(ctx, dpkgQuery, dpkgQueryArgs, func(line []byte) {
+		if pkg := parseDebPackageLine(ctx, line); pkg != nil {
+			result = append(result, pkg)
+		}
+	}); err != nil {
 		return nil, err
 	}
+	return result, nil
+}
 
-	return parseInstalledDebPackages(ctx, out), nil
+func parseAptCachePolicy(data []byte) map[string]string {
+	/*
+		git:
+		  Installed: 1:2.39.2-1.1
+		  Candidate: 1:2.39.5-0+deb12u1
+		  Version table:
+		     1:2.39.5-0+deb12u1 500
+		        500 http://deb.debian.org/debian-security bookworm-security/main amd64 Packages
+		 *** 1:2.39.2-1.1 500
+		        500 http://deb.debian.org/debian bookworm/main amd64 Packages
+		        100 /var/lib/dpkg/status
+		libc6:i386:
+		  Installed: 2.36-9+deb12u7
+		...
+	*/
+	repos := map[string]string{}
+	var name string
+	var installed bool
+	for _, ln := range bytes.Split(data, []byte("\n")) {
+		if len(ln) == 0 {
+			continue
+		}
+		if ln[0] != ' ' {
+			// A package, foreign architecture packages are suffixed with it.
+			name, _, _ = strings.Cut(strings.TrimSuffix(string(ln), ":"), ":")
+			installed = false
+			continue
+		}
+		fields := strings.Fields(string(ln))
+		indent := len(ln) - len(bytes.TrimLeft(ln, " "))
+		switch {
+		case len(fields) == 0 || strings.HasSuffix(fields[0], ":"):
+			// Installed, Candidate, Version table.
+		case indent < 8:
+			// A version, the installed one is marked.
+			installed = fields[0] == "***"
+		case installed && len(fields) >= 3 && repos[name] == "":
+			// The first source of the installed version, the dpkg status
+			// file only lists it as installed.
+			repos[name] = fields[1] + " " + fields[2]
+		}
+	}
+	return repos
+}
+
+// aptInstalledRepositories returns the apt source each of pkgs is installed
+// from, keyed by name, packages not available from any source are left out.
+func aptInstalledRepositories(ctx context.Context, pkgs []*PkgInfo) (map[string]string, error) {
+	seen := map[string]bool{}
+	args := append([]string{}, aptCachePolicy...)
+	for _, pkg := range pkgs {
+		if !seen[pkg.Name] {
+			seen[pkg.Name] = true
+			args = append(args, pkg.Name)
+		}
+	}
+	out, err := run(ctx, aptCache, args)
+	if err != nil {
+		return nil, err
+	}
+	return parseAptCachePolicy(out), nil
 }
 
 func parseInstalledDebPackages(ctx context.Context, data []byte) []*PkgInfo {
@@ -374,25 +566,31 @@ func parseInstalledDebPackages(ctx context.Context, data []byte) []*PkgInfo {
 
 	var result []*PkgInfo
 	for _, entry := range entries {
-		var dpkg packageMetadata
-		if err := json.Unmarshal(entry, &dpkg); err != nil {
-			clog.Debugf(ctx, "unable to parse dpkg package info, err %s, raw - %s", err, string(entry))
-			continue
-		}
-
-		pkg := pkgInfoFromPackageMetadata(dpkg)
-		if dpkg.Status != "installed" {
-			continue
+		if pkg := parseDebPackageLine(ctx, entry); pkg != nil {
+			result = append(result, pkg)
 		}
-
-		result = append(result, pkg)
 	}
 
 	return result
 }
 
+// parseDebPackageLine parses a single line of dpkg-query output, it returns
+// nil for unparsable lines and packages that are not installed.
+func parseDebPackageLine(ctx context.Context, line []byte) *PkgInfo {
+	var dpkg packageMetadata
+	if err := json.Unmarshal(line, &dpkg); err != nil {
+		clog.Debugf(ctx, "unable to parse dpkg package info, err %s, raw - %s", err, string(line))
+		return nil
+	}
+	if dpkg.Status != "installed" {
+		return nil
+	}
+	return pkgInfoFromPackageMetadata(dpkg)
+}
+
 // DpkgInstall installs a deb package.
 func DpkgInstall(ctx context.Context, path string) error {
 	_, err := run(ctx, dpkg, append(dpkgInstallArgs, path))
+	journal.Record(ctx, journal.PackageInstall, path, err)
 	return err
 }
@@ -21,6 +21,7 @@ import (
 	"slices"
 	"testing"
 
+	"github.com/GoogleCloudPlatform/osconfig/util"
 	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
 	"github.com/golang/mock/gomock"
 )
@@ -368,6 +369,51 @@ func TestAptUpdates(t *testing.T) {
 			},
 			expectedError: nil,
 		},
+		{
+			name: "upgrade with new packages",
+			args: []AptGetUpgradeOption{AptGetUpgradeWithNewPkgs(true), AptGetUpgradeShowNew(true)},
+			expectedCommandsChain: []expectedCommand{
+				{
+					cmd:    exec.Command(aptGet, aptGetUpdateArgs...),
+					envs:   []string{"DEBIAN_FRONTEND=noninteractive"},
+					stdout: []byte("stdout"),
+					stderr: []byte(""),
+					err:    nil,
+				},
+				{
+					cmd:    exec.Command(aptGet, append(slices.Clone(aptGetUpgradableArgs), aptGetUpgradeCmd, aptGetWithNewPkgsArg)...),
+					envs:   []string{"DEBIAN_FRONTEND=noninteractive"},
+					stdout: []byte("Inst linux-image-amd64 [6.1.0-1] (6.1.0-2 Debian:12/stable [amd64])\nInst linux-image-6.1.0-2-amd64 (6.1.0-2 Debian:12/stable [amd64])"),
+					stderr: []byte(""),
+					err:    nil,
+				},
+			},
+			expectedResults: []*PkgInfo{
+				{Name: "linux-image-amd64", Arch: "x86_64", Version: "6.1.0-2"},
+				{Name: "linux-image-6.1.0-2-amd64", Arch: "x86_64", Version: "6.1.0-2"},
+			},
+		},
+		{
+			name: "with new packages is ignored for dist-upgrade",
+			args: []AptGetUpgradeOption{AptGetUpgradeType(AptGetDistUpgrade), AptGetUpgradeWithNewPkgs(true)},
+			expectedCommandsChain: []expectedCommand{
+				{
+					cmd:    exec.Command(aptGet, aptGetUpdateArgs...),
+					envs:   []string{"DEBIAN_FRONTEND=noninteractive"},
+					stdout: []byte("stdout"),
+					stderr: []byte(""),
+					err:    nil,
+				},
+				{
+					cmd:    exec.Command(aptGet, append(slices.Clone(aptGetUpgradableArgs), aptGetDistUpgradeCmd)...),
+					envs:   []string{"DEBIAN_FRONTEND=noninteractive"},
+					stdout: []byte(""),
+					stderr: []byte(""),
+					err:    nil,
+				},
+			},
+			expectedResults: nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -588,12 +634,58 @@ func TestParseInstalledDebpackages(t *testing.T) {
 	}
 }
 
+func TestParseAptCachePolicy(t *testing.T) {
+	data := []byte(`git:
+  Installed: 1:2.39.2-1.1
+  Candidate: 1:2.39.5-0+deb12u1
+  Version table:
+     1:2.39.5-0+deb12u1 500
+        500 http://deb.debian.org/debian-security bookworm-security/main amd64 Packages
+ *** 1:2.39.2-1.1 500
+        500 http://deb.debian.org/debian bookworm/main amd64 Packages
+        100 /var/lib/dpkg/status
+libc6:i386:
+  Installed: 2.36-9+deb12u7
+  Candidate: 2.36-9+deb12u7
+  Version table:
+ *** 2.36-9+deb12u7 500
+        500 http://deb.debian.org/debian bookworm/main i386 Packages
+        100 /var/lib/dpkg/status
+google-cloud-cli:
+  Installed: 460.0.0-0
+  Candidate: 460.0.0-0
+  Version table:
+ *** 460.0.0-0 100
+        100 /var/lib/dpkg/status
+`)
+	want := map[string]string{
+		"git":   "http://deb.debian.org/debian bookworm/main",
+		"libc6": "http://deb.debian.org/debian bookworm/main",
+	}
+	if got := parseAptCachePolicy(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAptCachePolicy() = %v, want %v", got, want)
+	}
+}
+
 func TestParseAptUpdates(t *testing.T) {
 	normalCase := `
 Inst libldap-common [2.4.45+dfsg-1ubuntu1.2] (2.4.45+dfsg-1ubuntu1.3 Ubuntu:18.04/bionic-updates, Ubuntu:18.04/bionic-security [all])
 Inst google-cloud-sdk [245.0.0-0] (246.0.0-0 cloud-sdk-stretch:cloud-sdk-stretch [amd64]) []
 Inst firmware-linux-free (3.4 Debian:9.9/stable [all])
 Conf firmware-linux-free (3.4 Debian:9.9/stable [all])
+`
+
+	// The simulation lines are not translated, only the progress and
+	// summary lines around them.
+	localized := `Paketlisten werden gelesen…
+Abhängigkeitsbaum wird aufgebaut…
+Statusinformationen werden eingelesen…
+Paketaktualisierung (Upgrade) wird berechnet…
+Die folgenden Pakete werden aktualisiert (Upgrade):
+  libldap-common
+1 aktualisiert, 0 neu installiert, 0 zu entfernen und 0 nicht aktualisiert.
+Inst libldap-common [2.4.45+dfsg-1ubuntu1.2] (2.4.45+dfsg-1ubuntu1.3 Ubuntu:18.04/bionic-updates, Ubuntu:18.04/bionic-security [all])
+Conf libldap-common (2.4.45+dfsg-1ubuntu1.3 Ubuntu:18.04/bionic-updates, Ubuntu:18.04/bionic-security [all])
 `
 
 	tests := []struct {
@@ -621,6 +713,14 @@ Conf firmware-linux-free (3.4 Debian:9.9/stable [all])
 				{Name: "firmware-linux-free", Arch: "all", Version: "3.4"},
 			},
 		},
+		{
+			name:    "Localized output",
+			input:   []byte(localized),
+			showNew: true,
+			want: []*PkgInfo{
+				{Name: "libldap-common", Arch: "all", Version: "2.4.45+dfsg-1ubuntu1.3"},
+			},
+		},
 		{
 			name:    "No lines formatted as a package info",
 			input:   []byte("nothing here"),
@@ -651,6 +751,49 @@ Conf firmware-linux-free (3.4 Debian:9.9/stable [all])
 	}
 }
 
+func TestParseAptAutoremove(t *testing.T) {
+	data := []byte("Reading package lists...\nRemv linux-image-5.10.0-20-amd64 [5.10.158-2]\nRemv libfoo1 [1.2-3] [libbar2:amd64 ]\nRemv broken\n")
+	want := []*PkgInfo{
+		{Name: "linux-image-5.10.0-20-amd64", Version: "5.10.158-2"},
+		{Name: "libfoo1", Version: "1.2-3"},
+		{Name: "broken"},
+	}
+	if got := parseAptAutoremove(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAptAutoremove() = %v, want %v", got, want)
+	}
+}
+
+func TestAptAutoremove(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	setExpectations(mockCommandRunner, []expectedCommand{
+		{
+			cmd:    exec.Command(aptGet, aptGetAutoremove...),
+			envs:   []string{"DEBIAN_FRONTEND=noninteractive"},
+			stdout: []byte("stdout"),
+			stderr: dpkgErr,
+			err:    errors.New("error"),
+		},
+		{
+			cmd:    exec.CommandContext(testCtx, dpkg, dpkgRepairArgs...),
+			stdout: []byte("stdout"),
+			stderr: []byte("stderr"),
+		},
+		{
+			cmd:    exec.Command(aptGet, aptGetAutoremove...),
+			envs:   []string{"DEBIAN_FRONTEND=noninteractive"},
+			stdout: []byte("stdout"),
+			stderr: []byte("stderr"),
+		},
+	})
+	if err := AptAutoremove(testCtx); err != nil {
+		t.Errorf("AptAutoremove: unexpected error %v", err)
+	}
+}
+
 func TestDebPkgInfo(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -767,3 +910,33 @@ func TestDpkgInstall(t *testing.T) {
 		t.Errorf("DpkgInstall: got unexpected error %q", err)
 	}
 }
+
+// dpkgQueryOutput returns dpkg-query output listing n installed packages.
+func dpkgQueryOutput(n int) []byte {
+	var out []byte
+	for i := 0; i < n; i++ {
+		out = fmt.Appendf(out, `{"architecture":"amd64","install_time":"1700000000","package":"package-%d","source_name":"source-%d","source_version":"1.%d-1","status":"installed","version":"1.%d-1"}`+"\n", i, i, i, i)
+	}
+	return out
+}
+
+func BenchmarkParseInstalledDebPackages(b *testing.B) {
+	out := dpkgQueryOutput(50000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseInstalledDebPackages(testCtx, out)
+	}
+}
+
+func BenchmarkInstalledDebPackages(b *testing.B) {
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = &streamingRunner{stdout: dpkgQueryOutput(50000), chunkSize: 32 * 1024}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := InstalledDebPackages(testCtx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+var (
+	// pkgNameRe matches package and patch names, with an optional version,
+	// architecture or apt pkg/release pin. It excludes shell metacharacters,
+	// whitespace, paths and a leading dash that could be taken for an option.
+	pkgNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+_:~@=-]*(/[A-Za-z0-9][A-Za-z0-9.+_~-]*)?$`)
+
+	// repoIDRe matches yum and zypper repository IDs, which are written as
+	// the section name of a repo file.
+	repoIDRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
+)
+
+// ValidatePackageName checks name, which comes from a policy or task, can be
+// passed to a package manager as a package or patch name.
+func ValidatePackageName(name string) error {
+	if !pkgNameRe.MatchString(name) {
+		return fmt.Errorf("invalid package name %q", name)
+	}
+	return nil
+}
+
+// ValidatePackageFile checks path, a local package file from a policy, is an
+// absolute and clean path to a regular file with one of exts, like ".deb".
+func ValidatePackageFile(path string, exts ...string) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return fmt.Errorf("invalid package file %q, must be absolute and clean", path)
+	}
+	var ok bool
+	for _, ext := range exts {
+		ok = ok || filepath.Ext(path) == ext
+	}
+	if !ok {
+		return fmt.Errorf("invalid package file %q, must be one of %q", path, exts)
+	}
+	if fi, err := os.Lstat(path); err != nil || !fi.Mode().IsRegular() {
+		return fmt.Errorf("invalid package file %q: not a regular file", path)
+	}
+	return nil
+}
+
+// ValidateRepoID checks id, which comes from a policy, can be used as a
+// repository ID.
+func ValidateRepoID(id string) error {
+	if !repoIDRe.MatchString(id) {
+		return fmt.Errorf("invalid repository id %q", id)
+	}
+	return nil
+}
+
+// pkgArgs returns the args of a package manager command, the fixed args
+// followed by pkgs once they are validated with ValidatePackageName, or
+// ValidatePackageFile for the absolute paths of local .deb and .rpm files.
+// A new slice is returned so the fixed args are never appended to in place.
+func pkgArgs(args []string, pkgs []string) ([]string, error) {
+	argv := make([]string, 0, len(args)+len(pkgs))
+	argv = append(argv, args...)
+	for _, p := range pkgs {
+		validate := ValidatePackageName
+		if filepath.IsAbs(p) {
+			validate = func(p string) error { return ValidatePackageFile(p, ".deb", ".rpm") }
+		}
+		if err := validate(p); err != nil {
+			return nil, err
+		}
+		argv = append(argv, p)
+	}
+	return argv, nil
+}
+
+// logArgv logs the exact argv of a package manager command that changes the
+// system, right before it is run.
+func logArgv(ctx context.Context, cmd *exec.Cmd) {
+	clog.Infof(ctx, "Running package manager command %q", append([]string{cmd.Path}, cmd.Args[1:]...))
+}
+
+// runChange is run for package manager commands that change the system, like
+// an install or remove, their argv is always logged.
+func runChange(ctx context.Context, cmd string, args []string) ([]byte, error) {
+	logArgv(ctx, exec.CommandContext(ctx, cmd, args...))
+	return run(ctx, cmd, args)
+}
@@ -0,0 +1,114 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidatePackageName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"vim", false},
+		{"libstdc++6", false},
+		{"google-osconfig-agent=20240101.00-g1", false},
+		{"kernel-5.14.0.x86_64", false},
+		{"openssl:amd64", false},
+		{"SUSE-SLE-Module-Basesystem-15-SP5-2024-1", false},
+		{"nginx/bookworm-backports", false},
+		{"nginx/", true},
+		{"nginx/../etc", true},
+		{"nginx/a/b", true},
+		{"", true},
+		{"-o", true},
+		{"--allow-unauthenticated", true},
+		{"vim; rm -rf /", true},
+		{"vim $(id)", true},
+		{"vim`id`", true},
+		{"vim|cat", true},
+		{"vim\nrm", true},
+		{"./local.rpm", true},
+		{"/tmp/local.rpm", true},
+		{"vim*", true},
+	}
+	for _, tt := range tests {
+		if err := ValidatePackageName(tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("ValidatePackageName(%q) = %v, want error %t", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateRepoID(t *testing.T) {
+	tests := []struct {
+		id      string
+		wantErr bool
+	}{
+		{"google-compute-engine", false},
+		{"epel_8.x86_64", false},
+		{"", true},
+		{"repo]\ngpgcheck=0\n[other", true},
+		{"../../etc/passwd", true},
+		{"repo id", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateRepoID(tt.id); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateRepoID(%q) = %v, want error %t", tt.id, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPkgArgs(t *testing.T) {
+	base := make([]string, 2, 10)
+	base[0], base[1] = "install", "-y"
+
+	args, err := pkgArgs(base, []string{"vim"})
+	if err != nil {
+		t.Fatalf("pkgArgs: %v", err)
+	}
+	other, err := pkgArgs(base, []string{"emacs"})
+	if err != nil {
+		t.Fatalf("pkgArgs: %v", err)
+	}
+	// The fixed args have spare capacity, each call must still get its own
+	// slice.
+	if got := args[len(args)-1]; got != "vim" {
+		t.Errorf("args = %q, want it to end with vim, not %q", args, got)
+	}
+	if got := other[len(other)-1]; got != "emacs" {
+		t.Errorf("args = %q, want it to end with emacs, not %q", other, got)
+	}
+
+	if _, err := pkgArgs(base, []string{"vim", "-oAPT::Get::AllowUnauthenticated=true"}); err == nil {
+		t.Error("pkgArgs with an option as a package: want error")
+	}
+
+	dir := t.TempDir()
+	deb := filepath.Join(dir, "pkg.deb")
+	if err := os.WriteFile(deb, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if args, err := pkgArgs(base, []string{deb}); err != nil || args[len(args)-1] != deb {
+		t.Errorf("pkgArgs with a local deb: got %q, %v, want it to end with %q", args, err, deb)
+	}
+	for _, p := range []string{filepath.Join(dir, "missing.deb"), dir + "/../" + filepath.Base(dir) + "/pkg.deb", filepath.Join(dir, "pkg.sh"), dir} {
+		if _, err := pkgArgs(base, []string{p}); err == nil {
+			t.Errorf("pkgArgs(%q): want error", p)
+		}
+	}
+}
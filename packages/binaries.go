@@ -0,0 +1,141 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"debug/buildinfo"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+)
+
+// maxBinaryScanFiles bounds the files checked per directory, so a path
+// pointing at a large directory does not stall inventory.
+const maxBinaryScanFiles = 10000
+
+// cargoInstalls is the part of the .crates2.json file in a cargo install root
+// that is used, keyed by "<name> <version> (<source>)".
+type cargoInstalls struct {
+	Installs map[string]struct {
+		Bins   []string `json:"bins"`
+		Target string   `json:"target"`
+	} `json:"installs"`
+}
+
+// InstalledBinaries scans dirs for Go binaries and cargo install roots, like
+// /home/*/go/bin and /home/*/.cargo. Dirs may be glob patterns. Only the
+// files directly in a directory are checked, subdirectories are not.
+func InstalledBinaries(ctx context.Context, dirs []string) (gobins, cargo []*PkgInfo) {
+	seen := map[string]bool{}
+	for _, pattern := range dirs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			clog.Errorf(ctx, "Invalid binary inventory path %q: %v", pattern, err)
+			continue
+		}
+		for _, dir := range matches {
+			if seen[dir] {
+				continue
+			}
+			seen[dir] = true
+			if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+				continue
+			}
+
+			crates, err := cargoInstalledCrates(filepath.Join(dir, ".crates2.json"))
+			if err != nil {
+				clog.Debugf(ctx, "Error reading cargo installs in %q: %v", dir, err)
+			}
+			cargo = append(cargo, crates...)
+			gobins = append(gobins, goBinaries(ctx, dir)...)
+		}
+	}
+	return gobins, cargo
+}
+
+// goBinaries returns the main module of each Go binary in dir.
+func goBinaries(ctx context.Context, dir string) []*PkgInfo {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		clog.Debugf(ctx, "Error reading %q: %v", dir, err)
+		return nil
+	}
+	if len(entries) > maxBinaryScanFiles {
+		clog.Warningf(ctx, "Only checking the first %d of %d files in %q for Go binaries.", maxBinaryScanFiles, len(entries), dir)
+		entries = entries[:maxBinaryScanFiles]
+	}
+
+	var pkgs []*PkgInfo
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		info, err := buildinfo.ReadFile(path)
+		if err != nil {
+			// Not a Go binary.
+			continue
+		}
+		name := info.Main.Path
+		if name == "" {
+			name = info.Path
+		}
+		var arch string
+		for _, s := range info.Settings {
+			if s.Key == "GOARCH" {
+				arch = osinfo.Architecture(s.Value)
+			}
+		}
+		pkgs = append(pkgs, &PkgInfo{Name: name, Arch: arch, Version: info.Main.Version, Location: path})
+	}
+	return pkgs
+}
+
+// cargoInstalledCrates returns the crates recorded by cargo install in a
+// .crates2.json file, nothing if it does not exist.
+func cargoInstalledCrates(path string) ([]*PkgInfo, error) {
+	/*
+		{"installs":{"ripgrep 14.1.0 (registry+https://github.com/rust-lang/crates.io-index)":{"version_req":null,"bins":["rg"],"features":[],"all_features":false,"no_default_features":false,"profile":"release","target":"x86_64-unknown-linux-gnu","rustc":"rustc 1.77.2"}}}
+	*/
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var installs cargoInstalls
+	if err := json.Unmarshal(b, &installs); err != nil {
+		return nil, err
+	}
+
+	var pkgs []*PkgInfo
+	for key, install := range installs.Installs {
+		fields := strings.Fields(key)
+		if len(fields) < 2 {
+			continue
+		}
+		arch, _, _ := strings.Cut(install.Target, "-")
+		pkgs = append(pkgs, &PkgInfo{Name: fields[0], Arch: osinfo.Architecture(arch), Version: fields[1], Location: filepath.Dir(path)})
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs, nil
+}
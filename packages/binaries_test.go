@@ -0,0 +1,81 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/google/go-cmp/cmp"
+)
+
+func copyFile(t *testing.T, src, dst string) {
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstalledBinaries(t *testing.T) {
+	home := t.TempDir()
+	gobin := filepath.Join(home, "user", "go", "bin")
+	cargoRoot := filepath.Join(home, "user", ".cargo")
+	for _, d := range []string{gobin, cargoRoot} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The test binary is a Go binary of this module.
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyFile(t, exe, filepath.Join(gobin, "tool"))
+	if err := os.WriteFile(filepath.Join(gobin, "script.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	crates := `{"installs":{"ripgrep 14.1.0 (registry+https://github.com/rust-lang/crates.io-index)":{"bins":["rg"],"target":"x86_64-unknown-linux-gnu"},"bad":{}}}`
+	if err := os.WriteFile(filepath.Join(cargoRoot, ".crates2.json"), []byte(crates), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gobins, cargo := InstalledBinaries(context.Background(), []string{filepath.Join(home, "*", "go", "bin"), filepath.Join(home, "*", ".cargo"), gobin, filepath.Join(home, "missing")})
+
+	wantGo := []*PkgInfo{{Name: "github.com/GoogleCloudPlatform/osconfig", Arch: osinfo.Architecture(runtime.GOARCH), Version: "(devel)", Location: filepath.Join(gobin, "tool")}}
+	if diff := cmp.Diff(wantGo, gobins); diff != "" {
+		t.Errorf("Go binaries did not match expectation: (-want +got)\n%s", diff)
+	}
+	wantCargo := []*PkgInfo{{Name: "ripgrep", Arch: "x86_64", Version: "14.1.0", Location: cargoRoot}}
+	if diff := cmp.Diff(wantCargo, cargo); diff != "" {
+		t.Errorf("cargo crates did not match expectation: (-want +got)\n%s", diff)
+	}
+}
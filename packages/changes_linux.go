@@ -0,0 +1,37 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import "time"
+
+// packageDatabases are written by every dpkg or rpm transaction, the rpm
+// database is in one of several formats and locations depending on the
+// distribution and version.
+var packageDatabases = []string{
+	"/var/lib/dpkg/status",
+	"/var/lib/rpm/Packages",
+	"/var/lib/rpm/Packages.db",
+	"/var/lib/rpm/rpmdb.sqlite",
+	"/usr/lib/sysimage/rpm/Packages",
+	"/usr/lib/sysimage/rpm/Packages.db",
+	"/usr/lib/sysimage/rpm/rpmdb.sqlite",
+}
+
+// LastChange returns when packages were last installed, updated or removed,
+// from the modification time of the dpkg and rpm databases. It is zero if
+// there are none.
+func LastChange() time.Time {
+	return latestModTime(packageDatabases)
+}
@@ -0,0 +1,51 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// changeKeys are registry keys written when Windows updates are installed,
+// Component Based Servicing packages, or applications are installed or
+// removed.
+var changeKeys = []string{
+	`SOFTWARE\Microsoft\Windows\CurrentVersion\Component Based Servicing\Packages`,
+	`SOFTWARE\Microsoft\Windows\CurrentVersion\Uninstall`,
+	`SOFTWARE\Wow6432Node\Microsoft\Windows\CurrentVersion\Uninstall`,
+}
+
+// LastChange returns when packages were last installed, updated or removed,
+// from the last write time of the Windows update and application registry
+// keys and the modification time of the GooGet database.
+func LastChange() time.Time {
+	latest := latestModTime([]string{filepath.Join(os.Getenv("GooGetRoot"), "googet.db")})
+	for _, path := range changeKeys {
+		k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		info, err := k.Stat()
+		k.Close()
+		if err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
@@ -0,0 +1,65 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+// Names of the collectors that are only run when enabled, see Collectors.
+const (
+	// LicensesCollector adds package licenses, see AddLicenses.
+	LicensesCollector = "licenses"
+	// GoCollector and CargoCollector scan for Go binaries and cargo
+	// installs, see InstalledBinaries.
+	GoCollector    = "go"
+	CargoCollector = "cargo"
+	// PortsCollector lists listening sockets and the processes owning them,
+	// see inventory.ListeningPorts.
+	PortsCollector = "ports"
+	// ScheduledJobsCollector lists cron jobs, systemd timers and scheduled
+	// tasks, see inventory.ScheduledJobs.
+	ScheduledJobsCollector = "scheduledjobs"
+)
+
+// RepositoriesCollector checks the configured package repositories, see
+// GetRepositoryHealth. It is one of the DefaultCollectors.
+const RepositoriesCollector = "repositories"
+
+// AntivirusCollector gets the state of Microsoft Defender and other
+// antivirus products, see inventory.Antivirus. It is one of the
+// DefaultCollectors on Windows.
+const AntivirusCollector = "antivirus"
+
+// OptionalCollectors are the collectors that are not run by default.
+var OptionalCollectors = []string{LicensesCollector, GoCollector, CargoCollector, PortsCollector, ScheduledJobsCollector}
+
+// DefaultBinaryPaths are scanned for Go binaries and cargo installs when
+// those collectors are enabled without any paths being configured.
+var DefaultBinaryPaths = []string{"/usr/local/bin", "/root/go/bin", "/home/*/go/bin", "/root/.cargo", "/home/*/.cargo"}
+
+// Collectors is a set of enabled inventory collectors keyed by name, one of
+// DefaultCollectors or OptionalCollectors. A nil Collectors enables all of
+// the DefaultCollectors.
+type Collectors map[string]bool
+
+// Enabled reports whether the named collector is enabled.
+func (c Collectors) Enabled(name string) bool {
+	if c == nil {
+		for _, d := range DefaultCollectors {
+			if d == name {
+				return true
+			}
+		}
+		return false
+	}
+	return c[name]
+}
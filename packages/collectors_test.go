@@ -0,0 +1,36 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import "testing"
+
+func TestCollectorsEnabled(t *testing.T) {
+	var all Collectors
+	for _, name := range DefaultCollectors {
+		if !all.Enabled(name) {
+			t.Errorf("nil Collectors: Enabled(%q) = false, want true", name)
+		}
+	}
+	for _, name := range OptionalCollectors {
+		if all.Enabled(name) {
+			t.Errorf("nil Collectors: Enabled(%q) = true, want false", name)
+		}
+	}
+
+	c := Collectors{GoCollector: true}
+	if !c.Enabled(GoCollector) || c.Enabled(DefaultCollectors[0]) {
+		t.Errorf("Collectors %v: want only %q enabled", c, GoCollector)
+	}
+}
@@ -0,0 +1,119 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// Env is the environment package functions run in: the runners commands are
+// run with, the file system package manager configuration is read from and
+// the package managers found. Functions use the Env of their context, see
+// WithEnv, or DefaultEnv if it has none, so callers and tests can run them
+// against different environments concurrently.
+type Env struct {
+	Runner    util.CommandRunner
+	PtyRunner util.CommandRunner
+	FS        FS
+
+	// The package managers found, see the package level variables of the
+	// same name.
+	AptExists        bool
+	DpkgExists       bool
+	DpkgQueryExists  bool
+	YumExists        bool
+	DnfExists        bool
+	ZypperExists     bool
+	PacmanExists     bool
+	FlatpakExists    bool
+	RPMExists        bool
+	RPMQueryExists   bool
+	COSPkgInfoExists bool
+	GemExists        bool
+	PipExists        bool
+	NpmExists        bool
+	GooGetExists     bool
+	MSIExists        bool
+}
+
+// FS reads the package manager configuration, like repository files and
+// keys.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	Glob(pattern string) ([]string, error)
+}
+
+type osFS struct{}
+
+func (osFS) ReadFile(name string) ([]byte, error)  { return os.ReadFile(name) }
+func (osFS) Glob(pattern string) ([]string, error) { return filepath.Glob(pattern) }
+
+// DefaultEnv returns the environment of the host, the runners set by
+// SetCommandRunner and SetPtyCommandRunner and the package managers detected
+// when the package was initialized.
+func DefaultEnv() *Env {
+	return &Env{
+		Runner:           runner,
+		PtyRunner:        ptyrunner,
+		FS:               osFS{},
+		AptExists:        AptExists,
+		DpkgExists:       DpkgExists,
+		DpkgQueryExists:  DpkgQueryExists,
+		YumExists:        YumExists,
+		DnfExists:        DnfExists,
+		ZypperExists:     ZypperExists,
+		PacmanExists:     PacmanExists,
+		FlatpakExists:    FlatpakExists,
+		RPMExists:        RPMExists,
+		RPMQueryExists:   RPMQueryExists,
+		COSPkgInfoExists: COSPkgInfoExists,
+		GemExists:        GemExists,
+		PipExists:        PipExists,
+		NpmExists:        NpmExists,
+		GooGetExists:     GooGetExists,
+		MSIExists:        MSIExists,
+	}
+}
+
+type envKey struct{}
+
+// WithEnv returns a copy of ctx package functions run in env with. Unset
+// runners and file system are taken from DefaultEnv.
+func WithEnv(ctx context.Context, env *Env) context.Context {
+	e := *env
+	d := DefaultEnv()
+	if e.Runner == nil {
+		e.Runner = d.Runner
+	}
+	if e.PtyRunner == nil {
+		e.PtyRunner = d.PtyRunner
+	}
+	if e.FS == nil {
+		e.FS = d.FS
+	}
+	return context.WithValue(ctx, envKey{}, &e)
+}
+
+// envFrom returns the Env of ctx, DefaultEnv if it has none.
+func envFrom(ctx context.Context) *Env {
+	if e, ok := ctx.Value(envKey{}).(*Env); ok {
+		return e
+	}
+	return DefaultEnv()
+}
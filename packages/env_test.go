@@ -0,0 +1,73 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// cmdRunner returns the output of a command by its base name.
+type cmdRunner map[string]string
+
+func (r cmdRunner) Run(_ context.Context, cmd *exec.Cmd) ([]byte, []byte, error) {
+	return []byte(r[filepath.Base(cmd.Path)]), nil, nil
+}
+
+func TestWithEnv(t *testing.T) {
+	const path = "/usr/bin/google_osconfig_agent"
+	tests := []struct {
+		desc    string
+		env     *Env
+		want    bool
+		wantErr bool
+	}{
+		{"dpkg modified", &Env{DpkgQueryExists: true, Runner: cmdRunner{"dpkg": "??5??????   " + path}}, true, false},
+		{"rpm unmodified", &Env{RPMQueryExists: true, Runner: cmdRunner{}}, false, false},
+		{"no package manager", &Env{Runner: cmdRunner{"dpkg": "??5??????   " + path}}, false, true},
+	}
+
+	// Each environment is independent of the package level state and of
+	// the others.
+	var wg sync.WaitGroup
+	for _, tt := range tests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := WithEnv(context.Background(), tt.env)
+			for i := 0; i < 10; i++ {
+				got, err := PackageFileModified(ctx, &PkgInfo{Name: "google-osconfig-agent"}, path)
+				if (err != nil) != tt.wantErr || got != tt.want {
+					t.Errorf("%s: PackageFileModified() = %t, %v, want %t, error %t", tt.desc, got, err, tt.want, tt.wantErr)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWithEnvDefaults(t *testing.T) {
+	env := envFrom(WithEnv(context.Background(), &Env{AptExists: true}))
+	if env.Runner == nil || env.PtyRunner == nil || env.FS == nil {
+		t.Errorf("WithEnv did not default the runners and file system: %+v", env)
+	}
+	if !env.AptExists || env.YumExists {
+		t.Errorf("WithEnv changed the package managers found: %+v", env)
+	}
+}
@@ -0,0 +1,84 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	flatpak string
+
+	// Without a terminal flatpak prints the columns tab separated and
+	// without a header.
+	flatpakColumns      = "--columns=application,arch,branch,version,origin"
+	flatpakListArgs     = []string{"list", "--app", flatpakColumns}
+	flatpakRemoteLsArgs = []string{"remote-ls", "--updates", "--app", flatpakColumns}
+)
+
+func init() {
+	if runtime.GOOS != "windows" {
+		flatpak = "/usr/bin/flatpak"
+	}
+	FlatpakExists = util.Exists(flatpak)
+}
+
+func parseFlatpakApps(data []byte) []*PkgInfo {
+	/*
+		org.mozilla.firefox	x86_64	stable	125.0.1	flathub
+		org.gimp.GIMP	x86_64	stable	2.10.36	flathub
+		com.example.Nightly	x86_64	master		example
+	*/
+	var pkgs []*PkgInfo
+	for _, ln := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		flds := bytes.Split(ln, []byte("\t"))
+		if len(flds) != 5 {
+			continue
+		}
+		name, arch, branch, version, origin := string(flds[0]), string(flds[1]), string(flds[2]), string(flds[3]), string(flds[4])
+		if name == "" {
+			continue
+		}
+		// Not every app sets a version, the branch is what is installed.
+		if version == "" {
+			version = branch
+		}
+		pkgs = append(pkgs, &PkgInfo{Name: name, Arch: osinfo.Architecture(arch), RawArch: arch, Version: version, Repository: origin})
+	}
+	return pkgs
+}
+
+// InstalledFlatpakApps queries for all installed flatpak applications.
+func InstalledFlatpakApps(ctx context.Context) ([]*PkgInfo, error) {
+	out, err := run(ctx, flatpak, flatpakListArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseFlatpakApps(out), nil
+}
+
+// FlatpakUpdates queries for all available flatpak application updates.
+func FlatpakUpdates(ctx context.Context) ([]*PkgInfo, error) {
+	out, err := run(ctx, flatpak, flatpakRemoteLsArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseFlatpakApps(out), nil
+}
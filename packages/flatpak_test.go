@@ -0,0 +1,59 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseFlatpakApps(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []*PkgInfo
+	}{
+		{
+			"NormalCase",
+			"org.mozilla.firefox\tx86_64\tstable\t125.0.1\tflathub\ncom.example.Nightly\taarch64\tmaster\t\texample\n",
+			[]*PkgInfo{
+				{Name: "org.mozilla.firefox", Arch: "x86_64", RawArch: "x86_64", Version: "125.0.1", Repository: "flathub"},
+				{Name: "com.example.Nightly", Arch: "aarch64", RawArch: "aarch64", Version: "master", Repository: "example"},
+			},
+		},
+		{"NoApps", "", nil},
+		{"Junk", "Looking for updates…\nnot\ttab\tseparated\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseFlatpakApps([]byte(tt.data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFlatpakApps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlatpakUpdates(t *testing.T) {
+	ctx := WithEnv(context.Background(), &Env{FlatpakExists: true, Runner: cmdRunner{"flatpak": "org.gimp.GIMP\tx86_64\tstable\t2.10.38\tflathub\n"}})
+	got, err := FlatpakUpdates(ctx)
+	if err != nil {
+		t.Fatalf("FlatpakUpdates: %v", err)
+	}
+	want := []*PkgInfo{{Name: "org.gimp.GIMP", Arch: "x86_64", RawArch: "x86_64", Version: "2.10.38", Repository: "flathub"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FlatpakUpdates() = %v, want %v", got, want)
+	}
+}
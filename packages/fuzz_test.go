@@ -0,0 +1,156 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The fuzzers only check the parsers do not panic on malformed output, run
+// one with go test ./packages -run '^$' -fuzz FuzzParseAptUpdates.
+
+// addSeeds adds the examples and the testdata snapshots of the parser as
+// seed inputs.
+func addSeeds(f *testing.F, snapshot string, examples ...string) {
+	for _, e := range examples {
+		f.Add([]byte(e))
+	}
+	paths, err := filepath.Glob(filepath.Join("testdata", snapshot, "*.stdout"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+}
+
+func FuzzParseAptUpdates(f *testing.F) {
+	for _, e := range []string{
+		"Inst libldap-common [2.4.45+dfsg-1ubuntu1.2] (2.4.45+dfsg-1ubuntu1.3 Ubuntu:18.04/bionic-updates, Ubuntu:18.04/bionic-security [all])\n",
+		"Inst firmware-linux-free (3.4 Debian:9.9/stable [all]) []\nConf firmware-linux-free (3.4 Debian:9.9/stable [all])\n",
+		"Inst a [ (",
+	} {
+		f.Add([]byte(e), true)
+		f.Add([]byte(e), false)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, showNew bool) {
+		parseAptUpdates(context.Background(), data, showNew)
+	})
+}
+
+func FuzzParseInstalledDebPackages(f *testing.F) {
+	addSeeds(f, "dpkg-query",
+		`{"package":"adduser","architecture":"all","version":"3.118ubuntu2","status":"installed","source_name":"adduser","source_version":"3.118ubuntu2","install_time":"1700000000"}`,
+		`{"package":"git","architecture":"amd64","version":"1:2.25.1","status":"installed","install_time":"x"}`,
+	)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseInstalledDebPackages(context.Background(), data)
+	})
+}
+
+func FuzzParseDpkgDeb(f *testing.F) {
+	addSeeds(f, "",
+		"new Debian package, version 2.0.\nPackage: google-guest-agent\nVersion: 1:1dummy-g1\nArchitecture: amd64\n",
+	)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseDpkgDeb(data)
+	})
+}
+
+func FuzzParseInstalledRPMPackages(f *testing.F) {
+	addSeeds(f, "rpmquery",
+		`{"architecture":"x86_64","package":"gcc","source_name":"gcc-11.4.1-3.el9.src.rpm","version":"11.4.1-3.el9","install_time":"1700000000"}`,
+		`{"architecture":"noarch","package":"golang-src","source_name":"(none)","version":"1.22.3-1.el9"}`,
+	)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseInstalledRPMPackages(context.Background(), data)
+	})
+}
+
+func FuzzParseYumUpdates(f *testing.F) {
+	addSeeds(f, "yum-update",
+		"Dependencies resolved.\n=====\n Package Arch Version Repository Size\n=====\nInstalling:\n kernel x86_64 2.6.32-754.24.3.el6 updates 32 M\nUpdating:\n nspr x86_64 4.21.0-1.el6_10 updates 114 k\n\nTransaction Summary\n",
+		"Upgrading:\n foo\n",
+	)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseYumUpdates(data)
+	})
+}
+
+func FuzzParseZypperUpdates(f *testing.F) {
+	addSeeds(f, "zypper-list-updates",
+		`<stream><update-status version="0.6"><update-list><update kind="package" name="at" edition="3.1.14-8.3.1" arch="x86_64"></update></update-list></update-status></stream>`,
+	)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseZypperUpdates(data)
+	})
+}
+
+func FuzzParseZypperPatches(f *testing.F) {
+	addSeeds(f, "zypper-list-patches",
+		`<stream><update-status version="0.6"><update-list><update kind="patch" name="SUSE-2019-1206" status="applied" category="security" severity="low"><summary>Security update for bzip2</summary></update><update kind="patch" name="SUSE-2019-1258" status="needed" category="recommended" severity="moderate"><summary>Recommended update for postfix</summary></update></update-list></update-status></stream>`,
+	)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseZypperPatches(data)
+	})
+}
+
+func FuzzParseZypperPatchInfo(f *testing.F) {
+	addSeeds(f, "",
+		"Information for patch SUSE-2019-2974:\nName        : SUSE-2019-2974\nConflicts   : [2]\n    irqbalance.src < 1.1.0-9.3.1\n    irqbalance.x86_64 < 1.1.0-9.3.1\n",
+		"Name : a\nConflicts : [5]\n",
+	)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseZypperPatchInfo(data)
+	})
+}
+
+func FuzzParseGooGet(f *testing.F) {
+	addSeeds(f, "googet-update",
+		"Searching for available updates...\nfoo.noarch, 3.5.4@1 --> 3.6.7@1 from repo\nPerform update? (y/N):\n",
+		"Installed Packages:\nfoo.x86_64 1.2.3@4\nbar.noarch 1.2.3@4\n",
+	)
+	addSeeds(f, "googet-installed")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseGooGetUpdates(data)
+		parseInstalledGooGetPackages(data)
+	})
+}
+
+func FuzzParseWUAUpdates(f *testing.F) {
+	addSeeds(f, "",
+		`[{"Title":"Update","UpdateID":"a","KBArticleIDs":["123"],"RevisionNumber":1,"LastDeploymentChangeTime":"2019-01-01T00:00:00Z"}]`,
+		`[null, {}]`,
+		`null`,
+	)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkgs, err := parseWUAUpdates(data)
+		if err != nil {
+			return
+		}
+		for _, pkg := range pkgs {
+			if pkg == nil {
+				t.Fatalf("parseWUAUpdates(%q) returned a nil package", data)
+			}
+		}
+	})
+}
@@ -16,92 +16,120 @@ package packages
 
 import (
 	"context"
+	"errors"
+	"regexp"
 	"runtime"
 	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
-	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
 var (
-	gem string
+	// gems are the gem commands of each installed Ruby.
+	gems []string
 
-	gemListArgs        = []string{"list", "--local"}
-	gemOutdatedArgs    = []string{"outdated", "--local"}
+	gemPatterns = []string{
+		"/usr/bin/gem*",
+		"/usr/local/bin/gem*",
+		"/opt/rh/*/root/usr/bin/gem",
+		"/opt/rubies/*/bin/gem",
+		"/usr/local/rbenv/versions/*/bin/gem",
+		"/usr/local/rvm/rubies/*/bin/gem",
+	}
+	gemName = regexp.MustCompile(`^gem([0-9]+(\.[0-9]+)*)?$`)
+
+	gemListArgs        = []string{"list", "--local", "--quiet"}
+	gemOutdatedArgs    = []string{"outdated", "--local", "--quiet"}
 	gemListTimeout     = 15 * time.Second
 	gemOutdatedTimeout = 15 * time.Second
+
+	// Retried without --quiet for versions of RubyGems that reject it.
+	gemLegacyListArgs     = []string{"list", "--local"}
+	gemLegacyOutdatedArgs = []string{"outdated", "--local"}
+
+	// foo (1.2.3, default: 1.2.0)
+	// nokogiri (1.15.4 x86_64-linux)
+	gemListRe = regexp.MustCompile(`^(\S+) \((.+)\)$`)
+	// foo (1.2.8 < 1.3.2)
+	gemOutdatedRe = regexp.MustCompile(`^(\S+) \((\S+) < (\S+)\)$`)
 )
 
 func init() {
 	if runtime.GOOS != "windows" {
-		gem = "/usr/bin/gem"
+		gems = findCommands(append(gemPatterns, pathPatterns("gem*")...), gemName)
 	}
-	GemExists = util.Exists(gem)
+	GemExists = len(gems) > 0
 }
 
-// GemUpdates queries for all available gem updates.
-func GemUpdates(ctx context.Context) ([]*PkgInfo, error) {
-	stdout, err := runWithDeadline(ctx, gemOutdatedTimeout, gem, gemOutdatedArgs)
-	if err != nil {
-		return nil, err
+// gemPackages runs each gem command with args, or legacyArgs if that fails,
+// and parses the output with parse. An error is only returned if every gem
+// command failed.
+func gemPackages(ctx context.Context, timeout time.Duration, args, legacyArgs []string, parse func([]byte) []*PkgInfo) ([]*PkgInfo, error) {
+	var pkgs []*PkgInfo
+	var errs []string
+	for _, gem := range gems {
+		out, err := runWithDeadline(ctx, timeout, gem, args)
+		if err != nil {
+			var legacyErr error
+			if out, legacyErr = runWithDeadline(ctx, timeout, gem, legacyArgs); legacyErr != nil {
+				clog.Debugf(ctx, "Error running %s: %v", gem, err)
+				errs = append(errs, err.Error())
+				continue
+			}
+		}
+		found := parse(out)
+		for _, pkg := range found {
+			pkg.Location = gem
+		}
+		pkgs = append(pkgs, found...)
 	}
-	/*
-	   foo (1.2.8 < 1.3.2)
-	   bar (1.0.0 < 1.1.2)
-	   ...
-	*/
-
-	lines := strings.Split(strings.TrimSpace(string(stdout)), "\n")
-	if len(lines) == 0 {
-		return nil, nil
+	if len(errs) == len(gems) && len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\n"))
 	}
+	return pkgs, nil
+}
 
+func parseGemUpdates(data []byte) []*PkgInfo {
 	var pkgs []*PkgInfo
-	for _, ln := range lines {
-		pkg := strings.Fields(ln)
-		if len(pkg) != 4 {
-			clog.Debugf(ctx, "%q does not represent a gem update\n", ln)
+	for _, ln := range strings.Split(string(data), "\n") {
+		m := gemOutdatedRe.FindStringSubmatch(strings.TrimSpace(ln))
+		if m == nil {
 			continue
 		}
-		ver := strings.Trim(pkg[3], ")")
-		pkgs = append(pkgs, &PkgInfo{Name: pkg[0], Arch: noarch, Version: ver})
+		pkgs = append(pkgs, &PkgInfo{Name: m[1], Arch: noarch, Version: m[3]})
 	}
-	return pkgs, nil
+	return pkgs
 }
 
-// InstalledGemPackages queries for all installed gem packages.
-func InstalledGemPackages(ctx context.Context) ([]*PkgInfo, error) {
-	stdout, err := runWithDeadline(ctx, gemListTimeout, gem, gemListArgs)
-	if err != nil {
-		return nil, err
-	}
-
-	/*
-
-	   *** LOCAL GEMS ***
-
-	   foo (1.2.3, 1.2.4)
-	   bar (1.2.3)
-	   ...
-	*/
-	lines := strings.Split(strings.TrimSpace(string(stdout)), "\n")
-
-	if len(lines) == 0 {
-		clog.Debugf(ctx, "No gems installed.")
-		return nil, nil
-	}
-
+func parseInstalledGemPackages(data []byte) []*PkgInfo {
 	var pkgs []*PkgInfo
-	for _, ln := range lines[2:] {
-		pkg := strings.Fields(ln)
-		if len(pkg) < 2 {
-			clog.Debugf(ctx, "'%s' does not represent a gem", ln)
+	for _, ln := range strings.Split(string(data), "\n") {
+		// The "*** LOCAL GEMS ***" header is only printed to a terminal
+		// or without --quiet, and does not match.
+		m := gemListRe.FindStringSubmatch(strings.TrimSpace(ln))
+		if m == nil {
 			continue
 		}
-		for _, ver := range strings.Split(strings.Trim(pkg[1], "()"), ", ") {
-			pkgs = append(pkgs, &PkgInfo{Name: pkg[0], Arch: noarch, Version: ver})
+		for _, ver := range strings.Split(m[2], ", ") {
+			// Default gems are listed as "default: 1.2.3" and platform
+			// specific ones as "1.2.3 x86_64-linux".
+			ver = strings.TrimPrefix(ver, "default: ")
+			if v, _, _ := strings.Cut(ver, " "); v != "" {
+				pkgs = append(pkgs, &PkgInfo{Name: m[1], Arch: noarch, Version: v})
+			}
 		}
 	}
-	return pkgs, nil
+	return pkgs
+}
+
+// GemUpdates queries for all available gem updates.
+func GemUpdates(ctx context.Context) ([]*PkgInfo, error) {
+	return gemPackages(ctx, gemOutdatedTimeout, gemOutdatedArgs, gemLegacyOutdatedArgs, parseGemUpdates)
+}
+
+// InstalledGemPackages queries for all installed gem packages. The Location
+// of each package is the gem command of the Ruby it is installed for.
+func InstalledGemPackages(ctx context.Context) ([]*PkgInfo, error) {
+	return gemPackages(ctx, gemListTimeout, gemListArgs, gemLegacyListArgs, parseInstalledGemPackages)
 }
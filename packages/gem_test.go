@@ -0,0 +1,97 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestParseInstalledGemPackages(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []*PkgInfo
+	}{
+		{"NoHeader", "bigdecimal (3.1.4, default: 3.1.1)\nrake (13.0.6)\n", []*PkgInfo{
+			{Name: "bigdecimal", Arch: noarch, Version: "3.1.4"},
+			{Name: "bigdecimal", Arch: noarch, Version: "3.1.1"},
+			{Name: "rake", Arch: noarch, Version: "13.0.6"},
+		}},
+		{"Header", "\n*** LOCAL GEMS ***\n\nrake (13.0.6)\n", []*PkgInfo{{Name: "rake", Arch: noarch, Version: "13.0.6"}}},
+		{"Platform", "nokogiri (1.15.4 x86_64-linux, 1.14.0)\n", []*PkgInfo{
+			{Name: "nokogiri", Arch: noarch, Version: "1.15.4"},
+			{Name: "nokogiri", Arch: noarch, Version: "1.14.0"},
+		}},
+		{"NoGems", "", nil},
+		{"Unrecognized", "something we don't understand\nrake (13.0.6)", []*PkgInfo{{Name: "rake", Arch: noarch, Version: "13.0.6"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseInstalledGemPackages([]byte(tt.data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseInstalledGemPackages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGemUpdates(t *testing.T) {
+	data := []byte("foo (1.2.8 < 1.3.2)\nbar (1.0.0 < 1.1.2)\nnot an update\n")
+	want := []*PkgInfo{{Name: "foo", Arch: noarch, Version: "1.3.2"}, {Name: "bar", Arch: noarch, Version: "1.1.2"}}
+	if got := parseGemUpdates(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseGemUpdates() = %v, want %v", got, want)
+	}
+}
+
+func TestInstalledGemPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	defer func(g []string) { gems = g }(gems)
+	gems = []string{"/usr/bin/gem3.0", "/opt/rubies/ruby-2.7/bin/gem", "/opt/rubies/broken/bin/gem"}
+
+	mockCommandRunner.EXPECT().Run(gomock.Any(), utilmocks.EqCmd(exec.Command(gems[0], gemListArgs...))).Return([]byte("rake (13.0.6)\n"), nil, nil)
+	// An old RubyGems falls back to the legacy arguments.
+	mockCommandRunner.EXPECT().Run(gomock.Any(), utilmocks.EqCmd(exec.Command(gems[1], gemListArgs...))).Return(nil, []byte("invalid option: --quiet"), errors.New("exit status 1"))
+	mockCommandRunner.EXPECT().Run(gomock.Any(), utilmocks.EqCmd(exec.Command(gems[1], gemLegacyListArgs...))).Return([]byte("*** LOCAL GEMS ***\n\nrake (12.3.3)\n"), nil, nil)
+	// A broken gem command does not fail the others.
+	mockCommandRunner.EXPECT().Run(gomock.Any(), utilmocks.EqCmd(exec.Command(gems[2], gemListArgs...))).Return(nil, nil, errors.New("exit status 1"))
+	mockCommandRunner.EXPECT().Run(gomock.Any(), utilmocks.EqCmd(exec.Command(gems[2], gemLegacyListArgs...))).Return(nil, nil, errors.New("exit status 1"))
+
+	got, err := InstalledGemPackages(testCtx)
+	if err != nil {
+		t.Fatalf("InstalledGemPackages(): unexpected error: %v", err)
+	}
+	want := []*PkgInfo{
+		{Name: "rake", Arch: noarch, Version: "13.0.6", Location: gems[0]},
+		{Name: "rake", Arch: noarch, Version: "12.3.3", Location: gems[1]},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InstalledGemPackages() = %v, want %v", got, want)
+	}
+
+	gems = gems[2:]
+	mockCommandRunner.EXPECT().Run(gomock.Any(), gomock.Any()).Return(nil, nil, errors.New("exit status 1")).Times(2)
+	if _, err := InstalledGemPackages(testCtx); err == nil {
+		t.Error("InstalledGemPackages(): expected an error when every gem command fails")
+	}
+}
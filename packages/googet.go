@@ -21,6 +21,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/GoogleCloudPlatform/osconfig/journal"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
@@ -76,12 +77,14 @@ func GooGetUpdates(ctx context.Context) ([]*PkgInfo, error) {
 // InstallGooGetPackages installs GooGet packages.
 func InstallGooGetPackages(ctx context.Context, pkgs []string) error {
 	_, err := run(ctx, googet, append(googetInstallArgs, pkgs...))
+	journal.Record(ctx, journal.PackageInstall, strings.Join(pkgs, " "), err)
 	return err
 }
 
 // RemoveGooGetPackages installs GooGet packages.
 func RemoveGooGetPackages(ctx context.Context, pkgs []string) error {
 	_, err := run(ctx, googet, append(googetRemoveArgs, pkgs...))
+	journal.Record(ctx, journal.PackageRemove, strings.Join(pkgs, " "), err)
 	return err
 }
 
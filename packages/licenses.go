@@ -0,0 +1,239 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+)
+
+var (
+	rpmqueryLicenseArgs = []string{"--queryformat", "%{NAME} %{ARCH} %{LICENSE}\n", "-a"}
+	pipInspectArgs      = []string{"inspect", "--local"}
+	gemListDetailsArgs  = []string{"list", "--local", "--details"}
+
+	debDocDir = "/usr/share/doc"
+)
+
+func parseRPMLicenses(data []byte) map[string][]string {
+	/*
+		bash x86_64 GPLv3+
+		tzdata noarch Public Domain
+		gpg-pubkey (none) (none)
+	*/
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+
+	licenses := map[string][]string{}
+	for _, ln := range lines {
+		fields := strings.SplitN(string(ln), " ", 3)
+		if len(fields) != 3 || fields[2] == "(none)" {
+			continue
+		}
+		licenses[fields[0]+"."+osinfo.Architecture(fields[1])] = []string{fields[2]}
+	}
+	return licenses
+}
+
+// parseDebCopyright returns the licenses declared in a machine-readable
+// debian/copyright file, see
+// https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/.
+// Free-form copyright files are not parsed.
+func parseDebCopyright(data []byte) []string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), "Format:") {
+		return nil
+	}
+
+	var licenses []string
+	seen := map[string]bool{}
+	for scanner.Scan() {
+		l, ok := strings.CutPrefix(scanner.Text(), "License:")
+		if !ok {
+			continue
+		}
+		if l = strings.TrimSpace(l); l != "" && !seen[l] {
+			seen[l] = true
+			licenses = append(licenses, l)
+		}
+	}
+	return licenses
+}
+
+type pipInspectReport struct {
+	Installed []struct {
+		Metadata struct {
+			Name       string   `json:"name"`
+			License    string   `json:"license"`
+			Classifier []string `json:"classifier"`
+		} `json:"metadata"`
+	} `json:"installed"`
+}
+
+func parsePipLicenses(data []byte) (map[string][]string, error) {
+	var report pipInspectReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+
+	licenses := map[string][]string{}
+	for _, pkg := range report.Installed {
+		var l []string
+		// The License field is free-form and sometimes holds the whole license
+		// text, so prefer trove classifiers when there are any.
+		for _, c := range pkg.Metadata.Classifier {
+			if strings.HasPrefix(c, "License ::") {
+				parts := strings.Split(c, " :: ")
+				l = append(l, parts[len(parts)-1])
+			}
+		}
+		if len(l) == 0 && pkg.Metadata.License != "" && !strings.Contains(pkg.Metadata.License, "\n") {
+			l = []string{pkg.Metadata.License}
+		}
+		if len(l) > 0 {
+			licenses[strings.ToLower(pkg.Metadata.Name)] = l
+		}
+	}
+	return licenses, nil
+}
+
+func parseGemLicenses(data []byte) map[string][]string {
+	/*
+		*** LOCAL GEMS ***
+
+		bigdecimal (default: 3.1.1)
+		    Author: Kenta Murata, Zachary Scott, Shigeo Kobayashi
+		    Homepage: https://github.com/ruby/bigdecimal
+		    Licenses: Ruby, BSD-2-Clause
+		    Installed at (default): /usr/lib/ruby/gems/3.0.0
+
+		rake (13.0.6)
+		    Author: Hiroshi SHIBATA
+		    License: MIT
+	*/
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	licenses := map[string][]string{}
+	var name string
+	for _, ln := range lines {
+		if ln == "" || strings.HasPrefix(ln, "***") {
+			continue
+		}
+		if !strings.HasPrefix(ln, " ") {
+			name, _, _ = strings.Cut(ln, " ")
+			continue
+		}
+		ln = strings.TrimSpace(ln)
+		l, ok := strings.CutPrefix(ln, "Licenses:")
+		if !ok {
+			l, ok = strings.CutPrefix(ln, "License:")
+		}
+		if !ok || name == "" {
+			continue
+		}
+		for _, e := range strings.Split(l, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				licenses[name] = append(licenses[name], e)
+			}
+		}
+	}
+	return licenses
+}
+
+// pkgsAt returns the packages with the given Location.
+func pkgsAt(pkgs []*PkgInfo, location string) []*PkgInfo {
+	var found []*PkgInfo
+	for _, pkg := range pkgs {
+		if pkg.Location == location {
+			found = append(found, pkg)
+		}
+	}
+	return found
+}
+
+// AddLicenses populates Licenses for installed rpm, deb, pip and gem
+// packages, where the package manager makes them cheap to obtain.
+func AddLicenses(ctx context.Context, pkgs *Packages) error {
+	env := envFrom(ctx)
+	var errs []string
+	if env.RPMQueryExists && len(pkgs.Rpm) > 0 {
+		out, err := run(ctx, rpmquery, rpmqueryLicenseArgs)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("error getting rpm package licenses: %v", err))
+		} else {
+			licenses := parseRPMLicenses(out)
+			for _, pkg := range pkgs.Rpm {
+				pkg.Licenses = licenses[pkg.Name+"."+pkg.Arch]
+			}
+		}
+	}
+	for _, pkg := range pkgs.Deb {
+		data, err := env.FS.ReadFile(filepath.Join(debDocDir, pkg.Name, "copyright"))
+		if err != nil {
+			clog.Debugf(ctx, "No copyright file for deb package %q: %v", pkg.Name, err)
+			continue
+		}
+		pkg.Licenses = parseDebCopyright(data)
+	}
+	// pip and gem packages are matched by the interpreter or gem command
+	// they were listed with, see InstalledPipPackages and
+	// InstalledGemPackages.
+	for _, python := range pythons {
+		pythonPkgs := pkgsAt(pkgs.Pip, python)
+		if len(pythonPkgs) == 0 {
+			continue
+		}
+		out, err := runPip(ctx, pipListTimeout, python, pipInspectArgs)
+		if err == nil {
+			var licenses map[string][]string
+			if licenses, err = parsePipLicenses(out); err == nil {
+				for _, pkg := range pythonPkgs {
+					pkg.Licenses = licenses[strings.ToLower(pkg.Name)]
+				}
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("error getting pip package licenses for %s: %v", python, err))
+		}
+	}
+	for _, gem := range gems {
+		gemPkgs := pkgsAt(pkgs.Gem, gem)
+		if len(gemPkgs) == 0 {
+			continue
+		}
+		out, err := runWithDeadline(ctx, gemListTimeout, gem, gemListDetailsArgs)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("error getting gem package licenses for %s: %v", gem, err))
+			continue
+		}
+		licenses := parseGemLicenses(out)
+		for _, pkg := range gemPkgs {
+			pkg.Licenses = licenses[pkg.Name]
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestParseRPMLicenses(t *testing.T) {
+	data := []byte("bash x86_64 GPLv3+\ntzdata noarch Public Domain\ngpg-pubkey (none) (none)\n")
+	want := map[string][]string{
+		"bash.x86_64": {"GPLv3+"},
+		"tzdata.all":  {"Public Domain"},
+	}
+	if got := parseRPMLicenses(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRPMLicenses() = %v, want %v", got, want)
+	}
+}
+
+func TestParseDebCopyright(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "MachineReadable",
+			data: `Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+Upstream-Name: bash
+
+Files: *
+Copyright: 1987-2020 Free Software Foundation, Inc.
+License: GPL-3+
+
+Files: debian/*
+License: GPL-3+
+
+Files: lib/readline/*
+License: GPL-2+
+ This program is free software.
+`,
+			want: []string{"GPL-3+", "GPL-2+"},
+		},
+		{
+			name: "FreeForm",
+			data: "This package was debianized by someone.\n\nLicense: GPL\n",
+			want: nil,
+		},
+		{
+			name: "Empty",
+			data: "",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseDebCopyright([]byte(tt.data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDebCopyright() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePipLicenses(t *testing.T) {
+	data := []byte(`{"version": "1", "installed": [
+		{"metadata": {"name": "requests", "license": "Apache 2.0", "classifier": ["License :: OSI Approved :: Apache Software License", "Programming Language :: Python"]}},
+		{"metadata": {"name": "PyYAML", "license": "MIT"}},
+		{"metadata": {"name": "certifi", "license": "MPL-2.0\nfull text"}}
+	]}`)
+	want := map[string][]string{
+		"requests": {"Apache Software License"},
+		"pyyaml":   {"MIT"},
+	}
+	got, err := parsePipLicenses(data)
+	if err != nil {
+		t.Fatalf("parsePipLicenses(): unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePipLicenses() = %v, want %v", got, want)
+	}
+
+	if _, err := parsePipLicenses([]byte("not json")); err == nil {
+		t.Errorf("parsePipLicenses(): expected error for invalid input")
+	}
+}
+
+func TestParseGemLicenses(t *testing.T) {
+	data := []byte(`
+*** LOCAL GEMS ***
+
+bigdecimal (default: 3.1.1)
+    Author: Kenta Murata, Zachary Scott, Shigeo Kobayashi
+    Homepage: https://github.com/ruby/bigdecimal
+    Licenses: Ruby, BSD-2-Clause
+    Installed at (default): /usr/lib/ruby/gems/3.0.0
+
+rake (13.0.6)
+    Author: Hiroshi SHIBATA
+    License: MIT
+
+nolicense (1.0.0)
+    Author: Someone
+`)
+	want := map[string][]string{
+		"bigdecimal": {"Ruby", "BSD-2-Clause"},
+		"rake":       {"MIT"},
+	}
+	if got := parseGemLicenses(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseGemLicenses() = %v, want %v", got, want)
+	}
+}
+
+func TestAddLicenses(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	oldRPMQueryExists, oldDebDocDir := RPMQueryExists, debDocDir
+	defer func() { RPMQueryExists, debDocDir = oldRPMQueryExists, oldDebDocDir }()
+	RPMQueryExists = true
+	debDocDir = t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(debDocDir, "bash"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	copyright := "Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/\n\nFiles: *\nLicense: GPL-3+\n"
+	if err := os.WriteFile(filepath.Join(debDocDir, "bash", "copyright"), []byte(copyright), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rpmCmd := utilmocks.EqCmd(exec.Command(rpmquery, rpmqueryLicenseArgs...))
+	mockCommandRunner.EXPECT().Run(testCtx, rpmCmd).Return([]byte("gcc x86_64 GPLv3+\n"), nil, nil).Times(1)
+
+	pkgs := &Packages{
+		Rpm: []*PkgInfo{{Name: "gcc", Arch: "x86_64"}},
+		Deb: []*PkgInfo{{Name: "bash", Arch: "x86_64"}, {Name: "missing", Arch: "all"}},
+	}
+	if err := AddLicenses(testCtx, pkgs); err != nil {
+		t.Fatalf("AddLicenses(): unexpected error: %v", err)
+	}
+
+	want := &Packages{
+		Rpm: []*PkgInfo{{Name: "gcc", Arch: "x86_64", Licenses: []string{"GPLv3+"}}},
+		Deb: []*PkgInfo{{Name: "bash", Arch: "x86_64", Licenses: []string{"GPL-3+"}}, {Name: "missing", Arch: "all"}},
+	}
+	if !reflect.DeepEqual(pkgs, want) {
+		t.Errorf("AddLicenses() = %+v, want %+v", pkgs, want)
+	}
+}
@@ -0,0 +1,41 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"io"
+	"os/exec"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// cLocaleRunner is a util.DefaultRunner running commands in the C locale,
+// the output of package managers is parsed so it must not be translated.
+// Package manager transactions can be silent for long, they are not checked
+// for stalled output.
+type cLocaleRunner struct {
+	util.DefaultRunner
+}
+
+func (r *cLocaleRunner) Run(ctx context.Context, cmd *exec.Cmd) ([]byte, []byte, error) {
+	util.SetCLocale(cmd)
+	return r.DefaultRunner.Run(util.WithoutStallCheck(ctx), cmd)
+}
+
+func (r *cLocaleRunner) RunStreaming(ctx context.Context, cmd *exec.Cmd, w io.Writer) ([]byte, error) {
+	util.SetCLocale(cmd)
+	return r.DefaultRunner.RunStreaming(util.WithoutStallCheck(ctx), cmd, w)
+}
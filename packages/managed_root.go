@@ -0,0 +1,205 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	chroot  = "/usr/sbin/chroot"
+	nsenter = "/usr/bin/nsenter"
+)
+
+// ManagedRoot is an alternate system root, such as a chroot or the root
+// filesystem of a running container, that is managed from the host.
+// This is experimental.
+type ManagedRoot struct {
+	// Name identifies the root when reporting.
+	Name string
+	// Path is the absolute path to the root filesystem.
+	Path string
+	// Pid is the container process whose namespaces are entered when
+	// running commands, if set.
+	Pid int
+}
+
+// ParseManagedRoot parses a managed root spec. Valid specs are an absolute
+// path ("/srv/appliance"), a container process ("pid:1234", which uses the
+// root filesystem of that process), and either of those prefixed with a name
+// ("appliance=/srv/appliance").
+func ParseManagedRoot(spec string) (ManagedRoot, error) {
+	spec = strings.TrimSpace(spec)
+	name, target, named := strings.Cut(spec, "=")
+	if !named {
+		target = spec
+	}
+	name, target = strings.TrimSpace(name), strings.TrimSpace(target)
+
+	var root ManagedRoot
+	switch {
+	case strings.HasPrefix(target, "pid:"):
+		pid, err := strconv.Atoi(strings.TrimPrefix(target, "pid:"))
+		if err != nil || pid <= 1 {
+			return root, fmt.Errorf("invalid managed root %q: bad pid", spec)
+		}
+		root.Path = fmt.Sprintf("/proc/%d/root", pid)
+		root.Name = fmt.Sprintf("pid-%d", pid)
+		root.Pid = pid
+	case filepath.IsAbs(target) && filepath.Clean(target) != "/":
+		root.Path = filepath.Clean(target)
+		root.Name = root.Path
+	default:
+		return root, fmt.Errorf("invalid managed root %q: must be an absolute path other than / or pid:<pid>", spec)
+	}
+	if named {
+		root.Name = name
+	}
+	return root, nil
+}
+
+func (r ManagedRoot) exists(path string) bool {
+	return util.Exists(filepath.Join(r.Path, path))
+}
+
+// GetInstalledPackagesInRoot gets all installed rpm and deb packages in a
+// managed root. The package databases are read with the host binaries so
+// nothing is executed inside the root.
+func GetInstalledPackagesInRoot(ctx context.Context, root ManagedRoot) (*Packages, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errors.New("managed roots are not supported on Windows")
+	}
+	env := envFrom(ctx)
+	pkgs := &Packages{}
+	var errs []string
+	if env.RPMQueryExists && root.exists("/var/lib/rpm") {
+		out, err := run(ctx, rpmquery, append([]string{"--root", root.Path}, rpmqueryInstalledArgs...))
+		if err != nil {
+			msg := fmt.Sprintf("error listing installed rpm packages in %q: %v", root.Name, err)
+			clog.Debugf(ctx, "Error: %s", msg)
+			errs = append(errs, msg)
+		} else {
+			pkgs.Rpm = parseInstalledRPMPackages(ctx, out)
+		}
+	}
+	if env.DpkgQueryExists && root.exists("/var/lib/dpkg/status") {
+		out, err := run(ctx, dpkgQuery, append([]string{"--admindir=" + filepath.Join(root.Path, "/var/lib/dpkg")}, dpkgQueryArgs...))
+		if err != nil {
+			msg := fmt.Sprintf("error listing installed deb packages in %q: %v", root.Name, err)
+			clog.Debugf(ctx, "Error: %s", msg)
+			errs = append(errs, msg)
+		} else {
+			pkgs.Deb = parseInstalledDebPackages(ctx, out)
+		}
+	}
+
+	var err error
+	if len(errs) != 0 {
+		err = errors.New(strings.Join(errs, "\n"))
+	}
+	return pkgs, err
+}
+
+// PackageManager returns the package manager found in the root, "apt",
+// "yum" or "zypper", or "" if there is none.
+func (r ManagedRoot) PackageManager() string {
+	switch {
+	case r.exists(aptGet):
+		return "apt"
+	case r.exists(yum):
+		return "yum"
+	case r.exists(zypper):
+		return "zypper"
+	}
+	return ""
+}
+
+// rootPackageManager returns the package manager and install/remove args to
+// use inside root.
+func rootPackageManager(root ManagedRoot) (string, []string, []string, error) {
+	switch root.PackageManager() {
+	case "apt":
+		return aptGet, aptGetInstallArgs, aptGetRemoveArgs, nil
+	case "yum":
+		return yum, yumInstallArgs, yumRemoveArgs, nil
+	case "zypper":
+		return zypper, zypperInstallArgs, zypperRemoveArgs, nil
+	}
+	return "", nil, nil, fmt.Errorf("no supported package manager found in managed root %q", root.Name)
+}
+
+func runInRoot(ctx context.Context, root ManagedRoot, cmd string, args []string) error {
+	if runtime.GOOS == "windows" {
+		return errors.New("managed roots are not supported on Windows")
+	}
+	// Container roots are entered with nsenter so the package manager sees the
+	// container's mounts and network, plain roots just use chroot.
+	var c *exec.Cmd
+	if root.Pid != 0 {
+		c = exec.CommandContext(ctx, nsenter, append([]string{"--target", strconv.Itoa(root.Pid), "--mount", "--uts", "--ipc", "--net", "--pid", "--", cmd}, args...)...)
+	} else {
+		c = exec.CommandContext(ctx, chroot, append([]string{root.Path, cmd}, args...)...)
+	}
+	c.Env = append(c.Env, "DEBIAN_FRONTEND=noninteractive", "PATH=/usr/sbin:/usr/bin:/sbin:/bin")
+	logArgv(ctx, c)
+	stdout, stderr, err := envFrom(ctx).Runner.Run(ctx, c)
+	if err != nil {
+		return fmt.Errorf("error running %s with args %q in managed root %q: %v, stdout: %q, stderr: %q", cmd, args, root.Name, err, stdout, stderr)
+	}
+	return nil
+}
+
+// InstallPackagesInRoot installs packages inside a managed root using the
+// package manager found in that root.
+func InstallPackagesInRoot(ctx context.Context, root ManagedRoot, pkgs []string) error {
+	cmd, installArgs, _, err := rootPackageManager(root)
+	if err != nil {
+		return err
+	}
+	args, err := pkgArgs(installArgs, pkgs)
+	if err != nil {
+		return err
+	}
+	err = runInRoot(ctx, root, cmd, args)
+	journal.Record(ctx, journal.PackageInstall, root.Name+": "+strings.Join(pkgs, " "), err)
+	return err
+}
+
+// RemovePackagesInRoot removes packages inside a managed root using the
+// package manager found in that root.
+func RemovePackagesInRoot(ctx context.Context, root ManagedRoot, pkgs []string) error {
+	cmd, _, removeArgs, err := rootPackageManager(root)
+	if err != nil {
+		return err
+	}
+	args, err := pkgArgs(removeArgs, pkgs)
+	if err != nil {
+		return err
+	}
+	err = runInRoot(ctx, root, cmd, args)
+	journal.Record(ctx, journal.PackageRemove, root.Name+": "+strings.Join(pkgs, " "), err)
+	return err
+}
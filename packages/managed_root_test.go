@@ -0,0 +1,142 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestParseManagedRoot(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    ManagedRoot
+		wantErr bool
+	}{
+		{spec: "/srv/appliance", want: ManagedRoot{Name: "/srv/appliance", Path: "/srv/appliance"}},
+		{spec: "app=/srv/appliance/", want: ManagedRoot{Name: "app", Path: "/srv/appliance"}},
+		{spec: "pid:1234", want: ManagedRoot{Name: "pid-1234", Path: "/proc/1234/root", Pid: 1234}},
+		{spec: "web = pid:1234", want: ManagedRoot{Name: "web", Path: "/proc/1234/root", Pid: 1234}},
+		{spec: "/", wantErr: true},
+		{spec: "relative/path", wantErr: true},
+		{spec: "pid:1", wantErr: true},
+		{spec: "pid:abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseManagedRoot(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseManagedRoot(%q): unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseManagedRoot(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestGetInstalledPackagesInRoot(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	oldDpkgQueryExists, oldRPMQueryExists := DpkgQueryExists, RPMQueryExists
+	defer func() { DpkgQueryExists, RPMQueryExists = oldDpkgQueryExists, oldRPMQueryExists }()
+	DpkgQueryExists, RPMQueryExists = true, true
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "var/lib/dpkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "var/lib/dpkg/status"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	root := ManagedRoot{Name: "test", Path: dir}
+
+	// Only dpkg-query should run as there is no rpm database in root.
+	dpkgQueryCmd := utilmocks.EqCmd(exec.Command(dpkgQuery, append([]string{"--admindir=" + filepath.Join(dir, "var/lib/dpkg")}, dpkgQueryArgs...)...))
+	stdout := []byte(`{"package":"git","architecture":"amd64","version":"1:2.25.1-1ubuntu3.12","status":"installed","source_name":"git","source_version":"1:2.25.1-1ubuntu3.12"}`)
+	mockCommandRunner.EXPECT().Run(testCtx, dpkgQueryCmd).Return(stdout, nil, nil).Times(1)
+
+	got, err := GetInstalledPackagesInRoot(testCtx, root)
+	if err != nil {
+		t.Fatalf("GetInstalledPackagesInRoot(): got unexpected error: %v", err)
+	}
+	want := &Packages{Deb: []*PkgInfo{{Name: "git", Arch: "x86_64", Version: "1:2.25.1-1ubuntu3.12", Source: Source{Name: "git", Version: "1:2.25.1-1ubuntu3.12"}}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetInstalledPackagesInRoot() = %+v, want %+v", got, want)
+	}
+}
+
+func TestInstallPackagesInRoot(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	root := ManagedRoot{Name: "test", Path: t.TempDir()}
+	if err := InstallPackagesInRoot(testCtx, root, []string{"foo"}); err == nil {
+		t.Errorf("InstallPackagesInRoot(): expected error for root without a package manager")
+	}
+
+	if err := os.MkdirAll(filepath.Join(root.Path, filepath.Dir(aptGet)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root.Path, aptGet), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(chroot, append([]string{root.Path, aptGet}, append(aptGetInstallArgs, "foo")...)...)
+	cmd.Env = []string{"DEBIAN_FRONTEND=noninteractive", "PATH=/usr/sbin:/usr/bin:/sbin:/bin"}
+	mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(cmd)).Return(nil, nil, nil).Times(1)
+	if err := InstallPackagesInRoot(testCtx, root, []string{"foo"}); err != nil {
+		t.Errorf("InstallPackagesInRoot(): got unexpected error: %v", err)
+	}
+}
+
+func TestRemovePackagesInContainerRoot(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	// The root of a container is found under its process, here a directory
+	// standing in for /proc/<pid>/root.
+	root := ManagedRoot{Name: "web", Path: t.TempDir(), Pid: 1234}
+	if err := os.MkdirAll(filepath.Join(root.Path, filepath.Dir(yum)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root.Path, yum), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := root.PackageManager(); got != "yum" {
+		t.Errorf("PackageManager() = %q, want %q", got, "yum")
+	}
+	cmd := exec.Command(nsenter, append([]string{"--target", "1234", "--mount", "--uts", "--ipc", "--net", "--pid", "--", yum}, append(yumRemoveArgs, "foo")...)...)
+	cmd.Env = []string{"DEBIAN_FRONTEND=noninteractive", "PATH=/usr/sbin:/usr/bin:/sbin:/bin"}
+	mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(cmd)).Return(nil, nil, nil).Times(1)
+	if err := RemovePackagesInRoot(testCtx, root, []string{"foo"}); err != nil {
+		t.Errorf("RemovePackagesInRoot(): got unexpected error: %v", err)
+	}
+
+	if err := RemovePackagesInRoot(testCtx, root, []string{"-foo"}); err == nil {
+		t.Errorf("RemovePackagesInRoot(): expected error for an invalid package name")
+	}
+}
@@ -0,0 +1,109 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/journal"
+)
+
+var (
+	yumDowngradeArgs = []string{"downgrade", "--assumeyes"}
+	// --oldpackage lets zypper install a version older than the installed
+	// one.
+	zypperInstallVersionArgs = append(append([]string{}, zypperInstallArgs...), "--oldpackage")
+)
+
+// Manifest is a package set, the exact version of each package, like the
+// packages installed on a golden VM, see ManifestFromPackages. Versions are
+// as reported by inventory, with the epoch if there is one.
+type Manifest struct {
+	Packages map[string]string `json:"packages"`
+}
+
+// Validate checks the package names and versions, they are passed to the
+// package manager.
+func (m *Manifest) Validate() error {
+	if len(m.Packages) == 0 {
+		return fmt.Errorf("manifest has no packages")
+	}
+	for name, version := range m.Packages {
+		if err := ValidatePackageName(name); err != nil {
+			return err
+		}
+		if strings.ContainsAny(name, "=:") {
+			return fmt.Errorf("invalid package name %q, manifest names can not have a version or architecture", name)
+		}
+		if err := ValidatePackageVersion(version); err != nil {
+			return fmt.Errorf("package %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// ParseManifest parses and validates a JSON manifest.
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %v", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ManifestFromPackages returns the manifest of the installed deb or rpm
+// packages in pkgs. A package installed for more than one architecture is
+// only listed once, with the version of its first entry.
+func ManifestFromPackages(pkgs *Packages) *Manifest {
+	m := &Manifest{Packages: map[string]string{}}
+	for _, list := range [][]*PkgInfo{pkgs.Deb, pkgs.Rpm} {
+		for _, pkg := range list {
+			if _, ok := m.Packages[pkg.Name]; !ok {
+				m.Packages[pkg.Name] = pkg.Version
+			}
+		}
+	}
+	return m
+}
+
+// DowngradeYumPackages downgrades yum packages to the versions in pkgs, as
+// name-version. Older yum versions don't downgrade on install.
+func DowngradeYumPackages(ctx context.Context, pkgs []string) error {
+	args, err := pkgArgs(yumDowngradeArgs, pkgs)
+	if err != nil {
+		return err
+	}
+	_, err = runChange(ctx, yum, args)
+	journal.Record(ctx, journal.PackageInstall, strings.Join(pkgs, " "), err)
+	return err
+}
+
+// InstallZypperPackageVersions installs the versions of zypper packages in
+// pkgs, as name=version, downgrading installed packages if needed.
+func InstallZypperPackageVersions(ctx context.Context, pkgs []string) error {
+	args, err := pkgArgs(zypperInstallVersionArgs, pkgs)
+	if err != nil {
+		return err
+	}
+	_, err = runChange(ctx, zypper, args)
+	journal.Record(ctx, journal.PackageInstall, strings.Join(pkgs, " "), err)
+	return err
+}
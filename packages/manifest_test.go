@@ -0,0 +1,67 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		desc    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"valid", `{"packages": {"curl": "7.88.1-10+deb12u5", "vim": "2:9.0.1378-2"}}`, map[string]string{"curl": "7.88.1-10+deb12u5", "vim": "2:9.0.1378-2"}, false},
+		{"rpm", `{"packages": {"bash": "5.1.8-9.el9"}}`, map[string]string{"bash": "5.1.8-9.el9"}, false},
+		{"empty", `{"packages": {}}`, nil, true},
+		{"not json", `curl 7.88.1`, nil, true},
+		{"name with version", `{"packages": {"curl=7.88.1": "7.88.1"}}`, nil, true},
+		{"option name", `{"packages": {"--force": "1"}}`, nil, true},
+		{"empty version", `{"packages": {"curl": ""}}`, nil, true},
+		{"version with space", `{"packages": {"curl": "1.0 bash"}}`, nil, true},
+	}
+	for _, tt := range tests {
+		m, err := ParseManifest([]byte(tt.data))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ParseManifest() error = %v, want error %t", tt.desc, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if diff := cmp.Diff(tt.want, m.Packages); diff != "" {
+			t.Errorf("%s: ParseManifest() mismatch (-want +got):\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestManifestFromPackages(t *testing.T) {
+	pkgs := &Packages{
+		Rpm: []*PkgInfo{
+			{Name: "glibc", Arch: "x86_64", Version: "2.34-100.el9"},
+			{Name: "glibc", Arch: "i686", Version: "2.34-100.el9"},
+			{Name: "bash", Arch: "x86_64", Version: "5.1.8-9.el9"},
+		},
+		Pip: []*PkgInfo{{Name: "requests", Version: "2.31.0"}},
+	}
+	want := map[string]string{"glibc": "2.34-100.el9", "bash": "5.1.8-9.el9"}
+	if diff := cmp.Diff(want, ManifestFromPackages(pkgs).Packages); diff != "" {
+		t.Errorf("ManifestFromPackages() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"unsafe"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
 	ole "github.com/go-ole/go-ole"
 	"golang.org/x/sys/windows"
 )
@@ -239,7 +240,9 @@ func InstallMSIPackage(ctx context.Context, path string, args []string) error {
 
 	args = append(msiInstallArgs, args...)
 	clog.Infof(ctx, "Installing msi package %q with command line %q.", path, args)
-	if err := msiInstallProductW(path, args); err != nil {
+	err := msiInstallProductW(path, args)
+	journal.Record(ctx, journal.PackageInstall, path, err)
+	if err != nil {
 		return fmt.Errorf("error installing MSI package %q: %v", path, err)
 	}
 
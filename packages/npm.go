@@ -0,0 +1,210 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// npmCommand is an npm CLI script and the node next to it that runs it,
+// node is not always on the PATH, like with nvm.
+type npmCommand struct {
+	node, cli string
+}
+
+var (
+	// npms are the npm commands of each installed node.
+	npms []npmCommand
+
+	npmPatterns = []string{
+		"/usr/bin/npm",
+		"/usr/local/bin/npm",
+		"/opt/node*/bin/npm",
+		"/usr/local/nvm/versions/node/*/bin/npm",
+		"/root/.nvm/versions/node/*/bin/npm",
+	}
+
+	npmListArgs        = []string{"ls", "--global", "--json", "--depth=0"}
+	npmOutdatedArgs    = []string{"outdated", "--global", "--json"}
+	npmListTimeout     = 15 * time.Second
+	npmOutdatedTimeout = 30 * time.Second
+)
+
+func init() {
+	if runtime.GOOS != "windows" {
+		npms = findNpmCommands(npmPatterns)
+	}
+	NpmExists = len(npms) > 0
+}
+
+// findNpmCommands returns the npm commands matching the glob patterns with
+// a node in the same directory. Paths are resolved through symlinks so each
+// npm is only returned once.
+func findNpmCommands(patterns []string) []npmCommand {
+	var cmds []npmCommand
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			cli, err := filepath.EvalSymlinks(match)
+			if err != nil || seen[cli] {
+				continue
+			}
+			node, err := filepath.EvalSymlinks(filepath.Join(filepath.Dir(match), "node"))
+			if err != nil {
+				continue
+			}
+			if fi, err := os.Stat(node); err != nil || !fi.Mode().IsRegular() || fi.Mode().Perm()&0111 == 0 {
+				continue
+			}
+			seen[cli] = true
+			cmds = append(cmds, npmCommand{node: node, cli: cli})
+		}
+	}
+	return cmds
+}
+
+// runNpm runs npm with args. npm exits with 1 when there are outdated
+// packages or problems in the tree, like extraneous packages, but still
+// prints the JSON result.
+func runNpm(ctx context.Context, timeout time.Duration, npm npmCommand, args []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	stdout, stderr, err := envFrom(ctx).Runner.Run(ctx, exec.CommandContext(ctx, npm.node, append([]string{npm.cli}, args...)...))
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 && len(stdout) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", npm.cli, args, err, stdout, stderr)
+	}
+	return stdout, nil
+}
+
+// npmPackages runs npm with args for each node and parses the output with
+// parse. An error is only returned if npm failed for every node.
+func npmPackages(ctx context.Context, timeout time.Duration, args []string, parse func([]byte) ([]*PkgInfo, error)) ([]*PkgInfo, error) {
+	var pkgs []*PkgInfo
+	var errs []string
+	for _, npm := range npms {
+		out, err := runNpm(ctx, timeout, npm, args)
+		var found []*PkgInfo
+		if err == nil {
+			found, err = parse(out)
+		}
+		if err != nil {
+			clog.Debugf(ctx, "Error running npm for %s: %v", npm.node, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", npm.node, err))
+			continue
+		}
+		for _, pkg := range found {
+			pkg.Location = npm.node
+		}
+		pkgs = append(pkgs, found...)
+	}
+	if len(errs) == len(npms) && len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\n"))
+	}
+	return pkgs, nil
+}
+
+type npmListOutput struct {
+	Dependencies map[string]struct {
+		Version string `json:"version"`
+	} `json:"dependencies"`
+}
+
+type npmOutdatedPkg struct {
+	Current string `json:"current"`
+	Latest  string `json:"latest"`
+}
+
+func parseInstalledNpmPackages(data []byte) ([]*PkgInfo, error) {
+	/*
+		{
+		  "name": "lib",
+		  "dependencies": {
+		    "npm": {"version": "10.5.0", "overridden": false},
+		    "typescript": {"version": "5.4.5", "overridden": false}
+		  }
+		}
+	*/
+	var out npmListOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+
+	var pkgs []*PkgInfo
+	for name, dep := range out.Dependencies {
+		// Missing packages, listed in package.json but not installed, have
+		// no version.
+		if dep.Version == "" {
+			continue
+		}
+		pkgs = append(pkgs, &PkgInfo{Name: name, Arch: noarch, Version: dep.Version})
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs, nil
+}
+
+func parseNpmUpdates(data []byte) ([]*PkgInfo, error) {
+	/*
+		{
+		  "typescript": {
+		    "current": "5.4.5",
+		    "wanted": "5.4.5",
+		    "latest": "5.5.2",
+		    "dependent": "global",
+		    "location": "/usr/lib/node_modules/typescript"
+		  }
+		}
+	*/
+	var outdated map[string]npmOutdatedPkg
+	if err := json.Unmarshal(data, &outdated); err != nil {
+		return nil, err
+	}
+
+	var pkgs []*PkgInfo
+	for name, pkg := range outdated {
+		if pkg.Latest == "" || pkg.Latest == pkg.Current {
+			continue
+		}
+		pkgs = append(pkgs, &PkgInfo{Name: name, Arch: noarch, Version: pkg.Latest})
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs, nil
+}
+
+// NpmUpdates queries for all available updates of global npm packages.
+func NpmUpdates(ctx context.Context) ([]*PkgInfo, error) {
+	return npmPackages(ctx, npmOutdatedTimeout, npmOutdatedArgs, parseNpmUpdates)
+}
+
+// InstalledNpmPackages queries for all globally installed npm packages. The
+// Location of each package is the node it is installed for.
+func InstalledNpmPackages(ctx context.Context) ([]*PkgInfo, error) {
+	return npmPackages(ctx, npmListTimeout, npmListArgs, parseInstalledNpmPackages)
+}
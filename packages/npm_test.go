@@ -0,0 +1,146 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestParseInstalledNpmPackages(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []*PkgInfo
+		wantErr bool
+	}{
+		{
+			"NormalCase",
+			`{"name": "lib", "dependencies": {"typescript": {"version": "5.4.5"}, "@angular/cli": {"version": "17.3.6"}, "missing": {"required": "^1.0.0", "missing": true}}}`,
+			[]*PkgInfo{
+				{Name: "@angular/cli", Arch: noarch, Version: "17.3.6"},
+				{Name: "typescript", Arch: noarch, Version: "5.4.5"},
+			},
+			false,
+		},
+		{"NoPackages", `{"name": "lib"}`, nil, false},
+		{"Invalid", `npm ERR! code ELSPROBLEMS`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseInstalledNpmPackages([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseInstalledNpmPackages(): got err %v, want err %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseInstalledNpmPackages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNpmUpdates(t *testing.T) {
+	data := `{
+	  "typescript": {"current": "5.4.5", "wanted": "5.4.5", "latest": "5.5.2", "dependent": "global"},
+	  "corepack": {"current": "0.28.0", "wanted": "0.28.0", "latest": "0.28.0", "dependent": "global"}
+	}`
+	got, err := parseNpmUpdates([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []*PkgInfo{{Name: "typescript", Arch: noarch, Version: "5.5.2"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseNpmUpdates() = %v, want %v", got, want)
+	}
+}
+
+func TestNpmUpdates(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	defer func(n []npmCommand) { npms = n }(npms)
+	npms = []npmCommand{
+		{node: "/usr/bin/node", cli: "/usr/share/nodejs/npm/bin/npm-cli.js"},
+		{node: "/root/.nvm/versions/node/v20.12.2/bin/node", cli: "/root/.nvm/versions/node/v20.12.2/lib/node_modules/npm/bin/npm-cli.js"},
+	}
+	npmCmd := func(npm npmCommand, args []string) gomock.Matcher {
+		return utilmocks.EqCmd(exec.Command(npm.node, append([]string{npm.cli}, args...)...))
+	}
+
+	// npm outdated exits with 1 when there are outdated packages.
+	outdated := exec.Command("/bin/sh", "-c", "exit 1").Run()
+	mockCommandRunner.EXPECT().Run(gomock.Any(), npmCmd(npms[0], npmOutdatedArgs)).Return([]byte(`{"typescript": {"current": "5.4.5", "latest": "5.5.2"}}`), nil, outdated)
+	mockCommandRunner.EXPECT().Run(gomock.Any(), npmCmd(npms[1], npmOutdatedArgs)).Return(nil, []byte("npm ERR! network"), errors.New("exit status 1"))
+
+	got, err := NpmUpdates(testCtx)
+	if err != nil {
+		t.Fatalf("NpmUpdates(): unexpected error: %v", err)
+	}
+	want := []*PkgInfo{{Name: "typescript", Arch: noarch, Version: "5.5.2", Location: npms[0].node}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NpmUpdates() = %v, want %v", got, want)
+	}
+
+	mockCommandRunner.EXPECT().Run(gomock.Any(), gomock.Any()).Return(nil, []byte("npm ERR! network"), outdated).Times(2)
+	if _, err := NpmUpdates(testCtx); err == nil {
+		t.Error("NpmUpdates(): did not get expected error when npm failed for every node")
+	}
+}
+
+func TestFindNpmCommands(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, perm os.FileMode) string {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, perm); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	symlink := func(target, name string) {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cli := write("lib/node_modules/npm/bin/npm-cli.js", 0755)
+	node := write("bin/node", 0755)
+	symlink(cli, "bin/npm")
+	// The same npm through another link is only returned once.
+	if err := os.MkdirAll(filepath.Join(dir, "local/bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	symlink(cli, "local/bin/npm")
+	symlink(node, "local/bin/node")
+	// An npm without a node next to it is skipped.
+	write("nvm/v18/bin/npm", 0755)
+
+	got := findNpmCommands([]string{filepath.Join(dir, "bin/npm"), filepath.Join(dir, "local/bin/npm"), filepath.Join(dir, "nvm/*/bin/npm")})
+	want := []npmCommand{{node: node, cli: cli}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findNpmCommands() = %v, want %v", got, want)
+	}
+}
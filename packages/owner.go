@@ -0,0 +1,139 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+)
+
+var (
+	dpkgQueryOwnerArgs = []string{"-S"}
+	dpkgVerifyArgs     = []string{"--verify"}
+	rpmqueryOwnerArgs  = append(rpmqueryArgs, "-f")
+	rpmVerifyArgs      = []string{"-V"}
+)
+
+// OwningPackage returns the installed deb or rpm package the file at path
+// belongs to, with its Repository where apt, dnf or zypper know it, nil if
+// no package owns the file.
+func OwningPackage(ctx context.Context, path string) (*PkgInfo, error) {
+	var pkg *PkgInfo
+	env := envFrom(ctx)
+	switch {
+	case env.DpkgQueryExists:
+		stdout, stderr, err := envFrom(ctx).Runner.Run(ctx, exec.CommandContext(ctx, dpkgQuery, append(dpkgQueryOwnerArgs, path)...))
+		if err != nil {
+			if bytes.Contains(stderr, []byte("no path found")) {
+				return nil, nil
+			}
+			return nil, errcode.Wrap(errcode.PackageManager, fmt.Errorf("error running %s with args %q: %v, stderr: %q", dpkgQuery, dpkgQueryOwnerArgs, err, stderr))
+		}
+		name := parseDpkgQueryOwner(stdout, path)
+		if name == "" {
+			return nil, nil
+		}
+		out, err := run(ctx, dpkgQuery, append(dpkgQueryArgs, name))
+		if err != nil {
+			return nil, err
+		}
+		if pkgs := parseInstalledDebPackages(ctx, out); len(pkgs) > 0 {
+			pkg = pkgs[0]
+			setDebRepositories(ctx, env, nil, pkgs[:1])
+		}
+	case env.RPMQueryExists:
+		stdout, stderr, err := envFrom(ctx).Runner.Run(ctx, exec.CommandContext(ctx, rpmquery, append(rpmqueryOwnerArgs, path)...))
+		if err != nil {
+			if bytes.Contains(stdout, []byte("not owned")) {
+				return nil, nil
+			}
+			return nil, errcode.Wrap(errcode.PackageManager, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", rpmquery, rpmqueryOwnerArgs, err, stdout, stderr))
+		}
+		if pkgs := parseInstalledRPMPackages(ctx, stdout); len(pkgs) > 0 {
+			pkg = pkgs[0]
+			setRPMRepositories(ctx, env, nil, pkgs[:1])
+		}
+	}
+	return pkg, nil
+}
+
+// parseDpkgQueryOwner returns the first package owning path in dpkg-query -S
+// output, without the architecture of multi-arch packages.
+func parseDpkgQueryOwner(data []byte, path string) string {
+	/*
+		google-osconfig-agent: /usr/bin/google_osconfig_agent
+		diversion by dash from: /bin/sh
+		libc6:amd64, libc6:i386: /usr/share/doc/libc6
+	*/
+	for _, line := range strings.Split(string(data), "\n") {
+		owners, p, ok := strings.Cut(strings.TrimSpace(line), ": ")
+		if !ok || p != path || strings.HasPrefix(owners, "diversion by ") {
+			continue
+		}
+		owner, _, _ := strings.Cut(owners, ",")
+		name, _, _ := strings.Cut(strings.TrimSpace(owner), ":")
+		return name
+	}
+	return ""
+}
+
+// PackageFileModified reports whether the file at path differs from the one
+// installed by pkg, see OwningPackage, according to the checksums of dpkg or
+// rpm.
+func PackageFileModified(ctx context.Context, pkg *PkgInfo, path string) (bool, error) {
+	var cmd string
+	var args []string
+	env := envFrom(ctx)
+	switch {
+	case env.DpkgQueryExists:
+		cmd, args = dpkg, append(dpkgVerifyArgs, pkg.Name)
+	case env.RPMQueryExists:
+		cmd, args = rpm, append(rpmVerifyArgs, pkg.Name)
+	default:
+		return false, fmt.Errorf("no package manager to verify %q", path)
+	}
+	// Both exit non zero when any file of the package differs, so only
+	// fail without output.
+	stdout, stderr, err := env.Runner.Run(ctx, exec.CommandContext(ctx, cmd, args...))
+	if err != nil && len(bytes.TrimSpace(stdout)) == 0 {
+		return false, errcode.Wrap(errcode.PackageManager, fmt.Errorf("error running %s with args %q: %v, stderr: %q", cmd, args, err, stderr))
+	}
+	return parseVerifyOutput(stdout, path), nil
+}
+
+// parseVerifyOutput reports whether the dpkg --verify or rpm -V output has
+// a digest mismatch for path, or path is missing.
+func parseVerifyOutput(data []byte, path string) bool {
+	/*
+		??5??????   /usr/bin/google_osconfig_agent
+		S.5....T.  c /etc/osconfig/config
+		missing     /usr/share/doc/google-osconfig-agent/README
+	*/
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[len(fields)-1] != path {
+			continue
+		}
+		if fields[0] == "missing" || (len(fields[0]) == 9 && fields[0][2] == '5') {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestParseDpkgQueryOwner(t *testing.T) {
+	data := []byte("diversion by dash from: /bin/sh\n" +
+		"google-osconfig-agent: /usr/bin/google_osconfig_agent\n" +
+		"libc6:amd64, libc6:i386: /usr/share/doc/libc6\n")
+	for path, want := range map[string]string{
+		"/usr/bin/google_osconfig_agent": "google-osconfig-agent",
+		"/usr/share/doc/libc6":           "libc6",
+		"/bin/sh":                        "",
+		"/usr/bin/other":                 "",
+	} {
+		if got := parseDpkgQueryOwner(data, path); got != want {
+			t.Errorf("parseDpkgQueryOwner(%q): got %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestParseVerifyOutput(t *testing.T) {
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{"", false},
+		{"??5??????   /usr/bin/google_osconfig_agent\n", true},
+		{"S.5....T.    /usr/bin/google_osconfig_agent\n", true},
+		{"missing     /usr/bin/google_osconfig_agent\n", true},
+		{".M.......    /usr/bin/google_osconfig_agent\n", false},
+		{"??5?????? c /etc/osconfig/config\n", false},
+	}
+	for _, tt := range tests {
+		if got := parseVerifyOutput([]byte(tt.data), "/usr/bin/google_osconfig_agent"); got != tt.want {
+			t.Errorf("parseVerifyOutput(%q): got %t, want %t", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestOwningPackageDeb(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = mockCommandRunner
+	defer func(dq, a bool) { DpkgQueryExists, AptExists = dq, a }(DpkgQueryExists, AptExists)
+	DpkgQueryExists, AptExists = true, true
+
+	path := "/usr/bin/google_osconfig_agent"
+	gomock.InOrder(
+		mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(dpkgQuery, "-S", path))).
+			Return([]byte("google-osconfig-agent: "+path+"\n"), nil, nil),
+		mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(dpkgQuery, append(dpkgQueryArgs, "google-osconfig-agent")...))).
+			Return([]byte(`{"package":"google-osconfig-agent","architecture":"amd64","version":"20240101.00-g1","status":"installed"}`), nil, nil),
+		mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(aptCache, "policy", "google-osconfig-agent"))).
+			Return([]byte("google-osconfig-agent:\n  Installed: 20240101.00-g1\n  Candidate: 20240101.00-g1\n  Version table:\n *** 20240101.00-g1 500\n        500 https://packages.cloud.google.com/apt google-compute-engine-bookworm-stable/main amd64 Packages\n        100 /var/lib/dpkg/status\n"), nil, nil),
+		mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(dpkg, "--verify", "google-osconfig-agent"))).
+			Return([]byte("??5??????   "+path+"\n"), nil, errors.New("exit status 1")),
+		mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(dpkgQuery, "-S", "/opt/agent"))).
+			Return(nil, []byte("dpkg-query: no path found matching pattern /opt/agent"), errors.New("exit status 1")),
+	)
+
+	pkg, err := OwningPackage(testCtx, path)
+	if err != nil {
+		t.Fatalf("OwningPackage: %v", err)
+	}
+	if pkg == nil || pkg.Name != "google-osconfig-agent" || pkg.Version != "20240101.00-g1" {
+		t.Fatalf("OwningPackage: got %v, want google-osconfig-agent 20240101.00-g1", pkg)
+	}
+	if want := "https://packages.cloud.google.com/apt google-compute-engine-bookworm-stable/main"; pkg.Repository != want {
+		t.Errorf("OwningPackage: got repository %q, want %q", pkg.Repository, want)
+	}
+	modified, err := PackageFileModified(testCtx, pkg, path)
+	if err != nil || !modified {
+		t.Errorf("PackageFileModified: got %t, %v, want true", modified, err)
+	}
+
+	if pkg, err := OwningPackage(testCtx, "/opt/agent"); pkg != nil || err != nil {
+		t.Errorf("OwningPackage of an unowned file: got %v, %v, want nil", pkg, err)
+	}
+}
@@ -17,14 +17,21 @@
 package packages
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
 	"github.com/GoogleCloudPlatform/osconfig/osinfo"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 )
@@ -38,17 +45,26 @@ var (
 	DpkgQueryExists bool
 	// YumExists indicates whether yum is installed.
 	YumExists bool
+	// DnfExists indicates whether dnf is installed.
+	DnfExists bool
 	// ZypperExists indicates whether zypper is installed.
 	ZypperExists bool
+	// PacmanExists indicates whether pacman is installed.
+	PacmanExists bool
+	// FlatpakExists indicates whether flatpak is installed.
+	FlatpakExists bool
 	// RPMExists indicates whether rpm is installed.
 	RPMExists bool
 	// RPMQueryExists indicates whether rpmquery is installed.
 	RPMQueryExists bool
 	// COSPkgInfoExists indicates whether COS package information is available.
 	COSPkgInfoExists bool
-	// GemExists indicates whether gem is installed.
+	// GemExists indicates whether gem is installed for any Ruby.
 	GemExists bool
-	// PipExists indicates whether pip is installed.
+	// NpmExists indicates whether npm is installed for any node.
+	NpmExists bool
+	// PipExists indicates whether a Python interpreter pip may be installed
+	// for was found.
 	PipExists bool
 	// GooGetExists indicates whether googet is installed.
 	GooGetExists bool
@@ -57,7 +73,7 @@ var (
 
 	noarch = osinfo.Architecture("noarch")
 
-	runner = util.CommandRunner(&util.DefaultRunner{})
+	runner = util.CommandRunner(&cLocaleRunner{})
 
 	ptyrunner = util.CommandRunner(&ptyRunner{})
 )
@@ -70,9 +86,14 @@ type Packages struct {
 	Deb                []*PkgInfo            `json:"deb,omitempty"`
 	Zypper             []*PkgInfo            `json:"zypper,omitempty"`
 	ZypperPatches      []*ZypperPatch        `json:"zypperPatches,omitempty"`
+	Pacman             []*PkgInfo            `json:"pacman,omitempty"`
+	Flatpak            []*PkgInfo            `json:"flatpak,omitempty"`
 	COS                []*PkgInfo            `json:"cos,omitempty"`
 	Gem                []*PkgInfo            `json:"gem,omitempty"`
 	Pip                []*PkgInfo            `json:"pip,omitempty"`
+	Npm                []*PkgInfo            `json:"npm,omitempty"`
+	Go                 []*PkgInfo            `json:"go,omitempty"`
+	Cargo              []*PkgInfo            `json:"cargo,omitempty"`
 	GooGet             []*PkgInfo            `json:"googet,omitempty"`
 	WUA                []*WUAPackage         `json:"wua,omitempty"`
 	QFE                []*QFEPackage         `json:"qfe,omitempty"`
@@ -84,6 +105,24 @@ type PkgInfo struct {
 	Name, Arch, RawArch, Version string
 
 	Source Source
+
+	// InstallTime is when an installed package was last installed or
+	// upgraded, nil if the package manager does not record it. Repository
+	// is the repository an installed package came from as named by its
+	// package manager: the apt source, the dnf or zypper repository or the
+	// flatpak remote, empty if unknown.
+	InstallTime *time.Time `json:",omitempty"`
+	Repository  string     `json:",omitempty"`
+
+	// Licenses are the declared licenses, only collected in extended
+	// inventory mode.
+	Licenses []string `json:",omitempty"`
+
+	// Location is where a Go binary or cargo install root was found, see
+	// InstalledBinaries, the Python interpreter or environment of a pip
+	// package, see InstalledPipPackages and InstalledPythonEnvPackages, or
+	// the gem command of a gem, see InstalledGemPackages.
+	Location string `json:",omitempty"`
 }
 
 // Source represents source package from which binary package was built.
@@ -114,6 +153,22 @@ type WUAPackage struct {
 	RevisionNumber           int32
 }
 
+// parseWUAUpdates decodes the WUA updates written as JSON by the wuaupdates
+// subprocess, null entries are dropped.
+func parseWUAUpdates(data []byte) ([]*WUAPackage, error) {
+	var wua []*WUAPackage
+	if err := json.Unmarshal(data, &wua); err != nil {
+		return nil, err
+	}
+	pkgs := wua[:0]
+	for _, pkg := range wua {
+		if pkg != nil {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs, nil
+}
+
 // QFEPackage describes a Windows Quick Fix Engineering package.
 type QFEPackage struct {
 	Caption, Description, HotFixID, InstalledOn string
@@ -129,19 +184,121 @@ type WindowsApplication struct {
 }
 
 func run(ctx context.Context, cmd string, args []string) ([]byte, error) {
-	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, cmd, args...))
+	stdout, stderr, err := envFrom(ctx).Runner.Run(ctx, exec.CommandContext(ctx, cmd, args...))
 	if err != nil {
-		return nil, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", cmd, args, err, stdout, stderr)
+		return nil, errcode.Wrap(errcode.PackageManager, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", cmd, args, err, stdout, stderr))
 	}
 	return stdout, nil
 }
 
+// runLines runs cmd calling f for each line of its stdout as it is produced,
+// so large outputs such as every installed package are never held in memory
+// at once. The line passed to f is only valid until f returns. Runners that
+// can't stream have their buffered output split into lines instead.
+func runLines(ctx context.Context, cmd string, args []string, f func([]byte)) error {
+	sr, ok := envFrom(ctx).Runner.(util.StreamingCommandRunner)
+	if !ok {
+		out, err := run(ctx, cmd, args)
+		if err != nil {
+			return err
+		}
+		for _, line := range bytes.Split(out, []byte("\n")) {
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				f(line)
+			}
+		}
+		return nil
+	}
+
+	w := &lineWriter{f: f}
+	stderr, err := sr.RunStreaming(ctx, exec.CommandContext(ctx, cmd, args...), w)
+	if err != nil {
+		return errcode.Wrap(errcode.PackageManager, fmt.Errorf("error running %s with args %q: %v, stderr: %q", cmd, args, err, stderr))
+	}
+	w.flush()
+	return nil
+}
+
+// lineWriter is an io.Writer calling f for each complete, non blank, line
+// written, only a partial trailing line is buffered.
+type lineWriter struct {
+	f   func([]byte)
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			break
+		}
+		line := p[:i]
+		if len(w.buf) > 0 {
+			w.buf = append(w.buf, line...)
+			line = w.buf
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			w.f(line)
+		}
+		w.buf = w.buf[:0]
+		p = p[i+1:]
+	}
+	w.buf = append(w.buf, p...)
+	return n, nil
+}
+
+func (w *lineWriter) flush() {
+	if line := bytes.TrimSpace(w.buf); len(line) > 0 {
+		w.f(line)
+	}
+	w.buf = w.buf[:0]
+}
+
 func runWithDeadline(ctx context.Context, timeout time.Duration, cmd string, args []string) ([]byte, error) {
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	return run(ctxWithTimeout, cmd, args)
 }
 
+// pathPatterns returns glob in each absolute directory of PATH, to find the
+// commands installed outside of the common locations.
+func pathPatterns(glob string) []string {
+	var patterns []string
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if filepath.IsAbs(dir) {
+			patterns = append(patterns, filepath.Join(dir, glob))
+		}
+	}
+	return patterns
+}
+
+// findCommands returns the executables matching the glob patterns whose base
+// name matches name. Paths are resolved through symlinks so aliases such as
+// python3 and python3.11 are only returned once.
+func findCommands(patterns []string, name *regexp.Regexp) []string {
+	var cmds []string
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			if !name.MatchString(filepath.Base(match)) {
+				continue
+			}
+			path, err := filepath.EvalSymlinks(match)
+			if err != nil || seen[path] {
+				continue
+			}
+			if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() || fi.Mode().Perm()&0111 == 0 {
+				continue
+			}
+			seen[path] = true
+			cmds = append(cmds, path)
+		}
+	}
+	return cmds
+}
+
 func formatFieldsMappingToFormattingString(fieldsMapping map[string]string) string {
 	fieldsDescriptors := make([]string, 0, len(fieldsMapping))
 
@@ -169,9 +326,16 @@ type packageMetadata struct {
 	Status        string `json:"status"`
 	SourceName    string `json:"source_name"`
 	SourceVersion string `json:"source_version"`
+	InstallTime   string `json:"install_time"`
 }
 
 func pkgInfoFromPackageMetadata(pm packageMetadata) *PkgInfo {
+	var installTime *time.Time
+	// Install time is in seconds since the epoch, unset or "(none)" is ignored.
+	if t, err := strconv.ParseInt(pm.InstallTime, 10, 64); err == nil && t > 0 {
+		it := time.Unix(t, 0).UTC()
+		installTime = &it
+	}
 	return &PkgInfo{
 		Name:    pm.Package,
 		Arch:    osinfo.Architecture(pm.Architecture),
@@ -180,14 +344,67 @@ func pkgInfoFromPackageMetadata(pm packageMetadata) *PkgInfo {
 			Name:    pm.SourceName,
 			Version: pm.SourceVersion,
 		},
+		InstallTime: installTime,
+	}
+}
+
+// setDebRepositories sets the Repository of installed deb packages from apt,
+// they are still reported without it if that fails.
+func setDebRepositories(ctx context.Context, env *Env, collectors Collectors, pkgs []*PkgInfo) {
+	if len(pkgs) == 0 || !env.AptExists || !collectors.Enabled("apt") {
+		return
+	}
+	repos, err := aptInstalledRepositories(ctx, pkgs)
+	if err != nil {
+		clog.Debugf(ctx, "Error getting apt package repositories: %v", err)
+		return
 	}
+	setRepositories(pkgs, repos, func(pkg *PkgInfo) string { return pkg.Name })
+}
+
+// setRPMRepositories sets the Repository of installed rpm packages from dnf
+// or zypper, they are still reported without it if that fails.
+func setRPMRepositories(ctx context.Context, env *Env, collectors Collectors, pkgs []*PkgInfo) {
+	var installedRepositories func(context.Context) (map[string]string, error)
+	switch {
+	case len(pkgs) == 0:
+		return
+	case env.DnfExists && collectors.Enabled("yum"):
+		installedRepositories = dnfInstalledRepositories
+	case env.ZypperExists && collectors.Enabled("zypper"):
+		installedRepositories = zypperInstalledRepositories
+	default:
+		return
+	}
+	repos, err := installedRepositories(ctx)
+	if err != nil {
+		clog.Debugf(ctx, "Error getting rpm package repositories: %v", err)
+		return
+	}
+	setRepositories(pkgs, repos, nameArch)
+}
+
+// setRepositories sets the Repository of pkgs from repos, keyed by key.
+func setRepositories(pkgs []*PkgInfo, repos map[string]string, key func(*PkgInfo) string) {
+	for _, pkg := range pkgs {
+		if repo, ok := repos[key(pkg)]; ok {
+			pkg.Repository = repo
+		}
+	}
+}
+
+// nameArch is the key of a package in the repositories returned by dnf and
+// zypper.
+func nameArch(pkg *PkgInfo) string {
+	return pkg.Name + "." + pkg.Arch
 }
 
 type ptyRunner struct{}
 
 func (p *ptyRunner) Run(ctx context.Context, cmd *exec.Cmd) ([]byte, []byte, error) {
 	clog.Debugf(ctx, "Running %q with args %q\n", cmd.Path, cmd.Args[1:])
-	stdout, stderr, err := runWithPty(cmd)
+	util.SetCLocale(cmd)
+	stdout, stderr, err := runWithPty(util.WithoutStallCheck(ctx), cmd)
 	clog.Debugf(ctx, "%s %q output:\n%s", cmd.Path, cmd.Args[1:], strings.ReplaceAll(string(stdout), "\n", "\n "))
 	return stdout, stderr, err
 }
@@ -22,12 +22,17 @@ import (
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 )
 
+// DefaultCollectors are the inventory collectors run by default, one per
+// package manager and the repository health check.
+var DefaultCollectors = []string{"rpm", "yum", "zypper", "deb", "apt", "pacman", "flatpak", "cos", "gem", "pip", "npm", RepositoriesCollector}
+
 // GetPackageUpdates gets all available package updates from any known
-// installed package manager.
-func GetPackageUpdates(ctx context.Context) (*Packages, error) {
+// installed package manager with an enabled collector.
+func GetPackageUpdates(ctx context.Context, collectors Collectors) (*Packages, error) {
+	env := envFrom(ctx)
 	pkgs := Packages{}
 	var errs []string
-	if AptExists {
+	if env.AptExists && collectors.Enabled("apt") {
 		apt, err := AptUpdates(ctx, AptGetUpgradeType(AptGetFullUpgrade), AptGetUpgradeShowNew(false))
 		if err != nil {
 			msg := fmt.Sprintf("error getting apt updates: %v", err)
@@ -37,7 +42,7 @@ func GetPackageUpdates(ctx context.Context) (*Packages, error) {
 			pkgs.Apt = apt
 		}
 	}
-	if YumExists {
+	if env.YumExists && collectors.Enabled("yum") {
 		yum, err := YumUpdates(ctx)
 		if err != nil {
 			msg := fmt.Sprintf("error getting yum updates: %v", err)
@@ -47,7 +52,7 @@ func GetPackageUpdates(ctx context.Context) (*Packages, error) {
 			pkgs.Yum = yum
 		}
 	}
-	if ZypperExists {
+	if env.ZypperExists && collectors.Enabled("zypper") {
 		zypper, err := ZypperUpdates(ctx)
 		if err != nil {
 			msg := fmt.Sprintf("error getting zypper updates: %v", err)
@@ -65,7 +70,27 @@ func GetPackageUpdates(ctx context.Context) (*Packages, error) {
 			pkgs.ZypperPatches = zypperPatches
 		}
 	}
-	if GemExists {
+	if env.PacmanExists && collectors.Enabled("pacman") {
+		pacman, err := PacmanUpdates(ctx)
+		if err != nil {
+			msg := fmt.Sprintf("error getting pacman updates: %v", err)
+			clog.Debugf(ctx, "Error: %s", msg)
+			errs = append(errs, msg)
+		} else {
+			pkgs.Pacman = pacman
+		}
+	}
+	if env.FlatpakExists && collectors.Enabled("flatpak") {
+		flatpak, err := FlatpakUpdates(ctx)
+		if err != nil {
+			msg := fmt.Sprintf("error getting flatpak updates: %v", err)
+			clog.Debugf(ctx, "Error: %s", msg)
+			errs = append(errs, msg)
+		} else {
+			pkgs.Flatpak = flatpak
+		}
+	}
+	if env.GemExists && collectors.Enabled("gem") {
 		gem, err := GemUpdates(ctx)
 		if err != nil {
 			msg := fmt.Sprintf("error getting gem updates: %v", err)
@@ -74,7 +99,7 @@ func GetPackageUpdates(ctx context.Context) (*Packages, error) {
 			pkgs.Gem = gem
 		}
 	}
-	if PipExists {
+	if env.PipExists && collectors.Enabled("pip") {
 		pip, err := PipUpdates(ctx)
 		if err != nil {
 			msg := fmt.Sprintf("error getting pip updates: %v", err)
@@ -83,6 +108,15 @@ func GetPackageUpdates(ctx context.Context) (*Packages, error) {
 			pkgs.Pip = pip
 		}
 	}
+	if env.NpmExists && collectors.Enabled("npm") {
+		npm, err := NpmUpdates(ctx)
+		if err != nil {
+			msg := fmt.Sprintf("error getting npm updates: %v", err)
+			clog.Debugf(ctx, "Error: %s", msg)
+		} else {
+			pkgs.Npm = npm
+		}
+	}
 
 	var err error
 	if len(errs) != 0 {
@@ -92,11 +126,12 @@ func GetPackageUpdates(ctx context.Context) (*Packages, error) {
 }
 
 // GetInstalledPackages gets all installed packages from any known installed
-// package manager.
-func GetInstalledPackages(ctx context.Context) (*Packages, error) {
+// package manager with an enabled collector.
+func GetInstalledPackages(ctx context.Context, collectors Collectors) (*Packages, error) {
+	env := envFrom(ctx)
 	pkgs := &Packages{}
 	var errs []string
-	if RPMQueryExists {
+	if env.RPMQueryExists && collectors.Enabled("rpm") {
 		rpm, err := InstalledRPMPackages(ctx)
 		if err != nil {
 			msg := fmt.Sprintf("error listing installed rpm packages: %v", err)
@@ -104,9 +139,10 @@ func GetInstalledPackages(ctx context.Context) (*Packages, error) {
 			errs = append(errs, msg)
 		} else {
 			pkgs.Rpm = rpm
+			setRPMRepositories(ctx, env, collectors, rpm)
 		}
 	}
-	if ZypperExists {
+	if env.ZypperExists && collectors.Enabled("zypper") {
 		zypperPatches, err := ZypperInstalledPatches(ctx)
 		if err != nil {
 			msg := fmt.Sprintf("error getting zypper installed patches: %v", err)
@@ -116,7 +152,7 @@ func GetInstalledPackages(ctx context.Context) (*Packages, error) {
 			pkgs.ZypperPatches = zypperPatches
 		}
 	}
-	if DpkgQueryExists {
+	if env.DpkgQueryExists && collectors.Enabled("deb") {
 		deb, err := InstalledDebPackages(ctx)
 		if err != nil {
 			msg := fmt.Sprintf("error listing installed deb packages: %v", err)
@@ -124,9 +160,30 @@ func GetInstalledPackages(ctx context.Context) (*Packages, error) {
 			errs = append(errs, msg)
 		} else {
 			pkgs.Deb = deb
+			setDebRepositories(ctx, env, collectors, deb)
 		}
 	}
-	if COSPkgInfoExists {
+	if env.PacmanExists && collectors.Enabled("pacman") {
+		pacman, err := InstalledPacmanPackages(ctx)
+		if err != nil {
+			msg := fmt.Sprintf("error listing installed pacman packages: %v", err)
+			clog.Debugf(ctx, "Error: %s", msg)
+			errs = append(errs, msg)
+		} else {
+			pkgs.Pacman = pacman
+		}
+	}
+	if env.FlatpakExists && collectors.Enabled("flatpak") {
+		flatpak, err := InstalledFlatpakApps(ctx)
+		if err != nil {
+			msg := fmt.Sprintf("error listing installed flatpak applications: %v", err)
+			clog.Debugf(ctx, "Error: %s", msg)
+			errs = append(errs, msg)
+		} else {
+			pkgs.Flatpak = flatpak
+		}
+	}
+	if env.COSPkgInfoExists && collectors.Enabled("cos") {
 		cos, err := InstalledCOSPackages()
 		if err != nil {
 			msg := fmt.Sprintf("error listing installed COS packages: %v", err)
@@ -136,7 +193,7 @@ func GetInstalledPackages(ctx context.Context) (*Packages, error) {
 			pkgs.COS = cos
 		}
 	}
-	if GemExists {
+	if env.GemExists && collectors.Enabled("gem") {
 		gem, err := InstalledGemPackages(ctx)
 		if err != nil {
 			msg := fmt.Sprintf("error listing installed gem packages: %v", err)
@@ -145,7 +202,7 @@ func GetInstalledPackages(ctx context.Context) (*Packages, error) {
 			pkgs.Gem = gem
 		}
 	}
-	if PipExists {
+	if env.PipExists && collectors.Enabled("pip") {
 		pip, err := InstalledPipPackages(ctx)
 		if err != nil {
 			msg := fmt.Sprintf("error listing installed pip packages: %v", err)
@@ -154,6 +211,15 @@ func GetInstalledPackages(ctx context.Context) (*Packages, error) {
 			pkgs.Pip = pip
 		}
 	}
+	if env.NpmExists && collectors.Enabled("npm") {
+		npm, err := InstalledNpmPackages(ctx)
+		if err != nil {
+			msg := fmt.Sprintf("error listing installed npm packages: %v", err)
+			clog.Debugf(ctx, "Error: %s", msg)
+		} else {
+			pkgs.Npm = npm
+		}
+	}
 
 	var err error
 	if len(errs) != 0 {
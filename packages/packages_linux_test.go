@@ -0,0 +1,40 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGetInstalledPackagesFlatpakCollector(t *testing.T) {
+	ctx := WithEnv(context.Background(), &Env{FlatpakExists: true, Runner: cmdRunner{"flatpak": "org.gimp.GIMP\tx86_64\tstable\t2.10.36\tflathub\n"}})
+	for _, tt := range []struct {
+		desc       string
+		collectors Collectors
+		want       int
+	}{
+		{"default", nil, 1},
+		{"disabled", Collectors{"rpm": true, "deb": true}, 0},
+	} {
+		pkgs, err := GetInstalledPackages(ctx, tt.collectors)
+		if err != nil {
+			t.Fatalf("%s: GetInstalledPackages: %v", tt.desc, err)
+		}
+		if len(pkgs.Flatpak) != tt.want {
+			t.Errorf("%s: got %d flatpak applications, want %d", tt.desc, len(pkgs.Flatpak), tt.want)
+		}
+	}
+}
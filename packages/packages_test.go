@@ -16,11 +16,15 @@ package packages
 
 import (
 	"context"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"testing"
 
+	"github.com/GoogleCloudPlatform/osconfig/util"
 	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
 	"github.com/golang/mock/gomock"
 )
@@ -84,3 +88,73 @@ func helperLoadBytes(name string) ([]byte, error) {
 	}
 	return bytes, nil
 }
+
+// streamingRunner is a util.StreamingCommandRunner writing stdout in chunks
+// of chunkSize bytes, splitting lines across writes.
+type streamingRunner struct {
+	stdout    []byte
+	chunkSize int
+}
+
+func (r *streamingRunner) Run(_ context.Context, _ *exec.Cmd) ([]byte, []byte, error) {
+	return r.stdout, nil, nil
+}
+
+func (r *streamingRunner) RunStreaming(_ context.Context, _ *exec.Cmd, w io.Writer) ([]byte, error) {
+	for out := r.stdout; len(out) > 0; {
+		n := min(r.chunkSize, len(out))
+		if _, err := w.Write(out[:n]); err != nil {
+			return nil, err
+		}
+		out = out[n:]
+	}
+	return nil, nil
+}
+
+func TestRunLines(t *testing.T) {
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	stdout := []byte("first\n\n  second  \r\nthird line\nlast")
+	want := []string{"first", "second", "third line", "last"}
+
+	for _, r := range []util.CommandRunner{
+		&streamingRunner{stdout: stdout, chunkSize: 1},
+		&streamingRunner{stdout: stdout, chunkSize: 4},
+		&streamingRunner{stdout: stdout, chunkSize: len(stdout)},
+		// Runners that don't stream fall back to Run.
+		struct{ util.CommandRunner }{&streamingRunner{stdout: stdout}},
+	} {
+		runner = r
+		var got []string
+		if err := runLines(testCtx, "cmd", nil, func(line []byte) { got = append(got, string(line)) }); err != nil {
+			t.Fatalf("runLines: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("runLines with %T: got %q, want %q", r, got, want)
+		}
+	}
+}
+
+func TestCLocaleRunner(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	t.Setenv("LANG", "de_DE.UTF-8")
+	t.Setenv("LC_MESSAGES", "fr_FR.UTF-8")
+
+	r := &cLocaleRunner{}
+	stdout, _, err := r.Run(testCtx, exec.Command("/bin/sh", "-c", `echo "$LANG $LC_ALL $LC_MESSAGES"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(stdout), "C C \n"; got != want {
+		t.Errorf("Run: got locale %q, want %q", got, want)
+	}
+
+	var out []string
+	if err := runLines(WithEnv(testCtx, &Env{Runner: r}), "/bin/sh", []string{"-c", `echo "$LANG $LC_ALL $LC_MESSAGES"`}, func(line []byte) { out = append(out, string(line)) }); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"C C"}; !reflect.DeepEqual(out, want) {
+		t.Errorf("RunStreaming: got locale %q, want %q", out, want)
+	}
+}
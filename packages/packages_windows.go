@@ -15,7 +15,6 @@ package packages
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -47,25 +46,24 @@ func wuaUpdates(ctx context.Context, query string) ([]*WUAPackage, error) {
 		return nil, err
 	}
 
-	var wua []*WUAPackage
-	stdout, stderr, err := runner.Run(ctx, exec.Command(exe, "wuaupdates", query))
+	stdout, stderr, err := envFrom(ctx).Runner.Run(ctx, exec.Command(exe, "wuaupdates", query))
 	if err != nil {
 		return nil, fmt.Errorf("error running agent to query for WUA updates, err: %v, stderr: %q ", err, stderr)
 	}
-	if err := json.Unmarshal(stdout, &wua); err != nil {
-		return nil, err
-	}
-
-	return wua, nil
+	return parseWUAUpdates(stdout)
 }
 
+// DefaultCollectors are the inventory collectors run by default.
+var DefaultCollectors = []string{"googet", "wua", "qfe", "windowsapplication", RepositoriesCollector, AntivirusCollector}
+
 // GetPackageUpdates gets available package updates GooGet as well as any
-// available updates from Windows Update Agent.
-func GetPackageUpdates(ctx context.Context) (*Packages, error) {
+// available updates from Windows Update Agent, for the enabled collectors.
+func GetPackageUpdates(ctx context.Context, collectors Collectors) (*Packages, error) {
+	env := envFrom(ctx)
 	var pkgs Packages
 	var errs []string
 
-	if GooGetExists {
+	if env.GooGetExists && collectors.Enabled("googet") {
 		if googet, err := GooGetUpdates(ctx); err != nil {
 			msg := fmt.Sprintf("error listing googet updates: %v", err)
 			clog.Debugf(ctx, "Error: %s", msg)
@@ -75,14 +73,16 @@ func GetPackageUpdates(ctx context.Context) (*Packages, error) {
 		}
 	}
 
-	clog.Debugf(ctx, "Searching for available WUA updates.")
+	if collectors.Enabled("wua") {
+		clog.Debugf(ctx, "Searching for available WUA updates.")
 
-	if wua, err := wuaUpdates(ctx, "IsInstalled=0"); err != nil {
-		msg := fmt.Sprintf("error listing installed Windows updates: %v", err)
-		clog.Debugf(ctx, "Error: %s", msg)
-		errs = append(errs, msg)
-	} else {
-		pkgs.WUA = wua
+		if wua, err := wuaUpdates(ctx, "IsInstalled=0"); err != nil {
+			msg := fmt.Sprintf("error listing installed Windows updates: %v", err)
+			clog.Debugf(ctx, "Error: %s", msg)
+			errs = append(errs, msg)
+		} else {
+			pkgs.WUA = wua
+		}
 	}
 
 	var err error
@@ -94,11 +94,12 @@ func GetPackageUpdates(ctx context.Context) (*Packages, error) {
 
 // GetInstalledPackages gets all installed GooGet packages and Windows updates.
 // Windows updates are read from Windows Update Agent and Win32_QuickFixEngineering.
-func GetInstalledPackages(ctx context.Context) (*Packages, error) {
+// Only the enabled collectors are run.
+func GetInstalledPackages(ctx context.Context, collectors Collectors) (*Packages, error) {
 	var pkgs Packages
 	var errs []string
 
-	if util.Exists(googet) {
+	if util.Exists(googet) && collectors.Enabled("googet") {
 		if googet, err := InstalledGooGetPackages(ctx); err != nil {
 			msg := fmt.Sprintf("error listing installed googet packages: %v", err)
 			clog.Debugf(ctx, "Error: %s", msg)
@@ -108,31 +109,37 @@ func GetInstalledPackages(ctx context.Context) (*Packages, error) {
 		}
 	}
 
-	clog.Debugf(ctx, "Searching for installed WUA updates.")
+	if collectors.Enabled("wua") {
+		clog.Debugf(ctx, "Searching for installed WUA updates.")
 
-	if wua, err := wuaUpdates(ctx, "IsInstalled=1"); err != nil {
-		msg := fmt.Sprintf("error listing installed Windows updates: %v", err)
-		clog.Debugf(ctx, "Error: %s", msg)
-		errs = append(errs, msg)
-	} else {
-		pkgs.WUA = wua
+		if wua, err := wuaUpdates(ctx, "IsInstalled=1"); err != nil {
+			msg := fmt.Sprintf("error listing installed Windows updates: %v", err)
+			clog.Debugf(ctx, "Error: %s", msg)
+			errs = append(errs, msg)
+		} else {
+			pkgs.WUA = wua
+		}
 	}
 
-	if qfe, err := QuickFixEngineering(ctx); err != nil {
-		msg := fmt.Sprintf("error listing installed QuickFixEngineering updates: %v", err)
-		clog.Debugf(ctx, "Error: %s", msg)
-		errs = append(errs, msg)
-	} else {
-		pkgs.QFE = qfe
+	if collectors.Enabled("qfe") {
+		if qfe, err := QuickFixEngineering(ctx); err != nil {
+			msg := fmt.Sprintf("error listing installed QuickFixEngineering updates: %v", err)
+			clog.Debugf(ctx, "Error: %s", msg)
+			errs = append(errs, msg)
+		} else {
+			pkgs.QFE = qfe
+		}
 	}
 
-	clog.Debugf(ctx, "Listing Windows Applications.")
-	if windowsApplications, err := GetWindowsApplications(ctx); err != nil {
-		msg := fmt.Sprintf("error listing installed Windows Applications: %v", err)
-		clog.Debugf(ctx, "Error: %s", msg)
-		errs = append(errs, msg)
-	} else {
-		pkgs.WindowsApplication = windowsApplications
+	if collectors.Enabled("windowsapplication") {
+		clog.Debugf(ctx, "Listing Windows Applications.")
+		if windowsApplications, err := GetWindowsApplications(ctx); err != nil {
+			msg := fmt.Sprintf("error listing installed Windows Applications: %v", err)
+			clog.Debugf(ctx, "Error: %s", msg)
+			errs = append(errs, msg)
+		} else {
+			pkgs.WindowsApplication = windowsApplications
+		}
 	}
 
 	var err error
@@ -0,0 +1,193 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/journal"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	pacman string
+	// checkupdates, from pacman-contrib, lists updates from a copy of the
+	// sync database, so it does not cause a partial upgrade.
+	checkupdates string
+
+	pacmanInstallArgs    = []string{"--sync", "--noconfirm", "--needed"}
+	pacmanUpgradeArgs    = []string{"--sync", "--refresh", "--sysupgrade", "--noconfirm", "--needed"}
+	pacmanRemoveArgs     = []string{"--remove", "--noconfirm"}
+	pacmanQueryInfoArgs  = []string{"--query", "--info"}
+	checkupdatesArgs     = []string{}
+	errNoCheckupdates    = errors.New("checkupdates not found, install pacman-contrib to list pacman updates")
+	pacmanInstallDateFmt = time.ANSIC
+)
+
+func init() {
+	if runtime.GOOS != "windows" {
+		pacman = "/usr/bin/pacman"
+		checkupdates = "/usr/bin/checkupdates"
+	}
+	PacmanExists = util.Exists(pacman)
+}
+
+// pacmanArch returns the architecture of a pacman package, any is used for
+// architecture independent packages.
+func pacmanArch(arch string) string {
+	if arch == "any" {
+		return noarch
+	}
+	return osinfo.Architecture(arch)
+}
+
+// InstallPacmanPackages installs pacman packages, installed packages are
+// not reinstalled.
+func InstallPacmanPackages(ctx context.Context, pkgs []string) error {
+	args, err := pkgArgs(pacmanInstallArgs, pkgs)
+	if err != nil {
+		return err
+	}
+	_, err = runChange(ctx, pacman, args)
+	journal.Record(ctx, journal.PackageInstall, strings.Join(pkgs, " "), err)
+	return err
+}
+
+// UpgradePacmanPackages upgrades pacman packages. Arch does not support
+// partial upgrades, the packages are upgraded with the rest of the system
+// from refreshed sync databases.
+func UpgradePacmanPackages(ctx context.Context, pkgs []string) error {
+	args, err := pkgArgs(pacmanUpgradeArgs, pkgs)
+	if err != nil {
+		return err
+	}
+	_, err = runChange(ctx, pacman, args)
+	journal.Record(ctx, journal.PackageInstall, strings.Join(pkgs, " "), err)
+	return err
+}
+
+// RemovePacmanPackages removes pacman packages.
+func RemovePacmanPackages(ctx context.Context, pkgs []string) error {
+	args, err := pkgArgs(pacmanRemoveArgs, pkgs)
+	if err != nil {
+		return err
+	}
+	_, err = runChange(ctx, pacman, args)
+	journal.Record(ctx, journal.PackageRemove, strings.Join(pkgs, " "), err)
+	return err
+}
+
+func parsePacmanUpdates(data []byte) []*PkgInfo {
+	/*
+		linux 6.8.4.arch1-1 -> 6.8.5.arch1-1
+		openssl 3.2.1-1 -> 3.3.0-1
+	*/
+	var pkgs []*PkgInfo
+	for _, ln := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		flds := bytes.Fields(ln)
+		if len(flds) != 4 || string(flds[2]) != "->" {
+			continue
+		}
+		pkgs = append(pkgs, &PkgInfo{Name: string(flds[0]), Version: string(flds[3])})
+	}
+	return pkgs
+}
+
+// PacmanUpdates queries for all available pacman updates, it needs
+// checkupdates from pacman-contrib.
+func PacmanUpdates(ctx context.Context) ([]*PkgInfo, error) {
+	if !util.Exists(checkupdates) {
+		return nil, errNoCheckupdates
+	}
+	stdout, stderr, err := envFrom(ctx).Runner.Run(ctx, exec.CommandContext(ctx, checkupdates, checkupdatesArgs...))
+	// Exit code 2 means there are no updates.
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 2 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error running %s: %v, stdout: %q, stderr: %q", checkupdates, err, stdout, stderr)
+	}
+	return parsePacmanUpdates(stdout), nil
+}
+
+func parseInstalledPacmanPackages(data []byte) []*PkgInfo {
+	/*
+		Name            : bash
+		Version         : 5.2.026-2
+		Description     : The GNU Bourne Again shell
+		Architecture    : x86_64
+		Optional Deps   : bash-completion: for tab completion
+		                  bash-docs: bash documentation
+		Install Date    : Tue Apr  9 10:11:12 2024
+		...
+
+		Name            : ca-certificates
+		...
+	*/
+	var pkgs []*PkgInfo
+	var pkg *PkgInfo
+	for _, ln := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(ln)) == 0 {
+			pkg = nil
+			continue
+		}
+		// Continuation lines of multi line values are indented.
+		if ln[0] == ' ' {
+			continue
+		}
+		key, value, ok := bytes.Cut(ln, []byte(" : "))
+		if !ok {
+			continue
+		}
+		v := string(bytes.TrimSpace(value))
+		switch string(bytes.TrimSpace(key)) {
+		case "Name":
+			pkg = &PkgInfo{Name: v}
+			pkgs = append(pkgs, pkg)
+		case "Version":
+			if pkg != nil {
+				pkg.Version = v
+			}
+		case "Architecture":
+			if pkg != nil {
+				pkg.Arch, pkg.RawArch = pacmanArch(v), v
+			}
+		case "Install Date":
+			// Dates are in the local time zone, in the C locale format.
+			if t, err := time.ParseInLocation(pacmanInstallDateFmt, v, time.Local); pkg != nil && err == nil {
+				t = t.UTC()
+				pkg.InstallTime = &t
+			}
+		}
+	}
+	return pkgs
+}
+
+// InstalledPacmanPackages queries for all installed pacman packages.
+func InstalledPacmanPackages(ctx context.Context) ([]*PkgInfo, error) {
+	out, err := run(ctx, pacman, pacmanQueryInfoArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseInstalledPacmanPackages(out), nil
+}
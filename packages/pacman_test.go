@@ -0,0 +1,143 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestInstallPacmanPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(pacman, append(pacmanInstallArgs, pkgs...)...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+	if err := InstallPacmanPackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), errors.New("error")).Times(1)
+	if err := InstallPacmanPackages(testCtx, pkgs); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestUpgradePacmanPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(pacman, append([]string{"--sync", "--refresh", "--sysupgrade", "--noconfirm", "--needed"}, pkgs...)...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+	if err := UpgradePacmanPackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), errors.New("error")).Times(1)
+	if err := UpgradePacmanPackages(testCtx, pkgs); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestRemovePacmanPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(pacman, append(pacmanRemoveArgs, pkgs...)...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+	if err := RemovePacmanPackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), errors.New("error")).Times(1)
+	if err := RemovePacmanPackages(testCtx, pkgs); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestParsePacmanUpdates(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []*PkgInfo
+	}{
+		{"NormalCase", "linux 6.8.4.arch1-1 -> 6.8.5.arch1-1\nopenssl 3.2.1-1 -> 3.3.0-1\n", []*PkgInfo{{Name: "linux", Version: "6.8.5.arch1-1"}, {Name: "openssl", Version: "3.3.0-1"}}},
+		{"NoPackages", "", nil},
+		{"Junk", "==> ERROR: Cannot fetch updates\nfoo 1.0 2.0\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePacmanUpdates([]byte(tt.data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePacmanUpdates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPacmanUpdates(t *testing.T) {
+	defer func(s string) { checkupdates = s }(checkupdates)
+	checkupdates = filepath.Join(t.TempDir(), "checkupdates")
+	if _, err := PacmanUpdates(testCtx); err != errNoCheckupdates {
+		t.Errorf("PacmanUpdates() without checkupdates: got err %v, want %v", err, errNoCheckupdates)
+	}
+	if err := os.WriteFile(checkupdates, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(checkupdates, checkupdatesArgs...))
+
+	// checkupdates exits with 2 when there are no updates.
+	noUpdates := exec.Command("/bin/sh", "-c", "exit 2").Run()
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(nil, nil, noUpdates).Times(1)
+	if got, err := PacmanUpdates(testCtx); err != nil || got != nil {
+		t.Errorf("PacmanUpdates() = %v, %v, want nil, nil", got, err)
+	}
+
+	failed := exec.Command("/bin/sh", "-c", "exit 1").Run()
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(nil, []byte("==> ERROR: Cannot fetch updates"), failed).Times(1)
+	if _, err := PacmanUpdates(testCtx); err == nil {
+		t.Errorf("did not get expected error")
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("linux 6.8.4.arch1-1 -> 6.8.5.arch1-1\n"), nil, nil).Times(1)
+	got, err := PacmanUpdates(testCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []*PkgInfo{{Name: "linux", Version: "6.8.5.arch1-1"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PacmanUpdates() = %v, want %v", got, want)
+	}
+}
@@ -17,26 +17,54 @@ package packages
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
 	"runtime"
+	"strings"
 	"time"
 
-	"github.com/GoogleCloudPlatform/osconfig/util"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
 )
 
 var (
-	pip string
+	// pythons are the Python interpreters pip is run with, as python -m pip,
+	// so that each interpreter's packages are listed once.
+	pythons []string
+
+	pythonPatterns = []string{
+		"/usr/bin/python*",
+		"/usr/local/bin/python*",
+		"/opt/rh/*/root/usr/bin/python*",
+		"/usr/local/pyenv/versions/*/bin/python*",
+		"/opt/pyenv/versions/*/bin/python*",
+	}
+	pythonName = regexp.MustCompile(`^python([23](\.[0-9]+)?)?$`)
 
 	pipListArgs        = []string{"list", "--format=json"}
-	pipOutdatedArgs    = append(pipListArgs, "--outdated")
+	pipOutdatedArgs    = []string{"list", "--format=json", "--outdated"}
 	pipListTimeout     = 15 * time.Second
 	pipOutdatedTimeout = 15 * time.Second
+
+	// pip before 9.0 has no --format option and only prints the legacy
+	// format.
+	pipLegacyListArgs     = []string{"list"}
+	pipLegacyOutdatedArgs = []string{"list", "--outdated"}
+
+	// foo (1.2.3)
+	// bar (1.0.0, /src/bar)
+	pipLegacyListRe = regexp.MustCompile(`^(\S+) \(([^,)\s]+)`)
+	// foo (Current: 1.2.3 Latest: 1.3.0 [wheel])
+	pipLegacyOutdatedRe = regexp.MustCompile(`^(\S+) \(.*Latest: ([^\s)]+)`)
+
+	errNoPip = errors.New("pip is not installed for this interpreter")
 )
 
 func init() {
 	if runtime.GOOS != "windows" {
-		pip = "/usr/bin/pip"
+		pythons = findCommands(append(pythonPatterns, pathPatterns("python*")...), pythonName)
 	}
-	PipExists = util.Exists(pip)
+	PipExists = len(pythons) > 0
 }
 
 type pipUpdatesPkg struct {
@@ -49,15 +77,58 @@ type pipInstalledPkg struct {
 	Version string `json:"version"`
 }
 
-// PipUpdates queries for all available pip updates.
-func PipUpdates(ctx context.Context) ([]*PkgInfo, error) {
-	out, err := runWithDeadline(ctx, pipOutdatedTimeout, pip, pipOutdatedArgs)
-	if err != nil {
-		return nil, err
+func runPip(ctx context.Context, timeout time.Duration, python string, args []string) ([]byte, error) {
+	out, err := runWithDeadline(ctx, timeout, python, append([]string{"-m", "pip"}, args...))
+	if err != nil && strings.Contains(err.Error(), "No module named pip") {
+		return nil, errNoPip
+	}
+	return out, err
+}
+
+// pipPackages runs pip with args for each Python interpreter and parses the
+// output with parse, falling back to the legacy args and parser for old
+// versions of pip. An error is only returned if pip failed for every
+// interpreter it is installed for.
+func pipPackages(ctx context.Context, timeout time.Duration, args, legacyArgs []string, parse, parseLegacy func([]byte) ([]*PkgInfo, error)) ([]*PkgInfo, error) {
+	var pkgs []*PkgInfo
+	var errs []string
+	var ok bool
+	for _, python := range pythons {
+		out, err := runPip(ctx, timeout, python, args)
+		p := parse
+		if err != nil && err != errNoPip {
+			if legacyOut, legacyErr := runPip(ctx, timeout, python, legacyArgs); legacyErr == nil {
+				out, err, p = legacyOut, nil, parseLegacy
+			}
+		}
+		if err == errNoPip {
+			clog.Debugf(ctx, "No pip installed for %s.", python)
+			continue
+		}
+		var found []*PkgInfo
+		if err == nil {
+			found, err = p(out)
+		}
+		if err != nil {
+			clog.Debugf(ctx, "Error running pip for %s: %v", python, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", python, err))
+			continue
+		}
+		ok = true
+		for _, pkg := range found {
+			pkg.Location = python
+		}
+		pkgs = append(pkgs, found...)
+	}
+	if !ok && len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\n"))
 	}
+	return pkgs, nil
+}
 
+func parsePipUpdates(data []byte) ([]*PkgInfo, error) {
 	var pipUpdates []pipUpdatesPkg
-	if err := json.Unmarshal(out, &pipUpdates); err != nil {
+	if err := json.Unmarshal(data, &pipUpdates); err != nil {
 		return nil, err
 	}
 
@@ -65,26 +136,43 @@ func PipUpdates(ctx context.Context) ([]*PkgInfo, error) {
 	for _, pkg := range pipUpdates {
 		pkgs = append(pkgs, &PkgInfo{Name: pkg.Name, Arch: noarch, Version: pkg.LatestVersion})
 	}
-
 	return pkgs, nil
 }
 
-// InstalledPipPackages queries for all installed pip packages.
-func InstalledPipPackages(ctx context.Context) ([]*PkgInfo, error) {
-	out, err := runWithDeadline(ctx, pipListTimeout, pip, pipListArgs)
-	if err != nil {
-		return nil, err
-	}
-
-	var pipUpdates []pipInstalledPkg
-	if err := json.Unmarshal(out, &pipUpdates); err != nil {
+func parseInstalledPipPackages(data []byte) ([]*PkgInfo, error) {
+	var pipInstalled []pipInstalledPkg
+	if err := json.Unmarshal(data, &pipInstalled); err != nil {
 		return nil, err
 	}
 
 	var pkgs []*PkgInfo
-	for _, pkg := range pipUpdates {
+	for _, pkg := range pipInstalled {
 		pkgs = append(pkgs, &PkgInfo{Name: pkg.Name, Arch: noarch, Version: pkg.Version})
 	}
-
 	return pkgs, nil
 }
+
+// parsePipLegacy parses the legacy pip list format with re, which captures
+// the name and version.
+func parsePipLegacy(re *regexp.Regexp) func([]byte) ([]*PkgInfo, error) {
+	return func(data []byte) ([]*PkgInfo, error) {
+		var pkgs []*PkgInfo
+		for _, ln := range strings.Split(string(data), "\n") {
+			if m := re.FindStringSubmatch(strings.TrimSpace(ln)); m != nil {
+				pkgs = append(pkgs, &PkgInfo{Name: m[1], Arch: noarch, Version: m[2]})
+			}
+		}
+		return pkgs, nil
+	}
+}
+
+// PipUpdates queries for all available pip updates.
+func PipUpdates(ctx context.Context) ([]*PkgInfo, error) {
+	return pipPackages(ctx, pipOutdatedTimeout, pipOutdatedArgs, pipLegacyOutdatedArgs, parsePipUpdates, parsePipLegacy(pipLegacyOutdatedRe))
+}
+
+// InstalledPipPackages queries for all installed pip packages. The Location
+// of each package is the Python interpreter it is installed for.
+func InstalledPipPackages(ctx context.Context) ([]*PkgInfo, error) {
+	return pipPackages(ctx, pipListTimeout, pipListArgs, pipLegacyListArgs, parseInstalledPipPackages, parsePipLegacy(pipLegacyListRe))
+}
@@ -0,0 +1,124 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestParsePipLegacy(t *testing.T) {
+	tests := []struct {
+		name string
+		re   string
+		data string
+		want []*PkgInfo
+	}{
+		{"List", "list", "requests (2.9.1)\nmypkg (0.1, /src/mypkg)\n", []*PkgInfo{
+			{Name: "requests", Arch: noarch, Version: "2.9.1"},
+			{Name: "mypkg", Arch: noarch, Version: "0.1"},
+		}},
+		{"Outdated", "outdated", "requests (Current: 2.9.1 Latest: 2.31.0 [wheel])\nsix (Current: 1.9.0 Latest: 1.16.0)\n", []*PkgInfo{
+			{Name: "requests", Arch: noarch, Version: "2.31.0"},
+			{Name: "six", Arch: noarch, Version: "1.16.0"},
+		}},
+		{"Empty", "list", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re := pipLegacyListRe
+			if tt.re == "outdated" {
+				re = pipLegacyOutdatedRe
+			}
+			got, err := parsePipLegacy(re)([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePipLegacy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstalledPipPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	defer func(p []string) { pythons = p }(pythons)
+	pythons = []string{"/usr/bin/python3.11", "/usr/bin/python2.7", "/usr/local/bin/python3.12"}
+	pipCmd := func(python string, args []string) gomock.Matcher {
+		return utilmocks.EqCmd(exec.Command(python, append([]string{"-m", "pip"}, args...)...))
+	}
+
+	mockCommandRunner.EXPECT().Run(gomock.Any(), pipCmd(pythons[0], pipListArgs)).Return([]byte(`[{"name":"requests","version":"2.31.0"}]`), nil, nil)
+	// pip before 9.0 falls back to the legacy format.
+	mockCommandRunner.EXPECT().Run(gomock.Any(), pipCmd(pythons[1], pipListArgs)).Return(nil, []byte("no such option: --format"), errors.New("exit status 2"))
+	mockCommandRunner.EXPECT().Run(gomock.Any(), pipCmd(pythons[1], pipLegacyListArgs)).Return([]byte("requests (2.9.1)\n"), nil, nil)
+	// Interpreters without pip are skipped.
+	mockCommandRunner.EXPECT().Run(gomock.Any(), pipCmd(pythons[2], pipListArgs)).Return(nil, []byte("/usr/local/bin/python3.12: No module named pip"), errors.New("exit status 1"))
+
+	got, err := InstalledPipPackages(testCtx)
+	if err != nil {
+		t.Fatalf("InstalledPipPackages(): unexpected error: %v", err)
+	}
+	want := []*PkgInfo{
+		{Name: "requests", Arch: noarch, Version: "2.31.0", Location: pythons[0]},
+		{Name: "requests", Arch: noarch, Version: "2.9.1", Location: pythons[1]},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InstalledPipPackages() = %v, want %v", got, want)
+	}
+}
+
+func TestFindPythons(t *testing.T) {
+	td := t.TempDir()
+	usr, venv := filepath.Join(td, "usr"), filepath.Join(td, "venv")
+	for _, dir := range []string{usr, venv} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, mode := range map[string]os.FileMode{
+		filepath.Join(usr, "python3.11"):        0755,
+		filepath.Join(usr, "python3.11-config"): 0755,
+		filepath.Join(usr, "python2"):           0644,
+		filepath.Join(venv, "python3.12"):       0755,
+	} {
+		if err := os.WriteFile(path, nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Aliases of an interpreter are only returned once.
+	if err := os.Symlink("python3.11", filepath.Join(usr, "python3")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", venv+string(filepath.ListSeparator)+"relative/bin")
+
+	got := findCommands(append([]string{filepath.Join(usr, "python*")}, pathPatterns("python*")...), pythonName)
+	want := []string{filepath.Join(usr, "python3.11"), filepath.Join(venv, "python3.12")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findCommands() = %q, want %q", got, want)
+	}
+}
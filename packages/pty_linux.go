@@ -17,6 +17,7 @@ package packages
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -27,6 +28,7 @@ import (
 	"syscall"
 	"unsafe"
 
+	"github.com/GoogleCloudPlatform/osconfig/util"
 	"golang.org/x/sys/unix"
 )
 
@@ -42,7 +44,7 @@ func ioctl(fd, req, arg uintptr) (err error) {
 // See https://bugzilla.redhat.com/show_bug.cgi?id=584525#c21
 // TODO: We should probably look into a thin python shim we can
 // interact with that the utilizes the yum libraries.
-func runWithPty(cmd *exec.Cmd) ([]byte, []byte, error) {
+func runWithPty(ctx context.Context, cmd *exec.Cmd) ([]byte, []byte, error) {
 	// Much of this logic was taken from, without the CGO stuff:
 	// https://golang.org/src/os/signal/signal_cgo_test.go
 
@@ -113,7 +115,7 @@ func runWithPty(cmd *exec.Cmd) ([]byte, []byte, error) {
 		}
 	}()
 
-	cmdErr := cmd.Run()
+	cmdErr := util.RunWithProcessGroup(ctx, cmd)
 
 	if err := tty.Close(); err != nil {
 		return stdout.Bytes(), stderr.Bytes(), err
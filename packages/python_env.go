@@ -0,0 +1,157 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// sitePackagesPatterns are where environments keep their packages, relative
+// to the environment, on Linux and on Windows.
+var sitePackagesPatterns = []string{
+	filepath.Join("lib", "python*", "site-packages"),
+	filepath.Join("Lib", "site-packages"),
+}
+
+// isPythonEnv reports whether dir is a virtualenv or a conda environment.
+func isPythonEnv(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, "pyvenv.cfg")); err == nil {
+		return true
+	}
+	fi, err := os.Stat(filepath.Join(dir, "conda-meta"))
+	return err == nil && fi.IsDir()
+}
+
+// pythonEnvs returns the environments in prefix: the prefix itself, its
+// subdirectories and, for a conda installation, the environments in envs.
+func pythonEnvs(prefix string) []string {
+	candidates := []string{prefix}
+	for _, pattern := range []string{"*", filepath.Join("envs", "*")} {
+		matches, _ := filepath.Glob(filepath.Join(prefix, pattern))
+		candidates = append(candidates, matches...)
+	}
+	var envs []string
+	for _, dir := range candidates {
+		if isPythonEnv(dir) {
+			envs = append(envs, dir)
+		}
+	}
+	return envs
+}
+
+// InstalledPythonEnvPackages lists the Python packages of the conda
+// environments and virtualenvs found under prefixes, which may be glob
+// patterns like /home/*/.venvs or /opt/conda. Each package has the
+// environment as Location.
+//
+// The package metadata in site-packages is read instead of running pip of
+// the environment, so no code from the environments is run.
+func InstalledPythonEnvPackages(ctx context.Context, prefixes []string) []*PkgInfo {
+	var pkgs []*PkgInfo
+	seen := map[string]bool{}
+	for _, pattern := range prefixes {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			clog.Errorf(ctx, "Invalid Python environment prefix %q: %v", pattern, err)
+			continue
+		}
+		for _, prefix := range matches {
+			for _, env := range pythonEnvs(prefix) {
+				if seen[env] {
+					continue
+				}
+				seen[env] = true
+				envPkgs := pythonEnvPackages(ctx, env)
+				clog.Debugf(ctx, "Found %d packages in Python environment %q.", len(envPkgs), env)
+				pkgs = append(pkgs, envPkgs...)
+			}
+		}
+	}
+	return pkgs
+}
+
+func pythonEnvPackages(ctx context.Context, env string) []*PkgInfo {
+	var pkgs []*PkgInfo
+	for _, sp := range sitePackagesPatterns {
+		dirs, _ := filepath.Glob(filepath.Join(env, sp))
+		for _, dir := range dirs {
+			var metadata []string
+			for _, pattern := range []string{"*.dist-info/METADATA", "*.egg-info/PKG-INFO", "*.egg-info"} {
+				matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+				metadata = append(metadata, matches...)
+			}
+			sort.Strings(metadata)
+			for _, path := range metadata {
+				if fi, err := os.Stat(path); err != nil || fi.IsDir() {
+					continue
+				}
+				name, version, err := readPythonMetadata(path)
+				if err != nil {
+					clog.Debugf(ctx, "Error reading Python package metadata %q: %v", path, err)
+					continue
+				}
+				if name == "" {
+					continue
+				}
+				pkgs = append(pkgs, &PkgInfo{Name: name, Arch: noarch, Version: version, Location: env})
+			}
+		}
+	}
+	return pkgs
+}
+
+// readPythonMetadata returns the name and version from the headers of a
+// METADATA or PKG-INFO file.
+func readPythonMetadata(path string) (name, version string, err error) {
+	/*
+		Metadata-Version: 2.1
+		Name: PyYAML
+		Version: 6.0.1
+		Summary: YAML parser and emitter for Python
+
+		<description>
+	*/
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		ln := s.Text()
+		if ln == "" {
+			// End of the headers.
+			break
+		}
+		k, v, ok := strings.Cut(ln, ":")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "Name":
+			name = strings.TrimSpace(v)
+		case "Version":
+			version = strings.TrimSpace(v)
+		}
+	}
+	return name, version, nil
+}
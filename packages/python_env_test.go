@@ -0,0 +1,65 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeTestFiles(t *testing.T, files map[string]string) {
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInstalledPythonEnvPackages(t *testing.T) {
+	dir := t.TempDir()
+	venv := filepath.Join(dir, "home", "user", ".venvs", "web")
+	conda := filepath.Join(dir, "opt", "conda")
+	condaEnv := filepath.Join(conda, "envs", "ml")
+	writeTestFiles(t, map[string]string{
+		filepath.Join(venv, "pyvenv.cfg"): "home = /usr/bin\n",
+		filepath.Join(venv, "lib", "python3.11", "site-packages", "PyYAML-6.0.1.dist-info", "METADATA"):  "Metadata-Version: 2.1\nName: PyYAML\nVersion: 6.0.1\n\nName: not a header\n",
+		filepath.Join(venv, "lib", "python3.11", "site-packages", "legacy-1.0.egg-info"):                 "Metadata-Version: 1.0\nName: legacy\nVersion: 1.0\n",
+		filepath.Join(conda, "conda-meta", "history"):                                                    "",
+		filepath.Join(conda, "lib", "python3.12", "site-packages", "conda-24.1.2.dist-info", "METADATA"): "Name: conda\nVersion: 24.1.2\n",
+		filepath.Join(condaEnv, "conda-meta", "history"):                                                 "",
+		filepath.Join(condaEnv, "Lib", "site-packages", "numpy-1.26.4.dist-info", "METADATA"):            "Name: numpy\nVersion: 1.26.4\n",
+		filepath.Join(condaEnv, "Lib", "site-packages", "broken.dist-info", "METADATA"):                  "Summary: no name\n",
+		// Not an environment.
+		filepath.Join(dir, "home", "user", ".venvs", "notes", "lib", "python3.11", "site-packages", "x-1.dist-info", "METADATA"): "Name: x\nVersion: 1\n",
+	})
+
+	got := InstalledPythonEnvPackages(context.Background(), []string{filepath.Join(dir, "home", "*", ".venvs"), conda, filepath.Join(dir, "missing")})
+	want := []*PkgInfo{
+		{Name: "PyYAML", Arch: noarch, Version: "6.0.1", Location: venv},
+		{Name: "legacy", Arch: noarch, Version: "1.0", Location: venv},
+		{Name: "conda", Arch: noarch, Version: "24.1.2", Location: conda},
+		{Name: "numpy", Arch: noarch, Version: "1.26.4", Location: condaEnv},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("InstalledPythonEnvPackages did not match expectation: (-want +got)\n%s", diff)
+	}
+}
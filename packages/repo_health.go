@@ -0,0 +1,493 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// Repository health statuses, see RepositoryHealth.
+const (
+	RepoOK          = "ok"
+	RepoUnreachable = "unreachable"
+	RepoGPGError    = "gpg_error"
+	// RepoNotChecked is used for repositories that are not served over
+	// http or https, or whose URL has variables that could not be expanded.
+	RepoNotChecked = "not_checked"
+)
+
+const (
+	repoHealthTimeout     = 15 * time.Second
+	repoHealthConcurrency = 4
+	maxRepoMetadataSize   = 16 * 1024 * 1024
+)
+
+var (
+	aptSourcesList  = "/etc/apt/sources.list"
+	aptSourcesDir   = "/etc/apt/sources.list.d"
+	aptTrustedGPG   = "/etc/apt/trusted.gpg"
+	aptTrustedDir   = "/etc/apt/trusted.gpg.d"
+	yumReposDir     = "/etc/yum.repos.d"
+	yumVarsDirs     = []string{"/etc/dnf/vars", "/etc/yum/vars"}
+	zypperReposDir  = "/etc/zypp/repos.d"
+	googetReposDir  = "C:/ProgramData/GooGet/repos"
+	repoHealthHTTP  = &http.Client{Timeout: repoHealthTimeout}
+	repoReleaseVers = func() (string, string) {
+		oi, err := osinfo.Get()
+		if err != nil {
+			return "", ""
+		}
+		major, _, _ := strings.Cut(oi.Version, ".")
+		return major, oi.Version
+	}
+)
+
+// RepositoryHealth is the result of fetching the metadata of a configured
+// package repository, see GetRepositoryHealth.
+type RepositoryHealth struct {
+	// Manager is the package manager, apt, yum, zypper or googet.
+	Manager string
+	// Source is the file the repository is configured in.
+	Source string
+	URL    string
+	// Status is one of RepoOK, RepoUnreachable, RepoGPGError or
+	// RepoNotChecked.
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+// repoCheck is a repository to check, metadata is the URL fetched and
+// verify checks the fetched metadata.
+type repoCheck struct {
+	health   *RepositoryHealth
+	metadata string
+	verify   func(ctx context.Context, body []byte) error
+}
+
+// GetRepositoryHealth fetches the metadata of the repositories configured
+// for the package managers on this system, broken repositories being the
+// most common cause of failed package installs and patches. Apt InRelease
+// signatures are verified with the trusted apt keys, for yum and zypper the
+// repository GPG keys are fetched and parsed.
+func GetRepositoryHealth(ctx context.Context) []*RepositoryHealth {
+	env := envFrom(ctx)
+	var checks []*repoCheck
+	if env.AptExists {
+		checks = append(checks, aptRepoChecks(ctx)...)
+	}
+	if env.YumExists {
+		checks = append(checks, rpmRepoChecks(ctx, "yum", yumReposDir)...)
+	}
+	if env.ZypperExists {
+		checks = append(checks, rpmRepoChecks(ctx, "zypper", zypperReposDir)...)
+	}
+	if env.GooGetExists {
+		checks = append(checks, googetRepoChecks(ctx)...)
+	}
+	return runRepoChecks(ctx, checks)
+}
+
+func runRepoChecks(ctx context.Context, checks []*repoCheck) []*RepositoryHealth {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, repoHealthConcurrency)
+	for _, c := range checks {
+		if c.health.Status != "" {
+			continue
+		}
+		wg.Add(1)
+		go func(c *repoCheck) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			c.run(ctx)
+		}(c)
+	}
+	wg.Wait()
+
+	var health []*RepositoryHealth
+	for _, c := range checks {
+		if c.health.Status != RepoOK {
+			clog.Debugf(ctx, "Repository %s %q is %s: %s", c.health.Manager, c.health.URL, c.health.Status, c.health.Error)
+		}
+		health = append(health, c.health)
+	}
+	return health
+}
+
+func (c *repoCheck) run(ctx context.Context) {
+	body, err := fetchRepoMetadata(ctx, c.metadata)
+	if err != nil {
+		c.health.Status, c.health.Error = RepoUnreachable, err.Error()
+		return
+	}
+	if c.verify != nil {
+		if err := c.verify(ctx, body); err != nil {
+			c.health.Status, c.health.Error = RepoGPGError, err.Error()
+			return
+		}
+	}
+	c.health.Status = RepoOK
+}
+
+func fetchRepoMetadata(ctx context.Context, u string) ([]byte, error) {
+	if strings.HasPrefix(u, "file://") {
+		return envFrom(ctx).FS.ReadFile(strings.TrimPrefix(u, "file://"))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := repoHealthHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got http status %d fetching %s", resp.StatusCode, u)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxRepoMetadataSize))
+}
+
+// newRepoCheck returns a check of the repository at base, repositories that
+// are not served over http or https are not checked.
+func newRepoCheck(manager, source, base, metadata string) *repoCheck {
+	h := &RepositoryHealth{Manager: manager, Source: source, URL: base}
+	u, err := url.Parse(base)
+	switch {
+	case err != nil:
+		h.Status, h.Error = RepoNotChecked, err.Error()
+	case u.Scheme != "http" && u.Scheme != "https":
+		h.Status = RepoNotChecked
+	case strings.Contains(base, "$"):
+		h.Status, h.Error = RepoNotChecked, "URL has variables that could not be expanded"
+	}
+	return &repoCheck{health: h, metadata: metadata}
+}
+
+// aptSource is a repository from an apt sources file.
+type aptSource struct {
+	uri, suite, signedBy string
+}
+
+// parseAptSources parses the deb lines of a one line style sources file.
+func parseAptSources(data []byte) []aptSource {
+	var sources []aptSource
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "deb" {
+			continue
+		}
+		var signedBy string
+		fields = fields[1:]
+		if strings.HasPrefix(fields[0], "[") {
+			for len(fields) > 0 {
+				opt := strings.Trim(fields[0], "[]")
+				if v, ok := strings.CutPrefix(opt, "signed-by="); ok {
+					signedBy = v
+				}
+				end := strings.HasSuffix(fields[0], "]")
+				fields = fields[1:]
+				if end {
+					break
+				}
+			}
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		sources = append(sources, aptSource{uri: fields[0], suite: fields[1], signedBy: signedBy})
+	}
+	return sources
+}
+
+// parseDeb822Sources parses the deb entries of a deb822 style .sources file.
+func parseDeb822Sources(data []byte) []aptSource {
+	var sources []aptSource
+	for _, stanza := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n\n") {
+		fields := map[string]string{}
+		for _, line := range strings.Split(stanza, "\n") {
+			if k, v, ok := strings.Cut(line, ":"); ok && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, " ") {
+				fields[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+			}
+		}
+		if !strings.Contains(" "+fields["types"]+" ", " deb ") || strings.EqualFold(fields["enabled"], "no") {
+			continue
+		}
+		for _, uri := range strings.Fields(fields["uris"]) {
+			for _, suite := range strings.Fields(fields["suites"]) {
+				sources = append(sources, aptSource{uri: uri, suite: suite, signedBy: fields["signed-by"]})
+			}
+		}
+	}
+	return sources
+}
+
+func aptRepoChecks(ctx context.Context) []*repoCheck {
+	fsys := envFrom(ctx).FS
+	files := []string{aptSourcesList}
+	lists, _ := fsys.Glob(filepath.Join(aptSourcesDir, "*.list"))
+	deb822, _ := fsys.Glob(filepath.Join(aptSourcesDir, "*.sources"))
+	files = append(append(files, lists...), deb822...)
+
+	var checks []*repoCheck
+	seen := map[string]bool{}
+	for _, f := range files {
+		data, err := fsys.ReadFile(f)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				clog.Debugf(ctx, "Error reading apt sources %q: %v", f, err)
+			}
+			continue
+		}
+		sources := parseAptSources(data)
+		if strings.HasSuffix(f, ".sources") {
+			sources = parseDeb822Sources(data)
+		}
+		for _, s := range sources {
+			// Flat repositories have a suite ending in /, relative to the URI.
+			release := strings.TrimSuffix(s.uri, "/") + "/dists/" + s.suite + "/InRelease"
+			if strings.HasSuffix(s.suite, "/") {
+				release = strings.TrimSuffix(s.uri, "/") + "/" + strings.TrimPrefix(s.suite, "./") + "InRelease"
+			}
+			if seen[release] {
+				continue
+			}
+			seen[release] = true
+			c := newRepoCheck("apt", f, s.uri, release)
+			signedBy := s.signedBy
+			c.verify = func(_ context.Context, body []byte) error { return verifyInRelease(fsys, body, signedBy) }
+			checks = append(checks, c)
+		}
+	}
+	return checks
+}
+
+// readKeyRing reads armored or binary keys from files.
+func readKeyRing(fsys FS, files []string) openpgp.EntityList {
+	var keys openpgp.EntityList
+	for _, f := range files {
+		data, err := fsys.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		el, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		if err != nil {
+			el, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+		}
+		if err == nil {
+			keys = append(keys, el...)
+		}
+	}
+	return keys
+}
+
+// verifyInRelease checks the signature of an InRelease file against the
+// signed-by keys of the source, or the trusted apt keys if it has none.
+func verifyInRelease(fsys FS, body []byte, signedBy string) error {
+	block, _ := clearsign.Decode(body)
+	if block == nil {
+		return fmt.Errorf("InRelease is not signed")
+	}
+	var files []string
+	if signedBy != "" && !strings.Contains(signedBy, "\n") {
+		files = strings.Split(signedBy, ",")
+	} else {
+		files, _ = fsys.Glob(filepath.Join(aptTrustedDir, "*"))
+		files = append(files, aptTrustedGPG)
+	}
+	keys := readKeyRing(fsys, files)
+	if _, err := openpgp.CheckDetachedSignature(keys, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body); err != nil {
+		return fmt.Errorf("InRelease signature could not be verified: %v", err)
+	}
+	return nil
+}
+
+// parseRepoFile parses the sections of a yum or zypper .repo file into
+// key value pairs, continuation lines are appended to the previous value.
+func parseRepoFile(data []byte) map[string]map[string]string {
+	sections := map[string]map[string]string{}
+	var cur map[string]string
+	var last string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			cur = map[string]string{}
+			sections[strings.Trim(line, "[]")] = cur
+		case cur == nil:
+		case (raw[0] == ' ' || raw[0] == '\t') && last != "":
+			cur[last] += " " + line
+		default:
+			if k, v, ok := strings.Cut(line, "="); ok {
+				last = strings.ToLower(strings.TrimSpace(k))
+				cur[last] = strings.TrimSpace(v)
+			}
+		}
+	}
+	return sections
+}
+
+// repoVars returns the values of yum and dnf repo variables.
+func repoVars(fsys FS, manager string) map[string]string {
+	arch := runtime.GOARCH
+	switch arch {
+	case "amd64":
+		arch = "x86_64"
+	case "arm64":
+		arch = "aarch64"
+	case "386":
+		arch = "i386"
+	}
+	major, full := repoReleaseVers()
+	vars := map[string]string{"basearch": arch, "arch": arch, "releasever": major}
+	if manager == "zypper" {
+		vars["releasever"] = full
+		return vars
+	}
+	for _, dir := range yumVarsDirs {
+		files, _ := fsys.Glob(filepath.Join(dir, "*"))
+		for _, f := range files {
+			if b, err := fsys.ReadFile(f); err == nil {
+				vars[filepath.Base(f)] = strings.TrimSpace(string(b))
+			}
+		}
+	}
+	return vars
+}
+
+func expandRepoVars(s string, vars map[string]string) string {
+	// Longer names first so $releasever_major is not expanded as
+	// $releasever.
+	names := make([]string, 0, len(vars))
+	for n := range vars {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for _, n := range names {
+		if vars[n] == "" {
+			continue
+		}
+		s = strings.ReplaceAll(s, "${"+n+"}", vars[n])
+		s = strings.ReplaceAll(s, "$"+n, vars[n])
+	}
+	return s
+}
+
+func rpmRepoChecks(ctx context.Context, manager, dir string) []*repoCheck {
+	fsys := envFrom(ctx).FS
+	files, _ := fsys.Glob(filepath.Join(dir, "*.repo"))
+	vars := repoVars(fsys, manager)
+	var checks []*repoCheck
+	for _, f := range files {
+		data, err := fsys.ReadFile(f)
+		if err != nil {
+			clog.Debugf(ctx, "Error reading %s repo file %q: %v", manager, f, err)
+			continue
+		}
+		var ids []string
+		sections := parseRepoFile(data)
+		for id := range sections {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			repo := sections[id]
+			if repo["enabled"] == "0" {
+				continue
+			}
+			var c *repoCheck
+			switch {
+			case repo["baseurl"] != "":
+				base := expandRepoVars(strings.Fields(repo["baseurl"])[0], vars)
+				c = newRepoCheck(manager, f, base, strings.TrimSuffix(base, "/")+"/repodata/repomd.xml")
+			case repo["metalink"] != "":
+				u := expandRepoVars(repo["metalink"], vars)
+				c = newRepoCheck(manager, f, u, u)
+			case repo["mirrorlist"] != "":
+				u := expandRepoVars(repo["mirrorlist"], vars)
+				c = newRepoCheck(manager, f, u, u)
+			default:
+				continue
+			}
+			if repo["gpgcheck"] != "0" && repo["gpgkey"] != "" {
+				keys := strings.Fields(strings.ReplaceAll(expandRepoVars(repo["gpgkey"], vars), ",", " "))
+				c.verify = func(ctx context.Context, _ []byte) error { return checkRepoKeys(ctx, keys) }
+			}
+			checks = append(checks, c)
+		}
+	}
+	return checks
+}
+
+// checkRepoKeys fetches and parses the GPG keys of a yum or zypper
+// repository, packages can not be installed if any of them is broken.
+func checkRepoKeys(ctx context.Context, keys []string) error {
+	for _, k := range keys {
+		data, err := fetchRepoMetadata(ctx, k)
+		if err != nil {
+			return fmt.Errorf("error fetching GPG key %s: %v", k, err)
+		}
+		if _, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data)); err != nil {
+			if _, err := openpgp.ReadKeyRing(bytes.NewReader(data)); err != nil {
+				return fmt.Errorf("error parsing GPG key %s: %v", k, err)
+			}
+		}
+	}
+	return nil
+}
+
+func googetRepoChecks(ctx context.Context) []*repoCheck {
+	fsys := envFrom(ctx).FS
+	files, _ := fsys.Glob(filepath.Join(googetReposDir, "*.repo"))
+	var checks []*repoCheck
+	for _, f := range files {
+		data, err := fsys.ReadFile(f)
+		if err != nil {
+			clog.Debugf(ctx, "Error reading googet repo file %q: %v", f, err)
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			if u, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "url:"); ok {
+				u = strings.TrimSpace(u)
+				checks = append(checks, newRepoCheck("googet", f, u, strings.TrimSuffix(u, "/")+"/index"))
+			}
+		}
+	}
+	return checks
+}
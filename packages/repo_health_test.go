@@ -0,0 +1,214 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+func TestParseAptSources(t *testing.T) {
+	data := []byte(`# comment
+deb http://deb.debian.org/debian bookworm main
+deb-src http://deb.debian.org/debian bookworm main
+deb [arch=amd64 signed-by=/usr/share/keyrings/cloud.gpg] https://packages.cloud.google.com/apt cloud-sdk main # trailing
+deb [ trusted=yes ] http://example.com/flat ./
+`)
+	want := []aptSource{
+		{uri: "http://deb.debian.org/debian", suite: "bookworm"},
+		{uri: "https://packages.cloud.google.com/apt", suite: "cloud-sdk", signedBy: "/usr/share/keyrings/cloud.gpg"},
+		{uri: "http://example.com/flat", suite: "./"},
+	}
+	if diff := cmp.Diff(want, parseAptSources(data), cmp.AllowUnexported(aptSource{})); diff != "" {
+		t.Errorf("parseAptSources() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseDeb822Sources(t *testing.T) {
+	data := []byte(`Types: deb deb-src
+URIs: http://deb.debian.org/debian
+Suites: bookworm bookworm-updates
+Components: main
+Signed-By: /usr/share/keyrings/debian-archive-keyring.gpg
+
+Types: deb-src
+URIs: http://deb.debian.org/debian
+Suites: bookworm
+
+Types: deb
+URIs: http://example.com/disabled
+Suites: stable
+Enabled: no
+`)
+	want := []aptSource{
+		{uri: "http://deb.debian.org/debian", suite: "bookworm", signedBy: "/usr/share/keyrings/debian-archive-keyring.gpg"},
+		{uri: "http://deb.debian.org/debian", suite: "bookworm-updates", signedBy: "/usr/share/keyrings/debian-archive-keyring.gpg"},
+	}
+	if diff := cmp.Diff(want, parseDeb822Sources(data), cmp.AllowUnexported(aptSource{})); diff != "" {
+		t.Errorf("parseDeb822Sources() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseRepoFile(t *testing.T) {
+	data := []byte(`[google-cloud]
+name=Google Cloud
+baseurl=https://packages.cloud.google.com/yum/repos/cloud-sdk-el9-$basearch
+enabled=1
+gpgcheck=1
+gpgkey=https://packages.cloud.google.com/yum/doc/yum-key.gpg
+       https://packages.cloud.google.com/yum/doc/rpm-package-key.gpg
+
+; comment
+[disabled]
+enabled=0
+`)
+	want := map[string]map[string]string{
+		"google-cloud": {
+			"name":     "Google Cloud",
+			"baseurl":  "https://packages.cloud.google.com/yum/repos/cloud-sdk-el9-$basearch",
+			"enabled":  "1",
+			"gpgcheck": "1",
+			"gpgkey":   "https://packages.cloud.google.com/yum/doc/yum-key.gpg https://packages.cloud.google.com/yum/doc/rpm-package-key.gpg",
+		},
+		"disabled": {"enabled": "0"},
+	}
+	if diff := cmp.Diff(want, parseRepoFile(data)); diff != "" {
+		t.Errorf("parseRepoFile() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExpandRepoVars(t *testing.T) {
+	vars := map[string]string{"basearch": "x86_64", "releasever": "9", "releasever_major": "9x", "contentdir": ""}
+	tests := []struct {
+		in, want string
+	}{
+		{"https://example.com/$releasever/$basearch", "https://example.com/9/x86_64"},
+		{"https://example.com/${releasever}/os", "https://example.com/9/os"},
+		{"https://example.com/$releasever_major", "https://example.com/9x"},
+		{"https://example.com/$contentdir", "https://example.com/$contentdir"},
+	}
+	for _, tt := range tests {
+		if got := expandRepoVars(tt.in, vars); got != tt.want {
+			t.Errorf("expandRepoVars(%q): got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRepoHealthChecks(t *testing.T) {
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key bytes.Buffer
+	w, err := armor.Encode(&key, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	var inRelease bytes.Buffer
+	sw, err := clearsign.Encode(&inRelease, entity.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sw.Write([]byte("Origin: test\nSuite: stable\n"))
+	sw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apt/dists/stable/InRelease", "/unsigned/dists/stable/InRelease":
+			if r.URL.Path == "/unsigned/dists/stable/InRelease" {
+				w.Write([]byte("Origin: test\n"))
+				return
+			}
+			w.Write(inRelease.Bytes())
+		case "/yum/x86_64/repodata/repomd.xml", "/badkey/repodata/repomd.xml":
+			w.Write([]byte("<repomd/>"))
+		case "/key.gpg":
+			w.Write(key.Bytes())
+		case "/notakey.gpg":
+			w.Write([]byte("not a key"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	keyring := filepath.Join(dir, "keyring.asc")
+	if err := os.WriteFile(keyring, key.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sourcesDir := filepath.Join(dir, "sources.list.d")
+	reposDir := filepath.Join(dir, "yum.repos.d")
+	for _, d := range []string{sourcesDir, reposDir} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(dir, "sources.list"): "deb [signed-by=" + keyring + "] " + srv.URL + "/apt stable main\n" +
+			"deb [signed-by=" + keyring + "] " + srv.URL + "/unsigned stable main\n" +
+			"deb cdrom:[Debian]/ stable main\n",
+		filepath.Join(sourcesDir, "missing.sources"): "Types: deb\nURIs: " + srv.URL + "/missing\nSuites: stable\n",
+		filepath.Join(reposDir, "test.repo"): "[ok]\nbaseurl=" + srv.URL + "/yum/$basearch\ngpgcheck=1\ngpgkey=" + srv.URL + "/key.gpg\n" +
+			"[badkey]\nbaseurl=" + srv.URL + "/badkey\ngpgkey=" + srv.URL + "/notakey.gpg\n" +
+			"[unexpanded]\nbaseurl=" + srv.URL + "/$unknown\n",
+	}
+	for f, content := range files {
+		if err := os.WriteFile(f, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(l, d, y string, v []string) {
+		aptSourcesList, aptSourcesDir, yumReposDir, yumVarsDirs = l, d, y, v
+	}(aptSourcesList, aptSourcesDir, yumReposDir, yumVarsDirs)
+	aptSourcesList, aptSourcesDir, yumReposDir, yumVarsDirs = filepath.Join(dir, "sources.list"), sourcesDir, reposDir, nil
+
+	ctx := context.Background()
+	checks := append(aptRepoChecks(ctx), rpmRepoChecks(ctx, "yum", reposDir)...)
+	var got []string
+	for _, h := range runRepoChecks(ctx, checks) {
+		got = append(got, h.Manager+" "+filepath.Base(h.Source)+" "+h.Status)
+	}
+	want := []string{
+		"apt sources.list ok",
+		"apt sources.list gpg_error",
+		"apt sources.list not_checked",
+		"apt missing.sources unreachable",
+		"yum test.repo gpg_error",
+		"yum test.repo ok",
+		"yum test.repo not_checked",
+	}
+	if repoVars(osFS{}, "yum")["basearch"] != "x86_64" {
+		want[5] = "yum test.repo unreachable"
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("repository health mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"runtime"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
@@ -33,8 +34,9 @@ var (
 		"package":      "%{NAME}",
 		"architecture": "%{ARCH}",
 		// %|EPOCH?{%{EPOCH}:}:{}| == if EPOCH then prepend "%{EPOCH}:" to version.
-		"version":     "%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}",
-		"source_name": "%{SOURCERPM}",
+		"version":      "%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}",
+		"source_name":  "%{SOURCERPM}",
+		"install_time": "%{INSTALLTIME}",
 	}
 
 	rpmInstallArgs = []string{"--upgrade", "--replacepkgs", "-v"}
@@ -65,32 +67,42 @@ func parseInstalledRPMPackages(ctx context.Context, data []byte) []*PkgInfo {
 
 	var result []*PkgInfo
 	for _, entry := range lines {
-		var rpm packageMetadata
-		if err := json.Unmarshal(entry, &rpm); err != nil {
-			clog.Debugf(ctx, "unable to parse rpm package info, err %s, raw - %s", err, string(entry))
-			continue
+		if pkg := parseRPMPackageLine(ctx, entry); pkg != nil {
+			result = append(result, pkg)
 		}
-
-		pkg := pkgInfoFromPackageMetadata(rpm)
-		result = append(result, pkg)
 	}
 
 	return result
 }
 
+// parseRPMPackageLine parses a single line of rpmquery output, it returns nil
+// for unparsable lines.
+func parseRPMPackageLine(ctx context.Context, line []byte) *PkgInfo {
+	var rpm packageMetadata
+	if err := json.Unmarshal(line, &rpm); err != nil {
+		clog.Debugf(ctx, "unable to parse rpm package info, err %s, raw - %s", err, string(line))
+		return nil
+	}
+	return pkgInfoFromPackageMetadata(rpm)
+}
+
 // InstalledRPMPackages queries for all installed rpm packages.
 func InstalledRPMPackages(ctx context.Context) ([]*PkgInfo, error) {
-	out, err := run(ctx, rpmquery, rpmqueryInstalledArgs)
-	if err != nil {
+	var result []*PkgInfo
+	if err := runLines(ctx, rpmquery, rpmqueryInstalledArgs, func(line []byte) {
+		if pkg := parseRPMPackageLine(ctx, line); pkg != nil {
+			result = append(result, pkg)
+		}
+	}); err != nil {
 		return nil, err
 	}
-
-	return parseInstalledRPMPackages(ctx, out), nil
+	return result, nil
 }
 
 // RPMInstall installs an rpm packages.
 func RPMInstall(ctx context.Context, path string) error {
 	_, err := run(ctx, rpm, append(rpmInstallArgs, path))
+	journal.Record(ctx, journal.PackageInstall, path, err)
 	return err
 }
 
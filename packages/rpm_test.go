@@ -16,15 +16,20 @@ package packages
 
 import (
 	"errors"
+	"fmt"
 	"os/exec"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+	"github.com/GoogleCloudPlatform/osconfig/util"
 	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
 	"github.com/golang/mock/gomock"
 )
 
 func TestParseInstalledRPMPackages(t *testing.T) {
+	installTime := time.Unix(1718000000, 0).UTC()
 	tests := []struct {
 		name string
 		data []byte
@@ -40,6 +45,20 @@ func TestParseInstalledRPMPackages(t *testing.T) {
 				{Name: "golang-src", Arch: "all", Version: "1.22.3-1.el9", Source: Source{Name: "golang-1.22.3-1.el9.src.rpm"}},
 			},
 		},
+		{
+			name: "Install time and vendor",
+			data: []byte(`{"architecture":"x86_64","install_time":"1718000000","package":"gcc","source_name":"gcc-11.4.1-3.el9.src.rpm","version":"11.4.1-3.el9"}`),
+			want: []*PkgInfo{
+				{Name: "gcc", Arch: "x86_64", Version: "11.4.1-3.el9", Source: Source{Name: "gcc-11.4.1-3.el9.src.rpm"}, InstallTime: &installTime},
+			},
+		},
+		{
+			name: "No vendor",
+			data: []byte(`{"architecture":"x86_64","install_time":"(none)","package":"gcc","source_name":"gcc-11.4.1-3.el9.src.rpm","version":"11.4.1-3.el9"}`),
+			want: []*PkgInfo{
+				{Name: "gcc", Arch: "x86_64", Version: "11.4.1-3.el9", Source: Source{Name: "gcc-11.4.1-3.el9.src.rpm"}},
+			},
+		},
 		{
 			name: "No valid pacakges",
 			data: []byte("nothing here"),
@@ -105,7 +124,7 @@ func TestInstalledRPMPackages(t *testing.T) {
 			},
 			},
 			expectedResults: nil,
-			expectedError:   errors.New("error running /usr/bin/rpmquery with args [\"--queryformat\" \"\\\\{\\\"architecture\\\":\\\"%{ARCH}\\\",\\\"package\\\":\\\"%{NAME}\\\",\\\"source_name\\\":\\\"%{SOURCERPM}\\\",\\\"version\\\":\\\"%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\\\"\\\\}\\n\" \"-a\"]: unexpected error, stdout: \"stdout\", stderr: \"stderr\""),
+			expectedError:   errcode.Wrap(errcode.PackageManager, errors.New("error running /usr/bin/rpmquery with args [\"--queryformat\" \"\\\\{\\\"architecture\\\":\\\"%{ARCH}\\\",\\\"install_time\\\":\\\"%{INSTALLTIME}\\\",\\\"package\\\":\\\"%{NAME}\\\",\\\"source_name\\\":\\\"%{SOURCERPM}\\\",\\\"version\\\":\\\"%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\\\"\\\\}\\n\" \"-a\"]: unexpected error, stdout: \"stdout\", stderr: \"stderr\"")),
 		},
 	}
 
@@ -208,7 +227,7 @@ func TestRPMPkgInfo(t *testing.T) {
 				},
 			},
 			expectedResult: nil,
-			expectedError:  errors.New("error running /usr/bin/rpmquery with args [\"--queryformat\" \"\\\\{\\\"architecture\\\":\\\"%{ARCH}\\\",\\\"package\\\":\\\"%{NAME}\\\",\\\"source_name\\\":\\\"%{SOURCERPM}\\\",\\\"version\\\":\\\"%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\\\"\\\\}\\n\" \"-p\" \"/tmp/gcc.rpm\"]: unexpected error, stdout: \"stdout\", stderr: \"stderr\""),
+			expectedError:  errcode.Wrap(errcode.PackageManager, errors.New("error running /usr/bin/rpmquery with args [\"--queryformat\" \"\\\\{\\\"architecture\\\":\\\"%{ARCH}\\\",\\\"install_time\\\":\\\"%{INSTALLTIME}\\\",\\\"package\\\":\\\"%{NAME}\\\",\\\"source_name\\\":\\\"%{SOURCERPM}\\\",\\\"version\\\":\\\"%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\\\"\\\\}\\n\" \"-p\" \"/tmp/gcc.rpm\"]: unexpected error, stdout: \"stdout\", stderr: \"stderr\"")),
 		},
 	}
 
@@ -258,3 +277,33 @@ func TestRPMInstall(t *testing.T) {
 		t.Errorf("RPMInstall: got unexpected error %q", err)
 	}
 }
+
+// rpmqueryOutput returns rpmquery output listing n installed packages.
+func rpmqueryOutput(n int) []byte {
+	var out []byte
+	for i := 0; i < n; i++ {
+		out = fmt.Appendf(out, `{"architecture":"x86_64","install_time":"1700000000","package":"package-%d","source_name":"package-%d-1.%d-1.el9.src.rpm","version":"1.%d-1.el9"}`+"\n", i, i, i, i)
+	}
+	return out
+}
+
+func BenchmarkParseInstalledRPMPackages(b *testing.B) {
+	out := rpmqueryOutput(50000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseInstalledRPMPackages(testCtx, out)
+	}
+}
+
+func BenchmarkInstalledRPMPackages(b *testing.B) {
+	defer func(r util.CommandRunner) { runner = r }(runner)
+	runner = &streamingRunner{stdout: rpmqueryOutput(50000), chunkSize: 32 * 1024}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := InstalledRPMPackages(testCtx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	update       = flag.Bool("update", false, "rewrite the expected results of the testdata snapshots")
+	capture      = flag.Bool("capture", false, "capture snapshots of the package manager commands on this machine, see TestCaptureSnapshots")
+	snapshotName = flag.String("name", "", "name of the captured snapshots, defaults to <short name>-<version> of the OS, like debian-12")
+)
+
+// snapshotParser is a package manager command and the parser for its
+// output. The stdout of the command and the parsed result are kept as golden
+// snapshots in testdata/<name>, see TestCaptureSnapshots.
+type snapshotParser struct {
+	name   string
+	exists func(*Env) bool
+	cmd    string
+	args   []string
+	// pty runs the command with the pty runner, like the agent does for
+	// commands whose output depends on the terminal.
+	pty   bool
+	parse func(ctx context.Context, stdout []byte) interface{}
+}
+
+// zypperPatchesSnapshot holds both results of parseZypperPatches.
+type zypperPatchesSnapshot struct {
+	Installed, Available []*ZypperPatch
+}
+
+// snapshotParsers returns the parsers, the command paths are only set once
+// all init functions of the package ran.
+func snapshotParsers() []snapshotParser {
+	return []snapshotParser{
+		{
+			name:   "dpkg-query",
+			exists: func(e *Env) bool { return e.DpkgQueryExists },
+			cmd:    dpkgQuery,
+			args:   dpkgQueryArgs,
+			parse:  func(ctx context.Context, b []byte) interface{} { return parseInstalledDebPackages(ctx, b) },
+		},
+		{
+			name:   "apt-get-upgrade",
+			exists: func(e *Env) bool { return e.AptExists },
+			cmd:    aptGet,
+			args:   append(append([]string{}, aptGetUpgradableArgs...), aptGetUpgradeCmd),
+			parse:  func(ctx context.Context, b []byte) interface{} { return parseAptUpdates(ctx, b, false) },
+		},
+		{
+			name:   "apt-cache-policy",
+			exists: func(e *Env) bool { return e.AptExists },
+			cmd:    aptCache,
+			// A few packages, apt-cache policy lists the ones it is given.
+			args:  append(append([]string{}, aptCachePolicy...), "apt", "base-files", "bash", "git", "libc6", "tzdata"),
+			parse: func(_ context.Context, b []byte) interface{} { return parseAptCachePolicy(b) },
+		},
+		{
+			name:   "rpmquery",
+			exists: func(e *Env) bool { return e.RPMQueryExists },
+			cmd:    rpmquery,
+			args:   rpmqueryInstalledArgs,
+			parse:  func(ctx context.Context, b []byte) interface{} { return parseInstalledRPMPackages(ctx, b) },
+		},
+		{
+			name:   "yum-update",
+			exists: func(e *Env) bool { return e.YumExists },
+			cmd:    yum,
+			args:   yumListUpdatesArgs,
+			pty:    true,
+			parse:  func(_ context.Context, b []byte) interface{} { return parseYumUpdates(b) },
+		},
+		{
+			name:   "dnf-repoquery",
+			exists: func(e *Env) bool { return e.DnfExists },
+			cmd:    dnf,
+			args:   dnfRepoqueryInstalledArgs,
+			parse:  func(_ context.Context, b []byte) interface{} { return parseDnfRepoquery(b) },
+		},
+		{
+			name:   "zypper-list-updates",
+			exists: func(e *Env) bool { return e.ZypperExists },
+			cmd:    zypper,
+			args:   zypperListUpdatesArgs,
+			parse: func(_ context.Context, b []byte) interface{} {
+				pkgs, _ := parseZypperUpdates(b)
+				return pkgs
+			},
+		},
+		{
+			name:   "zypper-list-patches",
+			exists: func(e *Env) bool { return e.ZypperExists },
+			cmd:    zypper,
+			args:   append(append([]string{}, zypperListPatchesArgs...), "--all"),
+			parse: func(ctx context.Context, b []byte) interface{} {
+				installed, available, _ := parseZypperPatches(b)
+				return zypperPatchesSnapshot{Installed: installed, Available: available}
+			},
+		},
+		{
+			name:   "zypper-search-installed",
+			exists: func(e *Env) bool { return e.ZypperExists },
+			cmd:    zypper,
+			args:   zypperSearchInstalled,
+			parse: func(_ context.Context, b []byte) interface{} {
+				repos, _ := parseZypperInstalledRepositories(b)
+				return repos
+			},
+		},
+		{
+			name:   "pacman-query",
+			exists: func(e *Env) bool { return e.PacmanExists },
+			cmd:    pacman,
+			args:   pacmanQueryInfoArgs,
+			parse:  func(_ context.Context, b []byte) interface{} { return parseInstalledPacmanPackages(b) },
+		},
+		{
+			name:   "checkupdates",
+			exists: func(e *Env) bool { return e.PacmanExists },
+			cmd:    checkupdates,
+			args:   checkupdatesArgs,
+			parse:  func(_ context.Context, b []byte) interface{} { return parsePacmanUpdates(b) },
+		},
+		{
+			name:   "flatpak-list",
+			exists: func(e *Env) bool { return e.FlatpakExists },
+			cmd:    flatpak,
+			args:   flatpakListArgs,
+			parse:  func(_ context.Context, b []byte) interface{} { return parseFlatpakApps(b) },
+		},
+		{
+			name:   "flatpak-remote-ls",
+			exists: func(e *Env) bool { return e.FlatpakExists },
+			cmd:    flatpak,
+			args:   flatpakRemoteLsArgs,
+			parse:  func(_ context.Context, b []byte) interface{} { return parseFlatpakApps(b) },
+		},
+		{
+			name:   "googet-update",
+			exists: func(e *Env) bool { return e.GooGetExists },
+			cmd:    googet,
+			args:   googetUpdateQueryArgs,
+			parse:  func(_ context.Context, b []byte) interface{} { return parseGooGetUpdates(b) },
+		},
+		{
+			name:   "googet-installed",
+			exists: func(e *Env) bool { return e.GooGetExists },
+			cmd:    googet,
+			args:   googetInstalledQueryArgs,
+			parse:  func(_ context.Context, b []byte) interface{} { return parseInstalledGooGetPackages(b) },
+		},
+	}
+}
+
+func snapshotJSON(v interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// TestSnapshots parses the command output captured by TestCaptureSnapshots
+// and compares it with the expected result.
+func TestSnapshots(t *testing.T) {
+	ctx := context.Background()
+	// Some commands, like pacman, print install dates in local time.
+	defer func(l *time.Location) { time.Local = l }(time.Local)
+	time.Local = time.UTC
+	for _, p := range snapshotParsers() {
+		stdouts, err := filepath.Glob(filepath.Join("testdata", p.name, "*.stdout"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range stdouts {
+			p, path := p, path
+			name := strings.TrimSuffix(filepath.Base(path), ".stdout")
+			t.Run(p.name+"/"+name, func(t *testing.T) {
+				stdout, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := p.parse(ctx, stdout)
+
+				expectedPath := strings.TrimSuffix(path, ".stdout") + ".expected.json"
+				if *update {
+					b, err := snapshotJSON(got)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(expectedPath, b, 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				b, err := os.ReadFile(expectedPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := reflect.New(reflect.TypeOf(got))
+				if err := json.Unmarshal(b, want.Interface()); err != nil {
+					t.Fatalf("error parsing %s: %v", expectedPath, err)
+				}
+				if diff := cmp.Diff(want.Elem().Interface(), got); diff != "" {
+					t.Errorf("%s did not match expectation, regenerate with -update if intended: (-want +got)\n%s", expectedPath, diff)
+				}
+			})
+		}
+	}
+}
+
+// TestCaptureSnapshots, only run with -capture, runs the package manager
+// commands available on this machine and writes their stdout and parsed
+// result as testdata/<parser>/<name>.stdout and
+// testdata/<parser>/<name>.expected.json, replacing any existing snapshot of
+// the same name. Run it on a new distro version and check in the files to
+// cover it in TestSnapshots:
+//
+//	go test ./packages -run TestCaptureSnapshots -capture
+//	go test ./packages -run TestCaptureSnapshots -capture -name rocky-9.3
+//
+// Check in a representative part of large outputs, like the installed
+// packages, rather than all of it, and regenerate the expected results with
+// -update.
+func TestCaptureSnapshots(t *testing.T) {
+	if !*capture {
+		t.Skip("capturing snapshots needs -capture")
+	}
+	ctx := context.Background()
+	name := *snapshotName
+	if name == "" {
+		oi, err := osinfo.Get()
+		if err != nil {
+			t.Fatalf("Error getting OS info, set -name: %v", err)
+		}
+		name = strings.ToLower(strings.ReplaceAll(oi.ShortName+"-"+oi.Version, " ", "-"))
+	}
+
+	env := DefaultEnv()
+	var written int
+	for _, p := range snapshotParsers() {
+		if !p.exists(env) {
+			continue
+		}
+		r := env.Runner
+		if p.pty {
+			r = env.PtyRunner
+		}
+		stdout, stderr, err := r.Run(ctx, exec.CommandContext(ctx, p.cmd, p.args...))
+		if err != nil {
+			// Some commands, like yum update --assumeno, exit non-zero on
+			// success, the output is still worth keeping.
+			t.Logf("%s with args %q: %v, stderr: %q", p.cmd, p.args, err, stderr)
+		}
+		if len(stdout) == 0 {
+			t.Logf("No output from %s, skipping snapshot %q.", p.cmd, p.name)
+			continue
+		}
+
+		expected, err := snapshotJSON(p.parse(ctx, stdout))
+		if err != nil {
+			t.Fatalf("Error marshaling %s result: %v", p.name, err)
+		}
+		base := filepath.Join("testdata", p.name, name)
+		if err := os.MkdirAll(filepath.Dir(base), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for path, b := range map[string][]byte{base + ".stdout": stdout, base + ".expected.json": expected} {
+			if err := os.WriteFile(path, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("Wrote %s", path)
+			written++
+		}
+	}
+	if written == 0 {
+		t.Error("No supported package manager found.")
+	}
+}
@@ -18,9 +18,10 @@
 package packages
 
 import (
+	"context"
 	"os/exec"
 )
 
-func runWithPty(cmd *exec.Cmd) ([]byte, []byte, error) {
+func runWithPty(ctx context.Context, cmd *exec.Cmd) ([]byte, []byte, error) {
 	return nil, nil, nil
 }
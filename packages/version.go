@@ -0,0 +1,241 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// versionRe matches deb and rpm versions, with an optional epoch.
+var versionRe = regexp.MustCompile(`^([0-9]+:)?[A-Za-z0-9][A-Za-z0-9.+_~-]*$`)
+
+// ValidatePackageVersion checks version, which comes from a policy, can be
+// passed to a package manager as the version of a package.
+func ValidatePackageVersion(version string) error {
+	if !versionRe.MatchString(version) {
+		return fmt.Errorf("invalid package version %q", version)
+	}
+	return nil
+}
+
+// splitEpoch splits an [epoch:]version, the epoch defaults to 0.
+func splitEpoch(v string) (int, string) {
+	e, rest, ok := strings.Cut(v, ":")
+	if !ok {
+		return 0, v
+	}
+	epoch, err := strconv.Atoi(e)
+	if err != nil {
+		return 0, v
+	}
+	return epoch, rest
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// CompareDebVersions compares two [epoch:]upstream[-revision] deb versions
+// like dpkg --compare-versions, it returns -1, 0 or 1 if a is older, the same
+// as or newer than b.
+func CompareDebVersions(a, b string) int {
+	ea, a := splitEpoch(a)
+	eb, b := splitEpoch(b)
+	if c := compareInts(ea, eb); c != 0 {
+		return c
+	}
+	ua, ra := a, ""
+	if i := strings.LastIndex(a, "-"); i >= 0 {
+		ua, ra = a[:i], a[i+1:]
+	}
+	ub, rb := b, ""
+	if i := strings.LastIndex(b, "-"); i >= 0 {
+		ub, rb = b[:i], b[i+1:]
+	}
+	if c := debVerRevCmp(ua, ub); c != 0 {
+		return c
+	}
+	return debVerRevCmp(ra, rb)
+}
+
+// debOrder is the sort weight of a non digit character, a tilde sorts
+// before anything, even the end of the string, and letters before other
+// characters.
+func debOrder(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+	c := s[i]
+	switch {
+	case c == '~':
+		return -1
+	case c >= '0' && c <= '9':
+		return 0
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return int(c)
+	}
+	return int(c) + 256
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// debVerRevCmp is dpkg's verrevcmp, alternating non digit and digit parts
+// are compared.
+func debVerRevCmp(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			if c := compareInts(debOrder(a, i), debOrder(b, j)); c != 0 {
+				return c
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		first := 0
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if first == 0 {
+				first = compareInts(int(a[i]), int(b[j]))
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if first != 0 {
+			return first
+		}
+	}
+	return 0
+}
+
+// CompareRPMVersions compares two [epoch:]version[-release] rpm versions
+// like rpm, it returns -1, 0 or 1 if a is older, the same as or newer than
+// b. A release is only compared when both versions have one.
+func CompareRPMVersions(a, b string) int {
+	ea, a := splitEpoch(a)
+	eb, b := splitEpoch(b)
+	if c := compareInts(ea, eb); c != 0 {
+		return c
+	}
+	va, ra, okA := strings.Cut(a, "-")
+	vb, rb, okB := strings.Cut(b, "-")
+	if c := rpmVerCmp(va, vb); c != 0 || !okA || !okB {
+		return c
+	}
+	return rpmVerCmp(ra, rb)
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// rpmVerCmp is rpm's rpmvercmp, alternating alphabetic and numeric segments
+// are compared, a tilde sorts before anything and a caret after the end of
+// the version but before anything else.
+func rpmVerCmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	for len(a) > 0 || len(b) > 0 {
+		for len(a) > 0 && !isAlnum(a[0]) && a[0] != '~' && a[0] != '^' {
+			a = a[1:]
+		}
+		for len(b) > 0 && !isAlnum(b[0]) && b[0] != '~' && b[0] != '^' {
+			b = b[1:]
+		}
+
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			switch {
+			case a == "":
+				return -1
+			case b == "":
+				return 1
+			case !strings.HasPrefix(a, "^"):
+				return 1
+			case !strings.HasPrefix(b, "^"):
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if a == "" || b == "" {
+			break
+		}
+
+		numeric := isDigit(a[0])
+		segA, segB := a, b
+		a, b = strings.TrimLeftFunc(a, segmentFunc(numeric)), strings.TrimLeftFunc(b, segmentFunc(numeric))
+		segA, segB = segA[:len(segA)-len(a)], segB[:len(segB)-len(b)]
+		if segB == "" {
+			// Segments of different types, numeric is newer.
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		if numeric {
+			segA, segB = strings.TrimLeft(segA, "0"), strings.TrimLeft(segB, "0")
+			if c := compareInts(len(segA), len(segB)); c != 0 {
+				return c
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	}
+	return 1
+}
+
+func segmentFunc(numeric bool) func(rune) bool {
+	if numeric {
+		return func(r rune) bool { return r >= '0' && r <= '9' }
+	}
+	return func(r rune) bool { return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') }
+}
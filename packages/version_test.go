@@ -0,0 +1,76 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import "testing"
+
+func TestCompareDebVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-10", "1.0-9", 1},
+		{"1:1.0", "2.0", 1},
+		{"0:1.0", "1.0", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0a", "1.0", 1},
+		{"1.0+dfsg-1", "1.0-1", 1},
+		{"7.88.1-10+deb12u5", "7.88.1-10+deb12u4", 1},
+		{"2.4.45+dfsg-1ubuntu1.2", "2.4.45+dfsg-1ubuntu1.10", -1},
+		{"1.001", "1.1", 0},
+	}
+	for _, tt := range tests {
+		if got := CompareDebVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareDebVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareDebVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareDebVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestCompareRPMVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0-1.el9", "1.0-1.el9", 0},
+		{"1.0-1.el9", "1.0-2.el9", -1},
+		{"1.10-1", "1.9-1", 1},
+		{"1:1.0-1", "2.0-1", 1},
+		{"1.0", "1.0-5", 0},
+		{"1.0a", "1.0", 1},
+		{"1.0", "1.0.1", -1},
+		{"1.0~rc1-1", "1.0-1", -1},
+		{"1.0^git1-1", "1.0-1", 1},
+		{"1.0^git1-1", "1.0.1-1", -1},
+		{"2.34-100.el9", "2.34-83.el9", 1},
+		{"1.a", "1.1", -1},
+		{"1.001", "1.1", 0},
+	}
+	for _, tt := range tests {
+		if got := CompareRPMVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareRPMVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareRPMVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareRPMVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
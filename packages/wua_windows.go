@@ -19,6 +19,7 @@ import (
 	"sync"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
 	ole "github.com/go-ole/go-ole"
 	"github.com/go-ole/go-ole/oleutil"
 )
@@ -105,7 +106,9 @@ func (s *IUpdateSession) InstallWUAUpdate(ctx context.Context, updt *IUpdate) er
 	}
 
 	clog.Debugf(ctx, "Installing update %s", title.Value())
-	if err := s.InstallWUAUpdateCollection(ctx, updts); err != nil {
+	err = s.InstallWUAUpdateCollection(ctx, updts)
+	journal.Record(ctx, journal.PackageInstall, fmt.Sprint(title.Value()), err)
+	if err != nil {
 		return fmt.Errorf("InstallWUAUpdateCollection error: %v", err)
 	}
 
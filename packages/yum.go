@@ -25,25 +25,32 @@ import (
 	"strings"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
 	"github.com/GoogleCloudPlatform/osconfig/osinfo"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
 var (
 	yum string
+	dnf string
 
 	yumInstallArgs           = []string{"install", "--assumeyes"}
 	yumRemoveArgs            = []string{"remove", "--assumeyes"}
 	yumCheckUpdateArgs       = []string{"check-update", "--assumeyes"}
 	yumListUpdatesArgs       = []string{"update", "--assumeno", "--cacheonly", "--color=never"}
 	yumListUpdateMinimalArgs = []string{"update-minimal", "--assumeno", "--cacheonly", "--color=never"}
+	// dnf 4 ends each package with a newline itself, dnf 5 needs it in the
+	// format.
+	dnfRepoqueryInstalledArgs = []string{"repoquery", "--installed", "--cacheonly", "--quiet", "--queryformat", "%{name}.%{arch} %{from_repo}\n"}
 )
 
 func init() {
 	if runtime.GOOS != "windows" {
 		yum = "/usr/bin/yum"
+		dnf = "/usr/bin/dnf"
 	}
 	YumExists = util.Exists(yum)
+	DnfExists = util.Exists(dnf)
 }
 
 type yumUpdateOpts struct {
@@ -72,13 +79,23 @@ func YumUpdateMinimal(minimal bool) YumUpdateOption {
 
 // InstallYumPackages installs yum packages.
 func InstallYumPackages(ctx context.Context, pkgs []string) error {
-	_, err := run(ctx, yum, append(yumInstallArgs, pkgs...))
+	args, err := pkgArgs(yumInstallArgs, pkgs)
+	if err != nil {
+		return err
+	}
+	_, err = runChange(ctx, yum, args)
+	journal.Record(ctx, journal.PackageInstall, strings.Join(pkgs, " "), err)
 	return err
 }
 
 // RemoveYumPackages removes yum packages.
 func RemoveYumPackages(ctx context.Context, pkgs []string) error {
-	_, err := run(ctx, yum, append(yumRemoveArgs, pkgs...))
+	args, err := pkgArgs(yumRemoveArgs, pkgs)
+	if err != nil {
+		return err
+	}
+	_, err = runChange(ctx, yum, args)
+	journal.Record(ctx, journal.PackageRemove, strings.Join(pkgs, " "), err)
 	return err
 }
 
@@ -159,7 +176,7 @@ func getYumTXFile(data []byte) string {
 func YumUpdates(ctx context.Context, opts ...YumUpdateOption) ([]*PkgInfo, error) {
 	// We just use check-update to ensure all repo keys are synced as we run
 	// update with --assumeno.
-	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, yum, yumCheckUpdateArgs...))
+	stdout, stderr, err := envFrom(ctx).Runner.Run(ctx, exec.CommandContext(ctx, yum, yumCheckUpdateArgs...))
 	// Exit code 0 means no updates, 100 means there are updates.
 	if err == nil {
 		return nil, nil
@@ -196,7 +213,7 @@ func listAndParseYumPackages(ctx context.Context, opts ...YumUpdateOption) ([]*P
 		args = append(args, "--security")
 	}
 
-	stdout, stderr, err := ptyrunner.Run(ctx, exec.CommandContext(ctx, yum, args...))
+	stdout, stderr, err := envFrom(ctx).PtyRunner.Run(ctx, exec.CommandContext(ctx, yum, args...))
 	if err != nil {
 		return nil, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", yum, args, err, stdout, stderr)
 	}
@@ -221,3 +238,35 @@ func listAndParseYumPackages(ctx context.Context, opts ...YumUpdateOption) ([]*P
 	}
 	return pkgs, nil
 }
+
+func parseDnfRepoquery(data []byte) map[string]string {
+	/*
+		bash.x86_64 anaconda
+		google-osconfig-agent.x86_64 google-compute-engine
+		kernel.x86_64 baseos
+		local-tool.noarch @commandline
+	*/
+	repos := map[string]string{}
+	for _, ln := range bytes.Split(data, []byte("\n")) {
+		fields := strings.Fields(string(ln))
+		if len(fields) != 2 {
+			continue
+		}
+		i := strings.LastIndex(fields[0], ".")
+		if i == -1 {
+			continue
+		}
+		repos[fields[0][:i]+"."+osinfo.Architecture(fields[0][i+1:])] = fields[1]
+	}
+	return repos
+}
+
+// dnfInstalledRepositories returns the dnf repository each installed package
+// was installed from, keyed by "name.arch".
+func dnfInstalledRepositories(ctx context.Context) (map[string]string, error) {
+	out, err := run(ctx, dnf, dnfRepoqueryInstalledArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseDnfRepoquery(out), nil
+}
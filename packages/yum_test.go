@@ -187,6 +187,19 @@ func contains(names []string, name string) bool {
 	return false
 }
 
+func TestParseDnfRepoquery(t *testing.T) {
+	data := []byte("bash.x86_64 anaconda\n\ngoogle-osconfig-agent.x86_64 google-compute-engine\ntzdata.noarch baseos\nlocal-tool.noarch @commandline\nbroken\n")
+	want := map[string]string{
+		"bash.x86_64":                  "anaconda",
+		"google-osconfig-agent.x86_64": "google-compute-engine",
+		"tzdata.all":                   "baseos",
+		"local-tool.all":               "@commandline",
+	}
+	if got := parseDnfRepoquery(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDnfRepoquery() = %v, want %v", got, want)
+	}
+}
+
 func TestParseYumUpdates(t *testing.T) {
 	data := []byte(`
 	=================================================================================================================================================================================
@@ -220,6 +233,27 @@ func TestParseYumUpdates(t *testing.T) {
 	}
 }
 
+// TestParseYumUpdatesLocalized shows why yum runs in the C locale: yum and
+// dnf have no machine readable transaction output and translate the section
+// headings the parser looks for.
+func TestParseYumUpdatesLocalized(t *testing.T) {
+	data := []byte(`Letzte Prüfung auf abgelaufene Metadaten: vor 0:11:22 am Di 12 Nov 2019 00:13:38 UTC.
+Abhängigkeiten sind aufgelöst.
+================================================================================
+ Paket                Architektur  Version              Paketquelle       Größe
+================================================================================
+Aktualisieren:
+ foo                  noarch       2.0.0-1              BaseOS           361 k
+
+Zusammenfassung der Transaktion
+================================================================================
+Aktualisieren  1 Paket
+`)
+	if got := parseYumUpdates(data); got != nil {
+		t.Errorf("parseYumUpdates() = %v, want nil for localized output", got)
+	}
+}
+
 func TestParseYumUpdatesWithInstallingDependenciesKeywords(t *testing.T) {
 	data := []byte(`
 	=================================================================================================================================================================================
@@ -17,14 +17,17 @@ package packages
 import (
 	"bytes"
 	"context"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"os/exec"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 
-	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
 	"github.com/GoogleCloudPlatform/osconfig/osinfo"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 )
@@ -35,9 +38,10 @@ var (
 	// zypperInstallArgs is zypper command to install patches, packages
 	zypperInstallArgs     = []string{"--gpg-auto-import-keys", "--non-interactive", "install", "--auto-agree-with-licenses"}
 	zypperRemoveArgs      = []string{"--non-interactive", "remove"}
-	zypperListUpdatesArgs = []string{"--gpg-auto-import-keys", "-q", "list-updates"}
-	zypperListPatchesArgs = []string{"--gpg-auto-import-keys", "-q", "list-patches"}
+	zypperListUpdatesArgs = []string{"--gpg-auto-import-keys", "--xmlout", "list-updates"}
+	zypperListPatchesArgs = []string{"--gpg-auto-import-keys", "--xmlout", "list-patches"}
 	zypperPatchInfoArgs   = []string{"info", "-t", "patch"}
+	zypperSearchInstalled = []string{"--xmlout", "search", "--installed-only", "--details", "--type", "package"}
 )
 
 func init() {
@@ -88,24 +92,37 @@ func ZypperListPatchAll(all bool) ZypperListOption {
 // InstallZypperPackages Installs zypper packages
 func InstallZypperPackages(ctx context.Context, pkgs []string) error {
 	// TODO: Add retries when the it fails with exit code: 7 - which means zypper is locked by another process id.
-	_, err := run(ctx, zypper, append(zypperInstallArgs, pkgs...))
+	args, err := pkgArgs(zypperInstallArgs, pkgs)
+	if err != nil {
+		return err
+	}
+	_, err = runChange(ctx, zypper, args)
+	journal.Record(ctx, journal.PackageInstall, strings.Join(pkgs, " "), err)
 	return err
 }
 
 // ZypperInstall installs zypper patches and packages
 func ZypperInstall(ctx context.Context, patches []*ZypperPatch, pkgs []*PkgInfo) error {
-	args := zypperInstallArgs
-
 	// https://www.mankier.com/8/zypper#Concepts-Package_Types use patch install
 	// for single patch and package installs
+	var names []string
 	for _, patch := range patches {
-		args = append(args, "patch:"+patch.Name)
+		if err := ValidatePackageName(patch.Name); err != nil {
+			return err
+		}
+		names = append(names, "patch:"+patch.Name)
 	}
 	for _, pkg := range pkgs {
-		args = append(args, "package:"+pkg.Name)
+		if err := ValidatePackageName(pkg.Name); err != nil {
+			return err
+		}
+		names = append(names, "package:"+pkg.Name)
 	}
+	args := append(append([]string{}, zypperInstallArgs...), names...)
 
-	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, zypper, args...))
+	cmd := exec.CommandContext(ctx, zypper, args...)
+	logArgv(ctx, cmd)
+	stdout, stderr, err := envFrom(ctx).Runner.Run(ctx, cmd)
 	// https://en.opensuse.org/SDB:Zypper_manual#EXIT_CODES
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
@@ -118,38 +135,89 @@ func ZypperInstall(ctx context.Context, patches []*ZypperPatch, pkgs []*PkgInfo)
 		}
 	}
 
+	journal.Record(ctx, journal.PackageInstall, strings.Join(names, " "), err)
 	return err
 }
 
 // RemoveZypperPackages installed Zypper packages.
 func RemoveZypperPackages(ctx context.Context, pkgs []string) error {
-	_, err := run(ctx, zypper, append(zypperRemoveArgs, pkgs...))
+	args, err := pkgArgs(zypperRemoveArgs, pkgs)
+	if err != nil {
+		return err
+	}
+	_, err = runChange(ctx, zypper, args)
+	journal.Record(ctx, journal.PackageRemove, strings.Join(pkgs, " "), err)
 	return err
 }
 
-func parseZypperUpdates(data []byte) []*PkgInfo {
+// zypperUpdateList is the update-list of the zypper --xmlout list-updates and
+// list-patches output, its attributes are not translated.
+type zypperUpdateList struct {
+	Updates []struct {
+		Kind     string `xml:"kind,attr"`
+		Name     string `xml:"name,attr"`
+		Edition  string `xml:"edition,attr"`
+		Arch     string `xml:"arch,attr"`
+		Status   string `xml:"status,attr"`
+		Category string `xml:"category,attr"`
+		Severity string `xml:"severity,attr"`
+		Summary  string `xml:"summary"`
+	} `xml:"update"`
+}
+
+// parseZypperUpdateList returns the updates of all update-list elements in
+// the zypper XML stream, the progress and message elements are skipped.
+func parseZypperUpdateList(data []byte) (zypperUpdateList, error) {
 	/*
-		      S | Repository          | Name                   | Current Version | Available Version | Arch
-		      --+---------------------+------------------------+-----------------+-------------------+-------
-		      v | SLES12-SP3-Updates  | at                     | 3.1.14-7.3      | 3.1.14-8.3.1      | x86_64
-		      v | SLES12-SP3-Updates  | autoyast2-installation | 3.2.17-1.3      | 3.2.22-2.9.2      | noarch
-			   ...
+		<?xml version='1.0'?>
+		<stream>
+		<message type="info">Loading repository data...</message>
+		<update-status version="0.6">
+		<update-list>
+		<update kind="package" name="at" edition="3.1.14-8.3.1" arch="x86_64" edition-old="3.1.14-7.3" >
+		<summary>A Job Manager</summary>
+		<source url="https://updates.suse.com/SUSE/Updates/SLE-SERVER/12-SP3/x86_64/update" alias="SLES12-SP3-Updates"/>
+		</update>
+		</update-list>
+		</update-status>
+		</stream>
 	*/
+	var list zypperUpdateList
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return list, nil
+		}
+		if err != nil {
+			return list, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "update-list" {
+			continue
+		}
+		var l zypperUpdateList
+		if err := d.DecodeElement(&l, &se); err != nil {
+			return list, err
+		}
+		list.Updates = append(list.Updates, l.Updates...)
+	}
+}
 
-	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+func parseZypperUpdates(data []byte) ([]*PkgInfo, error) {
+	list, err := parseZypperUpdateList(data)
+	if err != nil {
+		return nil, err
+	}
 
 	var pkgs []*PkgInfo
-	for _, ln := range lines {
-		pkg := bytes.Split(ln, []byte("|"))
-		if len(pkg) != 6 || string(bytes.TrimSpace(pkg[0])) != "v" {
+	for _, u := range list.Updates {
+		if u.Kind != "package" || u.Name == "" {
 			continue
 		}
-		name := string(bytes.TrimSpace(pkg[2]))
-		arch := string(bytes.TrimSpace(pkg[5]))
-		ver := string(bytes.TrimSpace(pkg[4]))
-		pkgs = append(pkgs, &PkgInfo{Name: name, Arch: osinfo.Architecture(arch), Version: ver})
+		pkgs = append(pkgs, &PkgInfo{Name: u.Name, Arch: osinfo.Architecture(u.Arch), Version: u.Edition})
 	}
-	return pkgs
+	return pkgs, nil
 }
 
 // ZypperUpdates queries for all available zypper updates.
@@ -158,61 +226,47 @@ func ZypperUpdates(ctx context.Context) ([]*PkgInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	return parseZypperUpdates(out), nil
+	pkgs, err := parseZypperUpdates(out)
+	if err != nil {
+		return nil, errcode.Wrap(errcode.PackageManager, fmt.Errorf("error parsing zypper list-updates output: %v", err))
+	}
+	return pkgs, nil
 }
 
-func parseZypperPatches(ctx context.Context, data []byte) ([]*ZypperPatch, []*ZypperPatch) {
+func parseZypperPatches(data []byte) ([]*ZypperPatch, []*ZypperPatch, error) {
 	/*
-
-		Repository                          | Name                                        | Category    | Severity  | Interactive | Status     | Summary
-		------------------------------------+---------------------------------------------+-------------+-----------+-------------+------------+------------------------------------------------------------
-		SLE-Module-Basesystem15-SP1-Updates | SUSE-SLE-Module-Basesystem-15-SP1-2019-1206 | security    | low       | ---         | applied    | Security update for bzip2
-		SLE-Module-Basesystem15-SP1-Updates | SUSE-SLE-Module-Basesystem-15-SP1-2019-1221 | security    | moderate  | ---         | applied    | Security update for libxslt
-		SLE-Module-Basesystem15-SP1-Updates | SUSE-SLE-Module-Basesystem-15-SP1-2019-1229 | recommended | moderate  | ---         | not needed | Recommended update for sensors
-		SLE-Module-Basesystem15-SP1-Updates | SUSE-SLE-Module-Basesystem-15-SP1-2019-1258 | recommended | moderate  | ---         | needed     | Recommended update for postfix
+		<update-status version="0.6">
+		<update-list>
+		<update name="SUSE-SLE-Module-Basesystem-15-SP1-2019-1206" edition="1" arch="noarch" status="applied" category="security" severity="low" pkgmanager="false" restart="false" interactive="false" kind="patch">
+		<summary>Security update for bzip2</summary>
+		</update>
+		<update name="SUSE-SLE-Module-Basesystem-15-SP1-2019-1258" edition="1" arch="noarch" status="needed" category="recommended" severity="moderate" pkgmanager="false" restart="false" interactive="false" kind="patch">
+		<summary>Recommended update for postfix</summary>
+		</update>
+		</update-list>
+		</update-status>
 	*/
-
-	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	list, err := parseZypperUpdateList(data)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	var installed []*ZypperPatch
 	var available []*ZypperPatch
-	for _, ln := range lines {
-		patch, status, err := parseZypperPatch(ln)
-		if err != nil {
-			clog.Debugf(ctx, "skipping a line from zypper patch output: %s", err)
+	for _, u := range list.Updates {
+		if u.Kind != "patch" || u.Name == "" {
 			continue
 		}
-
-		switch status {
+		patch := &ZypperPatch{Name: u.Name, Category: u.Category, Severity: u.Severity, Summary: strings.TrimSpace(u.Summary)}
+		switch u.Status {
 		case "needed":
 			available = append(available, patch)
 		case "applied":
 			installed = append(installed, patch)
-		default:
-			continue
 		}
 	}
 
-	return installed, available
-}
-
-func parseZypperPatch(tableLine []byte) (*ZypperPatch, string, error) {
-	patch := bytes.Split(tableLine, []byte("|"))
-	if len(patch) < 7 || len(patch) > 8 {
-		return nil, "", fmt.Errorf("not parsable zypper patch line; expected 7 or 8 segments, got - %d; this usually isn't an error; line: %s", len(patch), string(tableLine))
-	}
-
-	name := string(bytes.TrimSpace(patch[1]))
-	category := string(bytes.TrimSpace(patch[2]))
-	severity := string(bytes.TrimSpace(patch[3]))
-	status := string(bytes.TrimSpace(patch[5]))
-	summary := string(bytes.TrimSpace(patch[6]))
-
-	if len(patch) == 8 {
-		summary = string(bytes.TrimSpace(patch[7]))
-	}
-
-	return &ZypperPatch{Name: name, Category: category, Severity: severity, Summary: summary}, status, nil
+	return installed, available, nil
 }
 
 func zypperPatches(ctx context.Context, opts ...ZypperListOption) ([]byte, error) {
@@ -256,7 +310,10 @@ func ZypperPatches(ctx context.Context, opts ...ZypperListOption) ([]*ZypperPatc
 	if err != nil {
 		return nil, err
 	}
-	_, patches := parseZypperPatches(ctx, out)
+	_, patches, err := parseZypperPatches(out)
+	if err != nil {
+		return nil, errcode.Wrap(errcode.PackageManager, fmt.Errorf("error parsing zypper list-patches output: %v", err))
+	}
 	return patches, nil
 }
 
@@ -266,7 +323,10 @@ func ZypperInstalledPatches(ctx context.Context, opts ...ZypperListOption) ([]*Z
 	if err != nil {
 		return nil, err
 	}
-	patches, _ := parseZypperPatches(ctx, out)
+	patches, _, err := parseZypperPatches(out)
+	if err != nil {
+		return nil, errcode.Wrap(errcode.PackageManager, fmt.Errorf("error parsing zypper list-patches output: %v", err))
+	}
 	return patches, nil
 }
 
@@ -358,6 +418,9 @@ func parseZypperPatchInfo(out []byte) (map[string][]string, error) {
 		}
 		ctr := i + 1
 		ctrEnd := ctr + conflictLines
+		if ctrEnd > len(lines) {
+			return nil, fmt.Errorf("invalid patch info: %d conflicts listed but only %d lines left", conflictLines, len(lines)-ctr)
+		}
 		for ; ctr < ctrEnd; ctr++ {
 			//libsolv.src < 0.6.36-2.27.19.8
 			//libsolv-tools.x86_64 < 0.6.36-2.27.19.8
@@ -436,3 +499,73 @@ func ZypperPackagesInPatch(ctx context.Context, patches []*ZypperPatch) (map[str
 	}
 	return parseZypperPatchInfo(out)
 }
+
+// zypperSolvableList is the solvable-list of the zypper --xmlout search
+// output.
+type zypperSolvableList struct {
+	Solvables []struct {
+		Status     string `xml:"status,attr"`
+		Name       string `xml:"name,attr"`
+		Arch       string `xml:"arch,attr"`
+		Repository string `xml:"repository,attr"`
+	} `xml:"solvable"`
+}
+
+func parseZypperInstalledRepositories(data []byte) (map[string]string, error) {
+	/*
+		<?xml version='1.0'?>
+		<stream>
+		<message type="info">Loading repository data...</message>
+		<search-result version="0.0">
+		<solvable-list>
+		<solvable status="installed" name="bash" kind="package" edition="4.4-150400.27.3.2" arch="x86_64" repository="SLE-Module-Basesystem15-SP5-Updates"/>
+		<solvable status="installed" name="local-tool" kind="package" edition="1.0-1" arch="noarch" repository="(System Packages)"/>
+		</solvable-list>
+		</search-result>
+		</stream>
+	*/
+	repos := map[string]string{}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return repos, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "solvable-list" {
+			continue
+		}
+		var l zypperSolvableList
+		if err := d.DecodeElement(&l, &se); err != nil {
+			return nil, err
+		}
+		for _, s := range l.Solvables {
+			// Packages not available from any repository are listed as
+			// System Packages.
+			if s.Status != "installed" || s.Repository == "" || strings.HasPrefix(s.Repository, "(") {
+				continue
+			}
+			key := s.Name + "." + osinfo.Architecture(s.Arch)
+			if _, ok := repos[key]; !ok {
+				repos[key] = s.Repository
+			}
+		}
+	}
+}
+
+// zypperInstalledRepositories returns the zypper repository each installed
+// package is available from, keyed by "name.arch".
+func zypperInstalledRepositories(ctx context.Context) (map[string]string, error) {
+	out, err := run(ctx, zypper, zypperSearchInstalled)
+	if err != nil {
+		return nil, err
+	}
+	repos, err := parseZypperInstalledRepositories(out)
+	if err != nil {
+		return nil, errcode.Wrap(errcode.PackageManager, fmt.Errorf("error parsing zypper search output: %v", err))
+	}
+	return repos, nil
+}
@@ -15,13 +15,13 @@
 package packages
 
 import (
-	"context"
 	"errors"
 	"os/exec"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
 	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
 	"github.com/golang/mock/gomock"
 )
@@ -65,11 +65,38 @@ func TestRemoveZypper(t *testing.T) {
 }
 
 func TestParseZypperUpdates(t *testing.T) {
-	normalCase := `S | Repository          | Name                   | Current Version | Available Version | Arch
---+---------------------+------------------------+-----------------+-------------------+-------
-v | SLES12-SP3-Updates  | at                     | 3.1.14-7.3      | 3.1.14-8.3.1      | x86_64
-v | SLES12-SP3-Updates  | autoyast2-installation | 3.2.17-1.3      | 3.2.22-2.9.2      | noarch
-this is junk data`
+	normalCase := `<?xml version='1.0'?>
+<stream>
+<message type="info">Loading repository data...</message>
+<message type="info">Reading installed packages...</message>
+<update-status version="0.6">
+<update-list>
+<update kind="package" name="at" edition="3.1.14-8.3.1" arch="x86_64" edition-old="3.1.14-7.3" >
+<summary>A Job Manager</summary>
+<source url="https://updates.suse.com/SUSE/Updates/SLE-SERVER/12-SP3/x86_64/update" alias="SLES12-SP3-Updates"/>
+</update>
+<update kind="package" name="autoyast2-installation" edition="3.2.22-2.9.2" arch="noarch" edition-old="3.2.17-1.3" >
+<summary>YaST2 - Auto Installation Modules</summary>
+<source url="https://updates.suse.com/SUSE/Updates/SLE-SERVER/12-SP3/x86_64/update" alias="SLES12-SP3-Updates"/>
+</update>
+</update-list>
+</update-status>
+</stream>`
+
+	// Messages and summaries are translated, the attributes are not.
+	localized := `<?xml version='1.0'?>
+<stream>
+<message type="info">Repository-Daten werden geladen...</message>
+<message type="info">Installierte Pakete werden gelesen...</message>
+<update-status version="0.6">
+<update-list>
+<update kind="package" name="at" edition="3.1.14-8.3.1" arch="x86_64" edition-old="3.1.14-7.3" >
+<summary>Ein Job-Manager</summary>
+<source url="https://updates.suse.com/SUSE/Updates/SLE-SERVER/12-SP3/x86_64/update" alias="SLES12-SP3-Updates"/>
+</update>
+</update-list>
+</update-status>
+</stream>`
 
 	tests := []struct {
 		name string
@@ -77,16 +104,53 @@ this is junk data`
 		want []*PkgInfo
 	}{
 		{"NormalCase", []byte(normalCase), []*PkgInfo{{Name: "at", Arch: "x86_64", Version: "3.1.14-8.3.1"}, {Name: "autoyast2-installation", Arch: "all", Version: "3.2.22-2.9.2"}}},
+		{"Localized", []byte(localized), []*PkgInfo{{Name: "at", Arch: "x86_64", Version: "3.1.14-8.3.1"}}},
+		{"TableOutput", []byte("v | SLES12-SP3-Updates | at | 3.1.14-7.3 | 3.1.14-8.3.1 | x86_64"), nil},
 		{"NoPackages", []byte("nothing here"), nil},
 		{"nil", nil, nil},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := parseZypperUpdates(tt.data); !reflect.DeepEqual(got, tt.want) {
+			got, err := parseZypperUpdates(tt.data)
+			if err != nil {
+				t.Fatalf("parseZypperUpdates() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("parseZypperUpdates() = %v, want %v", got, tt.want)
 			}
 		})
 	}
+
+	if _, err := parseZypperUpdates([]byte(normalCase[:len(normalCase)/2])); err == nil {
+		t.Errorf("parseZypperUpdates() of truncated output: expected an error")
+	}
+}
+
+func TestParseZypperInstalledRepositories(t *testing.T) {
+	data := []byte(`<?xml version='1.0'?>
+<stream>
+<message type="info">Loading repository data...</message>
+<search-result version="0.0">
+<solvable-list>
+<solvable status="installed" name="bash" kind="package" edition="4.4-150400.27.3.2" arch="x86_64" repository="SLE-Module-Basesystem15-SP5-Updates"/>
+<solvable status="installed" name="bash" kind="package" edition="4.4-150400.27.3.2" arch="x86_64" repository="SLE-Module-Basesystem15-SP5-Pool"/>
+<solvable status="installed" name="local-tool" kind="package" edition="1.0-1" arch="noarch" repository="(System Packages)"/>
+<solvable status="not-installed" name="vim" kind="package" edition="9.0-1" arch="x86_64" repository="SLE-Module-Basesystem15-SP5-Pool"/>
+</solvable-list>
+</search-result>
+</stream>
+`)
+	got, err := parseZypperInstalledRepositories(data)
+	if err != nil {
+		t.Fatalf("parseZypperInstalledRepositories() unexpected error: %v", err)
+	}
+	if want := map[string]string{"bash.x86_64": "SLE-Module-Basesystem15-SP5-Updates"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseZypperInstalledRepositories() = %v, want %v", got, want)
+	}
+
+	if _, err := parseZypperInstalledRepositories([]byte("<stream><solvable-list><solvable")); err == nil {
+		t.Errorf("parseZypperInstalledRepositories() of truncated output: expected an error")
+	}
 }
 
 func TestZypperUpdates(t *testing.T) {
@@ -97,7 +161,7 @@ func TestZypperUpdates(t *testing.T) {
 	runner = mockCommandRunner
 	expectedCmd := utilmocks.EqCmd(exec.Command(zypper, zypperListUpdatesArgs...))
 
-	data := []byte("v | SLES12-SP3-Updates  | at                     | 3.1.14-7.3      | 3.1.14-8.3.1      | x86_64")
+	data := []byte(`<update-status version="0.6"><update-list><update kind="package" name="at" edition="3.1.14-8.3.1" arch="x86_64" edition-old="3.1.14-7.3"></update></update-list></update-status>`)
 	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(data, []byte("stderr"), nil).Times(1)
 	ret, err := ZypperUpdates(testCtx)
 	if err != nil {
@@ -113,25 +177,52 @@ func TestZypperUpdates(t *testing.T) {
 	if _, err := ZypperUpdates(testCtx); err == nil {
 		t.Errorf("did not get expected error")
 	}
+
+	// Output that is not valid XML is an error, not an empty list.
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(data[:40], nil, nil).Times(1)
+	if _, err := ZypperUpdates(testCtx); errcode.Of(err, "") != errcode.PackageManager {
+		t.Errorf("ZypperUpdates() of truncated output: got error %v, want a %s error", err, errcode.PackageManager)
+	}
 }
 
 func TestParseZypperPatches(t *testing.T) {
-	normalCase := `Repository                          | Name                                        | Category    | Severity  | Interactive | Status     | Summary
-------------------------------------+---------------------------------------------+-------------+-----------+-------------+------------+------------------------------------------------------------
-SLE-Module-Basesystem15-SP1-Updates | SUSE-SLE-Module-Basesystem-15-SP1-2019-1206 | security    | low       | ---         | applied    | Security update for bzip2
-SLE-Module-Basesystem15-SP1-Updates | SUSE-SLE-Module-Basesystem-15-SP1-2019-1221 | security    | moderate  | ---         | needed     | Security update for libxslt
-SLE-Module-Basesystem15-SP1-Updates | SUSE-SLE-Module-Basesystem-15-SP1-2019-1229 | recommended | moderate  | ---         | not needed | Recommended update for sensors
-SLE-Module-Basesystem15-SP1-Updates | SUSE-SLE-Module-Basesystem-15-SP1-2019-1258 | recommended | moderate  | ---         | needed     | Recommended update for postfix
-some junk data`
-
-	//Recently new format of response was observed, the difference is additional "Since" field in the table.
-	withSinceField := `Repository                                    | Name                                                  | Category    | Severity  | Interactive    | Status     | Since      | Summary
-----------------------------------------------+-------------------------------------------------------+-------------+-----------+----------------+------------+------------------------------------------------------------
-SLE-Module-Basesystem15-SP1-Updates           | SUSE-SLE-Module-Basesystem-15-SP1-2019-1206           | security    | low       | ---            | applied    | -          | Security update for bzip2
-SLE-Module-Basesystem15-SP1-Updates           | SUSE-SLE-Module-Basesystem-15-SP1-2019-1221           | security    | moderate  | ---            | needed     | -          | Security update for libxslt
-SLE-Module-Basesystem15-SP1-Updates           | SUSE-SLE-Module-Basesystem-15-SP1-2019-1229           | recommended | moderate  | ---            | not needed | -          | Recommended update for sensors
-SLE-Module-Basesystem15-SP1-Updates           | SUSE-SLE-Module-Basesystem-15-SP1-2019-1258           | recommended | moderate  | ---            | needed     | -          | Recommended update for postfix
-some junk data`
+	normalCase := `<?xml version='1.0'?>
+<stream>
+<message type="info">Loading repository data...</message>
+<update-status version="0.6">
+<update-list>
+<update name="SUSE-SLE-Module-Basesystem-15-SP1-2019-1206" edition="1" arch="noarch" status="applied" category="security" severity="low" pkgmanager="false" restart="false" interactive="false" kind="patch">
+<summary>Security update for bzip2</summary>
+<source url="https://updates.suse.com/SUSE/Updates/SLE-Module-Basesystem/15-SP1/x86_64/update" alias="SLE-Module-Basesystem15-SP1-Updates"/>
+</update>
+<update name="SUSE-SLE-Module-Basesystem-15-SP1-2019-1221" edition="1" arch="noarch" status="needed" category="security" severity="moderate" pkgmanager="false" restart="false" interactive="false" kind="patch">
+<summary>Security update for libxslt</summary>
+</update>
+<update name="SUSE-SLE-Module-Basesystem-15-SP1-2019-1229" edition="1" arch="noarch" status="not-needed" category="recommended" severity="moderate" pkgmanager="false" restart="false" interactive="false" kind="patch">
+<summary>Recommended update for sensors</summary>
+</update>
+<update name="SUSE-SLE-Module-Basesystem-15-SP1-2019-1258" edition="1" arch="noarch" status="needed" category="recommended" severity="moderate" pkgmanager="false" restart="false" interactive="false" kind="patch">
+<summary>Recommended update for postfix</summary>
+</update>
+</update-list>
+</update-status>
+</stream>`
+
+	// The table status column is translated, the status attribute is not.
+	localized := `<?xml version='1.0'?>
+<stream>
+<message type="info">Repository-Daten werden geladen...</message>
+<update-status version="0.6">
+<update-list>
+<update name="SUSE-SLE-Module-Basesystem-15-SP1-2019-1206" edition="1" arch="noarch" status="applied" category="security" severity="low" pkgmanager="false" restart="false" interactive="false" kind="patch">
+<summary>Sicherheitsupdate für bzip2</summary>
+</update>
+<update name="SUSE-SLE-Module-Basesystem-15-SP1-2019-1258" edition="1" arch="noarch" status="needed" category="recommended" severity="moderate" pkgmanager="false" restart="false" interactive="false" kind="patch">
+<summary>Empfohlenes Update für postfix</summary>
+</update>
+</update-list>
+</update-status>
+</stream>`
 
 	tests := []struct {
 		name      string
@@ -146,18 +237,20 @@ some junk data`
 			[]*ZypperPatch{{"SUSE-SLE-Module-Basesystem-15-SP1-2019-1221", "security", "moderate", "Security update for libxslt"}, {"SUSE-SLE-Module-Basesystem-15-SP1-2019-1258", "recommended", "moderate", "Recommended update for postfix"}},
 		},
 		{
-			"WithSinceField",
-			[]byte(withSinceField),
-			[]*ZypperPatch{{"SUSE-SLE-Module-Basesystem-15-SP1-2019-1206", "security", "low", "Security update for bzip2"}},
-			[]*ZypperPatch{{"SUSE-SLE-Module-Basesystem-15-SP1-2019-1221", "security", "moderate", "Security update for libxslt"}, {"SUSE-SLE-Module-Basesystem-15-SP1-2019-1258", "recommended", "moderate", "Recommended update for postfix"}},
+			"Localized",
+			[]byte(localized),
+			[]*ZypperPatch{{"SUSE-SLE-Module-Basesystem-15-SP1-2019-1206", "security", "low", "Sicherheitsupdate für bzip2"}},
+			[]*ZypperPatch{{"SUSE-SLE-Module-Basesystem-15-SP1-2019-1258", "recommended", "moderate", "Empfohlenes Update für postfix"}},
 		},
 		{"NoPackages", []byte("nothing here"), nil, nil},
 		{"nil", nil, nil, nil},
 	}
-	ctx := context.Background()
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotIns, gotAvail := parseZypperPatches(ctx, tt.data)
+			gotIns, gotAvail, err := parseZypperPatches(tt.data)
+			if err != nil {
+				t.Fatalf("parseZypperPatches() unexpected error: %v", err)
+			}
 			if !reflect.DeepEqual(gotIns, tt.wantIns) {
 				t.Errorf("parseZypperPatches() = %v, want %v", gotIns, tt.wantIns)
 			}
@@ -176,7 +269,7 @@ func TestZypperPatches(t *testing.T) {
 	runner = mockCommandRunner
 	expectedCmd := utilmocks.EqCmd(exec.Command(zypper, append(zypperListPatchesArgs, "--all")...))
 
-	data := []byte("SLE-Module-Basesystem15-SP1-Updates | SUSE-SLE-Module-Basesystem-15-SP1-2019-1258 | recommended | moderate  | ---         | needed     | Recommended update for postfix")
+	data := []byte(`<update-status version="0.6"><update-list><update name="SUSE-SLE-Module-Basesystem-15-SP1-2019-1258" edition="1" arch="noarch" status="needed" category="recommended" severity="moderate" kind="patch"><summary>Recommended update for postfix</summary></update></update-list></update-status>`)
 	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(data, []byte("stderr"), nil).Times(1)
 	ret, err := ZypperPatches(testCtx)
 	if err != nil {
@@ -192,6 +285,11 @@ func TestZypperPatches(t *testing.T) {
 	if _, err := ZypperPatches(testCtx); err == nil {
 		t.Errorf("did not get expected error")
 	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(data[:40], nil, nil).Times(1)
+	if _, err := ZypperPatches(testCtx); errcode.Of(err, "") != errcode.PackageManager {
+		t.Errorf("ZypperPatches() of truncated output: got error %v, want a %s error", err, errcode.PackageManager)
+	}
 }
 
 func TestZypperInstalledPatches(t *testing.T) {
@@ -202,7 +300,7 @@ func TestZypperInstalledPatches(t *testing.T) {
 	runner = mockCommandRunner
 	expectedCmd := utilmocks.EqCmd(exec.Command(zypper, append(zypperListPatchesArgs, "--all")...))
 
-	data := []byte("SLE-Module-Basesystem15-SP1-Updates | SUSE-SLE-Module-Basesystem-15-SP1-2019-1258 | recommended | moderate  | ---         | applied     | Recommended update for postfix")
+	data := []byte(`<update-status version="0.6"><update-list><update name="SUSE-SLE-Module-Basesystem-15-SP1-2019-1258" edition="1" arch="noarch" status="applied" category="recommended" severity="moderate" kind="patch"><summary>Recommended update for postfix</summary></update></update-list></update-status>`)
 	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(data, []byte("stderr"), nil).Times(1)
 	ret, err := ZypperInstalledPatches(testCtx)
 	if err != nil {
@@ -29,6 +29,16 @@ BuildArch: %{_arch}
 BuildRequires: systemd
 %endif
 
+# The agent runs in its own permissive SELinux domain, EL6 policy is too old.
+%if 0%{?rhel} >= 7 || 0%{?fedora}
+%global with_selinux 1
+%global selinuxtype targeted
+%global selinuxmodule google_osconfig_agent
+BuildRequires: selinux-policy-devel
+Requires(post): selinux-policy-base, policycoreutils
+Requires(postun): policycoreutils
+%endif
+
 %description
 Contains the OSConfig agent binary and startup scripts
 
@@ -37,6 +47,10 @@ Contains the OSConfig agent binary and startup scripts
 
 %build
 GOPATH=%{_gopath} CGO_ENABLED=0 %{_go} build -ldflags="-s -w -X main.version=%{version}-%{release}" -mod=readonly -o google_osconfig_agent
+%if 0%{?with_selinux}
+make -C packaging/selinux -f %{_datadir}/selinux/devel/Makefile %{selinuxmodule}.pp
+bzip2 -9 packaging/selinux/%{selinuxmodule}.pp
+%endif
 
 %install
 install -d "%{buildroot}/%{_docdir}/%{name}"
@@ -52,8 +66,13 @@ install -p -m 0644 %{name}.conf %{buildroot}/etc/init
 install -d %{buildroot}%{_unitdir}
 install -d %{buildroot}%{_presetdir}
 install -p -m 0644 %{name}.service %{buildroot}%{_unitdir}
+install -p -m 0644 packaging/systemd/%{name}-helper.service packaging/systemd/%{name}-helper.socket %{buildroot}%{_unitdir}
 install -p -m 0644 90-%{name}.preset %{buildroot}%{_presetdir}/90-%{name}.preset
 %endif
+%if 0%{?with_selinux}
+install -d %{buildroot}%{_datadir}/selinux/packages/%{selinuxtype}
+install -p -m 0644 packaging/selinux/%{selinuxmodule}.pp.bz2 %{buildroot}%{_datadir}/selinux/packages/%{selinuxtype}
+%endif
 
 %files
 %{_docdir}/%{name}
@@ -63,10 +82,26 @@ install -p -m 0644 90-%{name}.preset %{buildroot}%{_presetdir}/90-%{name}.preset
 /etc/init/%{name}.conf
 %else
 %{_unitdir}/%{name}.service
+%{_unitdir}/%{name}-helper.service
+%{_unitdir}/%{name}-helper.socket
 %{_presetdir}/90-%{name}.preset
 %endif
+%if 0%{?with_selinux}
+%{_datadir}/selinux/packages/%{selinuxtype}/%{selinuxmodule}.pp.bz2
+%endif
+
+%if 0%{?with_selinux}
+%pre
+%selinux_relabel_pre -s %{selinuxtype}
+%endif
 
 %post
+%if 0%{?with_selinux}
+# Load the policy module before the service is first started so the agent
+# starts in its own domain.
+%selinux_modules_install -s %{selinuxtype} %{_datadir}/selinux/packages/%{selinuxtype}/%{selinuxmodule}.pp.bz2
+%selinux_relabel_post -s %{selinuxtype}
+%endif
 %if 0%{?el6}
 if [ $1 -eq 1 ]; then
   # Start the service on first install
@@ -86,9 +121,15 @@ if [ $1 -eq 2 ]; then
 fi
 
 %preun
-%systemd_preun google-osconfig-agent.service
+%systemd_preun google-osconfig-agent.service google-osconfig-agent-helper.socket google-osconfig-agent-helper.service
 
 %postun
 %systemd_postun google-osconfig-agent.service
+%if 0%{?with_selinux}
+if [ $1 -eq 0 ]; then
+  %selinux_modules_uninstall -s %{selinuxtype} %{selinuxmodule}
+  %selinux_relabel_post -s %{selinuxtype}
+fi
+%endif
 
 %endif
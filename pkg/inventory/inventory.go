@@ -0,0 +1,125 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package inventory is the stable API to the OS inventory collectors of the
+// OS Config agent, for tools that embed them instead of running the agent.
+// It reads no agent configuration or metadata, everything it needs is passed
+// in Options.
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/GoogleCloudPlatform/osconfig/inventory"
+	agentpackages "github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/pkg/packages"
+)
+
+// SchemaVersion is the version of the JSON encoding of Inventory.
+const SchemaVersion = inventory.SchemaVersion
+
+// Options select what Collect gathers.
+type Options struct {
+	// Collectors are the names of the collectors to run, the
+	// packages.DefaultCollectors if empty.
+	Collectors []string
+	// BinaryPaths are scanned by the go and cargo collectors, these
+	// default to common install locations.
+	BinaryPaths []string
+	// PythonEnvPrefixes are scanned for Python environments by the pip
+	// collector.
+	PythonEnvPrefixes []string
+	// ManagedRoots are additional root file systems to list the installed
+	// packages of, an absolute path or pid:<pid> for the root of a process,
+	// optionally prefixed with a name as in name=/srv/root.
+	ManagedRoots []string
+	// BootIntegrity is reported as is, like the last Shielded VM integrity
+	// monitoring result.
+	BootIntegrity string
+}
+
+// Collect gathers the inventory of the host. Errors of individual
+// collectors leave their sections empty, an error is only returned for
+// invalid Options.
+func Collect(ctx context.Context, opts Options) (*Inventory, error) {
+	c, err := packages.NewCollectors(opts.Collectors)
+	if err != nil {
+		return nil, err
+	}
+	return fromAgent(inventory.Collect(ctx, inventory.Options{
+		Collectors:        agentpackages.Collectors(c),
+		BinaryPaths:       opts.BinaryPaths,
+		PythonEnvPrefixes: opts.PythonEnvPrefixes,
+		ManagedRoots:      opts.ManagedRoots,
+		BootIntegrity:     opts.BootIntegrity,
+	}))
+}
+
+// WriteJSON writes inv as indented JSON.
+func WriteJSON(w io.Writer, inv *Inventory) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(inv)
+}
+
+// WriteText writes a human readable summary of inv.
+func WriteText(w io.Writer, inv *Inventory) error {
+	a, err := toAgent(inv)
+	if err != nil {
+		return err
+	}
+	return inventory.WriteText(w, a)
+}
+
+// Read reads an inventory written by WriteJSON from a local file or a
+// gs://bucket/object URL.
+func Read(ctx context.Context, src string) (*Inventory, error) {
+	inv, err := inventory.ReadInventory(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	return fromAgent(inv)
+}
+
+// DiffPackages returns the packages added, removed, upgraded or downgraded
+// from before to after.
+func DiffPackages(before, after *Inventory) (*Diff, error) {
+	b, err := toAgent(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := toAgent(after)
+	if err != nil {
+		return nil, err
+	}
+	d := inventory.DiffPackages(b.InstalledPackages, a.InstalledPackages)
+	return &Diff{
+		Added:      changesFromAgent(d.Added),
+		Removed:    changesFromAgent(d.Removed),
+		Upgraded:   changesFromAgent(d.Upgraded),
+		Downgraded: changesFromAgent(d.Downgraded),
+	}, nil
+}
+
+// WriteDiffJSON writes d as indented JSON.
+func WriteDiffJSON(w io.Writer, d *Diff) error {
+	return inventory.WriteDiff(w, diffToAgent(d), inventory.FormatJSON)
+}
+
+// WriteDiffText writes a human readable summary of d.
+func WriteDiffText(w io.Writer, d *Diff) error {
+	return inventory.WriteDiff(w, diffToAgent(d), inventory.FormatText)
+}
@@ -0,0 +1,76 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/pkg/packages"
+)
+
+func TestCollectInvalidOptions(t *testing.T) {
+	if inv, err := Collect(context.Background(), Options{Collectors: []string{"cpan"}}); err == nil {
+		t.Errorf("Collect with an unknown collector = %+v, want error", inv)
+	}
+}
+
+// Embedding the package must not register the flags of the agent.
+func TestNoAgentFlags(t *testing.T) {
+	flag.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, "test.") {
+			t.Errorf("flag %q is registered", f.Name)
+		}
+	})
+}
+
+func TestAgentRoundTrip(t *testing.T) {
+	pkg := &packages.PkgInfo{Name: "osconfig-agent", Arch: "x86_64", Version: "1.0", Source: packages.Source{Name: "osconfig"}}
+	want := &Inventory{
+		SchemaVersion:   SchemaVersion,
+		Hostname:        "host",
+		InstanceCreated: "2024-01-02T00:00:00Z",
+		InstalledPackages: &packages.Packages{
+			Deb:                []*packages.PkgInfo{pkg},
+			ZypperPatches:      []*packages.ZypperPatch{{Name: "patch", Category: "security"}},
+			WindowsApplication: []*packages.WindowsApplication{{DisplayName: "app", InstallDate: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}},
+		},
+		ManagedRoots:   []*ManagedRootInventory{{Name: "root", Path: "/srv/root", Error: "error"}},
+		Repositories:   []*RepositoryHealth{{Manager: "apt", Source: "/etc/apt/sources.list", Status: "ok"}},
+		ListeningPorts: []*ListeningPort{{Protocol: "tcp", Family: "ipv4", Port: 22, PID: 1}},
+		ScheduledJobs:  []*ScheduledJob{{Source: "cron", Name: "/etc/crontab:1", Enabled: true}},
+		Antivirus: &AntivirusStatus{
+			Defender: &DefenderStatus{Enabled: true, SignatureUpdated: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+			Products: []*AntivirusProduct{{Name: "Defender", UpToDate: true}},
+		},
+		Agent: &AgentIdentity{Path: "/usr/bin/google_osconfig_agent", Package: pkg},
+	}
+
+	a, err := toAgent(want)
+	if err != nil {
+		t.Fatalf("toAgent: %v", err)
+	}
+	got, err := fromAgent(a)
+	if err != nil {
+		t.Fatalf("fromAgent: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fromAgent(toAgent(inv)) = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,249 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/inventory"
+	agentpackages "github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/pkg/packages"
+)
+
+// Inventory is the inventory of an instance, encoded as in SchemaVersion of
+// the inventory of the agent.
+type Inventory struct {
+	SchemaVersion        string
+	Hostname             string
+	LongName             string
+	ShortName            string
+	Version              string
+	Architecture         string
+	KernelVersion        string
+	KernelRelease        string
+	OSConfigAgentVersion string
+	Image                string
+	// InstanceCreated is when the instance was created, in RFC 3339 format,
+	// empty if not known. It is only set in inventories collected by the
+	// agent.
+	InstanceCreated string
+	ImageID         string
+	ImageVersion    string
+	BuildID         string
+	// TPMVersion is the version of the (v)TPM, empty without one, and
+	// BootIntegrity is as passed in Options.
+	TPMVersion        string
+	BootIntegrity     string
+	InstalledPackages *packages.Packages
+	PackageUpdates    *packages.Packages
+	ManagedRoots      []*ManagedRootInventory
+	// Repositories is the health of the configured package repositories.
+	Repositories []*RepositoryHealth
+	// ListeningPorts are the listening sockets, only collected with the
+	// ports collector.
+	ListeningPorts []*ListeningPort
+	// ScheduledJobs are the cron jobs, systemd timers or scheduled tasks,
+	// only collected with the scheduledjobs collector.
+	ScheduledJobs []*ScheduledJob
+	// Antivirus is the state of the antivirus products on Windows.
+	Antivirus *AntivirusStatus
+	// Agent identifies the agent binary, it is only set in inventories
+	// collected by the agent.
+	Agent       *AgentIdentity
+	LastUpdated string
+}
+
+// ManagedRootInventory is the inventory of a managed root.
+type ManagedRootInventory struct {
+	Name              string
+	Path              string
+	InstalledPackages *packages.Packages
+	Error             string `json:",omitempty"`
+}
+
+// RepositoryHealth is the health of a configured package repository.
+type RepositoryHealth struct {
+	// Manager is the package manager, apt, yum, zypper or googet.
+	Manager string
+	// Source is the file the repository is configured in.
+	Source string
+	URL    string
+	// Status is ok, unreachable, gpg_error or not_checked.
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+// ListeningPort is a listening socket.
+type ListeningPort struct {
+	// Protocol is tcp or udp, Family ipv4 or ipv6.
+	Protocol, Family string
+	Address          string
+	Port             int
+	// Exposure is loopback, any or address.
+	Exposure string
+	// Process and PID own the socket, when known.
+	Process string `json:",omitempty"`
+	PID     int    `json:",omitempty"`
+}
+
+// ScheduledJob is a cron job, systemd timer or scheduled task.
+type ScheduledJob struct {
+	// Source is cron, systemd or taskscheduler.
+	Source string
+	// Name is the crontab file and line, the timer unit or the task path.
+	Name string
+	// Schedule is like the crontab schedule, OnCalendar=daily or Daily.
+	Schedule string
+	// User runs the job, when known.
+	User    string `json:",omitempty"`
+	Command string
+	Enabled bool
+}
+
+// AntivirusStatus is the state of the antivirus products on Windows.
+type AntivirusStatus struct {
+	// Defender is the state of Microsoft Defender Antivirus, nil if it is not
+	// installed or its service is not running.
+	Defender *DefenderStatus `json:",omitempty"`
+	// Products are the antivirus products registered with Security Center,
+	// Defender included.
+	Products []*AntivirusProduct `json:",omitempty"`
+}
+
+// DefenderStatus is the state of Microsoft Defender Antivirus, times are zero
+// if they are not known.
+type DefenderStatus struct {
+	Enabled            bool
+	RealTimeProtection bool
+	SignatureVersion   string
+	SignatureUpdated   time.Time
+	LastQuickScan      time.Time
+	LastFullScan       time.Time
+}
+
+// AntivirusProduct is an antivirus product registered with Security Center.
+type AntivirusProduct struct {
+	Name string
+	// Path is the executable reporting the state of the product.
+	Path     string
+	Enabled  bool
+	UpToDate bool
+}
+
+// AgentIdentity identifies the agent binary.
+type AgentIdentity struct {
+	Path   string
+	SHA256 string
+	// Package is the package that installed the binary, nil if it was not
+	// installed by a package manager.
+	Package *packages.PkgInfo `json:",omitempty"`
+	// Modified is set when the binary differs from the one its package
+	// installed.
+	Modified bool   `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+// Diff is the package changes between two inventories.
+type Diff struct {
+	Added      []*PackageChange
+	Removed    []*PackageChange
+	Upgraded   []*PackageChange
+	Downgraded []*PackageChange
+}
+
+// PackageChange is a package added, removed, upgraded or downgraded.
+type PackageChange struct {
+	Manager string
+	Name    string
+	Arch    string `json:",omitempty"`
+	// OldVersion is empty for an added package, NewVersion for a removed
+	// one.
+	OldVersion string `json:",omitempty"`
+	NewVersion string `json:",omitempty"`
+}
+
+// fromAgent returns the inventory collected by the agent as an Inventory.
+// The types of the agent change with it, the inventory is converted through
+// its JSON encoding, which only changes with SchemaVersion.
+func fromAgent(inv *inventory.InstanceInventory) (*Inventory, error) {
+	var out *Inventory
+	if err := recode(inv, &out); err != nil {
+		return nil, err
+	}
+	// Windows applications are not part of the encoding.
+	if out != nil {
+		if p := inv.InstalledPackages; p != nil && out.InstalledPackages != nil {
+			for _, a := range p.WindowsApplication {
+				v := packages.WindowsApplication(*a)
+				out.InstalledPackages.WindowsApplication = append(out.InstalledPackages.WindowsApplication, &v)
+			}
+		}
+	}
+	return out, nil
+}
+
+// toAgent returns inv as the inventory of the agent, see fromAgent.
+func toAgent(inv *Inventory) (*inventory.InstanceInventory, error) {
+	var out *inventory.InstanceInventory
+	if err := recode(inv, &out); err != nil {
+		return nil, err
+	}
+	if out != nil {
+		if p := inv.InstalledPackages; p != nil && out.InstalledPackages != nil {
+			for _, a := range p.WindowsApplication {
+				v := agentpackages.WindowsApplication(*a)
+				out.InstalledPackages.WindowsApplication = append(out.InstalledPackages.WindowsApplication, &v)
+			}
+		}
+	}
+	return out, nil
+}
+
+// recode converts in to out, which have the same JSON encoding.
+func recode(in, out any) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+func changesFromAgent(in []*inventory.PackageChange) []*PackageChange {
+	var out []*PackageChange
+	for _, c := range in {
+		v := PackageChange(*c)
+		out = append(out, &v)
+	}
+	return out
+}
+
+func changesToAgent(in []*PackageChange) []*inventory.PackageChange {
+	var out []*inventory.PackageChange
+	for _, c := range in {
+		v := inventory.PackageChange(*c)
+		out = append(out, &v)
+	}
+	return out
+}
+
+func diffToAgent(d *Diff) *inventory.Diff {
+	return &inventory.Diff{
+		Added:      changesToAgent(d.Added),
+		Removed:    changesToAgent(d.Removed),
+		Upgraded:   changesToAgent(d.Upgraded),
+		Downgraded: changesToAgent(d.Downgraded),
+	}
+}
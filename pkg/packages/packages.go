@@ -0,0 +1,190 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package packages is the stable API to the package collectors of the OS
+// Config agent, for tools that embed them instead of running the agent. It
+// reads no agent configuration or metadata, everything it needs is passed
+// in.
+package packages
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+// Collectors is a set of enabled collectors, see NewCollectors.
+type Collectors map[string]bool
+
+// Enabled reports whether the named collector is enabled.
+func (c Collectors) Enabled(name string) bool {
+	return c[name]
+}
+
+// CommandRunner runs the commands of the collectors.
+type CommandRunner interface {
+	Run(ctx context.Context, command *exec.Cmd) ([]byte, []byte, error)
+}
+
+// FS reads the package manager configuration, like repository files and
+// keys.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	Glob(pattern string) ([]string, error)
+}
+
+// Env is the environment collectors run in, the command runners, file system
+// and package managers found, see WithEnv.
+type Env struct {
+	Runner CommandRunner
+	// PtyRunner runs the commands that need a terminal.
+	PtyRunner CommandRunner
+	FS        FS
+
+	// The package managers found.
+	AptExists        bool
+	DpkgExists       bool
+	DpkgQueryExists  bool
+	YumExists        bool
+	ZypperExists     bool
+	PacmanExists     bool
+	FlatpakExists    bool
+	RPMExists        bool
+	RPMQueryExists   bool
+	COSPkgInfoExists bool
+	GemExists        bool
+	PipExists        bool
+	NpmExists        bool
+	GooGetExists     bool
+	MSIExists        bool
+}
+
+// DefaultEnv returns the environment of the host.
+func DefaultEnv() *Env {
+	e := packages.DefaultEnv()
+	return &Env{
+		Runner:           e.Runner,
+		PtyRunner:        e.PtyRunner,
+		FS:               e.FS,
+		AptExists:        e.AptExists,
+		DpkgExists:       e.DpkgExists,
+		DpkgQueryExists:  e.DpkgQueryExists,
+		YumExists:        e.YumExists,
+		ZypperExists:     e.ZypperExists,
+		PacmanExists:     e.PacmanExists,
+		FlatpakExists:    e.FlatpakExists,
+		RPMExists:        e.RPMExists,
+		RPMQueryExists:   e.RPMQueryExists,
+		COSPkgInfoExists: e.COSPkgInfoExists,
+		GemExists:        e.GemExists,
+		PipExists:        e.PipExists,
+		NpmExists:        e.NpmExists,
+		GooGetExists:     e.GooGetExists,
+		MSIExists:        e.MSIExists,
+	}
+}
+
+// WithEnv returns a copy of ctx collectors run in env with.
+func WithEnv(ctx context.Context, env *Env) context.Context {
+	return packages.WithEnv(ctx, &packages.Env{
+		Runner:           env.Runner,
+		PtyRunner:        env.PtyRunner,
+		FS:               env.FS,
+		AptExists:        env.AptExists,
+		DpkgExists:       env.DpkgExists,
+		DpkgQueryExists:  env.DpkgQueryExists,
+		YumExists:        env.YumExists,
+		ZypperExists:     env.ZypperExists,
+		PacmanExists:     env.PacmanExists,
+		FlatpakExists:    env.FlatpakExists,
+		RPMExists:        env.RPMExists,
+		RPMQueryExists:   env.RPMQueryExists,
+		COSPkgInfoExists: env.COSPkgInfoExists,
+		GemExists:        env.GemExists,
+		PipExists:        env.PipExists,
+		NpmExists:        env.NpmExists,
+		GooGetExists:     env.GooGetExists,
+		MSIExists:        env.MSIExists,
+	})
+}
+
+// Names of the optional collectors, see OptionalCollectors.
+const (
+	LicensesCollector      = packages.LicensesCollector
+	GoCollector            = packages.GoCollector
+	CargoCollector         = packages.CargoCollector
+	PortsCollector         = packages.PortsCollector
+	ScheduledJobsCollector = packages.ScheduledJobsCollector
+)
+
+// AntivirusCollector is one of the DefaultCollectors on Windows.
+const AntivirusCollector = packages.AntivirusCollector
+
+// DefaultCollectors returns the names of the collectors run when none are
+// selected, one per package manager and the repository health check.
+func DefaultCollectors() []string {
+	return slices.Clone(packages.DefaultCollectors)
+}
+
+// OptionalCollectors returns the names of the collectors that are only run
+// when selected.
+func OptionalCollectors() []string {
+	return slices.Clone(packages.OptionalCollectors)
+}
+
+// NewCollectors returns the set of the named collectors, the
+// DefaultCollectors if names is empty. Names are case insensitive, unknown
+// names are an error.
+func NewCollectors(names []string) (Collectors, error) {
+	if len(names) == 0 {
+		names = packages.DefaultCollectors
+	}
+	c := Collectors{}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if !slices.Contains(packages.DefaultCollectors, name) && !slices.Contains(packages.OptionalCollectors, name) {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		c[name] = true
+	}
+	return c, nil
+}
+
+// Installed returns the packages installed by the package managers whose
+// collectors are named, see NewCollectors. Packages of the package managers
+// that could be listed are returned along with an error for the others.
+func Installed(ctx context.Context, collectors []string) (*Packages, error) {
+	c, err := NewCollectors(collectors)
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := packages.GetInstalledPackages(ctx, packages.Collectors(c))
+	return fromAgent(pkgs), err
+}
+
+// Updates returns the available updates of the package managers whose
+// collectors are named, see NewCollectors. Updates of the package managers
+// that could be listed are returned along with an error for the others.
+func Updates(ctx context.Context, collectors []string) (*Packages, error) {
+	c, err := NewCollectors(collectors)
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := packages.GetPackageUpdates(ctx, packages.Collectors(c))
+	return fromAgent(pkgs), err
+}
@@ -0,0 +1,53 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewCollectors(t *testing.T) {
+	defaults := Collectors{}
+	for _, name := range DefaultCollectors() {
+		defaults[name] = true
+	}
+
+	tests := []struct {
+		desc    string
+		names   []string
+		want    Collectors
+		wantErr bool
+	}{
+		{"defaults", nil, defaults, false},
+		{"selected", []string{"deb", "Ports"}, Collectors{"deb": true, "ports": true}, false},
+		{"unknown", []string{"deb", "cpan"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := NewCollectors(tt.names)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: NewCollectors(%q) error = %v, want error %t", tt.desc, tt.names, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: NewCollectors(%q) = %v, want %v", tt.desc, tt.names, got, tt.want)
+		}
+	}
+
+	// Callers can't change the collectors of the agent.
+	DefaultCollectors()[0] = "changed"
+	if DefaultCollectors()[0] == "changed" {
+		t.Error("DefaultCollectors returned the agent's own slice")
+	}
+}
@@ -0,0 +1,167 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+// Packages are the packages of each package manager. It is encoded as in
+// the inventory of the agent.
+type Packages struct {
+	Yum                []*PkgInfo            `json:"yum,omitempty"`
+	Rpm                []*PkgInfo            `json:"rpm,omitempty"`
+	Apt                []*PkgInfo            `json:"apt,omitempty"`
+	Deb                []*PkgInfo            `json:"deb,omitempty"`
+	Zypper             []*PkgInfo            `json:"zypper,omitempty"`
+	ZypperPatches      []*ZypperPatch        `json:"zypperPatches,omitempty"`
+	Pacman             []*PkgInfo            `json:"pacman,omitempty"`
+	Flatpak            []*PkgInfo            `json:"flatpak,omitempty"`
+	COS                []*PkgInfo            `json:"cos,omitempty"`
+	Gem                []*PkgInfo            `json:"gem,omitempty"`
+	Pip                []*PkgInfo            `json:"pip,omitempty"`
+	Npm                []*PkgInfo            `json:"npm,omitempty"`
+	Go                 []*PkgInfo            `json:"go,omitempty"`
+	Cargo              []*PkgInfo            `json:"cargo,omitempty"`
+	GooGet             []*PkgInfo            `json:"googet,omitempty"`
+	WUA                []*WUAPackage         `json:"wua,omitempty"`
+	QFE                []*QFEPackage         `json:"qfe,omitempty"`
+	WindowsApplication []*WindowsApplication `json:"-"`
+}
+
+// PkgInfo describes a package.
+type PkgInfo struct {
+	Name, Arch, RawArch, Version string
+
+	Source Source
+
+	// InstallTime is when an installed package was last installed or
+	// upgraded, nil if the package manager does not record it. Repository
+	// is the repository an installed package came from as named by its
+	// package manager: the apt source, the dnf or zypper repository or the
+	// flatpak remote, empty if unknown.
+	InstallTime *time.Time `json:",omitempty"`
+	Repository  string     `json:",omitempty"`
+
+	// Licenses are the declared licenses, only collected for the licenses
+	// collector.
+	Licenses []string `json:",omitempty"`
+
+	// Location is where a Go binary or cargo install root was found, the
+	// Python interpreter or environment of a pip package, or the gem
+	// command of a gem.
+	Location string `json:",omitempty"`
+}
+
+func (i *PkgInfo) String() string {
+	return fmt.Sprintf("%s %s %s", i.Name, i.Arch, i.Version)
+}
+
+// Source is the source package a binary package was built from.
+type Source struct {
+	Name, Version string
+}
+
+// ZypperPatch describes a Zypper patch.
+type ZypperPatch struct {
+	Name, Category, Severity, Summary string
+}
+
+// WUAPackage describes a Windows Update Agent package.
+type WUAPackage struct {
+	LastDeploymentChangeTime time.Time
+	Title                    string
+	Description              string
+	SupportURL               string
+	UpdateID                 string
+	Categories               []string
+	KBArticleIDs             []string
+	MoreInfoURLs             []string
+	CategoryIDs              []string
+	RevisionNumber           int32
+}
+
+// QFEPackage describes a Windows Quick Fix Engineering package.
+type QFEPackage struct {
+	Caption, Description, HotFixID, InstalledOn string
+}
+
+// WindowsApplication describes a Windows Application.
+type WindowsApplication struct {
+	DisplayName    string
+	DisplayVersion string
+	InstallDate    time.Time
+	Publisher      string
+	HelpLink       string
+}
+
+// fromAgent returns the packages collected by the agent as Packages. The
+// types of the agent change with it, the ones of this package are stable.
+func fromAgent(p *packages.Packages) *Packages {
+	if p == nil {
+		return nil
+	}
+	return &Packages{
+		Yum:                convert(p.Yum, pkgInfoFromAgent),
+		Rpm:                convert(p.Rpm, pkgInfoFromAgent),
+		Apt:                convert(p.Apt, pkgInfoFromAgent),
+		Deb:                convert(p.Deb, pkgInfoFromAgent),
+		Zypper:             convert(p.Zypper, pkgInfoFromAgent),
+		ZypperPatches:      convert(p.ZypperPatches, func(z *packages.ZypperPatch) *ZypperPatch { v := ZypperPatch(*z); return &v }),
+		Pacman:             convert(p.Pacman, pkgInfoFromAgent),
+		Flatpak:            convert(p.Flatpak, pkgInfoFromAgent),
+		COS:                convert(p.COS, pkgInfoFromAgent),
+		Gem:                convert(p.Gem, pkgInfoFromAgent),
+		Pip:                convert(p.Pip, pkgInfoFromAgent),
+		Npm:                convert(p.Npm, pkgInfoFromAgent),
+		Go:                 convert(p.Go, pkgInfoFromAgent),
+		Cargo:              convert(p.Cargo, pkgInfoFromAgent),
+		GooGet:             convert(p.GooGet, pkgInfoFromAgent),
+		WUA:                convert(p.WUA, func(w *packages.WUAPackage) *WUAPackage { v := WUAPackage(*w); return &v }),
+		QFE:                convert(p.QFE, func(q *packages.QFEPackage) *QFEPackage { v := QFEPackage(*q); return &v }),
+		WindowsApplication: convert(p.WindowsApplication, func(a *packages.WindowsApplication) *WindowsApplication { v := WindowsApplication(*a); return &v }),
+	}
+}
+
+func pkgInfoFromAgent(p *packages.PkgInfo) *PkgInfo {
+	return &PkgInfo{
+		Name:        p.Name,
+		Arch:        p.Arch,
+		RawArch:     p.RawArch,
+		Version:     p.Version,
+		Source:      Source(p.Source),
+		InstallTime: p.InstallTime,
+		Repository:  p.Repository,
+		Licenses:    p.Licenses,
+		Location:    p.Location,
+	}
+}
+
+// convert converts each non nil element of in with f.
+func convert[T, U any](in []*T, f func(*T) *U) []*U {
+	if in == nil {
+		return nil
+	}
+	out := make([]*U, 0, len(in))
+	for _, e := range in {
+		if e != nil {
+			out = append(out, f(e))
+		}
+	}
+	return out
+}
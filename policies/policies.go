@@ -64,6 +64,11 @@ func run(ctx context.Context) {
 
 // Run looks up osconfigs and applies them using tasker.Enqueue.
 func Run(ctx context.Context) {
+	// Guest policies have no validation mode.
+	if agentconfig.ReadOnly() {
+		clog.Infof(ctx, "Not running guest policies, the agent is in read-only mode.")
+		return
+	}
 	tasker.Enqueue(ctx, "Run GuestPolicies", func() { run(ctx) })
 }
 
@@ -106,6 +111,7 @@ func setConfig(ctx context.Context, egp *agentendpointpb.EffectiveGuestPolicy) {
 	var aptInstallPkgs, aptRemovePkgs, aptUpdatePkgs []*agentendpointpb.Package
 	var yumInstallPkgs, yumRemovePkgs, yumUpdatePkgs []*agentendpointpb.Package
 	var zypperInstallPkgs, zypperRemovePkgs, zypperUpdatePkgs []*agentendpointpb.Package
+	var pacmanInstallPkgs, pacmanRemovePkgs, pacmanUpdatePkgs []*agentendpointpb.Package
 	for _, pkg := range egp.GetPackages() {
 		switch pkg.GetPackage().GetManager() {
 		case agentendpointpb.Package_ANY, agentendpointpb.Package_MANAGER_UNSPECIFIED:
@@ -115,16 +121,19 @@ func setConfig(ctx context.Context, egp *agentendpointpb.EffectiveGuestPolicy) {
 				aptInstallPkgs = append(aptInstallPkgs, pkg.GetPackage())
 				yumInstallPkgs = append(yumInstallPkgs, pkg.GetPackage())
 				zypperInstallPkgs = append(zypperInstallPkgs, pkg.GetPackage())
+				pacmanInstallPkgs = append(pacmanInstallPkgs, pkg.GetPackage())
 			case agentendpointpb.DesiredState_REMOVED:
 				gooRemovePkgs = append(gooRemovePkgs, pkg.GetPackage())
 				aptRemovePkgs = append(aptRemovePkgs, pkg.GetPackage())
 				yumRemovePkgs = append(yumRemovePkgs, pkg.GetPackage())
 				zypperRemovePkgs = append(zypperRemovePkgs, pkg.GetPackage())
+				pacmanRemovePkgs = append(pacmanRemovePkgs, pkg.GetPackage())
 			case agentendpointpb.DesiredState_UPDATED:
 				gooUpdatePkgs = append(gooUpdatePkgs, pkg.GetPackage())
 				aptUpdatePkgs = append(aptUpdatePkgs, pkg.GetPackage())
 				yumUpdatePkgs = append(yumUpdatePkgs, pkg.GetPackage())
 				zypperUpdatePkgs = append(zypperUpdatePkgs, pkg.GetPackage())
+				pacmanUpdatePkgs = append(pacmanUpdatePkgs, pkg.GetPackage())
 			}
 		case agentendpointpb.Package_GOO:
 			switch pkg.GetPackage().GetDesiredState() {
@@ -209,6 +218,14 @@ func setConfig(ctx context.Context, egp *agentendpointpb.EffectiveGuestPolicy) {
 			clog.Errorf(ctx, "Error performing zypper changes: %v", err)
 		}
 	}
+
+	if packages.PacmanExists {
+		if err := retryutil.RetryFunc(ctx, 1*time.Minute, "Applying pacman changes", func() error {
+			return pacmanChanges(ctx, pacmanInstallPkgs, pacmanRemovePkgs, pacmanUpdatePkgs)
+		}); err != nil {
+			clog.Errorf(ctx, "Error performing pacman changes: %v", err)
+		}
+	}
 }
 
 func checksum(r io.Reader) hash.Hash {
@@ -86,7 +86,7 @@ func InstallRecipe(ctx context.Context, recipe *agentendpointpb.SoftwareRecipe)
 		switch {
 		case step.GetFileCopy() != nil:
 			stepType = "CopyFile"
-			err = stepCopyFile(step.GetFileCopy(), artifacts, runEnvs, stepDir)
+			err = stepCopyFile(ctx, step.GetFileCopy(), artifacts, runEnvs, stepDir)
 		case step.GetArchiveExtraction() != nil:
 			stepType = "ExtractArchive"
 			err = stepExtractArchive(ctx, step.GetArchiveExtraction(), artifacts, runEnvs, stepDir)
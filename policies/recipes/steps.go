@@ -17,6 +17,7 @@ package recipes
 import (
 	"archive/tar"
 	"archive/zip"
+	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
 	"context"
@@ -32,6 +33,7 @@ import (
 	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/journal"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 	"github.com/ulikunitz/xz"
@@ -46,7 +48,7 @@ var extensionMap = map[agentendpointpb.SoftwareRecipe_Step_RunScript_Interpreter
 	agentendpointpb.SoftwareRecipe_Step_RunScript_POWERSHELL:              ".ps1",
 }
 
-func stepCopyFile(step *agentendpointpb.SoftwareRecipe_Step_CopyFile, artifacts map[string]string, runEnvs []string, stepDir string) error {
+func stepCopyFile(ctx context.Context, step *agentendpointpb.SoftwareRecipe_Step_CopyFile, artifacts map[string]string, runEnvs []string, stepDir string) error {
 	dest, err := util.NormPath(step.Destination)
 	if err != nil {
 		return err
@@ -84,6 +86,7 @@ func stepCopyFile(step *agentendpointpb.SoftwareRecipe_Step_CopyFile, artifacts
 	defer reader.Close()
 
 	_, err = util.AtomicWriteFileStream(reader, "", dest, permissions)
+	journal.Record(ctx, journal.FileWrite, dest, err)
 
 	return err
 }
@@ -479,7 +482,9 @@ func stepRunScript(ctx context.Context, step *agentendpointpb.SoftwareRecipe_Ste
 	return executeCommand(ctx, cmd, args, stepDir, runEnvs, step.AllowedExitCodes)
 }
 
-func executeCommand(ctx context.Context, cmd string, args []string, workDir string, runEnvs []string, allowedExitCodes []int32) error {
+func executeCommand(ctx context.Context, cmd string, args []string, workDir string, runEnvs []string, allowedExitCodes []int32) (err error) {
+	defer func() { journal.Record(ctx, journal.ScriptRun, strings.Join(append([]string{cmd}, args...), " "), err) }()
+
 	cmdObj := exec.Command(cmd, args...)
 	cmdObj.Dir = workDir
 	defaultEnv, err := createDefaultEnvironment()
@@ -489,8 +494,11 @@ func executeCommand(ctx context.Context, cmd string, args []string, workDir stri
 	cmdObj.Env = append(cmdObj.Env, defaultEnv...)
 	cmdObj.Env = append(cmdObj.Env, runEnvs...)
 
-	o, err := cmdObj.CombinedOutput()
-	clog.Infof(ctx, "Combined output for %q command:\n%s", cmd, o)
+	var out bytes.Buffer
+	cmdObj.Stdout = &out
+	cmdObj.Stderr = &out
+	err = util.RunWithProcessGroup(ctx, cmdObj)
+	clog.Infof(ctx, "Combined output for %q command:\n%s", cmd, out.Bytes())
 	if err == nil {
 		return nil
 	}
@@ -0,0 +1,241 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package preflight runs the diagnostics of the check CLI action: whether
+// the agent can reach the metadata server and the agent endpoint, get an
+// identity token, use a package manager and write its state, the usual
+// reasons an agent fails without reporting anything.
+package preflight
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+// Output formats for Write.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Statuses of a Result.
+const (
+	StatusOK      = "ok"
+	StatusWarning = "warning"
+	StatusFailed  = "failed"
+	// StatusSkipped is for checks that depend on a check that failed.
+	StatusSkipped = "skipped"
+)
+
+// Result is the outcome of one check.
+type Result struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report is the outcome of all checks.
+type Report struct {
+	AgentVersion string    `json:"agentVersion"`
+	Time         time.Time `json:"time"`
+	Results      []Result  `json:"results"`
+}
+
+// Failed reports whether any check failed.
+func (r *Report) Failed() bool {
+	for _, res := range r.Results {
+		if res.Status == StatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// Overridden in tests.
+var (
+	refreshMetadata = agentconfig.Refresh
+	idToken         = agentconfig.IDToken
+	dialEndpoint    = dialTLS
+	geteuid         = os.Geteuid
+	goos            = runtime.GOOS
+	cacheDir        = agentconfig.CacheDir
+)
+
+// dialTLS opens a TLS connection to addr, a host:port, like the agent
+// endpoint client does.
+func dialTLS(ctx context.Context, addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	d := &tls.Dialer{Config: &tls.Config{ServerName: strings.TrimSuffix(host, ".")}}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func result(name string, err error, detail string) Result {
+	if err != nil {
+		return Result{Name: name, Status: StatusFailed, Detail: err.Error()}
+	}
+	return Result{Name: name, Status: StatusOK, Detail: detail}
+}
+
+func checkMetadata(ctx context.Context) Result {
+	if err := refreshMetadata(ctx); err != nil {
+		return result("metadata", err, "")
+	}
+	return result("metadata", nil, fmt.Sprintf("project %q, zone %q, instance %q", agentconfig.ProjectID(), agentconfig.Zone(), agentconfig.Name()))
+}
+
+func checkFeatures() Result {
+	var enabled []string
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{
+		{"OS inventory", agentconfig.OSInventoryEnabled()},
+		{"OS policies and patch", agentconfig.TaskNotificationEnabled()},
+		{"guest policies", agentconfig.GuestPoliciesEnabled()},
+	} {
+		if f.on {
+			enabled = append(enabled, f.name)
+		}
+	}
+	if len(enabled) == 0 {
+		return Result{Name: "features", Status: StatusWarning, Detail: "no feature is enabled, set enable-osconfig=TRUE in the project or instance metadata"}
+	}
+	return Result{Name: "features", Status: StatusOK, Detail: strings.Join(enabled, ", ") + " enabled"}
+}
+
+func checkIdentityToken() Result {
+	_, err := idToken()
+	return result("identity token", err, "")
+}
+
+func checkEndpoint(ctx context.Context) Result {
+	addr := agentconfig.SvcEndpoint()
+	if err := dialEndpoint(ctx, addr); err != nil {
+		return result("agent endpoint", fmt.Errorf("error connecting to %s: %v", addr, err), "")
+	}
+	return result("agent endpoint", nil, addr)
+}
+
+// packageManagers returns the package managers the agent can install and
+// remove packages with.
+func packageManagers() []string {
+	var ms []string
+	for _, m := range []struct {
+		name   string
+		exists bool
+	}{
+		{"apt", packages.AptExists},
+		{"yum", packages.YumExists},
+		{"zypper", packages.ZypperExists},
+		{"pacman", packages.PacmanExists},
+		{"googet", packages.GooGetExists},
+		{"msi", packages.MSIExists},
+	} {
+		if m.exists {
+			ms = append(ms, m.name)
+		}
+	}
+	return ms
+}
+
+func checkPackageManagers() Result {
+	ms := packageManagers()
+	if len(ms) == 0 {
+		return Result{Name: "package managers", Status: StatusWarning, Detail: "no supported package manager found, OS policies can't install packages and inventory only lists what other collectors find"}
+	}
+	return Result{Name: "package managers", Status: StatusOK, Detail: strings.Join(ms, ", ")}
+}
+
+func checkPermissions() Result {
+	dir := cacheDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return result("permissions", fmt.Errorf("can't create the state directory: %v", err), "")
+	}
+	f, err := os.CreateTemp(dir, "preflight_")
+	if err != nil {
+		return result("permissions", fmt.Errorf("can't write to the state directory: %v", err), "")
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	if goos != "windows" && geteuid() != 0 {
+		if agentconfig.PrivilegedHelperSocket() != "" {
+			return Result{Name: "permissions", Status: StatusOK, Detail: fmt.Sprintf("not root, enforcing through the privileged helper at %s", agentconfig.PrivilegedHelperSocket())}
+		}
+		return Result{Name: "permissions", Status: StatusWarning, Detail: "not running as root, the agent only reports inventory and evaluates OS policies"}
+	}
+	return result("permissions", nil, fmt.Sprintf("can write to %s", filepath.Clean(dir)))
+}
+
+// Run runs all checks, those needing the metadata server are skipped when it
+// can't be reached. Each network check is bounded by timeout.
+func Run(ctx context.Context, timeout time.Duration) *Report {
+	r := &Report{AgentVersion: agentconfig.Version(), Time: time.Now()}
+	withTimeout := func(f func(context.Context) Result) Result {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return f(ctx)
+	}
+
+	md := withTimeout(checkMetadata)
+	r.Results = append(r.Results, md)
+	if md.Status == StatusFailed {
+		for _, name := range []string{"features", "identity token", "agent endpoint"} {
+			r.Results = append(r.Results, Result{Name: name, Status: StatusSkipped, Detail: "the metadata server can't be reached"})
+		}
+	} else {
+		r.Results = append(r.Results, checkFeatures(), checkIdentityToken(), withTimeout(checkEndpoint))
+	}
+	r.Results = append(r.Results, checkPackageManagers(), checkPermissions())
+	return r
+}
+
+// Write writes r to w in format, FormatText or FormatJSON.
+func Write(w io.Writer, r *Report, format string) error {
+	switch format {
+	case FormatText:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CHECK\tSTATUS\tDETAIL")
+		for _, res := range r.Results {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", res.Name, res.Status, res.Detail)
+		}
+		return tw.Flush()
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
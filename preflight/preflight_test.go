@@ -0,0 +1,172 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func statuses(r *Report) map[string]string {
+	m := map[string]string{}
+	for _, res := range r.Results {
+		m[res.Name] = res.Status
+	}
+	return m
+}
+
+func TestRun(t *testing.T) {
+	defer func(f func(context.Context) error) { refreshMetadata = f }(refreshMetadata)
+	defer func(f func() (string, error)) { idToken = f }(idToken)
+	defer func(f func(context.Context, string) error) { dialEndpoint = f }(dialEndpoint)
+	defer func(f func() int) { geteuid = f }(geteuid)
+	defer func(s string) { goos = s }(goos)
+	defer func(f func() string) { cacheDir = f }(cacheDir)
+	goos = "linux"
+	dir := t.TempDir()
+
+	ok := func(context.Context) error { return nil }
+	tests := []struct {
+		desc       string
+		metadata   func(context.Context) error
+		token      error
+		dial       error
+		euid       int
+		cacheDir   string
+		want       map[string]string
+		wantFailed bool
+	}{
+		{
+			"all ok", ok, nil, nil, 0, dir,
+			map[string]string{"metadata": StatusOK, "identity token": StatusOK, "agent endpoint": StatusOK, "permissions": StatusOK},
+			false,
+		},
+		{
+			"no metadata server", func(context.Context) error { return errors.New("connection refused") }, nil, nil, 0, dir,
+			map[string]string{"metadata": StatusFailed, "features": StatusSkipped, "identity token": StatusSkipped, "agent endpoint": StatusSkipped, "permissions": StatusOK},
+			true,
+		},
+		{
+			"no token or endpoint", ok, errors.New("403"), errors.New("i/o timeout"), 0, dir,
+			map[string]string{"metadata": StatusOK, "identity token": StatusFailed, "agent endpoint": StatusFailed, "permissions": StatusOK},
+			true,
+		},
+		{
+			"not root", ok, nil, nil, 1000, dir,
+			map[string]string{"metadata": StatusOK, "identity token": StatusOK, "agent endpoint": StatusOK, "permissions": StatusWarning},
+			false,
+		},
+		{
+			"state directory not writable", ok, nil, nil, 0, filepath.Join(dir, "missing", string([]byte{0})),
+			map[string]string{"metadata": StatusOK, "identity token": StatusOK, "agent endpoint": StatusOK, "permissions": StatusFailed},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			refreshMetadata = tt.metadata
+			idToken = func() (string, error) { return "token", tt.token }
+			dialEndpoint = func(context.Context, string) error { return tt.dial }
+			geteuid = func() int { return tt.euid }
+			cacheDir = func() string { return tt.cacheDir }
+
+			r := Run(context.Background(), time.Second)
+			got := statuses(r)
+			// Features and package managers depend on the host.
+			for _, name := range []string{"features", "package managers"} {
+				if _, ok := tt.want[name]; !ok {
+					delete(got, name)
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("statuses mismatch (-want +got):\n%s", diff)
+			}
+			if r.Failed() != tt.wantFailed {
+				t.Errorf("Failed() = %t, want %t", r.Failed(), tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestDialTLS(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// Not a TLS server, the handshake fails.
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			conn.Write([]byte("HTTP/1.0 400 Bad Request\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := dialTLS(ctx, l.Addr().String()); err == nil {
+		t.Error("dialTLS to a plain TCP server: want error")
+	}
+	if err := dialTLS(ctx, "no-port"); err == nil {
+		t.Error("dialTLS without port: want error")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r := &Report{
+		AgentVersion: "1.0",
+		Time:         time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		Results: []Result{
+			{Name: "metadata", Status: StatusOK, Detail: `project "p"`},
+			{Name: "identity token", Status: StatusFailed, Detail: "403"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, r, FormatText); err != nil {
+		t.Fatalf("Write text: %v", err)
+	}
+	want := "CHECK           STATUS  DETAIL\nmetadata        ok      project \"p\"\nidentity token  failed  403\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("text output mismatch (-want +got):\n%s", diff)
+	}
+
+	buf.Reset()
+	if err := Write(&buf, r, FormatJSON); err != nil {
+		t.Fatalf("Write json: %v", err)
+	}
+	var got Report
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("error decoding %s: %v", buf.String(), err)
+	}
+	if diff := cmp.Diff(r, &got); diff != "" {
+		t.Errorf("json output mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(buf.String(), `"agentVersion": "1.0"`) {
+		t.Errorf("json output %s has no agentVersion", buf.String())
+	}
+
+	if err := Write(&buf, r, "yaml"); err == nil {
+		t.Error("Write yaml: want error")
+	}
+}
@@ -0,0 +1,91 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package privhelper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+)
+
+// Client sends requests to the privileged helper.
+type Client struct {
+	socket string
+}
+
+// NewClient returns a Client of the helper listening on socket.
+func NewClient(socket string) *Client {
+	return &Client{socket: socket}
+}
+
+func (c *Client) do(ctx context.Context, req *Request) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", c.socket)
+	if err != nil {
+		return fmt.Errorf("error connecting to the privileged helper: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("error sending %s request to the privileged helper: %v", req.Op, err)
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("error reading %s response of the privileged helper: %v", req.Op, err)
+	}
+	if resp.Error == "" {
+		return nil
+	}
+	err = errors.New(resp.Error)
+	if resp.Code != "" {
+		err = errcode.Wrap(resp.Code, err)
+	}
+	return err
+}
+
+// InstallPackages installs pkgs with manager, refresh updates the package
+// index first.
+func (c *Client) InstallPackages(ctx context.Context, manager string, pkgs []string, refresh bool) error {
+	return c.do(ctx, &Request{Op: InstallPackages, Manager: manager, Packages: pkgs, Refresh: refresh})
+}
+
+// DowngradePackages installs the pinned versions in pkgs with manager,
+// downgrading installed packages.
+func (c *Client) DowngradePackages(ctx context.Context, manager string, pkgs []string) error {
+	return c.do(ctx, &Request{Op: DowngradePackages, Manager: manager, Packages: pkgs})
+}
+
+// RemovePackages removes pkgs with manager.
+func (c *Client) RemovePackages(ctx context.Context, manager string, pkgs []string) error {
+	return c.do(ctx, &Request{Op: RemovePackages, Manager: manager, Packages: pkgs})
+}
+
+// WriteFile atomically replaces path with content.
+func (c *Client) WriteFile(ctx context.Context, path string, content []byte, mode os.FileMode) error {
+	return c.do(ctx, &Request{Op: WriteFile, Path: path, Content: content, Mode: mode})
+}
+
+// RemoveFile removes path.
+func (c *Client) RemoveFile(ctx context.Context, path string) error {
+	return c.do(ctx, &Request{Op: RemoveFile, Path: path})
+}
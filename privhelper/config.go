@@ -0,0 +1,116 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package privhelper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigFile is the configuration of the helper, it must only be writable by
+// root.
+const ConfigFile = "/etc/google_osconfig_agent/privileged_helper.json"
+
+// Config is the directories the helper acts in, it comes from ConfigFile and
+// never from the requests of the agent.
+type Config struct {
+	// WriteDirs are the directories files are written in and removed from.
+	WriteDirs []string `json:"write_dirs"`
+	// PackageDirs are the directories local .deb and .rpm files are
+	// installed from, each must only be writable by root or the agent user,
+	// see Server.stagePackage.
+	PackageDirs []string `json:"package_dirs"`
+}
+
+// DefaultConfig is used when there is no ConfigFile: the repository files
+// managed by the agent and the packages it downloads to its state
+// directory. Keys are never written by the helper, see keyDirs.
+var DefaultConfig = Config{
+	WriteDirs: []string{
+		"/etc/apt/sources.list.d",
+		"/etc/yum.repos.d",
+		"/etc/zypp/repos.d",
+	},
+	PackageDirs: []string{
+		"/var/lib/google_osconfig_agent",
+	},
+}
+
+// LoadConfig reads the Config at path, DefaultConfig if there is none.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		c := DefaultConfig
+		return &c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode().Perm()&0022 != 0 {
+		return nil, fmt.Errorf("%s must not be writable by group or others, mode is %s", path, fi.Mode().Perm())
+	}
+
+	var c Config
+	if err := json.NewDecoder(f).Decode(&c); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	for _, dir := range append(append([]string{}, c.WriteDirs...), c.PackageDirs...) {
+		if err := validatePath(dir); err != nil || dir == "/" {
+			return nil, fmt.Errorf("invalid directory %q in %s, must be absolute, clean and not /", dir, path)
+		}
+	}
+	return &c, nil
+}
+
+// within reports whether path is in one of dirs. The symlinks of the
+// directories leading to path are resolved first, so a link can not point
+// out of dirs.
+func within(path string, dirs []string) bool {
+	parent, err := resolveExisting(filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	for _, dir := range dirs {
+		if d, err := resolveExisting(dir); err == nil && (parent == d || strings.HasPrefix(parent, d+string(filepath.Separator))) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveExisting resolves the symlinks of the longest existing prefix of
+// path, the directories that would be created below it are kept as is.
+func resolveExisting(path string) (string, error) {
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !os.IsNotExist(err) || filepath.Dir(path) == path {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(path)}, rest...)
+		path = filepath.Dir(path)
+	}
+}
@@ -0,0 +1,30 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package privhelper
+
+import (
+	"errors"
+	"os"
+)
+
+// openNoFollow is not supported, the helper only runs on Linux.
+func openNoFollow(path string) (*os.File, string, error) {
+	return nil, "", errors.New("the privileged helper is not supported on Windows")
+}
+
+// ownerUID is not supported, the helper only runs on Linux.
+func ownerUID(fi os.FileInfo) int {
+	return -1
+}
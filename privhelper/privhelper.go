@@ -0,0 +1,80 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package privhelper moves the privileged actions of OS policy enforcement,
+// installing and removing packages and writing files outside of the agent's
+// own directories, out of the agent so it can run as an unprivileged user.
+//
+// The helper is the agent binary run as "privileged-helper", started by
+// systemd socket activation on the first request and exiting once idle. It
+// serves a single user, checked with the peer credentials of the Unix
+// socket, and only the few operations below, on files and local packages in
+// the directories of its own Config.
+package privhelper
+
+import (
+	"os"
+
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+)
+
+// Operations.
+const (
+	InstallPackages = "install-packages"
+	// DowngradePackages installs the pinned versions, name=version or
+	// name-version for yum, of packages that are installed with a newer
+	// version.
+	DowngradePackages = "downgrade-packages"
+	RemovePackages    = "remove-packages"
+	WriteFile         = "write-file"
+	RemoveFile        = "remove-file"
+)
+
+// Package managers.
+const (
+	Apt    = "apt"
+	Deb    = "deb"
+	Yum    = "yum"
+	Zypper = "zypper"
+	RPM    = "rpm"
+	GooGet = "googet"
+)
+
+// maxRequestSize bounds the content of a written file.
+const maxRequestSize = 64 << 20
+
+// Request is a privileged action, a single JSON object sent on a new
+// connection.
+type Request struct {
+	Op string `json:"op"`
+
+	// Manager and Packages are the package manager and the names of the
+	// packages, or paths of local .deb and .rpm files, to install or remove.
+	// Refresh updates the apt package index first.
+	Manager  string   `json:"manager,omitempty"`
+	Packages []string `json:"packages,omitempty"`
+	Refresh  bool     `json:"refresh,omitempty"`
+
+	// Path, Content and Mode are the file to write or remove.
+	Path    string      `json:"path,omitempty"`
+	Content []byte      `json:"content,omitempty"`
+	Mode    os.FileMode `json:"mode,omitempty"`
+}
+
+// Response is the result of a Request, the error code is kept so errors are
+// reported the same as when the agent enforces itself.
+type Response struct {
+	Error string       `json:"error,omitempty"`
+	Code  errcode.Code `json:"code,omitempty"`
+}
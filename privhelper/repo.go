@@ -0,0 +1,193 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package privhelper
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// keyDirs hold the keys package managers trust. The helper never writes in
+// them, even when a Config allows it, with a key and a repository of its
+// own the agent could install anything as root. Repository files written by
+// the helper can only refer to keys already in them.
+var keyDirs = []string{"/etc/apt/keyrings", "/etc/apt/trusted.gpg.d", "/etc/pki/rpm-gpg", "/usr/share/keyrings"}
+
+// aptTrustedKeyring is the legacy apt keyring, trusted for all repositories.
+const aptTrustedKeyring = "/etc/apt/trusted.gpg"
+
+// aptInsecureOptions turn off the signature checks of an apt repository.
+var aptInsecureOptions = []string{"trusted", "allow-insecure", "allow-weak", "allow-downgrade-to-insecure"}
+
+// validateRepoFile checks the apt .list and .sources, and yum and zypper
+// .repo files written by the helper check package signatures with keys in
+// keyDirs. Other files are not checked.
+func validateRepoFile(path string, content []byte) error {
+	var err error
+	switch filepath.Ext(path) {
+	case ".list":
+		err = validateAptList(string(content))
+	case ".sources":
+		err = validateAptSources(string(content))
+	case ".repo":
+		err = validateRPMRepo(string(content))
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("repository file %q: %v", path, err)
+	}
+	return nil
+}
+
+// disabled reports whether v is a false boolean value.
+func disabled(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "0", "no", "false", "off":
+		return true
+	}
+	return false
+}
+
+// checkKeyFile checks path, a key referred to by a repository, is in
+// keyDirs.
+func checkKeyFile(path string) error {
+	if validatePath(path) != nil || !within(path, keyDirs) {
+		return fmt.Errorf("key %q is not in one of %q", path, keyDirs)
+	}
+	return nil
+}
+
+// checkAptOption checks an option of an apt repository, from a .list or a
+// .sources file.
+func checkAptOption(key, value string) error {
+	key = strings.ToLower(strings.TrimRight(key, "+-"))
+	for _, o := range aptInsecureOptions {
+		if key == o && !disabled(value) {
+			return fmt.Errorf("option %s turns off signature checks", key)
+		}
+	}
+	if key == "signed-by" {
+		// Fingerprints refer to keys already trusted.
+		for _, v := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+			if filepath.IsAbs(v) {
+				if err := checkKeyFile(v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateAptList checks the one line entries of a .list file, like
+// "deb [arch=amd64 signed-by=/usr/share/keyrings/repo.gpg] uri suite main".
+func validateAptList(content string) error {
+	for _, line := range strings.Split(content, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		_, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, "[") {
+			continue
+		}
+		opts, _, ok := strings.Cut(rest[1:], "]")
+		if !ok {
+			return fmt.Errorf("unterminated options in %q", line)
+		}
+		for _, opt := range strings.Fields(opts) {
+			k, v, _ := strings.Cut(opt, "=")
+			if err := checkAptOption(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateAptSources checks the deb822 stanzas of a .sources file.
+func validateAptSources(content string) error {
+	var key string
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if line != "" && (line[0] == ' ' || line[0] == '\t') {
+			// A continuation line, Signed-By can embed a whole key this way.
+			if strings.EqualFold(key, "signed-by") && strings.TrimSpace(line) != "" {
+				return errors.New("embedded keys are not allowed in Signed-By")
+			}
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			key = ""
+			continue
+		}
+		key = strings.TrimSpace(k)
+		if err := checkAptOption(key, strings.TrimSpace(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRPMRepo checks each repository of a yum or zypper .repo file
+// enables gpgcheck, and only refers to local keys with gpgkey: remote keys
+// are imported, and trusted, when packages are installed.
+func validateRPMRepo(content string) error {
+	var section, key string
+	var sections []string
+	gpgcheck := map[string]bool{}
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section, key = trimmed, ""
+			sections = append(sections, section)
+			continue
+		}
+		value := trimmed
+		if line[0] != ' ' && line[0] != '\t' {
+			k, v, _ := strings.Cut(trimmed, "=")
+			key, value = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+		}
+		switch key {
+		case "gpgcheck", "pkg_gpgcheck":
+			if disabled(value) {
+				return fmt.Errorf("%s %s turns off signature checks", section, key)
+			}
+			gpgcheck[section] = true
+		case "gpgkey":
+			for _, v := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+				if !strings.HasPrefix(v, "file://") {
+					return fmt.Errorf("%s gpgkey %q must be a local file:// key", section, v)
+				}
+				if err := checkKeyFile(strings.TrimPrefix(v, "file://")); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, section := range sections {
+		if !gpgcheck[section] {
+			return fmt.Errorf("%s must set gpgcheck=1", section)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package privhelper
+
+import "testing"
+
+func TestValidateRepoFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		content string
+		wantErr bool
+	}{
+		{"AptList", "/etc/apt/sources.list.d/a.list", "deb http://repo stable main\n", false},
+		{"AptListSignedBy", "/etc/apt/sources.list.d/a.list", "deb [arch=amd64 signed-by=/usr/share/keyrings/repo.gpg] http://repo stable main\n", false},
+		{"AptListSignedByAgentKey", "/etc/apt/sources.list.d/a.list", "deb [signed-by=/var/lib/google_osconfig_agent/repo.gpg] http://repo stable main\n", true},
+		{"AptListTrusted", "/etc/apt/sources.list.d/a.list", "deb [ trusted=yes ] http://repo stable main\n", true},
+		{"AptListAllowInsecure", "/etc/apt/sources.list.d/a.list", "deb [allow-insecure=yes] http://repo stable main\n", true},
+		{"AptListTrustedComment", "/etc/apt/sources.list.d/a.list", "# deb [trusted=yes] http://repo stable main\n", false},
+		{"AptSources", "/etc/apt/sources.list.d/a.sources", "Types: deb\nURIs: http://repo\nSuites: stable\nComponents: main\nSigned-By: /etc/apt/keyrings/repo.gpg\n", false},
+		{"AptSourcesTrusted", "/etc/apt/sources.list.d/a.sources", "Types: deb\nURIs: http://repo\nTrusted: yes\n", true},
+		{"AptSourcesEmbeddedKey", "/etc/apt/sources.list.d/a.sources", "Types: deb\nSigned-By:\n -----BEGIN PGP PUBLIC KEY BLOCK-----\n .\n mQINBF\n", true},
+		{"Yum", "/etc/yum.repos.d/a.repo", "[a]\nbaseurl=http://repo\nenabled=1\ngpgcheck=1\ngpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-a\n", false},
+		{"YumKeyContinuation", "/etc/yum.repos.d/a.repo", "[a]\ngpgcheck=1\ngpgkey=file:///etc/pki/rpm-gpg/a\n  https://repo/key.gpg\n", true},
+		{"YumRemoteKey", "/etc/yum.repos.d/a.repo", "[a]\ngpgcheck=1\ngpgkey=https://repo/key.gpg\n", true},
+		{"YumNoGPGCheck", "/etc/yum.repos.d/a.repo", "[a]\ngpgcheck=0\n", true},
+		{"YumUnsetGPGCheck", "/etc/yum.repos.d/a.repo", "[a]\nbaseurl=http://repo\n[b]\ngpgcheck=1\n", true},
+		{"ZypperNoPkgGPGCheck", "/etc/zypp/repos.d/a.repo", "[a]\ngpgcheck=1\npkg_gpgcheck=off\n", true},
+		{"Other", "/etc/myapp/app.conf", "trusted=yes\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRepoFile(tt.path, []byte(tt.content)); (err != nil) != tt.wantErr {
+				t.Errorf("validateRepoFile() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package privhelper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// readTimeout bounds reading a request, not the action it asks for.
+const readTimeout = 30 * time.Second
+
+var (
+	aptUpdate = packages.AptUpdate
+
+	installFuncs = map[string]func(context.Context, []string) error{
+		Apt:    packages.InstallAptPackages,
+		Deb:    func(ctx context.Context, pkgs []string) error { return forEach(ctx, pkgs, packages.DpkgInstall) },
+		Yum:    packages.InstallYumPackages,
+		Zypper: packages.InstallZypperPackages,
+		RPM:    func(ctx context.Context, pkgs []string) error { return forEach(ctx, pkgs, packages.RPMInstall) },
+		GooGet: packages.InstallGooGetPackages,
+	}
+	downgradeFuncs = map[string]func(context.Context, []string) error{
+		Apt:    packages.DowngradeAptPackages,
+		Yum:    packages.DowngradeYumPackages,
+		Zypper: packages.InstallZypperPackageVersions,
+	}
+	removeFuncs = map[string]func(context.Context, []string) error{
+		Apt:    packages.RemoveAptPackages,
+		Yum:    packages.RemoveYumPackages,
+		Zypper: packages.RemoveZypperPackages,
+		GooGet: packages.RemoveGooGetPackages,
+	}
+
+	// localPackageExt are the local package files each manager installs.
+	localPackageExt = map[string]string{Apt: ".deb", Deb: ".deb", Yum: ".rpm", Zypper: ".rpm", RPM: ".rpm"}
+)
+
+func forEach(ctx context.Context, paths []string, f func(context.Context, string) error) error {
+	for _, p := range paths {
+		if err := f(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Server serves the requests of a single user, one at a time.
+type Server struct {
+	// UID is the only user allowed to connect.
+	UID int
+	// Config is the directories files and local packages are allowed in.
+	Config Config
+	// IdleTimeout is how long Serve waits for the next connection before it
+	// returns.
+	IdleTimeout time.Duration
+}
+
+// Serve accepts connections on l until ctx is done or no connection is made
+// for IdleTimeout.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	done := make(chan struct{})
+	defer close(done)
+	conns := make(chan net.Conn)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case conns <- conn:
+			case <-done:
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	idle := time.NewTimer(s.IdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-idle.C:
+			clog.Debugf(ctx, "Privileged helper idle for %s, exiting.", s.IdleTimeout)
+			return nil
+		case err := <-errs:
+			return err
+		case conn := <-conns:
+			s.serveConn(ctx, conn)
+			if !idle.Stop() {
+				select {
+				case <-idle.C:
+				default:
+				}
+			}
+			idle.Reset(s.IdleTimeout)
+		}
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	resp := s.serveRequest(ctx, conn)
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		clog.Errorf(ctx, "Error writing privileged helper response: %v", err)
+	}
+}
+
+func (s *Server) serveRequest(ctx context.Context, conn net.Conn) *Response {
+	uid, err := peerUID(conn)
+	if err != nil {
+		clog.Errorf(ctx, "Rejected privileged helper connection: %v", err)
+		return &Response{Error: "permission denied", Code: errcode.Auth}
+	}
+	if uid != s.UID {
+		clog.Warningf(ctx, "Rejected privileged helper connection from uid %d, only uid %d is allowed.", uid, s.UID)
+		return &Response{Error: "permission denied", Code: errcode.Auth}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	var req Request
+	if err := json.NewDecoder(io.LimitReader(conn, maxRequestSize)).Decode(&req); err != nil {
+		return &Response{Error: fmt.Sprintf("invalid request: %v", err), Code: errcode.Internal}
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	target := req.Path
+	if target == "" {
+		target = req.Manager + " " + strings.Join(req.Packages, " ")
+	}
+	clog.Infof(ctx, "Privileged helper request %s %s.", req.Op, target)
+	if err := s.handle(ctx, &req); err != nil {
+		clog.Errorf(ctx, "Privileged helper request %s failed: %v", req.Op, err)
+		return &Response{Error: err.Error(), Code: errcode.Of(err, errcode.Internal)}
+	}
+	return &Response{}
+}
+
+// handle validates and runs req, anything not strictly needed for
+// enforcement, or outside of the directories of s.Config, is rejected.
+func (s *Server) handle(ctx context.Context, req *Request) error {
+	switch req.Op {
+	case InstallPackages:
+		f, ok := installFuncs[req.Manager]
+		if !ok {
+			return fmt.Errorf("unsupported package manager %q", req.Manager)
+		}
+		if err := validatePackages(req.Manager, req.Packages, true); err != nil {
+			return err
+		}
+		pkgs, cleanup, err := s.stagePackages(req.Packages)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		if req.Refresh && req.Manager == Apt {
+			if _, err := aptUpdate(ctx); err != nil {
+				return err
+			}
+		}
+		return f(ctx, pkgs)
+	case DowngradePackages:
+		f, ok := downgradeFuncs[req.Manager]
+		if !ok {
+			return fmt.Errorf("unsupported package manager %q", req.Manager)
+		}
+		if err := validatePackages(req.Manager, req.Packages, false); err != nil {
+			return err
+		}
+		return f(ctx, req.Packages)
+	case RemovePackages:
+		f, ok := removeFuncs[req.Manager]
+		if !ok {
+			return fmt.Errorf("unsupported package manager %q", req.Manager)
+		}
+		if err := validatePackages(req.Manager, req.Packages, false); err != nil {
+			return err
+		}
+		return f(ctx, req.Packages)
+	case WriteFile:
+		if err := s.validateFile(req.Path); err != nil {
+			return err
+		}
+		if req.Mode&^os.ModePerm != 0 {
+			return fmt.Errorf("invalid mode %s for %q", req.Mode, req.Path)
+		}
+		if err := validateRepoFile(req.Path, req.Content); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(req.Path), 0755); err != nil {
+			return err
+		}
+		return util.AtomicWrite(req.Path, req.Content, req.Mode)
+	case RemoveFile:
+		if err := s.validateFile(req.Path); err != nil {
+			return err
+		}
+		return os.Remove(req.Path)
+	default:
+		return fmt.Errorf("unsupported operation %q", req.Op)
+	}
+}
+
+// validatePackages checks pkgs are package names, or with local, only set
+// for installs, local package files of the manager, so no option can be
+// passed to the package manager. Local files are checked again when they
+// are staged, see Server.stagePackage.
+func validatePackages(manager string, pkgs []string, local bool) error {
+	if len(pkgs) == 0 {
+		return errors.New("no packages")
+	}
+	ext := localPackageExt[manager]
+	for _, p := range pkgs {
+		if local && ext != "" && filepath.IsAbs(p) {
+			if err := packages.ValidatePackageFile(p, ext); err != nil {
+				return err
+			}
+			continue
+		}
+		// Local packages of dpkg and rpm must be files.
+		if manager == Deb || manager == RPM || packages.ValidatePackageName(p) != nil {
+			return fmt.Errorf("invalid %s package %q", manager, p)
+		}
+	}
+	return nil
+}
+
+// stagePackages returns pkgs with their local package files replaced by
+// staged copies, see stagePackage, and a func removing the copies.
+func (s *Server) stagePackages(pkgs []string) ([]string, func(), error) {
+	staged := append([]string{}, pkgs...)
+	var dirs []string
+	cleanup := func() {
+		for _, dir := range dirs {
+			os.RemoveAll(dir)
+		}
+	}
+	for i, p := range pkgs {
+		if !filepath.IsAbs(p) {
+			continue
+		}
+		c, err := s.stagePackage(p)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		dirs = append(dirs, filepath.Dir(c))
+		staged[i] = c
+	}
+	return staged, cleanup, nil
+}
+
+// stagePackage copies the local package file at path to a new directory of
+// root and returns the copy, which is what gets installed. The file is
+// opened once without following symlinks and all checks are done on the
+// open file: it must be a regular file in one of the PackageDirs, and the
+// directory it is in must only be writable by root or the agent user.
+// Swapping the file, or a directory leading to it, after it was opened
+// doesn't change what is installed.
+func (s *Server) stagePackage(path string) (string, error) {
+	f, opened, err := openNoFollow(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid package file %q: %v", path, err)
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("invalid package file %q: not a regular file", path)
+	}
+	if !within(opened, s.Config.PackageDirs) {
+		return "", fmt.Errorf("package file %q is not in a directory allowed by %s", path, ConfigFile)
+	}
+	dir, err := os.Stat(filepath.Dir(opened))
+	if err != nil {
+		return "", err
+	}
+	if dir.Mode().Perm()&0022 != 0 {
+		return "", fmt.Errorf("package file %q is in a directory writable by group or others", path)
+	}
+	if uid := ownerUID(dir); uid != 0 && uid != s.UID {
+		return "", fmt.Errorf("package file %q is in a directory of uid %d, only root or uid %d are allowed", path, uid, s.UID)
+	}
+
+	stage, err := os.MkdirTemp("", "osconfig_privhelper_")
+	if err != nil {
+		return "", err
+	}
+	staged := filepath.Join(stage, filepath.Base(opened))
+	out, err := os.OpenFile(staged, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err == nil {
+		_, err = io.Copy(out, f)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		os.RemoveAll(stage)
+		return "", fmt.Errorf("error staging package file %q: %v", path, err)
+	}
+	return staged, nil
+}
+
+func validatePath(path string) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return fmt.Errorf("invalid path %q, must be absolute and clean", path)
+	}
+	return nil
+}
+
+// validateFile checks path is a file in one of the WriteDirs of s.Config,
+// and not a key trusted by a package manager.
+func (s *Server) validateFile(path string) error {
+	if err := validatePath(path); err != nil {
+		return err
+	}
+	if path == aptTrustedKeyring || within(path, keyDirs) {
+		return fmt.Errorf("%q is a package manager key, keys are not written by the privileged helper", path)
+	}
+	if !within(path, s.Config.WriteDirs) {
+		return fmt.Errorf("%q is not in a directory allowed by %s", path, ConfigFile)
+	}
+	return nil
+}
+
+// ActivationListener returns the socket passed by systemd socket activation.
+func ActivationListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, errors.New("not started by systemd socket activation")
+	}
+	if n, err := strconv.Atoi(os.Getenv("LISTEN_FDS")); err != nil || n < 1 {
+		return nil, fmt.Errorf("no socket passed by systemd, LISTEN_FDS=%q", os.Getenv("LISTEN_FDS"))
+	}
+	// Passed file descriptors start after stdin, stdout and stderr.
+	f := os.NewFile(3, "privileged-helper.socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
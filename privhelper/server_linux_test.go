@@ -0,0 +1,287 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package privhelper
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+)
+
+// startServer serves uid, acting in the directories of cfg, on a new socket
+// until the test ends.
+func startServer(t *testing.T, uid int, cfg Config) *Client {
+	t.Helper()
+	// Unix socket paths are limited to about 100 bytes.
+	dir, err := os.MkdirTemp("", "privhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "helper.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		(&Server{UID: uid, Config: cfg, IdleTimeout: time.Minute}).Serve(ctx, l)
+	}()
+	t.Cleanup(func() { cancel(); l.Close(); <-done })
+	return NewClient(socket)
+}
+
+func TestServerPackages(t *testing.T) {
+	var got [][]string
+	record := func(name string) func(context.Context, []string) error {
+		return func(_ context.Context, pkgs []string) error {
+			got = append(got, append([]string{name}, pkgs...))
+			if pkgs[0] == "broken" {
+				return errcode.Wrap(errcode.PackageManager, errors.New("broken package"))
+			}
+			return nil
+		}
+	}
+	defer func(i, d, r map[string]func(context.Context, []string) error) {
+		installFuncs, downgradeFuncs, removeFuncs = i, d, r
+	}(installFuncs, downgradeFuncs, removeFuncs)
+	// Local packages are installed from a staged copy, recorded with its
+	// content.
+	var staged []string
+	dpkg := func(_ context.Context, pkgs []string) error {
+		data, err := os.ReadFile(pkgs[0])
+		if err != nil {
+			return err
+		}
+		staged = append(staged, pkgs[0])
+		got = append(got, []string{"dpkg", string(data)})
+		return nil
+	}
+	installFuncs = map[string]func(context.Context, []string) error{Apt: record("install"), Deb: dpkg}
+	downgradeFuncs = map[string]func(context.Context, []string) error{Apt: record("downgrade")}
+	removeFuncs = map[string]func(context.Context, []string) error{Apt: record("remove")}
+	defer func(f func(context.Context) ([]byte, error)) { aptUpdate = f }(aptUpdate)
+	aptUpdate = func(context.Context) ([]byte, error) { got = append(got, []string{"update"}); return nil, nil }
+
+	pkgDir := t.TempDir()
+	deb := filepath.Join(pkgDir, "pkg.deb")
+	if err := os.WriteFile(deb, []byte("package"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	otherDeb := filepath.Join(t.TempDir(), "pkg.deb")
+	if err := os.WriteFile(otherDeb, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	linkDeb := filepath.Join(pkgDir, "link.deb")
+	if err := os.Symlink(otherDeb, linkDeb); err != nil {
+		t.Fatal(err)
+	}
+	sharedDir := filepath.Join(pkgDir, "shared")
+	if err := os.Mkdir(sharedDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(sharedDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	sharedDeb := filepath.Join(sharedDir, "pkg.deb")
+	if err := os.WriteFile(sharedDeb, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	c := startServer(t, os.Getuid(), Config{PackageDirs: []string{pkgDir}})
+	if err := c.InstallPackages(ctx, Apt, []string{"nginx", "curl=7.88.1-10"}, true); err != nil {
+		t.Errorf("InstallPackages: %v", err)
+	}
+	if err := c.InstallPackages(ctx, Deb, []string{deb}, false); err != nil {
+		t.Errorf("InstallPackages of a local deb: %v", err)
+	}
+	if err := c.RemovePackages(ctx, Apt, []string{"nginx"}); err != nil {
+		t.Errorf("RemovePackages: %v", err)
+	}
+	if err := c.DowngradePackages(ctx, Apt, []string{"curl=7.74.0-1"}); err != nil {
+		t.Errorf("DowngradePackages: %v", err)
+	}
+	if err := c.DowngradePackages(ctx, Apt, []string{deb}); err == nil {
+		t.Error("DowngradePackages of a local deb: want an error")
+	}
+	err := c.InstallPackages(ctx, Apt, []string{"broken"}, false)
+	if err == nil || errcode.Of(err, errcode.Internal) != errcode.PackageManager {
+		t.Errorf("InstallPackages of a broken package: got %v, want a %s error", err, errcode.PackageManager)
+	}
+
+	want := [][]string{{"update"}, {"install", "nginx", "curl=7.88.1-10"}, {"dpkg", "package"}, {"remove", "nginx"}, {"downgrade", "curl=7.74.0-1"}, {"install", "broken"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("package actions: got %q, want %q", got, want)
+	}
+	for _, s := range staged {
+		if s == deb {
+			t.Errorf("local package installed from %q, want a staged copy", s)
+		}
+		if _, err := os.Stat(s); !os.IsNotExist(err) {
+			t.Errorf("staged package %q: got %v, want it removed after the install", s, err)
+		}
+	}
+
+	// Nothing that could be an option or another file reaches the package
+	// manager.
+	for _, tt := range []struct {
+		manager string
+		pkgs    []string
+	}{
+		{Apt, []string{"-o", "APT::Update::Pre-Invoke::=sh"}},
+		{Apt, nil},
+		{Apt, []string{"/etc/shadow"}},
+		{Apt, []string{"/tmp/../tmp/pkg.deb"}},
+		{Deb, []string{otherDeb}},
+		{Deb, []string{linkDeb}},
+		{Deb, []string{sharedDeb}},
+		{Deb, []string{"nginx"}},
+		{"pip", []string{"requests"}},
+	} {
+		if err := c.InstallPackages(ctx, tt.manager, tt.pkgs, false); err == nil {
+			t.Errorf("InstallPackages(%s, %q): want an error", tt.manager, tt.pkgs)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("invalid requests ran package actions: %q", got[len(want):])
+	}
+}
+
+func TestServerFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c := startServer(t, os.Getuid(), Config{WriteDirs: []string{dir}})
+	path := filepath.Join(dir, "etc", "app.conf")
+
+	if err := c.WriteFile(ctx, path, []byte("key=value\n"), 0640); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "key=value\n" {
+		t.Errorf("written file: got %q, %v, want %q", data, err, "key=value\n")
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("written file mode: got %v, %v, want 0640", fi.Mode(), err)
+	}
+
+	if err := c.WriteFile(ctx, path, nil, os.ModeSetuid|0755); err == nil {
+		t.Error("WriteFile with setuid: want an error")
+	}
+	if err := c.WriteFile(ctx, "relative/app.conf", nil, 0644); err == nil {
+		t.Error("WriteFile of a relative path: want an error")
+	}
+	outside := t.TempDir()
+	if err := c.WriteFile(ctx, filepath.Join(outside, "app.conf"), nil, 0644); err == nil {
+		t.Error("WriteFile outside of the allowed directories: want an error")
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteFile(ctx, filepath.Join(dir, "link", "app.conf"), nil, 0644); err == nil {
+		t.Error("WriteFile through a symlink out of the allowed directories: want an error")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "app.conf")); !os.IsNotExist(err) {
+		t.Errorf("file outside of the allowed directories: got %v, want it not to exist", err)
+	}
+
+	keys := startServer(t, os.Getuid(), Config{WriteDirs: []string{"/etc/apt"}})
+	if err := keys.WriteFile(ctx, "/etc/apt/trusted.gpg.d/repo.gpg", nil, 0644); err == nil {
+		t.Error("WriteFile of an apt key: want an error")
+	}
+	if err := c.WriteFile(ctx, filepath.Join(dir, "repo.list"), []byte("deb [trusted=yes] http://repo stable main\n"), 0644); err == nil {
+		t.Error("WriteFile of an unchecked apt repository: want an error")
+	}
+
+	if err := c.RemoveFile(ctx, path); err != nil {
+		t.Errorf("RemoveFile: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("removed file: got %v, want it not to exist", err)
+	}
+}
+
+func TestServerRejectsOtherUsers(t *testing.T) {
+	c := startServer(t, os.Getuid()+1, DefaultConfig)
+	err := c.RemoveFile(context.Background(), filepath.Join(t.TempDir(), "file"))
+	if err == nil || errcode.Of(err, errcode.Internal) != errcode.Auth {
+		t.Errorf("RemoveFile as another user: got %v, want a %s error", err, errcode.Auth)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	if c, err := LoadConfig(filepath.Join(dir, "missing.json")); err != nil || !reflect.DeepEqual(*c, DefaultConfig) {
+		t.Errorf("LoadConfig of a missing file: got %+v, %v, want the DefaultConfig", c, err)
+	}
+
+	for _, tt := range []struct {
+		desc    string
+		content string
+		mode    os.FileMode
+		want    *Config
+	}{
+		{"valid", `{"write_dirs": ["/etc/myapp"], "package_dirs": ["/var/cache/pkgs"]}`, 0644, &Config{WriteDirs: []string{"/etc/myapp"}, PackageDirs: []string{"/var/cache/pkgs"}}},
+		{"writable by others", `{"write_dirs": ["/etc/myapp"]}`, 0666, nil},
+		{"relative dir", `{"write_dirs": ["etc/myapp"]}`, 0644, nil},
+		{"root", `{"write_dirs": ["/"]}`, 0644, nil},
+		{"invalid json", `{"write_dirs": `, 0644, nil},
+	} {
+		path := filepath.Join(dir, tt.desc+".json")
+		if err := os.WriteFile(path, []byte(tt.content), tt.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, tt.mode); err != nil {
+			t.Fatal(err)
+		}
+		c, err := LoadConfig(path)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s: LoadConfig: want an error", tt.desc)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(c, tt.want) {
+			t.Errorf("%s: LoadConfig: got %+v, %v, want %+v", tt.desc, c, err, tt.want)
+		}
+	}
+}
+
+func TestServeIdleTimeout(t *testing.T) {
+	dir, err := os.MkdirTemp("", "privhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := net.Listen("unix", filepath.Join(dir, "helper.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	s := &Server{UID: os.Getuid(), IdleTimeout: 10 * time.Millisecond}
+	if err := s.Serve(context.Background(), l); err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
@@ -25,6 +25,7 @@ import (
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -67,7 +68,23 @@ func RetryFunc(ctx context.Context, maxRetryTime time.Duration, desc string, f f
 	}
 }
 
-// RetryAPICall retries an API call for maxRetryTime.
+// RetryDelay returns the delay the server asked for before retrying in the
+// RetryInfo details of a RESOURCE_EXHAUSTED or UNAVAILABLE error.
+func RetryDelay(err error) (time.Duration, bool) {
+	s, ok := status.FromError(err)
+	if !ok || (s.Code() != codes.ResourceExhausted && s.Code() != codes.Unavailable) {
+		return 0, false
+	}
+	for _, d := range s.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok && ri.GetRetryDelay() != nil {
+			return ri.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+// RetryAPICall retries an API call for maxRetryTime. A retry delay sent by
+// the server is honored, see RetryDelay.
 func RetryAPICall(ctx context.Context, maxRetryTime time.Duration, name string, f func() error) error {
 	var tot time.Duration
 	for i := 1; ; i++ {
@@ -96,6 +113,9 @@ func RetryAPICall(ctx context.Context, maxRetryTime time.Duration, name string,
 		}
 
 		ns := RetrySleep(i, extra)
+		if d, ok := RetryDelay(err); ok && d > ns {
+			ns = d
+		}
 		tot += ns
 		if tot > maxRetryTime {
 			return err
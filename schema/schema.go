@@ -0,0 +1,296 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package schema reads the local files the agent accepts, like local
+// policies, as YAML or JSON, which is also YAML, and validates them against
+// a published JSON schema. Errors point to the line and column of the
+// invalid value.
+//
+// Only the subset of JSON schema the agent's schemas use is supported: type,
+// properties, required, additionalProperties, items, enum, minLength, $ref
+// to $defs and oneOf, where the first branch whose type matches the value is
+// used.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Error is a document not matching its schema.
+type Error struct {
+	Line, Column int
+	// Path is the JSON path of the invalid value, like $.resources[0].id.
+	Path string
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("line %d, column %d: %s: %s", e.Line, e.Column, e.Path, e.Msg)
+}
+
+// Errorf returns an Error at the position of n, for checks of a document
+// done outside of its schema.
+func Errorf(n *yaml.Node, path, format string, args ...any) error {
+	return &Error{Line: n.Line, Column: n.Column, Path: path, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Schema is a JSON schema.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Defs                 map[string]*Schema `json:"$defs"`
+	Type                 typeList           `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []string           `json:"enum"`
+	MinLength            int                `json:"minLength"`
+	OneOf                []*Schema          `json:"oneOf"`
+
+	root *Schema
+}
+
+// typeList is a type or list of types.
+type typeList []string
+
+func (t *typeList) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = typeList{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*t = l
+	return nil
+}
+
+// Load reads a JSON schema.
+func Load(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	s.root = &s
+	return &s, nil
+}
+
+// Parse reads a YAML or JSON document.
+func Parse(data []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, errors.New("empty document")
+	}
+	return doc.Content[0], nil
+}
+
+// Validate checks n matches the schema, only the first error is returned.
+func (s *Schema) Validate(n *yaml.Node) error {
+	return s.validate(s.root, resolve(n), "$")
+}
+
+func (s *Schema) validate(root *Schema, n *yaml.Node, path string) error {
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/$defs/")
+		def, ok := root.Defs[name]
+		if !ok {
+			return fmt.Errorf("schema: unknown $ref %q", s.Ref)
+		}
+		return def.validate(root, n, path)
+	}
+	if len(s.OneOf) > 0 {
+		for _, o := range s.OneOf {
+			if t := o.resolvedType(root); len(t) == 0 || t.matches(n) {
+				return o.validate(root, n, path)
+			}
+		}
+		return Errorf(n, path, "got %s, want %s", kind(n), s.types(root))
+	}
+
+	if len(s.Type) > 0 && !s.Type.matches(n) {
+		return Errorf(n, path, "got %s, want %s", kind(n), strings.Join(s.Type, " or "))
+	}
+	if len(s.Enum) > 0 && n.Kind == yaml.ScalarNode {
+		found := false
+		for _, e := range s.Enum {
+			found = found || e == n.Value
+		}
+		if !found {
+			return Errorf(n, path, "%q is not one of %s", n.Value, strings.Join(s.Enum, ", "))
+		}
+	}
+	if s.MinLength > 0 && n.Kind == yaml.ScalarNode && len([]rune(n.Value)) < s.MinLength {
+		return Errorf(n, path, "must be at least %d characters", s.MinLength)
+	}
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], resolve(n.Content[i+1])
+			p := path + "." + k.Value
+			if seen[k.Value] {
+				return Errorf(k, p, "duplicate key")
+			}
+			seen[k.Value] = true
+			if ps, ok := s.Properties[k.Value]; ok {
+				if err := ps.validate(root, v, p); err != nil {
+					return err
+				}
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				return Errorf(k, p, "unknown field %q", k.Value)
+			}
+		}
+		for _, r := range s.Required {
+			if !seen[r] {
+				return Errorf(n, path, "missing required field %q", r)
+			}
+		}
+	case yaml.SequenceNode:
+		if s.Items != nil {
+			for i, item := range n.Content {
+				if err := s.Items.validate(root, resolve(item), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resolvedType is the type of s, following a $ref.
+func (s *Schema) resolvedType(root *Schema) typeList {
+	if s.Ref != "" {
+		if def, ok := root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]; ok {
+			return def.resolvedType(root)
+		}
+	}
+	return s.Type
+}
+
+func (s *Schema) types(root *Schema) string {
+	var types []string
+	for _, o := range s.OneOf {
+		types = append(types, o.resolvedType(root)...)
+	}
+	return strings.Join(types, " or ")
+}
+
+func (t typeList) matches(n *yaml.Node) bool {
+	k := kind(n)
+	for _, want := range t {
+		if want == k || (want == "number" && k == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// kind is the JSON schema type of n.
+func kind(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch n.ShortTag() {
+	case "!!null":
+		return "null"
+	case "!!bool":
+		return "boolean"
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+// ToJSON converts n to JSON, keeping the order of mapping keys.
+func ToJSON(n *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, resolve(n)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSON(buf *bytes.Buffer, n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, err := json.Marshal(n.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(k)
+			buf.WriteByte(':')
+			if err := writeJSON(buf, resolve(n.Content[i+1])); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range n.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, resolve(item)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case yaml.ScalarNode:
+		var v any
+		if err := n.Decode(&v); err != nil {
+			return Errorf(n, "", "%v", err)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return Errorf(n, "", "%v", err)
+		}
+		buf.Write(b)
+	default:
+		return Errorf(n, "", "unsupported YAML node")
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+  "oneOf": [
+    {"$ref": "#/$defs/item"},
+    {"type": "array", "items": {"$ref": "#/$defs/item"}}
+  ],
+  "$defs": {
+    "item": {
+      "type": "object",
+      "required": ["name"],
+      "additionalProperties": false,
+      "properties": {
+        "name": {"type": "string", "minLength": 1},
+        "count": {"type": "integer"},
+        "ratio": {"type": "number"},
+        "color": {"type": "string", "enum": ["red", "blue"]},
+        "tags": {"type": "array", "items": {"type": "string"}},
+        "extra": {"type": "object"}
+      }
+    }
+  }
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := Load([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc    string
+		doc     string
+		wantErr string
+	}{
+		{"json object", `{"name": "a", "count": 1, "ratio": 1, "tags": ["x"], "extra": {"any": true}}`, ""},
+		{"yaml list", "- name: a\n  color: red\n- name: b\n  ratio: 0.5\n", ""},
+		{"yaml anchors", "- &a {name: a, tags: [x]}\n- *a\n", ""},
+		{"unknown field", "name: a\ncolour: red\n", `line 2, column 1: $.colour: unknown field "colour"`},
+		{"missing field", "- name: a\n- count: 1\n", `line 2, column 3: $[1]: missing required field "name"`},
+		{"wrong type", `{"name": "a", "count": "1"}`, `line 1, column 24: $.count: got string, want integer`},
+		{"empty string", `{"name": ""}`, `line 1, column 10: $.name: must be at least 1 characters`},
+		{"enum", "name: a\ncolor: green\n", `line 2, column 8: $.color: "green" is not one of red, blue`},
+		{"array item", "name: a\ntags: [x, 1]\n", `line 2, column 11: $.tags[1]: got integer, want string`},
+		{"duplicate key", "name: a\nname: b\n", `line 2, column 1: $.name: duplicate key`},
+		{"top level", `"a"`, `line 1, column 1: $: got string, want object or array`},
+	}
+	for _, tt := range tests {
+		n, err := Parse([]byte(tt.doc))
+		if err != nil {
+			t.Fatalf("%s: Parse: %v", tt.desc, err)
+		}
+		err = s.Validate(n)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: Validate: %v", tt.desc, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.wantErr {
+			t.Errorf("%s: Validate error = %v, want %q", tt.desc, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, doc := range []string{"", "name: [\n", "{\"name\": "} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("Parse(%q): want error", doc)
+		}
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	tests := []struct {
+		doc, want string
+	}{
+		{"b: 1\na: [x, 2.5, true, null]\n", `{"b":1,"a":["x",2.5,true,null]}`},
+		{"- &a {k: \"v\"}\n- *a\n", `[{"k":"v"},{"k":"v"}]`},
+		{"s: '007'\nn: 007\n", `{"s":"007","n":7}`},
+		{"{\n\t\"id\": \"tabs\"\n}", `{"id":"tabs"}`},
+		{"text: |\n  line1\n  line2\n", `{"text":"line1\nline2\n"}`},
+	}
+	for _, tt := range tests {
+		n, err := Parse([]byte(tt.doc))
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.doc, err)
+		}
+		got, err := ToJSON(n)
+		if err != nil {
+			t.Fatalf("ToJSON(%q): %v", tt.doc, err)
+		}
+		if strings.TrimSpace(string(got)) != tt.want {
+			t.Errorf("ToJSON(%q) = %s, want %s", tt.doc, got, tt.want)
+		}
+	}
+}
@@ -17,10 +17,12 @@ package tasker
 
 import (
 	"context"
+	"fmt"
 	"runtime/debug"
 	"sync"
 
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/agentstatus"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 )
 
@@ -59,6 +61,20 @@ func Close() {
 	wg.Wait()
 }
 
+// RunSafely runs f and recovers a panic, logging it with the stack, so a
+// panic in one part of the agent does not crash the whole process. The
+// returned error describes the panic, it is nil if f returned normally.
+func RunSafely(ctx context.Context, name string, f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s panicked: %v", name, r)
+			clog.Errorf(ctx, "%v\n%s", err, debug.Stack())
+		}
+	}()
+	f()
+	return nil
+}
+
 func tasker(ctx context.Context) {
 	wg.Add(1)
 	defer wg.Done()
@@ -71,7 +87,9 @@ func tasker(ctx context.Context) {
 				return
 			}
 			clog.Debugf(ctx, "Tasker running %q.", t.name)
-			t.run()
+			done := agentstatus.StartTask(t.name)
+			RunSafely(ctx, t.name, t.run)
+			done()
 			clog.Debugf(ctx, "Finished task %q.", t.name)
 			if agentconfig.FreeOSMemory() {
 				debug.FreeOSMemory()
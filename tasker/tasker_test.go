@@ -22,6 +22,24 @@ import (
 
 var notes []int
 
+func TestRunSafely(t *testing.T) {
+	if err := RunSafely(context.Background(), "ok", func() {}); err != nil {
+		t.Errorf("RunSafely of a func that returns: got err %v, want nil", err)
+	}
+	err := RunSafely(context.Background(), "test", func() { panic("boom") })
+	if err == nil || err.Error() != "test panicked: boom" {
+		t.Errorf("RunSafely of a func that panics: got err %v, want %q", err, "test panicked: boom")
+	}
+}
+
+// TestEnqueueTaskPanic must run before any test that calls Close.
+func TestEnqueueTaskPanic(t *testing.T) {
+	done := make(chan struct{})
+	Enqueue(context.Background(), "panic", func() { panic("boom") })
+	Enqueue(context.Background(), "after panic", func() { close(done) })
+	<-done
+}
+
 // TestEnqueueTaskRunSequentially to set sequential
 // execution of tasks in tasker
 func TestEnqueueTaskRunSequentially(t *testing.T) {
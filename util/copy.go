@@ -0,0 +1,59 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"encoding/hex"
+	"hash"
+	"io"
+	"sync"
+)
+
+// copyBufferSize is the size of the buffers used by Copy, large enough that
+// multi-GB files are hashed and copied with few reads.
+const copyBufferSize = 1 << 20
+
+var copyBuffers = sync.Pool{New: func() any {
+	b := make([]byte, copyBufferSize)
+	return &b
+}}
+
+// readerOnly hides the io.WriterTo of a reader, like the one of *os.File
+// that falls back to io.Copy with a small buffer of its own.
+type readerOnly struct {
+	io.Reader
+}
+
+// Copy copies from src to dst until EOF like io.Copy, streaming through a
+// pooled buffer so copying or hashing large files neither holds them in
+// memory nor allocates a buffer each time. A dst that is an io.ReaderFrom,
+// like *os.File that can copy in the kernel, is still used.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	if _, ok := dst.(io.ReaderFrom); !ok {
+		src = readerOnly{src}
+	}
+	b := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(b)
+	return io.CopyBuffer(dst, src, *b)
+}
+
+// HashReader returns the hex encoded digest of the contents of r hashed
+// with h.
+func HashReader(h hash.Hash, r io.Reader) (string, error) {
+	if _, err := Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
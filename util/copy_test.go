@@ -0,0 +1,160 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	// More than one buffer so the copy loops.
+	content := bytes.Repeat([]byte("0123456789abcdef"), copyBufferSize/8+3)
+	src := filepath.Join(t.TempDir(), "src")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		desc string
+		dst  func() (io.Writer, func() []byte)
+	}{
+		{"writer", func() (io.Writer, func() []byte) {
+			var buf bytes.Buffer
+			return struct{ io.Writer }{&buf}, buf.Bytes
+		}},
+		{"file", func() (io.Writer, func() []byte) {
+			dst := filepath.Join(t.TempDir(), "dst")
+			f, err := os.Create(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { f.Close() })
+			return f, func() []byte {
+				b, err := os.ReadFile(dst)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return b
+			}
+		}},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			f, err := os.Open(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			dst, got := tt.dst()
+			n, err := Copy(dst, f)
+			if err != nil {
+				t.Fatalf("Copy: %v", err)
+			}
+			if n != int64(len(content)) {
+				t.Errorf("Copy copied %d bytes, want %d", n, len(content))
+			}
+			if !bytes.Equal(got(), content) {
+				t.Error("copied content does not match the source")
+			}
+		})
+	}
+}
+
+func TestHashReader(t *testing.T) {
+	content := strings.Repeat("a", copyBufferSize+1)
+	want := sha256.Sum256([]byte(content))
+	got, err := HashReader(sha256.New(), strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("HashReader: %v", err)
+	}
+	if got != hex.EncodeToString(want[:]) {
+		t.Errorf("HashReader = %q, want %q", got, hex.EncodeToString(want[:]))
+	}
+
+	if _, err := HashReader(sha256.New(), io.MultiReader(strings.NewReader("a"), errReader{})); err == nil {
+		t.Error("HashReader: want error of the reader")
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, fmt.Errorf("read error") }
+
+// sparseFile creates a file of size bytes without writing them, so
+// multi-GB files can be benchmarked without using that much disk.
+func sparseFile(b *testing.B, size int64) string {
+	path := filepath.Join(b.TempDir(), "large")
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+var benchmarkSizes = []int64{64 << 20, 1 << 30, 4 << 30}
+
+func BenchmarkHashReader(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("%dMiB", size>>20), func(b *testing.B) {
+			path := sparseFile(b, size)
+			b.SetBytes(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f, err := os.Open(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := HashReader(sha256.New(), f); err != nil {
+					b.Fatal(err)
+				}
+				f.Close()
+			}
+		})
+	}
+}
+
+func BenchmarkAtomicWriteFileStream(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("%dMiB", size>>20), func(b *testing.B) {
+			src := sparseFile(b, size)
+			dst := filepath.Join(b.TempDir(), "dst")
+			b.SetBytes(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f, err := os.Open(src)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := AtomicWriteFileStream(f, "", dst, 0644); err != nil {
+					b.Fatal(err)
+				}
+				f.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrLocked is returned by TryLockFile when the lock is held, by this or
+// another process.
+var ErrLocked = errors.New("lock is held")
+
+// FileLock is an exclusive lock on a file, held until Unlock.
+type FileLock struct {
+	f *os.File
+}
+
+// TryLockFile takes an exclusive lock on path, creating it and its directory
+// if needed, without waiting. The lock is released when the process exits,
+// so a crashed run never leaves it held.
+func TryLockFile(path string) (*FileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := tryLock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileLock{f: f}, nil
+}
+
+// Unlock releases the lock, the file is left in place as removing it could
+// race with another process locking it.
+func (l *FileLock) Unlock() error {
+	unlock(l.f)
+	return l.f.Close()
+}
@@ -0,0 +1,42 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks", "a.lock")
+	l, err := TryLockFile(path)
+	if err != nil {
+		t.Fatalf("TryLockFile(%q): %v", path, err)
+	}
+	// Locks are per open file, a second open in the same process conflicts
+	// like one in another process would.
+	if _, err := TryLockFile(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("TryLockFile of a held lock: got %v, want ErrLocked", err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	l, err = TryLockFile(path)
+	if err != nil {
+		t.Fatalf("TryLockFile after Unlock: %v", err)
+	}
+	l.Unlock()
+}
@@ -0,0 +1,80 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"context"
+	"os/exec"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// RunWithProcessGroup runs cmd in its own process group, a Job Object on
+// Windows, and kills the whole group, including any grandchildren, when ctx
+// is done. Processes left in the group after cmd exits normally are not
+// killed, so scripts can still start background processes.
+//
+// A command still running at twice its timeout, or that wrote no output for
+// the stall timeout, is hung: its process tree and the agent goroutines are
+// dumped to the hung command directory, it is killed and a HungCommand
+// error returned.
+func RunWithProcessGroup(ctx context.Context, cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	wd := newWatchdog(ctx, cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	g, err := newProcessGroup(cmd)
+	if err != nil {
+		clog.Warningf(ctx, "Error creating process group for %q, only the process itself is killed on cancel: %v", cmd.Path, err)
+	}
+	kill := func() {
+		if g == nil {
+			cmd.Process.Kill()
+			return
+		}
+		clog.Debugf(ctx, "Killing process group of %q.", cmd.Path)
+		if err := g.kill(); err != nil {
+			clog.Errorf(ctx, "Error killing process group of %q: %v", cmd.Path, err)
+		}
+	}
+
+	done := make(chan struct{})
+	killed := make(chan struct{})
+	watched := make(chan struct{})
+	go func() {
+		defer close(killed)
+		select {
+		case <-ctx.Done():
+			kill()
+		case <-done:
+		}
+	}()
+	go func() {
+		defer close(watched)
+		wd.run(ctx, done, kill)
+	}()
+	err = cmd.Wait()
+	close(done)
+	<-killed
+	<-watched
+	if g != nil {
+		g.close()
+	}
+	if hungErr := wd.err(err); hungErr != nil {
+		return hungErr
+	}
+	return err
+}
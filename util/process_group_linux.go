@@ -0,0 +1,121 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// processGroup is the process group led by a started command.
+type processGroup struct {
+	pgid int
+}
+
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// A session leader already leads its own process group and can not
+	// call setpgid.
+	if !cmd.SysProcAttr.Setsid {
+		cmd.SysProcAttr.Setpgid = true
+	}
+}
+
+func newProcessGroup(cmd *exec.Cmd) (*processGroup, error) {
+	return &processGroup{pgid: cmd.Process.Pid}, nil
+}
+
+func (g *processGroup) kill() error {
+	// A negative pid signals the whole process group.
+	if err := syscall.Kill(-g.pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	return nil
+}
+
+func (g *processGroup) close() {}
+
+// procStat is a process from /proc/<pid>/stat.
+type procStat struct {
+	pid, ppid, pgrp int
+	state, comm     string
+}
+
+// processTree lists pid, its descendants and any process left in its process
+// group, one per line with its parent, state and command line.
+func processTree(pid int) (string, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return "", err
+	}
+	procs := map[int]procStat{}
+	for _, e := range entries {
+		p, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join("/proc", e.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// The command name is in parentheses and may contain spaces.
+		s := string(b)
+		open, end := strings.Index(s, "("), strings.LastIndex(s, ")")
+		if open < 0 || end < open {
+			continue
+		}
+		fields := strings.Fields(s[end+1:])
+		if len(fields) < 3 {
+			continue
+		}
+		ppid, _ := strconv.Atoi(fields[1])
+		pgrp, _ := strconv.Atoi(fields[2])
+		procs[p] = procStat{pid: p, ppid: ppid, pgrp: pgrp, state: fields[0], comm: s[open+1 : end]}
+	}
+
+	var tree []int
+	for p, st := range procs {
+		inTree := st.pgrp == pid
+		for q := p; !inTree && q > 1; q = procs[q].ppid {
+			inTree = q == pid
+		}
+		if inTree {
+			tree = append(tree, p)
+		}
+	}
+	sort.Ints(tree)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%-8s %-8s %-5s %s\n", "PID", "PPID", "STATE", "COMMAND")
+	for _, p := range tree {
+		st := procs[p]
+		cmdline, _ := os.ReadFile(filepath.Join("/proc", strconv.Itoa(p), "cmdline"))
+		command := strings.TrimSpace(string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '})))
+		if command == "" {
+			command = "[" + st.comm + "]"
+		}
+		fmt.Fprintf(&buf, "%-8d %-8d %-5s %s\n", p, st.ppid, st.state, command)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,126 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+)
+
+// alive reports whether pid is running, zombies count as dead.
+func alive(pid int) bool {
+	b, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(b[strings.LastIndex(string(b), ")")+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestRunWithProcessGroupKillsGrandchildren(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cmd := exec.Command("/bin/sh", "-c", `sleep 60 & echo $! > "$0"; wait`, pidFile)
+	errc := make(chan error, 1)
+	go func() { errc <- RunWithProcessGroup(ctx, cmd) }()
+
+	var pid int
+	for i := 0; i < 100 && pid == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+		b, _ := os.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+	}
+	if pid == 0 {
+		t.Fatal("grandchild did not start")
+	}
+	cancel()
+
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("RunWithProcessGroup: want an error for a killed command")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("RunWithProcessGroup did not return after cancel")
+	}
+	for i := 0; i < 100 && alive(pid); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if alive(pid) {
+		t.Errorf("grandchild %d is still running after cancel", pid)
+	}
+}
+
+func TestRunWithProcessGroupKeepsBackgroundProcesses(t *testing.T) {
+	var out bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", `sleep 60 > /dev/null 2>&1 & echo $!`)
+	cmd.Stdout = &out
+	if err := RunWithProcessGroup(context.Background(), cmd); err != nil {
+		t.Fatalf("RunWithProcessGroup: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(out.String()))
+	if err != nil {
+		t.Fatalf("unexpected output %q: %v", out.String(), err)
+	}
+	defer syscall.Kill(pid, syscall.SIGKILL)
+
+	if !alive(pid) {
+		t.Errorf("background process %d was killed after a normal exit", pid)
+	}
+}
+
+func TestRunWithProcessGroupKillsHungCommand(t *testing.T) {
+	dir := t.TempDir()
+	defer func(f func() string) { HungCommandDir = f }(HungCommandDir)
+	HungCommandDir = func() string { return dir }
+	defer func(f func() time.Duration) { CommandStallTimeout = f }(CommandStallTimeout)
+	CommandStallTimeout = func() time.Duration { return 200 * time.Millisecond }
+	defer func(d time.Duration) { watchdogInterval = d }(watchdogInterval)
+	watchdogInterval = 50 * time.Millisecond
+
+	var out bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", `echo started; sleep 60`)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := RunWithProcessGroup(context.Background(), cmd)
+	if got := errcode.Of(err, ""); got != errcode.HungCommand {
+		t.Fatalf("RunWithProcessGroup: got error %v with code %q, want %q", err, got, errcode.HungCommand)
+	}
+
+	dumps, _ := filepath.Glob(filepath.Join(dir, "hung_command_*.txt"))
+	if len(dumps) != 1 {
+		t.Fatalf("got diagnostics %q, want one file", dumps)
+	}
+	b, err := os.ReadFile(dumps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Reason: no output", "sleep 60", "goroutine"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("diagnostics do not contain %q:\n%s", want, b)
+		}
+	}
+}
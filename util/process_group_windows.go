@@ -0,0 +1,99 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// processGroup is a Job Object holding a started command, child processes
+// are added to the job of their parent.
+type processGroup struct {
+	job windows.Handle
+}
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func newProcessGroup(cmd *exec.Cmd) (*processGroup, error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	p, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		windows.CloseHandle(job)
+		return nil, err
+	}
+	defer windows.CloseHandle(p)
+	if err := windows.AssignProcessToJobObject(job, p); err != nil {
+		windows.CloseHandle(job)
+		return nil, err
+	}
+	return &processGroup{job: job}, nil
+}
+
+func (g *processGroup) kill() error {
+	return windows.TerminateJobObject(g.job, 1)
+}
+
+// close releases the job handle, without JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+// this leaves any remaining processes running.
+func (g *processGroup) close() {
+	windows.CloseHandle(g.job)
+}
+
+// processTree lists pid and its descendants, one per line with its parent
+// and executable.
+func processTree(pid int) (string, error) {
+	snap, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CloseHandle(snap)
+
+	procs := map[uint32]windows.ProcessEntry32{}
+	e := windows.ProcessEntry32{Size: uint32(unsafe.Sizeof(windows.ProcessEntry32{}))}
+	for err = windows.Process32First(snap, &e); err == nil; err = windows.Process32Next(snap, &e) {
+		procs[e.ProcessID] = e
+	}
+
+	var tree []int
+	for p := range procs {
+		// Process IDs are reused, stop at a parent that is its own ancestor.
+		seen := map[uint32]bool{}
+		for q := p; q != 0 && !seen[q]; q = procs[q].ParentProcessID {
+			if q == uint32(pid) {
+				tree = append(tree, int(p))
+				break
+			}
+			seen[q] = true
+		}
+	}
+	sort.Ints(tree)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%-8s %-8s %s\n", "PID", "PPID", "EXECUTABLE")
+	for _, p := range tree {
+		e := procs[uint32(p)]
+		fmt.Fprintf(&buf, "%-8d %-8d %s\n", p, e.ParentProcessID, windows.UTF16ToString(e.ExeFile[:]))
+	}
+	return buf.String(), nil
+}
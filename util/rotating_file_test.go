@@ -0,0 +1,77 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "agent.log")
+	r := NewRotatingFile(path, 10, 2)
+	defer r.Close()
+
+	for _, s := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n", "this line is longer than max\n"} {
+		if _, err := r.Write([]byte(s)); err != nil {
+			t.Fatalf("Write(%q): %v", s, err)
+		}
+	}
+
+	want := map[string]string{
+		path:        "this line is longer than max\n",
+		path + ".1": "dddddd\n",
+		path + ".2": "cccccc\n",
+	}
+	for p, w := range want {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Errorf("reading %s: %v", p, err)
+			continue
+		}
+		if string(b) != w {
+			t.Errorf("%s: got %q, want %q", filepath.Base(p), b, w)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists, want only 2 backups kept", filepath.Base(path))
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	if err := os.WriteFile(path, []byte("existing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRotatingFile(path, 16, 0)
+	defer r.Close()
+	for _, tt := range []struct{ write, want string }{
+		{"new\n", "existing\nnew\n"},
+		// With no backups the full file is removed on rotation.
+		{"rotated\n", "rotated\n"},
+	} {
+		if _, err := r.Write([]byte(tt.write)); err != nil {
+			t.Fatalf("Write(%q): %v", tt.write, err)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("after Write(%q): got %q, want %q", tt.write, b, tt.want)
+		}
+	}
+}
@@ -105,7 +105,7 @@ func AtomicWriteFileStream(r io.Reader, checksum, path string, mode os.FileMode)
 	}()
 
 	hasher := sha256.New()
-	if _, err = io.Copy(io.MultiWriter(tmp, hasher), r); err != nil {
+	if _, err = Copy(io.MultiWriter(tmp, hasher), r); err != nil {
 		return "", err
 	}
 
@@ -127,16 +127,24 @@ type CommandRunner interface {
 	Run(ctx context.Context, command *exec.Cmd) ([]byte, []byte, error)
 }
 
+// StreamingCommandRunner is a CommandRunner that can also write stdout of a
+// command as it is produced rather than buffering it.
+type StreamingCommandRunner interface {
+	CommandRunner
+	RunStreaming(ctx context.Context, command *exec.Cmd, stdout io.Writer) ([]byte, error)
+}
+
 // DefaultRunner is a default CommandRunner.
 type DefaultRunner struct{}
 
-// Run takes precreated exec.Cmd and returns the stdout and stderr.
+// Run takes precreated exec.Cmd and returns the stdout and stderr. The
+// command and any processes it starts are killed when ctx is done.
 func (r *DefaultRunner) Run(ctx context.Context, cmd *exec.Cmd) ([]byte, []byte, error) {
 	clog.Debugf(ctx, "Running %q with args %q\n", cmd.Path, cmd.Args[1:])
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	err := cmd.Run()
+	err := RunWithProcessGroup(ctx, cmd)
 	clog.DebugStructured(
 		ctx,
 		struct {
@@ -156,6 +164,37 @@ func (r *DefaultRunner) Run(ctx context.Context, cmd *exec.Cmd) ([]byte, []byte,
 	return stdout.Bytes(), stderr.Bytes(), err
 }
 
+// RunStreaming runs cmd writing its stdout to w and returns the stderr. Unlike
+// Run the stdout is not logged, it may be large.
+func (r *DefaultRunner) RunStreaming(ctx context.Context, cmd *exec.Cmd, w io.Writer) ([]byte, error) {
+	clog.Debugf(ctx, "Running %q with args %q\n", cmd.Path, cmd.Args[1:])
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	err := RunWithProcessGroup(ctx, cmd)
+	clog.Debugf(ctx, "%s %q exit code: %d, stderr:\n%s", cmd.Path, cmd.Args[1:], cmd.ProcessState.ExitCode(), stderr.String())
+	return stderr.Bytes(), err
+}
+
+// SetCLocale makes cmd run in the C locale, replacing the locale variables
+// of its environment, or of the agent if it has none, so output that is
+// parsed is not translated.
+func SetCLocale(cmd *exec.Cmd) {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	var out []string
+	for _, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		if name == "LANG" || name == "LANGUAGE" || strings.HasPrefix(name, "LC_") {
+			continue
+		}
+		out = append(out, e)
+	}
+	cmd.Env = append(out, "LANG=C", "LC_ALL=C")
+}
+
 // TempFile is a little bit like ioutil.TempFile but takes FileMode in
 // order to work nicely on Windows where File.Chmod is not supported.
 func TempFile(dir string, pattern string, mode os.FileMode) (f *os.File, err error) {
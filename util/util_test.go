@@ -1,6 +1,8 @@
 package util
 
 import (
+	"os/exec"
+	"reflect"
 	"testing"
 )
 
@@ -44,3 +46,22 @@ func TestSanitizePath(t *testing.T) {
 
 	}
 }
+
+func TestSetCLocale(t *testing.T) {
+	cmd := exec.Command("apt-get", "upgrade")
+	cmd.Env = []string{"PATH=/usr/bin", "LANG=de_DE.UTF-8", "LANGUAGE=de:en", "LC_MESSAGES=fr_FR.UTF-8", "LC_ALL=ja_JP.UTF-8", "DEBIAN_FRONTEND=noninteractive"}
+	SetCLocale(cmd)
+	want := []string{"PATH=/usr/bin", "DEBIAN_FRONTEND=noninteractive", "LANG=C", "LC_ALL=C"}
+	if !reflect.DeepEqual(cmd.Env, want) {
+		t.Errorf("SetCLocale: got env %q, want %q", cmd.Env, want)
+	}
+
+	t.Setenv("LC_TIME", "de_DE.UTF-8")
+	cmd = exec.Command("rpmquery")
+	SetCLocale(cmd)
+	for _, e := range cmd.Env {
+		if e == "LC_TIME=de_DE.UTF-8" {
+			t.Errorf("SetCLocale: want the agent locale replaced, got env %q", cmd.Env)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/errcode"
+)
+
+var (
+	// CommandStallTimeout is how long a command may write no output before
+	// it is considered hung, 0 to never consider silent commands hung. The
+	// agent sets it from its configuration.
+	CommandStallTimeout = func() time.Duration { return 0 }
+	// HungCommandDir is where diagnostics of hung commands are written
+	// before they are killed. The agent sets it from its configuration.
+	HungCommandDir = func() string { return filepath.Join(os.TempDir(), "osconfig_hung_commands") }
+
+	// watchdogInterval is how often running commands are checked.
+	watchdogInterval = 30 * time.Second
+)
+
+type noStallCheckKey struct{}
+
+// WithoutStallCheck returns a copy of ctx commands are not checked for
+// stalled output with, only against their deadline. It is used for package
+// manager transactions, which can be silent for long and must not be killed
+// half way.
+func WithoutStallCheck(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noStallCheckKey{}, true)
+}
+
+// activityWriter records when output was last written.
+type activityWriter struct {
+	w    io.Writer
+	last *atomic.Int64
+}
+
+func (a *activityWriter) Write(p []byte) (int, error) {
+	a.last.Store(time.Now().UnixNano())
+	return a.w.Write(p)
+}
+
+// watchdog detects a hung command, one still running at twice its timeout,
+// the deadline of its context, or that wrote no output for the stall
+// timeout.
+type watchdog struct {
+	cmd      *exec.Cmd
+	started  time.Time
+	deadline time.Time
+	stall    time.Duration
+	// lastOutput is in Unix nanoseconds, it is only tracked for output that
+	// is not written directly to a file.
+	lastOutput *atomic.Int64
+	// hung is why the command was killed as hung, set before it is killed.
+	hung atomic.Value
+}
+
+// newWatchdog sets up a watchdog for cmd, it must be called before cmd is
+// started as its output is wrapped to track activity.
+func newWatchdog(ctx context.Context, cmd *exec.Cmd) *watchdog {
+	w := &watchdog{cmd: cmd, started: time.Now(), stall: CommandStallTimeout()}
+	if ctx.Value(noStallCheckKey{}) != nil {
+		w.stall = 0
+	}
+	if d, ok := ctx.Deadline(); ok {
+		w.deadline = w.started.Add(2 * d.Sub(w.started))
+	}
+
+	// Commands writing straight to a file, or discarding their output, are
+	// only checked against their deadline.
+	_, stdoutFile := cmd.Stdout.(*os.File)
+	_, stderrFile := cmd.Stderr.(*os.File)
+	if w.stall <= 0 || cmd.Stdout == nil || stdoutFile || stderrFile {
+		w.stall = 0
+		return w
+	}
+	w.lastOutput = &atomic.Int64{}
+	w.lastOutput.Store(w.started.UnixNano())
+	// Stdout and stderr sharing a writer must keep sharing it, exec only
+	// serializes writes when they are the same.
+	same := cmd.Stdout == cmd.Stderr
+	cmd.Stdout = &activityWriter{w: cmd.Stdout, last: w.lastOutput}
+	if same {
+		cmd.Stderr = cmd.Stdout
+	} else if cmd.Stderr != nil {
+		cmd.Stderr = &activityWriter{w: cmd.Stderr, last: w.lastOutput}
+	}
+	return w
+}
+
+// check returns why the command is hung at now, or "" if it is not.
+func (w *watchdog) check(now time.Time) string {
+	if !w.deadline.IsZero() && now.After(w.deadline) {
+		return fmt.Sprintf("still running %s after it started, twice its timeout", now.Sub(w.started).Round(time.Second))
+	}
+	if w.stall > 0 {
+		if silent := now.Sub(time.Unix(0, w.lastOutput.Load())); silent >= w.stall {
+			return fmt.Sprintf("no output for %s", silent.Round(time.Second))
+		}
+	}
+	return ""
+}
+
+// run checks the command until done is closed, a hung command is recorded
+// with dumpHungCommand and killed with kill.
+func (w *watchdog) run(ctx context.Context, done <-chan struct{}, kill func()) {
+	if w.deadline.IsZero() && w.stall <= 0 {
+		return
+	}
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			reason := w.check(now)
+			if reason == "" {
+				continue
+			}
+			w.hung.Store(reason)
+			path, err := w.dump(reason)
+			if err != nil {
+				clog.Errorf(ctx, "Command %q is hung, %s, error writing diagnostics: %v", w.cmd.Path, reason, err)
+			} else {
+				clog.Errorf(ctx, "Command %q is hung, %s, diagnostics written to %q, killing it.", w.cmd.Path, reason, path)
+			}
+			kill()
+			return
+		}
+	}
+}
+
+// err returns the error of a command killed as hung, nil if it wasn't.
+func (w *watchdog) err(cmdErr error) error {
+	reason, _ := w.hung.Load().(string)
+	if reason == "" {
+		return nil
+	}
+	return errcode.Wrap(errcode.HungCommand, fmt.Errorf("command %q hung, %s, and was killed: %v", w.cmd.Path, reason, cmdErr))
+}
+
+// dump writes the process tree of the command and a dump of the agent
+// goroutines to a new file in HungCommandDir, and returns its path.
+func (w *watchdog) dump(reason string) (string, error) {
+	dir := HungCommandDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	pid := w.cmd.Process.Pid
+	path := filepath.Join(dir, fmt.Sprintf("hung_command_%s_%d.txt", time.Now().UTC().Format("20060102T150405Z"), pid))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(f, "Command: %q\nArgs: %q\nPID: %d\nStarted: %s\nReason: %s\n", w.cmd.Path, w.cmd.Args[1:], pid, w.started.UTC().Format(time.RFC3339), reason)
+	if w.lastOutput != nil {
+		fmt.Fprintf(f, "Last output: %s\n", time.Unix(0, w.lastOutput.Load()).UTC().Format(time.RFC3339))
+	}
+	fmt.Fprint(f, "\nProcess tree:\n")
+	tree, err := processTree(pid)
+	if err != nil {
+		fmt.Fprintf(f, "error listing processes: %v\n", err)
+	}
+	fmt.Fprint(f, tree)
+	fmt.Fprint(f, "\nAgent goroutines:\n")
+	if err := pprof.Lookup("goroutine").WriteTo(f, 2); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,88 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchdogCheck(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	output := func(d time.Duration) *atomic.Int64 {
+		var last atomic.Int64
+		last.Store(start.Add(d).UnixNano())
+		return &last
+	}
+	tests := []struct {
+		desc     string
+		deadline time.Time
+		stall    time.Duration
+		last     *atomic.Int64
+		at       time.Duration
+		wantHung bool
+	}{
+		{"no limits", time.Time{}, 0, nil, 24 * time.Hour, false},
+		{"before twice timeout", start.Add(20 * time.Minute), 0, nil, 19 * time.Minute, false},
+		{"past twice timeout", start.Add(20 * time.Minute), 0, nil, 21 * time.Minute, true},
+		{"recent output", time.Time{}, 10 * time.Minute, output(5 * time.Minute), 14 * time.Minute, false},
+		{"stalled output", time.Time{}, 10 * time.Minute, output(5 * time.Minute), 15 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		w := &watchdog{cmd: exec.Command("true"), started: start, deadline: tt.deadline, stall: tt.stall, lastOutput: tt.last}
+		if got := w.check(start.Add(tt.at)); (got != "") != tt.wantHung {
+			t.Errorf("%s: check() = %q, want hung %t", tt.desc, got, tt.wantHung)
+		}
+	}
+}
+
+func TestNewWatchdog(t *testing.T) {
+	defer func(f func() time.Duration) { CommandStallTimeout = f }(CommandStallTimeout)
+	CommandStallTimeout = func() time.Duration { return time.Minute }
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	// A shared stdout and stderr writer stays shared.
+	var out bytes.Buffer
+	cmd := exec.Command("true")
+	cmd.Stdout, cmd.Stderr = &out, &out
+	w := newWatchdog(ctx, cmd)
+	if cmd.Stdout != cmd.Stderr {
+		t.Error("stdout and stderr no longer share a writer")
+	}
+	if w.stall != time.Minute {
+		t.Errorf("stall = %s, want %s", w.stall, time.Minute)
+	}
+	if got, want := w.deadline.Sub(w.started).Round(time.Minute), 2*time.Hour; got != want {
+		t.Errorf("deadline is %s after start, want %s", got, want)
+	}
+
+	// Package manager commands are not checked for stalls.
+	cmd = exec.Command("true")
+	cmd.Stdout = &out
+	if w := newWatchdog(WithoutStallCheck(ctx), cmd); w.stall != 0 || w.deadline.IsZero() {
+		t.Errorf("watchdog without stall check: stall %s, deadline %s, want only a deadline", w.stall, w.deadline)
+	}
+
+	// Discarded output is not checked for stalls.
+	if w := newWatchdog(context.Background(), exec.Command("true")); w.stall != 0 || !w.deadline.IsZero() {
+		t.Errorf("watchdog without output or deadline: stall %s, deadline %s, want neither", w.stall, w.deadline)
+	}
+}